@@ -15,11 +15,15 @@
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/netip"
+	"net/textproto"
 	"net/url"
 	"os/exec"
 	"runtime"
@@ -35,6 +39,7 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/udprelay/endpoint"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
@@ -755,6 +760,54 @@ func (lc *Client) GetWaitingFile(ctx context.Context, baseName string) (rc io.Re
 	return res.Body, res.ContentLength, nil
 }
 
+// GetWaitingDir returns the contents of the received Taildrop directory
+// baseName as a tar archive. See [apitype.WaitingFile.Dir].
+func (lc *Client) GetWaitingDir(ctx context.Context, baseName string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/files/"+url.PathEscape(baseName)+"?format=tar", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Body, nil
+}
+
+// SSHRecordings returns the Tailscale SSH session recordings stored on the
+// local disk, newest first.
+func (lc *Client) SSHRecordings(ctx context.Context) ([]apitype.SSHRecording, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/ssh-recordings/")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.SSHRecording](body)
+}
+
+// GetSSHRecording returns the contents of the named Tailscale SSH session
+// recording in asciicast v2 format. The recording is decompressed if needed.
+func (lc *Client) GetSSHRecording(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/ssh-recordings/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("HTTP %s: %s", res.Status, body)
+	}
+	return res.Body, nil
+}
+
 func (lc *Client) FileTargets(ctx context.Context) ([]apitype.FileTarget, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/file-targets")
 	if err != nil {
@@ -787,6 +840,115 @@ func (lc *Client) PushFile(ctx context.Context, target tailcfg.StableNodeID, siz
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// QueueFile adds Taildrop file r to the outbox of tailscaled, to be sent to
+// target in the background, once it's online. It returns the queued file.
+//
+// A size of -1 means unknown.
+// The name parameter is the original filename, not escaped.
+func (lc *Client) QueueFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) (*apitype.QueuedFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-queue/"+string(target)+"/"+url.PathEscape(name), r)
+	if err != nil {
+		return nil, err
+	}
+	if size != -1 {
+		req.ContentLength = size
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	all, _ := io.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return nil, bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+	}
+	return decodeJSON[*apitype.QueuedFile](all)
+}
+
+// QueuedFiles returns the files in the Taildrop outbox of tailscaled, which
+// are waiting to be sent.
+func (lc *Client) QueuedFiles(ctx context.Context) ([]apitype.QueuedFile, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/file-queue/")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.QueuedFile](body)
+}
+
+// CancelQueuedFile removes the file with the given ID from the Taildrop
+// outbox, canceling its send.
+func (lc *Client) CancelQueuedFile(ctx context.Context, id string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/file-queue/"+url.PathEscape(id), http.StatusNoContent, nil)
+	return err
+}
+
+// PushDir sends the directory tree in fsys to target as a Taildrop directory
+// named name. The manifest must describe the contents of fsys; its regular
+// files are read from fsys in the order they're listed.
+func (lc *Client) PushDir(ctx context.Context, target tailcfg.StableNodeID, name string, manifest *apitype.TaildropManifest, fsys fs.FS) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeDirParts(mw, manifest, fsys))
+	}()
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(name), pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := lc.doLocalRequestNiceError(req)
+	pr.Close() // stop writing parts if the request ended early
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 200 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	all, _ := io.ReadAll(res.Body)
+	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+}
+
+// writeDirParts writes the multipart/form-data body of a PushDir request to
+// mw: the JSON manifest, followed by the contents of each regular file.
+func writeDirParts(mw *multipart.Writer, manifest *apitype.TaildropManifest, fsys fs.FS) error {
+	w, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="manifest"`},
+		"Content-Type":        {"application/json"},
+	})
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		return err
+	}
+	for _, e := range manifest.Files {
+		if !e.Mode.IsRegular() {
+			continue
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Disposition": {mime.FormatMediaType("form-data", map[string]string{"name": e.Path})},
+		})
+		if err != nil {
+			return err
+		}
+		f, err := fsys.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.LimitReader(f, e.Size+1))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if n != e.Size {
+			return fmt.Errorf("%s changed size while sending", e.Path)
+		}
+	}
+	return mw.Close()
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -1080,6 +1242,12 @@ func (lc *Client) CurrentDERPMap(ctx context.Context) (*tailcfg.DERPMap, error)
 	return &derpMap, nil
 }
 
+// NetcheckHistory returns tailscaled's recent netcheck reports, oldest first,
+// as a JSON array of netcheck.Report values.
+func (lc *Client) NetcheckHistory(ctx context.Context) ([]byte, error) {
+	return lc.get200(ctx, "/localapi/v0/netcheck-history")
+}
+
 // CertPair returns a cert and private key for the provided DNS domain.
 //
 // It returns a cached certificate from disk if it's still valid.
@@ -1597,6 +1765,16 @@ func (lc *Client) DebugDERPRegion(ctx context.Context, regionIDOrCode string) (*
 	return decodeJSON[*ipnstate.DebugDERPRegionReport](body)
 }
 
+// DebugRelayStatus returns the status of the UDP relay server and the
+// endpoints allocated on it.
+func (lc *Client) DebugRelayStatus(ctx context.Context) (*endpoint.ServerStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-relay-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*endpoint.ServerStatus](body)
+}
+
 // DebugPacketFilterRules returns the packet filter rules for the current device.
 func (lc *Client) DebugPacketFilterRules(ctx context.Context) ([]tailcfg.FilterRule, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-packet-filter-rules", 200, nil)
@@ -5,6 +5,9 @@
 package apitype
 
 import (
+	"io/fs"
+	"time"
+
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
 	"tailscale.com/util/ctxkey"
@@ -53,7 +56,67 @@ type FileTarget struct {
 
 type WaitingFile struct {
 	Name string
-	Size int64
+	Size int64 // total size of the files within, if Dir
+
+	// Dir is whether the entry is a directory received with a Taildrop
+	// directory transfer. Its contents can be fetched as a tar archive.
+	Dir bool `json:",omitempty"`
+}
+
+// QueuedFile is a file in the Taildrop outbox, waiting to be sent to a peer
+// once it's online.
+type QueuedFile struct {
+	ID      string
+	PeerID  tailcfg.StableNodeID
+	Name    string
+	Size    int64
+	Created time.Time
+
+	// Attempts is the number of failed attempts to send the file to the
+	// peer while it was online, and LastAttempt and LastError are the time
+	// and error of the latest one.
+	Attempts    int       `json:",omitempty"`
+	LastAttempt time.Time `json:",omitzero"`
+	LastError   string    `json:",omitempty"`
+}
+
+// TaildropManifest describes a directory sent with Taildrop. It is sent
+// before the contents of the directory's files, which are sent in the order
+// they're listed in Files.
+type TaildropManifest struct {
+	Files []TaildropManifestEntry
+}
+
+// TaildropManifestEntry is a file or subdirectory in a TaildropManifest.
+// Parent directories of files need not be listed, unless their mode or
+// modification time is to be preserved.
+type TaildropManifestEntry struct {
+	Path    string      // slash-separated path, relative to the directory
+	Size    int64       // zero for directories
+	Mode    fs.FileMode // permission bits, plus fs.ModeDir for directories
+	ModTime time.Time
+
+	// SHA256 is the hex-encoded SHA-256 hash of the file's contents.
+	// It is empty for directories.
+	SHA256 string `json:",omitempty"`
+}
+
+// SSHRecording is a Tailscale SSH session recording stored on the local disk
+// of the node running the SSH server, as returned by the LocalAPI
+// /ssh-recordings/ endpoint.
+type SSHRecording struct {
+	Name    string    // base name of the recording file
+	Size    int64     // size on disk; may be compressed
+	ModTime time.Time // time of the last write to the recording
+
+	// The following fields are from the recording's header, if it
+	// could be read.
+
+	SSHUser     string `json:",omitempty"` // as presented by the client
+	LocalUser   string `json:",omitempty"` // effective user on the server
+	SrcNode     string `json:",omitempty"` // FQDN of the connecting node
+	SrcNodeUser string `json:",omitempty"` // login name, if not tagged
+	Command     string `json:",omitempty"` // empty for shell sessions
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
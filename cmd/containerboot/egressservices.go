@@ -16,11 +16,14 @@
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
 	"tailscale.com/client/local"
 	"tailscale.com/ipn"
 	"tailscale.com/kube/egressservices"
@@ -35,6 +38,17 @@
 
 const tailscaleTunInterface = "tailscale0"
 
+const (
+	// drainCheckInterval is how often the proxy checks whether backends
+	// that are being drained still have established connections.
+	drainCheckInterval = 30 * time.Second
+	// maxDrainDuration is how long the proxy drains a backend for at most.
+	// Long-lived connections can keep conntrack entries around for days,
+	// and listing conntrack entries requires CAP_NET_ADMIN, which the proxy
+	// may not have.
+	maxDrainDuration = time.Hour
+)
+
 // Modified using a build flag to speed up tests.
 var testSleepDuration string
 
@@ -76,6 +90,15 @@ type egressProxy struct {
 	longSleep time.Duration
 	// client is a client that can send HTTP requests.
 	client httpClient
+
+	// listConntrack returns the number of conntrack entries per address,
+	// used to determine whether backends that are being drained still have
+	// established connections. Can be overridden in tests.
+	listConntrack func() (map[netip.Addr]int, error)
+	// draining is true if any backends were being drained after the last
+	// sync, in which case the proxy periodically resyncs to check whether
+	// the drain has completed.
+	draining bool
 }
 
 // httpClient is a client that can send HTTP requests and can be mocked in tests.
@@ -105,6 +128,8 @@ func (ep *egressProxy) run(ctx context.Context, n ipn.Notify, opts egressProxyRu
 		}
 		eventChan = w.Events
 	}
+	drainTicker := time.NewTicker(drainCheckInterval)
+	defer drainTicker.Stop()
 
 	if err := ep.sync(ctx, n); err != nil {
 		return err
@@ -115,6 +140,11 @@ func (ep *egressProxy) run(ctx context.Context, n ipn.Notify, opts egressProxyRu
 			return nil
 		case <-tickChan:
 			log.Printf("periodic sync, ensuring firewall config is up to date...")
+		case <-drainTicker.C:
+			if !ep.draining {
+				continue
+			}
+			log.Printf("checking whether egress service backends have been drained...")
 		case <-eventChan:
 			log.Printf("config file change detected, ensuring firewall config is up to date...")
 		case n = <-ep.netmapChan:
@@ -152,6 +182,7 @@ func (ep *egressProxy) configure(opts egressProxyRunOpts) {
 	ep.podIPv4 = opts.podIPv4
 	ep.tailnetAddrs = opts.tailnetAddrs
 	ep.client = &http.Client{} // default HTTP client
+	ep.listConntrack = conntrackEntries
 	sleepDuration := time.Second
 	if d, err := time.ParseDuration(testSleepDuration); err == nil && d > 0 {
 		log.Printf("using test sleep duration %v", d)
@@ -174,7 +205,7 @@ func (ep *egressProxy) sync(ctx context.Context, n ipn.Notify) error {
 	if err != nil {
 		return fmt.Errorf("error retrieving current egress proxy status: %w", err)
 	}
-	newStatus, err := ep.syncEgressConfigs(cfgs, status, n)
+	newStatus, err := ep.syncEgressConfigs(cfgs, status, n, time.Now())
 	if err != nil {
 		return fmt.Errorf("error syncing egress service configs: %w", err)
 	}
@@ -195,7 +226,8 @@ func (ep *egressProxy) addrsHaveChanged(n ipn.Notify) bool {
 // syncEgressConfigs adds and deletes firewall rules to match the desired
 // configuration. It uses the provided status to determine what is currently
 // applied and updates the status after a successful sync.
-func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *egressservices.Status, n ipn.Notify) (*egressservices.Status, error) {
+func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *egressservices.Status, n ipn.Notify, now time.Time) (*egressservices.Status, error) {
+	ep.draining = false
 	if !(wantsServicesConfigured(cfgs) || hasServicesConfigured(status)) {
 		return nil, nil
 	}
@@ -214,6 +246,14 @@ func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *e
 	rulesPerSvcToAdd := make(map[string][]rule, 0)
 	rulesPerSvcToDelete := make(map[string][]rule, 0)
 	for svcName, cfg := range *cfgs {
+		if len(cfg.Backends) != 0 {
+			svcStatus, err := ep.syncWeightedBackends(svcName, cfg, status, n, now)
+			if err != nil {
+				return nil, fmt.Errorf("error syncing weighted backends for %s: %w", svcName, err)
+			}
+			mak.Set(&newStatus.Services, svcName, svcStatus)
+			continue
+		}
 		tailnetTargetIPs, err := ep.tailnetTargetIPsForSvc(cfg, n)
 		if err != nil {
 			return nil, fmt.Errorf("error determining tailnet target IPs: %w", err)
@@ -230,26 +270,8 @@ func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *e
 			mak.Set(&rulesPerSvcToDelete, svcName, rulesToDelete)
 		}
 		if len(rulesToAdd) != 0 || ep.addrsHaveChanged(n) {
-			// For each tailnet target, set up SNAT from the local tailnet device address of the matching
-			// family.
-			for _, t := range tailnetTargetIPs {
-				var local netip.Addr
-				for _, pfx := range n.NetMap.SelfNode.Addresses().All() {
-					if !pfx.IsSingleIP() {
-						continue
-					}
-					if pfx.Addr().Is4() != t.Is4() {
-						continue
-					}
-					local = pfx.Addr()
-					break
-				}
-				if !local.IsValid() {
-					return nil, fmt.Errorf("no valid local IP: %v", local)
-				}
-				if err := ep.nfr.EnsureSNATForDst(local, t); err != nil {
-					return nil, fmt.Errorf("error setting up SNAT rule: %w", err)
-				}
+			if err := ep.ensureSNATForTargets(tailnetTargetIPs, n); err != nil {
+				return nil, err
 			}
 		}
 		// Update the status. Status will be written back to the state Secret by the caller.
@@ -267,6 +289,238 @@ func (ep *egressProxy) syncEgressConfigs(cfgs *egressservices.Configs, status *e
 	return newStatus, nil
 }
 
+// ensureSNATForTargets sets up SNAT from the local tailnet device address of
+// the matching family for each of the given tailnet targets.
+func (ep *egressProxy) ensureSNATForTargets(targets []netip.Addr, n ipn.Notify) error {
+	for _, t := range targets {
+		var local netip.Addr
+		for _, pfx := range n.NetMap.SelfNode.Addresses().All() {
+			if !pfx.IsSingleIP() {
+				continue
+			}
+			if pfx.Addr().Is4() != t.Is4() {
+				continue
+			}
+			local = pfx.Addr()
+			break
+		}
+		if !local.IsValid() {
+			return fmt.Errorf("no valid local IP: %v", local)
+		}
+		if err := ep.nfr.EnsureSNATForDst(local, t); err != nil {
+			return fmt.Errorf("error setting up SNAT rule: %w", err)
+		}
+	}
+	return nil
+}
+
+// syncWeightedBackends ensures that new connections for an egress service with
+// weighted backends are load balanced across the tailnet IPs of the backends
+// and returns the new status of the service.
+//
+// Backends that have been removed are given a weight of 0, so they don't
+// receive any new connections. Netfilter only evaluates NAT rules for the first
+// packet of a connection, so connections that are already established to a
+// removed backend keep their DNAT mapping in conntrack until they are closed or
+// expire. If the service has drain enabled, removed backends are recorded as
+// draining in the status until that has happened, so that they can be safely
+// decommissioned.
+func (ep *egressProxy) syncWeightedBackends(svcName string, cfg egressservices.Config, status *egressservices.Status, n ipn.Notify, now time.Time) (*egressservices.ServiceStatus, error) {
+	wpm, ok := ep.nfr.(linuxfw.WeightedPortMapper)
+	if !ok {
+		return nil, errors.New("weighted backends are only supported in nftables firewall mode")
+	}
+	var (
+		targets []linuxfw.WeightedTarget
+		ips     []netip.Addr
+	)
+	for _, b := range cfg.Backends {
+		bIPs, err := ep.tailnetTargetIPsForSvc(egressservices.Config{TailnetTarget: b.TailnetTarget}, n)
+		if err != nil {
+			return nil, fmt.Errorf("error determining tailnet target IPs: %w", err)
+		}
+		for _, ip := range bIPs {
+			if slices.Contains(ips, ip) {
+				continue
+			}
+			ips = append(ips, ip)
+			targets = append(targets, linuxfw.WeightedTarget{IP: ip, Weight: max(b.Weight, 1)})
+		}
+	}
+
+	svcStatus := &egressservices.ServiceStatus{
+		TailnetTargetIPs: ips,
+		Ports:            cfg.Ports,
+		Backends:         cfg.Backends,
+	}
+	// Removed backends get weight 0, which removes their rules.
+	cur, _ := lookupCurrentConfig(svcName, status)
+	var removed []netip.Addr
+	if cur != nil {
+		for _, ip := range cur.TailnetTargetIPs {
+			if !slices.Contains(ips, ip) {
+				removed = append(removed, ip)
+				targets = append(targets, linuxfw.WeightedTarget{IP: ip})
+			}
+		}
+		svcStatus.Draining = cur.Draining
+	}
+	if cfg.Drain {
+		svcStatus.Draining = ep.drainingTargets(svcName, svcStatus.Draining, removed, ips, now)
+	} else {
+		svcStatus.Draining = nil
+	}
+	ep.draining = ep.draining || len(svcStatus.Draining) > 0
+
+	for pm := range cfg.Ports {
+		if err := wpm.EnsureWeightedPortMapRulesForSvc(svcName, tailscaleTunInterface, targets, toLinuxfwPortMap(pm)); err != nil {
+			return nil, fmt.Errorf("error ensuring weighted rules: %w", err)
+		}
+	}
+	if cur != nil {
+		for pm := range cur.Ports {
+			if _, ok := cfg.Ports[pm]; ok {
+				continue
+			}
+			if err := wpm.DeleteWeightedPortMapRulesForSvc(svcName, tailscaleTunInterface, toLinuxfwPortMap(pm)); err != nil {
+				return nil, fmt.Errorf("error deleting weighted rules: %w", err)
+			}
+		}
+	}
+	if cur == nil || !slices.Equal(cur.TailnetTargetIPs, ips) || ep.addrsHaveChanged(n) {
+		if err := ep.ensureSNATForTargets(ips, n); err != nil {
+			return nil, err
+		}
+	}
+	return svcStatus, nil
+}
+
+// drainingTargets returns the tailnet IPs of removed backends that are still
+// being drained. A backend stops being drained once there are no more
+// connections to it in conntrack, it is added back, or it has been drained for
+// maxDrainDuration.
+func (ep *egressProxy) drainingTargets(svcName string, draining []egressservices.DrainingTarget, removed, active []netip.Addr, now time.Time) []egressservices.DrainingTarget {
+	for _, ip := range removed {
+		if !slices.ContainsFunc(draining, func(d egressservices.DrainingTarget) bool { return d.IP == ip }) {
+			log.Printf("egress service %s: draining removed backend %v", svcName, ip)
+			draining = append(draining, egressservices.DrainingTarget{IP: ip, Since: now})
+		}
+	}
+	if len(draining) == 0 {
+		return nil
+	}
+	conns, err := ep.listConntrack()
+	if err != nil {
+		log.Printf("egress service %s: unable to list conntrack entries, backends will be drained for %v: %v", svcName, maxDrainDuration, err)
+	}
+	var ret []egressservices.DrainingTarget
+	for _, d := range draining {
+		switch {
+		case slices.Contains(active, d.IP):
+			log.Printf("egress service %s: backend %v was added back, no longer draining", svcName, d.IP)
+		case now.Sub(d.Since) >= maxDrainDuration:
+			log.Printf("egress service %s: backend %v has been draining for %v, no longer draining", svcName, d.IP, maxDrainDuration)
+		case err == nil && conns[d.IP] == 0:
+			log.Printf("egress service %s: backend %v has been drained", svcName, d.IP)
+		default:
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// Conntrack netlink message and attribute types from
+// include/uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	ipctnlMsgCtGet = 1
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaTupleIP    = 1
+	ctaIPv4Src    = 1
+	ctaIPv4Dst    = 2
+	ctaIPv6Src    = 3
+	ctaIPv6Dst    = 4
+)
+
+// conntrackEntries returns the number of conntrack entries in the proxy's
+// network namespace per address that appears in them. It lists the entries
+// over ctnetlink, which unlike /proc/net/nf_conntrack is available on all
+// kernels that have conntrack.
+func conntrackEntries() (map[netip.Addr]int, error) {
+	c, err := netlink.Dial(unix.NETLINK_NETFILTER, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing netfilter netlink: %w", err)
+	}
+	defer c.Close()
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_CTNETLINK<<8 | ipctnlMsgCtGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		// struct nfgenmsg: all address families, version 0, resource ID 0.
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, 0},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error dumping conntrack entries: %w", err)
+	}
+	return countConntrackAddrs(msgs)
+}
+
+// countConntrackAddrs returns the number of conntrack entries in msgs, as
+// returned by a ctnetlink dump, per address that appears in their original
+// or reply tuple.
+func countConntrackAddrs(msgs []netlink.Message) (map[netip.Addr]int, error) {
+	const nfgenmsgLen = 4
+	ret := make(map[netip.Addr]int)
+	for _, m := range msgs {
+		if len(m.Data) < nfgenmsgLen {
+			return nil, fmt.Errorf("short conntrack message of %d bytes", len(m.Data))
+		}
+		ad, err := netlink.NewAttributeDecoder(m.Data[nfgenmsgLen:])
+		if err != nil {
+			return nil, err
+		}
+		var seen []netip.Addr
+		for ad.Next() {
+			if ad.Type() != ctaTupleOrig && ad.Type() != ctaTupleReply {
+				continue
+			}
+			ad.Nested(func(tad *netlink.AttributeDecoder) error {
+				for tad.Next() {
+					if tad.Type() != ctaTupleIP {
+						continue
+					}
+					tad.Nested(func(iad *netlink.AttributeDecoder) error {
+						for iad.Next() {
+							switch iad.Type() {
+							case ctaIPv4Src, ctaIPv4Dst, ctaIPv6Src, ctaIPv6Dst:
+								ip, ok := netip.AddrFromSlice(iad.Bytes())
+								if ok && !slices.Contains(seen, ip) {
+									seen = append(seen, ip)
+								}
+							}
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+		if err := ad.Err(); err != nil {
+			return nil, fmt.Errorf("error decoding conntrack entry: %w", err)
+		}
+		for _, ip := range seen {
+			ret[ip]++
+		}
+	}
+	return ret, nil
+}
+
+func toLinuxfwPortMap(pm egressservices.PortMap) linuxfw.PortMap {
+	return linuxfw.PortMap{MatchPort: pm.MatchPort, TargetPort: pm.TargetPort, Protocol: pm.Protocol}
+}
+
 // updatesForCfg calculates any rules that need to be added or deleted for an individucal egress service config.
 func updatesForCfg(svcName string, cfg egressservices.Config, status *egressservices.Status, tailnetTargetIPs []netip.Addr) ([]rule, []rule, error) {
 	rulesToAdd := make([]rule, 0)
@@ -368,12 +622,23 @@ func (ep *egressProxy) deleteUnnecessaryServices(cfgs *egressservices.Configs, s
 	}
 
 	for svcName, svc := range status.Services {
-		if _, ok := (*cfgs)[svcName]; !ok {
+		cfg, ok := (*cfgs)[svcName]
+		if !ok {
 			log.Printf("service %s is no longer required, deleting", svcName)
 			if err := ensureServiceDeleted(svcName, svc, ep.nfr); err != nil {
 				return fmt.Errorf("error deleting service %s: %w", svcName, err)
 			}
 			// TODO (irbekrm): also delete the SNAT rule here
+			continue
+		}
+		if (len(cfg.Backends) != 0) != (len(svc.Backends) != 0) {
+			// The rules for weighted backends are set up differently,
+			// so recreate the service from scratch.
+			log.Printf("service %s has switched between a single and weighted backends, recreating", svcName)
+			if err := ensureServiceDeleted(svcName, svc, ep.nfr); err != nil {
+				return fmt.Errorf("error deleting service %s: %w", svcName, err)
+			}
+			delete(status.Services, svcName)
 		}
 	}
 	return nil
@@ -7,6 +7,7 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,9 +16,15 @@
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/mdlayher/netlink"
+	"tailscale.com/ipn"
 	"tailscale.com/kube/egressservices"
 	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/linuxfw"
 )
 
 func Test_updatesForSvc(t *testing.T) {
@@ -322,3 +329,160 @@ func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	}
 	return resp, nil
 }
+
+func TestSyncWeightedBackends(t *testing.T) {
+	backend1, backend2, backend3 := netip.MustParseAddr("100.99.99.1"), netip.MustParseAddr("100.99.99.2"), netip.MustParseAddr("100.99.99.3")
+	pm := egressservices.PortMap{Protocol: "tcp", MatchPort: 4003, TargetPort: 80}
+	pm1 := egressservices.PortMap{Protocol: "udp", MatchPort: 4004, TargetPort: 53}
+	backend := func(ip netip.Addr, weight uint32) egressservices.Backend {
+		return egressservices.Backend{TailnetTarget: egressservices.TailnetTarget{IP: ip.String()}, Weight: weight}
+	}
+	nfr := linuxfw.NewFakeNetfilterRunner()
+	var conns map[netip.Addr]int
+	var connsErr error
+	ep := &egressProxy{
+		nfr:           nfr,
+		listConntrack: func() (map[netip.Addr]int, error) { return conns, connsErr },
+		tailnetAddrs:  []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+	}
+	n := ipn.Notify{NetMap: &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")}}).View(),
+	}}
+	now := time.Now()
+	var status *egressservices.Status
+	sync := func(cfg egressservices.Config) *egressservices.ServiceStatus {
+		t.Helper()
+		var err error
+		status, err = ep.syncEgressConfigs(&egressservices.Configs{"svc": cfg}, status, n, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status.Services["svc"]
+	}
+	expectTargets := func(pm egressservices.PortMap, want ...linuxfw.WeightedTarget) {
+		t.Helper()
+		got := nfr.GetWeightedPortMapRules("svc")[toLinuxfwPortMap(pm)]
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("unexpected weighted targets %v, want %v", got, want)
+		}
+	}
+
+	// 1. Weighted backends are set up for each port; a weight of 0 is
+	// treated as 1.
+	cfg := egressservices.Config{
+		Ports:    egressservices.PortMaps{pm: {}, pm1: {}},
+		Backends: []egressservices.Backend{backend(backend1, 3), backend(backend2, 0)},
+		Drain:    true,
+	}
+	st := sync(cfg)
+	expectTargets(pm, linuxfw.WeightedTarget{IP: backend1, Weight: 3}, linuxfw.WeightedTarget{IP: backend2, Weight: 1})
+	expectTargets(pm1, linuxfw.WeightedTarget{IP: backend1, Weight: 3}, linuxfw.WeightedTarget{IP: backend2, Weight: 1})
+	if want := []netip.Addr{backend1, backend2}; !reflect.DeepEqual(st.TailnetTargetIPs, want) {
+		t.Fatalf("unexpected tailnet target IPs %v, want %v", st.TailnetTargetIPs, want)
+	}
+
+	// 2. A removed backend stops receiving new connections and is drained
+	// while it has connections in conntrack.
+	conns = map[netip.Addr]int{backend1: 1}
+	cfg.Backends = []egressservices.Backend{backend(backend2, 1), backend(backend3, 1)}
+	cfg.Ports = egressservices.PortMaps{pm: {}}
+	st = sync(cfg)
+	expectTargets(pm, linuxfw.WeightedTarget{IP: backend2, Weight: 1}, linuxfw.WeightedTarget{IP: backend3, Weight: 1})
+	expectTargets(pm1)
+	if want := []egressservices.DrainingTarget{{IP: backend1, Since: now}}; !reflect.DeepEqual(st.Draining, want) {
+		t.Fatalf("unexpected draining targets %+v, want %+v", st.Draining, want)
+	}
+	if !ep.draining {
+		t.Fatal("expected proxy to be draining")
+	}
+
+	// 3. The backend is still draining while it has connections.
+	now = now.Add(time.Minute)
+	if st = sync(cfg); len(st.Draining) != 1 {
+		t.Fatalf("expected backend to be draining, got %+v", st.Draining)
+	}
+
+	// 4. Once the connections are gone, the drain completes.
+	conns = nil
+	if st = sync(cfg); len(st.Draining) != 0 || ep.draining {
+		t.Fatalf("expected drain to be completed, got %+v", st.Draining)
+	}
+
+	// 5. If conntrack entries cannot be listed, the backend is drained for
+	// maxDrainDuration.
+	connsErr = errors.New("operation not permitted")
+	cfg.Backends = []egressservices.Backend{backend(backend3, 1)}
+	if st = sync(cfg); len(st.Draining) != 1 {
+		t.Fatalf("expected backend to be draining, got %+v", st.Draining)
+	}
+	now = now.Add(maxDrainDuration)
+	if st = sync(cfg); len(st.Draining) != 0 {
+		t.Fatalf("expected drain to be completed, got %+v", st.Draining)
+	}
+	expectTargets(pm, linuxfw.WeightedTarget{IP: backend3, Weight: 1})
+
+	// 6. Without drain, removed backends are not tracked.
+	cfg.Drain = false
+	cfg.Backends = []egressservices.Backend{backend(backend1, 1)}
+	if st = sync(cfg); len(st.Draining) != 0 {
+		t.Fatalf("expected no draining backends, got %+v", st.Draining)
+	}
+	expectTargets(pm, linuxfw.WeightedTarget{IP: backend1, Weight: 1})
+
+	// 7. Switching to a single tailnet target deletes the weighted rules.
+	cfg.Backends = nil
+	cfg.TailnetTarget = egressservices.TailnetTarget{IP: backend1.String()}
+	st = sync(cfg)
+	expectTargets(pm)
+	if len(st.Backends) != 0 {
+		t.Fatalf("unexpected backends in status: %+v", st.Backends)
+	}
+}
+
+func TestCountConntrackAddrs(t *testing.T) {
+	entry := func(origSrc, origDst, replySrc, replyDst netip.Addr) netlink.Message {
+		ae := netlink.NewAttributeEncoder()
+		tuple := func(typ uint16, src, dst netip.Addr) {
+			srcType, dstType := uint16(ctaIPv4Src), uint16(ctaIPv4Dst)
+			if src.Is6() {
+				srcType, dstType = ctaIPv6Src, ctaIPv6Dst
+			}
+			ae.Nested(typ, func(tae *netlink.AttributeEncoder) error {
+				tae.Nested(ctaTupleIP, func(iae *netlink.AttributeEncoder) error {
+					iae.Bytes(srcType, src.AsSlice())
+					iae.Bytes(dstType, dst.AsSlice())
+					return nil
+				})
+				return nil
+			})
+		}
+		tuple(ctaTupleOrig, origSrc, origDst)
+		tuple(ctaTupleReply, replySrc, replyDst)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return netlink.Message{Data: append([]byte{2, 0, 0, 0}, b...)}
+	}
+	pod, svc := netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.9")
+	backend, self := netip.MustParseAddr("100.99.99.1"), netip.MustParseAddr("100.64.0.1")
+	pod6, svc6 := netip.MustParseAddr("fd00::5"), netip.MustParseAddr("fd00::9")
+	backend6, self6 := netip.MustParseAddr("fd7a:115c:a1e0::1"), netip.MustParseAddr("fd7a:115c:a1e0::2")
+
+	got, err := countConntrackAddrs([]netlink.Message{
+		entry(pod, svc, backend, self),
+		entry(pod, svc, backend, self),
+		entry(pod6, svc6, backend6, self6),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[netip.Addr]int{pod: 2, svc: 2, backend: 2, self: 2, pod6: 1, svc6: 1, backend6: 1, self6: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("countConntrackAddrs() = %v, want %v", got, want)
+	}
+
+	if _, err := countConntrackAddrs([]netlink.Message{{Data: []byte{2}}}); err == nil {
+		t.Error("countConntrackAddrs() succeeded for a truncated message")
+	}
+}
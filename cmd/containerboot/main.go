@@ -73,6 +73,11 @@
 //     and not `tailscale up` or `tailscale set`.
 //     The config file contents are currently read once on container start.
 //     NB: This env var is currently experimental and the logic will likely change!
+//   - TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX: if specified with
+//     TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR, the config file is instead named
+//     <prefix>cap-<current-tailscaled-cap>.hujson. This lets the replicas of
+//     a StatefulSet share a config directory, with the prefix set from the
+//     Pod name.
 //     TS_EXPERIMENTAL_ENABLE_FORWARDING_OPTIMIZATIONS: set to true to
 //     autoconfigure the default network interface for optimal performance for
 //     Tailscale subnet router/exit node.
@@ -834,14 +839,16 @@ func contextWithExitSignalWatch() (context.Context, func()) {
 // tailscaledConfigFilePath returns the path to the tailscaled config file that
 // should be used for the current capability version. It is determined by the
 // TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR environment variable and looks for a
-// file named cap-<capability_version>.hujson in the directory. It searches for
-// the highest capability version that is less than or equal to the current
-// capability version.
+// file named cap-<capability_version>.hujson in the directory, prefixed with
+// TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX if set. It searches for the highest
+// capability version that is less than or equal to the current capability
+// version.
 func tailscaledConfigFilePath() string {
 	dir := os.Getenv("TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR")
 	if dir == "" {
 		return ""
 	}
+	prefix := os.Getenv("TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX")
 	fe, err := os.ReadDir(dir)
 	if err != nil {
 		log.Fatalf("error reading tailscaled config directory %q: %v", dir, err)
@@ -854,7 +861,11 @@ func tailscaledConfigFilePath() string {
 		if e.Type().IsDir() {
 			continue
 		}
-		cv, err := kubeutils.CapVerFromFileName(e.Name())
+		name, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok {
+			continue
+		}
+		cv, err := kubeutils.CapVerFromFileName(name)
 		if err != nil {
 			continue
 		}
@@ -865,7 +876,7 @@ func tailscaledConfigFilePath() string {
 	if maxCompatVer == -1 {
 		log.Fatalf("no tailscaled config file found in %q for current capability version %d", dir, tailcfg.CurrentCapabilityVersion)
 	}
-	filePath := filepath.Join(dir, kubeutils.TailscaledConfigFileName(maxCompatVer))
+	filePath := filepath.Join(dir, prefix+kubeutils.TailscaledConfigFileName(maxCompatVer))
 	log.Printf("Using tailscaled config file %q to match current capability version %d", filePath, tailcfg.CurrentCapabilityVersion)
 	return filePath
 }
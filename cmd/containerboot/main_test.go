@@ -774,6 +774,23 @@ type testCase struct {
 				},
 			}
 		},
+		"experimental_tailscaled_config_prefix": func(env *testEnv) testCase {
+			return testCase{
+				Env: map[string]string{
+					"TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR":    filepath.Join(env.d, "etc/tailscaled/"),
+					"TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX": "proxy-1.",
+				},
+				Phases: []phase{
+					{
+						WantCmds: []string{
+							"/usr/bin/tailscaled --socket=/tmp/tailscaled.sock --state=mem: --statedir=/tmp --tun=userspace-networking --config=/etc/tailscaled/proxy-1.cap-95.hujson",
+						},
+					}, {
+						Notify: runningNotify,
+					},
+				},
+			}
+		},
 		"metrics_enabled": func(env *testEnv) testCase {
 			return testCase{
 				Env: map[string]string{
@@ -1632,6 +1649,7 @@ func newTestEnv(t *testing.T) testEnv {
 		"proc/sys/net/ipv4/ip_forward":          []byte("0"),
 		"proc/sys/net/ipv6/conf/all/forwarding": []byte("0"),
 		"etc/tailscaled/cap-95.hujson":          mustJSON(t, tailscaledConf),
+		"etc/tailscaled/proxy-1.cap-95.hujson":  mustJSON(t, tailscaledConf),
 		"etc/tailscaled/serve-config.json":      mustJSON(t, serveConf),
 		filepath.Join("etc/tailscaled/", egressservices.KeyEgressServices): mustJSON(t, egressCfg),
 		filepath.Join("etc/tailscaled/", egressservices.KeyHEPPings):       []byte("4"),
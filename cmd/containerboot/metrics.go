@@ -6,33 +6,47 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
 
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/kube/kubetypes"
 )
 
+// conntrackCountPath is the file that reports the number of connections
+// tracked by the kernel in the current network namespace.
+const conntrackCountPath = "/proc/sys/net/netfilter/nf_conntrack_count"
+
 // metrics is a simple metrics HTTP server, if enabled it forwards requests to
 // the tailscaled's LocalAPI usermetrics endpoint at /localapi/v0/usermetrics.
 type metrics struct {
-	debugEndpoint string
-	lc            *local.Client
+	debugEndpoint      string
+	lc                 *local.Client
+	conntrackCountPath string // overridden in tests
 }
 
-func proxy(w http.ResponseWriter, r *http.Request, url string, do func(*http.Request) (*http.Response, error)) {
+// proxy forwards the request to url and copies the response to w. It reports
+// whether the response was successfully proxied with a 2xx status code.
+//
+// The Content-Length header of the response is not copied, so that callers
+// can append to the body.
+func proxy(w http.ResponseWriter, r *http.Request, url string, do func(*http.Request) (*http.Response, error)) bool {
 	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, r.Body)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to construct request: %s", err), http.StatusInternalServerError)
-		return
+		return false
 	}
 	req.Header = r.Header.Clone()
 
 	resp, err := do(req)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to proxy request: %s", err), http.StatusInternalServerError)
-		return
+		return false
 	}
 	defer resp.Body.Close()
 
@@ -41,15 +55,43 @@ func proxy(w http.ResponseWriter, r *http.Request, url string, do func(*http.Req
 			w.Header().Add(key, v)
 		}
 	}
+	w.Header().Del("Content-Length")
 	w.WriteHeader(resp.StatusCode)
 	if _, err := io.Copy(w, resp.Body); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
 	}
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
 }
 
 func (m *metrics) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	localAPIURL := "http://" + apitype.LocalAPIHost + "/localapi/v0/usermetrics"
-	proxy(w, r, localAPIURL, m.lc.DoLocalRequest)
+	if !proxy(w, r, localAPIURL, m.lc.DoLocalRequest) {
+		return
+	}
+	// The number of tracked connections is not known to tailscaled, as most
+	// proxied connections are forwarded by the kernel, so append it to the
+	// user metrics. It is used by the operator to autoscale ProxyGroups.
+	if n, ok := m.trackedConnections(); ok {
+		fmt.Fprintf(w, "# HELP %s Number of connections tracked by the kernel in the proxy's network namespace.\n", kubetypes.MetricProxyConnections)
+		fmt.Fprintf(w, "# TYPE %s gauge\n", kubetypes.MetricProxyConnections)
+		fmt.Fprintf(w, "%s %d\n", kubetypes.MetricProxyConnections, n)
+	}
+}
+
+// trackedConnections returns the number of connections tracked by conntrack
+// in the proxy's network namespace. It returns false if conntrack is not in
+// use, for example, for userspace proxies.
+func (m *metrics) trackedConnections() (int64, bool) {
+	b, err := os.ReadFile(m.conntrackCountPath)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
 }
 
 func (m *metrics) handleDebug(w http.ResponseWriter, r *http.Request) {
@@ -70,8 +112,9 @@ func (m *metrics) handleDebug(w http.ResponseWriter, r *http.Request) {
 // metrics instead of debug metrics on the "metrics" port.
 func registerMetricsHandlers(mux *http.ServeMux, lc *local.Client, debugAddrPort string) {
 	m := &metrics{
-		lc:            lc,
-		debugEndpoint: debugAddrPort,
+		lc:                 lc,
+		debugEndpoint:      debugAddrPort,
+		conntrackCountPath: conntrackCountPath,
 	}
 
 	mux.HandleFunc("GET /metrics", m.handleMetrics)
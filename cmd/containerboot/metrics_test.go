@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/local"
+	"tailscale.com/kube/kubetypes"
+)
+
+func TestMetricsConntrackCount(t *testing.T) {
+	const userMetrics = "tailscaled_inbound_bytes_total 5\n"
+	lapi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/localapi/v0/usermetrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(userMetrics)))
+		io.WriteString(w, userMetrics)
+	}))
+	defer lapi.Close()
+	lc := &local.Client{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", lapi.Listener.Addr().String())
+		},
+	}
+
+	countPath := filepath.Join(t.TempDir(), "nf_conntrack_count")
+	m := &metrics{lc: lc, conntrackCountPath: countPath}
+	srv := httptest.NewServer(http.HandlerFunc(m.handleMetrics))
+	defer srv.Close()
+
+	get := func() string {
+		t.Helper()
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Without conntrack, only the user metrics are served.
+	if got := get(); got != userMetrics {
+		t.Errorf("got metrics %q; want %q", got, userMetrics)
+	}
+
+	if err := os.WriteFile(countPath, []byte("42\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got := get()
+	if !strings.HasPrefix(got, userMetrics) {
+		t.Errorf("got metrics %q; want them to start with the user metrics", got)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("# TYPE %s gauge\n", kubetypes.MetricProxyConnections),
+		fmt.Sprintf("%s 42\n", kubetypes.MetricProxyConnections),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got metrics %q; want them to contain %q", got, want)
+		}
+	}
+}
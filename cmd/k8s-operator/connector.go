@@ -199,6 +199,7 @@ func (a *ConnectorReconciler) maybeProvisionConnector(ctx context.Context, logge
 		},
 		ProxyClassName: proxyClass,
 		proxyType:      proxyTypeConnector,
+		Replicas:       cn.Spec.Replicas,
 	}
 
 	if cn.Spec.SubnetRouter != nil && len(cn.Spec.SubnetRouter.AdvertiseRoutes) > 0 {
@@ -243,21 +244,35 @@ func (a *ConnectorReconciler) maybeProvisionConnector(ctx context.Context, logge
 		return err
 	}
 
-	dev, err := a.ssr.DeviceInfo(ctx, crl, logger)
+	devs, err := a.ssr.ReplicaDeviceInfo(ctx, crl, logger)
 	if err != nil {
 		return err
 	}
 
-	if dev == nil || dev.hostname == "" {
-		logger.Debugf("no Tailscale hostname known yet, waiting for Connector Pod to finish auth")
-		// No hostname yet. Wait for the connector pod to auth.
+	cn.Status.Devices = nil
+	for _, dev := range devs {
+		if dev.hostname == "" {
+			// No hostname yet. Wait for the replica's Pod to auth.
+			continue
+		}
+		cn.Status.Devices = append(cn.Status.Devices, tsapi.TailnetDevice{
+			Hostname:   dev.hostname,
+			TailnetIPs: dev.ips,
+		})
+	}
+	if len(cn.Status.Devices) == 0 {
+		logger.Debugf("no Tailscale hostname known yet, waiting for Connector Pods to finish auth")
+	}
+
+	// The top level hostname and IPs are only set for single replica
+	// Connectors, so that they always refer to the same device.
+	if sts.replicas() != 1 || len(cn.Status.Devices) != 1 {
 		cn.Status.TailnetIPs = nil
 		cn.Status.Hostname = ""
 		return nil
 	}
-
-	cn.Status.TailnetIPs = dev.ips
-	cn.Status.Hostname = dev.hostname
+	cn.Status.TailnetIPs = cn.Status.Devices[0].TailnetIPs
+	cn.Status.Hostname = cn.Status.Devices[0].Hostname
 
 	return nil
 }
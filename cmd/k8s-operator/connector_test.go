@@ -7,9 +7,13 @@
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -20,6 +24,7 @@
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/kube/kubetypes"
 	"tailscale.com/tstest"
+	"tailscale.com/types/ptr"
 	"tailscale.com/util/mak"
 )
 
@@ -95,6 +100,7 @@ func TestConnector(t *testing.T) {
 	cn.Status.SubnetRoutes = cn.Spec.SubnetRouter.AdvertiseRoutes.Stringify()
 	cn.Status.Hostname = hostname
 	cn.Status.TailnetIPs = []string{"127.0.0.1", "::1"}
+	cn.Status.Devices = []tsapi.TailnetDevice{{Hostname: hostname, TailnetIPs: []string{"127.0.0.1", "::1"}}}
 	expectEqual(t, fc, cn, func(o *tsapi.Connector) {
 		o.Status.Conditions = nil
 	})
@@ -395,3 +401,207 @@ func TestConnectorWithAppConnector(t *testing.T) {
 	}}
 	expectReconciled(t, cr, "", "test")
 }
+
+func TestConnectorWithReplicas(t *testing.T) {
+	cn := &tsapi.Connector{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  types.UID("1234-UID"),
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       tsapi.ConnectorKind,
+			APIVersion: "tailscale.com/v1alpha1",
+		},
+		Spec: tsapi.ConnectorSpec{
+			SubnetRouter: &tsapi.SubnetRouter{
+				AdvertiseRoutes: []tsapi.Route{"10.40.0.0/14"},
+			},
+			Replicas: ptr.To[int32](3),
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(cn).
+		WithStatusSubresource(cn).
+		Build()
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	cr := &ConnectorReconciler{
+		Client: fc,
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		clock:  cl,
+		logger: zl.Sugar(),
+	}
+	crl := childResourceLabels("test", "", "connector")
+
+	expectReconciled(t, cr, "", "test")
+	sts, err := getSingleObject[appsv1.StatefulSet](context.Background(), fc, "operator-ns", crl)
+	if err != nil || sts == nil {
+		t.Fatalf("getting StatefulSet: %v", err)
+	}
+	if got := stsReplicas(sts); got != 3 {
+		t.Fatalf("got %d StatefulSet replicas, want 3", got)
+	}
+	c := sts.Spec.Template.Spec.Containers[0]
+	if !slices.Contains(c.Env, corev1.EnvVar{Name: "TS_KUBE_SECRET", Value: "$(POD_NAME)"}) {
+		t.Errorf("replicas do not use their own state Secrets: %v", c.Env)
+	}
+	if c.ReadinessProbe == nil || c.ReadinessProbe.HTTPGet == nil || c.ReadinessProbe.HTTPGet.Path != "/healthz" {
+		t.Errorf("unexpected readiness probe %+v", c.ReadinessProbe)
+	}
+
+	// Each replica gets its own Secret with the same routes, but a different
+	// hostname. All replicas mount the shared config Secret, which has each
+	// replica's config prefixed with its Pod name.
+	secretName := func(i int) string { return fmt.Sprintf("%s-%d", sts.Name, i) }
+	configKeys := func() []string {
+		t.Helper()
+		cfg := &corev1.Secret{}
+		if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "operator-ns", Name: sts.Name + "-config"}, cfg); err != nil {
+			t.Fatalf("getting config Secret: %v", err)
+		}
+		return slices.Sorted(maps.Keys(cfg.Data))
+	}
+	var wantKeys []string
+	for i := range 3 {
+		opts := configOpts{
+			secretName:   secretName(i),
+			parentType:   "connector",
+			hostname:     fmt.Sprintf("test-connector-%d", i),
+			subnetRoutes: "10.40.0.0/14",
+		}
+		expectEqual(t, fc, expectedSecret(t, fc, opts))
+		wantKeys = append(wantKeys, secretName(i)+".cap-107.hujson", secretName(i)+".cap-95.hujson")
+	}
+	if got := configKeys(); !slices.Equal(got, wantKeys) {
+		t.Errorf("config Secret has keys %q, want %q", got, wantKeys)
+	}
+	if !slices.Contains(c.Env, corev1.EnvVar{Name: "TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX", Value: "$(POD_NAME)."}) {
+		t.Errorf("replicas do not read their own config: %v", c.Env)
+	}
+
+	// Connector status lists the devices of the replicas that have
+	// authenticated.
+	for i := range 2 {
+		mustUpdate(t, fc, "operator-ns", secretName(i), func(s *corev1.Secret) {
+			mak.Set(&s.Data, "device_id", []byte(fmt.Sprintf("id-%d", i)))
+			mak.Set(&s.Data, "device_fqdn", []byte(fmt.Sprintf("test-connector-%d.tailnetxyz.ts.net.", i)))
+			mak.Set(&s.Data, "device_ips", []byte(fmt.Sprintf(`["100.64.0.%d"]`, i)))
+		})
+	}
+	expectReconciled(t, cr, "", "test")
+	cn.Finalizers = append(cn.Finalizers, "tailscale.com/finalizer")
+	cn.Status.SubnetRoutes = "10.40.0.0/14"
+	cn.Status.Devices = []tsapi.TailnetDevice{
+		{Hostname: "test-connector-0.tailnetxyz.ts.net", TailnetIPs: []string{"100.64.0.0"}},
+		{Hostname: "test-connector-1.tailnetxyz.ts.net", TailnetIPs: []string{"100.64.0.1"}},
+	}
+	expectEqual(t, fc, cn, func(o *tsapi.Connector) {
+		o.Status.Conditions = nil
+	})
+
+	// Scaling between multiple replicas doesn't change the Pod template, so
+	// that the remaining replicas aren't restarted.
+	sts, err = getSingleObject[appsv1.StatefulSet](context.Background(), fc, "operator-ns", crl)
+	if err != nil || sts == nil {
+		t.Fatalf("getting StatefulSet: %v", err)
+	}
+	tmpl := sts.Spec.Template.DeepCopy()
+	mustUpdate(t, fc, "", "test", func(conn *tsapi.Connector) {
+		conn.Spec.Replicas = ptr.To[int32](2)
+	})
+	expectReconciled(t, cr, "", "test")
+	sts, err = getSingleObject[appsv1.StatefulSet](context.Background(), fc, "operator-ns", crl)
+	if err != nil || sts == nil {
+		t.Fatalf("getting StatefulSet: %v", err)
+	}
+	if got := stsReplicas(sts); got != 2 {
+		t.Fatalf("got %d StatefulSet replicas, want 2", got)
+	}
+	if diff := cmp.Diff(tmpl, &sts.Spec.Template); diff != "" {
+		t.Errorf("Pod template changed when scaling (-old +new):\n%s", diff)
+	}
+	if got, want := configKeys(), wantKeys[:4]; !slices.Equal(got, want) {
+		t.Errorf("config Secret has keys %q, want %q", got, want)
+	}
+	expectMissing[corev1.Secret](t, fc, "operator-ns", secretName(2))
+
+	// Scale down to a single replica. The resources of the removed replicas
+	// are only cleaned up once their Pods are gone.
+	mustCreate(t, fc, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(1),
+			Namespace: "operator-ns",
+		},
+	})
+	mustUpdate(t, fc, "", "test", func(conn *tsapi.Connector) {
+		conn.Spec.Replicas = ptr.To[int32](1)
+	})
+	expectReconciled(t, cr, "", "test")
+	sts, err = getSingleObject[appsv1.StatefulSet](context.Background(), fc, "operator-ns", crl)
+	if err != nil || sts == nil {
+		t.Fatalf("getting StatefulSet: %v", err)
+	}
+	if got := stsReplicas(sts); got != 1 {
+		t.Fatalf("got %d StatefulSet replicas, want 1", got)
+	}
+	expectMissing[corev1.Secret](t, fc, "operator-ns", sts.Name+"-config")
+	expectEqual(t, fc, expectedSecret(t, fc, configOpts{
+		secretName:          secretName(1),
+		parentType:          "connector",
+		hostname:            "test-connector-1",
+		subnetRoutes:        "10.40.0.0/14",
+		shouldRemoveAuthKey: true,
+	}), func(s *corev1.Secret) {
+		s.Data = nil
+	})
+	if got := ft.Deleted(); len(got) != 0 {
+		t.Fatalf("devices deleted while replica is still running: %v", got)
+	}
+
+	mustDeleteAll(t, fc, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: secretName(1), Namespace: "operator-ns"}})
+	expectReconciled(t, cr, "", "test")
+	expectMissing[corev1.Secret](t, fc, "operator-ns", secretName(1))
+	if got, want := ft.Deleted(), []string{"id-1"}; !slices.Equal(got, want) {
+		t.Fatalf("got deleted devices %v, want %v", got, want)
+	}
+	// The remaining replica gets the Connector's hostname and the top level
+	// status fields are set.
+	expectEqual(t, fc, expectedSecret(t, fc, configOpts{
+		secretName:          secretName(0),
+		parentType:          "connector",
+		hostname:            "test-connector",
+		subnetRoutes:        "10.40.0.0/14",
+		shouldRemoveAuthKey: true,
+	}), func(s *corev1.Secret) {
+		s.Data = nil
+	})
+	cn.Spec.Replicas = ptr.To[int32](1)
+	cn.Status.Devices = cn.Status.Devices[:1]
+	cn.Status.Hostname = "test-connector-0.tailnetxyz.ts.net"
+	cn.Status.TailnetIPs = []string{"100.64.0.0"}
+	expectEqual(t, fc, cn, func(o *tsapi.Connector) {
+		o.Status.Conditions = nil
+	})
+
+	// Deleting the Connector cleans up the devices of all replicas.
+	if err = fc.Delete(context.Background(), cn); err != nil {
+		t.Fatalf("error deleting Connector: %v", err)
+	}
+	expectRequeue(t, cr, "", "test")
+	expectReconciled(t, cr, "", "test")
+	expectMissing[corev1.Secret](t, fc, "operator-ns", secretName(0))
+	if got, want := ft.Deleted(), []string{"id-1", "id-0"}; !slices.Equal(got, want) {
+		t.Fatalf("got deleted devices %v, want %v", got, want)
+	}
+}
@@ -512,7 +512,7 @@ func (f *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	r := http.Response{
 		StatusCode: resp.statusCode,
 		Header:     make(http.Header),
-		Body:       io.NopCloser(bytes.NewReader([]byte{})),
+		Body:       io.NopCloser(bytes.NewReader([]byte(resp.body))),
 	}
 	r.Header.Add(kubetypes.PodIPv4Header, resp.podIP)
 	return &r, nil
@@ -522,4 +522,5 @@ type fakeResponse struct {
 	err        error
 	statusCode int
 	podIP      string // for the Pod IP header
+	body       string
 }
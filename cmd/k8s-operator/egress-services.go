@@ -12,8 +12,10 @@
 	"errors"
 	"fmt"
 	"math/rand/v2"
+	"net/netip"
 	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -548,7 +550,10 @@ func egressSvcCfg(externalNameSvc, clusterIPSvc *corev1.Service, ns string, l *z
 	cfg := egressservices.Config{
 		TailnetTarget:       tt,
 		HealthCheckEndpoint: hep,
+		Drain:               externalNameSvc.Annotations[AnnotationDrainBackends] == "true",
 	}
+	// The annotation has already been validated.
+	cfg.Backends, _ = backendsFromSvc(externalNameSvc)
 	for _, svcPort := range clusterIPSvc.Spec.Ports {
 		if svcPort.Name == tsHealthCheckPortName {
 			continue // exclude healthcheck from egress svcs configs
@@ -562,9 +567,26 @@ func egressSvcCfg(externalNameSvc, clusterIPSvc *corev1.Service, ns string, l *z
 func validateEgressService(svc *corev1.Service, pg *tsapi.ProxyGroup) []string {
 	violations := validateService(svc)
 
-	// We check that only one of these two is set in the earlier validateService function.
-	if svc.Annotations[AnnotationTailnetTargetFQDN] == "" && svc.Annotations[AnnotationTailnetTargetIP] == "" {
-		violations = append(violations, fmt.Sprintf("egress Service for ProxyGroup must have one of %s, %s annotations set", AnnotationTailnetTargetFQDN, AnnotationTailnetTargetIP))
+	// We check that only one of the first two is set in the earlier validateService function.
+	hasTarget := svc.Annotations[AnnotationTailnetTargetFQDN] != "" || svc.Annotations[AnnotationTailnetTargetIP] != ""
+	hasBackends := svc.Annotations[AnnotationTailnetTargetBackends] != ""
+	switch {
+	case !hasTarget && !hasBackends:
+		violations = append(violations, fmt.Sprintf("egress Service for ProxyGroup must have one of %s, %s, %s annotations set", AnnotationTailnetTargetFQDN, AnnotationTailnetTargetIP, AnnotationTailnetTargetBackends))
+	case hasTarget && hasBackends:
+		violations = append(violations, fmt.Sprintf("annotation %s cannot be set together with %s or %s", AnnotationTailnetTargetBackends, AnnotationTailnetTargetFQDN, AnnotationTailnetTargetIP))
+	}
+	if _, err := backendsFromSvc(svc); err != nil {
+		violations = append(violations, fmt.Sprintf("invalid value of annotation %s: %v", AnnotationTailnetTargetBackends, err))
+	}
+	switch drain := svc.Annotations[AnnotationDrainBackends]; drain {
+	case "", "false":
+	case "true":
+		if !hasBackends {
+			violations = append(violations, fmt.Sprintf("annotation %s requires %s to be set", AnnotationDrainBackends, AnnotationTailnetTargetBackends))
+		}
+	default:
+		violations = append(violations, fmt.Sprintf("invalid value of annotation %s: %q, must be \"true\" or \"false\"", AnnotationDrainBackends, drain))
 	}
 	if len(svc.Spec.Ports) == 0 {
 		violations = append(violations, "egress Service for ProxyGroup must have at least one target Port specified")
@@ -628,6 +650,37 @@ func tailnetTargetFromSvc(svc *corev1.Service) egressservices.TailnetTarget {
 	}
 }
 
+// backendsFromSvc returns the weighted backends set by the
+// tailscale.com/tailnet-backends annotation of the given egress Service, if
+// any.
+func backendsFromSvc(svc *corev1.Service) ([]egressservices.Backend, error) {
+	v := svc.Annotations[AnnotationTailnetTargetBackends]
+	if v == "" {
+		return nil, nil
+	}
+	var backends []egressservices.Backend
+	for _, s := range strings.Split(v, ",") {
+		target, weight, hasWeight := strings.Cut(strings.TrimSpace(s), "=")
+		var b egressservices.Backend
+		if ip, err := netip.ParseAddr(target); err == nil {
+			b.TailnetTarget.IP = ip.String()
+		} else if isMagicDNSName(target) {
+			b.TailnetTarget.FQDN = target
+		} else {
+			return nil, fmt.Errorf("backend %q is neither an IP address nor a MagicDNS name", target)
+		}
+		if hasWeight {
+			w, err := strconv.ParseUint(weight, 10, 32)
+			if err != nil || w == 0 {
+				return nil, fmt.Errorf("invalid weight %q of backend %q, must be a positive integer", weight, target)
+			}
+			b.Weight = uint32(w)
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
 func portMap(p corev1.ServicePort) egressservices.PortMap {
 	// TODO (irbekrm): out of bounds check?
 	return egressservices.PortMap{
@@ -643,7 +696,7 @@ func isEgressSvcForProxyGroup(obj client.Object) bool {
 		return false
 	}
 	annots := s.ObjectMeta.Annotations
-	return annots[AnnotationProxyGroup] != "" && (annots[AnnotationTailnetTargetFQDN] != "" || annots[AnnotationTailnetTargetIP] != "")
+	return annots[AnnotationProxyGroup] != "" && (annots[AnnotationTailnetTargetFQDN] != "" || annots[AnnotationTailnetTargetIP] != "" || annots[AnnotationTailnetTargetBackends] != "")
 }
 
 // egressSvcConfig returns a ConfigMap that contains egress services configuration for the provided ProxyGroup as well
@@ -734,6 +787,8 @@ type cfg struct {
 	Ports         []corev1.ServicePort         `json:"ports"`
 	TailnetTarget egressservices.TailnetTarget `json:"tailnetTarget"`
 	ProxyGroup    string                       `json:"proxyGroup"`
+	Backends      []egressservices.Backend     `json:"backends,omitempty"`
+	Drain         bool                         `json:"drain,omitempty"`
 }
 
 func svcConfiguredReason(svc *corev1.Service, configured bool, l *zap.SugaredLogger) string {
@@ -745,10 +800,13 @@ func svcConfiguredReason(svc *corev1.Service, configured bool, l *zap.SugaredLog
 	}
 	r += fmt.Sprintf("ProxyGroup:%s", svc.Annotations[AnnotationProxyGroup])
 	tt := tailnetTargetFromSvc(svc)
+	backends, _ := backendsFromSvc(svc)
 	s := cfg{
 		Ports:         svc.Spec.Ports,
 		TailnetTarget: tt,
 		ProxyGroup:    svc.Annotations[AnnotationProxyGroup],
+		Backends:      backends,
+		Drain:         svc.Annotations[AnnotationDrainBackends] == "true",
 	}
 	r += fmt.Sprintf(":Config:%s", cfgHash(s, l))
 	return r
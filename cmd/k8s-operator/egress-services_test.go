@@ -115,6 +115,29 @@ func TestTailscaleEgressServices(t *testing.T) {
 		expectReconciled(t, esr, "default", "test")
 		validateReadyService(t, fc, esr, svc, clock, zl, cm)
 	})
+	t.Run("service_weighted_backends", func(t *testing.T) {
+		svc.Annotations = map[string]string{
+			AnnotationTailnetTargetBackends: "100.64.0.1=3, foo.bar.ts.net.",
+			AnnotationDrainBackends:         "true",
+			AnnotationProxyGroup:            "foo",
+		}
+		mustUpdate(t, fc, "default", "test", func(s *corev1.Service) {
+			s.Annotations = svc.Annotations
+		})
+		expectReconciled(t, esr, "default", "test")
+		validateReadyService(t, fc, esr, svc, clock, zl, cm)
+		gotCfg := configFromCM(t, cm, tailnetSvcName(svc))
+		wantBackends := []egressservices.Backend{
+			{TailnetTarget: egressservices.TailnetTarget{IP: "100.64.0.1"}, Weight: 3},
+			{TailnetTarget: egressservices.TailnetTarget{FQDN: "foo.bar.ts.net."}},
+		}
+		if diff := cmp.Diff(gotCfg.Backends, wantBackends); diff != "" {
+			t.Errorf("unexpected backends (-got +want):\n%s", diff)
+		}
+		if !gotCfg.Drain {
+			t.Error("drain not enabled in config")
+		}
+	})
 
 	t.Run("delete_external_name_service", func(t *testing.T) {
 		name := findGenNameForEgressSvcResources(t, fc, svc)
@@ -293,3 +316,57 @@ func configFromCM(t *testing.T, cm *corev1.ConfigMap, svcName string) *egressser
 	}
 	return nil
 }
+
+func TestValidateEgressServiceBackends(t *testing.T) {
+	pg := &tsapi.ProxyGroup{Spec: tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeEgress}}
+	tests := []struct {
+		name    string
+		annots  map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "backends",
+			annots: map[string]string{AnnotationTailnetTargetBackends: "100.64.0.1=2,fd7a:115c:a1e0::1,foo.bar.ts.net", AnnotationDrainBackends: "true"},
+		},
+		{
+			name:    "backends_and_target",
+			annots:  map[string]string{AnnotationTailnetTargetBackends: "100.64.0.1", AnnotationTailnetTargetIP: "100.64.0.2"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_backend",
+			annots:  map[string]string{AnnotationTailnetTargetBackends: "100.64.0.1,example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "zero_weight",
+			annots:  map[string]string{AnnotationTailnetTargetBackends: "100.64.0.1=0"},
+			wantErr: true,
+		},
+		{
+			name:    "drain_without_backends",
+			annots:  map[string]string{AnnotationTailnetTargetIP: "100.64.0.2", AnnotationDrainBackends: "true"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_drain",
+			annots:  map[string]string{AnnotationTailnetTargetBackends: "100.64.0.1", AnnotationDrainBackends: "yes"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: tt.annots},
+				Spec: corev1.ServiceSpec{
+					Type:  corev1.ServiceTypeExternalName,
+					Ports: []corev1.ServicePort{{Protocol: "TCP", Port: 80}},
+				},
+			}
+			violations := validateEgressService(svc, pg)
+			if (len(violations) > 0) != tt.wantErr {
+				t.Errorf("validateEgressService() = %q, wantErr %v", violations, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,1435 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	klabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	"tailscale.com/internal/client/tailscale"
+	"tailscale.com/ipn"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+const (
+	// gatewayControllerName is the controller name that GatewayClasses must
+	// set in .spec.controllerName for their Gateways to be managed by the
+	// operator.
+	gatewayControllerName gatewayv1.GatewayController = "tailscale.com/gateway-controller"
+	// FinalizerNameGateway is the finalizer used by the HAGatewayReconciler.
+	FinalizerNameGateway = "tailscale.com/gateway-finalizer"
+
+	kindGateway   gatewayv1.Kind = "Gateway"
+	kindHTTPRoute gatewayv1.Kind = "HTTPRoute"
+	kindTLSRoute  gatewayv1.Kind = "TLSRoute"
+	kindTCPRoute  gatewayv1.Kind = "TCPRoute"
+
+	// reasonUnsupportedHostname is the reason set on a listener whose
+	// hostname does not match the Gateway's MagicDNS name.
+	reasonUnsupportedHostname gatewayv1.ListenerConditionReason = "UnsupportedHostname"
+	// reasonInvalidParameters is the reason set on a Gateway whose
+	// ProxyGroup can't be determined. It's defined by Gateway API v1.1,
+	// which is newer than the version we depend on.
+	reasonInvalidParameters gatewayv1.GatewayConditionReason = "InvalidParameters"
+)
+
+var gaugeGatewayResources = clientmetric.NewGauge(kubetypes.MetricGatewayResourceCount)
+
+func init() {
+	if err := gatewayv1.Install(tsapi.GlobalScheme); err != nil {
+		panic(fmt.Sprintf("failed to add gateway.networking.k8s.io/v1 scheme: %s", err))
+	}
+	if err := gatewayv1alpha2.Install(tsapi.GlobalScheme); err != nil {
+		panic(fmt.Sprintf("failed to add gateway.networking.k8s.io/v1alpha2 scheme: %s", err))
+	}
+}
+
+// GatewayClassReconciler reconciles GatewayClasses whose controller name is
+// gatewayControllerName. It validates the class parameters, which may refer
+// to the ingress ProxyGroup that serves the class' Gateways, and reports the
+// result in the Accepted condition.
+type GatewayClassReconciler struct {
+	client.Client
+	logger *zap.SugaredLogger
+}
+
+func (r *GatewayClassReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("GatewayClass", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	gc := new(gatewayv1.GatewayClass)
+	err = r.Get(ctx, req.NamespacedName, gc)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("GatewayClass not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get GatewayClass: %w", err)
+	}
+	if gc.Spec.ControllerName != gatewayControllerName {
+		return res, nil
+	}
+
+	oldStatus := gc.Status.DeepCopy()
+	cond := metav1.Condition{
+		Type:               string(gatewayv1.GatewayClassConditionStatusAccepted),
+		Status:             metav1.ConditionTrue,
+		Reason:             string(gatewayv1.GatewayClassReasonAccepted),
+		Message:            "GatewayClass is accepted",
+		ObservedGeneration: gc.Generation,
+	}
+	if _, err := proxyGroupForGatewayClass(ctx, r.Client, gc); err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = string(gatewayv1.GatewayClassReasonInvalidParameters)
+		cond.Message = err.Error()
+	}
+	apimeta.SetStatusCondition(&gc.Status.Conditions, cond)
+	if apiequality.Semantic.DeepEqual(oldStatus, &gc.Status) {
+		return res, nil
+	}
+	logger.Infof("updating GatewayClass status, accepted: %s", cond.Status)
+	if err := r.Status().Update(ctx, gc); err != nil {
+		return res, fmt.Errorf("failed to update GatewayClass status: %w", err)
+	}
+	return res, nil
+}
+
+// proxyGroupForGatewayClass returns the name of the ProxyGroup referenced by
+// the GatewayClass' parameters, or an empty string if the class has no
+// parameters. It returns an error if the parameters are invalid.
+func proxyGroupForGatewayClass(ctx context.Context, cl client.Client, gc *gatewayv1.GatewayClass) (string, error) {
+	ref := gc.Spec.ParametersRef
+	if ref == nil {
+		return "", nil
+	}
+	if string(ref.Group) != tsapi.SchemeGroupVersion.Group || ref.Kind != "ProxyGroup" {
+		return "", fmt.Errorf("parametersRef must refer to a %s ProxyGroup, got %s %s", tsapi.SchemeGroupVersion.Group, ref.Group, ref.Kind)
+	}
+	pg := &tsapi.ProxyGroup{}
+	if err := cl.Get(ctx, client.ObjectKey{Name: ref.Name}, pg); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("ProxyGroup %q not found", ref.Name)
+		}
+		return "", fmt.Errorf("error getting ProxyGroup %q: %w", ref.Name, err)
+	}
+	if pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+		return "", fmt.Errorf("ProxyGroup %q is of type %q but must be of type %q", pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeIngress)
+	}
+	return pg.Name, nil
+}
+
+// HAGatewayReconciler reconciles Gateways of a GatewayClass managed by the
+// operator, along with the HTTPRoutes, TLSRoutes and TCPRoutes attached to
+// them. Each Gateway is exposed as a Tailscale Service on an ingress
+// ProxyGroup, the same way as HA Ingresses are, and shares the Ingresses'
+// serve config, TLS certificates and Tailscale Service ownership model.
+type HAGatewayReconciler struct {
+	*HAIngressReconciler
+
+	gwMu sync.Mutex // protects following
+	// managedGateways is a set of all Gateway resources that we're currently
+	// managing. This is only used for metrics.
+	managedGateways set.Slice[types.UID]
+}
+
+// Reconcile ensures that a Tailscale Service named after the hostname of the
+// Gateway exists, that the serve config of the Gateway's ProxyGroup routes
+// traffic for each of the Gateway's listeners to the backends of the routes
+// attached to it, and that the status of the Gateway and of its routes
+// reflects that.
+func (r *HAGatewayReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("Gateway", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	gw := new(gatewayv1.Gateway)
+	err = r.Get(ctx, req.NamespacedName, gw)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("Gateway not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get Gateway: %w", err)
+	}
+
+	hostname := hostnameForGateway(gw)
+	logger = logger.With("hostname", hostname)
+
+	gc, err := r.gatewayClass(ctx, gw)
+	if err != nil {
+		return res, err
+	}
+
+	needsRequeue := false
+	if !gw.DeletionTimestamp.IsZero() || gc == nil {
+		needsRequeue, err = r.maybeCleanupGateway(ctx, hostname, gw, logger)
+	} else {
+		needsRequeue, err = r.maybeProvisionGateway(ctx, hostname, gw, gc, logger)
+	}
+	if err != nil {
+		return res, err
+	}
+	if needsRequeue {
+		res = reconcile.Result{RequeueAfter: requeueInterval()}
+	}
+	return res, nil
+}
+
+// gatewayClass returns the GatewayClass of the Gateway, or nil if the class
+// does not exist or is not managed by the operator.
+func (r *HAGatewayReconciler) gatewayClass(ctx context.Context, gw *gatewayv1.Gateway) (*gatewayv1.GatewayClass, error) {
+	gc := &gatewayv1.GatewayClass{}
+	if err := r.Get(ctx, client.ObjectKey{Name: string(gw.Spec.GatewayClassName)}, gc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get GatewayClass %q: %w", gw.Spec.GatewayClassName, err)
+	}
+	if gc.Spec.ControllerName != gatewayControllerName {
+		return nil, nil
+	}
+	return gc, nil
+}
+
+// maybeProvisionGateway ensures that the Tailscale Service for the Gateway
+// exists and is up to date, and that the serve config of the Gateway's
+// ProxyGroup contains the Gateway's listeners. Returns true if the operation
+// resulted in a Tailscale Service update.
+func (r *HAGatewayReconciler) maybeProvisionGateway(ctx context.Context, hostname string, gw *gatewayv1.Gateway, gc *gatewayv1.GatewayClass, logger *zap.SugaredLogger) (svcsChanged bool, err error) {
+	oldStatus := gw.Status.DeepCopy()
+	defer func() {
+		if err != nil || apiequality.Semantic.DeepEqual(oldStatus, &gw.Status) {
+			return
+		}
+		logger.Infof("Updating Gateway status")
+		if updateErr := r.Status().Update(ctx, gw); updateErr != nil {
+			err = fmt.Errorf("failed to update Gateway status: %w", updateErr)
+		}
+	}()
+	setAccepted := func(status metav1.ConditionStatus, reason gatewayv1.GatewayConditionReason, msg string) {
+		setGatewayCondition(gw, gatewayv1.GatewayConditionAccepted, status, string(reason), msg)
+	}
+	setProgrammed := func(status metav1.ConditionStatus, reason gatewayv1.GatewayConditionReason, msg string) {
+		setGatewayCondition(gw, gatewayv1.GatewayConditionProgrammed, status, string(reason), msg)
+	}
+
+	serviceName := tailcfg.ServiceName("svc:" + hostname)
+	existingTSSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if isErrorFeatureFlagNotEnabled(err) {
+		logger.Warn(msgFeatureFlagNotEnabled)
+		r.recorder.Event(gw, corev1.EventTypeWarning, warningTailscaleServiceFeatureFlagNotEnabled, msgFeatureFlagNotEnabled)
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonPending, msgFeatureFlagNotEnabled)
+		return false, nil
+	}
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		return false, fmt.Errorf("error getting Tailscale Service %q: %w", hostname, err)
+	}
+
+	// Get and validate ProxyGroup readiness. The ProxyGroup annotation on
+	// the Gateway takes precedence over the GatewayClass parameters.
+	pgName, err := proxyGroupForGatewayClass(ctx, r.Client, gc)
+	if err != nil {
+		setAccepted(metav1.ConditionFalse, reasonInvalidParameters, fmt.Sprintf("invalid GatewayClass parameters: %v", err))
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, "Gateway is not accepted")
+		return false, nil
+	}
+	if a := gw.Annotations[AnnotationProxyGroup]; a != "" {
+		pgName = a
+	}
+	if pgName == "" {
+		msg := fmt.Sprintf("no ProxyGroup configured: set parametersRef on GatewayClass %q or the %s annotation on the Gateway", gc.Name, AnnotationProxyGroup)
+		setAccepted(metav1.ConditionFalse, reasonInvalidParameters, msg)
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, "Gateway is not accepted")
+		return false, nil
+	}
+	logger = logger.With("ProxyGroup", pgName)
+
+	pg := &tsapi.ProxyGroup{}
+	if err := r.Get(ctx, client.ObjectKey{Name: pgName}, pg); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Infof("ProxyGroup does not exist")
+			setAccepted(metav1.ConditionFalse, reasonInvalidParameters, fmt.Sprintf("ProxyGroup %q not found", pgName))
+			setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, "Gateway is not accepted")
+			return false, nil
+		}
+		return false, fmt.Errorf("getting ProxyGroup %q: %w", pgName, err)
+	}
+
+	// Validate Gateway configuration
+	if err := r.validateGateway(ctx, gw, pg); err != nil {
+		logger.Infof("invalid Gateway configuration: %v", err)
+		r.recorder.Event(gw, corev1.EventTypeWarning, "InvalidGatewayConfiguration", err.Error())
+		setAccepted(metav1.ConditionFalse, reasonInvalidParameters, err.Error())
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, "Gateway is not accepted")
+		return false, nil
+	}
+	if len(gw.Spec.Addresses) > 0 {
+		setAccepted(metav1.ConditionFalse, gatewayv1.GatewayReasonUnsupportedAddress, "Gateway addresses can not be requested, the Gateway is addressed by its MagicDNS name")
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, "Gateway is not accepted")
+		return false, nil
+	}
+	if !tsoperator.ProxyGroupIsReady(pg) {
+		logger.Infof("ProxyGroup is not (yet) ready")
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonPending, fmt.Sprintf("ProxyGroup %q is not ready", pgName))
+		return false, nil
+	}
+
+	if !slices.Contains(gw.Finalizers, FinalizerNameGateway) {
+		logger.Infof("exposing Gateway over tailscale")
+		gw.Finalizers = append(gw.Finalizers, FinalizerNameGateway)
+		if err := r.Update(ctx, gw); err != nil {
+			return false, fmt.Errorf("failed to add finalizer: %w", err)
+		}
+		r.gwMu.Lock()
+		r.managedGateways.Add(gw.UID)
+		gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+		r.gwMu.Unlock()
+	}
+
+	// 1. Ensure that Tailscale Services no longer used by any Ingress or
+	// Gateway on this ProxyGroup are cleaned up (for example, because the
+	// Gateway's hostname changed), and that the Tailscale Service for this
+	// Gateway is not served by other ProxyGroups (because the Gateway was
+	// moved to a different ProxyGroup).
+	svcsChanged, err = r.maybeCleanupProxyGroup(ctx, pgName, logger)
+	if err != nil {
+		return false, fmt.Errorf("failed to cleanup Tailscale Service resources for ProxyGroup: %w", err)
+	}
+	if err := r.removeFromProxyGroups(ctx, serviceName, pgName, logger); err != nil {
+		return false, fmt.Errorf("failed to remove Tailscale Service from other ProxyGroups: %w", err)
+	}
+
+	// 2. Ensure that this operator instance owns the Tailscale Service.
+	updatedAnnotations, err := r.ownerAnnotations(existingTSSvc)
+	if err != nil {
+		const instr = "To proceed, you can either manually delete the existing Tailscale Service or choose a different hostname with the tailscale.com/hostname annotation on the Gateway"
+		msg := fmt.Sprintf("error ensuring ownership of Tailscale Service %s: %v. %s", hostname, err, instr)
+		logger.Warn(msg)
+		r.recorder.Event(gw, corev1.EventTypeWarning, "InvalidTailscaleService", msg)
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonInvalid, msg)
+		return false, nil
+	}
+
+	tcd, err := r.tailnetCertDomain(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error determining DNS name base: %w", err)
+	}
+	dnsName := hostname + "." + tcd
+
+	// 3. Translate listeners and routes into the serve config for the
+	// Tailscale Service.
+	routes, err := r.routesForGateway(ctx, gw)
+	if err != nil {
+		return false, err
+	}
+	listeners, err := r.attachRoutes(ctx, gw, dnsName, routes)
+	if err != nil {
+		return false, err
+	}
+
+	svcCfg := &ipn.ServiceConfig{}
+	var tsSvcPorts []string
+	needsCerts, allNeedCerts := false, true
+	validListeners := 0
+	for _, ls := range listeners {
+		if !ls.accepted {
+			continue
+		}
+		validListeners++
+		tsSvcPorts = append(tsSvcPorts, fmt.Sprintf("tcp:%d", ls.l.Port))
+		if ls.needsCert() {
+			needsCerts = true
+		} else {
+			allNeedCerts = false
+		}
+		port := uint16(ls.l.Port)
+		switch ls.l.Protocol {
+		case gatewayv1.HTTPProtocolType, gatewayv1.HTTPSProtocolType:
+			if len(ls.handlers) == 0 {
+				continue
+			}
+			mak.Set(&svcCfg.TCP, port, &ipn.TCPPortHandler{
+				HTTP:  ls.l.Protocol == gatewayv1.HTTPProtocolType,
+				HTTPS: ls.l.Protocol == gatewayv1.HTTPSProtocolType,
+			})
+			mak.Set(&svcCfg.Web, ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(port)))), &ipn.WebServerConfig{
+				Handlers: ls.handlers,
+			})
+		case gatewayv1.TLSProtocolType, gatewayv1.TCPProtocolType:
+			if ls.backend == "" {
+				continue
+			}
+			h := &ipn.TCPPortHandler{TCPForward: ls.backend}
+			if ls.needsCert() {
+				h.TerminateTLS = dnsName
+			}
+			mak.Set(&svcCfg.TCP, port, h)
+		}
+	}
+	if validListeners == 0 {
+		setAccepted(metav1.ConditionFalse, gatewayv1.GatewayReasonListenersNotValid, "none of the Gateway's listeners are valid")
+	} else if validListeners < len(listeners) {
+		setAccepted(metav1.ConditionTrue, gatewayv1.GatewayReasonListenersNotValid, "some of the Gateway's listeners are not valid")
+	} else {
+		setAccepted(metav1.ConditionTrue, gatewayv1.GatewayReasonAccepted, "Gateway is accepted")
+	}
+
+	// 4. Ensure that TLS Secret and RBAC exists if any of the listeners
+	// terminate TLS.
+	if needsCerts {
+		if err := r.ensureCertResources(ctx, pg, dnsName, gw, "gateway"); err != nil {
+			return false, fmt.Errorf("error ensuring cert resources: %w", err)
+		}
+	} else if err := r.cleanupCertResources(ctx, pgName, serviceName); err != nil {
+		return false, fmt.Errorf("failed to clean up cert resources: %w", err)
+	}
+
+	// 5. Ensure that the serve config for the ProxyGroup contains the
+	// Tailscale Service.
+	cm, cfg, err := r.proxyGroupServeConfig(ctx, pgName)
+	if err != nil {
+		return false, fmt.Errorf("error getting ProxyGroup serve config: %w", err)
+	}
+	if cm == nil {
+		logger.Infof("no ProxyGroup serve config ConfigMap found, unable to update serve config. Ensure that ProxyGroup is healthy.")
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonPending, fmt.Sprintf("ProxyGroup %q is not ready", pgName))
+		return svcsChanged, nil
+	}
+	var gotCfg *ipn.ServiceConfig
+	if cfg.Services != nil {
+		gotCfg = cfg.Services[serviceName]
+	}
+	if !reflect.DeepEqual(gotCfg, svcCfg) {
+		logger.Infof("Updating serve config")
+		mak.Set(&cfg.Services, serviceName, svcCfg)
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return false, fmt.Errorf("error marshaling serve config: %w", err)
+		}
+		mak.Set(&cm.BinaryData, serveConfigKey, cfgBytes)
+		if err := r.Update(ctx, cm); err != nil {
+			return false, fmt.Errorf("error updating serve config: %w", err)
+		}
+	}
+
+	// 6. Ensure that the Tailscale Service exists and is up to date.
+	tags := r.defaultTags
+	if tstr, ok := gw.Annotations[AnnotationTags]; ok {
+		tags = strings.Split(tstr, ",")
+	}
+	tsSvc := &tailscale.VIPService{
+		Name:        serviceName,
+		Tags:        tags,
+		Ports:       tsSvcPorts,
+		Comment:     managedTSServiceComment,
+		Annotations: updatedAnnotations,
+	}
+	if existingTSSvc != nil {
+		tsSvc.Addrs = existingTSSvc.Addrs
+	}
+	if existingTSSvc == nil ||
+		!reflect.DeepEqual(tsSvc.Tags, existingTSSvc.Tags) ||
+		!reflect.DeepEqual(tsSvc.Ports, existingTSSvc.Ports) ||
+		!ownersAreSetAndEqual(tsSvc, existingTSSvc) {
+		logger.Infof("Ensuring Tailscale Service exists and is up to date")
+		if err := r.tsClient.CreateOrUpdateVIPService(ctx, tsSvc); err != nil {
+			return false, fmt.Errorf("error creating Tailscale Service: %w", err)
+		}
+	}
+
+	// 7. Update tailscaled's AdvertiseServices config. If all listeners
+	// terminate TLS, the Tailscale Service is only advertised once certs
+	// have been issued.
+	mode := serviceAdvertisementHTTPAndHTTPS
+	switch {
+	case validListeners == 0:
+		mode = serviceAdvertisementOff
+	case allNeedCerts:
+		mode = serviceAdvertisementHTTPS
+	}
+	if err = r.maybeUpdateAdvertiseServicesConfig(ctx, pg.Name, serviceName, mode, logger); err != nil {
+		return false, fmt.Errorf("failed to update tailscaled config: %w", err)
+	}
+
+	// 8. Update Gateway and route status.
+	count, err := r.numberPodsAdvertising(ctx, pg.Name, serviceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if any Pods are configured: %w", err)
+	}
+	hasCerts := false
+	if needsCerts {
+		if hasCerts, err = r.hasCerts(ctx, serviceName); err != nil {
+			return false, fmt.Errorf("error checking TLS credentials provisioned for Gateway: %w", err)
+		}
+	}
+	programmed := count > 0
+	if programmed {
+		setProgrammed(metav1.ConditionTrue, gatewayv1.GatewayReasonProgrammed, fmt.Sprintf("%d ProxyGroup Pod(s) advertising Tailscale Service", count))
+		gw.Status.Addresses = []gatewayv1.GatewayStatusAddress{{
+			Type:  ptr.To(gatewayv1.HostnameAddressType),
+			Value: dnsName,
+		}}
+	} else {
+		setProgrammed(metav1.ConditionFalse, gatewayv1.GatewayReasonAddressNotAssigned, "No ProxyGroup Pods are advertising the Tailscale Service yet")
+		gw.Status.Addresses = nil
+	}
+	r.setListenerStatuses(gw, listeners, programmed, hasCerts)
+	if err := r.updateRouteStatuses(ctx, gw, routes); err != nil {
+		return false, err
+	}
+	return svcsChanged, nil
+}
+
+// validateGateway validates that the Gateway is properly configured.
+// Currently validates:
+// - Any tags provided via tailscale.com/tags annotation are valid Tailscale ACL tags
+// - The derived hostname is a valid DNS label
+// - The referenced ProxyGroup is of type 'ingress'
+// - No other Gateway or HA Ingress in the cluster uses the same hostname
+func (r *HAGatewayReconciler) validateGateway(ctx context.Context, gw *gatewayv1.Gateway, pg *tsapi.ProxyGroup) error {
+	var errs []error
+	if violations := tagViolations(gw); len(violations) > 0 {
+		errs = append(errs, fmt.Errorf("Gateway contains invalid tags: %v", strings.Join(violations, ",")))
+	}
+	hostname := hostnameForGateway(gw)
+	if err := dnsname.ValidLabel(hostname); err != nil {
+		errs = append(errs, fmt.Errorf("invalid hostname %q: %w. Ensure that the hostname is a valid DNS label", hostname, err))
+	}
+	if pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+		errs = append(errs, fmt.Errorf("ProxyGroup %q is of type %q but must be of type %q",
+			pg.Name, pg.Spec.Type, tsapi.ProxyGroupTypeIngress))
+	}
+
+	gwList := &gatewayv1.GatewayList{}
+	if err := r.List(ctx, gwList); err != nil {
+		errs = append(errs, fmt.Errorf("[unexpected] error listing Gateways: %w", err))
+		return errors.Join(errs...)
+	}
+	for _, g := range gwList.Items {
+		// Hostnames are tailnet-wide, so Gateways of all classes count.
+		if g.UID != gw.UID && hostnameForGateway(&g) == hostname {
+			errs = append(errs, fmt.Errorf("found duplicate Gateway %q for hostname %q - multiple Gateways for the same hostname in the same cluster are not allowed", client.ObjectKeyFromObject(&g), hostname))
+		}
+	}
+	ingList := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingList); err != nil {
+		errs = append(errs, fmt.Errorf("[unexpected] error listing Ingresses: %w", err))
+		return errors.Join(errs...)
+	}
+	for _, i := range ingList.Items {
+		if r.shouldExpose(&i) && hostnameForIngress(&i) == hostname {
+			errs = append(errs, fmt.Errorf("found Ingress %q for hostname %q - Gateways and Ingresses can not share a hostname", client.ObjectKeyFromObject(&i), hostname))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// maybeCleanupGateway ensures that any resources, such as the Tailscale
+// Service created for this Gateway, are cleaned up when the Gateway is being
+// deleted or no longer belongs to a GatewayClass managed by the operator. As
+// for Ingresses, the Tailscale Service is only deleted if it is not owned by
+// other operator instances.
+func (r *HAGatewayReconciler) maybeCleanupGateway(ctx context.Context, hostname string, gw *gatewayv1.Gateway, logger *zap.SugaredLogger) (svcChanged bool, err error) {
+	logger.Debugf("Ensuring any resources for Gateway are cleaned up")
+	if !slices.Contains(gw.Finalizers, FinalizerNameGateway) {
+		logger.Debugf("no finalizer, nothing to do")
+		return false, nil
+	}
+	logger.Infof("Ensuring that Tailscale Service %q configuration is cleaned up", hostname)
+	serviceName := tailcfg.ServiceName("svc:" + hostname)
+	svc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if err != nil && !isErrorTailscaleServiceNotFound(err) {
+		if isErrorFeatureFlagNotEnabled(err) {
+			msg := fmt.Sprintf("Unable to proceed with cleanup: %s.", msgFeatureFlagNotEnabled)
+			logger.Warn(msg)
+			r.recorder.Event(gw, corev1.EventTypeWarning, warningTailscaleServiceFeatureFlagNotEnabled, msg)
+			return false, nil
+		}
+		return false, fmt.Errorf("error getting Tailscale Service: %w", err)
+	}
+
+	// 1. Clean up the Tailscale Service.
+	svcChanged, err = r.cleanupTailscaleService(ctx, svc, logger)
+	if err != nil {
+		return false, fmt.Errorf("error deleting Tailscale Service: %w", err)
+	}
+
+	// 2. Remove the Tailscale Service from the serve config and tailscaled
+	// config of whichever ProxyGroup serves it. The Gateway's ProxyGroup
+	// can't be relied on here, as the GatewayClass might have changed or
+	// been deleted.
+	if err := r.removeFromProxyGroups(ctx, serviceName, "", logger); err != nil {
+		return false, err
+	}
+
+	// 3. Detach the Gateway's routes.
+	routes, err := r.routesForGateway(ctx, gw)
+	if err != nil {
+		return false, err
+	}
+	for _, rt := range routes {
+		n := len(rt.status.Parents)
+		rt.status.Parents = slices.DeleteFunc(rt.status.Parents, func(ps gatewayv1.RouteParentStatus) bool {
+			return ps.ControllerName == gatewayControllerName && parentRefIsGateway(ps.ParentRef, rt.GetNamespace(), gw)
+		})
+		if len(rt.status.Parents) == n {
+			continue
+		}
+		if err := r.Status().Update(ctx, rt.Object); err != nil {
+			return false, fmt.Errorf("failed to update %s %s status: %w", rt.kind, client.ObjectKeyFromObject(rt.Object), err)
+		}
+	}
+
+	// 4. Remove the finalizer.
+	gw.Finalizers = slices.DeleteFunc(gw.Finalizers, func(f string) bool {
+		return f == FinalizerNameGateway
+	})
+	if err := r.Update(ctx, gw); err != nil {
+		return false, fmt.Errorf("failed to remove finalizer %q: %w", FinalizerNameGateway, err)
+	}
+	r.gwMu.Lock()
+	defer r.gwMu.Unlock()
+	r.managedGateways.Remove(gw.UID)
+	gaugeGatewayResources.Set(int64(r.managedGateways.Len()))
+	return svcChanged, nil
+}
+
+// removeFromProxyGroups removes the Tailscale Service from the serve config
+// and tailscaled config of all ingress ProxyGroups other than except, and
+// cleans up its cert resources for those ProxyGroups.
+func (r *HAGatewayReconciler) removeFromProxyGroups(ctx context.Context, serviceName tailcfg.ServiceName, except string, logger *zap.SugaredLogger) error {
+	pgList := &tsapi.ProxyGroupList{}
+	if err := r.List(ctx, pgList); err != nil {
+		return fmt.Errorf("error listing ProxyGroups: %w", err)
+	}
+	for _, pg := range pgList.Items {
+		if pg.Name == except || pg.Spec.Type != tsapi.ProxyGroupTypeIngress {
+			continue
+		}
+		cm, cfg, err := r.proxyGroupServeConfig(ctx, pg.Name)
+		if err != nil {
+			return fmt.Errorf("error getting ProxyGroup serve config: %w", err)
+		}
+		if cfg == nil || cfg.Services[serviceName] == nil {
+			continue
+		}
+		if err := r.maybeUpdateAdvertiseServicesConfig(ctx, pg.Name, serviceName, serviceAdvertisementOff, logger); err != nil {
+			return fmt.Errorf("failed to update tailscaled config services: %w", err)
+		}
+		if err := r.cleanupCertResources(ctx, pg.Name, serviceName); err != nil {
+			return fmt.Errorf("failed to clean up cert resources: %w", err)
+		}
+		logger.Infof("Removing Tailscale Service %q from serve config for ProxyGroup %q", serviceName, pg.Name)
+		delete(cfg.Services, serviceName)
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return fmt.Errorf("error marshaling serve config: %w", err)
+		}
+		mak.Set(&cm.BinaryData, serveConfigKey, cfgBytes)
+		if err := r.Update(ctx, cm); err != nil {
+			return fmt.Errorf("error updating serve config: %w", err)
+		}
+	}
+	return nil
+}
+
+// hostnameForGateway returns the hostname for a Gateway resource: the value
+// of its tailscale.com/hostname annotation if set, otherwise a hostname
+// derived from the Gateway namespace and name with namespacedName.
+func hostnameForGateway(gw *gatewayv1.Gateway) string {
+	if h := gw.Annotations[AnnotationHostname]; h != "" {
+		return h
+	}
+	return namespacedName(gw.Namespace, gw.Name) + "-gateway"
+}
+
+// gatewayHostnames returns the hostnames of all Gateways in the cluster. It
+// returns an empty set if the Gateway API CRDs are not installed.
+func gatewayHostnames(ctx context.Context, cl client.Client) (set.Set[string], error) {
+	hostnames := make(set.Set[string])
+	gwList := &gatewayv1.GatewayList{}
+	if err := cl.List(ctx, gwList); err != nil {
+		if apimeta.IsNoMatchError(err) {
+			return hostnames, nil
+		}
+		return nil, fmt.Errorf("listing Gateways: %w", err)
+	}
+	for _, gw := range gwList.Items {
+		hostnames.Add(hostnameForGateway(&gw))
+	}
+	return hostnames, nil
+}
+
+// gatewayRoute is an HTTPRoute, TLSRoute or TCPRoute.
+type gatewayRoute struct {
+	client.Object
+	kind       gatewayv1.Kind
+	parentRefs []gatewayv1.ParentReference
+	hostnames  []gatewayv1.Hostname
+	status     *gatewayv1.RouteStatus
+
+	httpRules []gatewayv1.HTTPRouteRule // only for HTTPRoutes
+	backends  [][]gatewayv1.BackendRef  // backends of each rule of TLSRoutes and TCPRoutes
+
+	// results are the results of attaching the route to each of its parent
+	// references to the Gateway being reconciled.
+	results []routeParentResult
+}
+
+// routesForGateway returns all routes with a parent reference to the
+// Gateway, oldest first. TLSRoutes and TCPRoutes are only part of the
+// experimental Gateway API channel and are skipped if their CRDs are not
+// installed.
+func (r *HAGatewayReconciler) routesForGateway(ctx context.Context, gw *gatewayv1.Gateway) ([]*gatewayRoute, error) {
+	var routes []*gatewayRoute
+	httpRoutes := &gatewayv1.HTTPRouteList{}
+	if err := r.List(ctx, httpRoutes); err != nil && !apimeta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("error listing HTTPRoutes: %w", err)
+	}
+	for i := range httpRoutes.Items {
+		rt := &httpRoutes.Items[i]
+		routes = append(routes, &gatewayRoute{
+			Object:     rt,
+			kind:       kindHTTPRoute,
+			parentRefs: rt.Spec.ParentRefs,
+			hostnames:  rt.Spec.Hostnames,
+			status:     &rt.Status.RouteStatus,
+			httpRules:  rt.Spec.Rules,
+		})
+	}
+	tlsRoutes := &gatewayv1alpha2.TLSRouteList{}
+	if err := r.List(ctx, tlsRoutes); err != nil && !apimeta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("error listing TLSRoutes: %w", err)
+	}
+	for i := range tlsRoutes.Items {
+		rt := &tlsRoutes.Items[i]
+		gr := &gatewayRoute{
+			Object:     rt,
+			kind:       kindTLSRoute,
+			parentRefs: rt.Spec.ParentRefs,
+			hostnames:  rt.Spec.Hostnames,
+			status:     &rt.Status.RouteStatus,
+		}
+		for _, rule := range rt.Spec.Rules {
+			gr.backends = append(gr.backends, rule.BackendRefs)
+		}
+		routes = append(routes, gr)
+	}
+	tcpRoutes := &gatewayv1alpha2.TCPRouteList{}
+	if err := r.List(ctx, tcpRoutes); err != nil && !apimeta.IsNoMatchError(err) {
+		return nil, fmt.Errorf("error listing TCPRoutes: %w", err)
+	}
+	for i := range tcpRoutes.Items {
+		rt := &tcpRoutes.Items[i]
+		gr := &gatewayRoute{
+			Object:     rt,
+			kind:       kindTCPRoute,
+			parentRefs: rt.Spec.ParentRefs,
+			status:     &rt.Status.RouteStatus,
+		}
+		for _, rule := range rt.Spec.Rules {
+			gr.backends = append(gr.backends, rule.BackendRefs)
+		}
+		routes = append(routes, gr)
+	}
+
+	routes = slices.DeleteFunc(routes, func(rt *gatewayRoute) bool {
+		return !slices.ContainsFunc(rt.parentRefs, func(ref gatewayv1.ParentReference) bool {
+			return parentRefIsGateway(ref, rt.GetNamespace(), gw)
+		}) && !slices.ContainsFunc(rt.status.Parents, func(ps gatewayv1.RouteParentStatus) bool {
+			return ps.ControllerName == gatewayControllerName && parentRefIsGateway(ps.ParentRef, rt.GetNamespace(), gw)
+		})
+	})
+	slices.SortStableFunc(routes, func(a, b *gatewayRoute) int {
+		if c := a.GetCreationTimestamp().Compare(b.GetCreationTimestamp().Time); c != 0 {
+			return c
+		}
+		return strings.Compare(client.ObjectKeyFromObject(a).String(), client.ObjectKeyFromObject(b).String())
+	})
+	return routes, nil
+}
+
+// parentRefIsGateway reports whether the parent reference of a route in
+// namespace routeNS refers to the Gateway.
+func parentRefIsGateway(ref gatewayv1.ParentReference, routeNS string, gw *gatewayv1.Gateway) bool {
+	if ref.Group != nil && *ref.Group != gatewayv1.GroupName {
+		return false
+	}
+	if ref.Kind != nil && *ref.Kind != kindGateway {
+		return false
+	}
+	ns := routeNS
+	if ref.Namespace != nil {
+		ns = string(*ref.Namespace)
+	}
+	return ns == gw.Namespace && string(ref.Name) == gw.Name
+}
+
+// listenerState is the result of translating a Gateway listener and the
+// routes attached to it.
+type listenerState struct {
+	l *gatewayv1.Listener
+
+	accepted       bool
+	acceptedReason gatewayv1.ListenerConditionReason
+	acceptedMsg    string
+	conflicted     bool
+	resolvedReason gatewayv1.ListenerConditionReason
+	resolvedMsg    string
+	supportedKinds []gatewayv1.RouteGroupKind
+	attachedRoutes int32
+
+	handlers map[string]*ipn.HTTPHandler // for HTTP and HTTPS listeners
+	backend  string                      // host:port, for TLS and TCP listeners
+}
+
+// needsCert reports whether the listener terminates TLS.
+func (ls *listenerState) needsCert() bool {
+	switch ls.l.Protocol {
+	case gatewayv1.HTTPSProtocolType:
+		return true
+	case gatewayv1.TLSProtocolType:
+		return ls.l.TLS == nil || ls.l.TLS.Mode == nil || *ls.l.TLS.Mode == gatewayv1.TLSModeTerminate
+	}
+	return false
+}
+
+// routeKindsForProtocol returns the route kinds that can be attached to
+// listeners with the given protocol.
+func routeKindsForProtocol(p gatewayv1.ProtocolType) []gatewayv1.Kind {
+	switch p {
+	case gatewayv1.HTTPProtocolType, gatewayv1.HTTPSProtocolType:
+		return []gatewayv1.Kind{kindHTTPRoute}
+	case gatewayv1.TLSProtocolType:
+		return []gatewayv1.Kind{kindTLSRoute}
+	case gatewayv1.TCPProtocolType:
+		return []gatewayv1.Kind{kindTCPRoute}
+	}
+	return nil
+}
+
+// newListenerState validates the listener of a Gateway with the given DNS
+// name. Listeners on ports already used by previous listeners are
+// conflicted.
+func newListenerState(l *gatewayv1.Listener, dnsName string, usedPorts set.Set[gatewayv1.PortNumber]) *listenerState {
+	ls := &listenerState{
+		l:              l,
+		accepted:       true,
+		acceptedReason: gatewayv1.ListenerReasonAccepted,
+		acceptedMsg:    "Listener is accepted",
+		resolvedReason: gatewayv1.ListenerReasonResolvedRefs,
+		resolvedMsg:    "All references are resolved",
+	}
+	kinds := routeKindsForProtocol(l.Protocol)
+	if l.AllowedRoutes != nil && len(l.AllowedRoutes.Kinds) > 0 {
+		var allowed []gatewayv1.Kind
+		for _, k := range l.AllowedRoutes.Kinds {
+			if (k.Group == nil || *k.Group == gatewayv1.GroupName) && slices.Contains(kinds, k.Kind) {
+				allowed = append(allowed, k.Kind)
+			} else {
+				ls.resolvedReason = gatewayv1.ListenerReasonInvalidRouteKinds
+				ls.resolvedMsg = fmt.Sprintf("route kind %q is not supported for protocol %s", k.Kind, l.Protocol)
+			}
+		}
+		kinds = allowed
+	}
+	ls.supportedKinds = []gatewayv1.RouteGroupKind{}
+	for _, k := range kinds {
+		ls.supportedKinds = append(ls.supportedKinds, gatewayv1.RouteGroupKind{
+			Group: ptr.To(gatewayv1.Group(gatewayv1.GroupName)),
+			Kind:  k,
+		})
+	}
+
+	reject := func(reason gatewayv1.ListenerConditionReason, format string, args ...any) {
+		ls.accepted = false
+		ls.acceptedReason = reason
+		ls.acceptedMsg = fmt.Sprintf(format, args...)
+	}
+	switch {
+	case routeKindsForProtocol(l.Protocol) == nil:
+		reject(gatewayv1.ListenerReasonUnsupportedProtocol, "protocol %s is not supported", l.Protocol)
+	case l.Protocol == gatewayv1.HTTPSProtocolType && l.TLS != nil && l.TLS.Mode != nil && *l.TLS.Mode != gatewayv1.TLSModeTerminate:
+		reject(gatewayv1.ListenerReasonUnsupportedProtocol, "TLS mode %s is not supported for protocol HTTPS", *l.TLS.Mode)
+	case l.Hostname != nil && !hostnameMatches(*l.Hostname, dnsName):
+		reject(reasonUnsupportedHostname, "listener hostname %q does not match the Gateway's MagicDNS name %q", *l.Hostname, dnsName)
+	case usedPorts.Contains(l.Port):
+		ls.conflicted = true
+		reject(gatewayv1.ListenerReasonPortUnavailable, "port %d is already used by another listener", l.Port)
+	}
+	usedPorts.Add(l.Port)
+	return ls
+}
+
+// hostnameMatches reports whether the DNS name matches the hostname, which
+// may be a wildcard.
+func hostnameMatches(h gatewayv1.Hostname, dnsName string) bool {
+	if suffix, ok := strings.CutPrefix(string(h), "*"); ok {
+		return strings.HasSuffix(dnsName, suffix)
+	}
+	return string(h) == dnsName
+}
+
+// routeParentResult is the result of attaching a route to one of its
+// parents.
+type routeParentResult struct {
+	ref            gatewayv1.ParentReference
+	accepted       bool
+	acceptedReason gatewayv1.RouteConditionReason
+	acceptedMsg    string
+	resolvedReason gatewayv1.RouteConditionReason
+	resolvedMsg    string
+}
+
+// attachRoutes validates the Gateway's listeners and attaches the routes to
+// them. The result for each of a route's parent references to the Gateway is
+// recorded in the route's results.
+func (r *HAGatewayReconciler) attachRoutes(ctx context.Context, gw *gatewayv1.Gateway, dnsName string, routes []*gatewayRoute) ([]*listenerState, error) {
+	usedPorts := make(set.Set[gatewayv1.PortNumber])
+	var listeners []*listenerState
+	for i := range gw.Spec.Listeners {
+		listeners = append(listeners, newListenerState(&gw.Spec.Listeners[i], dnsName, usedPorts))
+	}
+
+	for _, rt := range routes {
+		tr, err := r.translateRoute(ctx, rt)
+		if err != nil {
+			return nil, err
+		}
+		rt.results = nil
+		for _, ref := range rt.parentRefs {
+			if !parentRefIsGateway(ref, rt.GetNamespace(), gw) {
+				continue
+			}
+			res := routeParentResult{
+				ref:            ref,
+				acceptedReason: gatewayv1.RouteReasonNoMatchingParent,
+				acceptedMsg:    "no listener matches the parent reference",
+				resolvedReason: gatewayv1.RouteReasonResolvedRefs,
+				resolvedMsg:    "All references are resolved",
+			}
+			if tr.unresolvedMsg != "" {
+				res.resolvedReason, res.resolvedMsg = tr.unresolvedReason, tr.unresolvedMsg
+			}
+			if tr.unsupportedMsg != "" {
+				res.acceptedReason, res.acceptedMsg = gatewayv1.RouteReasonUnsupportedValue, tr.unsupportedMsg
+				rt.results = append(rt.results, res)
+				continue
+			}
+			for _, ls := range listeners {
+				if !ls.accepted ||
+					(ref.SectionName != nil && *ref.SectionName != ls.l.Name) ||
+					(ref.Port != nil && *ref.Port != ls.l.Port) {
+					continue
+				}
+				allowed, err := r.routeAllowed(ctx, gw, ls, rt)
+				if err != nil {
+					return nil, err
+				}
+				if !allowed {
+					res.acceptedReason = gatewayv1.RouteReasonNotAllowedByListeners
+					res.acceptedMsg = "route is not allowed by the listener's allowedRoutes"
+					continue
+				}
+				if len(rt.hostnames) > 0 && !slices.ContainsFunc(rt.hostnames, func(h gatewayv1.Hostname) bool {
+					return hostnameMatches(h, dnsName)
+				}) {
+					res.acceptedReason = gatewayv1.RouteReasonNoMatchingListenerHostname
+					res.acceptedMsg = fmt.Sprintf("none of the route's hostnames match the Gateway's MagicDNS name %q", dnsName)
+					continue
+				}
+				if rt.kind != kindHTTPRoute && ls.attachedRoutes > 0 {
+					res.acceptedReason = gatewayv1.RouteReasonNotAllowedByListeners
+					res.acceptedMsg = fmt.Sprintf("listener %q already has a %s attached", ls.l.Name, rt.kind)
+					continue
+				}
+				res.accepted = true
+				res.acceptedReason = gatewayv1.RouteReasonAccepted
+				res.acceptedMsg = "Route is accepted"
+				ls.attachedRoutes++
+				if rt.kind == kindHTTPRoute {
+					for _, p := range tr.paths {
+						if _, ok := ls.handlers[p]; !ok {
+							mak.Set(&ls.handlers, p, tr.handlers[p])
+						}
+					}
+				} else {
+					ls.backend = tr.backend
+				}
+			}
+			rt.results = append(rt.results, res)
+		}
+	}
+	return listeners, nil
+}
+
+// routeAllowed reports whether the listener's allowedRoutes allow the route
+// to be attached.
+func (r *HAGatewayReconciler) routeAllowed(ctx context.Context, gw *gatewayv1.Gateway, ls *listenerState, rt *gatewayRoute) (bool, error) {
+	if !slices.ContainsFunc(ls.supportedKinds, func(k gatewayv1.RouteGroupKind) bool { return k.Kind == rt.kind }) {
+		return false, nil
+	}
+	from := gatewayv1.NamespacesFromSame
+	var selector *metav1.LabelSelector
+	if ar := ls.l.AllowedRoutes; ar != nil && ar.Namespaces != nil {
+		if ar.Namespaces.From != nil {
+			from = *ar.Namespaces.From
+		}
+		selector = ar.Namespaces.Selector
+	}
+	switch from {
+	case gatewayv1.NamespacesFromAll:
+		return true, nil
+	case gatewayv1.NamespacesFromSame:
+		return rt.GetNamespace() == gw.Namespace, nil
+	case gatewayv1.NamespacesFromSelector:
+		if selector == nil {
+			return false, nil
+		}
+		sel, err := metav1.LabelSelectorAsSelector(selector)
+		if err != nil {
+			return false, nil
+		}
+		ns := &corev1.Namespace{}
+		if err := r.Get(ctx, client.ObjectKey{Name: rt.GetNamespace()}, ns); err != nil {
+			return false, fmt.Errorf("error getting Namespace %q: %w", rt.GetNamespace(), err)
+		}
+		return sel.Matches(klabels.Set(ns.Labels)), nil
+	}
+	return false, nil
+}
+
+// translatedRoute is a route translated into serve config.
+type translatedRoute struct {
+	// unsupportedMsg, if non-empty, explains why the route uses features
+	// that are not supported. Such routes are not attached.
+	unsupportedMsg string
+	// unresolvedMsg, if non-empty, explains why some of the route's backends
+	// could not be resolved. Rules with such backends are skipped.
+	unresolvedReason gatewayv1.RouteConditionReason
+	unresolvedMsg    string
+
+	paths    []string // in order of precedence, for HTTPRoutes
+	handlers map[string]*ipn.HTTPHandler
+	backend  string // host:port, for TLSRoutes and TCPRoutes
+}
+
+// translateRoute translates the rules of the route into serve config
+// handlers. Only a subset of the Gateway API is supported: HTTPRoute rules
+// must have PathPrefix matches only, no filters and a single backend, and
+// TLSRoutes and TCPRoutes must have a single rule with a single backend.
+func (r *HAGatewayReconciler) translateRoute(ctx context.Context, rt *gatewayRoute) (*translatedRoute, error) {
+	tr := &translatedRoute{}
+	unresolved := func(reason gatewayv1.RouteConditionReason, msg string) {
+		if tr.unresolvedMsg == "" {
+			tr.unresolvedReason, tr.unresolvedMsg = reason, msg
+		}
+	}
+	if rt.kind != kindHTTPRoute {
+		if len(rt.backends) != 1 || len(rt.backends[0]) != 1 {
+			tr.unsupportedMsg = fmt.Sprintf("%s must have exactly one rule with exactly one backendRef", rt.kind)
+			return tr, nil
+		}
+		svc, port, reason, msg, err := r.resolveBackend(ctx, rt.GetNamespace(), rt.backends[0][0].BackendObjectReference)
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			unresolved(reason, msg)
+			return tr, nil
+		}
+		tr.backend = net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(port)))
+		return tr, nil
+	}
+
+	for i, rule := range rt.httpRules {
+		if len(rule.Filters) > 0 {
+			tr.unsupportedMsg = fmt.Sprintf("rule %d: filters are not supported", i)
+			return tr, nil
+		}
+		if len(rule.BackendRefs) != 1 {
+			tr.unsupportedMsg = fmt.Sprintf("rule %d: exactly one backendRef is supported", i)
+			return tr, nil
+		}
+		if len(rule.BackendRefs[0].Filters) > 0 {
+			tr.unsupportedMsg = fmt.Sprintf("rule %d: backendRef filters are not supported", i)
+			return tr, nil
+		}
+		var paths []string
+		for _, m := range rule.Matches {
+			if len(m.Headers) > 0 || len(m.QueryParams) > 0 || m.Method != nil {
+				tr.unsupportedMsg = fmt.Sprintf("rule %d: only path matches are supported", i)
+				return tr, nil
+			}
+			p := "/"
+			if m.Path != nil {
+				if m.Path.Type != nil && *m.Path.Type != gatewayv1.PathMatchPathPrefix {
+					tr.unsupportedMsg = fmt.Sprintf("rule %d: path match type %s is not supported, only PathPrefix is", i, *m.Path.Type)
+					return tr, nil
+				}
+				if m.Path.Value != nil {
+					p = *m.Path.Value
+				}
+			}
+			paths = append(paths, p)
+		}
+		if len(paths) == 0 {
+			paths = []string{"/"}
+		}
+
+		ref := rule.BackendRefs[0].BackendObjectReference
+		svc, port, reason, msg, err := r.resolveBackend(ctx, rt.GetNamespace(), ref)
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			unresolved(reason, msg)
+			continue
+		}
+		proto := "http://"
+		if port == 443 || slices.ContainsFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool {
+			return p.Port == port && p.Name == "https"
+		}) {
+			proto = "https+insecure://"
+		}
+		for _, p := range paths {
+			if _, ok := tr.handlers[p]; ok {
+				continue
+			}
+			tr.paths = append(tr.paths, p)
+			mak.Set(&tr.handlers, p, &ipn.HTTPHandler{
+				Proxy: proto + net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(port))) + p,
+			})
+		}
+	}
+	return tr, nil
+}
+
+// resolveBackend returns the Service and port that the backend reference
+// of a route in namespace ns refers to. If the reference can not be
+// resolved, it returns the reason and a message explaining why.
+func (r *HAGatewayReconciler) resolveBackend(ctx context.Context, ns string, ref gatewayv1.BackendObjectReference) (_ *corev1.Service, port int32, reason gatewayv1.RouteConditionReason, msg string, _ error) {
+	if (ref.Group != nil && *ref.Group != "") || (ref.Kind != nil && *ref.Kind != "Service") {
+		return nil, 0, gatewayv1.RouteReasonInvalidKind, fmt.Sprintf("backend %q must be a Service", ref.Name), nil
+	}
+	if ref.Namespace != nil && string(*ref.Namespace) != ns {
+		return nil, 0, gatewayv1.RouteReasonRefNotPermitted, fmt.Sprintf("backend %q must be in the route's namespace", ref.Name), nil
+	}
+	if ref.Port == nil {
+		return nil, 0, gatewayv1.RouteReasonUnsupportedProtocol, fmt.Sprintf("backend %q must set a port", ref.Name), nil
+	}
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: ns, Name: string(ref.Name)}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, 0, gatewayv1.RouteReasonBackendNotFound, fmt.Sprintf("Service %q not found", ref.Name), nil
+		}
+		return nil, 0, "", "", fmt.Errorf("error getting Service %q: %w", ref.Name, err)
+	}
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		return nil, 0, gatewayv1.RouteReasonBackendNotFound, fmt.Sprintf("Service %q has no ClusterIP", ref.Name), nil
+	}
+	return svc, int32(*ref.Port), "", "", nil
+}
+
+// setGatewayCondition sets the condition on the Gateway's status.
+func setGatewayCondition(gw *gatewayv1.Gateway, typ gatewayv1.GatewayConditionType, status metav1.ConditionStatus, reason, msg string) {
+	apimeta.SetStatusCondition(&gw.Status.Conditions, metav1.Condition{
+		Type:               string(typ),
+		Status:             status,
+		Reason:             reason,
+		Message:            msg,
+		ObservedGeneration: gw.Generation,
+	})
+}
+
+// setListenerStatuses sets the status of each of the Gateway's listeners.
+// Listeners are programmed once the Gateway is and, if they terminate TLS,
+// certs have been issued.
+func (r *HAGatewayReconciler) setListenerStatuses(gw *gatewayv1.Gateway, listeners []*listenerState, gwProgrammed, hasCerts bool) {
+	var statuses []gatewayv1.ListenerStatus
+	for _, ls := range listeners {
+		st := gatewayv1.ListenerStatus{
+			Name:           ls.l.Name,
+			SupportedKinds: ls.supportedKinds,
+			AttachedRoutes: ls.attachedRoutes,
+		}
+		if i := slices.IndexFunc(gw.Status.Listeners, func(s gatewayv1.ListenerStatus) bool { return s.Name == ls.l.Name }); i >= 0 {
+			st.Conditions = gw.Status.Listeners[i].Conditions
+		}
+		setCond := func(typ gatewayv1.ListenerConditionType, ok bool, reason gatewayv1.ListenerConditionReason, msg string) {
+			status := metav1.ConditionFalse
+			if ok {
+				status = metav1.ConditionTrue
+			}
+			apimeta.SetStatusCondition(&st.Conditions, metav1.Condition{
+				Type:               string(typ),
+				Status:             status,
+				Reason:             string(reason),
+				Message:            msg,
+				ObservedGeneration: gw.Generation,
+			})
+		}
+		setCond(gatewayv1.ListenerConditionAccepted, ls.accepted, ls.acceptedReason, ls.acceptedMsg)
+		setCond(gatewayv1.ListenerConditionResolvedRefs, ls.resolvedReason == gatewayv1.ListenerReasonResolvedRefs, ls.resolvedReason, ls.resolvedMsg)
+		if ls.conflicted {
+			setCond(gatewayv1.ListenerConditionConflicted, true, gatewayv1.ListenerReasonProtocolConflict, ls.acceptedMsg)
+		} else {
+			setCond(gatewayv1.ListenerConditionConflicted, false, gatewayv1.ListenerReasonNoConflicts, "No conflicts")
+		}
+		switch {
+		case !ls.accepted:
+			setCond(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonInvalid, "Listener is not accepted")
+		case !gwProgrammed:
+			setCond(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonPending, "Gateway is not programmed yet")
+		case ls.needsCert() && !hasCerts:
+			setCond(gatewayv1.ListenerConditionProgrammed, false, gatewayv1.ListenerReasonPending, "Waiting for TLS certificate to be issued")
+		default:
+			setCond(gatewayv1.ListenerConditionProgrammed, true, gatewayv1.ListenerReasonProgrammed, "Listener is programmed")
+		}
+		statuses = append(statuses, st)
+	}
+	gw.Status.Listeners = statuses
+}
+
+// updateRouteStatuses updates the status of each route for its parent
+// references to the Gateway, and removes the status for any parent
+// references to the Gateway that the route no longer has.
+func (r *HAGatewayReconciler) updateRouteStatuses(ctx context.Context, gw *gatewayv1.Gateway, routes []*gatewayRoute) error {
+	for _, rt := range routes {
+		oldStatus := rt.status.DeepCopy()
+		rt.status.Parents = slices.DeleteFunc(rt.status.Parents, func(ps gatewayv1.RouteParentStatus) bool {
+			return ps.ControllerName == gatewayControllerName &&
+				parentRefIsGateway(ps.ParentRef, rt.GetNamespace(), gw) &&
+				!slices.ContainsFunc(rt.results, func(res routeParentResult) bool {
+					return apiequality.Semantic.DeepEqual(res.ref, ps.ParentRef)
+				})
+		})
+		for _, res := range rt.results {
+			i := slices.IndexFunc(rt.status.Parents, func(ps gatewayv1.RouteParentStatus) bool {
+				return ps.ControllerName == gatewayControllerName && apiequality.Semantic.DeepEqual(res.ref, ps.ParentRef)
+			})
+			if i < 0 {
+				rt.status.Parents = append(rt.status.Parents, gatewayv1.RouteParentStatus{
+					ParentRef:      res.ref,
+					ControllerName: gatewayControllerName,
+				})
+				i = len(rt.status.Parents) - 1
+			}
+			ps := &rt.status.Parents[i]
+			accepted := metav1.ConditionFalse
+			if res.accepted {
+				accepted = metav1.ConditionTrue
+			}
+			apimeta.SetStatusCondition(&ps.Conditions, metav1.Condition{
+				Type:               string(gatewayv1.RouteConditionAccepted),
+				Status:             accepted,
+				Reason:             string(res.acceptedReason),
+				Message:            res.acceptedMsg,
+				ObservedGeneration: rt.GetGeneration(),
+			})
+			resolved := metav1.ConditionFalse
+			if res.resolvedReason == gatewayv1.RouteReasonResolvedRefs {
+				resolved = metav1.ConditionTrue
+			}
+			apimeta.SetStatusCondition(&ps.Conditions, metav1.Condition{
+				Type:               string(gatewayv1.RouteConditionResolvedRefs),
+				Status:             resolved,
+				Reason:             string(res.resolvedReason),
+				Message:            res.resolvedMsg,
+				ObservedGeneration: rt.GetGeneration(),
+			})
+		}
+		if apiequality.Semantic.DeepEqual(oldStatus, rt.status) {
+			continue
+		}
+		if err := r.Status().Update(ctx, rt.Object); err != nil {
+			return fmt.Errorf("failed to update %s %s status: %w", rt.kind, client.ObjectKeyFromObject(rt.Object), err)
+		}
+	}
+	return nil
+}
+
+// gatewaysFromRoute returns a handler that returns reconcile requests for all
+// Gateways that a route refers to, or that have previously managed the
+// route's status.
+func gatewaysFromRoute(logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		var refs []gatewayv1.ParentReference
+		var status *gatewayv1.RouteStatus
+		switch rt := o.(type) {
+		case *gatewayv1.HTTPRoute:
+			refs, status = rt.Spec.ParentRefs, &rt.Status.RouteStatus
+		case *gatewayv1alpha2.TLSRoute:
+			refs, status = rt.Spec.ParentRefs, &rt.Status.RouteStatus
+		case *gatewayv1alpha2.TCPRoute:
+			refs, status = rt.Spec.ParentRefs, &rt.Status.RouteStatus
+		default:
+			logger.Infof("[unexpected] route handler triggered for an object that is not a route")
+			return nil
+		}
+		for _, ps := range status.Parents {
+			if ps.ControllerName == gatewayControllerName {
+				refs = append(refs, ps.ParentRef)
+			}
+		}
+		var reqs []reconcile.Request
+		for _, ref := range refs {
+			if (ref.Group != nil && *ref.Group != gatewayv1.GroupName) || (ref.Kind != nil && *ref.Kind != kindGateway) {
+				continue
+			}
+			nn := types.NamespacedName{Namespace: o.GetNamespace(), Name: string(ref.Name)}
+			if ref.Namespace != nil {
+				nn.Namespace = string(*ref.Namespace)
+			}
+			if !slices.ContainsFunc(reqs, func(r reconcile.Request) bool { return r.NamespacedName == nn }) {
+				reqs = append(reqs, reconcile.Request{NamespacedName: nn})
+			}
+		}
+		return reqs
+	}
+}
+
+// allGateways returns a handler that returns reconcile requests for all
+// Gateways. It is used for events on resources, such as GatewayClasses and
+// ProxyGroups, that may affect any Gateway.
+func allGateways(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		gwList := &gatewayv1.GatewayList{}
+		if err := cl.List(ctx, gwList); err != nil {
+			logger.Infof("error listing Gateways: %v", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(gwList.Items))
+		for _, gw := range gwList.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gw)})
+		}
+		return reqs
+	}
+}
+
+// gatewaysFromSecret returns a handler that returns reconcile requests for
+// Gateways that should be reconciled in response to a Secret event: the
+// Gateway for which a TLS Secret was created, or all Gateways if a ProxyGroup
+// state Secret changed.
+func gatewaysFromSecret(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	all := allGateways(cl, logger)
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		secret, ok := o.(*corev1.Secret)
+		if !ok {
+			logger.Infof("[unexpected] Secret handler triggered for an object that is not a Secret")
+			return nil
+		}
+		if isTLSSecret(secret) && secret.Labels[LabelParentType] == "gateway" {
+			return []reconcile.Request{
+				{
+					NamespacedName: types.NamespacedName{
+						Namespace: secret.Labels[LabelParentNamespace],
+						Name:      secret.Labels[LabelParentName],
+					},
+				},
+			}
+		}
+		if isPGStateSecret(secret) {
+			return all(ctx, o)
+		}
+		return nil
+	}
+}
+
+// gatewaysFromService returns a handler that returns reconcile requests for
+// the Gateways of all routes that have the Service as a backend.
+func gatewaysFromService(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	fromRoute := gatewaysFromRoute(logger)
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		isBackend := func(refs []gatewayv1.BackendRef) bool {
+			return slices.ContainsFunc(refs, func(ref gatewayv1.BackendRef) bool {
+				return string(ref.Name) == o.GetName() &&
+					(ref.Namespace == nil || string(*ref.Namespace) == o.GetNamespace())
+			})
+		}
+		var reqs []reconcile.Request
+		httpRoutes := &gatewayv1.HTTPRouteList{}
+		if err := cl.List(ctx, httpRoutes, client.InNamespace(o.GetNamespace())); err != nil {
+			logger.Infof("error listing HTTPRoutes: %v", err)
+		}
+		for _, rt := range httpRoutes.Items {
+			for _, rule := range rt.Spec.Rules {
+				if slices.ContainsFunc(rule.BackendRefs, func(ref gatewayv1.HTTPBackendRef) bool { return isBackend([]gatewayv1.BackendRef{ref.BackendRef}) }) {
+					reqs = append(reqs, fromRoute(ctx, &rt)...)
+					break
+				}
+			}
+		}
+		tlsRoutes := &gatewayv1alpha2.TLSRouteList{}
+		if err := cl.List(ctx, tlsRoutes, client.InNamespace(o.GetNamespace())); err != nil && !apimeta.IsNoMatchError(err) {
+			logger.Infof("error listing TLSRoutes: %v", err)
+		}
+		for _, rt := range tlsRoutes.Items {
+			if slices.ContainsFunc(rt.Spec.Rules, func(rule gatewayv1alpha2.TLSRouteRule) bool { return isBackend(rule.BackendRefs) }) {
+				reqs = append(reqs, fromRoute(ctx, &rt)...)
+			}
+		}
+		tcpRoutes := &gatewayv1alpha2.TCPRouteList{}
+		if err := cl.List(ctx, tcpRoutes, client.InNamespace(o.GetNamespace())); err != nil && !apimeta.IsNoMatchError(err) {
+			logger.Infof("error listing TCPRoutes: %v", err)
+		}
+		for _, rt := range tcpRoutes.Items {
+			if slices.ContainsFunc(rt.Spec.Rules, func(rule gatewayv1alpha2.TCPRouteRule) bool { return isBackend(rule.BackendRefs) }) {
+				reqs = append(reqs, fromRoute(ctx, &rt)...)
+			}
+		}
+		return reqs
+	}
+}
+
+// gatewayClassesFromProxyGroup returns a handler that returns reconcile
+// requests for all GatewayClasses managed by the operator, so that their
+// parameters are revalidated when a ProxyGroup changes.
+func gatewayClassesFromProxyGroup(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		gcList := &gatewayv1.GatewayClassList{}
+		if err := cl.List(ctx, gcList); err != nil {
+			logger.Infof("error listing GatewayClasses: %v", err)
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, gc := range gcList.Items {
+			if gc.Spec.ControllerName == gatewayControllerName {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&gc)})
+			}
+		}
+		return reqs
+	}
+}
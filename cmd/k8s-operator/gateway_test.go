@@ -0,0 +1,559 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+func TestGatewayClassReconciler(t *testing.T) {
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithStatusSubresource(&tsapi.ProxyGroup{}, &gatewayv1.GatewayClass{}).
+		Build()
+	createPGResources(t, fc, "test-pg")
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &GatewayClassReconciler{Client: fc, logger: zl.Sugar()}
+
+	gc := &gatewayv1.GatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "tailscale", Generation: 1},
+		Spec: gatewayv1.GatewayClassSpec{
+			ControllerName: gatewayControllerName,
+			ParametersRef: &gatewayv1.ParametersReference{
+				Group: "tailscale.com",
+				Kind:  "ProxyGroup",
+				Name:  "test-pg",
+			},
+		},
+	}
+	mustCreate(t, fc, gc)
+	expectReconciled(t, r, "", "tailscale")
+	expectCondition(t, fc, gc, string(gatewayv1.GatewayClassConditionStatusAccepted), metav1.ConditionTrue, string(gatewayv1.GatewayClassReasonAccepted))
+
+	// A GatewayClass that refers to a ProxyGroup that does not exist is not
+	// accepted.
+	mustUpdate(t, fc, "", "tailscale", func(gc *gatewayv1.GatewayClass) {
+		gc.Spec.ParametersRef.Name = "missing-pg"
+	})
+	expectReconciled(t, r, "", "tailscale")
+	expectCondition(t, fc, gc, string(gatewayv1.GatewayClassConditionStatusAccepted), metav1.ConditionFalse, string(gatewayv1.GatewayClassReasonInvalidParameters))
+
+	// GatewayClasses of other controllers are left alone.
+	other := &gatewayv1.GatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Spec:       gatewayv1.GatewayClassSpec{ControllerName: "example.com/gateway-controller"},
+	}
+	mustCreate(t, fc, other)
+	expectReconciled(t, r, "", "other")
+	if err := fc.Get(context.Background(), client.ObjectKeyFromObject(other), other); err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Status.Conditions) != 0 {
+		t.Errorf("unexpected conditions on GatewayClass of another controller: %v", other.Status.Conditions)
+	}
+}
+
+func TestGatewayHTTPRoutes(t *testing.T) {
+	r, fc, ft := setupGatewayTest(t)
+
+	gw := testGateway(
+		gatewayv1.Listener{Name: "https", Port: 443, Protocol: gatewayv1.HTTPSProtocolType},
+		gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType},
+	)
+	mustCreate(t, fc, gw)
+	mustCreate(t, fc, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Rules: []gatewayv1.HTTPRouteRule{
+				{
+					Matches: []gatewayv1.HTTPRouteMatch{{
+						Path: &gatewayv1.HTTPPathMatch{
+							Type:  ptr.To(gatewayv1.PathMatchPathPrefix),
+							Value: ptr.To("/api"),
+						},
+					}},
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef("api", 8080)}},
+				},
+				{
+					BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef("web", 443)}},
+				},
+			},
+		},
+	})
+
+	expectReconciled(t, r, "default", "test-gw")
+	verifyTailscaleService(t, ft, "svc:my-gw", []string{"tcp:443", "tcp:80"})
+	// Only port 80 does not need certs, so the Tailscale Service is
+	// advertised straight away.
+	verifyTailscaledConfig(t, fc, "test-pg", []string{"svc:my-gw"})
+
+	handlers := map[string]*ipn.HTTPHandler{
+		"/api": {Proxy: "http://10.0.0.1:8080/api"},
+		"/":    {Proxy: "https+insecure://10.0.0.2:443/"},
+	}
+	want := &ipn.ServiceConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			443: {HTTPS: true},
+			80:  {HTTP: true},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"my-gw.ts.net:443": {Handlers: handlers},
+			"my-gw.ts.net:80":  {Handlers: handlers},
+		},
+	}
+	if got := gatewayServiceConfig(t, fc, "svc:my-gw"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected serve config:\ngot:  %+v\nwant: %+v", got, want)
+	}
+
+	expectCondition(t, fc, gw, string(gatewayv1.GatewayConditionAccepted), metav1.ConditionTrue, string(gatewayv1.GatewayReasonAccepted))
+	expectCondition(t, fc, gw, string(gatewayv1.GatewayConditionProgrammed), metav1.ConditionFalse, string(gatewayv1.GatewayReasonAddressNotAssigned))
+	expectRouteCondition(t, fc, &gatewayv1.HTTPRoute{}, "app", string(gatewayv1.RouteConditionAccepted), metav1.ConditionTrue, string(gatewayv1.RouteReasonAccepted))
+	expectRouteCondition(t, fc, &gatewayv1.HTTPRoute{}, "app", string(gatewayv1.RouteConditionResolvedRefs), metav1.ConditionTrue, string(gatewayv1.RouteReasonResolvedRefs))
+
+	// Once Pods advertise the Tailscale Service and certs have been issued,
+	// the Gateway and its listeners are programmed.
+	mustCreate(t, fc, advertisingStateSecret("svc:my-gw"))
+	if err := populateTLSSecret(context.Background(), fc, "test-pg", "my-gw.ts.net"); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciled(t, r, "default", "test-gw")
+	expectCondition(t, fc, gw, string(gatewayv1.GatewayConditionProgrammed), metav1.ConditionTrue, string(gatewayv1.GatewayReasonProgrammed))
+	wantAddrs := []gatewayv1.GatewayStatusAddress{{Type: ptr.To(gatewayv1.HostnameAddressType), Value: "my-gw.ts.net"}}
+	if !reflect.DeepEqual(gw.Status.Addresses, wantAddrs) {
+		t.Errorf("unexpected Gateway addresses: got %+v, want %+v", gw.Status.Addresses, wantAddrs)
+	}
+	for _, ls := range gw.Status.Listeners {
+		if ls.AttachedRoutes != 1 {
+			t.Errorf("listener %q: got %d attached routes, want 1", ls.Name, ls.AttachedRoutes)
+		}
+		if !apimeta.IsStatusConditionTrue(ls.Conditions, string(gatewayv1.ListenerConditionProgrammed)) {
+			t.Errorf("listener %q is not programmed: %+v", ls.Name, ls.Conditions)
+		}
+	}
+
+	// Tailscale Services of Gateways are not cleaned up by the HA Ingress
+	// reconciler.
+	if _, err := r.HAIngressReconciler.maybeCleanupProxyGroup(context.Background(), "test-pg", r.logger); err != nil {
+		t.Fatal(err)
+	}
+	verifyTailscaleService(t, ft, "svc:my-gw", []string{"tcp:443", "tcp:80"})
+	gatewayServiceConfig(t, fc, "svc:my-gw")
+}
+
+func TestGatewayTCPAndTLSRoutes(t *testing.T) {
+	r, fc, ft := setupGatewayTest(t)
+
+	gw := testGateway(
+		gatewayv1.Listener{Name: "postgres", Port: 5432, Protocol: gatewayv1.TCPProtocolType},
+		gatewayv1.Listener{
+			Name:     "tls",
+			Port:     8443,
+			Protocol: gatewayv1.TLSProtocolType,
+			TLS:      &gatewayv1.GatewayTLSConfig{Mode: ptr.To(gatewayv1.TLSModePassthrough)},
+		},
+		gatewayv1.Listener{Name: "udp", Port: 53, Protocol: gatewayv1.UDPProtocolType},
+	)
+	mustCreate(t, fc, gw)
+	mustCreate(t, fc, &gatewayv1alpha2.TCPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec: gatewayv1alpha2.TCPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw", SectionName: ptr.To(gatewayv1.SectionName("postgres"))}},
+			},
+			Rules: []gatewayv1alpha2.TCPRouteRule{{BackendRefs: []gatewayv1.BackendRef{backendRef("api", 5432)}}},
+		},
+	})
+	mustCreate(t, fc, &gatewayv1alpha2.TLSRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"},
+		Spec: gatewayv1alpha2.TLSRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Hostnames: []gatewayv1.Hostname{"*.ts.net"},
+			Rules:     []gatewayv1alpha2.TLSRouteRule{{BackendRefs: []gatewayv1.BackendRef{backendRef("web", 443)}}},
+		},
+	})
+
+	expectReconciled(t, r, "default", "test-gw")
+	verifyTailscaleService(t, ft, "svc:my-gw", []string{"tcp:5432", "tcp:8443"})
+	want := &ipn.ServiceConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			5432: {TCPForward: "10.0.0.1:5432"},
+			8443: {TCPForward: "10.0.0.2:443"},
+		},
+	}
+	if got := gatewayServiceConfig(t, fc, "svc:my-gw"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected serve config:\ngot:  %+v\nwant: %+v", got, want)
+	}
+	expectRouteCondition(t, fc, &gatewayv1alpha2.TCPRoute{}, "db", string(gatewayv1.RouteConditionAccepted), metav1.ConditionTrue, string(gatewayv1.RouteReasonAccepted))
+	expectRouteCondition(t, fc, &gatewayv1alpha2.TLSRoute{}, "tls", string(gatewayv1.RouteConditionAccepted), metav1.ConditionTrue, string(gatewayv1.RouteReasonAccepted))
+
+	// The UDP listener is not supported.
+	expectCondition(t, fc, gw, string(gatewayv1.GatewayConditionAccepted), metav1.ConditionTrue, string(gatewayv1.GatewayReasonListenersNotValid))
+	udp := gw.Status.Listeners[2]
+	if c := apimeta.FindStatusCondition(udp.Conditions, string(gatewayv1.ListenerConditionAccepted)); c == nil || c.Reason != string(gatewayv1.ListenerReasonUnsupportedProtocol) {
+		t.Errorf("unexpected Accepted condition for UDP listener: %+v", c)
+	}
+
+	// TLS listeners that terminate TLS forward decrypted traffic.
+	mustUpdate(t, fc, "default", "test-gw", func(gw *gatewayv1.Gateway) {
+		gw.Spec.Listeners[1].TLS = nil
+	})
+	expectReconciled(t, r, "default", "test-gw")
+	want.TCP[8443] = &ipn.TCPPortHandler{TCPForward: "10.0.0.2:443", TerminateTLS: "my-gw.ts.net"}
+	if got := gatewayServiceConfig(t, fc, "svc:my-gw"); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected serve config:\ngot:  %+v\nwant: %+v", got, want)
+	}
+	expectEqual(t, fc, certSecretRole("test-pg", "operator-ns", "my-gw.ts.net"))
+}
+
+func TestGatewayUnsupportedRoutes(t *testing.T) {
+	r, fc, _ := setupGatewayTest(t)
+
+	gw := testGateway(gatewayv1.Listener{Name: "https", Port: 443, Protocol: gatewayv1.HTTPSProtocolType})
+	mustCreate(t, fc, gw)
+	mustCreate(t, fc, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "exact", Namespace: "default"},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Rules: []gatewayv1.HTTPRouteRule{{
+				Matches: []gatewayv1.HTTPRouteMatch{{
+					Path: &gatewayv1.HTTPPathMatch{
+						Type:  ptr.To(gatewayv1.PathMatchExact),
+						Value: ptr.To("/exact"),
+					},
+				}},
+				BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef("api", 8080)}},
+			}},
+		},
+	})
+	otherNSBackend := backendRef("api", 8080)
+	otherNSBackend.Namespace = ptr.To(gatewayv1.Namespace("other"))
+	mustCreate(t, fc, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-ns", Namespace: "default"},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Rules: []gatewayv1.HTTPRouteRule{{
+				BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: otherNSBackend}},
+			}},
+		},
+	})
+	mustCreate(t, fc, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "wrong-host", Namespace: "default"},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Hostnames: []gatewayv1.Hostname{"example.com"},
+			Rules: []gatewayv1.HTTPRouteRule{{
+				BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef("api", 8080)}},
+			}},
+		},
+	})
+
+	expectReconciled(t, r, "default", "test-gw")
+	expectRouteCondition(t, fc, &gatewayv1.HTTPRoute{}, "exact", string(gatewayv1.RouteConditionAccepted), metav1.ConditionFalse, string(gatewayv1.RouteReasonUnsupportedValue))
+	expectRouteCondition(t, fc, &gatewayv1.HTTPRoute{}, "other-ns", string(gatewayv1.RouteConditionResolvedRefs), metav1.ConditionFalse, string(gatewayv1.RouteReasonRefNotPermitted))
+	expectRouteCondition(t, fc, &gatewayv1.HTTPRoute{}, "wrong-host", string(gatewayv1.RouteConditionAccepted), metav1.ConditionFalse, string(gatewayv1.RouteReasonNoMatchingListenerHostname))
+
+	// Only the route with the unresolved backend is attached, and it has no
+	// handlers.
+	if err := fc.Get(context.Background(), client.ObjectKeyFromObject(gw), gw); err != nil {
+		t.Fatal(err)
+	}
+	if got := gw.Status.Listeners[0].AttachedRoutes; got != 1 {
+		t.Errorf("got %d attached routes, want 1", got)
+	}
+	if got := gatewayServiceConfig(t, fc, "svc:my-gw"); len(got.TCP) != 0 || len(got.Web) != 0 {
+		t.Errorf("unexpected serve config: %+v", got)
+	}
+}
+
+func TestGatewayCleanup(t *testing.T) {
+	r, fc, ft := setupGatewayTest(t)
+
+	gw := testGateway(gatewayv1.Listener{Name: "http", Port: 80, Protocol: gatewayv1.HTTPProtocolType})
+	mustCreate(t, fc, gw)
+	mustCreate(t, fc, &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: gatewayv1.HTTPRouteSpec{
+			CommonRouteSpec: gatewayv1.CommonRouteSpec{
+				ParentRefs: []gatewayv1.ParentReference{{Name: "test-gw"}},
+			},
+			Rules: []gatewayv1.HTTPRouteRule{{
+				BackendRefs: []gatewayv1.HTTPBackendRef{{BackendRef: backendRef("api", 8080)}},
+			}},
+		},
+	})
+	expectReconciled(t, r, "default", "test-gw")
+	verifyTailscaleService(t, ft, "svc:my-gw", []string{"tcp:80"})
+	verifyTailscaledConfig(t, fc, "test-pg", []string{"svc:my-gw"})
+
+	if err := fc.Delete(context.Background(), gw); err != nil {
+		t.Fatal(err)
+	}
+	expectReconciled(t, r, "default", "test-gw")
+
+	if _, err := ft.GetVIPService(context.Background(), "svc:my-gw"); !isErrorTailscaleServiceNotFound(err) {
+		t.Errorf("expected Tailscale Service to be deleted, got error %v", err)
+	}
+	verifyTailscaledConfig(t, fc, "test-pg", nil)
+	cm := &corev1.ConfigMap{}
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: "test-pg-ingress-config", Namespace: "operator-ns"}, cm); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ipn.ServeConfig{}
+	if err := json.Unmarshal(cm.BinaryData[serveConfigKey], cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Services) != 0 {
+		t.Errorf("expected serve config to have no services, got %+v", cfg.Services)
+	}
+	expectMissing[gatewayv1.Gateway](t, fc, "default", "test-gw")
+	rt := &gatewayv1.HTTPRoute{}
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: "app", Namespace: "default"}, rt); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.Status.Parents) != 0 {
+		t.Errorf("expected route status parents to be removed, got %+v", rt.Status.Parents)
+	}
+}
+
+func TestGatewayHostnames(t *testing.T) {
+	r, fc, _ := setupGatewayTest(t)
+	pg := &tsapi.ProxyGroup{Spec: tsapi.ProxyGroupSpec{Type: tsapi.ProxyGroupTypeIngress}}
+
+	gw := testGateway()
+	mustCreate(t, fc, gw)
+	other := testGateway()
+	other.Name, other.UID, other.Spec.GatewayClassName = "other-gw", "5678-UID", "other-class"
+	mustCreate(t, fc, other)
+	if err := r.validateGateway(context.Background(), gw, pg); err == nil {
+		t.Error("Gateway sharing a hostname with a Gateway of another class was accepted")
+	}
+
+	// Derived hostnames don't collide for Gateways whose namespace and name
+	// join to the same string.
+	a := &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "a-b", Name: "c"}}
+	b := &gatewayv1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "b-c"}}
+	if ha, hb := hostnameForGateway(a), hostnameForGateway(b); ha == hb {
+		t.Errorf("Gateways a-b/c and a/b-c both got hostname %q", ha)
+	}
+}
+
+func setupGatewayTest(t *testing.T) (*HAGatewayReconciler, client.Client, *fakeTSClient) {
+	t.Helper()
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(
+			&gatewayv1.GatewayClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "tailscale"},
+				Spec: gatewayv1.GatewayClassSpec{
+					ControllerName: gatewayControllerName,
+					ParametersRef: &gatewayv1.ParametersReference{
+						Group: "tailscale.com",
+						Kind:  "ProxyGroup",
+						Name:  "test-pg",
+					},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.0.0.1",
+					Ports:     []corev1.ServicePort{{Port: 8080}, {Port: 5432}},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: "10.0.0.2",
+					Ports:     []corev1.ServicePort{{Name: "https", Port: 443}},
+				},
+			},
+		).
+		WithStatusSubresource(
+			&tsapi.ProxyGroup{},
+			&gatewayv1.Gateway{},
+			&gatewayv1.HTTPRoute{},
+			&gatewayv1alpha2.TLSRoute{},
+			&gatewayv1alpha2.TCPRoute{},
+		).
+		Build()
+	createPGResources(t, fc, "test-pg")
+
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &HAGatewayReconciler{
+		HAIngressReconciler: &HAIngressReconciler{
+			Client:      fc,
+			tsClient:    ft,
+			defaultTags: []string{"tag:k8s"},
+			tsNamespace: "operator-ns",
+			tsnetServer: &fakeTSNetServer{certDomains: []string{"foo.com"}},
+			logger:      zl.Sugar(),
+			recorder:    record.NewFakeRecorder(10),
+			lc: &fakeLocalClient{
+				status: &ipnstate.Status{
+					CurrentTailnet: &ipnstate.TailnetStatus{
+						MagicDNSSuffix: "ts.net",
+					},
+				},
+			},
+			gatewayAPIEnabled: true,
+		},
+	}
+	return r, fc, ft
+}
+
+func testGateway(listeners ...gatewayv1.Listener) *gatewayv1.Gateway {
+	return &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-gw",
+			Namespace: "default",
+			UID:       types.UID("1234-UID"),
+			Annotations: map[string]string{
+				AnnotationHostname: "my-gw",
+			},
+		},
+		Spec: gatewayv1.GatewaySpec{
+			GatewayClassName: "tailscale",
+			Listeners:        listeners,
+		},
+	}
+}
+
+func backendRef(svc string, port gatewayv1.PortNumber) gatewayv1.BackendRef {
+	return gatewayv1.BackendRef{
+		BackendObjectReference: gatewayv1.BackendObjectReference{
+			Name: gatewayv1.ObjectName(svc),
+			Port: ptr.To(port),
+		},
+	}
+}
+
+// advertisingStateSecret returns a state Secret for a ProxyGroup Pod that
+// advertises the Tailscale Service.
+func advertisingStateSecret(svcName string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pg-0",
+			Namespace: "operator-ns",
+			Labels:    pgSecretLabels("test-pg", "state"),
+		},
+		Data: map[string][]byte{
+			"_current-profile": []byte("profile-foo"),
+			"profile-foo":      []byte(`{"AdvertiseServices":["` + svcName + `"],"Config":{"NodeID":"node-foo"}}`),
+		},
+	}
+}
+
+func gatewayServiceConfig(t *testing.T, fc client.Client, svcName string) *ipn.ServiceConfig {
+	t.Helper()
+	cm := &corev1.ConfigMap{}
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: "test-pg-ingress-config", Namespace: "operator-ns"}, cm); err != nil {
+		t.Fatalf("getting ConfigMap: %v", err)
+	}
+	cfg := &ipn.ServeConfig{}
+	if err := json.Unmarshal(cm.BinaryData[serveConfigKey], cfg); err != nil {
+		t.Fatalf("unmarshaling serve config: %v", err)
+	}
+	svc := cfg.Services[tailcfg.ServiceName(svcName)]
+	if svc == nil {
+		t.Fatalf("service %q not found in serve config", svcName)
+	}
+	return svc
+}
+
+// expectCondition fetches obj, which must be a Gateway or a GatewayClass,
+// and checks that it has a condition of the given type, status and reason.
+func expectCondition(t *testing.T, fc client.Client, obj client.Object, typ string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	if err := fc.Get(context.Background(), client.ObjectKeyFromObject(obj), obj); err != nil {
+		t.Fatal(err)
+	}
+	var conds []metav1.Condition
+	switch o := obj.(type) {
+	case *gatewayv1.Gateway:
+		conds = o.Status.Conditions
+	case *gatewayv1.GatewayClass:
+		conds = o.Status.Conditions
+	default:
+		t.Fatalf("unexpected object type %T", obj)
+	}
+	checkCondition(t, conds, typ, status, reason)
+}
+
+// expectRouteCondition fetches the route named name in the default namespace
+// and checks that its status for its first parent has a condition of the
+// given type, status and reason.
+func expectRouteCondition(t *testing.T, fc client.Client, rt client.Object, name, typ string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, rt); err != nil {
+		t.Fatal(err)
+	}
+	var parents []gatewayv1.RouteParentStatus
+	switch o := rt.(type) {
+	case *gatewayv1.HTTPRoute:
+		parents = o.Status.Parents
+	case *gatewayv1alpha2.TLSRoute:
+		parents = o.Status.Parents
+	case *gatewayv1alpha2.TCPRoute:
+		parents = o.Status.Parents
+	default:
+		t.Fatalf("unexpected route type %T", rt)
+	}
+	if len(parents) != 1 {
+		t.Fatalf("route %q: got %d parent statuses, want 1", name, len(parents))
+	}
+	if parents[0].ControllerName != gatewayControllerName {
+		t.Errorf("route %q: got controller name %q, want %q", name, parents[0].ControllerName, gatewayControllerName)
+	}
+	checkCondition(t, parents[0].Conditions, typ, status, reason)
+}
+
+func checkCondition(t *testing.T, conds []metav1.Condition, typ string, status metav1.ConditionStatus, reason string) {
+	t.Helper()
+	c := apimeta.FindStatusCondition(conds, typ)
+	if c == nil {
+		t.Fatalf("condition %q not found in %+v", typ, conds)
+	}
+	if c.Status != status || c.Reason != reason {
+		t.Errorf("condition %q: got status %q reason %q (%s), want status %q reason %q", typ, c.Status, c.Reason, c.Message, status, reason)
+	}
+}
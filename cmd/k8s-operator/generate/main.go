@@ -20,18 +20,22 @@
 )
 
 const (
-	operatorDeploymentFilesPath   = "cmd/k8s-operator/deploy"
-	connectorCRDPath              = operatorDeploymentFilesPath + "/crds/tailscale.com_connectors.yaml"
-	proxyClassCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_proxyclasses.yaml"
-	dnsConfigCRDPath              = operatorDeploymentFilesPath + "/crds/tailscale.com_dnsconfigs.yaml"
-	recorderCRDPath               = operatorDeploymentFilesPath + "/crds/tailscale.com_recorders.yaml"
-	proxyGroupCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_proxygroups.yaml"
-	helmTemplatesPath             = operatorDeploymentFilesPath + "/chart/templates"
-	connectorCRDHelmTemplatePath  = helmTemplatesPath + "/connector.yaml"
-	proxyClassCRDHelmTemplatePath = helmTemplatesPath + "/proxyclass.yaml"
-	dnsConfigCRDHelmTemplatePath  = helmTemplatesPath + "/dnsconfig.yaml"
-	recorderCRDHelmTemplatePath   = helmTemplatesPath + "/recorder.yaml"
-	proxyGroupCRDHelmTemplatePath = helmTemplatesPath + "/proxygroup.yaml"
+	operatorDeploymentFilesPath      = "cmd/k8s-operator/deploy"
+	connectorCRDPath                 = operatorDeploymentFilesPath + "/crds/tailscale.com_connectors.yaml"
+	proxyClassCRDPath                = operatorDeploymentFilesPath + "/crds/tailscale.com_proxyclasses.yaml"
+	dnsConfigCRDPath                 = operatorDeploymentFilesPath + "/crds/tailscale.com_dnsconfigs.yaml"
+	recorderCRDPath                  = operatorDeploymentFilesPath + "/crds/tailscale.com_recorders.yaml"
+	proxyGroupCRDPath                = operatorDeploymentFilesPath + "/crds/tailscale.com_proxygroups.yaml"
+	serviceExportCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_serviceexports.yaml"
+	serviceImportCRDPath             = operatorDeploymentFilesPath + "/crds/tailscale.com_serviceimports.yaml"
+	helmTemplatesPath                = operatorDeploymentFilesPath + "/chart/templates"
+	connectorCRDHelmTemplatePath     = helmTemplatesPath + "/connector.yaml"
+	proxyClassCRDHelmTemplatePath    = helmTemplatesPath + "/proxyclass.yaml"
+	dnsConfigCRDHelmTemplatePath     = helmTemplatesPath + "/dnsconfig.yaml"
+	recorderCRDHelmTemplatePath      = helmTemplatesPath + "/recorder.yaml"
+	proxyGroupCRDHelmTemplatePath    = helmTemplatesPath + "/proxygroup.yaml"
+	serviceExportCRDHelmTemplatePath = helmTemplatesPath + "/serviceexport.yaml"
+	serviceImportCRDHelmTemplatePath = helmTemplatesPath + "/serviceimport.yaml"
 
 	helmConditionalStart = "{{ if .Values.installCRDs -}}\n"
 	helmConditionalEnd   = "{{- end -}}"
@@ -115,9 +119,9 @@ func main() {
 	}
 }
 
-// generate places tailscale.com CRDs (currently Connector, ProxyClass, DNSConfig, Recorder) into
-// the Helm chart templates behind .Values.installCRDs=true condition (true by
-// default).
+// generate places tailscale.com CRDs (currently Connector, ProxyClass, DNSConfig, Recorder,
+// ProxyGroup, ServiceExport, ServiceImport) into the Helm chart templates behind
+// .Values.installCRDs=true condition (true by default).
 func generate(baseDir string) error {
 	addCRDToHelm := func(crdPath, crdTemplatePath string) error {
 		chartBytes, err := os.ReadFile(filepath.Join(baseDir, crdPath))
@@ -149,6 +153,8 @@ func generate(baseDir string) error {
 		{dnsConfigCRDPath, dnsConfigCRDHelmTemplatePath},
 		{recorderCRDPath, recorderCRDHelmTemplatePath},
 		{proxyGroupCRDPath, proxyGroupCRDHelmTemplatePath},
+		{serviceExportCRDPath, serviceExportCRDHelmTemplatePath},
+		{serviceImportCRDPath, serviceImportCRDHelmTemplatePath},
 	} {
 		if err := addCRDToHelm(crd.crdPath, crd.templatePath); err != nil {
 			return fmt.Errorf("error adding %s CRD to Helm templates: %w", crd.crdPath, err)
@@ -165,6 +171,8 @@ func cleanup(baseDir string) error {
 		dnsConfigCRDHelmTemplatePath,
 		recorderCRDHelmTemplatePath,
 		proxyGroupCRDHelmTemplatePath,
+		serviceExportCRDHelmTemplatePath,
+		serviceImportCRDHelmTemplatePath,
 	} {
 		if err := os.Remove(filepath.Join(baseDir, path)); err != nil && !os.IsNotExist(err) {
 			return fmt.Errorf("error cleaning up %s: %w", path, err)
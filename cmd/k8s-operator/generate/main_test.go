@@ -65,6 +65,12 @@ func Test_generate(t *testing.T) {
 	if !strings.Contains(installContentsWithCRD.String(), "name: proxygroups.tailscale.com") {
 		t.Errorf("ProxyGroup CRD not found in default chart install")
 	}
+	if !strings.Contains(installContentsWithCRD.String(), "name: serviceexports.tailscale.com") {
+		t.Errorf("ServiceExport CRD not found in default chart install")
+	}
+	if !strings.Contains(installContentsWithCRD.String(), "name: serviceimports.tailscale.com") {
+		t.Errorf("ServiceImport CRD not found in default chart install")
+	}
 
 	// Test that CRDs can be excluded from Helm chart install
 	installContentsWithoutCRD := bytes.NewBuffer([]byte{})
@@ -89,4 +95,10 @@ func Test_generate(t *testing.T) {
 	if strings.Contains(installContentsWithoutCRD.String(), "name: proxygroups.tailscale.com") {
 		t.Errorf("ProxyGroup CRD found in chart install that should not contain a CRD")
 	}
+	if strings.Contains(installContentsWithoutCRD.String(), "name: serviceexports.tailscale.com") {
+		t.Errorf("ServiceExport CRD found in chart install that should not contain a CRD")
+	}
+	if strings.Contains(installContentsWithoutCRD.String(), "name: serviceimports.tailscale.com") {
+		t.Errorf("ServiceImport CRD found in chart install that should not contain a CRD")
+	}
 }
@@ -76,6 +76,10 @@ type HAIngressReconciler struct {
 	lc          localClient
 	defaultTags []string
 	operatorID  string // stableID of the operator's Tailscale device
+	// gatewayAPIEnabled is whether Gateways are also exposed on ingress
+	// ProxyGroups, in which case their Tailscale Services must not be
+	// cleaned up as unused.
+	gatewayAPIEnabled bool
 
 	mu sync.Mutex // protects following
 	// managedIngresses is a set of all ingress resources that we're currently
@@ -252,7 +256,7 @@ func (r *HAIngressReconciler) maybeProvision(ctx context.Context, hostname strin
 		return false, fmt.Errorf("error determining DNS name base: %w", err)
 	}
 	dnsName := hostname + "." + tcd
-	if err := r.ensureCertResources(ctx, pg, dnsName, ing); err != nil {
+	if err := r.ensureCertResources(ctx, pg, dnsName, ing, "ingress"); err != nil {
 		return false, fmt.Errorf("error ensuring cert resources: %w", err)
 	}
 
@@ -417,7 +421,7 @@ func (r *HAIngressReconciler) maybeProvision(ctx context.Context, hostname strin
 
 // maybeCleanupProxyGroup ensures that any Tailscale Services that are
 // associated with the provided ProxyGroup and no longer needed for any
-// Ingresses exposed on this ProxyGroup are deleted, if not owned by other
+// Ingresses or Gateways exposed on this ProxyGroup are deleted, if not owned by other
 // operator instances, else the owner reference is cleaned up.  Returns true if
 // the operation resulted in an existing Tailscale Service updates (owner
 // reference removal).
@@ -436,11 +440,17 @@ func (r *HAIngressReconciler) maybeCleanupProxyGroup(ctx context.Context, proxyG
 	if err := r.List(ctx, ingList); err != nil {
 		return false, fmt.Errorf("listing Ingresses: %w", err)
 	}
+	var gwHostnames set.Set[string]
+	if r.gatewayAPIEnabled {
+		if gwHostnames, err = gatewayHostnames(ctx, r.Client); err != nil {
+			return false, err
+		}
+	}
 	serveConfigChanged := false
 	// For each Tailscale Service in serve config...
 	for tsSvcName := range cfg.Services {
-		// ...check if there is currently an Ingress with this hostname
-		found := false
+		// ...check if there is currently an Ingress or Gateway with this hostname
+		found := gwHostnames.Contains(tsSvcName.WithoutPrefix())
 		for _, i := range ingList.Items {
 			ingressHostname := hostnameForIngress(&i)
 			if ingressHostname == tsSvcName.WithoutPrefix() {
@@ -450,7 +460,7 @@ func (r *HAIngressReconciler) maybeCleanupProxyGroup(ctx context.Context, proxyG
 		}
 
 		if !found {
-			logger.Infof("Tailscale Service %q is not owned by any Ingress, cleaning up", tsSvcName)
+			logger.Infof("Tailscale Service %q is not owned by any Ingress or Gateway, cleaning up", tsSvcName)
 			tsService, err := r.tsClient.GetVIPService(ctx, tsSvcName)
 			if isErrorFeatureFlagNotEnabled(err) {
 				msg := fmt.Sprintf("Unable to proceed with cleanup: %s.", msgFeatureFlagNotEnabled)
@@ -924,15 +934,16 @@ func ownersAreSetAndEqual(a, b *tailscale.VIPService) bool {
 		strings.EqualFold(a.Annotations[ownerAnnotation], b.Annotations[ownerAnnotation])
 }
 
-// ensureCertResources ensures that the TLS Secret for an HA Ingress and RBAC
-// resources that allow proxies to manage the Secret are created.
+// ensureCertResources ensures that the TLS Secret for an HA Ingress or a
+// Gateway (the parent, of type parentType) and RBAC resources that allow
+// proxies to manage the Secret are created.
 // Note that Tailscale Service's name validation matches Kubernetes
 // resource name validation, so we can be certain that the Tailscale Service name
 // (domain) is a valid Kubernetes resource name.
 // https://github.com/tailscale/tailscale/blob/8b1e7f646ee4730ad06c9b70c13e7861b964949b/util/dnsname/dnsname.go#L99
 // https://kubernetes.io/docs/concepts/overview/working-with-objects/names/#dns-subdomain-names
-func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, ing *networkingv1.Ingress) error {
-	secret := certSecret(pg.Name, r.tsNamespace, domain, ing)
+func (r *HAIngressReconciler) ensureCertResources(ctx context.Context, pg *tsapi.ProxyGroup, domain string, parent client.Object, parentType string) error {
+	secret := certSecret(pg.Name, r.tsNamespace, domain, parent, parentType)
 	if _, err := createOrUpdate(ctx, r.Client, r.tsNamespace, secret, func(s *corev1.Secret) {
 		// Labels might have changed if the Ingress has been updated to use a
 		// different ProxyGroup.
@@ -1040,14 +1051,15 @@ func certSecretRoleBinding(pgName, namespace, domain string) *rbacv1.RoleBinding
 
 // certSecret creates a Secret that will store the TLS certificate and private
 // key for the given domain. Domain must be a valid Kubernetes resource name.
-func certSecret(pgName, namespace, domain string, ing *networkingv1.Ingress) *corev1.Secret {
+func certSecret(pgName, namespace, domain string, parent client.Object, parentType string) *corev1.Secret {
 	labels := certResourceLabels(pgName, domain)
 	labels[kubetypes.LabelSecretType] = "certs"
-	// Labels that let us identify the Ingress resource lets us reconcile
-	// the Ingress when the TLS Secret is updated (for example, when TLS
-	// certs have been provisioned).
-	labels[LabelParentName] = ing.Name
-	labels[LabelParentNamespace] = ing.Namespace
+	// Labels that let us identify the parent resource lets us reconcile
+	// the Ingress or Gateway when the TLS Secret is updated (for example,
+	// when TLS certs have been provisioned).
+	labels[LabelParentType] = parentType
+	labels[LabelParentName] = parent.GetName()
+	labels[LabelParentNamespace] = parent.GetNamespace()
 	return &corev1.Secret{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
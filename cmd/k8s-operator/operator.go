@@ -33,6 +33,7 @@
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -40,6 +41,8 @@
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+	gatewayv1alpha2 "sigs.k8s.io/gateway-api/apis/v1alpha2"
 	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/hostinfo"
@@ -77,6 +80,7 @@ func main() {
 		tsFirewallMode        = defaultEnv("PROXY_FIREWALL_MODE", "")
 		defaultProxyClass     = defaultEnv("PROXY_DEFAULT_CLASS", "")
 		isDefaultLoadBalancer = defaultBool("OPERATOR_DEFAULT_LOAD_BALANCER", false)
+		enableGatewayAPI      = defaultBool("OPERATOR_ENABLE_GATEWAY_API", false)
 	)
 
 	var opts []kzap.Opts
@@ -126,6 +130,7 @@ func main() {
 		proxyTags:                     tags,
 		proxyFirewallMode:             tsFirewallMode,
 		defaultProxyClass:             defaultProxyClass,
+		enableGatewayAPI:              enableGatewayAPI,
 	}
 	runReconcilers(rOpts)
 }
@@ -363,6 +368,8 @@ func runReconcilers(opts reconcilerOpts) {
 			lc:          lc,
 			operatorID:  id,
 			tsNamespace: opts.tailscaleNamespace,
+
+			gatewayAPIEnabled: opts.enableGatewayAPI,
 		})
 	if err != nil {
 		startlog.Fatalf("could not create ingress-pg-reconciler: %v", err)
@@ -371,6 +378,62 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("failed setting up indexer for HA Ingresses: %v", err)
 	}
 
+	if opts.enableGatewayAPI {
+		err = builder.
+			ControllerManagedBy(mgr).
+			For(&gatewayv1.GatewayClass{}).
+			Named("gatewayclass-reconciler").
+			Watches(&tsapi.ProxyGroup{}, handler.EnqueueRequestsFromMapFunc(gatewayClassesFromProxyGroup(mgr.GetClient(), startlog))).
+			Complete(&GatewayClassReconciler{
+				Client: mgr.GetClient(),
+				logger: opts.log.Named("gatewayclass-reconciler"),
+			})
+		if err != nil {
+			startlog.Fatalf("could not create gatewayclass-reconciler: %v", err)
+		}
+		allGatewaysFilter := handler.EnqueueRequestsFromMapFunc(allGateways(mgr.GetClient(), startlog))
+		gatewayRouteFilter := handler.EnqueueRequestsFromMapFunc(gatewaysFromRoute(startlog))
+		b := builder.
+			ControllerManagedBy(mgr).
+			For(&gatewayv1.Gateway{}).
+			Named("gateway-reconciler").
+			Watches(&gatewayv1.GatewayClass{}, allGatewaysFilter).
+			Watches(&tsapi.ProxyGroup{}, allGatewaysFilter).
+			Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromSecret(mgr.GetClient(), startlog))).
+			Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(gatewaysFromService(mgr.GetClient(), startlog))).
+			Watches(&gatewayv1.HTTPRoute{}, gatewayRouteFilter)
+		// TLSRoutes and TCPRoutes are only part of the experimental channel
+		// of the Gateway API, so their CRDs might not be installed.
+		for _, rt := range []client.Object{&gatewayv1alpha2.TLSRoute{}, &gatewayv1alpha2.TCPRoute{}} {
+			gvk, err := apiutil.GVKForObject(rt, mgr.GetScheme())
+			if err != nil {
+				startlog.Fatalf("[unexpected] error determining GroupVersionKind: %v", err)
+			}
+			if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				startlog.Infof("%s CRD not found, %ss will not be reconciled: %v", gvk.Kind, gvk.Kind, err)
+				continue
+			}
+			b = b.Watches(rt, gatewayRouteFilter)
+		}
+		err = b.Complete(&HAGatewayReconciler{
+			HAIngressReconciler: &HAIngressReconciler{
+				recorder:          eventRecorder,
+				tsClient:          opts.tsClient,
+				tsnetServer:       opts.tsServer,
+				defaultTags:       strings.Split(opts.proxyTags, ","),
+				Client:            mgr.GetClient(),
+				logger:            opts.log.Named("gateway-reconciler"),
+				lc:                lc,
+				operatorID:        id,
+				tsNamespace:       opts.tailscaleNamespace,
+				gatewayAPIEnabled: true,
+			},
+		})
+		if err != nil {
+			startlog.Fatalf("could not create gateway-reconciler: %v", err)
+		}
+	}
+
 	ingressSvcFromEpsFilter := handler.EnqueueRequestsFromMapFunc(ingressSvcFromEps(mgr.GetClient(), opts.log.Named("service-pg-reconciler")))
 	err = builder.
 		ControllerManagedBy(mgr).
@@ -398,6 +461,37 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("failed setting up indexer for HA Services: %v", err)
 	}
 
+	err = builder.
+		ControllerManagedBy(mgr).
+		For(&tsapi.ServiceExport{}).
+		Named("serviceexport-reconciler").
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(serviceExportsFromService(mgr.GetClient(), startlog))).
+		Complete(&ServiceExportReconciler{
+			Client:   mgr.GetClient(),
+			recorder: eventRecorder,
+			tsClient: opts.tsClient,
+			clock:    tstime.DefaultClock{},
+			logger:   opts.log.Named("serviceexport-reconciler"),
+		})
+	if err != nil {
+		startlog.Fatalf("could not create serviceexport-reconciler: %v", err)
+	}
+	err = builder.
+		ControllerManagedBy(mgr).
+		For(&tsapi.ServiceImport{}).
+		Named("serviceimport-reconciler").
+		Watches(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("serviceimport"))).
+		Complete(&ServiceImportReconciler{
+			Client:   mgr.GetClient(),
+			recorder: eventRecorder,
+			tsClient: opts.tsClient,
+			clock:    tstime.DefaultClock{},
+			logger:   opts.log.Named("serviceimport-reconciler"),
+		})
+	if err != nil {
+		startlog.Fatalf("could not create serviceimport-reconciler: %v", err)
+	}
+
 	connectorFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("connector"))
 	// If a ProxyClassChanges, enqueue all Connectors that have
 	// .spec.proxyClass set to the name of this ProxyClass.
@@ -614,6 +708,23 @@ func runReconcilers(opts reconcilerOpts) {
 		startlog.Fatalf("could not create ProxyGroup reconciler: %v", err)
 	}
 
+	// ProxyGroup autoscaler. It is triggered by ProxyGroup changes and
+	// requeues autoscaled ProxyGroups periodically to scrape their replicas'
+	// metrics.
+	err = builder.ControllerManagedBy(mgr).
+		For(&tsapi.ProxyGroup{}).
+		Named("proxygroup-autoscaler").
+		Complete(&proxyGroupAutoscaler{
+			Client:      mgr.GetClient(),
+			logger:      opts.log.Named("proxygroup-autoscaler"),
+			clock:       tstime.DefaultClock{},
+			tsNamespace: opts.tailscaleNamespace,
+			httpClient:  http.DefaultClient,
+		})
+	if err != nil {
+		startlog.Fatalf("could not create ProxyGroup autoscaler: %v", err)
+	}
+
 	startlog.Infof("Startup complete, operator running, version: %s", version.Long())
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		startlog.Fatalf("could not start manager: %v", err)
@@ -658,6 +769,11 @@ type reconcilerOpts struct {
 	// class for proxies that do not have a ProxyClass set.
 	// this is defined by an operator env variable.
 	defaultProxyClass string
+	// enableGatewayAPI determines whether the operator should reconcile
+	// Gateway API resources (GatewayClasses, Gateways, HTTPRoutes,
+	// TLSRoutes and TCPRoutes). Gateways are exposed on ingress ProxyGroups.
+	// Requires the Gateway API CRDs to be installed.
+	enableGatewayAPI bool
 }
 
 // enqueueAllIngressEgressProxySvcsinNS returns a reconcile request for each
@@ -1124,6 +1240,9 @@ func HAIngressesFromSecret(cl client.Client, logger *zap.SugaredLogger) handler.
 			return nil
 		}
 		if isTLSSecret(secret) {
+			if secret.ObjectMeta.Labels[LabelParentType] == "gateway" {
+				return nil
+			}
 			return []reconcile.Request{
 				{
 					NamespacedName: types.NamespacedName{
@@ -190,16 +190,27 @@ func (r *ProxyGroupReconciler) Reconcile(ctx context.Context, req reconcile.Requ
 	}
 
 	desiredReplicas := int(pgReplicas(pg))
+	// Autoscaled ProxyGroups are scaled routinely, so they remain ready while
+	// being scaled as long as some of their replicas are running.
+	status, reason := metav1.ConditionFalse, reasonProxyGroupCreating
+	if pg.Spec.Autoscaling != nil && len(pg.Status.Devices) > 0 {
+		status, reason = metav1.ConditionTrue, reasonProxyGroupReady
+	}
 	if len(pg.Status.Devices) < desiredReplicas {
 		message := fmt.Sprintf("%d/%d ProxyGroup pods running", len(pg.Status.Devices), desiredReplicas)
 		logger.Debug(message)
-		return setStatusReady(pg, metav1.ConditionFalse, reasonProxyGroupCreating, message)
+		return setStatusReady(pg, status, reason, message)
 	}
 
 	if len(pg.Status.Devices) > desiredReplicas {
 		message := fmt.Sprintf("waiting for %d ProxyGroup pods to shut down", len(pg.Status.Devices)-desiredReplicas)
 		logger.Debug(message)
-		return setStatusReady(pg, metav1.ConditionFalse, reasonProxyGroupCreating, message)
+		if _, err := setStatusReady(pg, status, reason, message); err != nil {
+			return reconcile.Result{}, err
+		}
+		// Removed replicas may take a while to drain, check back to clean
+		// up their resources once they have shut down.
+		return reconcile.Result{RequeueAfter: shortRequeue}, nil
 	}
 
 	logger.Info("ProxyGroup resources synced")
@@ -208,6 +219,10 @@ func (r *ProxyGroupReconciler) Reconcile(ctx context.Context, req reconcile.Requ
 
 // validateProxyClassForPG applies custom validation logic for ProxyClass applied to ProxyGroup.
 func validateProxyClassForPG(logger *zap.SugaredLogger, pg *tsapi.ProxyGroup, pc *tsapi.ProxyClass) {
+	if pg.Spec.Autoscaling != nil && hasLocalAddrPortSet(pc) {
+		logger.Warnf("ProxyClass %s applied to an autoscaled ProxyGroup has TS_LOCAL_ADDR_PORT env var set to a custom value. "+
+			"The operator scrapes replica metrics on the Pod IP:%d, so the ProxyGroup will not be scaled based on load.", pc.Name, defaultLocalAddrPort)
+	}
 	if pg.Spec.Type == tsapi.ProxyGroupTypeIngress {
 		return
 	}
@@ -306,6 +321,7 @@ func (r *ProxyGroupReconciler) maybeProvision(ctx context.Context, pg *tsapi.Pro
 		proxyType: string(pg.Spec.Type),
 	}
 	ss = applyProxyClassToStatefulSet(proxyClass, ss, cfg, logger)
+	enableMetricsForAutoscaling(pg, ss)
 	capver, err := r.capVerForPG(ctx, pg, logger)
 	if err != nil {
 		return fmt.Errorf("error getting device info: %w", err)
@@ -378,6 +394,13 @@ func (r *ProxyGroupReconciler) cleanupDanglingResources(ctx context.Context, pg
 		if m.ordinal+1 <= int(pgReplicas(pg)) {
 			continue
 		}
+		if m.podUID != "" {
+			// Wait for the replica's Pod to shut down before deleting its
+			// device and state, so that it keeps serving traffic till it has
+			// drained (see containerboot's waitTillSafeToShutdown).
+			logger.Debugf("waiting for Pod %s to shut down before cleaning up its resources", m.stateSecret.Name)
+			continue
+		}
 
 		// Dangling resource, delete the config + state Secrets, as well as
 		// deleting the device from the tailnet.
@@ -0,0 +1,330 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tstime"
+	"tailscale.com/util/httpm"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// autoscalingSyncPeriod is how often the metrics endpoints of the replicas
+	// of an autoscaled ProxyGroup are scraped.
+	autoscalingSyncPeriod = 15 * time.Second
+	// autoscalingTolerance is the fraction by which the observed load can
+	// differ from the target before the ProxyGroup is scaled.
+	autoscalingTolerance = 0.1
+	// defaultScaleDownStabilizationWindow is the default period of time over
+	// which replica count recommendations are considered when scaling down.
+	defaultScaleDownStabilizationWindow = 5 * time.Minute
+
+	metricInboundBytes  = "tailscaled_inbound_bytes_total"
+	metricOutboundBytes = "tailscaled_outbound_bytes_total"
+)
+
+// proxyGroupAutoscaler periodically scrapes the metrics endpoint of each
+// replica of ProxyGroups that have autoscaling configured, and records the
+// number of replicas that the ProxyGroup should run in its status.
+//
+// The ProxyGroup reconciler reads the desired number of replicas from the
+// status and scales the StatefulSet accordingly. When a ProxyGroup is scaled
+// down, the removed replicas drain before they shut down (egress replicas wait
+// till cluster traffic is no longer routed to them in a pre-stop hook, ingress
+// replicas unadvertise their services on SIGTERM) and the ProxyGroup reconciler
+// only deletes their tailnet devices and state once their Pods are gone.
+type proxyGroupAutoscaler struct {
+	client.Client
+	logger      *zap.SugaredLogger
+	clock       tstime.Clock
+	tsNamespace string
+	httpClient  doer // http client that can be set to a mock client in tests
+
+	mu              sync.Mutex                              // protects following
+	lastScrape      map[types.UID]time.Time                 // ProxyGroup UID -> time of the last scrape
+	samples         map[types.UID]map[types.UID]bytesSample // ProxyGroup UID -> Pod UID -> last byte counters sample
+	recommendations map[types.UID][]replicasRecommendation  // ProxyGroup UID -> recent recommendations
+}
+
+// bytesSample is the total number of bytes proxied by a replica at a point in
+// time. Consecutive samples are used to calculate the replica's throughput.
+type bytesSample struct {
+	at    time.Time
+	bytes float64
+}
+
+// replicasRecommendation is the number of replicas that a ProxyGroup should
+// run, as calculated from the load observed at a point in time.
+type replicasRecommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// proxyGroupLoad is the load observed across a ProxyGroup's replicas.
+type proxyGroupLoad struct {
+	// connsReplicas is the number of replicas that reported the number of
+	// tracked connections, and conns is the sum of their connections.
+	connsReplicas int32
+	conns         int64
+	// throughputReplicas is the number of replicas for which throughput could
+	// be calculated, and throughput is the sum of their throughput in bytes
+	// per second.
+	throughputReplicas int32
+	throughput         float64
+}
+
+func (a *proxyGroupAutoscaler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := a.logger.With("ProxyGroup", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	pg := new(tsapi.ProxyGroup)
+	err = a.Get(ctx, req.NamespacedName, pg)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ProxyGroup not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get tailscale.com ProxyGroup: %w", err)
+	}
+	if markedForDeletion(pg) || pg.Spec.Autoscaling == nil {
+		a.forget(pg.UID)
+		if pg.Status.Autoscaling != nil && !markedForDeletion(pg) {
+			logger.Infof("autoscaling is no longer configured for ProxyGroup")
+			pg.Status.Autoscaling = nil
+			return res, a.Status().Update(ctx, pg)
+		}
+		return res, nil
+	}
+
+	// Reconciles are also triggered by changes to the ProxyGroup, so make sure
+	// that replicas are not scraped more often than the sync period.
+	now := a.clock.Now()
+	a.mu.Lock()
+	last, ok := a.lastScrape[pg.UID]
+	a.mu.Unlock()
+	if ok && now.Sub(last) < autoscalingSyncPeriod {
+		return reconcile.Result{RequeueAfter: autoscalingSyncPeriod - now.Sub(last)}, nil
+	}
+
+	current := pgReplicas(pg)
+	load, reporting, err := a.observeLoad(ctx, pg, logger)
+	if err != nil {
+		return res, fmt.Errorf("error observing ProxyGroup load: %w", err)
+	}
+	a.mu.Lock()
+	mak.Set(&a.lastScrape, pg.UID, now)
+	a.mu.Unlock()
+
+	as := pg.Spec.Autoscaling
+	desired := desiredReplicas(as, current, load)
+	if desired < current && reporting < current {
+		// Don't remove replicas based on partial data, e.g. while some
+		// replicas are still starting up.
+		logger.Debugf("only %d/%d replicas reported metrics, not scaling down", reporting, current)
+		desired = current
+	}
+	desired = a.stabilize(pg.UID, as, current, desired, now)
+
+	oldStatus := pg.Status.DeepCopy()
+	st := pg.Status.Autoscaling
+	if st == nil {
+		st = &tsapi.ProxyGroupAutoscalingStatus{}
+		pg.Status.Autoscaling = st
+	}
+	if desired != current {
+		logger.Infof("scaling ProxyGroup from %d to %d replicas", current, desired)
+		st.LastScaleTime = &metav1.Time{Time: now}
+	}
+	st.DesiredReplicas = desired
+	st.CurrentConnectionsPerReplica = nil
+	if load.connsReplicas > 0 {
+		v := load.conns / int64(load.connsReplicas)
+		st.CurrentConnectionsPerReplica = &v
+	}
+	st.CurrentThroughputPerReplica = nil
+	if load.throughputReplicas > 0 {
+		st.CurrentThroughputPerReplica = resource.NewQuantity(int64(load.throughput/float64(load.throughputReplicas)), resource.BinarySI)
+	}
+	if !apiequality.Semantic.DeepEqual(oldStatus, &pg.Status) {
+		if err := a.Status().Update(ctx, pg); err != nil {
+			return res, fmt.Errorf("error updating ProxyGroup status: %w", err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: autoscalingSyncPeriod}, nil
+}
+
+// observeLoad scrapes the metrics endpoints of the ProxyGroup's running
+// replicas and returns the observed load, as well as the number of replicas
+// whose metrics could be scraped.
+func (a *proxyGroupAutoscaler) observeLoad(ctx context.Context, pg *tsapi.ProxyGroup, logger *zap.SugaredLogger) (load proxyGroupLoad, reporting int32, _ error) {
+	pods := &corev1.PodList{}
+	if err := a.List(ctx, pods, client.InNamespace(a.tsNamespace), client.MatchingLabels(pgLabels(pg.Name, nil))); err != nil {
+		return load, 0, fmt.Errorf("error listing Pods for ProxyGroup %s: %w", pg.Name, err)
+	}
+	a.mu.Lock()
+	prevSamples := a.samples[pg.UID]
+	a.mu.Unlock()
+	samples := make(map[types.UID]bytesSample)
+	for _, pod := range pods.Items {
+		if !pod.DeletionTimestamp.IsZero() || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		l := logger.With("proxy_pod", pod.Name)
+		conns, connsOK, bytes, bytesOK, err := a.scrape(ctx, &pod)
+		if err != nil {
+			l.Debugf("error scraping metrics: %v", err)
+			continue
+		}
+		reporting++
+		if connsOK {
+			load.connsReplicas++
+			load.conns += conns
+		}
+		if bytesOK {
+			now := a.clock.Now()
+			samples[pod.UID] = bytesSample{at: now, bytes: bytes}
+			prev, ok := prevSamples[pod.UID]
+			// Counters are reset when the replica restarts.
+			if elapsed := now.Sub(prev.at).Seconds(); ok && elapsed > 0 && bytes >= prev.bytes {
+				load.throughputReplicas++
+				load.throughput += (bytes - prev.bytes) / elapsed
+			}
+		}
+	}
+	// Only keep the samples of the replicas that were just scraped, so that
+	// samples of deleted Pods are dropped.
+	a.mu.Lock()
+	mak.Set(&a.samples, pg.UID, samples)
+	a.mu.Unlock()
+	return load, reporting, nil
+}
+
+// scrape retrieves the number of tracked connections and the total number of
+// bytes proxied from the Pod's metrics endpoint.
+func (a *proxyGroupAutoscaler) scrape(ctx context.Context, pod *corev1.Pod) (conns int64, connsOK bool, bytes float64, bytesOK bool, _ error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	u := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(defaultLocalAddrPort)) + "/metrics"
+	req, err := http.NewRequestWithContext(ctx, httpm.GET, u, nil)
+	if err != nil {
+		return 0, false, 0, false, fmt.Errorf("error creating new HTTP request: %w", err)
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return 0, false, 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, 0, false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, false, 0, false, fmt.Errorf("error parsing metrics: %w", err)
+	}
+	if mf, ok := mfs[kubetypes.MetricProxyConnections]; ok {
+		for _, m := range mf.GetMetric() {
+			conns += int64(m.GetGauge().GetValue())
+			connsOK = true
+		}
+	}
+	for _, name := range []string{metricInboundBytes, metricOutboundBytes} {
+		if mf, ok := mfs[name]; ok {
+			for _, m := range mf.GetMetric() {
+				bytes += m.GetCounter().GetValue()
+				bytesOK = true
+			}
+		}
+	}
+	return conns, connsOK, bytes, bytesOK, nil
+}
+
+// desiredReplicas returns the number of replicas needed to serve the observed
+// load at the configured targets, within the configured replica bounds. If no
+// load could be observed, or the load is within the tolerance of the targets,
+// the current number of replicas is returned.
+func desiredReplicas(as *tsapi.ProxyGroupAutoscaling, current int32, load proxyGroupLoad) int32 {
+	desired := int32(-1)
+	propose := func(total, perReplica float64, replicas int32) {
+		avg := total / float64(replicas)
+		r := current
+		if math.Abs(avg/perReplica-1) > autoscalingTolerance {
+			r = int32(math.Ceil(total / perReplica))
+		}
+		desired = max(desired, r)
+	}
+	if as.TargetConnectionsPerReplica != nil && load.connsReplicas > 0 {
+		propose(float64(load.conns), float64(*as.TargetConnectionsPerReplica), load.connsReplicas)
+	}
+	if as.TargetThroughputPerReplica != nil && !as.TargetThroughputPerReplica.IsZero() && load.throughputReplicas > 0 {
+		propose(load.throughput, as.TargetThroughputPerReplica.AsApproximateFloat64(), load.throughputReplicas)
+	}
+	if desired < 0 {
+		desired = current
+	}
+	return min(max(desired, pgMinReplicas(as)), as.MaxReplicas)
+}
+
+// stabilize records the recommendation and returns the number of replicas
+// that the ProxyGroup should be scaled to. Scaling up happens immediately;
+// when scaling down, the highest recommendation within the stabilization
+// window is used, so that short dips in load don't cause replicas to be
+// removed.
+func (a *proxyGroupAutoscaler) stabilize(uid types.UID, as *tsapi.ProxyGroupAutoscaling, current, recommended int32, now time.Time) int32 {
+	window := defaultScaleDownStabilizationWindow
+	if as.ScaleDownStabilizationWindow != nil {
+		window = as.ScaleDownStabilizationWindow.Duration
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var recs []replicasRecommendation
+	for _, r := range a.recommendations[uid] {
+		if now.Sub(r.at) < window {
+			recs = append(recs, r)
+		}
+	}
+	recs = append(recs, replicasRecommendation{at: now, replicas: recommended})
+	mak.Set(&a.recommendations, uid, recs)
+
+	if recommended >= current {
+		return recommended
+	}
+	stabilized := recommended
+	for _, r := range recs {
+		stabilized = max(stabilized, r.replicas)
+	}
+	return min(stabilized, current)
+}
+
+// forget drops the state that the autoscaler keeps for the ProxyGroup.
+func (a *proxyGroupAutoscaler) forget(uid types.UID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.lastScrape, uid)
+	delete(a.samples, uid)
+	delete(a.recommendations, uid)
+}
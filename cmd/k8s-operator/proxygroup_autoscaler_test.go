@@ -0,0 +1,267 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstest"
+	"tailscale.com/types/ptr"
+)
+
+func TestDesiredReplicas(t *testing.T) {
+	conns := &tsapi.ProxyGroupAutoscaling{
+		MinReplicas:                 ptr.To[int32](2),
+		MaxReplicas:                 5,
+		TargetConnectionsPerReplica: ptr.To[int64](100),
+	}
+	both := conns.DeepCopy()
+	both.TargetThroughputPerReplica = ptr.To(resource.MustParse("1Mi"))
+
+	tests := []struct {
+		name    string
+		as      *tsapi.ProxyGroupAutoscaling
+		current int32
+		load    proxyGroupLoad
+		want    int32
+	}{
+		{
+			name:    "no_metrics",
+			as:      conns,
+			current: 3,
+			want:    3,
+		},
+		{
+			name:    "at_target",
+			as:      conns,
+			current: 3,
+			load:    proxyGroupLoad{connsReplicas: 3, conns: 300},
+			want:    3,
+		},
+		{
+			name:    "within_tolerance",
+			as:      conns,
+			current: 3,
+			load:    proxyGroupLoad{connsReplicas: 3, conns: 320},
+			want:    3,
+		},
+		{
+			name:    "scale_up",
+			as:      conns,
+			current: 3,
+			load:    proxyGroupLoad{connsReplicas: 3, conns: 390},
+			want:    4,
+		},
+		{
+			name:    "scale_up_capped_at_max",
+			as:      conns,
+			current: 3,
+			load:    proxyGroupLoad{connsReplicas: 3, conns: 3000},
+			want:    5,
+		},
+		{
+			name:    "scale_down",
+			as:      conns,
+			current: 4,
+			load:    proxyGroupLoad{connsReplicas: 4, conns: 250},
+			want:    3,
+		},
+		{
+			name:    "scale_down_capped_at_min",
+			as:      conns,
+			current: 4,
+			load:    proxyGroupLoad{connsReplicas: 4},
+			want:    2,
+		},
+		{
+			name:    "highest_of_both_targets",
+			as:      both,
+			current: 2,
+			load: proxyGroupLoad{
+				connsReplicas:      2,
+				conns:              200,
+				throughputReplicas: 2,
+				throughput:         4 << 20,
+			},
+			want: 4,
+		},
+		{
+			name:    "throughput_without_target_ignored",
+			as:      conns,
+			current: 2,
+			load: proxyGroupLoad{
+				connsReplicas:      2,
+				conns:              200,
+				throughputReplicas: 2,
+				throughput:         4 << 20,
+			},
+			want: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := desiredReplicas(tt.as, tt.current, tt.load); got != tt.want {
+				t.Errorf("desiredReplicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyGroupAutoscaler(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			UID:  "pg-uid",
+		},
+		Spec: tsapi.ProxyGroupSpec{
+			Type: tsapi.ProxyGroupTypeEgress,
+			Autoscaling: &tsapi.ProxyGroupAutoscaling{
+				MaxReplicas:                  4,
+				TargetConnectionsPerReplica:  ptr.To[int64](100),
+				TargetThroughputPerReplica:   ptr.To(resource.MustParse("1Mi")),
+				ScaleDownStabilizationWindow: &metav1.Duration{Duration: time.Minute},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg).
+		WithStatusSubresource(pg).
+		Build()
+	zl, _ := zap.NewDevelopment()
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	httpCl := &fakeHTTPClient{t: t}
+	a := &proxyGroupAutoscaler{
+		Client:      fc,
+		logger:      zl.Sugar(),
+		clock:       cl,
+		tsNamespace: tsNamespace,
+		httpClient:  httpCl,
+	}
+
+	mustCreatePod := func(i int) {
+		t.Helper()
+		mustCreate(t, fc, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("test-%d", i),
+				Namespace: tsNamespace,
+				Labels:    pgLabels(pg.Name, nil),
+				UID:       types.UID(fmt.Sprintf("pod-uid-%d", i)),
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				PodIP: fmt.Sprintf("10.0.0.%d", i),
+			},
+		})
+	}
+	// setMetrics sets the responses of the replicas' metrics endpoints to
+	// the given number of connections and total bytes proxied.
+	setMetrics := func(conns []int, bytes []int) {
+		httpCl.mu.Lock()
+		defer httpCl.mu.Unlock()
+		httpCl.state = make(map[string][]fakeResponse)
+		for i := range conns {
+			body := fmt.Sprintf(`# TYPE tailscaled_inbound_bytes_total counter
+tailscaled_inbound_bytes_total{path="direct_ipv4"} %d
+# TYPE tailscaled_outbound_bytes_total counter
+tailscaled_outbound_bytes_total{path="direct_ipv4"} 0
+# TYPE tailscale_proxy_connections gauge
+tailscale_proxy_connections %d
+`, bytes[i], conns[i])
+			u := fmt.Sprintf("http://10.0.0.%d:9002/metrics", i)
+			httpCl.state[u] = []fakeResponse{{statusCode: http.StatusOK, body: body}}
+		}
+	}
+	expectDesired := func(want int32) *tsapi.ProxyGroupAutoscalingStatus {
+		t.Helper()
+		got := new(tsapi.ProxyGroup)
+		if err := fc.Get(context.Background(), types.NamespacedName{Name: pg.Name}, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Status.Autoscaling == nil {
+			t.Fatalf("autoscaling status not set")
+		}
+		if got.Status.Autoscaling.DesiredReplicas != want {
+			t.Fatalf("desired replicas = %d, want %d", got.Status.Autoscaling.DesiredReplicas, want)
+		}
+		if n := pgReplicas(got); n != want {
+			t.Fatalf("pgReplicas() = %d, want %d", n, want)
+		}
+		return got.Status.Autoscaling
+	}
+
+	// The ProxyGroup starts with the minimum number of replicas.
+	mustCreatePod(0)
+	setMetrics([]int{50}, []int{0})
+	expectRequeue(t, a, "", pg.Name)
+	st := expectDesired(1)
+	if st.CurrentConnectionsPerReplica == nil || *st.CurrentConnectionsPerReplica != 50 {
+		t.Fatalf("unexpected current connections %v", st.CurrentConnectionsPerReplica)
+	}
+	if st.CurrentThroughputPerReplica != nil {
+		t.Fatalf("throughput should not be known after the first scrape, got %v", st.CurrentThroughputPerReplica)
+	}
+
+	// Replicas are not scraped more often than the sync period.
+	expectRequeue(t, a, "", pg.Name)
+
+	// Throughput of 3MiB/s requires 3 replicas.
+	cl.Advance(autoscalingSyncPeriod)
+	setMetrics([]int{50}, []int{3 << 20 * int(autoscalingSyncPeriod.Seconds())})
+	expectRequeue(t, a, "", pg.Name)
+	st = expectDesired(3)
+	if want := resource.MustParse("3Mi"); st.CurrentThroughputPerReplica == nil || st.CurrentThroughputPerReplica.Cmp(want) != 0 {
+		t.Fatalf("unexpected current throughput %v, want %v", st.CurrentThroughputPerReplica, want)
+	}
+	if st.LastScaleTime == nil || !st.LastScaleTime.Time.Equal(cl.Now().Truncate(time.Second)) {
+		t.Fatalf("unexpected last scale time %v", st.LastScaleTime)
+	}
+
+	// Load drops, but not all of the replicas have started up yet, so the
+	// ProxyGroup is not scaled down.
+	mustCreatePod(1)
+	cl.Advance(autoscalingSyncPeriod)
+	setMetrics([]int{10, 10}, []int{3 << 20 * int(autoscalingSyncPeriod.Seconds()), 0})
+	expectRequeue(t, a, "", pg.Name)
+	expectDesired(3)
+
+	// All replicas are running and the load stays low, but the ProxyGroup is
+	// only scaled down once the stabilization window has passed.
+	mustCreatePod(2)
+	cl.Advance(autoscalingSyncPeriod)
+	setMetrics([]int{10, 10, 10}, []int{3 << 20 * int(autoscalingSyncPeriod.Seconds()), 0, 0})
+	expectRequeue(t, a, "", pg.Name)
+	expectDesired(3)
+
+	cl.Advance(time.Minute)
+	setMetrics([]int{10, 10, 10}, []int{3 << 20 * int(autoscalingSyncPeriod.Seconds()), 0, 0})
+	expectRequeue(t, a, "", pg.Name)
+	expectDesired(1)
+
+	// Disabling autoscaling clears the status.
+	mustUpdate(t, fc, "", pg.Name, func(p *tsapi.ProxyGroup) {
+		p.Spec.Autoscaling = nil
+	})
+	expectReconciled(t, a, "", pg.Name)
+	got := new(tsapi.ProxyGroup)
+	if err := fc.Get(context.Background(), types.NamespacedName{Name: pg.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Autoscaling != nil {
+		t.Fatalf("expected autoscaling status to be cleared, got %+v", got.Status.Autoscaling)
+	}
+}
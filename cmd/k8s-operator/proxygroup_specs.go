@@ -69,15 +69,20 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 	}
 	tmpl.Spec.Volumes = func() []corev1.Volume {
 		var volumes []corev1.Volume
-		for i := range pgReplicas(pg) {
-			volumes = append(volumes, corev1.Volume{
+		n, optional := pgConfigVolumes(pg)
+		for i := range n {
+			vol := corev1.Volume{
 				Name: fmt.Sprintf("tailscaledconfig-%d", i),
 				VolumeSource: corev1.VolumeSource{
 					Secret: &corev1.SecretVolumeSource{
 						SecretName: pgConfigSecretName(pg.Name, i),
 					},
 				},
-			})
+			}
+			if optional {
+				vol.VolumeSource.Secret.Optional = ptr.To(true)
+			}
+			volumes = append(volumes, vol)
 		}
 
 		volumes = append(volumes, corev1.Volume{
@@ -103,7 +108,8 @@ func pgStatefulSet(pg *tsapi.ProxyGroup, namespace, image, tsFirewallMode string
 		// TODO(tomhjp): Read config directly from the secret instead. The
 		// mounts change on scaling up/down which causes unnecessary restarts
 		// for pods that haven't meaningfully changed.
-		for i := range pgReplicas(pg) {
+		n, _ := pgConfigVolumes(pg)
+		for i := range n {
 			mounts = append(mounts, corev1.VolumeMount{
 				Name:      fmt.Sprintf("tailscaledconfig-%d", i),
 				ReadOnly:  true,
@@ -372,6 +378,14 @@ func pgOwnerReference(owner *tsapi.ProxyGroup) []metav1.OwnerReference {
 }
 
 func pgReplicas(pg *tsapi.ProxyGroup) int32 {
+	if as := pg.Spec.Autoscaling; as != nil {
+		// The number of replicas of autoscaled ProxyGroups is determined
+		// by the autoscaler and recorded in the status.
+		if pg.Status.Autoscaling == nil {
+			return pgMinReplicas(as)
+		}
+		return min(max(pg.Status.Autoscaling.DesiredReplicas, pgMinReplicas(as)), as.MaxReplicas)
+	}
 	if pg.Spec.Replicas != nil {
 		return *pg.Spec.Replicas
 	}
@@ -379,6 +393,24 @@ func pgReplicas(pg *tsapi.ProxyGroup) int32 {
 	return 2
 }
 
+func pgMinReplicas(as *tsapi.ProxyGroupAutoscaling) int32 {
+	if as.MinReplicas != nil {
+		return *as.MinReplicas
+	}
+	return 1
+}
+
+// pgConfigVolumes returns the number of replicas for which config Secret
+// volumes are mounted. Autoscaled ProxyGroups mount optional volumes for the
+// maximum number of replicas, so that scaling does not change the Pod template
+// and restart the existing replicas.
+func pgConfigVolumes(pg *tsapi.ProxyGroup) (n int32, optional bool) {
+	if pg.Spec.Autoscaling != nil {
+		return pg.Spec.Autoscaling.MaxReplicas, true
+	}
+	return pgReplicas(pg), false
+}
+
 func pgConfigSecretName(pgName string, i int32) string {
 	return fmt.Sprintf("%s-%d-config", pgName, i)
 }
@@ -398,6 +430,28 @@ func hasLocalAddrPortSet(proxyClass *tsapi.ProxyClass) bool {
 	})
 }
 
+// enableMetricsForAutoscaling ensures that the replicas of an autoscaled
+// ProxyGroup serve metrics on their local address, so that the autoscaler can
+// scrape them. It is a no-op if a ProxyClass has already enabled metrics.
+func enableMetricsForAutoscaling(pg *tsapi.ProxyGroup, ss *appsv1.StatefulSet) {
+	if pg.Spec.Autoscaling == nil {
+		return
+	}
+	for i, c := range ss.Spec.Template.Spec.Containers {
+		if c.Name != "tailscale" {
+			continue
+		}
+		if slices.ContainsFunc(c.Env, func(e corev1.EnvVar) bool { return e.Name == "TS_ENABLE_METRICS" }) {
+			return
+		}
+		ss.Spec.Template.Spec.Containers[i].Env = append(ss.Spec.Template.Spec.Containers[i].Env, corev1.EnvVar{
+			Name:  "TS_ENABLE_METRICS",
+			Value: "true",
+		})
+		return
+	}
+}
+
 // hepPings returns the number of times a health check endpoint exposed by a Service fronting ProxyGroup replicas should
 // be pinged to ensure that all currently configured backend replicas are hit.
 func hepPings(pg *tsapi.ProxyGroup) int {
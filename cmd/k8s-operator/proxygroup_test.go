@@ -9,6 +9,7 @@
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 
@@ -249,6 +250,118 @@ func TestProxyGroup(t *testing.T) {
 	})
 }
 
+func TestProxyGroupAutoscaledScaleDown(t *testing.T) {
+	pg := &tsapi.ProxyGroup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Finalizers: []string{"tailscale.com/finalizer"},
+		},
+		Spec: tsapi.ProxyGroupSpec{
+			Type: tsapi.ProxyGroupTypeEgress,
+			Autoscaling: &tsapi.ProxyGroupAutoscaling{
+				MaxReplicas:                 3,
+				TargetConnectionsPerReplica: ptr.To[int64](100),
+			},
+		},
+		Status: tsapi.ProxyGroupStatus{
+			Autoscaling: &tsapi.ProxyGroupAutoscalingStatus{DesiredReplicas: 2},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pg).
+		WithStatusSubresource(pg).
+		Build()
+	tsClient := &fakeTSClient{}
+	zl, _ := zap.NewDevelopment()
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	reconciler := &ProxyGroupReconciler{
+		tsNamespace:    tsNamespace,
+		proxyImage:     testProxyImage,
+		defaultTags:    []string{"tag:test-tag"},
+		tsFirewallMode: "auto",
+
+		Client:   fc,
+		tsClient: tsClient,
+		recorder: record.NewFakeRecorder(1),
+		l:        zl.Sugar(),
+		clock:    cl,
+	}
+
+	expectReconciled(t, reconciler, "", pg.Name)
+	addNodeIDToStateSecrets(t, fc, pg)
+	expectReconciled(t, reconciler, "", pg.Name)
+	expectSecrets(t, fc, []string{"test-0", "test-0-config", "test-1", "test-1-config"})
+
+	// Config Secrets are mounted as optional volumes for the maximum number
+	// of replicas, so that scaling does not restart existing replicas, and
+	// metrics are enabled for the autoscaler to scrape.
+	ss := &appsv1.StatefulSet{}
+	if err := fc.Get(context.Background(), client.ObjectKey{Namespace: tsNamespace, Name: pg.Name}, ss); err != nil {
+		t.Fatal(err)
+	}
+	if got := *ss.Spec.Replicas; got != 2 {
+		t.Fatalf("StatefulSet replicas = %d, want 2", got)
+	}
+	var cfgVolumes int
+	for _, v := range ss.Spec.Template.Spec.Volumes {
+		if v.Secret != nil {
+			cfgVolumes++
+			if v.Secret.Optional == nil || !*v.Secret.Optional {
+				t.Errorf("expected config Secret volume %s to be optional", v.Name)
+			}
+		}
+	}
+	if cfgVolumes != 3 {
+		t.Fatalf("got %d config Secret volumes, want 3", cfgVolumes)
+	}
+	if !slices.ContainsFunc(ss.Spec.Template.Spec.Containers[0].Env, func(e corev1.EnvVar) bool {
+		return e.Name == "TS_ENABLE_METRICS" && e.Value == "true"
+	}) {
+		t.Fatalf("expected metrics to be enabled, got env %v", ss.Spec.Template.Spec.Containers[0].Env)
+	}
+
+	// The autoscaler scales the ProxyGroup down while the removed replica's
+	// Pod is still draining.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-1",
+			Namespace: tsNamespace,
+			UID:       "pod-uid-1",
+		},
+	}
+	mustCreate(t, fc, pod)
+	mustUpdateStatus(t, fc, "", pg.Name, func(p *tsapi.ProxyGroup) {
+		p.Status.Autoscaling.DesiredReplicas = 1
+	})
+	expectRequeue(t, reconciler, "", pg.Name)
+	if err := fc.Get(context.Background(), client.ObjectKey{Namespace: tsNamespace, Name: pg.Name}, ss); err != nil {
+		t.Fatal(err)
+	}
+	if got := *ss.Spec.Replicas; got != 1 {
+		t.Fatalf("StatefulSet replicas = %d, want 1", got)
+	}
+	if len(tsClient.deleted) != 0 {
+		t.Fatalf("expected no devices to be deleted while the Pod is draining, got %v", tsClient.deleted)
+	}
+	expectSecrets(t, fc, []string{"test-0", "test-0-config", "test-1", "test-1-config"})
+	got := &tsapi.ProxyGroup{}
+	if err := fc.Get(context.Background(), client.ObjectKey{Name: pg.Name}, got); err != nil {
+		t.Fatal(err)
+	}
+	if !tsoperator.ProxyGroupIsReady(got) {
+		t.Fatalf("expected autoscaled ProxyGroup to remain ready while scaling, got conditions %v", got.Status.Conditions)
+	}
+
+	// Once the Pod has shut down, its device and state get cleaned up.
+	mustDeleteAll(t, fc, pod)
+	expectReconciled(t, reconciler, "", pg.Name)
+	if diff := cmp.Diff(tsClient.deleted, []string{"nodeid-1"}); diff != "" {
+		t.Fatalf("unexpected deleted devices (-got +want):\n%s", diff)
+	}
+	expectSecrets(t, fc, []string{"test-0", "test-0-config"})
+}
+
 func TestProxyGroupTypes(t *testing.T) {
 	pc := &tsapi.ProxyClass{
 		ObjectMeta: metav1.ObjectMeta{
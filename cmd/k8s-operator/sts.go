@@ -6,6 +6,7 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/sha256"
 	_ "embed"
@@ -25,6 +26,7 @@
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apiserver/pkg/storage/names"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
@@ -65,6 +67,14 @@
 	AnnotationTailnetTargetFQDN = "tailscale.com/tailnet-fqdn"
 
 	AnnotationProxyGroup = "tailscale.com/proxy-group"
+	// Comma-separated tailnet IPs or MagicDNS names of nodes, each optionally
+	// followed by "=<weight>", that connections to a ProxyGroup egress Service
+	// are load balanced across. Requires the proxies to use nftables.
+	AnnotationTailnetTargetBackends = "tailscale.com/tailnet-backends"
+	// If set to "true", ProxyGroup egress proxies keep routing established
+	// connections to backends that are removed from
+	// tailscale.com/tailnet-backends until the connections close.
+	AnnotationDrainBackends = "tailscale.com/drain-backends"
 
 	// Annotations settable by users on ingresses.
 	AnnotationFunnel = "tailscale.com/funnel"
@@ -141,6 +151,18 @@ type tailscaleSTSConfig struct {
 	ProxyClassName string // name of ProxyClass if one needs to be applied to the proxy
 
 	ProxyClass *tsapi.ProxyClass // ProxyClass that needs to be applied to the proxy (if there is one)
+
+	// Replicas is the number of replicas of the proxy. If nil, a single
+	// replica is run.
+	Replicas *int32
+}
+
+// replicas returns the number of replicas the proxy StatefulSet should run.
+func (c *tailscaleSTSConfig) replicas() int32 {
+	if c.Replicas == nil {
+		return 1
+	}
+	return *c.Replicas
 }
 
 type connector struct {
@@ -201,14 +223,34 @@ func (a *tailscaleSTSReconciler) Provision(ctx context.Context, logger *zap.Suga
 	}
 	sts.ProxyClass = proxyClass
 
-	secretName, tsConfigHash, _, err := a.createOrGetSecret(ctx, logger, sts, hsvc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create or get API key secret: %w", err)
+	// The config hash and Secret of the first replica are used for the
+	// StatefulSet of single replica proxies.
+	secretName, tsConfigHash := replicaSecretName(hsvc, 0), ""
+	replicaConfigs := make(map[string]tailscaledConfigs)
+	for i := range sts.replicas() {
+		name, hash, configs, err := a.createOrGetSecret(ctx, logger, sts, hsvc, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create or get API key secret: %w", err)
+		}
+		if i == 0 {
+			secretName, tsConfigHash = name, hash
+		}
+		if configs != nil {
+			replicaConfigs[name] = configs
+		}
+	}
+	if sts.replicas() > 1 {
+		if err := a.reconcileReplicaConfigSecret(ctx, sts, hsvc, replicaConfigs); err != nil {
+			return nil, fmt.Errorf("failed to reconcile replica config Secret: %w", err)
+		}
 	}
 	_, err = a.reconcileSTS(ctx, logger, sts, hsvc, secretName, tsConfigHash)
 	if err != nil {
 		return nil, fmt.Errorf("failed to reconcile statefulset: %w", err)
 	}
+	if err := a.cleanupExcessReplicas(ctx, logger, sts, hsvc); err != nil {
+		return nil, fmt.Errorf("failed to clean up resources of removed replicas: %w", err)
+	}
 	mo := &metricsOpts{
 		proxyStsName: hsvc.Name,
 		tsNamespace:  hsvc.Namespace,
@@ -250,21 +292,17 @@ func (a *tailscaleSTSReconciler) Cleanup(ctx context.Context, logger *zap.Sugare
 		return false, nil
 	}
 
-	dev, err := a.DeviceInfo(ctx, labels, logger)
-	if err != nil {
-		return false, fmt.Errorf("getting device info: %w", err)
-	}
-	if dev != nil && dev.id != "" {
-		logger.Debugf("deleting device %s from control", string(dev.id))
-		if err := a.tsClient.DeleteDevice(ctx, string(dev.id)); err != nil {
-			errResp := &tailscale.ErrResponse{}
-			if ok := errors.As(err, errResp); ok && errResp.Status == http.StatusNotFound {
-				logger.Debugf("device %s not found, likely because it has already been deleted from control", string(dev.id))
-			} else {
-				return false, fmt.Errorf("deleting device: %w", err)
-			}
-		} else {
-			logger.Debugf("device %s deleted from control", string(dev.id))
+	secrets := &corev1.SecretList{}
+	if err := a.List(ctx, secrets, client.InNamespace(a.operatorNamespace), client.MatchingLabels(labels)); err != nil {
+		return false, fmt.Errorf("listing proxy state Secrets: %w", err)
+	}
+	for _, sec := range secrets.Items {
+		dev, err := deviceInfo(&sec, "", logger)
+		if err != nil {
+			return false, fmt.Errorf("getting device info: %w", err)
+		}
+		if err := a.deleteDevice(ctx, logger, dev); err != nil {
+			return false, err
 		}
 	}
 
@@ -315,6 +353,15 @@ func statefulSetNameBase(parent string) string {
 	}
 }
 
+// namespacedName returns <ns>-<name>-<n>, where n is the length of ns, for use
+// as the name of a tailnet resource derived from a namespaced cluster
+// resource. Joining ns and name alone would be ambiguous, as both can contain
+// dashes: a-b/c and a/b-c would both become a-b-c. With the length of the
+// namespace, distinct namespace and name pairs always get distinct names.
+func namespacedName(ns, name string) string {
+	return fmt.Sprintf("%s-%s-%d", ns, name, len(ns))
+}
+
 func (a *tailscaleSTSReconciler) reconcileHeadlessService(ctx context.Context, logger *zap.SugaredLogger, sts *tailscaleSTSConfig) (*corev1.Service, error) {
 	nameBase := statefulSetNameBase(sts.ParentResourceName)
 	hsvc := &corev1.Service{
@@ -335,13 +382,142 @@ func (a *tailscaleSTSReconciler) reconcileHeadlessService(ctx context.Context, l
 	return createOrUpdate(ctx, a.Client, a.operatorNamespace, hsvc, func(svc *corev1.Service) { svc.Spec = hsvc.Spec })
 }
 
-func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service) (secretName, hash string, configs tailscaledConfigs, _ error) {
+// deleteDevice deletes the given proxy device from control, if it is known.
+func (a *tailscaleSTSReconciler) deleteDevice(ctx context.Context, logger *zap.SugaredLogger, dev *device) error {
+	if dev == nil || dev.id == "" {
+		return nil
+	}
+	logger.Debugf("deleting device %s from control", string(dev.id))
+	if err := a.tsClient.DeleteDevice(ctx, string(dev.id)); err != nil {
+		errResp := &tailscale.ErrResponse{}
+		if ok := errors.As(err, errResp); ok && errResp.Status == http.StatusNotFound {
+			logger.Debugf("device %s not found, likely because it has already been deleted from control", string(dev.id))
+		} else {
+			return fmt.Errorf("deleting device: %w", err)
+		}
+	} else {
+		logger.Debugf("device %s deleted from control", string(dev.id))
+	}
+	return nil
+}
+
+// replicaSecretName returns the name of the state and config Secret of the
+// proxy replica with the given index. It matches the name of the replica's
+// Pod.
+func replicaSecretName(hsvc *corev1.Service, replica int32) string {
+	return fmt.Sprintf("%s-%d", hsvc.Name, replica)
+}
+
+// replicaConfigSecretName returns the name of the Secret that holds the
+// tailscaled configs of all replicas of a multi-replica proxy.
+func replicaConfigSecretName(hsvc *corev1.Service) string {
+	return hsvc.Name + "-config"
+}
+
+// reconcileReplicaConfigSecret ensures that the config Secret of a
+// multi-replica proxy holds the tailscaled configs of its replicas, keyed by
+// the replica's state Secret name, which is also its Pod name.
+//
+// All replicas mount the same Secret and pick their config with the
+// TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX of containerboot, so that the Pod
+// template doesn't depend on the number of replicas, and scaling the proxy
+// doesn't restart the existing replicas.
+func (a *tailscaleSTSReconciler) reconcileReplicaConfigSecret(ctx context.Context, stsC *tailscaleSTSConfig, hsvc *corev1.Service, replicaConfigs map[string]tailscaledConfigs) error {
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			// Hardcode a -0 suffix so that in future, if we support
-			// multiple StatefulSet replicas, we can provision -N for
-			// those.
-			Name:      hsvc.Name + "-0",
+			Name:      replicaConfigSecretName(hsvc),
+			Namespace: a.operatorNamespace,
+			Labels:    stsC.ChildResourceLabels,
+		},
+	}
+	for name, configs := range replicaConfigs {
+		for cv, cfg := range configs {
+			b, err := json.Marshal(cfg)
+			if err != nil {
+				return fmt.Errorf("error marshalling tailscaled config: %w", err)
+			}
+			mak.Set(&secret.Data, name+"."+tsoperator.TailscaledConfigFileName(cv), b)
+		}
+	}
+	_, err := createOrUpdate(ctx, a.Client, a.operatorNamespace, secret, func(s *corev1.Secret) {
+		s.Labels = secret.Labels
+		s.Data = secret.Data
+	})
+	return err
+}
+
+// replicaIndex parses the replica index from the name of a proxy state
+// Secret. It returns -1 if the name is not that of a replica's Secret.
+func replicaIndex(hsvc *corev1.Service, secretName string) int32 {
+	suffix, ok := strings.CutPrefix(secretName, hsvc.Name+"-")
+	if !ok {
+		return -1
+	}
+	i, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil {
+		return -1
+	}
+	return int32(i)
+}
+
+// cleanupExcessReplicas deletes the devices and state Secrets of proxy
+// replicas that have been scaled down. A replica's resources are only deleted
+// once its Pod is gone, so that it keeps routing traffic until it has shut
+// down.
+func (a *tailscaleSTSReconciler) cleanupExcessReplicas(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service) error {
+	secrets := &corev1.SecretList{}
+	if err := a.List(ctx, secrets, client.InNamespace(a.operatorNamespace), client.MatchingLabels(stsC.ChildResourceLabels)); err != nil {
+		return fmt.Errorf("error listing proxy state Secrets: %w", err)
+	}
+	for _, sec := range secrets.Items {
+		if sec.Name == replicaConfigSecretName(hsvc) {
+			if stsC.replicas() <= 1 {
+				if err := a.Delete(ctx, &sec); err != nil && !apierrors.IsNotFound(err) {
+					return fmt.Errorf("error deleting Secret %s: %w", sec.Name, err)
+				}
+			}
+			continue
+		}
+		if i := replicaIndex(hsvc, sec.Name); i < stsC.replicas() {
+			continue
+		}
+		pod := &corev1.Pod{}
+		if err := a.Get(ctx, client.ObjectKeyFromObject(&sec), pod); err == nil {
+			logger.Debugf("waiting for Pod %s of a removed replica to terminate", pod.Name)
+			continue
+		} else if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error getting Pod %s: %w", sec.Name, err)
+		}
+		dev, err := deviceInfo(&sec, "", logger)
+		if err != nil {
+			return fmt.Errorf("error getting device info: %w", err)
+		}
+		if err := a.deleteDevice(ctx, logger, dev); err != nil {
+			return err
+		}
+		logger.Infof("deleting state Secret %s of a removed replica", sec.Name)
+		if err := a.Delete(ctx, &sec); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("error deleting Secret %s: %w", sec.Name, err)
+		}
+	}
+	return nil
+}
+
+// stsReplicas returns the number of replicas of the given StatefulSet.
+func stsReplicas(sts *appsv1.StatefulSet) int32 {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+	return *sts.Spec.Replicas
+}
+
+// createOrGetSecret ensures that the state and config Secret of the proxy
+// replica with the given index exists and contains up to date tailscaled
+// config.
+func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *zap.SugaredLogger, stsC *tailscaleSTSConfig, hsvc *corev1.Service, replica int32) (secretName, hash string, configs tailscaledConfigs, _ error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      replicaSecretName(hsvc, replica),
 			Namespace: a.operatorNamespace,
 			Labels:    stsC.ChildResourceLabels,
 		},
@@ -363,9 +539,10 @@ func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *
 		if err != nil {
 			return "", "", nil, err
 		}
-		if sts != nil {
-			// StatefulSet exists, so we have already created the secret.
-			// If the secret is missing, they should delete the StatefulSet.
+		if sts != nil && replica < stsReplicas(sts) {
+			// StatefulSet exists and runs this replica, so we have
+			// already created the secret. If the secret is missing,
+			// they should delete the StatefulSet.
 			logger.Errorf("Tailscale proxy secret doesn't exist, but the corresponding StatefulSet %s/%s already does. Something is wrong, please delete the StatefulSet.", sts.GetNamespace(), sts.GetName())
 			return "", "", nil, nil
 		}
@@ -381,7 +558,12 @@ func (a *tailscaleSTSReconciler) createOrGetSecret(ctx context.Context, logger *
 			return "", "", nil, err
 		}
 	}
-	configs, err := tailscaledConfig(stsC, authKey, orig)
+	replicaC := stsC
+	if stsC.replicas() > 1 {
+		replicaC = ptr.To(*stsC)
+		replicaC.Hostname = fmt.Sprintf("%s-%d", stsC.Hostname, replica)
+	}
+	configs, err := tailscaledConfig(replicaC, authKey, orig)
 	if err != nil {
 		return "", "", nil, fmt.Errorf("error creating tailscaled config: %w", err)
 	}
@@ -452,10 +634,40 @@ func (a *tailscaleSTSReconciler) DeviceInfo(ctx context.Context, childLabels map
 	if sec == nil {
 		return dev, nil
 	}
+	return a.secretDeviceInfo(ctx, sec, logger)
+}
+
+// ReplicaDeviceInfo returns the devices of all replicas of the operator proxy
+// with the given labels, ordered by replica index. Replicas whose device is not
+// (yet) known are omitted.
+func (a *tailscaleSTSReconciler) ReplicaDeviceInfo(ctx context.Context, childLabels map[string]string, logger *zap.SugaredLogger) (devs []*device, _ error) {
+	secrets := &corev1.SecretList{}
+	if err := a.List(ctx, secrets, client.InNamespace(a.operatorNamespace), client.MatchingLabels(childLabels)); err != nil {
+		return nil, err
+	}
+	// Names of the replica Secrets only differ in the index suffix.
+	slices.SortFunc(secrets.Items, func(a, b corev1.Secret) int {
+		return cmp.Or(cmp.Compare(len(a.Name), len(b.Name)), strings.Compare(a.Name, b.Name))
+	})
+	for _, sec := range secrets.Items {
+		dev, err := a.secretDeviceInfo(ctx, &sec, logger)
+		if err != nil {
+			return nil, err
+		}
+		if dev != nil {
+			devs = append(devs, dev)
+		}
+	}
+	return devs, nil
+}
+
+// secretDeviceInfo returns device info from the given proxy state Secret,
+// cross-validating the capver against the Pod with the same name.
+func (a *tailscaleSTSReconciler) secretDeviceInfo(ctx context.Context, sec *corev1.Secret, logger *zap.SugaredLogger) (*device, error) {
 	podUID := ""
 	pod := new(corev1.Pod)
 	if err := a.Get(ctx, types.NamespacedName{Namespace: sec.Namespace, Name: sec.Name}, pod); err != nil && !apierrors.IsNotFound(err) {
-		return dev, err
+		return nil, err
 	} else if err == nil {
 		podUID = string(pod.ObjectMeta.UID)
 	}
@@ -564,6 +776,7 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		mak.Set(&ss.ObjectMeta.Labels, key, val)
 	}
 	ss.Spec.ServiceName = headlessSvc.Name
+	ss.Spec.Replicas = ptr.To(sts.replicas())
 	ss.Spec.Selector = &metav1.LabelSelector{
 		MatchLabels: map[string]string{
 			"app": sts.ParentResourceUID,
@@ -575,17 +788,37 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 	}
 
 	// Generic containerboot configuration options.
-	container.Env = append(container.Env,
-		corev1.EnvVar{
-			Name:  "TS_KUBE_SECRET",
-			Value: proxySecret,
-		},
-		corev1.EnvVar{
-			// New style is in the form of cap-<capability-version>.hujson.
-			Name:  "TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR",
-			Value: "/etc/tsconfig",
-		},
-	)
+	if sts.replicas() > 1 {
+		// Each replica stores its state in the Secret with the same name
+		// as its Pod, and reads its tailscaled config from the files
+		// prefixed with its Pod name in the shared config Secret.
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "TS_KUBE_SECRET",
+				Value: "$(POD_NAME)",
+			},
+			corev1.EnvVar{
+				Name:  "TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR",
+				Value: "/etc/tsconfig",
+			},
+			corev1.EnvVar{
+				Name:  "TS_EXPERIMENTAL_VERSIONED_CONFIG_PREFIX",
+				Value: "$(POD_NAME).",
+			},
+		)
+	} else {
+		container.Env = append(container.Env,
+			corev1.EnvVar{
+				Name:  "TS_KUBE_SECRET",
+				Value: proxySecret,
+			},
+			corev1.EnvVar{
+				// New style is in the form of cap-<capability-version>.hujson.
+				Name:  "TS_EXPERIMENTAL_VERSIONED_CONFIG_DIR",
+				Value: "/etc/tsconfig",
+			},
+		)
+	}
 	if sts.ForwardClusterTrafficViaL7IngressProxy {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  "EXPERIMENTAL_ALLOW_PROXYING_CLUSTER_TRAFFIC_VIA_INGRESS",
@@ -593,20 +826,55 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		})
 	}
 
-	configVolume := corev1.Volume{
-		Name: "tailscaledconfig",
-		VolumeSource: corev1.VolumeSource{
-			Secret: &corev1.SecretVolumeSource{
-				SecretName: proxySecret,
+	if sts.replicas() > 1 {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "tailscaledconfig",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: replicaConfigSecretName(headlessSvc),
+				},
 			},
-		},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "tailscaledconfig",
+			ReadOnly:  true,
+			MountPath: "/etc/tsconfig",
+		})
+		// StatefulSets are updated one Pod at a time, and the next Pod is
+		// only replaced once the updated one is Ready. Mark replicas
+		// Ready once they are connected to the tailnet, so that a rolling
+		// update never takes down all replicas at once.
+		if !hasLocalAddrPortSet(sts.ProxyClass) {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  "TS_ENABLE_HEALTH_CHECK",
+				Value: "true",
+			})
+			container.ReadinessProbe = &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{
+						Path: "/healthz",
+						Port: intstr.FromInt(defaultLocalAddrPort),
+					},
+				},
+				PeriodSeconds: 5,
+			}
+		}
+	} else {
+		configVolume := corev1.Volume{
+			Name: "tailscaledconfig",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: proxySecret,
+				},
+			},
+		}
+		pod.Spec.Volumes = append(ss.Spec.Template.Spec.Volumes, configVolume)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "tailscaledconfig",
+			ReadOnly:  true,
+			MountPath: "/etc/tsconfig",
+		})
 	}
-	pod.Spec.Volumes = append(ss.Spec.Template.Spec.Volumes, configVolume)
-	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
-		Name:      "tailscaledconfig",
-		ReadOnly:  true,
-		MountPath: "/etc/tsconfig",
-	})
 
 	if a.tsFirewallMode != "" {
 		container.Env = append(container.Env, corev1.EnvVar{
@@ -662,9 +930,14 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		})
 	}
 
-	dev, err := a.DeviceInfo(ctx, sts.ChildResourceLabels, logger)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get device info: %w", err)
+	var dev *device
+	sec := &corev1.Secret{}
+	if err := a.Get(ctx, types.NamespacedName{Namespace: a.operatorNamespace, Name: replicaSecretName(headlessSvc, 0)}, sec); err == nil {
+		if dev, err = a.secretDeviceInfo(ctx, sec, logger); err != nil {
+			return nil, fmt.Errorf("failed to get device info: %w", err)
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get proxy state Secret: %w", err)
 	}
 
 	app, err := appInfoForProxy(sts)
@@ -53,6 +53,27 @@ func Test_statefulSetNameBase(t *testing.T) {
 	}
 }
 
+func Test_namespacedName(t *testing.T) {
+	if got, want := namespacedName("default", "test"), "default-test-7"; got != want {
+		t.Errorf("namespacedName = %q, want %q", got, want)
+	}
+	// Every way of splitting a dashed string into a namespace and a name
+	// must give a different result.
+	seen := make(map[string]string)
+	const s = "a-b-c-d"
+	for i, c := range s {
+		if c != '-' {
+			continue
+		}
+		ns, name := s[:i], s[i+1:]
+		got := namespacedName(ns, name)
+		if prev, ok := seen[got]; ok {
+			t.Errorf("namespacedName(%q, %q) = %q, same as for %s", ns, name, got, prev)
+		}
+		seen[got] = ns + "/" + name
+	}
+}
+
 func Test_applyProxyClassToStatefulSet(t *testing.T) {
 	zl, err := zap.NewDevelopment()
 	if err != nil {
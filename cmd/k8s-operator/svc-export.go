@@ -0,0 +1,271 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/util/mak"
+)
+
+const (
+	reasonServiceExportInvalid = "ServiceExportInvalid"
+	reasonServiceExportPending = "ServiceExportPending"
+	reasonServiceExported      = "ServiceExported"
+
+	// exportedPortsAnnotation is set on the Tailscale Service of an exported
+	// Service. It contains a JSON encoded list of the ports of the exported
+	// Service, which is used by ServiceImports that don't specify ports.
+	exportedPortsAnnotation = "tailscale.com/exported-ports"
+)
+
+// ServiceExportReconciler reconciles ServiceExport resources. For each
+// ServiceExport, it creates a ClusterIP Service that selects the same Pods as
+// the exported Service and is annotated to be exposed on the ingress
+// ProxyGroup as a Tailscale Service. The Tailscale Service itself is managed
+// by the HAServiceReconciler.
+type ServiceExportReconciler struct {
+	client.Client
+	logger   *zap.SugaredLogger
+	recorder record.EventRecorder
+	clock    tstime.Clock
+	tsClient tsClient
+}
+
+func (r *ServiceExportReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("ServiceExport", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	se := new(tsapi.ServiceExport)
+	err = r.Get(ctx, req.NamespacedName, se)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ServiceExport not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get tailscale.com ServiceExport: %w", err)
+	}
+	if markedForDeletion(se) {
+		// The generated Service is owned by the ServiceExport and gets
+		// garbage collected, which in turn cleans up the Tailscale Service.
+		logger.Debugf("ServiceExport is being deleted")
+		return res, nil
+	}
+
+	oldStatus := se.Status.DeepCopy()
+	defer func() {
+		if !apiequality.Semantic.DeepEqual(oldStatus, &se.Status) {
+			// An error encountered here should get returned by the Reconcile function.
+			err = errors.Join(err, r.Client.Status().Update(ctx, se))
+		}
+	}()
+	setReady := func(status metav1.ConditionStatus, reason, msg string) {
+		tsoperator.SetServiceExportCondition(se, tsapi.ServiceExportReady, status, reason, msg, se.Generation, r.clock, logger)
+	}
+
+	svc := new(corev1.Service)
+	if err := r.Get(ctx, req.NamespacedName, svc); apierrors.IsNotFound(err) {
+		msg := fmt.Sprintf("Service %s not found", req.NamespacedName)
+		logger.Info(msg)
+		setReady(metav1.ConditionFalse, reasonServiceExportInvalid, msg)
+		se.Status.TailscaleService = ""
+		return res, r.deleteExportedService(ctx, se)
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get Service: %w", err)
+	}
+	if violations := validateExportedService(svc); len(violations) > 0 {
+		msg := fmt.Sprintf("Service cannot be exported: %s", strings.Join(violations, ", "))
+		r.recorder.Event(se, corev1.EventTypeWarning, reasonServiceExportInvalid, msg)
+		setReady(metav1.ConditionFalse, reasonServiceExportInvalid, msg)
+		se.Status.TailscaleService = ""
+		return res, r.deleteExportedService(ctx, se)
+	}
+
+	desired := exportedService(se, svc)
+	exported, err := createOrUpdate(ctx, r.Client, se.Namespace, desired, func(s *corev1.Service) {
+		s.Labels = desired.Labels
+		s.Annotations = desired.Annotations
+		s.Spec.Selector = desired.Spec.Selector
+		s.Spec.Ports = desired.Spec.Ports
+	})
+	if err != nil {
+		return res, fmt.Errorf("error ensuring exported Service: %w", err)
+	}
+
+	// The HAServiceReconciler sets the IngressSvcConfigured condition once
+	// the Tailscale Service has been created and at least one proxy is
+	// advertising it.
+	if cond := tsoperator.GetServiceCondition(exported, tsapi.IngressSvcConfigured); cond == nil || cond.Status != metav1.ConditionTrue {
+		if cond := tsoperator.GetServiceCondition(exported, tsapi.IngressSvcValid); cond != nil && cond.Status == metav1.ConditionFalse {
+			setReady(metav1.ConditionFalse, reasonServiceExportInvalid, cond.Message)
+			return res, nil
+		}
+		msg := fmt.Sprintf("waiting for the Service to be exposed on ProxyGroup %q", se.Spec.ProxyGroup)
+		if cond != nil {
+			msg = cond.Message
+		}
+		setReady(metav1.ConditionFalse, reasonServiceExportPending, msg)
+		return res, nil
+	}
+
+	serviceName := tailcfg.ServiceName("svc:" + nameForService(exported))
+	if err := r.ensureExportedPorts(ctx, serviceName, svc); err != nil {
+		if isErrorFeatureFlagNotEnabled(err) {
+			logger.Warn(msgFeatureFlagNotEnabled)
+			setReady(metav1.ConditionFalse, reasonServiceExportPending, msgFeatureFlagNotEnabled)
+			return res, nil
+		}
+		return res, fmt.Errorf("error publishing exported ports: %w", err)
+	}
+	se.Status.TailscaleService = serviceName.String()
+	setReady(metav1.ConditionTrue, reasonServiceExported, fmt.Sprintf("Service is exported as Tailscale Service %s", serviceName))
+	return res, nil
+}
+
+// ensureExportedPorts records the ports of the exported Service on the
+// Tailscale Service, so that importing clusters can create a Service with the
+// same ports. If the same Service is exported from multiple clusters, the
+// ports of the Service in each cluster are expected to be the same.
+func (r *ServiceExportReconciler) ensureExportedPorts(ctx context.Context, name tailcfg.ServiceName, svc *corev1.Service) error {
+	tsSvc, err := r.tsClient.GetVIPService(ctx, name)
+	if isErrorTailscaleServiceNotFound(err) {
+		// The Tailscale Service is created by the HAServiceReconciler;
+		// we will be requeued once it has updated the Service status.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ports := make([]tsapi.ServiceImportPort, 0, len(svc.Spec.Ports))
+	for _, p := range svc.Spec.Ports {
+		ports = append(ports, tsapi.ServiceImportPort{
+			Name:     p.Name,
+			Protocol: p.Protocol,
+			Port:     p.Port,
+		})
+	}
+	b, err := json.Marshal(ports)
+	if err != nil {
+		return fmt.Errorf("error marshalling exported ports: %w", err)
+	}
+	if tsSvc.Annotations[exportedPortsAnnotation] == string(b) {
+		return nil
+	}
+	mak.Set(&tsSvc.Annotations, exportedPortsAnnotation, string(b))
+	return r.tsClient.CreateOrUpdateVIPService(ctx, tsSvc)
+}
+
+// deleteExportedService deletes the Service generated for the ServiceExport,
+// if any.
+func (r *ServiceExportReconciler) deleteExportedService(ctx context.Context, se *tsapi.ServiceExport) error {
+	svc, err := getSingleObject[corev1.Service](ctx, r.Client, se.Namespace, childResourceLabels(se.Name, se.Namespace, "serviceexport"))
+	if err != nil {
+		return fmt.Errorf("error getting exported Service: %w", err)
+	}
+	if svc == nil {
+		return nil
+	}
+	if err := r.Delete(ctx, svc); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error deleting exported Service: %w", err)
+	}
+	return nil
+}
+
+// exportedServiceName returns the name of the Tailscale Service that the
+// Service name in namespace ns is exported as, derived with namespacedName.
+// ServiceImports in other clusters find it by the Service name and namespace
+// alone.
+func exportedServiceName(ns, name string) tailcfg.ServiceName {
+	return tailcfg.ServiceName("svc:" + namespacedName(ns, name))
+}
+
+// exportedService returns the ClusterIP Service that exposes the Pods of svc
+// on the ServiceExport's ProxyGroup as the Tailscale Service named by
+// exportedServiceName.
+func exportedService(se *tsapi.ServiceExport, svc *corev1.Service) *corev1.Service {
+	ports := make([]corev1.ServicePort, 0, len(svc.Spec.Ports))
+	for _, p := range svc.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:        p.Name,
+			Protocol:    p.Protocol,
+			AppProtocol: p.AppProtocol,
+			Port:        p.Port,
+			TargetPort:  p.TargetPort,
+		})
+	}
+	annots := map[string]string{
+		AnnotationExpose:     "true",
+		AnnotationProxyGroup: se.Spec.ProxyGroup,
+		AnnotationHostname:   exportedServiceName(se.Namespace, se.Name).WithoutPrefix(),
+	}
+	if len(se.Spec.Tags) > 0 {
+		annots[AnnotationTags] = strings.Join(se.Spec.Tags.Stringify(), ",")
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    statefulSetNameBase(se.Name),
+			Namespace:       se.Namespace,
+			Labels:          childResourceLabels(se.Name, se.Namespace, "serviceexport"),
+			Annotations:     annots,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(se, tsapi.SchemeGroupVersion.WithKind(tsapi.ServiceExportKind))},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: svc.Spec.Selector,
+			Ports:    ports,
+		},
+	}
+}
+
+func validateExportedService(svc *corev1.Service) []string {
+	var violations []string
+	if svc.Spec.Type != "" && svc.Spec.Type != corev1.ServiceTypeClusterIP && svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		violations = append(violations, fmt.Sprintf("Service of type %s cannot be exported", svc.Spec.Type))
+	}
+	if len(svc.Spec.Selector) == 0 {
+		violations = append(violations, "Service must have a selector")
+	}
+	if len(svc.Spec.Ports) == 0 {
+		violations = append(violations, "Service must have at least one port")
+	}
+	return violations
+}
+
+// serviceExportsFromService returns a handler that, for a Service generated
+// for a ServiceExport, enqueues the ServiceExport and for any other Service,
+// enqueues the ServiceExport with the same name, if one exists.
+func serviceExportsFromService(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		if isManagedByType(o, "serviceexport") {
+			return []reconcile.Request{{NamespacedName: parentFromObjectLabels(o)}}
+		}
+		se := new(tsapi.ServiceExport)
+		if err := cl.Get(ctx, client.ObjectKeyFromObject(o), se); err != nil {
+			if !apierrors.IsNotFound(err) {
+				logger.Infof("error getting ServiceExport for Service %s: %v", client.ObjectKeyFromObject(o), err)
+			}
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(o)}}
+	}
+}
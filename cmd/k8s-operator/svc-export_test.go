@@ -0,0 +1,245 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/internal/client/tailscale"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+func TestServiceExport(t *testing.T) {
+	se := &tsapi.ServiceExport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("se-uid"),
+		},
+		Spec: tsapi.ServiceExportSpec{
+			ProxyGroup: "ingress-pg",
+			Tags:       tsapi.Tags{"tag:exported"},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeNodePort,
+			Selector: map[string]string{"app": "test"},
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt(8080),
+				NodePort:   30080,
+			}},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(se, svc).
+		WithStatusSubresource(se, &corev1.Service{}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTSClient{}
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	r := &ServiceExportReconciler{
+		Client:   fc,
+		logger:   zl.Sugar(),
+		recorder: record.NewFakeRecorder(10),
+		clock:    cl,
+		tsClient: ft,
+	}
+	expectReady := func(status metav1.ConditionStatus, reason string) *tsapi.ServiceExport {
+		t.Helper()
+		got := new(tsapi.ServiceExport)
+		if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Status.Conditions) != 1 {
+			t.Fatalf("expected 1 condition, got %+v", got.Status.Conditions)
+		}
+		cond := got.Status.Conditions[0]
+		if cond.Type != string(tsapi.ServiceExportReady) || cond.Status != status || cond.Reason != reason {
+			t.Fatalf("unexpected condition %+v, want status %s, reason %s", cond, status, reason)
+		}
+		return got
+	}
+	exportedSvc := func() *corev1.Service {
+		t.Helper()
+		s, err := getSingleObject[corev1.Service](context.Background(), fc, "default", childResourceLabels("test", "default", "serviceexport"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// 1. The exported Service is created and annotated to be exposed on the
+	// ProxyGroup.
+	expectReconciled(t, r, "default", "test")
+	expectReady(metav1.ConditionFalse, reasonServiceExportPending)
+	gen := exportedSvc()
+	if gen == nil {
+		t.Fatal("exported Service not created")
+	}
+	wantAnnots := map[string]string{
+		AnnotationExpose:     "true",
+		AnnotationProxyGroup: "ingress-pg",
+		AnnotationHostname:   "default-test-7",
+		AnnotationTags:       "tag:exported",
+	}
+	expectEqual(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            gen.Name,
+			Namespace:       "default",
+			Labels:          childResourceLabels("test", "default", "serviceexport"),
+			Annotations:     wantAnnots,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(se, tsapi.SchemeGroupVersion.WithKind(tsapi.ServiceExportKind))},
+			GenerateName:    gen.GenerateName,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: map[string]string{"app": "test"},
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt(8080),
+			}},
+		},
+	})
+
+	// 2. The ServiceExport is ready once the Tailscale Service is configured
+	// and the exported ports get published on the Tailscale Service.
+	mustCreateVIPService := func() {
+		t.Helper()
+		if err := ft.CreateOrUpdateVIPService(context.Background(), &tailscale.VIPService{
+			Name:        "svc:default-test-7",
+			Annotations: map[string]string{ownerAnnotation: `{"ownerRefs":[{"operatorID":"self-id"}]}`},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustCreateVIPService()
+	mustUpdateStatus(t, fc, "default", gen.Name, func(s *corev1.Service) {
+		tsoperator.SetServiceCondition(s, tsapi.IngressSvcConfigured, metav1.ConditionTrue, reasonIngressSvcConfigured, "", cl, zl.Sugar())
+	})
+	expectReconciled(t, r, "default", "test")
+	got := expectReady(metav1.ConditionTrue, reasonServiceExported)
+	if got.Status.TailscaleService != "svc:default-test-7" {
+		t.Fatalf("unexpected Tailscale Service %q", got.Status.TailscaleService)
+	}
+	tsSvc, err := ft.GetVIPService(context.Background(), tailcfg.ServiceName("svc:default-test-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"name":"http","protocol":"TCP","port":80}]`; tsSvc.Annotations[exportedPortsAnnotation] != want {
+		t.Fatalf("unexpected exported ports annotation %q, want %q", tsSvc.Annotations[exportedPortsAnnotation], want)
+	}
+	if tsSvc.Annotations[ownerAnnotation] == "" {
+		t.Fatal("owner annotation removed from Tailscale Service")
+	}
+
+	// 3. Changes to the exported Service are propagated.
+	mustUpdate(t, fc, "default", "test", func(s *corev1.Service) {
+		s.Spec.Ports = append(s.Spec.Ports, corev1.ServicePort{
+			Name:     "dns",
+			Protocol: corev1.ProtocolUDP,
+			Port:     53,
+		})
+	})
+	expectReconciled(t, r, "default", "test")
+	if gen = exportedSvc(); len(gen.Spec.Ports) != 2 {
+		t.Fatalf("expected 2 ports on exported Service, got %+v", gen.Spec.Ports)
+	}
+	if want := `[{"name":"http","protocol":"TCP","port":80},{"name":"dns","protocol":"UDP","port":53}]`; tsSvc.Annotations[exportedPortsAnnotation] != want {
+		t.Fatalf("unexpected exported ports annotation %q, want %q", tsSvc.Annotations[exportedPortsAnnotation], want)
+	}
+
+	// 4. If the Service is deleted, the exported Service is deleted too.
+	mustDeleteAll(t, fc, svc)
+	expectReconciled(t, r, "default", "test")
+	got = expectReady(metav1.ConditionFalse, reasonServiceExportInvalid)
+	if got.Status.TailscaleService != "" {
+		t.Fatalf("expected Tailscale Service status to be cleared, got %q", got.Status.TailscaleService)
+	}
+	if exportedSvc() != nil {
+		t.Fatal("expected exported Service to be deleted")
+	}
+}
+
+func TestValidateExportedService(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *corev1.Service
+		want int
+	}{
+		{
+			name: "valid",
+			svc: &corev1.Service{Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "test"},
+				Ports:    []corev1.ServicePort{{Port: 80}},
+			}},
+		},
+		{
+			name: "headless",
+			svc: &corev1.Service{Spec: corev1.ServiceSpec{
+				ClusterIP: "None",
+				Selector:  map[string]string{"app": "test"},
+				Ports:     []corev1.ServicePort{{Port: 80}},
+			}},
+		},
+		{
+			name: "external_name",
+			svc: &corev1.Service{Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "example.com",
+				Ports:        []corev1.ServicePort{{Port: 80}},
+			}},
+			want: 2,
+		},
+		{
+			name: "no_ports",
+			svc: &corev1.Service{Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"app": "test"},
+			}},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateExportedService(tt.svc); len(got) != tt.want {
+				t.Errorf("validateExportedService() = %v, want %d violations", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportedServiceName(t *testing.T) {
+	if got, want := exportedServiceName("default", "test"), tailcfg.ServiceName("svc:default-test-7"); got != want {
+		t.Errorf("exportedServiceName = %q, want %q", got, want)
+	}
+	if a, b := exportedServiceName("a-b", "c"), exportedServiceName("a", "b-c"); a == b {
+		t.Errorf("Services a-b/c and a/b-c are both exported as %q", a)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tailscale.com/internal/client/tailscale"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstime"
+	"tailscale.com/util/mak"
+)
+
+const (
+	reasonServiceImportInvalid = "ServiceImportInvalid"
+	reasonServiceImportPending = "ServiceImportPending"
+	reasonServiceImported      = "ServiceImported"
+)
+
+// ServiceImportReconciler reconciles ServiceImport resources. For each
+// ServiceImport, it looks up the Tailscale Service of the Service exported
+// from another cluster and creates an ExternalName Service with the same name
+// as the ServiceImport that targets the Tailscale Service's tailnet IP via the
+// egress ProxyGroup. The ClusterIP Service and the proxy configuration for it
+// are managed by the egressSvcsReconciler.
+type ServiceImportReconciler struct {
+	client.Client
+	logger   *zap.SugaredLogger
+	recorder record.EventRecorder
+	clock    tstime.Clock
+	tsClient tsClient
+}
+
+func (r *ServiceImportReconciler) Reconcile(ctx context.Context, req reconcile.Request) (res reconcile.Result, err error) {
+	logger := r.logger.With("ServiceImport", req.NamespacedName)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	si := new(tsapi.ServiceImport)
+	err = r.Get(ctx, req.NamespacedName, si)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ServiceImport not found, assuming it was deleted")
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get tailscale.com ServiceImport: %w", err)
+	}
+	if markedForDeletion(si) {
+		// The generated Service is owned by the ServiceImport and gets
+		// garbage collected, which in turn cleans up the egress
+		// configuration.
+		logger.Debugf("ServiceImport is being deleted")
+		return res, nil
+	}
+
+	oldStatus := si.Status.DeepCopy()
+	defer func() {
+		if !apiequality.Semantic.DeepEqual(oldStatus, &si.Status) {
+			// An error encountered here should get returned by the Reconcile function.
+			err = errors.Join(err, r.Client.Status().Update(ctx, si))
+		}
+	}()
+	setReady := func(status metav1.ConditionStatus, reason, msg string) {
+		tsoperator.SetServiceImportCondition(si, tsapi.ServiceImportReady, status, reason, msg, si.Generation, r.clock, logger)
+	}
+
+	// The Tailscale Service may be (re-)exported or change at any time, so
+	// periodically check it for updates.
+	res = reconcile.Result{RequeueAfter: requeueInterval()}
+
+	serviceName := exportedServiceName(si.Namespace, si.Name)
+	si.Status.TailscaleService = serviceName.String()
+	tsSvc, err := r.tsClient.GetVIPService(ctx, serviceName)
+	if isErrorFeatureFlagNotEnabled(err) {
+		logger.Warn(msgFeatureFlagNotEnabled)
+		r.recorder.Event(si, corev1.EventTypeWarning, warningTailscaleServiceFeatureFlagNotEnabled, msgFeatureFlagNotEnabled)
+		setReady(metav1.ConditionFalse, reasonServiceImportPending, msgFeatureFlagNotEnabled)
+		return res, nil
+	}
+	if isErrorTailscaleServiceNotFound(err) {
+		msg := fmt.Sprintf("Tailscale Service %s not found, waiting for the Service to be exported", serviceName)
+		logger.Debug(msg)
+		setReady(metav1.ConditionFalse, reasonServiceImportPending, msg)
+		si.Status.TailnetIPs = nil
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("error getting Tailscale Service %s: %w", serviceName, err)
+	}
+	si.Status.TailnetIPs = tsSvc.Addrs
+
+	ip := tailscaleServiceIPv4(tsSvc)
+	if !ip.IsValid() {
+		msg := fmt.Sprintf("Tailscale Service %s does not (yet) have an IPv4 address", serviceName)
+		setReady(metav1.ConditionFalse, reasonServiceImportPending, msg)
+		return res, nil
+	}
+	ports, err := importedPorts(si, tsSvc)
+	if err != nil {
+		setReady(metav1.ConditionFalse, reasonServiceImportInvalid, err.Error())
+		return res, nil
+	}
+
+	svc := new(corev1.Service)
+	err = r.Get(ctx, req.NamespacedName, svc)
+	if apierrors.IsNotFound(err) {
+		svc = importedService(si, ip, ports)
+		logger.Infof("creating Service for Tailscale Service %s", serviceName)
+		if err := r.Create(ctx, svc); err != nil {
+			return res, fmt.Errorf("error creating imported Service: %w", err)
+		}
+	} else if err != nil {
+		return res, fmt.Errorf("failed to get Service: %w", err)
+	} else if !metav1.IsControlledBy(svc, si) {
+		msg := fmt.Sprintf("Service %s already exists and is not managed by the ServiceImport", req.NamespacedName)
+		r.recorder.Event(si, corev1.EventTypeWarning, reasonServiceImportInvalid, msg)
+		setReady(metav1.ConditionFalse, reasonServiceImportInvalid, msg)
+		return res, nil
+	} else {
+		// The egressSvcsReconciler manages the ExternalName of the
+		// Service, so only the tailnet target and ports are updated here.
+		want := importedService(si, ip, ports)
+		if svc.Annotations[AnnotationTailnetTargetIP] != want.Annotations[AnnotationTailnetTargetIP] ||
+			svc.Annotations[AnnotationProxyGroup] != want.Annotations[AnnotationProxyGroup] ||
+			!apiequality.Semantic.DeepEqual(svc.Spec.Ports, want.Spec.Ports) {
+			mak.Set(&svc.Annotations, AnnotationTailnetTargetIP, want.Annotations[AnnotationTailnetTargetIP])
+			mak.Set(&svc.Annotations, AnnotationProxyGroup, want.Annotations[AnnotationProxyGroup])
+			svc.Spec.Ports = want.Spec.Ports
+			logger.Infof("updating Service for Tailscale Service %s", serviceName)
+			if err := r.Update(ctx, svc); err != nil {
+				return res, fmt.Errorf("error updating imported Service: %w", err)
+			}
+		}
+	}
+
+	// The egress Service readiness reconciler sets the EgressSvcReady
+	// condition once at least one proxy is ready to route traffic for the
+	// Service.
+	if cond := tsoperator.GetServiceCondition(svc, tsapi.EgressSvcValid); cond != nil && cond.Status == metav1.ConditionFalse {
+		setReady(metav1.ConditionFalse, reasonServiceImportInvalid, cond.Message)
+		return res, nil
+	}
+	if cond := tsoperator.GetServiceCondition(svc, tsapi.EgressSvcReady); cond == nil || cond.Status != metav1.ConditionTrue {
+		msg := fmt.Sprintf("waiting for ProxyGroup %q to be ready to route traffic for the Service", si.Spec.ProxyGroup)
+		if cond != nil {
+			msg = cond.Message
+		}
+		setReady(metav1.ConditionFalse, reasonServiceImportPending, msg)
+		return res, nil
+	}
+	setReady(metav1.ConditionTrue, reasonServiceImported, fmt.Sprintf("Tailscale Service %s is imported as Service %s", serviceName, req.Name))
+	return res, nil
+}
+
+// importedService returns the ExternalName Service that routes cluster
+// traffic to the Tailscale Service's tailnet IP via the ServiceImport's
+// ProxyGroup.
+func importedService(si *tsapi.ServiceImport, ip netip.Addr, ports []tsapi.ServiceImportPort) *corev1.Service {
+	svcPorts := make([]corev1.ServicePort, 0, len(ports))
+	for _, p := range ports {
+		proto := p.Protocol
+		if proto == "" {
+			proto = corev1.ProtocolTCP
+		}
+		svcPorts = append(svcPorts, corev1.ServicePort{
+			Name:     p.Name,
+			Protocol: proto,
+			Port:     p.Port,
+		})
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      si.Name,
+			Namespace: si.Namespace,
+			Labels:    childResourceLabels(si.Name, si.Namespace, "serviceimport"),
+			Annotations: map[string]string{
+				AnnotationTailnetTargetIP: ip.String(),
+				AnnotationProxyGroup:      si.Spec.ProxyGroup,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(si, tsapi.SchemeGroupVersion.WithKind(tsapi.ServiceImportKind))},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeExternalName,
+			// The egressSvcsReconciler points the ExternalName at the
+			// ClusterIP Service that it creates for the egress proxies.
+			ExternalName: "placeholder",
+			Ports:        svcPorts,
+		},
+	}
+}
+
+// importedPorts returns the ports of the ServiceImport if set, or else the
+// ports that the exporting cluster published on the Tailscale Service.
+func importedPorts(si *tsapi.ServiceImport, tsSvc *tailscale.VIPService) ([]tsapi.ServiceImportPort, error) {
+	if len(si.Spec.Ports) > 0 {
+		return si.Spec.Ports, nil
+	}
+	v, ok := tsSvc.Annotations[exportedPortsAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("Tailscale Service %s does not list its ports, set ports on the ServiceImport to import it", tsSvc.Name)
+	}
+	var ports []tsapi.ServiceImportPort
+	if err := json.Unmarshal([]byte(v), &ports); err != nil {
+		return nil, fmt.Errorf("error parsing ports of Tailscale Service %s: %w", tsSvc.Name, err)
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("Tailscale Service %s does not have any ports", tsSvc.Name)
+	}
+	return ports, nil
+}
+
+// tailscaleServiceIPv4 returns the IPv4 address of the Tailscale Service, or
+// the zero value if it does not have one.
+func tailscaleServiceIPv4(tsSvc *tailscale.VIPService) netip.Addr {
+	for _, a := range tsSvc.Addrs {
+		if ip, err := netip.ParseAddr(a); err == nil && ip.Is4() {
+			return ip
+		}
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"tailscale.com/internal/client/tailscale"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstest"
+)
+
+func TestServiceImport(t *testing.T) {
+	si := &tsapi.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("si-uid"),
+		},
+		Spec: tsapi.ServiceImportSpec{
+			ProxyGroup: "egress-pg",
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(si).
+		WithStatusSubresource(si, &corev1.Service{}).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTSClient{}
+	cl := tstest.NewClock(tstest.ClockOpts{})
+	r := &ServiceImportReconciler{
+		Client:   fc,
+		logger:   zl.Sugar(),
+		recorder: record.NewFakeRecorder(10),
+		clock:    cl,
+		tsClient: ft,
+	}
+	expectReady := func(status metav1.ConditionStatus, reason string) *tsapi.ServiceImport {
+		t.Helper()
+		got := new(tsapi.ServiceImport)
+		if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Status.Conditions) != 1 {
+			t.Fatalf("expected 1 condition, got %+v", got.Status.Conditions)
+		}
+		cond := got.Status.Conditions[0]
+		if cond.Type != string(tsapi.ServiceImportReady) || cond.Status != status || cond.Reason != reason {
+			t.Fatalf("unexpected condition %+v, want status %s, reason %s", cond, status, reason)
+		}
+		return got
+	}
+	importedSvc := func(ports []corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "default",
+				Labels:    childResourceLabels("test", "default", "serviceimport"),
+				Annotations: map[string]string{
+					AnnotationTailnetTargetIP: vipTestIP,
+					AnnotationProxyGroup:      "egress-pg",
+				},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(si, tsapi.SchemeGroupVersion.WithKind(tsapi.ServiceImportKind))},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:         corev1.ServiceTypeExternalName,
+				ExternalName: "placeholder",
+				Ports:        ports,
+			},
+		}
+	}
+
+	// 1. The Service has not (yet) been exported.
+	expectRequeue(t, r, "default", "test")
+	got := expectReady(metav1.ConditionFalse, reasonServiceImportPending)
+	if got.Status.TailscaleService != "svc:default-test-7" {
+		t.Fatalf("unexpected Tailscale Service %q", got.Status.TailscaleService)
+	}
+	expectMissing[corev1.Service](t, fc, "default", "test")
+
+	// 2. The Service gets exported without a list of ports.
+	tsSvc := &tailscale.VIPService{
+		Name:  "svc:default-test-7",
+		Addrs: []string{vipTestIP, "fd7a:115c:a1e0::1"},
+	}
+	if err := ft.CreateOrUpdateVIPService(context.Background(), tsSvc); err != nil {
+		t.Fatal(err)
+	}
+	expectRequeue(t, r, "default", "test")
+	expectReady(metav1.ConditionFalse, reasonServiceImportInvalid)
+	expectMissing[corev1.Service](t, fc, "default", "test")
+
+	// 3. Once the exporting cluster has published the ports, the
+	// ExternalName Service for the egress ProxyGroup is created.
+	tsSvc.Annotations = map[string]string{
+		exportedPortsAnnotation: `[{"name":"http","protocol":"TCP","port":80},{"name":"dns","protocol":"UDP","port":53}]`,
+	}
+	expectRequeue(t, r, "default", "test")
+	got = expectReady(metav1.ConditionFalse, reasonServiceImportPending)
+	if diff := cmp.Diff(got.Status.TailnetIPs, tsSvc.Addrs); diff != "" {
+		t.Fatalf("unexpected tailnet IPs (-got +want):\n%s", diff)
+	}
+	expectEqual(t, fc, importedSvc([]corev1.ServicePort{
+		{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+		{Name: "dns", Protocol: corev1.ProtocolUDP, Port: 53},
+	}))
+
+	// 4. The egress reconcilers point the Service at the ClusterIP Service
+	// and mark it ready.
+	mustUpdate(t, fc, "default", "test", func(s *corev1.Service) {
+		s.Spec.ExternalName = "ts-test-abcde.tailscale.svc.cluster.local"
+	})
+	mustUpdateStatus(t, fc, "default", "test", func(s *corev1.Service) {
+		tsoperator.SetServiceCondition(s, tsapi.EgressSvcReady, metav1.ConditionTrue, "", "", cl, zl.Sugar())
+	})
+	expectRequeue(t, r, "default", "test")
+	expectReady(metav1.ConditionTrue, reasonServiceImported)
+
+	// 5. Ports set on the ServiceImport take precedence and the ExternalName
+	// set by the egress reconciler is preserved.
+	mustUpdate(t, fc, "default", "test", func(s *tsapi.ServiceImport) {
+		s.Spec.Ports = []tsapi.ServiceImportPort{{Port: 80}}
+	})
+	expectRequeue(t, r, "default", "test")
+	expectEqual(t, fc, importedSvc([]corev1.ServicePort{{Protocol: corev1.ProtocolTCP, Port: 80}}), func(s *corev1.Service) {
+		s.Spec.ExternalName = ""
+		s.Status = corev1.ServiceStatus{}
+	})
+	svc := new(corev1.Service)
+	if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.ExternalName != "ts-test-abcde.tailscale.svc.cluster.local" {
+		t.Fatalf("ExternalName was overwritten: %q", svc.Spec.ExternalName)
+	}
+}
+
+func TestServiceImportExistingService(t *testing.T) {
+	si := &tsapi.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			UID:       types.UID("si-uid"),
+		},
+		Spec: tsapi.ServiceImportSpec{
+			ProxyGroup: "egress-pg",
+			Ports:      []tsapi.ServiceImportPort{{Port: 80}},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "test"},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(si, svc).
+		WithStatusSubresource(si).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fakeTSClient{}
+	if err := ft.CreateOrUpdateVIPService(context.Background(), &tailscale.VIPService{Name: "svc:default-test-7"}); err != nil {
+		t.Fatal(err)
+	}
+	r := &ServiceImportReconciler{
+		Client:   fc,
+		logger:   zl.Sugar(),
+		recorder: record.NewFakeRecorder(10),
+		clock:    tstest.NewClock(tstest.ClockOpts{}),
+		tsClient: ft,
+	}
+
+	// A Service that is not managed by the ServiceImport is never modified.
+	expectRequeue(t, r, "default", "test")
+	got := new(tsapi.ServiceImport)
+	if err := fc.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Status.Conditions) != 1 || got.Status.Conditions[0].Reason != reasonServiceImportInvalid {
+		t.Fatalf("unexpected conditions %+v", got.Status.Conditions)
+	}
+	expectEqual(t, fc, svc, func(s *corev1.Service) {
+		s.TypeMeta = metav1.TypeMeta{}
+	})
+}
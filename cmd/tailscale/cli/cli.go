@@ -245,6 +245,7 @@ func newRootCmd() *ffcli.Command {
 			pingCmd,
 			ncCmd,
 			sshCmd,
+			sshRecordingsCmd,
 			funnelCmd(),
 			serveCmd(),
 			versionCmd,
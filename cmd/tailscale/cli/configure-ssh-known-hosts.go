@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var configureSSHKnownHostsArgs struct {
+	file string
+}
+
+func configureSSHKnownHostsCmd() *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "ssh-known-hosts",
+		ShortHelp:  "Trust the SSH host keys of tailnet peers in known_hosts",
+		ShortUsage: "tailscale configure ssh-known-hosts [--file=<path>]",
+		LongHelp: strings.TrimSpace(`
+Run this command to add the SSH host keys that peers advertise to the tailnet
+to an OpenSSH known_hosts file, so that SSH clients other than 'tailscale ssh'
+can connect to peers by MagicDNS name or Tailscale IP without being prompted to
+trust their host keys on first use.
+
+For peers whose host keys are signed by an SSH certificate authority, an
+@cert-authority entry is also written, limited to that peer's names and IPs.
+
+The entries are kept in a block delimited by marker comments, which is replaced
+each time the command is run. The rest of the file is left untouched.
+`),
+		FlagSet: (func() *flag.FlagSet {
+			fs := newFlagSet("ssh-known-hosts")
+			fs.StringVar(&configureSSHKnownHostsArgs.file, "file", "", `known_hosts file to update; defaults to ~/.ssh/known_hosts; "-" writes the entries to stdout`)
+			return fs
+		})(),
+		Exec: runConfigureSSHKnownHosts,
+	}
+}
+
+const (
+	knownHostsBeginMarker = "# BEGIN tailscale configure ssh-known-hosts"
+	knownHostsEndMarker   = "# END tailscale configure ssh-known-hosts"
+)
+
+func runConfigureSSHKnownHosts(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.BackendState != "Running" {
+		return errors.New("Tailscale is not running")
+	}
+	entries := genSSHKnownHosts(st)
+
+	file := configureSSHKnownHostsArgs.file
+	if file == "-" {
+		Stdout.Write(entries)
+		return nil
+	}
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	cur, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, replaceKnownHostsBlock(cur, entries), 0600); err != nil {
+		return err
+	}
+	printf("Updated Tailscale SSH host keys in %s\n", file)
+	return nil
+}
+
+// genSSHKnownHosts returns known_hosts entries for all peers in st that
+// advertise SSH host keys. Each peer's entries are valid for its MagicDNS
+// FQDN, its short name and its Tailscale IPs.
+func genSSHKnownHosts(st *ipnstate.Status) []byte {
+	var buf bytes.Buffer
+	for _, k := range st.Peers() {
+		ps := st.Peer[k]
+		hosts := knownHostsPatterns(ps)
+		if hosts == "" {
+			continue
+		}
+		for _, hk := range ps.SSH_HostCAKeys {
+			if key, ok := knownHostsKey(hk); ok {
+				fmt.Fprintf(&buf, "@cert-authority %s %s\n", hosts, key)
+			}
+		}
+		for _, hk := range ps.SSH_HostKeys {
+			if key, ok := knownHostsKey(hk); ok {
+				fmt.Fprintf(&buf, "%s %s\n", hosts, key)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// knownHostsPatterns returns the comma-separated host patterns that identify
+// ps in a known_hosts file, or the empty string if it has none.
+func knownHostsPatterns(ps *ipnstate.PeerStatus) string {
+	var hosts []string
+	if fqdn := strings.TrimSuffix(ps.DNSName, "."); fqdn != "" {
+		hosts = append(hosts, fqdn)
+		if short, _, ok := strings.Cut(fqdn, "."); ok {
+			hosts = append(hosts, short)
+		}
+	}
+	for _, ip := range ps.TailscaleIPs {
+		hosts = append(hosts, ip.String())
+	}
+	for _, h := range hosts {
+		if strings.ContainsAny(h, " \t,*?!\r\n") { // invalid
+			return ""
+		}
+	}
+	return strings.Join(hosts, ",")
+}
+
+// knownHostsKey returns the key type and base64 key of the authorized_keys
+// formatted key s, dropping any comment.
+func knownHostsKey(s string) (_ string, ok bool) {
+	f := strings.Fields(s)
+	if len(f) < 2 || strings.ContainsAny(s, "\n\r") {
+		return "", false
+	}
+	return f[0] + " " + f[1], true
+}
+
+// replaceKnownHostsBlock returns the known_hosts file contents cur with the
+// block of entries managed by 'tailscale configure ssh-known-hosts' replaced
+// by entries, or with it appended if cur has no such block.
+func replaceKnownHostsBlock(cur, entries []byte) []byte {
+	var out bytes.Buffer
+	before, rest, found := bytes.Cut(cur, []byte(knownHostsBeginMarker+"\n"))
+	var after []byte
+	if found {
+		_, after, found = bytes.Cut(rest, []byte(knownHostsEndMarker+"\n"))
+	}
+	if !found {
+		before, after = cur, nil
+	}
+	out.Write(before)
+	if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	out.WriteString(knownHostsBeginMarker + "\n")
+	out.Write(entries)
+	out.WriteString(knownHostsEndMarker + "\n")
+	out.Write(after)
+	return out.Bytes()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestGenSSHKnownHosts(t *testing.T) {
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	st := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			k1: {
+				DNSName:        "foo.tail-scale.ts.net.",
+				TailscaleIPs:   []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+				SSH_HostKeys:   []string{"ssh-ed25519 AAAAfoo root@foo", "bogus"},
+				SSH_HostCAKeys: []string{"ssh-ed25519 AAAAca"},
+			},
+			k2: {
+				DNSName:      "bar.tail-scale.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+			},
+		},
+	}
+	got := string(genSSHKnownHosts(st))
+	want := "@cert-authority foo.tail-scale.ts.net,foo,100.64.0.1,fd7a:115c:a1e0::1 ssh-ed25519 AAAAca\n" +
+		"foo.tail-scale.ts.net,foo,100.64.0.1,fd7a:115c:a1e0::1 ssh-ed25519 AAAAfoo\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReplaceKnownHostsBlock(t *testing.T) {
+	block := func(entries string) string {
+		return knownHostsBeginMarker + "\n" + entries + knownHostsEndMarker + "\n"
+	}
+	tests := []struct {
+		name string
+		cur  string
+		want string
+	}{
+		{
+			name: "empty",
+			cur:  "",
+			want: block("new\n"),
+		},
+		{
+			name: "append",
+			cur:  "other key",
+			want: "other key\n" + block("new\n"),
+		},
+		{
+			name: "replace",
+			cur:  "before\n" + block("old1\nold2\n") + "after\n",
+			want: "before\n" + block("new\n") + "after\n",
+		},
+		{
+			name: "unterminated",
+			cur:  "before\n" + knownHostsBeginMarker + "\nold\n",
+			want: "before\n" + knownHostsBeginMarker + "\nold\n" + block("new\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(replaceKnownHostsBlock([]byte(tt.cur), []byte("new\n")))
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
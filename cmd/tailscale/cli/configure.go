@@ -25,6 +25,7 @@ func configureCmd() *ffcli.Command {
 		})(),
 		Subcommands: nonNilCmds(
 			configureKubeconfigCmd(),
+			configureSSHKnownHostsCmd(),
 			synologyConfigureCmd(),
 			synologyConfigureCertCmd(),
 			ccall(maybeSysExtCmd),
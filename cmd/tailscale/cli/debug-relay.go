@@ -0,0 +1,78 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"tailscale.com/net/udprelay/endpoint"
+)
+
+var debugRelayStatusArgs struct {
+	json bool
+}
+
+func runDebugRelayStatus(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %q", args)
+	}
+	st, err := localClient.DebugRelayStatus(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if debugRelayStatusArgs.json {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "\t")
+		return e.Encode(st)
+	}
+	printRelayStatus(Stdout, st, time.Now())
+	return nil
+}
+
+// printRelayStatus writes a human-readable table of st to w, with one row per
+// client of each endpoint.
+func printRelayStatus(w io.Writer, st *endpoint.ServerStatus, now time.Time) {
+	if st.UDPPort == nil {
+		fmt.Fprintln(w, "UDP relay server is not enabled")
+		return
+	}
+	fmt.Fprintf(w, "UDP relay server on port %d, %d endpoint(s) allocated\n", *st.UDPPort, len(st.Endpoints))
+	if len(st.Endpoints) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VNI\tAGE\tSTATE\tDISCO\tADDR\tLAST SEEN\tPACKETS\tBYTES\tDROPPED")
+	for _, ep := range st.Endpoints {
+		state := "unbound"
+		if !ep.BoundAt.IsZero() {
+			state = "bound"
+		}
+		for i, c := range ep.Clients {
+			vni, age := "", ""
+			if i == 0 {
+				vni = fmt.Sprint(ep.VNI)
+				age = now.Sub(ep.AllocatedAt).Round(time.Second).String()
+			} else {
+				state = ""
+			}
+			addr, lastSeen := "-", "-"
+			if c.AddrPort.IsValid() {
+				addr = c.AddrPort.String()
+			}
+			if !c.LastSeen.IsZero() {
+				lastSeen = now.Sub(c.LastSeen).Round(time.Second).String() + " ago"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\n",
+				vni, age, state, c.Disco.ShortString(), addr, lastSeen, c.Packets, c.Bytes, c.DroppedPackets)
+		}
+	}
+	tw.Flush()
+}
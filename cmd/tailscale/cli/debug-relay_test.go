@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/net/udprelay/endpoint"
+	"tailscale.com/types/key"
+	"tailscale.com/types/ptr"
+)
+
+func TestPrintRelayStatus(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	discoA, discoB := key.NewDisco().Public(), key.NewDisco().Public()
+
+	tests := []struct {
+		name string
+		st   *endpoint.ServerStatus
+		want []string
+	}{
+		{
+			name: "disabled",
+			st:   &endpoint.ServerStatus{},
+			want: []string{"UDP relay server is not enabled"},
+		},
+		{
+			name: "endpoints",
+			st: &endpoint.ServerStatus{
+				UDPPort: ptr.To(7777),
+				Endpoints: []endpoint.EndpointStatus{
+					{
+						VNI:         5,
+						AllocatedAt: now.Add(-time.Minute),
+						BoundAt:     now.Add(-50 * time.Second),
+						Clients: [2]endpoint.ClientStatus{
+							{Disco: discoA, AddrPort: netip.MustParseAddrPort("192.0.2.1:41641"), Bound: true, LastSeen: now.Add(-2 * time.Second), Packets: 10, Bytes: 1000},
+							{Disco: discoB, AddrPort: netip.MustParseAddrPort("[2001:db8::1]:41641"), Bound: true, LastSeen: now.Add(-3 * time.Second), Packets: 20, Bytes: 2000, DroppedPackets: 1},
+						},
+					},
+				},
+			},
+			want: []string{
+				"UDP relay server on port 7777, 1 endpoint(s) allocated",
+				"5    1m0s  bound  " + discoA.ShortString() + "  192.0.2.1:41641",
+				"2s ago     10       1000   0",
+				discoB.ShortString() + "  [2001:db8::1]:41641  3s ago     20       2000   1",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printRelayStatus(&buf, tt.st, now)
+			for _, want := range tt.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("output missing %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -322,6 +322,17 @@ func debugCmd() *ffcli.Command {
 					return fs
 				})(),
 			},
+			{
+				Name:       "relay-status",
+				ShortUsage: "tailscale debug relay-status [--json]",
+				Exec:       runDebugRelayStatus,
+				ShortHelp:  "Print the endpoints allocated on this node's UDP relay server",
+				FlagSet: (func() *flag.FlagSet {
+					fs := newFlagSet("relay-status")
+					fs.BoolVar(&debugRelayStatusArgs.json, "json", false, "output in JSON format")
+					return fs
+				})(),
+			},
 			{
 				Name:       "peer-endpoint-changes",
 				ShortUsage: "tailscale debug peer-endpoint-changes <hostname-or-IP>",
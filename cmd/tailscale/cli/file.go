@@ -6,12 +6,18 @@
 package cli
 
 import (
+	"archive/tar"
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -19,8 +25,10 @@
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"text/tabwriter"
 	"time"
 	"unicode/utf8"
 
@@ -47,11 +55,12 @@ func init() {
 func getFileCmd() *ffcli.Command {
 	return &ffcli.Command{
 		Name:       "file",
-		ShortUsage: "tailscale file <cp|get> ...",
+		ShortUsage: "tailscale file <cp|get|queue> ...",
 		ShortHelp:  "Send or receive files",
 		Subcommands: []*ffcli.Command{
 			fileCpCmd,
 			fileGetCmd,
+			fileQueueCmd,
 		},
 	}
 }
@@ -77,6 +86,7 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.BoolVar(&cpArgs.queue, "queue", false, "hand the files to tailscaled's outbox and return immediately; they're sent in the background once the target is online, and retried on failure (see 'tailscale file queue')")
 		return fs
 	})(),
 }
@@ -85,6 +95,7 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 	name    string
 	verbose bool
 	targets bool
+	queue   bool
 }
 
 func runCp(ctx context.Context, args []string) error {
@@ -118,7 +129,7 @@ func runCp(ctx context.Context, args []string) error {
 	if err != nil {
 		return fmt.Errorf("can't send to %s: %v", target, err)
 	}
-	if isOffline {
+	if isOffline && !cpArgs.queue {
 		fmt.Fprintf(Stderr, "# warning: %s is offline\n", target)
 	}
 
@@ -159,7 +170,20 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				if cpArgs.queue {
+					return errors.New("--queue does not support directories")
+				}
+				if name == "" {
+					abs, err := filepath.Abs(fileArg)
+					if err != nil {
+						return err
+					}
+					name = filepath.Base(abs)
+				}
+				if err := sendDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
@@ -172,6 +196,17 @@ func runCp(ctx context.Context, args []string) error {
 			}
 		}
 
+		if cpArgs.queue {
+			qf, err := localClient.QueueFile(ctx, stableID, contentLength, name, fileContents)
+			if err != nil {
+				return err
+			}
+			if cpArgs.verbose {
+				log.Printf("queued %q for %v/%v/%v as %s", name, target, ip, stableID, qf.ID)
+			}
+			continue
+		}
+
 		if cpArgs.verbose {
 			log.Printf("sending %q to %v/%v/%v ...", name, target, ip, stableID)
 		}
@@ -196,6 +231,116 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
+// sendDir sends the directory dir to the node with the given ID, as a
+// directory named name.
+func sendDir(ctx context.Context, stableID tailcfg.StableNodeID, dir, name string) error {
+	if cpArgs.verbose {
+		log.Printf("hashing files in %q ...", dir)
+	}
+	man, err := buildManifest(dir)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, e := range man.Files {
+		size += e.Size
+	}
+	if cpArgs.verbose {
+		log.Printf("sending directory %q (%d entries, %d bytes) to %v ...", name, len(man.Files), size, stableID)
+	}
+
+	fsys := &countingFS{FS: os.DirFS(dir)}
+	var group syncs.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name+"/", fsys.n.Load, size) })
+	}
+	err = localClient.PushDir(ctx, stableID, name, man, fsys)
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sent %q", name)
+	}
+	return nil
+}
+
+// buildManifest returns the Taildrop manifest of the regular files and
+// directories within dir, hashing each file. Other types of files, such as
+// symlinks, are skipped with a warning.
+func buildManifest(dir string) (*apitype.TaildropManifest, error) {
+	man := new(apitype.TaildropManifest)
+	err := filepath.WalkDir(dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		e := apitype.TaildropManifestEntry{
+			Path:    filepath.ToSlash(rel),
+			Mode:    fi.Mode() & (fs.ModeDir | fs.ModePerm),
+			ModTime: fi.ModTime(),
+		}
+		switch {
+		case fi.IsDir():
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			h := sha256.New()
+			if e.Size, err = io.Copy(h, f); err != nil {
+				return err
+			}
+			e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		default:
+			fmt.Fprintf(Stderr, "# warning: skipping %s: not a regular file or directory\n", p)
+			return nil
+		}
+		man.Files = append(man.Files, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return man, nil
+}
+
+// countingFS is an fs.FS that counts the bytes read from its files.
+type countingFS struct {
+	fs.FS
+	n atomic.Int64
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	f, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingFile{f, &c.n}, nil
+}
+
+type countingFile struct {
+	fs.File
+	n *atomic.Int64
+}
+
+func (f countingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.n.Add(int64(n))
+	return n, err
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -422,6 +567,91 @@ func runCpTargets(ctx context.Context, args []string) error {
 	return nil
 }
 
+var fileQueueCmd = &ffcli.Command{
+	Name:       "queue",
+	ShortUsage: "tailscale file queue [cancel <id>...]",
+	ShortHelp:  "List or cancel files waiting to be sent",
+	LongHelp: strings.TrimSpace(`
+'tailscale file queue' lists the files in tailscaled's outbox, which were
+queued with 'tailscale file cp --queue'. They're sent once their target is
+online, resuming any partial transfer, and retried on failure.
+
+Each profile has its own outbox; files queued by one profile are only sent
+while it's the current profile.
+`),
+	Exec: runFileQueue,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("queue")
+		fs.BoolVar(&queueArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "cancel",
+			ShortUsage: "tailscale file queue cancel <id>...",
+			ShortHelp:  "Cancel sending queued files",
+			Exec:       runFileQueueCancel,
+		},
+	},
+}
+
+var queueArgs struct {
+	json bool
+}
+
+func runFileQueue(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	files, err := localClient.QueuedFiles(ctx)
+	if err != nil {
+		return err
+	}
+	if queueArgs.json {
+		j, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	if len(files) == 0 {
+		outln("No files queued.")
+		return nil
+	}
+	names := map[tailcfg.StableNodeID]string{}
+	if fts, err := localClient.FileTargets(ctx); err == nil {
+		for _, ft := range fts {
+			names[ft.Node.StableID] = ft.Node.ComputedName
+		}
+	}
+	tw := tabwriter.NewWriter(Stdout, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTARGET\tNAME\tSIZE\tQUEUED\tSTATUS")
+	for _, qf := range files {
+		target := cmp.Or(names[qf.PeerID], string(qf.PeerID))
+		status := "waiting"
+		if qf.Attempts > 0 {
+			status = fmt.Sprintf("%d failed attempts; last: %s", qf.Attempts, truncateString(qf.LastError, 60))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v ago\t%s\n",
+			qf.ID, target, qf.Name, formatIEC(float64(qf.Size), "B"),
+			time.Since(qf.Created).Round(time.Second), status)
+	}
+	return tw.Flush()
+}
+
+func runFileQueueCancel(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: tailscale file queue cancel <id>...")
+	}
+	for _, id := range args {
+		if err := localClient.CancelQueuedFile(ctx, id); err != nil {
+			return fmt.Errorf("canceling %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
 // onConflict is a flag.Value for the --conflict flag's three string options.
 type onConflict string
 
@@ -520,7 +750,129 @@ func openFileOrSubstitute(dir, base string, action onConflict) (*os.File, error)
 	}
 }
 
+// mkdirOrSubstitute is like openFileOrSubstitute, for a directory.
+func mkdirOrSubstitute(dir, base string, action onConflict) (string, error) {
+	targetDir := filepath.Join(dir, base)
+	err := os.Mkdir(targetDir, 0755)
+	if err == nil {
+		return targetDir, nil
+	}
+	switch action {
+	default:
+		// This should not happen.
+		return "", fmt.Errorf("file issue. how to resolve this conflict? no one knows.")
+	case skipOnExist:
+		if _, statErr := os.Lstat(targetDir); statErr == nil {
+			return "", fmt.Errorf("refusing to overwrite directory: %w", err)
+		}
+		return "", fmt.Errorf("failed to write; %w", err)
+	case overwriteExisting:
+		if err = os.RemoveAll(targetDir); err != nil {
+			return "", fmt.Errorf("unable to remove target directory: %w", err)
+		}
+		if err = os.Mkdir(targetDir, 0755); err != nil {
+			return "", fmt.Errorf("unable to overwrite: %w", err)
+		}
+		return targetDir, nil
+	case createNumberedFiles:
+		maxAttempts := 100
+		for i := 1; i < maxAttempts; i++ {
+			p := numberedFileName(dir, base, i)
+			if err = os.Mkdir(p, 0755); err == nil {
+				return p, nil
+			}
+		}
+		return "", fmt.Errorf("unable to find a name for writing %v, final attempt: %w", targetDir, err)
+	}
+}
+
+// receiveDir is like receiveFile, for a directory received with a Taildrop
+// directory transfer.
+func receiveDir(ctx context.Context, wf apitype.WaitingFile, dir string) (targetDir string, size int64, err error) {
+	rc, err := localClient.GetWaitingDir(ctx, wf.Name)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening inbox directory %q: %w", wf.Name, err)
+	}
+	defer rc.Close()
+	targetDir, err = mkdirOrSubstitute(dir, wf.Name, getArgs.conflict)
+	if err != nil {
+		return "", 0, err
+	}
+	size, err = extractTar(rc, targetDir)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write %v: %v", targetDir, err)
+	}
+	return targetDir, size, nil
+}
+
+// extractTar extracts the regular files and directories in the tar archive
+// r into root, preserving their permissions and modification times. It
+// returns the total size of the files.
+func extractTar(r io.Reader, root string) (size int64, err error) {
+	var dirs []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+		rel := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(rel) {
+			return size, fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		p := filepath.Join(root, rel)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return size, err
+			}
+			dirs = append(dirs, hdr)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return size, err
+			}
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return size, err
+			}
+			if err := quarantine.SetOnFile(f); err != nil {
+				f.Close()
+				return size, fmt.Errorf("failed to apply quarantine attribute to file %v: %v", f.Name(), err)
+			}
+			n, err := io.Copy(f, tr)
+			size += n
+			if err := cmp.Or(err, f.Close()); err != nil {
+				return size, err
+			}
+			if err := os.Chmod(p, hdr.FileInfo().Mode().Perm()); err != nil {
+				return size, err
+			}
+			if err := os.Chtimes(p, time.Time{}, hdr.ModTime); err != nil {
+				return size, err
+			}
+		}
+	}
+	// Directories are listed before their contents, so apply their metadata
+	// in reverse, once their contents were written.
+	for _, hdr := range slices.Backward(dirs) {
+		p := filepath.Join(root, filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/")))
+		if err := os.Chtimes(p, time.Time{}, hdr.ModTime); err != nil {
+			return size, err
+		}
+		if err := os.Chmod(p, hdr.FileInfo().Mode().Perm()); err != nil {
+			return size, err
+		}
+	}
+	return size, nil
+}
+
 func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targetFile string, size int64, err error) {
+	if wf.Dir {
+		return receiveDir(ctx, wf, dir)
+	}
 	rc, size, err := localClient.GetWaitingFile(ctx, wf.Name)
 	if err != nil {
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
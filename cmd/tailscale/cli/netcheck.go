@@ -6,13 +6,18 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
+	"os"
+	"slices"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
@@ -37,6 +42,9 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.StringVar(&netcheckArgs.stun, "stun", "", `comma-separated additional STUN servers to probe, as "host:port" or "host"`)
+		fs.StringVar(&netcheckArgs.derpMap, "derp-map", "", "if non-empty, path to a JSON DERP map file to use instead of tailscaled's")
+		fs.BoolVar(&netcheckArgs.history, "history", false, "print tailscaled's recent netcheck reports instead of running a new one")
 		return fs
 	})(),
 }
@@ -45,9 +53,18 @@
 	format  string
 	every   time.Duration
 	verbose bool
+	stun    string
+	derpMap string
+	history bool
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
+	if netcheckArgs.history {
+		if netcheckArgs.every != 0 || netcheckArgs.stun != "" || netcheckArgs.derpMap != "" {
+			return errors.New("--history can't be used with --every, --stun or --derp-map")
+		}
+		return runNetcheckHistory(ctx)
+	}
 	logf := logger.WithPrefix(log.Printf, "portmap: ")
 	bus := eventbus.New()
 	defer bus.Close()
@@ -69,6 +86,11 @@ func runNetcheck(ctx context.Context, args []string) error {
 		PortMapper:  pm,
 		UseDNSCache: false, // always resolve, don't cache
 	}
+	for s := range strings.SplitSeq(netcheckArgs.stun, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			c.STUNServers = append(c.STUNServers, s)
+		}
+	}
 	if netcheckArgs.verbose {
 		c.Logf = logger.WithPrefix(log.Printf, "netcheck: ")
 		c.Verbose = true
@@ -84,6 +106,14 @@ func runNetcheck(ctx context.Context, args []string) error {
 		fmt.Fprintln(Stderr, "netcheck: UDP test failure:", err)
 	}
 
+	if netcheckArgs.derpMap != "" {
+		dm, err := readDERPMapFile(netcheckArgs.derpMap)
+		if err != nil {
+			return err
+		}
+		return runNetcheckLoop(ctx, c, dm)
+	}
+
 	dm, err := localClient.CurrentDERPMap(ctx)
 	noRegions := dm != nil && len(dm.Regions) == 0
 	if noRegions {
@@ -100,6 +130,12 @@ func runNetcheck(ctx context.Context, args []string) error {
 			return err
 		}
 	}
+	return runNetcheckLoop(ctx, c, dm)
+}
+
+// runNetcheckLoop runs netcheck reports with c against dm and prints them,
+// repeatedly if --every is set.
+func runNetcheckLoop(ctx context.Context, c *netcheck.Client, dm *tailcfg.DERPMap) error {
 	for {
 		t0 := time.Now()
 		report, err := c.GetReport(ctx, dm, nil)
@@ -163,6 +199,20 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	if report.CaptivePortal != "" {
 		printf("\t* CaptivePortal: %v\n", report.CaptivePortal)
 	}
+	if len(report.STUNServers) > 0 {
+		printf("\t* STUN servers:\n")
+		for _, hp := range slices.Sorted(maps.Keys(report.STUNServers)) {
+			res := report.STUNServers[hp]
+			var addrs []string
+			if res.GlobalV4.IsValid() {
+				addrs = append(addrs, res.GlobalV4.String())
+			}
+			if res.GlobalV6.IsValid() {
+				addrs = append(addrs, res.GlobalV6.String())
+			}
+			printf("\t\t- %s: %-7s (%s)\n", hp, res.Latency().Round(time.Millisecond/10), strings.Join(addrs, ", "))
+		}
+	}
 
 	// When DERP latency checking failed,
 	// magicsock will try to pick the DERP server that
@@ -208,6 +258,97 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	return nil
 }
 
+// runNetcheckHistory prints tailscaled's recent netcheck reports.
+func runNetcheckHistory(ctx context.Context) error {
+	j, err := localClient.NetcheckHistory(ctx)
+	if err != nil {
+		return err
+	}
+	var reports []*netcheck.Report
+	if err := json.Unmarshal(j, &reports); err != nil {
+		return fmt.Errorf("invalid netcheck history JSON: %w", err)
+	}
+	switch netcheckArgs.format {
+	case "":
+	case "json":
+		j, err = json.MarshalIndent(reports, "", "\t")
+	case "json-line":
+		for _, r := range reports {
+			if err := printReport(nil, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", netcheckArgs.format)
+	}
+	if err != nil {
+		return err
+	}
+	if netcheckArgs.format != "" {
+		Stdout.Write(append(j, '\n'))
+		return nil
+	}
+
+	if len(reports) == 0 {
+		printf("No netcheck reports yet.\n")
+		return nil
+	}
+	dm, _ := localClient.CurrentDERPMap(ctx) // only for region names
+	printHistory(Stdout, dm, reports)
+	return nil
+}
+
+// printHistory writes reports to w as a table, one report per line, marking
+// changes in NAT behavior from the previous report.
+func printHistory(w io.Writer, dm *tailcfg.DERPMap, reports []*netcheck.Report) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tUDP\tIPV4\tIPV6\tVARIES-BY-DEST\tPORTMAP\tDERP\t")
+	var prev *netcheck.Report
+	for _, r := range reports {
+		v4, v6 := "-", "-"
+		if r.GlobalV4.IsValid() {
+			v4 = r.GlobalV4.String()
+		}
+		if r.GlobalV6.IsValid() {
+			v6 = r.GlobalV6.String()
+		}
+		derp := "-"
+		if r.PreferredDERP != 0 {
+			derp = fmt.Sprint(r.PreferredDERP)
+			if dm != nil && dm.Regions[r.PreferredDERP] != nil {
+				derp = dm.Regions[r.PreferredDERP].RegionCode
+			}
+			derp += " " + r.RegionLatency[r.PreferredDERP].Round(time.Millisecond).String()
+		}
+		var changed string
+		if prev != nil && (prev.MappingVariesByDestIP != r.MappingVariesByDestIP || prev.UDP != r.UDP) {
+			changed = "<- changed"
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%v\t%s\t%s\t%s\n",
+			r.Now.Local().Format(time.DateTime), r.UDP, v4, v6,
+			r.MappingVariesByDestIP, portMapping(r), derp, changed)
+		prev = r
+	}
+	tw.Flush()
+}
+
+// readDERPMapFile reads a JSON DERP map from the file at path.
+func readDERPMapFile(path string) (*tailcfg.DERPMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dm tailcfg.DERPMap
+	if err := json.Unmarshal(b, &dm); err != nil {
+		return nil, fmt.Errorf("invalid DERP map in %s: %w", path, err)
+	}
+	if len(dm.Regions) == 0 {
+		return nil, fmt.Errorf("DERP map in %s has no regions", path)
+	}
+	return &dm, nil
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !r.AnyPortMappingChecked() {
 		return "not checked"
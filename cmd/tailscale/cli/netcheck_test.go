@@ -0,0 +1,85 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+)
+
+func TestPrintHistory(t *testing.T) {
+	dm := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "nyc"},
+		},
+	}
+	now := time.Unix(1729624521, 0)
+	reports := []*netcheck.Report{
+		{
+			Now:                   now,
+			UDP:                   true,
+			GlobalV4:              netip.MustParseAddrPort("192.0.2.1:41641"),
+			MappingVariesByDestIP: opt.NewBool(false),
+			PreferredDERP:         1,
+			RegionLatency:         map[int]time.Duration{1: 12 * time.Millisecond},
+		},
+		{
+			Now:                   now.Add(time.Minute),
+			UDP:                   true,
+			GlobalV4:              netip.MustParseAddrPort("192.0.2.1:5000"),
+			MappingVariesByDestIP: opt.NewBool(true),
+			PreferredDERP:         2,
+			RegionLatency:         map[int]time.Duration{2: 30 * time.Millisecond},
+		},
+	}
+	var buf bytes.Buffer
+	printHistory(&buf, dm, reports)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines; want 3:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{"VARIES-BY-DEST", "nyc 12ms", "2 30ms"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("line %d = %q; want it to contain %q", i, lines[i], want)
+		}
+	}
+	if strings.Contains(lines[1], "changed") || !strings.HasSuffix(lines[2], "<- changed") {
+		t.Errorf("wrong change markers:\n%s", buf.String())
+	}
+}
+
+func TestReadDERPMapFile(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	if err := os.WriteFile(good, []byte(`{"Regions":{"900":{"RegionID":900,"RegionCode":"corp","Nodes":[{"Name":"900a","RegionID":900,"HostName":"derp.corp.example"}]}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dm, err := readDERPMapFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dm.Regions[900].Nodes[0].HostName; got != "derp.corp.example" {
+		t.Errorf("HostName = %q; want derp.corp.example", got)
+	}
+
+	empty := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(empty, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readDERPMapFile(empty); err == nil {
+		t.Error("readDERPMapFile of map with no regions succeeded")
+	}
+	if _, err := readDERPMapFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("readDERPMapFile of missing file succeeded")
+	}
+}
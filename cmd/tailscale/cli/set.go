@@ -5,10 +5,12 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"net/netip"
+	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
@@ -23,7 +25,9 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/qos"
 	"tailscale.com/types/views"
 	"tailscale.com/version"
 )
@@ -65,6 +69,8 @@ type setArgsT struct {
 	statefulFiltering      bool
 	netfilterMode          string
 	relayServerPort        string
+	pathPolicy             string
+	qosPolicy              string
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -86,6 +92,8 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.reportPosture, "report-posture", false, "allow management plane to gather device posture information")
 	setf.BoolVar(&setArgs.runWebClient, "webclient", false, "expose the web interface for managing this node over Tailscale at port 5252")
 	setf.StringVar(&setArgs.relayServerPort, "relay-server-port", "", hidden+"UDP port number (0 will pick a random unused port) for the relay server to bind to, on all interfaces, or empty string to disable relay server functionality")
+	setf.StringVar(&setArgs.pathPolicy, "path-policy", "", "path to a JSON file with the policy constraining how paths to peers are chosen (DERP regions, peer relays, metered interfaces), or empty string to remove it")
+	setf.StringVar(&setArgs.qosPolicy, "qos-policy", "", "path to a JSON file with the policy for rate limiting, prioritizing, and DSCP marking traffic to peers and subnet routes, or empty string to remove it")
 
 	ffcomplete.Flag(setf, "exit-node", func(args []string) ([]string, ffcomplete.ShellCompDirective, error) {
 		st, err := localClient.Status(context.Background())
@@ -249,6 +257,22 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		maskedPrefs.Prefs.RelayServerPort = ptr.To(int(uport))
 	}
 
+	if setArgs.pathPolicy != "" {
+		pp, err := readPathPolicyFile(setArgs.pathPolicy)
+		if err != nil {
+			return err
+		}
+		maskedPrefs.Prefs.PathPolicy = pp
+	}
+
+	if setArgs.qosPolicy != "" {
+		qp, err := readQoSPolicyFile(setArgs.qosPolicy)
+		if err != nil {
+			return err
+		}
+		maskedPrefs.Prefs.QoSPolicy = qp
+	}
+
 	checkPrefs := curPrefs.Clone()
 	checkPrefs.ApplyEdits(maskedPrefs)
 	if err := localClient.CheckPrefs(ctx, checkPrefs); err != nil {
@@ -296,3 +320,37 @@ func calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet bool, cu
 	}
 	return nil, nil
 }
+
+// readPathPolicyFile reads and validates the JSON-encoded path policy in the
+// named file.
+func readPathPolicyFile(name string) (*pathpolicy.Policy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pp := new(pathpolicy.Policy)
+	if err := json.Unmarshal(b, pp); err != nil {
+		return nil, fmt.Errorf("parsing path policy %s: %w", name, err)
+	}
+	if err := pp.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid path policy %s: %w", name, err)
+	}
+	return pp, nil
+}
+
+// readQoSPolicyFile reads and validates the JSON-encoded QoS policy in the
+// named file.
+func readQoSPolicyFile(name string) (*qos.Policy, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	qp := new(qos.Policy)
+	if err := json.Unmarshal(b, qp); err != nil {
+		return nil, fmt.Errorf("parsing QoS policy %s: %w", name, err)
+	}
+	if err := qp.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid QoS policy %s: %w", name, err)
+	}
+	return qp, nil
+}
@@ -0,0 +1,189 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var sshRecordingsCmd = &ffcli.Command{
+	Name:       "ssh-recordings",
+	ShortUsage: "tailscale ssh-recordings <list|play> [flags]",
+	ShortHelp:  "List and replay Tailscale SSH sessions recorded to local disk",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale ssh-recordings' command lists and replays Tailscale SSH session
+recordings that this node's SSH server stored on local disk, either because
+local recording is enabled with TS_SSH_LOCAL_RECORDING=1 or because no
+recorder was reachable and the SSH policy allowed the session to proceed.
+
+Recordings are in the asciicast v2 format.
+
+`),
+	Exec: func(ctx context.Context, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("tailscale ssh-recordings: unknown subcommand: %s", args[0])
+		}
+		return flag.ErrHelp
+	},
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "list",
+			ShortUsage: "tailscale ssh-recordings list [--json]",
+			ShortHelp:  "List recorded sessions, newest first",
+			Exec:       runSSHRecordingsList,
+			FlagSet: func() *flag.FlagSet {
+				fs := newFlagSet("list")
+				fs.BoolVar(&sshRecordingsArgs.json, "json", false, "output in JSON format")
+				return fs
+			}(),
+		},
+		{
+			Name:       "play",
+			ShortUsage: "tailscale ssh-recordings play [--speed=N] [--idle-limit=D] [--raw] <name>",
+			ShortHelp:  "Replay a recorded session to the terminal",
+			Exec:       runSSHRecordingsPlay,
+			FlagSet: func() *flag.FlagSet {
+				fs := newFlagSet("play")
+				fs.Float64Var(&sshRecordingsArgs.speed, "speed", 1, "playback speed multiplier")
+				fs.DurationVar(&sshRecordingsArgs.idleLimit, "idle-limit", 2*time.Second, "if non-zero, the maximum pause between two outputs")
+				fs.BoolVar(&sshRecordingsArgs.raw, "raw", false, "write the raw asciicast file to stdout instead of replaying it")
+				return fs
+			}(),
+		},
+	},
+}
+
+var sshRecordingsArgs struct {
+	json      bool
+	speed     float64
+	idleLimit time.Duration
+	raw       bool
+}
+
+func runSSHRecordingsList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	recs, err := localClient.SSHRecordings(ctx)
+	if err != nil {
+		return err
+	}
+	if sshRecordingsArgs.json {
+		e := json.NewEncoder(Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(recs)
+	}
+	if len(recs) == 0 {
+		outln("No SSH session recordings.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tTIME\tSIZE\tFROM\tUSER\tCOMMAND\n")
+	for _, r := range recs {
+		from := r.SrcNode
+		if r.SrcNodeUser != "" {
+			from = r.SrcNodeUser + "@" + from
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", r.Name, r.ModTime.Local().Format(time.DateTime), r.Size, from, r.LocalUser, r.Command)
+	}
+	return w.Flush()
+}
+
+func runSSHRecordingsPlay(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale ssh-recordings play <name>")
+	}
+	if sshRecordingsArgs.speed <= 0 {
+		return errors.New("--speed must be positive")
+	}
+	rc, err := localClient.GetSSHRecording(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if sshRecordingsArgs.raw {
+		_, err := io.Copy(Stdout, rc)
+		return err
+	}
+	return playCast(ctx, Stdout, rc, sshRecordingsArgs.speed, sshRecordingsArgs.idleLimit, sleepCtx)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// playCast replays the asciicast v2 recording read from r to w, sleeping
+// between outputs to reproduce the original timing divided by speed. Pauses
+// longer than idleLimit, if non-zero, are shortened to idleLimit.
+func playCast(ctx context.Context, w io.Writer, r io.Reader, speed float64, idleLimit time.Duration, sleep func(context.Context, time.Duration) error) error {
+	br := bufio.NewReader(r)
+	var hdr struct {
+		Version int `json:"version"`
+	}
+	line, err := br.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	if err := json.Unmarshal(line, &hdr); err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	if hdr.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", hdr.Version)
+	}
+	var last float64
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var ev []json.RawMessage
+			var ts float64
+			var typ, data string
+			if jerr := json.Unmarshal(line, &ev); jerr != nil || len(ev) != 3 {
+				return fmt.Errorf("invalid event line %q", line)
+			}
+			if json.Unmarshal(ev[0], &ts) != nil || json.Unmarshal(ev[1], &typ) != nil || json.Unmarshal(ev[2], &data) != nil {
+				return fmt.Errorf("invalid event line %q", line)
+			}
+			if typ == "o" {
+				d := time.Duration((ts - last) / speed * float64(time.Second))
+				if idleLimit > 0 && d > idleLimit {
+					d = idleLimit
+				}
+				if d > 0 {
+					if err := sleep(ctx, d); err != nil {
+						return err
+					}
+				}
+				last = ts
+				if _, err := io.WriteString(w, data); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlayCast(t *testing.T) {
+	const cast = `{"version":2,"width":80,"height":24}
+[0.5,"o","hello "]
+[0.6,"i","ignored"]
+[10.5,"o","world"]
+`
+	var sleeps []time.Duration
+	sleep := func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	var out strings.Builder
+	if err := playCast(context.Background(), &out, strings.NewReader(cast), 2, 3*time.Second, sleep); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "hello world"; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+	wantSleeps := []time.Duration{250 * time.Millisecond, 3 * time.Second}
+	if len(sleeps) != len(wantSleeps) || sleeps[0] != wantSleeps[0] || sleeps[1] != wantSleeps[1] {
+		t.Errorf("sleeps = %v; want %v", sleeps, wantSleeps)
+	}
+
+	if err := playCast(context.Background(), &out, strings.NewReader(`{"version":1}`), 1, 0, sleep); err == nil {
+		t.Error("playCast of v1 recording succeeded; want error")
+	}
+}
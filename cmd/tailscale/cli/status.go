@@ -195,6 +195,9 @@ func runStatus(ctx context.Context, args []string) error {
 		if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if ps.PathPolicy != "" {
+			f("; policy %s", ps.PathPolicy)
+		}
 		f("\n")
 	}
 
@@ -777,6 +777,8 @@ func init() {
 	addPrefFlagMapping("advertise-connector", "AppConnector")
 	addPrefFlagMapping("report-posture", "PostureChecking")
 	addPrefFlagMapping("relay-server-port", "RelayServerPort")
+	addPrefFlagMapping("path-policy", "PathPolicy")
+	addPrefFlagMapping("qos-policy", "QoSPolicy")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
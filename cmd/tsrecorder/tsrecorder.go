@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tsrecorder command is a session recording server for Tailscale SSH and
+// the Kubernetes API server proxy. It joins the tailnet with tsnet and stores
+// recordings in a local directory or an S3-compatible bucket.
+//
+// Usage:
+//
+//	tsrecorder --dst=/var/lib/tsrecorder/recordings
+//	tsrecorder --dst=s3://bucket/prefix/ [--s3-endpoint=https://minio.example.com]
+//
+// The node must then be set as a recorder in the tailnet policy file's SSH
+// rules or the Kubernetes operator's configuration.
+//
+// With --ui, recordings can also be listed and replayed in a browser at
+// http://<hostname>/ by peers granted the tailscale.com/cap/tsrecorder-ui
+// capability.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"tailscale.com/sessionrecording/recorder"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+)
+
+var (
+	flagVerbose    = flag.Bool("verbose", false, "be verbose")
+	flagHostname   = flag.String("hostname", "recorder", "tsnet hostname")
+	flagStateDir   = flag.String("statedir", "", "tsnet state directory; a default one will be created if not provided")
+	flagDst        = flag.String("dst", "", "where to store recordings: a local directory, or s3://bucket[/prefix]")
+	flagS3Endpoint = flag.String("s3-endpoint", "", "if non-empty, the URL of an S3-compatible endpoint to use instead of AWS S3; path-style addressing is used")
+	flagUI         = flag.Bool("ui", false, "serve a web UI to list and replay recordings to peers granted the "+string(tailcfg.PeerCapabilityTsRecorderUI)+" capability")
+)
+
+func main() {
+	flag.Parse()
+	if *flagDst == "" {
+		log.Fatal("--dst is required")
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	st, err := newStorage(ctx, *flagDst)
+	if err != nil {
+		log.Fatalf("setting up storage: %v", err)
+	}
+
+	ts := &tsnet.Server{
+		Hostname: *flagHostname,
+		Dir:      *flagStateDir,
+	}
+	if *flagVerbose {
+		ts.Logf = log.Printf
+	}
+	defer ts.Close()
+	if _, err := ts.Up(ctx); err != nil {
+		log.Fatal(err)
+	}
+	ln, err := ts.Listen("tcp", ":80")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ln.Close()
+
+	rs := &recorder.Server{
+		Storage: st,
+		Logf:    log.Printf,
+	}
+	if *flagUI {
+		lc, err := ts.LocalClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		rs.AuthorizeUI = func(r *http.Request) error {
+			who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
+			if err != nil {
+				return err
+			}
+			if !who.CapMap.HasCapability(tailcfg.PeerCapabilityTsRecorderUI) {
+				return fmt.Errorf("%s lacks the %s capability", who.Node.Name, tailcfg.PeerCapabilityTsRecorderUI)
+			}
+			return nil
+		}
+	}
+	srv := &http.Server{Handler: rs}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Printf("tsrecorder: storing recordings in %s", *flagDst)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// newStorage returns the recorder.Storage for the --dst flag value dst.
+func newStorage(ctx context.Context, dst string) (recorder.Storage, error) {
+	if !strings.HasPrefix(dst, "s3://") {
+		return &recorder.DirStorage{Dir: dst}, nil
+	}
+	u, err := url.Parse(dst)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing bucket name in --dst")
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if *flagS3Endpoint != "" {
+			o.BaseEndpoint = aws.String(*flagS3Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &recorder.S3Storage{
+		Client: client,
+		Bucket: u.Host,
+		Prefix: prefix,
+	}, nil
+}
@@ -15,6 +15,7 @@
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tailscale/xnet/webdav"
 	"tailscale.com/drive/driveimpl/dirfs"
@@ -36,6 +37,14 @@ type Child struct {
 	// with this Child's WebDAV service.
 	Transport http.RoundTripper
 
+	// StatTTL, if non-zero, overrides the TTL of the Handler's StatCache for
+	// this Child. If negative, PROPFIND results for this Child aren't cached.
+	StatTTL time.Duration
+
+	// ContentCache (if specified) caches the contents of files downloaded
+	// from this Child.
+	ContentCache *ContentCache
+
 	rp       *httputil.ReverseProxy
 	initOnce sync.Once
 }
@@ -112,6 +121,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// showing stale stats.
 		// TODO(oxtoacart): maybe only invalidate specific paths
 		h.StatCache.invalidate()
+		if len(pathComponents) >= mpl {
+			h.invalidateContent(r, pathComponents[mpl-1:], mpl)
+		}
 	}
 
 	if len(pathComponents) >= mpl {
@@ -186,9 +198,34 @@ func (h *Handler) delegate(mpl int, pathComponents []string, w http.ResponseWrit
 	u.Path = path.Join(u.Path, shared.Join(pathComponents[1:]...))
 	r.URL = u
 	r.Host = u.Host
+	if child.ContentCache != nil && r.Method == "GET" && cacheableRequest(r) {
+		child.serveCached(w, r, shared.Join(pathComponents[1:]...))
+		return
+	}
 	child.rp.ServeHTTP(w, r)
 }
 
+// invalidateContent removes the file or directory that r modifies, as well as
+// its Destination if any, from the ContentCache of the Child that
+// pathComponents refers to.
+func (h *Handler) invalidateContent(r *http.Request, pathComponents []string, mpl int) {
+	child := h.GetChild(pathComponents[0])
+	if child == nil || child.ContentCache == nil {
+		return
+	}
+	child.ContentCache.invalidate(shared.Join(pathComponents[1:]...))
+	if dest := r.Header.Get("Destination"); dest != "" {
+		destURL, err := url.Parse(dest)
+		if err != nil {
+			return
+		}
+		destinationComponents := shared.CleanAndSplit(destURL.Path)
+		if len(destinationComponents) >= mpl {
+			child.ContentCache.invalidate(shared.Join(destinationComponents[mpl:]...))
+		}
+	}
+}
+
 // SetChildren replaces the entire existing set of children with the given
 // ones. If staticRoot is given, the children will appear with a subfolder
 // bearing named <staticRoot>.
@@ -0,0 +1,301 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package compositedav
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentCache is an on-disk cache of the contents of files on a Child. This
+// avoids downloading the same files again and again, which is especially
+// painful over slow connections.
+//
+// Cached contents are validated with the Child on every GET using the ETag
+// that the Child returned along with them, so only requests for files that
+// haven't changed are served from the cache. The least recently used
+// contents are evicted to keep the total size of the cache under MaxSize.
+//
+// Each file is stored in Dir as <hash>.data, where hash is the hex SHA-256
+// of the file's path within the Child, with a <hash>.json describing it.
+type ContentCache struct {
+	// Dir is the directory in which to store cached contents.
+	Dir string
+
+	// MaxSize is the maximum total size of the cached contents, in bytes.
+	MaxSize int64
+
+	// mu guards the below values.
+	mu      sync.Mutex
+	loaded  bool                     // whether entries was loaded from Dir
+	entries map[string]*contentEntry // by path within the Child
+	size    int64                    // total size of entries
+}
+
+// contentEntry describes a cached file.
+type contentEntry struct {
+	Path         string // path within the Child
+	ETag         string
+	ContentType  string `json:",omitempty"`
+	LastModified string `json:",omitempty"` // as in the Last-Modified header
+	Size         int64
+
+	lastUsed time.Time
+}
+
+// cacheableRequest reports whether the response to r may come from a
+// ContentCache. Requests for ranges of files and conditional requests are
+// always sent to the Child.
+func cacheableRequest(r *http.Request) bool {
+	for _, h := range []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		if r.Header.Get(h) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *ContentCache) fileBase(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+// loadLocked loads the entries from Dir if it hasn't already. It requires
+// that c.mu be held.
+func (c *ContentCache) loadLocked() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.entries = make(map[string]*contentEntry)
+	des, _ := os.ReadDir(c.Dir)
+	for _, de := range des {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Left over from a download that was interrupted.
+			os.Remove(filepath.Join(c.Dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(c.Dir, name))
+		if err != nil {
+			continue
+		}
+		e := new(contentEntry)
+		if err := json.Unmarshal(b, e); err != nil || c.fileBase(e.Path)+".json" != filepath.Join(c.Dir, name) {
+			os.Remove(filepath.Join(c.Dir, name))
+			continue
+		}
+		fi, err := os.Stat(c.fileBase(e.Path) + ".data")
+		if err != nil || fi.Size() != e.Size {
+			c.removeFiles(e.Path)
+			continue
+		}
+		e.lastUsed = fi.ModTime()
+		c.entries[e.Path] = e
+		c.size += e.Size
+	}
+	c.evictLocked()
+}
+
+// get returns the entry for the file at path p, or nil if it isn't cached.
+func (c *ContentCache) get(p string) *contentEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadLocked()
+	e := c.entries[p]
+	if e == nil {
+		return nil
+	}
+	ec := *e
+	return &ec
+}
+
+// open opens the cached contents of e, marking it as used.
+func (c *ContentCache) open(e *contentEntry) (*os.File, error) {
+	f, err := os.Open(c.fileBase(e.Path) + ".data")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	os.Chtimes(f.Name(), time.Time{}, now) // for the LRU order after restarts
+	c.mu.Lock()
+	if ce := c.entries[e.Path]; ce != nil && ce.ETag == e.ETag {
+		ce.lastUsed = now
+	}
+	c.mu.Unlock()
+	return f, nil
+}
+
+// put adds e to the cache, with the contents in the file tmp, which it moves
+// into the cache.
+func (c *ContentCache) put(e *contentEntry, tmp string) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	base := c.fileBase(e.Path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadLocked()
+	c.deleteLocked(e.Path)
+	if err := cmp.Or(os.Rename(tmp, base+".data"), os.WriteFile(base+".json", b, 0600)); err != nil {
+		c.removeFiles(e.Path)
+		return err
+	}
+	e.lastUsed = time.Now()
+	c.entries[e.Path] = e
+	c.size += e.Size
+	c.evictLocked()
+	return nil
+}
+
+// invalidate removes the file or directory at path p, and everything
+// beneath it, from the cache.
+func (c *ContentCache) invalidate(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadLocked()
+	for ep := range c.entries {
+		if ep == p || strings.HasPrefix(ep, strings.TrimSuffix(p, "/")+"/") {
+			c.deleteLocked(ep)
+		}
+	}
+}
+
+// deleteLocked removes the file at path p from the cache. It requires that
+// c.mu be held.
+func (c *ContentCache) deleteLocked(p string) {
+	e := c.entries[p]
+	if e == nil {
+		return
+	}
+	delete(c.entries, p)
+	c.size -= e.Size
+	c.removeFiles(p)
+}
+
+func (c *ContentCache) removeFiles(p string) {
+	base := c.fileBase(p)
+	os.Remove(base + ".json")
+	os.Remove(base + ".data")
+}
+
+// evictLocked evicts the least recently used entries until the cache fits in
+// MaxSize. It requires that c.mu be held.
+func (c *ContentCache) evictLocked() {
+	if c.size <= c.MaxSize {
+		return
+	}
+	entries := make([]*contentEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *contentEntry) int {
+		return a.lastUsed.Compare(b.lastUsed)
+	})
+	for _, e := range entries {
+		if c.size <= c.MaxSize {
+			return
+		}
+		c.deleteLocked(e.Path)
+	}
+}
+
+// serveCached serves the GET request r for the file at path p, which has
+// already been rewritten to go to the Child, using the Child's ContentCache.
+func (c *Child) serveCached(w http.ResponseWriter, r *http.Request, p string) {
+	cc := c.ContentCache
+	e := cc.get(p)
+
+	or := r.Clone(r.Context())
+	or.RequestURI = ""
+	if e != nil {
+		or.Header.Set("If-None-Match", e.ETag)
+	}
+	tr := cmp.Or(c.Transport, http.DefaultTransport)
+	resp, err := tr.RoundTrip(or)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && e != nil {
+		f, err := cc.open(e)
+		if err == nil {
+			defer f.Close()
+			modTime, _ := http.ParseTime(e.LastModified)
+			w.Header().Set("ETag", e.ETag)
+			if e.ContentType != "" {
+				w.Header().Set("Content-Type", e.ContentType)
+			}
+			http.ServeContent(w, r, "", modTime, f)
+			return
+		}
+		// The cached contents went missing, so fetch them again.
+		cc.invalidate(p)
+		c.serveCached(w, r, p)
+		return
+	}
+
+	for k, vv := range resp.Header {
+		switch k {
+		case "Connection", "Keep-Alive", "Transfer-Encoding", "Trailer", "Upgrade":
+			// hop-by-hop headers
+		default:
+			w.Header()[k] = vv
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || strings.HasPrefix(etag, "W/") || resp.ContentLength > cc.MaxSize {
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	// Save the contents while sending them on.
+	if err := os.MkdirAll(cc.Dir, 0700); err != nil {
+		io.Copy(w, resp.Body)
+		return
+	}
+	tmp, err := os.CreateTemp(cc.Dir, "*.tmp")
+	if err != nil {
+		io.Copy(w, resp.Body)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(w, io.TeeReader(io.LimitReader(resp.Body, cc.MaxSize+1), tmp))
+	if err == nil && n > cc.MaxSize {
+		// Too large to cache, send the rest without saving it.
+		_, err = io.Copy(w, resp.Body)
+		err = cmp.Or(err, errors.New("too large"))
+	}
+	if err := cmp.Or(err, tmp.Close()); err != nil || (resp.ContentLength >= 0 && n != resp.ContentLength) {
+		tmp.Close()
+		return
+	}
+	cc.put(&contentEntry{
+		Path:         p,
+		ETag:         etag,
+		ContentType:  resp.Header.Get("Content-Type"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Size:         n,
+	}, tmp.Name())
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package compositedav
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tailscale/xnet/webdav"
+	"tailscale.com/drive/driveimpl/dirfs"
+)
+
+// contentCacheTestEnv is a Handler with a single Child, "child", that serves
+// the files in dir over WebDAV and caches their contents.
+type contentCacheTestEnv struct {
+	h   *Handler
+	cc  *ContentCache
+	dir string
+
+	mu       sync.Mutex
+	statuses []int // of GET requests to the Child
+}
+
+func newContentCacheTestEnv(t *testing.T, maxSize int64) *contentCacheTestEnv {
+	env := &contentCacheTestEnv{
+		dir: t.TempDir(),
+		cc:  &ContentCache{Dir: t.TempDir(), MaxSize: maxSize},
+	}
+	wh := &webdav.Handler{
+		FileSystem: webdav.Dir(env.dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			wh.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		wh.ServeHTTP(rec, r)
+		env.mu.Lock()
+		env.statuses = append(env.statuses, rec.Code)
+		env.mu.Unlock()
+		for k, vv := range rec.Header() {
+			w.Header()[k] = vv
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+
+	env.h = &Handler{}
+	env.h.SetChildren("", &Child{
+		Child:        &dirfs.Child{Name: "child"},
+		BaseURL:      func() (string, error) { return srv.URL, nil },
+		ContentCache: env.cc,
+	})
+	return env
+}
+
+func (env *contentCacheTestEnv) do(t *testing.T, method, p string, header http.Header, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "/child/"+p, strings.NewReader(body))
+	for k, vv := range header {
+		r.Header[k] = vv
+	}
+	w := httptest.NewRecorder()
+	env.h.ServeHTTP(w, r)
+	return w
+}
+
+// get gets the file at p and checks that its contents are want, and that the
+// Child responded with wantStatus.
+func (env *contentCacheTestEnv) get(t *testing.T, p, want string, wantStatus int) {
+	t.Helper()
+	w := env.do(t, "GET", p, nil, "")
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("GET %s = %d %q; want %d %q", p, w.Code, w.Body.String(), http.StatusOK, want)
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if got := env.statuses[len(env.statuses)-1]; got != wantStatus {
+		t.Errorf("GET %s: Child responded with %d; want %d", p, got, wantStatus)
+	}
+}
+
+func (env *contentCacheTestEnv) write(t *testing.T, p, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(env.dir, p), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentCache(t *testing.T) {
+	env := newContentCacheTestEnv(t, 1<<20)
+	env.write(t, "a.txt", "hello")
+
+	env.get(t, "a.txt", "hello", http.StatusOK)
+	env.get(t, "a.txt", "hello", http.StatusNotModified)
+	if e := env.cc.get("/a.txt"); e == nil || e.Size != 5 {
+		t.Fatalf("cache entry = %+v; want one of size 5", e)
+	}
+
+	// Changes on the remote are picked up.
+	env.write(t, "a.txt", "hello world")
+	os.Chtimes(filepath.Join(env.dir, "a.txt"), time.Time{}, time.Now().Add(time.Minute))
+	env.get(t, "a.txt", "hello world", http.StatusOK)
+	env.get(t, "a.txt", "hello world", http.StatusNotModified)
+
+	// Range requests bypass the cache.
+	w := env.do(t, "GET", "a.txt", http.Header{"Range": {"bytes=0-4"}}, "")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "hello" {
+		t.Errorf("range GET = %d %q; want %d %q", w.Code, w.Body.String(), http.StatusPartialContent, "hello")
+	}
+
+	// Local writes invalidate the cache.
+	if w := env.do(t, "PUT", "a.txt", nil, "bye"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d; want %d", w.Code, http.StatusCreated)
+	}
+	if e := env.cc.get("/a.txt"); e != nil {
+		t.Errorf("cache entry after PUT = %+v; want none", e)
+	}
+	env.get(t, "a.txt", "bye", http.StatusOK)
+
+	// The cache survives restarts.
+	cc := &ContentCache{Dir: env.cc.Dir, MaxSize: env.cc.MaxSize}
+	if e := cc.get("/a.txt"); e == nil || e.Size != 3 {
+		t.Errorf("cache entry after restart = %+v; want one of size 3", e)
+	}
+	f, err := cc.open(cc.get("/a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if b, _ := io.ReadAll(f); string(b) != "bye" {
+		t.Errorf("cached contents after restart = %q; want %q", b, "bye")
+	}
+}
+
+func TestContentCacheEviction(t *testing.T) {
+	env := newContentCacheTestEnv(t, 10)
+	env.write(t, "a.txt", "aaaaaa")
+	env.write(t, "b.txt", "bbbbbb")
+	env.write(t, "big.txt", "this is too large to cache")
+
+	env.get(t, "a.txt", "aaaaaa", http.StatusOK)
+	env.get(t, "b.txt", "bbbbbb", http.StatusOK)
+	if env.cc.get("/a.txt") != nil {
+		t.Error("least recently used file wasn't evicted")
+	}
+	env.get(t, "b.txt", "bbbbbb", http.StatusNotModified)
+
+	env.get(t, "big.txt", "this is too large to cache", http.StatusOK)
+	if env.cc.get("/big.txt") != nil {
+		t.Error("file larger than MaxSize was cached")
+	}
+	if env.cc.size > env.cc.MaxSize {
+		t.Errorf("cache size = %d; want at most %d", env.cc.size, env.cc.MaxSize)
+	}
+	des, err := os.ReadDir(env.cc.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(des) != 2 {
+		t.Errorf("cache directory has %d entries; want 2 for b.txt", len(des))
+	}
+}
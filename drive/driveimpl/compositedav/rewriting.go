@@ -10,6 +10,7 @@
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"tailscale.com/drive/driveimpl/shared"
 )
@@ -24,7 +25,17 @@ func (h *Handler) handlePROPFIND(w http.ResponseWriter, r *http.Request, pathCom
 		// Delegate to a Child.
 		depth := getDepth(r)
 
-		status, result := h.StatCache.getOr(r.URL.Path, depth, func() (int, []byte) {
+		var ttl time.Duration
+		if child := h.GetChild(pathComponents[mpl-1]); child != nil {
+			ttl = child.StatTTL
+		}
+		if ttl < 0 {
+			// Caching is disabled for this Child.
+			status, result := h.delegateRewriting(w, r, pathComponents, mpl)
+			respondRewritten(w, status, result)
+			return
+		}
+		status, result := h.StatCache.getOr(r.URL.Path, depth, ttl, func() (int, []byte) {
 			return h.delegateRewriting(w, r, pathComponents, mpl)
 		})
 
@@ -64,8 +64,9 @@ type StatCache struct {
 // value was found, it returns http.StatusMultiStatus along with the cached
 // value. Otherwise, it executes the given function and returns the resulting
 // status and value. If the function returned http.StatusMultiStatus, getOr
-// caches the resulting value at the given name and depth before returning.
-func (c *StatCache) getOr(name string, depth int, or func() (int, []byte)) (int, []byte) {
+// caches the resulting value at the given name and depth for ttl before
+// returning. If ttl is zero, the cache's TTL is used.
+func (c *StatCache) getOr(name string, depth int, ttl time.Duration, or func() (int, []byte)) (int, []byte) {
 	ce := c.get(name, depth)
 	if ce == nil {
 		// Not cached, fetch value.
@@ -73,7 +74,7 @@ func (c *StatCache) getOr(name string, depth int, or func() (int, []byte)) (int,
 		ce = newCacheEntry(status, raw)
 		if status == http.StatusMultiStatus || status == http.StatusNotFound {
 			// Got a legit status, cache value
-			c.set(name, depth, ce)
+			c.setWithTTL(name, depth, ttl, ce)
 		}
 	}
 	return ce.Status, ce.Raw
@@ -137,6 +138,12 @@ func (c *StatCache) tryGetLocked(name string, depth int) *cacheEntry {
 // store depth 0 entries for all children. If parsing the result fails, nothing
 // is cached.
 func (c *StatCache) set(name string, depth int, ce *cacheEntry) {
+	c.setWithTTL(name, depth, ttlcache.DefaultTTL, ce)
+}
+
+// setWithTTL is like set, but caches the entries for ttl instead of the
+// cache's TTL, unless ttl is zero.
+func (c *StatCache) setWithTTL(name string, depth int, ttl time.Duration, ce *cacheEntry) {
 	if c == nil {
 		return
 	}
@@ -179,17 +186,17 @@ func (c *StatCache) set(name string, depth int, ce *cacheEntry) {
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.setLocked(name, depth, ce)
+	c.setLocked(name, depth, ttl, ce)
 	if self != nil {
-		c.setLocked(name, 0, self)
+		c.setLocked(name, 0, ttl, self)
 	}
 	for childName, child := range children {
-		c.setLocked(childName, 0, child)
+		c.setLocked(childName, 0, ttl, child)
 	}
 }
 
 // setLocked requires that c.mu be held.
-func (c *StatCache) setLocked(name string, depth int, ce *cacheEntry) {
+func (c *StatCache) setLocked(name string, depth int, ttl time.Duration, ce *cacheEntry) {
 	if c.cachesByDepthAndPath == nil {
 		c.cachesByDepthAndPath = make(map[int]*ttlcache.Cache[string, *cacheEntry])
 	}
@@ -201,7 +208,7 @@ func (c *StatCache) setLocked(name string, depth int, ce *cacheEntry) {
 		go cache.Start()
 		c.cachesByDepthAndPath[depth] = cache
 	}
-	cache.Set(name, ce, ttlcache.DefaultTTL)
+	cache.Set(name, ce, ttl)
 }
 
 // invalidate invalidates the entire cache.
@@ -0,0 +1,257 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package driveimpl
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/drive/driveimpl/shared"
+)
+
+// davFS is an nfs.FS that accesses files through a WebDAV http.Handler,
+// in-process. This lets other protocols share the WebDAV implementation,
+// including its caching and the remotes' enforcement of permissions.
+type davFS struct {
+	h http.Handler
+}
+
+// newRequest returns a request with the given method for the file at name.
+func newRequest(ctx context.Context, method, name string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, davURL(name), body)
+}
+
+// do serves req and returns the response. Responses with unsuccessful status
+// codes are returned as errors.
+func (d *davFS) do(req *http.Request) (*bufferingResponseWriter, error) {
+	bw := &bufferingResponseWriter{header: make(http.Header)}
+	d.h.ServeHTTP(bw, req)
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	if bw.status >= 300 {
+		return nil, &davError{method: req.Method, name: req.URL.Path, status: bw.status}
+	}
+	return bw, nil
+}
+
+// doSimple serves a request with the given method and headers, and without
+// a body, for the file at name.
+func (d *davFS) doSimple(ctx context.Context, method, name string, header http.Header) (*bufferingResponseWriter, error) {
+	req, err := newRequest(ctx, method, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	return d.do(req)
+}
+
+// davURL returns the URL of the file at name.
+func davURL(name string) string {
+	return "http://local" + (&url.URL{Path: name}).EscapedPath()
+}
+
+// davError is an unsuccessful response to a WebDAV request.
+type davError struct {
+	method string
+	name   string
+	status int
+}
+
+func (e *davError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.method, e.name, e.status, http.StatusText(e.status))
+}
+
+func (e *davError) Unwrap() error {
+	switch e.status {
+	case http.StatusNotFound, http.StatusConflict:
+		// Conflict means that the parent directory doesn't exist.
+		return fs.ErrNotExist
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fs.ErrPermission
+	case http.StatusMethodNotAllowed, http.StatusPreconditionFailed:
+		// These are the responses to MKCOL and MOVE when the target exists.
+		return fs.ErrExist
+	}
+	return nil
+}
+
+// davFileInfo is the fs.FileInfo of a file listed by PROPFIND.
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *davFileInfo) Name() string       { return fi.name }
+func (fi *davFileInfo) Size() int64        { return fi.size }
+func (fi *davFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *davFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *davFileInfo) Sys() any           { return nil }
+
+func (fi *davFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Prop struct {
+				DisplayName   string `xml:"DAV: displayname"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind returns the file at name and, if depth is 1, its children. The
+// file itself may be nil if it wasn't included in the response.
+func (d *davFS) propfind(ctx context.Context, name string, depth int) (self *davFileInfo, children []*davFileInfo, err error) {
+	bw, err := d.doSimple(ctx, "PROPFIND", name, http.Header{"Depth": {strconv.Itoa(depth)}})
+	if err != nil {
+		return nil, nil, err
+	}
+	var ms davMultistatus
+	if err := xml.Unmarshal(bw.buf.Bytes(), &ms); err != nil {
+		return nil, nil, fmt.Errorf("PROPFIND %s: %w", name, err)
+	}
+	nameDepth := len(shared.CleanAndSplit(name))
+	for _, resp := range ms.Responses {
+		hrefPath := resp.Href
+		if u, err := url.Parse(resp.Href); err == nil && u.Path != "" {
+			hrefPath = u.Path
+		}
+		// Hrefs aren't escaped consistently, so tell the file itself from
+		// its children by the number of path components, and prefer display
+		// names to the last component.
+		hrefDepth := len(shared.CleanAndSplit(hrefPath))
+		fi := &davFileInfo{name: path.Base(name)}
+		switch hrefDepth {
+		case nameDepth:
+		case nameDepth + 1:
+			fi.name = path.Base(hrefPath)
+		default:
+			continue
+		}
+		found := false
+		for _, ps := range resp.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			found = true
+			p := ps.Prop
+			if p.DisplayName != "" {
+				fi.name = p.DisplayName
+			}
+			fi.size, _ = strconv.ParseInt(p.ContentLength, 10, 64)
+			fi.modTime, _ = http.ParseTime(p.LastModified)
+			fi.isDir = p.ResourceType.Collection != nil
+		}
+		switch {
+		case !found:
+		case hrefDepth == nameDepth:
+			self = fi
+		default:
+			children = append(children, fi)
+		}
+	}
+	return self, children, nil
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	fi, _, err := d.propfind(ctx, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return fi, nil
+}
+
+func (d *davFS) ReadDir(ctx context.Context, name string) ([]fs.FileInfo, error) {
+	_, children, err := d.propfind(ctx, name, 1)
+	if err != nil {
+		return nil, err
+	}
+	fis := make([]fs.FileInfo, len(children))
+	for i, fi := range children {
+		fis[i] = fi
+	}
+	return fis, nil
+}
+
+func (d *davFS) ReadAt(ctx context.Context, name string, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	bw, err := d.doSimple(ctx, "GET", name, http.Header{
+		"Range": {fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)},
+	})
+	if de, ok := err.(*davError); ok && de.status == http.StatusRequestedRangeNotSatisfiable {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	b := bw.buf.Bytes()
+	if bw.status != http.StatusPartialContent {
+		// The whole file was sent.
+		b = b[min(off, int64(len(b))):]
+	}
+	n := copy(p, b)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *davFS) WriteFile(ctx context.Context, name string, r io.Reader, size int64) error {
+	req, err := newRequest(ctx, "PUT", name, io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	_, err = d.do(req)
+	return err
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string) error {
+	_, err := d.doSimple(ctx, "MKCOL", name, nil)
+	return err
+}
+
+func (d *davFS) Remove(ctx context.Context, name string) error {
+	_, err := d.doSimple(ctx, "DELETE", name, nil)
+	return err
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	_, err := d.doSimple(ctx, "MOVE", oldName, http.Header{
+		"Destination": {davURL(newName)},
+		"Overwrite":   {"T"},
+	})
+	return err
+}
@@ -4,6 +4,9 @@
 package driveimpl
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -133,6 +136,114 @@ func TestPermissions(t *testing.T) {
 	}
 }
 
+func TestPathPermissions(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadOnly)
+	s.addGrant(remote1, map[string]any{
+		"shares": []string{share11},
+		"access": "rw",
+		"paths":  []string{"/incoming"},
+		"deny":   []string{"*.key", "/private"},
+	})
+	s.mkdir(remote1, share11, "incoming")
+	s.mkdir(remote1, share11, "private")
+	s.mkdir(remote1, share11, "public")
+	s.write(remote1, share11, "public/a.txt", "a")
+	s.write(remote1, share11, "private/b.txt", "b")
+	s.write(remote1, share11, "c.key", "c")
+
+	fis, err := s.client.ReadDir(shared.Join(domain, remote1, share11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	slices.Sort(names)
+	if want := []string{"incoming", "public"}; !slices.Equal(names, want) {
+		t.Errorf("share listing = %q; want %q without denied entries", names, want)
+	}
+	if _, err := s.client.Read(pathTo(remote1, share11, "c.key")); err == nil {
+		t.Error("reading denied file should fail")
+	}
+	if _, err := s.client.Read(pathTo(remote1, share11, "private/b.txt")); err == nil {
+		t.Error("reading file in denied directory should fail")
+	}
+
+	s.writeFile("writing file to read-only path should fail", remote1, share11, "public/d.txt", "d", false)
+	s.writeFile("writing file to read/write path should succeed", remote1, share11, "incoming/d.txt", "d", true)
+	s.writeFile("writing denied file to read/write path should fail", remote1, share11, "incoming/d.key", "d", false)
+
+	if err := s.client.Copy(pathTo(remote1, share11, "public/a.txt"), pathTo(remote1, share11, "incoming/a.txt"), false); err != nil {
+		t.Errorf("copying file from read-only to read/write path should succeed: %v", err)
+	}
+	if err := s.client.Copy(pathTo(remote1, share11, "incoming/d.txt"), pathTo(remote1, share11, "public/d.txt"), false); err == nil {
+		t.Error("copying file from read/write to read-only path should fail")
+	}
+	if err := s.client.Copy(pathTo(remote1, share11, "c.key"), pathTo(remote1, share11, "incoming/c.txt"), false); err == nil {
+		t.Error("copying denied file should fail")
+	}
+	s.renameFile("moving file from read-only to read/write path should fail", remote1, share11, "public/a.txt", share11, "incoming/e.txt", false)
+	s.renameFile("moving file from read/write to read-only path should fail", remote1, share11, "incoming/d.txt", share11, "public/d.txt", false)
+	s.renameFile("moving file to denied name should fail", remote1, share11, "incoming/d.txt", share11, "incoming/d.key", false)
+	s.renameFile("moving file within read/write path should succeed", remote1, share11, "incoming/d.txt", share11, "incoming/e.txt", true)
+	s.checkFileContents(remote1, share11, "incoming/e.txt")
+
+	// Directories that may contain denied files can't be removed or moved as
+	// a whole, but files in them can.
+	s.mkdir(remote1, share11, "incoming/sub")
+	s.write(remote1, share11, "incoming/sub/f.key", "f")
+	if err := s.client.Remove(pathTo(remote1, share11, "incoming/sub")); err == nil {
+		t.Error("deleting directory containing denied file should fail")
+	}
+	s.renameFile("moving directory containing denied file should fail", remote1, share11, "incoming/sub", share11, "incoming/sub2", false)
+	if err := s.client.Remove(pathTo(remote1, share11, "incoming/e.txt")); err != nil {
+		t.Errorf("deleting file in read/write path should succeed: %v", err)
+	}
+	s.write(remote1, share11, "incoming/g.txt", "g")
+	if err := s.client.Copy(pathTo(remote1, share11, "incoming/g.txt"), pathTo(remote1, share11, "incoming/sub"), true); err == nil {
+		t.Error("overwriting directory containing denied file should fail")
+	}
+	s.renameFile("moving file over directory containing denied file should fail", remote1, share11, "incoming/g.txt", share11, "incoming/sub", false)
+	if _, err := os.Stat(filepath.Join(s.remotes[remote1].shares[share11], "incoming", "sub", "f.key")); err != nil {
+		t.Errorf("denied file should still exist: %v", err)
+	}
+}
+
+func TestPathPermissionsTraversal(t *testing.T) {
+	s := newSystem(t)
+
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionNone)
+	s.addGrant(remote1, map[string]any{
+		"shares": []string{share11},
+		"access": "ro",
+		"paths":  []string{"/a/b"},
+	})
+	s.mkdir(remote1, share11, "a/b")
+	s.mkdir(remote1, share11, "a/c")
+	s.write(remote1, share11, "a/b/f.txt", "f")
+	s.write(remote1, share11, "a/g.txt", "g")
+
+	s.checkDirList("share with a path grant should be listed", shared.Join(domain, remote1), share11)
+	s.checkDirList("only the path to the grant should be listed", shared.Join(domain, remote1, share11), "a")
+	s.checkDirList("only the granted directory should be listed", shared.Join(domain, remote1, share11, "a"), "b")
+	s.checkDirList("granted directory should be listed in full", shared.Join(domain, remote1, share11, "a", "b"), "f.txt")
+	if got := s.readViaWebDAV(remote1, share11, "a/b/f.txt"); got != "f" {
+		t.Errorf("read %q; want %q", got, "f")
+	}
+	if _, err := s.client.Read(pathTo(remote1, share11, "a/g.txt")); err == nil {
+		t.Error("reading file outside of granted path should fail")
+	}
+	if _, err := s.client.ReadDir(pathTo(remote1, share11, "a/c")); err == nil {
+		t.Error("listing directory outside of granted path should fail")
+	}
+	s.writeFile("writing file to read-only path should fail", remote1, share11, "a/b/h.txt", "h", false)
+}
+
 // TestMissingPaths verifies that the fileserver running at localhost
 // correctly handles paths with missing required components.
 //
@@ -427,6 +538,88 @@ func TestUNLOCK(t *testing.T) {
 	}
 }
 
+// TestDAVFS tests the file system that the NFS server uses to access files
+// through the local WebDAV handler.
+func TestDAVFS(t *testing.T) {
+	s := newSystem(t)
+	s.addRemote(remote1)
+	s.addShare(remote1, share11, drive.PermissionReadWrite)
+	s.addShare(remote1, share12, drive.PermissionReadOnly)
+	s.write(remote1, share11, file111, "hello world")
+
+	ctx := context.Background()
+	dfs := &davFS{h: s.local.fs.h}
+	abs := func(name string) string {
+		return "/" + pathTo(remote1, share11, name)
+	}
+
+	fis, err := dfs.ReadDir(ctx, "/"+path.Join(domain, remote1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+		if !fi.IsDir() {
+			t.Errorf("share %q isn't a directory", fi.Name())
+		}
+	}
+	slices.Sort(names)
+	if want := []string{share12, share11}; !slices.Equal(names, want) {
+		t.Errorf("shares = %q; want %q", names, want)
+	}
+
+	fi, err := dfs.Stat(ctx, abs(file111))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != file111 || fi.Size() != 11 || fi.IsDir() {
+		t.Errorf("Stat = %q, %d, %v; want %q, 11, false", fi.Name(), fi.Size(), fi.IsDir(), file111)
+	}
+	if _, err := dfs.Stat(ctx, abs("missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of missing file = %v; want ErrNotExist", err)
+	}
+
+	buf := make([]byte, 5)
+	if n, err := dfs.ReadAt(ctx, abs(file111), buf, 6); n != 5 || string(buf) != "world" {
+		t.Errorf("ReadAt = %d, %v, %q; want 5, %q", n, err, buf[:n], "world")
+	}
+	if n, err := dfs.ReadAt(ctx, abs(file111), buf, 20); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v; want 0, EOF", n, err)
+	}
+
+	if err := dfs.Mkdir(ctx, abs("dir")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dfs.Mkdir(ctx, abs("dir")); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir of existing directory = %v; want ErrExist", err)
+	}
+	if err := dfs.WriteFile(ctx, abs("dir/"+file112), strings.NewReader("new"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.read(remote1, share11, "dir/"+file112); got != "new" {
+		t.Errorf("written file = %q; want %q", got, "new")
+	}
+	if err := dfs.Rename(ctx, abs(file111), abs("dir/"+file112)); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.read(remote1, share11, "dir/"+file112); got != "hello world" {
+		t.Errorf("renamed file = %q; want %q", got, "hello world")
+	}
+	if err := dfs.Remove(ctx, abs("dir/"+file112)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dfs.Stat(ctx, abs("dir/"+file112)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of removed file = %v; want ErrNotExist", err)
+	}
+
+	// Permissions are enforced by the remote.
+	err = dfs.WriteFile(ctx, "/"+pathTo(remote1, share12, file112), strings.NewReader("new"), 3)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("WriteFile to read-only share = %v; want ErrPermission", err)
+	}
+}
+
 type local struct {
 	l  net.Listener
 	fs *FileSystemForLocal
@@ -438,6 +631,7 @@ type remote struct {
 	fileServer  *FileServer
 	shares      map[string]string
 	permissions map[string]drive.Permission
+	grants      []string // raw grants in addition to permissions
 	mu          sync.RWMutex
 }
 
@@ -452,7 +646,30 @@ func (r *remote) unfreeze() {
 func (r *remote) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	r.fs.ServeHTTPWithPerms(r.permissions, w, req)
+	rawGrants := make([][]byte, 0, len(r.permissions)+len(r.grants))
+	for share, permission := range r.permissions {
+		if permission == drive.PermissionNone {
+			continue
+		}
+		access := "ro"
+		if permission == drive.PermissionReadWrite {
+			access = "rw"
+		}
+		rawGrant, err := json.Marshal(map[string]any{"shares": []string{share}, "access": access})
+		if err != nil {
+			panic(err)
+		}
+		rawGrants = append(rawGrants, rawGrant)
+	}
+	for _, g := range r.grants {
+		rawGrants = append(rawGrants, []byte(g))
+	}
+	permissions, err := drive.ParsePermissions(rawGrants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	r.fs.ServeHTTPWithPerms(permissions, w, req)
 }
 
 type system struct {
@@ -560,6 +777,21 @@ func (s *system) addShare(remoteName, shareName string, permission drive.Permiss
 	r.fileServer.SetShares(r.shares)
 }
 
+// addGrant adds the given drive grant for the remote's shares.
+func (s *system) addGrant(remoteName string, grant map[string]any) {
+	r, ok := s.remotes[remoteName]
+	if !ok {
+		s.t.Fatalf("unknown remote %q", remoteName)
+	}
+	b, err := json.Marshal(grant)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	r.mu.Lock()
+	r.grants = append(r.grants, string(b))
+	r.mu.Unlock()
+}
+
 func (s *system) freezeRemote(remoteName string) {
 	r, ok := s.remotes[remoteName]
 	if !ok {
@@ -668,6 +900,14 @@ func (s *system) write(remoteName, shareName, name, contents string) {
 	}
 }
 
+func (s *system) mkdir(remoteName, shareName, name string) {
+	dirname := filepath.Join(s.remotes[remoteName].shares[shareName], name)
+	err := os.MkdirAll(dirname, 0755)
+	if err != nil {
+		s.t.Fatalf("failed to MkdirAll: %s", err)
+	}
+}
+
 func (s *system) readViaWebDAV(remoteName, shareName, name string) string {
 	path := pathTo(remoteName, shareName, name)
 	b, err := s.client.Read(path)
@@ -774,3 +1014,41 @@ func (a *noopAuthenticator) Close() error {
   <D:lockscope><D:exclusive/></D:lockscope>
   <D:locktype><D:write/></D:locktype>
 </D:lockinfo>`
+
+func TestFilterMultiStatus(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">` +
+		`<D:response><D:href>/share/a</D:href><D:propstat/></D:response>` +
+		`<D:response><D:href>/share/secret&amp;x</D:href></D:response>` +
+		`<x:response xmlns:x="DAV:"><x:href>/share/secret2</x:href></x:response>` +
+		`<D:response><D:propstat/></D:response>` +
+		`<D:response><D:href>/share/b</D:href></D:response>` +
+		`</D:multistatus>`
+	visible := func(href string) bool {
+		return !strings.HasPrefix(href, "/share/secret")
+	}
+	got, err := filterMultiStatus([]byte(doc), visible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<D:multistatus xmlns:D="DAV:">` +
+		`<D:response><D:href>/share/a</D:href><D:propstat/></D:response>` +
+		`<D:response><D:href>/share/b</D:href></D:response>` +
+		`</D:multistatus>`
+	if string(got) != want {
+		t.Errorf("filtered document:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, bad := range []string{
+		``,
+		`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/share/a</D:href>`,
+		`<D:multistatus xmlns:D="DAV:"><D:response><D:href>/share/a</D:hre></D:response></D:multistatus>`,
+		`<D:other xmlns:D="DAV:"></D:other>`,
+		`<D:multistatus xmlns:D="DAV:"></D:multistatus><D:multistatus xmlns:D="DAV:"></D:multistatus>`,
+	} {
+		if got, err := filterMultiStatus([]byte(bad), visible); err == nil {
+			t.Errorf("filterMultiStatus(%q) = %q; want error", bad, got)
+		}
+	}
+}
@@ -5,14 +5,17 @@
 package driveimpl
 
 import (
+	"cmp"
 	"log"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"tailscale.com/drive"
 	"tailscale.com/drive/driveimpl/compositedav"
 	"tailscale.com/drive/driveimpl/dirfs"
+	"tailscale.com/drive/driveimpl/nfs"
 	"tailscale.com/types/logger"
 )
 
@@ -22,6 +25,10 @@
 	// DirectoryCacheLifetime setting of Windows' built-in SMB client,
 	// see https://learn.microsoft.com/en-us/previous-versions/windows/it-pro/windows-7/ff686200(v=ws.10)
 	statCacheTTL = 10 * time.Second
+
+	// defaultMaxContentSize is the default maximum total size of the cached
+	// contents of files on each remote.
+	defaultMaxContentSize = 256 << 20
 )
 
 // NewFileSystemForLocal starts serving a filesystem for local clients.
@@ -52,6 +59,15 @@ type FileSystemForLocal struct {
 	logf     logger.Logf
 	h        *compositedav.Handler
 	listener *connListener
+
+	// mu guards contentCaches and nfs.
+	mu sync.Mutex
+	// contentCaches are the content caches of the remotes, by remote name.
+	// They're kept across calls to SetRemotes as long as their
+	// configuration doesn't change.
+	contentCaches map[string]*compositedav.ContentCache
+	// nfs serves NFS clients. It's created on first use.
+	nfs *nfs.Server
 }
 
 func (s *FileSystemForLocal) startServing() {
@@ -70,10 +86,28 @@ func (s *FileSystemForLocal) HandleConn(conn net.Conn, remoteAddr net.Addr) erro
 	return s.listener.HandleConn(conn, remoteAddr)
 }
 
+// HandleNFSConn handles connections from local NFSv3 clients. They're
+// served the same files as WebDAV clients, through the WebDAV handler.
+func (s *FileSystemForLocal) HandleNFSConn(conn net.Conn) error {
+	s.mu.Lock()
+	if s.nfs == nil {
+		s.nfs = &nfs.Server{
+			FS:   &davFS{h: s.h},
+			Logf: s.logf,
+		}
+	}
+	srv := s.nfs
+	s.mu.Unlock()
+	return srv.HandleConn(conn)
+}
+
 // SetRemotes sets the complete set of remotes on the given tailnet domain
 // using a map of name -> url. If transport is specified, that transport
 // will be used to connect to these remotes.
 func (s *FileSystemForLocal) SetRemotes(domain string, remotes []*drive.Remote, transport http.RoundTripper) {
+	s.mu.Lock()
+	oldCaches := s.contentCaches
+	s.contentCaches = make(map[string]*compositedav.ContentCache)
 	children := make([]*compositedav.Child, 0, len(remotes))
 	for _, remote := range remotes {
 		children = append(children, &compositedav.Child{
@@ -81,17 +115,48 @@ func (s *FileSystemForLocal) SetRemotes(domain string, remotes []*drive.Remote,
 				Name:      remote.Name,
 				Available: remote.Available,
 			},
-			BaseURL:   func() (string, error) { return remote.URL, nil },
-			Transport: transport,
+			BaseURL:      func() (string, error) { return remote.URL, nil },
+			Transport:    transport,
+			StatTTL:      remote.Cache.StatTTL.Duration,
+			ContentCache: s.contentCacheLocked(oldCaches, remote),
 		})
 	}
+	s.mu.Unlock()
 
 	s.h.SetChildren(domain, children...)
 }
 
+// contentCacheLocked returns the content cache for remote, reusing the one in
+// oldCaches if it has the same configuration, or nil if the remote's file
+// contents aren't cached. It requires that s.mu be held.
+func (s *FileSystemForLocal) contentCacheLocked(oldCaches map[string]*compositedav.ContentCache, remote *drive.Remote) *compositedav.ContentCache {
+	maxSize := cmp.Or(remote.Cache.MaxContentSize, defaultMaxContentSize)
+	if remote.CacheDir == "" || maxSize < 0 {
+		return nil
+	}
+	cc := oldCaches[remote.Name]
+	if cc == nil || cc.Dir != remote.CacheDir || cc.MaxSize != maxSize {
+		cc = &compositedav.ContentCache{
+			Dir:     remote.CacheDir,
+			MaxSize: maxSize,
+		}
+	}
+	s.contentCaches[remote.Name] = cc
+	return cc
+}
+
 // Close() stops serving the WebDAV content
 func (s *FileSystemForLocal) Close() error {
 	err := s.listener.Close()
+	s.mu.Lock()
+	srv := s.nfs
+	s.mu.Unlock()
+	if srv != nil {
+		// Write any files that NFS clients didn't commit.
+		if err := srv.Close(); err != nil {
+			s.logf("closing NFS server: %v", err)
+		}
+	}
 	s.h.Close()
 	return err
 }
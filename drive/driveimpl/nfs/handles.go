@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"strings"
+
+	"tailscale.com/util/lru"
+)
+
+// maxHandles is the maximum number of file IDs a Server remembers besides
+// the root's. Handles of files whose IDs were forgotten are stale, which
+// clients recover from by looking the files up again.
+const maxHandles = 1 << 16
+
+// rootID is the file ID of the root directory, which is never forgotten.
+const rootID = 1
+
+// handleTable assigns file IDs, which are part of file handles, to paths,
+// remembering up to max of them besides the root's and forgetting the least
+// recently used ones.
+//
+// It's not safe for concurrent use.
+type handleTable struct {
+	max    int
+	nextID uint64
+
+	// paths and ids hold the same entries, keyed in opposite directions.
+	// Every use of an entry touches it in both, so that both evict the
+	// same entries.
+	paths lru.Cache[uint64, string] // by file ID
+	ids   lru.Cache[string, uint64] // by path
+}
+
+func newHandleTable(max int) *handleTable {
+	t := &handleTable{max: max, nextID: rootID + 1}
+	t.paths.MaxEntries = max
+	t.ids.MaxEntries = max
+	return t
+}
+
+// id returns the file ID of path p, assigning a new one if needed.
+func (t *handleTable) id(p string) uint64 {
+	if p == "/" {
+		return rootID
+	}
+	if id, ok := t.ids.GetOk(p); ok {
+		t.paths.Get(id)
+		return id
+	}
+	id := t.nextID
+	t.nextID++
+	t.ids.Set(p, id)
+	t.paths.Set(id, p)
+	return id
+}
+
+// path returns the path with the file ID id, if it's remembered.
+func (t *handleTable) path(id uint64) (string, bool) {
+	if id == rootID {
+		return "/", true
+	}
+	p, ok := t.paths.GetOk(id)
+	if ok {
+		t.ids.Get(p)
+	}
+	return p, ok
+}
+
+// beneath returns the remembered paths that are p or beneath it, along with
+// their file IDs.
+func (t *handleTable) beneath(p string) map[string]uint64 {
+	ret := make(map[string]uint64)
+	t.ids.ForEach(func(pp string, id uint64) {
+		if pp == p || strings.HasPrefix(pp, p+"/") {
+			ret[pp] = id
+		}
+	})
+	return ret
+}
+
+// forget forgets the file IDs of p and of any paths beneath it.
+func (t *handleTable) forget(p string) {
+	for pp, id := range t.beneath(p) {
+		t.ids.Delete(pp)
+		t.paths.Delete(id)
+	}
+}
+
+// renamed moves the file IDs of oldPath and of any paths beneath it to the
+// corresponding paths beneath newPath, forgetting newPath's own.
+func (t *handleTable) renamed(oldPath, newPath string) {
+	if id, ok := t.ids.PeekOk(newPath); ok {
+		t.ids.Delete(newPath)
+		t.paths.Delete(id)
+	}
+	for p, id := range t.beneath(oldPath) {
+		np := newPath + strings.TrimPrefix(p, oldPath)
+		t.ids.Delete(p)
+		t.ids.Set(np, id)
+		t.paths.Set(id, np)
+	}
+}
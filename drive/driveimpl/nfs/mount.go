@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import "path"
+
+// MOUNT procedures (RFC 1813, appendix I).
+const (
+	mountProcNull    = 0
+	mountProcMnt     = 1
+	mountProcDump    = 2
+	mountProcUmnt    = 3
+	mountProcUmntAll = 4
+	mountProcExport  = 5
+)
+
+const (
+	mntOK        = 0
+	mntErrNoEnt  = 2
+	mntErrNotDir = 20
+
+	maxPathLen = 1024
+)
+
+func (s *Server) handleMount(call *rpcCall, w *xdrWriter) error {
+	r := call.args
+	switch call.proc {
+	case mountProcNull, mountProcUmntAll:
+		return nil
+	case mountProcMnt:
+		p := path.Clean("/" + r.string(maxPathLen))
+		if r.err != nil {
+			return errGarbageArgs
+		}
+		// Any directory may be mounted, not just the root, so that clients
+		// can mount individual shares.
+		fi, err := s.stat(p)
+		switch {
+		case err != nil:
+			w.uint32(mntErrNoEnt)
+		case !fi.IsDir():
+			w.uint32(mntErrNotDir)
+		default:
+			w.uint32(mntOK)
+			w.opaque(s.handleFor(p))
+			w.uint32(2) // auth flavors
+			w.uint32(authSys)
+			w.uint32(authNone)
+		}
+		return nil
+	case mountProcDump:
+		// Mounts aren't tracked.
+		w.bool(false)
+		return nil
+	case mountProcUmnt:
+		r.string(maxPathLen)
+		return r.err
+	case mountProcExport:
+		w.bool(true)
+		w.string("/")
+		w.bool(false) // no groups
+		w.bool(false)
+		return nil
+	}
+	return errProcUnavail
+}
@@ -0,0 +1,311 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package nfs provides a minimal userspace NFSv3 server (RFC 1813), which
+// serves an FS over TCP so that it can be mounted with standard kernel NFS
+// clients.
+//
+// Only the MOUNT and NFS programs are served, both on the same port, so
+// clients have to be told the port instead of asking the portmapper, and
+// have to do without the NLM locking protocol. On Linux, for example:
+//
+//	mount -t nfs -o vers=3,proto=tcp,port=2049,mountport=2049,mountproto=tcp,nolock 100.100.100.100:/ /mnt/taildrive
+//
+// The server doesn't authenticate clients. AUTH_SYS credentials are only used
+// to report files as owned by the caller, and all callers get the same access
+// to the FS, so it must only be served to trusted local clients.
+package nfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// FS is a file system that can be served over NFS. Names are slash-separated
+// paths starting with a slash, like "/dir/file.txt".
+//
+// Errors should wrap fs.ErrNotExist, fs.ErrExist and fs.ErrPermission where
+// applicable, so that clients get the corresponding NFS errors.
+type FS interface {
+	Stat(ctx context.Context, name string) (fs.FileInfo, error)
+	ReadDir(ctx context.Context, name string) ([]fs.FileInfo, error)
+	ReadAt(ctx context.Context, name string, p []byte, off int64) (int, error)
+
+	// WriteFile creates or replaces the file name with the size bytes read
+	// from r.
+	WriteFile(ctx context.Context, name string, r io.Reader, size int64) error
+
+	Mkdir(ctx context.Context, name string) error
+
+	// Remove removes the file or empty directory name.
+	Remove(ctx context.Context, name string) error
+
+	// Rename renames oldName to newName, replacing newName if it exists.
+	Rename(ctx context.Context, oldName, newName string) error
+}
+
+const (
+	progNFS   = 100003
+	progMount = 100005
+
+	nfsVersion   = 3
+	mountVersion = 3
+
+	// maxIOSize is the maximum size of READ and WRITE requests.
+	maxIOSize = 1 << 20
+
+	// fsid is the file system ID reported in file attributes.
+	fsid = 0x7473 // "ts"
+)
+
+// NFSv3 status codes.
+const (
+	nfsOK           = 0
+	nfsErrPerm      = 1
+	nfsErrNoEnt     = 2
+	nfsErrIO        = 5
+	nfsErrAcces     = 13
+	nfsErrExist     = 17
+	nfsErrNotDir    = 20
+	nfsErrIsDir     = 21
+	nfsErrInval     = 22
+	nfsErrNotEmpty  = 66
+	nfsErrStale     = 70
+	nfsErrBadHandle = 10001
+	nfsErrBadCookie = 10003
+	nfsErrNotSupp   = 10004
+	nfsErrTooSmall  = 10005
+)
+
+// Server is an NFSv3 server.
+type Server struct {
+	// FS is the file system to serve.
+	FS FS
+
+	// Logf, if non-nil, is used for logging.
+	Logf logger.Logf
+
+	// TempDir, if non-empty, is the directory in which to buffer written
+	// files until they're written to FS. The default is os.TempDir.
+	TempDir string
+
+	initOnce sync.Once
+	ctx      context.Context // canceled by Close
+	cancel   context.CancelFunc
+
+	// verf identifies this instance of the Server. It's part of all file
+	// handles, so that clients get stale file handle errors for handles
+	// from earlier instances, and is the write verifier, so that clients
+	// send uncommitted writes again after a restart.
+	verf [8]byte
+
+	mu      sync.Mutex
+	handles *handleTable
+	pending map[string]*pendingFile // by path
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		rand.Read(s.verf[:])
+		s.handles = newHandleTable(maxHandles)
+		s.pending = make(map[string]*pendingFile)
+	})
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// HandleConn serves NFS and MOUNT calls on c until it's closed.
+func (s *Server) HandleConn(c net.Conn) error {
+	s.init()
+	return serveConn(c, s.handle)
+}
+
+// Close writes all pending writes to the FS, and stops them from being
+// written in the background.
+func (s *Server) Close() error {
+	s.init()
+	s.mu.Lock()
+	pending := slices.Collect(maps.Keys(s.pending))
+	s.mu.Unlock()
+	var errs []error
+	for _, name := range pending {
+		if err := s.flush(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.cancel()
+	return errors.Join(errs...)
+}
+
+func (s *Server) handle(call *rpcCall, w *xdrWriter) error {
+	switch call.prog {
+	case progMount:
+		if call.vers != mountVersion {
+			return progMismatchError{mountVersion, mountVersion}
+		}
+		return s.handleMount(call, w)
+	case progNFS:
+		if call.vers != nfsVersion {
+			return progMismatchError{nfsVersion, nfsVersion}
+		}
+		return s.handleNFS(call, w)
+	}
+	return errProgUnavail
+}
+
+// handleFor returns the file handle of the file at path p.
+func (s *Server) handleFor(p string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.handles.id(p)
+	return binary.BigEndian.AppendUint64(s.verf[:len(s.verf):len(s.verf)], id)
+}
+
+// fileID returns the file ID of the file at path p, which is also its inode
+// number as far as clients are concerned.
+func (s *Server) fileID(p string) uint64 {
+	fh := s.handleFor(p)
+	return binary.BigEndian.Uint64(fh[len(s.verf):])
+}
+
+// pathFor returns the path of the file with the handle fh, or an NFS error
+// status.
+func (s *Server) pathFor(fh []byte) (string, uint32) {
+	if len(fh) != len(s.verf)+8 {
+		return "", nfsErrBadHandle
+	}
+	if string(fh[:len(s.verf)]) != string(s.verf[:]) {
+		return "", nfsErrStale
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.handles.path(binary.BigEndian.Uint64(fh[len(s.verf):]))
+	if !ok {
+		return "", nfsErrStale
+	}
+	return p, nfsOK
+}
+
+// renamed updates the file handles for the files at and beneath oldPath to
+// refer to newPath.
+func (s *Server) renamed(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handles.renamed(oldPath, newPath)
+}
+
+// childPath returns the path of name in the directory dir, or an NFS error
+// status if name isn't a valid file name.
+func childPath(dir, name string) (string, uint32) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return "", nfsErrInval
+	}
+	return path.Join(dir, name), nfsOK
+}
+
+// nfsStatus returns the NFS status for err.
+func nfsStatus(err error) uint32 {
+	switch {
+	case err == nil:
+		return nfsOK
+	case errors.Is(err, fs.ErrNotExist):
+		return nfsErrNoEnt
+	case errors.Is(err, fs.ErrExist):
+		return nfsErrExist
+	case errors.Is(err, fs.ErrPermission):
+		return nfsErrAcces
+	}
+	return nfsErrIO
+}
+
+// stat returns the file info of the file at path p, taking pending writes
+// into account.
+func (s *Server) stat(p string) (fs.FileInfo, error) {
+	fi, err := s.FS.Stat(s.ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if pf := s.pendingFile(p); pf != nil {
+		fi = pf.fileInfo(fi)
+	}
+	return fi, nil
+}
+
+// fattr3 encodes the attributes of the file at path p with info fi.
+func (s *Server) fattr3(w *xdrWriter, call *rpcCall, p string, fi fs.FileInfo) {
+	typ, mode, nlink := uint32(1), uint32(0o644), uint32(1) // NF3REG
+	if fi.IsDir() {
+		typ, mode, nlink = 2, 0o755, 2 // NF3DIR
+	}
+	w.uint32(typ)
+	w.uint32(mode)
+	w.uint32(nlink)
+	// Report every file as owned by the caller, as the FS has no notion of
+	// local users. Access is controlled by the FS.
+	w.uint32(call.uid)
+	w.uint32(call.gid)
+	size := uint64(max(fi.Size(), 0))
+	if fi.IsDir() {
+		size = 4096
+	}
+	w.uint64(size) // size
+	w.uint64(size) // used
+	w.uint32(0)    // rdev
+	w.uint32(0)
+	w.uint64(fsid)
+	w.uint64(s.fileID(p))
+	for range 3 { // atime, mtime, ctime
+		nfstime3(w, fi.ModTime())
+	}
+}
+
+func nfstime3(w *xdrWriter, t time.Time) {
+	if t.IsZero() || t.Unix() < 0 {
+		w.uint32(0)
+		w.uint32(0)
+		return
+	}
+	w.uint32(uint32(t.Unix()))
+	w.uint32(uint32(t.Nanosecond()))
+}
+
+// postOpAttr encodes the post_op_attr of the file at path p, if it can be
+// stated.
+func (s *Server) postOpAttr(w *xdrWriter, call *rpcCall, p string) {
+	if p == "" {
+		w.bool(false)
+		return
+	}
+	fi, err := s.stat(p)
+	if err != nil {
+		w.bool(false)
+		return
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+}
+
+// wccData encodes the wcc_data of the file at path p. Pre-operation
+// attributes are never sent.
+func (s *Server) wccData(w *xdrWriter, call *rpcCall, p string) {
+	w.bool(false)
+	s.postOpAttr(w, call, p)
+}
@@ -0,0 +1,634 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// NFSv3 procedures (RFC 1813, section 3).
+const (
+	nfsProcNull        = 0
+	nfsProcGetattr     = 1
+	nfsProcSetattr     = 2
+	nfsProcLookup      = 3
+	nfsProcAccess      = 4
+	nfsProcReadlink    = 5
+	nfsProcRead        = 6
+	nfsProcWrite       = 7
+	nfsProcCreate      = 8
+	nfsProcMkdir       = 9
+	nfsProcSymlink     = 10
+	nfsProcMknod       = 11
+	nfsProcRemove      = 12
+	nfsProcRmdir       = 13
+	nfsProcRename      = 14
+	nfsProcLink        = 15
+	nfsProcReaddir     = 16
+	nfsProcReaddirplus = 17
+	nfsProcFsstat      = 18
+	nfsProcFsinfo      = 19
+	nfsProcPathconf    = 20
+	nfsProcCommit      = 21
+)
+
+const (
+	maxHandleSize = 64
+	maxNameLen    = 255
+
+	// Values of stable_how.
+	unstable = 0
+	fileSync = 2
+
+	// Values of createmode3.
+	createUnchecked = 0
+	createExclusive = 2
+
+	// Values of time_how.
+	setToClientTime = 2
+
+	accessExecute = 0x20
+)
+
+func (s *Server) handleNFS(call *rpcCall, w *xdrWriter) error {
+	r := call.args
+	switch call.proc {
+	case nfsProcNull:
+		return nil
+	case nfsProcGetattr:
+		return s.getattr(call, r, w)
+	case nfsProcSetattr:
+		return s.setattr(call, r, w)
+	case nfsProcLookup:
+		return s.lookup(call, r, w)
+	case nfsProcAccess:
+		return s.access(call, r, w)
+	case nfsProcReadlink:
+		w.uint32(nfsErrNotSupp)
+		w.bool(false)
+		return nil
+	case nfsProcRead:
+		return s.read(call, r, w)
+	case nfsProcWrite:
+		return s.write(call, r, w)
+	case nfsProcCreate:
+		return s.create(call, r, w)
+	case nfsProcMkdir:
+		return s.mkdir(call, r, w)
+	case nfsProcSymlink, nfsProcMknod:
+		// Only regular files and directories are supported.
+		w.uint32(nfsErrNotSupp)
+		w.bool(false) // dir_wcc
+		w.bool(false)
+		return nil
+	case nfsProcRemove, nfsProcRmdir:
+		return s.remove(call, r, w)
+	case nfsProcRename:
+		return s.rename(call, r, w)
+	case nfsProcLink:
+		w.uint32(nfsErrNotSupp)
+		w.bool(false) // file_attributes
+		w.bool(false) // linkdir_wcc
+		w.bool(false)
+		return nil
+	case nfsProcReaddir, nfsProcReaddirplus:
+		return s.readdir(call, r, w)
+	case nfsProcFsstat:
+		return s.fsstat(call, r, w)
+	case nfsProcFsinfo:
+		return s.fsinfo(call, r, w)
+	case nfsProcPathconf:
+		return s.pathconf(call, r, w)
+	case nfsProcCommit:
+		return s.commit(call, r, w)
+	}
+	return errProcUnavail
+}
+
+// sattr3 is the subset of file attributes that can be set.
+type sattr3 struct {
+	setSize bool
+	size    uint64
+}
+
+// readSattr3 decodes a sattr3. Only the size is used; the FS has no notion
+// of modes or owners, and sets modification times itself.
+func readSattr3(r *xdrReader) sattr3 {
+	var a sattr3
+	for range 3 { // mode, uid, gid
+		if r.bool() {
+			r.uint32()
+		}
+	}
+	if a.setSize = r.bool(); a.setSize {
+		a.size = r.uint64()
+	}
+	for range 2 { // atime, mtime
+		if r.uint32() == setToClientTime {
+			r.uint32()
+			r.uint32()
+		}
+	}
+	return a
+}
+
+// readDirOp decodes diropargs3, returning the path of the named file in the
+// directory, or an NFS error status.
+func (s *Server) readDirOp(r *xdrReader) (dir, p string, status uint32) {
+	fh := r.opaque(maxHandleSize)
+	name := r.string(maxNameLen)
+	if r.err != nil {
+		return "", "", nfsOK
+	}
+	dir, status = s.pathFor(fh)
+	if status != nfsOK {
+		return "", "", status
+	}
+	p, status = childPath(dir, name)
+	return dir, p, status
+}
+
+// statHandle returns the path and file info of the file with handle fh, or
+// an NFS error status.
+func (s *Server) statHandle(fh []byte) (string, fs.FileInfo, uint32) {
+	p, status := s.pathFor(fh)
+	if status != nfsOK {
+		return "", nil, status
+	}
+	fi, err := s.stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nfsErrStale
+	}
+	if err != nil {
+		return "", nil, nfsStatus(err)
+	}
+	return p, fi, nfsOK
+}
+
+func (s *Server) getattr(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	w.uint32(status)
+	if status == nfsOK {
+		s.fattr3(w, call, p, fi)
+	}
+	return nil
+}
+
+func (s *Server) setattr(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	a := readSattr3(r)
+	if r.bool() { // guard
+		r.uint32()
+		r.uint32()
+	}
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	if status == nfsOK && a.setSize {
+		if fi.IsDir() {
+			status = nfsErrIsDir
+		} else {
+			status = nfsStatus(s.truncate(p, int64(a.size)))
+		}
+	}
+	w.uint32(status)
+	s.wccData(w, call, p)
+	return nil
+}
+
+func (s *Server) lookup(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	dir, p, status := s.readDirOp(r)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	var fi fs.FileInfo
+	if status == nfsOK {
+		var err error
+		fi, err = s.stat(p)
+		status = nfsStatus(err)
+	}
+	w.uint32(status)
+	if status == nfsOK {
+		w.opaque(s.handleFor(p))
+		w.bool(true)
+		s.fattr3(w, call, p, fi)
+	}
+	s.postOpAttr(w, call, dir)
+	return nil
+}
+
+func (s *Server) access(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	want := r.uint32()
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	w.uint32(status)
+	if status != nfsOK {
+		w.bool(false)
+		return nil
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	// Grant everything but executing files. The FS enforces the actual
+	// permissions when the file is accessed.
+	if !fi.IsDir() {
+		want &^= accessExecute
+	}
+	w.uint32(want)
+	return nil
+}
+
+func (s *Server) read(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	off := r.uint64()
+	count := r.uint32()
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	if status == nfsOK && fi.IsDir() {
+		status = nfsErrIsDir
+	}
+	var buf []byte
+	var eof bool
+	if status == nfsOK {
+		buf = make([]byte, min(count, maxIOSize))
+		n, e, err := s.readAt(p, buf, int64(off))
+		buf, eof, status = buf[:n], e, nfsStatus(err)
+	}
+	w.uint32(status)
+	if status != nfsOK {
+		w.bool(false)
+		return nil
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	w.uint32(uint32(len(buf)))
+	w.bool(eof)
+	w.opaque(buf)
+	return nil
+}
+
+func (s *Server) write(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	off := r.uint64()
+	r.uint32() // count, which is the length of data
+	stable := r.uint32()
+	data := r.opaque(maxIOSize)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	if status == nfsOK && fi.IsDir() {
+		status = nfsErrIsDir
+	}
+	if status == nfsOK {
+		status = nfsStatus(s.writeAt(p, data, int64(off)))
+	}
+	committed := uint32(unstable)
+	if status == nfsOK && stable != unstable {
+		status = nfsStatus(s.flush(p))
+		committed = fileSync
+	}
+	w.uint32(status)
+	s.wccData(w, call, p)
+	if status == nfsOK {
+		w.uint32(uint32(len(data)))
+		w.uint32(committed)
+		w.fixed(s.verf[:])
+	}
+	return nil
+}
+
+func (s *Server) create(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	dir, p, status := s.readDirOp(r)
+	mode := r.uint32()
+	var a sattr3
+	if mode == createExclusive {
+		r.fixed(8) // verifier
+	} else {
+		a = readSattr3(r)
+	}
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	if status == nfsOK {
+		fi, err := s.stat(p)
+		switch {
+		case err == nil && (mode != createUnchecked || fi.IsDir()):
+			// Exclusive creates aren't supported beyond failing if the
+			// file exists, which is enough for most clients.
+			status = nfsErrExist
+		case err == nil && a.setSize:
+			status = nfsStatus(s.truncate(p, int64(a.size)))
+		case err == nil:
+			// Unchecked creates of existing files leave them be.
+		case errors.Is(err, fs.ErrNotExist):
+			s.discard(p)
+			status = nfsStatus(s.FS.WriteFile(s.ctx, p, bytes.NewReader(nil), 0))
+		default:
+			status = nfsStatus(err)
+		}
+	}
+	s.createResult(call, w, status, dir, p)
+	return nil
+}
+
+func (s *Server) mkdir(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	dir, p, status := s.readDirOp(r)
+	readSattr3(r)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	if status == nfsOK {
+		status = nfsStatus(s.FS.Mkdir(s.ctx, p))
+	}
+	s.createResult(call, w, status, dir, p)
+	return nil
+}
+
+// createResult encodes the results of CREATE and MKDIR.
+func (s *Server) createResult(call *rpcCall, w *xdrWriter, status uint32, dir, p string) {
+	w.uint32(status)
+	if status == nfsOK {
+		w.bool(true)
+		w.opaque(s.handleFor(p))
+		s.postOpAttr(w, call, p)
+	}
+	s.wccData(w, call, dir)
+}
+
+func (s *Server) remove(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	dir, p, status := s.readDirOp(r)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	if status == nfsOK {
+		status = s.checkRemove(p, call.proc == nfsProcRmdir)
+	}
+	if status == nfsOK {
+		status = nfsStatus(s.FS.Remove(s.ctx, p))
+	}
+	if status == nfsOK {
+		s.discard(p)
+		s.forget(p)
+	}
+	w.uint32(status)
+	s.wccData(w, call, dir)
+	return nil
+}
+
+// checkRemove returns the NFS error status for removing the file at path p
+// with REMOVE, or with RMDIR if isRmdir, if it can't be.
+func (s *Server) checkRemove(p string, isRmdir bool) uint32 {
+	fi, err := s.stat(p)
+	switch {
+	case err != nil:
+		return nfsStatus(err)
+	case isRmdir && !fi.IsDir():
+		return nfsErrNotDir
+	case !isRmdir && fi.IsDir():
+		return nfsErrIsDir
+	case isRmdir:
+		// The FS might remove directories recursively.
+		fis, err := s.FS.ReadDir(s.ctx, p)
+		if err != nil {
+			return nfsStatus(err)
+		}
+		if len(fis) > 0 {
+			return nfsErrNotEmpty
+		}
+	}
+	return nfsOK
+}
+
+// forget forgets the file handles for the file at path p and any files
+// beneath it, so that using them results in stale file handle errors.
+func (s *Server) forget(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handles.forget(p)
+}
+
+func (s *Server) rename(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fromDir, from, status := s.readDirOp(r)
+	toDir, to, toStatus := s.readDirOp(r)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	if status == nfsOK {
+		status = toStatus
+	}
+	if status == nfsOK && strings.HasPrefix(to, from+"/") {
+		status = nfsErrInval
+	}
+	if status == nfsOK && from != to {
+		// Write everything beneath from before it moves.
+		s.mu.Lock()
+		var flush []string
+		for p := range s.pending {
+			if p == from || strings.HasPrefix(p, from+"/") {
+				flush = append(flush, p)
+			}
+		}
+		s.mu.Unlock()
+		for _, p := range flush {
+			if err := s.flush(p); err != nil {
+				status = nfsStatus(err)
+				break
+			}
+		}
+	}
+	if status == nfsOK && from != to {
+		status = nfsStatus(s.FS.Rename(s.ctx, from, to))
+		if status == nfsOK {
+			s.discard(to)
+			s.renamed(from, to)
+		}
+	}
+	w.uint32(status)
+	s.wccData(w, call, fromDir)
+	s.wccData(w, call, toDir)
+	return nil
+}
+
+func (s *Server) readdir(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	plus := call.proc == nfsProcReaddirplus
+	fh := r.opaque(maxHandleSize)
+	cookie := r.uint64()
+	r.fixed(8)             // cookieverf
+	maxCount := r.uint32() // count, or dircount for READDIRPLUS
+	if plus {
+		maxCount = r.uint32()
+	}
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	if status == nfsOK && !fi.IsDir() {
+		status = nfsErrNotDir
+	}
+	var fis []fs.FileInfo
+	if status == nfsOK {
+		var err error
+		fis, err = s.FS.ReadDir(s.ctx, p)
+		status = nfsStatus(err)
+	}
+	if status == nfsOK && cookie > uint64(len(fis)) {
+		status = nfsErrBadCookie
+	}
+	if status != nfsOK {
+		w.uint32(status)
+		s.postOpAttr(w, call, p)
+		return nil
+	}
+
+	// Cookies are indexes into the sorted entries, which is good enough as
+	// long as the directory doesn't change between calls.
+	slices.SortFunc(fis, func(a, b fs.FileInfo) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	var entries xdrWriter
+	n := 0
+	for i := int(cookie); i < len(fis); i++ {
+		cp := path.Join(p, fis[i].Name())
+		var e xdrWriter
+		e.bool(true)
+		e.uint64(s.fileID(cp))
+		e.string(fis[i].Name())
+		e.uint64(uint64(i + 1))
+		if plus {
+			e.bool(true)
+			s.fattr3(&e, call, cp, fis[i])
+			e.bool(true)
+			e.opaque(s.handleFor(cp))
+		}
+		// Leave room for the status, attributes, verifier and eof.
+		if len(entries.b)+len(e.b)+200 > int(maxCount) {
+			break
+		}
+		entries.b = append(entries.b, e.b...)
+		n++
+	}
+	if n == 0 && int(cookie) < len(fis) {
+		w.uint32(nfsErrTooSmall)
+		s.postOpAttr(w, call, p)
+		return nil
+	}
+	w.uint32(nfsOK)
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	w.fixed(make([]byte, 8)) // cookieverf
+	w.b = append(w.b, entries.b...)
+	w.bool(false)
+	w.bool(int(cookie)+n == len(fis))
+	return nil
+}
+
+func (s *Server) fsstat(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	w.uint32(status)
+	if status != nfsOK {
+		w.bool(false)
+		return nil
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	// The space available on remotes is unknown, so make something up
+	// that's large enough not to get in the way.
+	const lots = 1 << 50
+	w.uint64(lots) // tbytes
+	w.uint64(lots) // fbytes
+	w.uint64(lots) // abytes
+	w.uint64(lots) // tfiles
+	w.uint64(lots) // ffiles
+	w.uint64(lots) // afiles
+	w.uint32(0)    // invarsec
+	return nil
+}
+
+func (s *Server) fsinfo(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	w.uint32(status)
+	if status != nfsOK {
+		w.bool(false)
+		return nil
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	w.uint32(maxIOSize) // rtmax
+	w.uint32(maxIOSize) // rtpref
+	w.uint32(4096)      // rtmult
+	w.uint32(maxIOSize) // wtmax
+	w.uint32(maxIOSize) // wtpref
+	w.uint32(4096)      // wtmult
+	w.uint32(64 << 10)  // dtpref
+	w.uint64(1<<63 - 1) // maxfilesize
+	w.uint32(0)         // time_delta
+	w.uint32(1)
+	w.uint32(0x18) // FSF3_HOMOGENEOUS | FSF3_CANSETTIME
+	return nil
+}
+
+func (s *Server) pathconf(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, fi, status := s.statHandle(fh)
+	w.uint32(status)
+	if status != nfsOK {
+		w.bool(false)
+		return nil
+	}
+	w.bool(true)
+	s.fattr3(w, call, p, fi)
+	w.uint32(1)          // linkmax
+	w.uint32(maxNameLen) // name_max
+	w.bool(true)         // no_trunc
+	w.bool(true)         // chown_restricted
+	w.bool(false)        // case_insensitive
+	w.bool(true)         // case_preserving
+	return nil
+}
+
+func (s *Server) commit(call *rpcCall, r *xdrReader, w *xdrWriter) error {
+	fh := r.opaque(maxHandleSize)
+	r.uint64() // offset
+	r.uint32() // count
+	if r.err != nil {
+		return errGarbageArgs
+	}
+	p, status := s.pathFor(fh)
+	if status == nfsOK {
+		// The FS can only write whole files, so commit all of it.
+		status = nfsStatus(s.flush(p))
+	}
+	w.uint32(status)
+	s.wccData(w, call, p)
+	if status == nfsOK {
+		w.fixed(s.verf[:])
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// dirFS is an FS backed by a local directory.
+type dirFS string
+
+func (d dirFS) path(name string) string { return filepath.Join(string(d), filepath.FromSlash(name)) }
+
+func (d dirFS) Stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d dirFS) ReadDir(ctx context.Context, name string) ([]fs.FileInfo, error) {
+	des, err := os.ReadDir(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var fis []fs.FileInfo
+	for _, de := range des {
+		fi, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		fis = append(fis, fi)
+	}
+	return fis, nil
+}
+
+func (d dirFS) ReadAt(ctx context.Context, name string, p []byte, off int64) (int, error) {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.ReadAt(p, off)
+}
+
+func (d dirFS) WriteFile(ctx context.Context, name string, r io.Reader, size int64) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.path(name), b, 0644)
+}
+
+func (d dirFS) Mkdir(ctx context.Context, name string) error {
+	return os.Mkdir(d.path(name), 0755)
+}
+
+func (d dirFS) Remove(ctx context.Context, name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d dirFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.Rename(d.path(oldName), d.path(newName))
+}
+
+// testClient is a minimal RPC client.
+type testClient struct {
+	t   *testing.T
+	c   net.Conn
+	br  *bufio.Reader
+	xid uint32
+}
+
+func newTestClient(t *testing.T, s *Server) *testClient {
+	c1, c2 := net.Pipe()
+	go s.HandleConn(c2)
+	t.Cleanup(func() { c1.Close() })
+	return &testClient{t: t, c: c1, br: bufio.NewReader(c1)}
+}
+
+// call calls the procedure proc of prog with args and returns a reader for
+// the results.
+func (tc *testClient) call(prog, proc uint32, args func(w *xdrWriter)) *xdrReader {
+	tc.t.Helper()
+	tc.xid++
+	w := &xdrWriter{}
+	w.uint32(tc.xid)
+	w.uint32(msgCall)
+	w.uint32(rpcVersion)
+	w.uint32(prog)
+	w.uint32(3)
+	w.uint32(proc)
+	cred := &xdrWriter{}
+	cred.uint32(0)
+	cred.string("test")
+	cred.uint32(1000)
+	cred.uint32(1000)
+	cred.uint32(0)
+	w.uint32(authSys)
+	w.opaque(cred.b)
+	w.uint32(authNone)
+	w.uint32(0)
+	if args != nil {
+		args(w)
+	}
+	if err := writeRecord(tc.c, w.b); err != nil {
+		tc.t.Fatal(err)
+	}
+	rec, err := readRecord(tc.br)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	r := &xdrReader{b: rec}
+	if xid := r.uint32(); xid != tc.xid {
+		tc.t.Fatalf("xid = %d; want %d", xid, tc.xid)
+	}
+	r.uint32() // msgReply
+	if stat := r.uint32(); stat != replyAccepted {
+		tc.t.Fatalf("reply stat = %d", stat)
+	}
+	r.uint32() // verifier
+	r.opaque(400)
+	if stat := r.uint32(); stat != acceptSuccess {
+		tc.t.Fatalf("accept stat = %d", stat)
+	}
+	return r
+}
+
+// skipFattr3 skips over a fattr3, returning the type, size and file ID.
+func skipFattr3(r *xdrReader) (typ uint32, size, fileID uint64) {
+	typ = r.uint32()
+	r.fixed(16) // mode, nlink, uid, gid
+	size = r.uint64()
+	r.fixed(24) // used, rdev, fsid
+	fileID = r.uint64()
+	r.fixed(24) // times
+	return typ, size, fileID
+}
+
+func skipPostOpAttr(r *xdrReader) {
+	if r.bool() {
+		skipFattr3(r)
+	}
+}
+
+func skipWcc(r *xdrReader) {
+	if r.bool() {
+		r.fixed(24)
+	}
+	skipPostOpAttr(r)
+}
+
+func (tc *testClient) mount(p string) []byte {
+	tc.t.Helper()
+	r := tc.call(progMount, mountProcMnt, func(w *xdrWriter) { w.string(p) })
+	if status := r.uint32(); status != mntOK {
+		tc.t.Fatalf("MNT %s = %d", p, status)
+	}
+	return r.opaque(maxHandleSize)
+}
+
+func (tc *testClient) lookup(dir []byte, name string) ([]byte, uint32) {
+	tc.t.Helper()
+	r := tc.call(progNFS, nfsProcLookup, func(w *xdrWriter) {
+		w.opaque(dir)
+		w.string(name)
+	})
+	status := r.uint32()
+	if status != nfsOK {
+		return nil, status
+	}
+	return r.opaque(maxHandleSize), status
+}
+
+func (tc *testClient) read(fh []byte, off uint64, count uint32) (string, bool) {
+	tc.t.Helper()
+	r := tc.call(progNFS, nfsProcRead, func(w *xdrWriter) {
+		w.opaque(fh)
+		w.uint64(off)
+		w.uint32(count)
+	})
+	if status := r.uint32(); status != nfsOK {
+		tc.t.Fatalf("READ = %d", status)
+	}
+	skipPostOpAttr(r)
+	r.uint32()
+	eof := r.bool()
+	return string(r.opaque(maxIOSize)), eof
+}
+
+func (tc *testClient) write(fh []byte, off uint64, data string) {
+	tc.t.Helper()
+	r := tc.call(progNFS, nfsProcWrite, func(w *xdrWriter) {
+		w.opaque(fh)
+		w.uint64(off)
+		w.uint32(uint32(len(data)))
+		w.uint32(unstable)
+		w.opaque([]byte(data))
+	})
+	if status := r.uint32(); status != nfsOK {
+		tc.t.Fatalf("WRITE = %d", status)
+	}
+}
+
+func (tc *testClient) commit(fh []byte) {
+	tc.t.Helper()
+	r := tc.call(progNFS, nfsProcCommit, func(w *xdrWriter) {
+		w.opaque(fh)
+		w.uint64(0)
+		w.uint32(0)
+	})
+	if status := r.uint32(); status != nfsOK {
+		tc.t.Fatalf("COMMIT = %d", status)
+	}
+}
+
+// create creates the file or, if isDir, the directory name in dir.
+func (tc *testClient) create(dir []byte, name string, isDir bool) []byte {
+	tc.t.Helper()
+	proc := uint32(nfsProcCreate)
+	if isDir {
+		proc = nfsProcMkdir
+	}
+	r := tc.call(progNFS, proc, func(w *xdrWriter) {
+		w.opaque(dir)
+		w.string(name)
+		if !isDir {
+			w.uint32(createUnchecked)
+		}
+		w.fixed(make([]byte, 24)) // empty sattr3
+	})
+	if status := r.uint32(); status != nfsOK {
+		tc.t.Fatalf("creating %s = %d", name, status)
+	}
+	r.bool()
+	return r.opaque(maxHandleSize)
+}
+
+func (tc *testClient) readdirplus(dir []byte) []string {
+	tc.t.Helper()
+	r := tc.call(progNFS, nfsProcReaddirplus, func(w *xdrWriter) {
+		w.opaque(dir)
+		w.uint64(0)
+		w.fixed(make([]byte, 8))
+		w.uint32(4096)
+		w.uint32(64 << 10)
+	})
+	if status := r.uint32(); status != nfsOK {
+		tc.t.Fatalf("READDIRPLUS = %d", status)
+	}
+	skipPostOpAttr(r)
+	r.fixed(8)
+	var names []string
+	for r.bool() {
+		r.uint64()
+		names = append(names, r.string(maxNameLen))
+		r.uint64()
+		skipPostOpAttr(r)
+		if r.bool() {
+			r.opaque(maxHandleSize)
+		}
+	}
+	if !r.bool() {
+		tc.t.Error("READDIRPLUS didn't reach eof")
+	}
+	if r.err != nil {
+		tc.t.Fatal(r.err)
+	}
+	return names
+}
+
+func (tc *testClient) dirOp(proc uint32, dir []byte, name string) uint32 {
+	tc.t.Helper()
+	r := tc.call(progNFS, proc, func(w *xdrWriter) {
+		w.opaque(dir)
+		w.string(name)
+	})
+	return r.uint32()
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{FS: dirFS(dir), TempDir: t.TempDir()}
+	defer s.Close()
+	tc := newTestClient(t, s)
+
+	root := tc.mount("/")
+	if r := tc.call(progMount, mountProcMnt, func(w *xdrWriter) { w.string("/a.txt") }); r.uint32() != mntErrNotDir {
+		t.Error("mounting a file succeeded")
+	}
+
+	a, status := tc.lookup(root, "a.txt")
+	if status != nfsOK {
+		t.Fatalf("LOOKUP a.txt = %d", status)
+	}
+	if _, status := tc.lookup(root, "missing"); status != nfsErrNoEnt {
+		t.Errorf("LOOKUP missing = %d; want %d", status, nfsErrNoEnt)
+	}
+	if _, status := tc.lookup(root, ".."); status != nfsErrInval {
+		t.Errorf("LOOKUP .. = %d; want %d", status, nfsErrInval)
+	}
+
+	r := tc.call(progNFS, nfsProcGetattr, func(w *xdrWriter) { w.opaque(a) })
+	if status := r.uint32(); status != nfsOK {
+		t.Fatalf("GETATTR = %d", status)
+	}
+	if typ, size, _ := skipFattr3(r); typ != 1 || size != 11 {
+		t.Errorf("GETATTR = type %d, size %d; want 1, 11", typ, size)
+	}
+
+	if got, eof := tc.read(a, 6, 100); got != "world" || !eof {
+		t.Errorf("READ = %q, %v; want %q, true", got, eof, "world")
+	}
+
+	// Writes are buffered until they're committed, but visible right away.
+	tc.write(a, 6, "there")
+	tc.write(a, 11, "!")
+	if got, _ := tc.read(a, 0, 100); got != "hello there!" {
+		t.Errorf("READ after WRITE = %q", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "hello world" {
+		t.Errorf("file contents before COMMIT = %q", b)
+	}
+	tc.commit(a)
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "hello there!" {
+		t.Errorf("file contents after COMMIT = %q", b)
+	}
+
+	sub := tc.create(root, "sub", true)
+	b := tc.create(sub, "b.txt", false)
+	tc.write(b, 0, "new file")
+	tc.commit(b)
+	if got := tc.readdirplus(root); !slices.Equal(got, []string{"a.txt", "sub"}) {
+		t.Errorf("READDIRPLUS / = %q", got)
+	}
+
+	// Handles follow renamed files.
+	if status := tc.call(progNFS, nfsProcRename, func(w *xdrWriter) {
+		w.opaque(root)
+		w.string("sub")
+		w.opaque(root)
+		w.string("moved")
+	}).uint32(); status != nfsOK {
+		t.Fatalf("RENAME = %d", status)
+	}
+	if got, _ := tc.read(b, 0, 100); got != "new file" {
+		t.Errorf("READ after RENAME = %q", got)
+	}
+
+	if status := tc.dirOp(nfsProcRmdir, root, "moved"); status != nfsErrNotEmpty {
+		t.Errorf("RMDIR of non-empty directory = %d; want %d", status, nfsErrNotEmpty)
+	}
+	if status := tc.dirOp(nfsProcRemove, sub, "b.txt"); status != nfsOK {
+		t.Errorf("REMOVE = %d", status)
+	}
+	r = tc.call(progNFS, nfsProcGetattr, func(w *xdrWriter) { w.opaque(b) })
+	if status := r.uint32(); status != nfsErrStale {
+		t.Errorf("GETATTR of removed file = %d; want %d", status, nfsErrStale)
+	}
+	if status := tc.dirOp(nfsProcRmdir, root, "moved"); status != nfsOK {
+		t.Errorf("RMDIR = %d", status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "moved")); !os.IsNotExist(err) {
+		t.Errorf("directory still exists after RMDIR: %v", err)
+	}
+}
+
+func TestServerFlushOnClose(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{FS: dirFS(dir), TempDir: t.TempDir()}
+	tc := newTestClient(t, s)
+	root := tc.mount("/")
+	f := tc.create(root, "f.txt", false)
+	tc.write(f, 0, "unstable")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "f.txt")); !bytes.Equal(b, []byte("unstable")) {
+		t.Errorf("file contents after Close = %q", b)
+	}
+}
+
+func TestHandleTable(t *testing.T) {
+	ht := newHandleTable(2)
+	a, b := ht.id("/a"), ht.id("/a/b")
+	if ht.id("/a") != a || ht.id("/") != rootID {
+		t.Fatal("IDs aren't stable")
+	}
+	c := ht.id("/c") // evicts /a/b, the least recently used
+	if _, ok := ht.path(b); ok {
+		t.Error("least recently used ID wasn't forgotten")
+	}
+	if p, _ := ht.path(a); p != "/a" {
+		t.Errorf("path(a) = %q; want /a", p)
+	}
+	if p, ok := ht.path(rootID); !ok || p != "/" {
+		t.Errorf("path(rootID) = %q, %v; want /", p, ok)
+	}
+
+	ht.renamed("/a", "/c")
+	if p, _ := ht.path(a); p != "/c" {
+		t.Errorf("path(a) after rename = %q; want /c", p)
+	}
+	if _, ok := ht.path(c); ok {
+		t.Error("ID of the rename target wasn't forgotten")
+	}
+	if ht.id("/c") != a {
+		t.Error("renamed path got a new ID")
+	}
+	ht.forget("/c")
+	if _, ok := ht.path(a); ok {
+		t.Error("forgotten ID is still remembered")
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flushDelay is how long after the last write to a file its pending contents
+// are written to the FS if the client doesn't commit them first.
+const flushDelay = 2 * time.Second
+
+// pendingFile holds the contents of a file that's being written. NFS clients
+// write files in pieces, while the FS can only replace whole files, so the
+// pieces are collected in a temporary file until they're committed.
+type pendingFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	modTime time.Time
+	timer   *time.Timer
+	closed  bool // whether f was flushed or discarded
+}
+
+// pendingFileInfo is the fs.FileInfo of a file with pending writes.
+type pendingFileInfo struct {
+	fs.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (fi pendingFileInfo) Size() int64        { return fi.size }
+func (fi pendingFileInfo) ModTime() time.Time { return fi.modTime }
+
+// fileInfo returns fi updated with the size and modification time of pf.
+func (pf *pendingFile) fileInfo(fi fs.FileInfo) fs.FileInfo {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pendingFileInfo{fi, pf.size, pf.modTime}
+}
+
+// pendingFile returns the pending writes to the file at path p, or nil if
+// there are none.
+func (s *Server) pendingFile(p string) *pendingFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending[p]
+}
+
+// openPending returns the pending writes to the file at path p, starting
+// them if there are none. If load is true, new pending writes start out with
+// the current contents of the file, otherwise with an empty file.
+//
+// Callers must check that the returned pendingFile isn't closed once they
+// hold its lock, and call openPending again if it is.
+func (s *Server) openPending(p string, load bool) (*pendingFile, error) {
+	s.mu.Lock()
+	if pf := s.pending[p]; pf != nil {
+		s.mu.Unlock()
+		return pf, nil
+	}
+	pf := &pendingFile{modTime: time.Now()}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	s.pending[p] = pf
+	s.mu.Unlock()
+
+	fail := func(err error) (*pendingFile, error) {
+		pf.closed = true
+		if pf.f != nil {
+			pf.f.Close()
+			os.Remove(pf.f.Name())
+		}
+		s.mu.Lock()
+		if s.pending[p] == pf {
+			delete(s.pending, p)
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(s.TempDir, "nfs-*")
+	if err != nil {
+		return fail(err)
+	}
+	pf.f = f
+	if load {
+		buf := make([]byte, maxIOSize)
+		for {
+			n, err := s.FS.ReadAt(s.ctx, p, buf, pf.size)
+			if n > 0 {
+				if _, err := f.WriteAt(buf[:n], pf.size); err != nil {
+					return fail(err)
+				}
+				pf.size += int64(n)
+			}
+			if err == io.EOF || (err == nil && n < len(buf)) {
+				break
+			}
+			if err != nil {
+				return fail(err)
+			}
+		}
+	}
+	pf.timer = time.AfterFunc(flushDelay, func() {
+		if err := s.flush(p); err != nil {
+			s.logf("nfs: writing %q: %v", p, err)
+		}
+	})
+	return pf, nil
+}
+
+// writeAt writes b to the file at path p at offset off. The write is pending
+// until it's flushed.
+func (s *Server) writeAt(p string, b []byte, off int64) error {
+	for {
+		pf, err := s.openPending(p, true)
+		if err != nil {
+			return err
+		}
+		pf.mu.Lock()
+		if pf.closed {
+			pf.mu.Unlock()
+			continue
+		}
+		_, err = pf.f.WriteAt(b, off)
+		if err == nil {
+			pf.size = max(pf.size, off+int64(len(b)))
+			pf.modTime = time.Now()
+			pf.timer.Reset(flushDelay)
+		}
+		pf.mu.Unlock()
+		return err
+	}
+}
+
+// truncate changes the size of the file at path p. The change is pending
+// until it's flushed.
+func (s *Server) truncate(p string, size int64) error {
+	for {
+		pf, err := s.openPending(p, size != 0)
+		if err != nil {
+			return err
+		}
+		pf.mu.Lock()
+		if pf.closed {
+			pf.mu.Unlock()
+			continue
+		}
+		err = pf.f.Truncate(size)
+		if err == nil {
+			pf.size = size
+			pf.modTime = time.Now()
+			pf.timer.Reset(flushDelay)
+		}
+		pf.mu.Unlock()
+		return err
+	}
+}
+
+// readAt reads from the file at path p at offset off, including any pending
+// writes. It reports whether the read reached the end of the file.
+func (s *Server) readAt(p string, b []byte, off int64) (n int, eof bool, err error) {
+	if pf := s.pendingFile(p); pf != nil {
+		pf.mu.Lock()
+		if !pf.closed {
+			defer pf.mu.Unlock()
+			n, err = io.NewSectionReader(pf.f, 0, pf.size).ReadAt(b, off)
+			if err == io.EOF {
+				err = nil
+			}
+			return n, off+int64(n) >= pf.size, err
+		}
+		pf.mu.Unlock()
+	}
+	n, err = s.FS.ReadAt(s.ctx, p, b, off)
+	if err == io.EOF {
+		return n, true, nil
+	}
+	return n, err == nil && n < len(b), err
+}
+
+// flush writes the pending writes to the file at path p, if any, to the FS.
+func (s *Server) flush(p string) error {
+	pf := s.pendingFile(p)
+	if pf == nil {
+		return nil
+	}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	if pf.closed {
+		return nil
+	}
+	pf.timer.Stop()
+	if err := s.FS.WriteFile(s.ctx, p, io.NewSectionReader(pf.f, 0, pf.size), pf.size); err != nil {
+		if s.ctx.Err() == nil {
+			// Try again later, in case the client doesn't.
+			pf.timer.Reset(flushDelay)
+		}
+		return err
+	}
+	pf.closed = true
+	pf.f.Close()
+	os.Remove(pf.f.Name())
+	s.mu.Lock()
+	if s.pending[p] == pf {
+		delete(s.pending, p)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// discard discards the pending writes to the file at path p and to any files
+// beneath it, because they were removed or replaced.
+func (s *Server) discard(p string) {
+	s.mu.Lock()
+	var discarded []*pendingFile
+	for pp, pf := range s.pending {
+		if pp == p || strings.HasPrefix(pp, p+"/") {
+			delete(s.pending, pp)
+			discarded = append(discarded, pf)
+		}
+	}
+	s.mu.Unlock()
+	for _, pf := range discarded {
+		pf.mu.Lock()
+		if !pf.closed {
+			pf.closed = true
+			pf.timer.Stop()
+			pf.f.Close()
+			os.Remove(pf.f.Name())
+		}
+		pf.mu.Unlock()
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ONC RPC (RFC 5531) constants.
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess      = 0
+	acceptProgUnavail  = 1
+	acceptProgMismatch = 2
+	acceptProcUnavail  = 3
+	acceptGarbageArgs  = 4
+	acceptSystemErr    = 5
+
+	rejectRPCMismatch = 0
+
+	authNone = 0
+	authSys  = 1
+
+	// maxRecordSize is the maximum size of an RPC record. It's a bit more
+	// than the maximum READ and WRITE size to leave room for the headers.
+	maxRecordSize = maxIOSize + 4096
+)
+
+// rpcCall is a decoded RPC call.
+type rpcCall struct {
+	xid  uint32
+	prog uint32
+	vers uint32
+	proc uint32
+
+	// uid and gid are the caller's credentials if it used AUTH_SYS.
+	uid, gid uint32
+
+	args *xdrReader
+}
+
+var (
+	// errProgUnavail is returned for calls to unknown programs.
+	errProgUnavail = errors.New("program unavailable")
+
+	// errProcUnavail is returned by program handlers for unknown procedures.
+	errProcUnavail = errors.New("procedure unavailable")
+)
+
+// progMismatchError is returned by program handlers for unsupported
+// versions.
+type progMismatchError struct {
+	low, high uint32
+}
+
+func (e progMismatchError) Error() string {
+	return fmt.Sprintf("program version mismatch (supported: %d-%d)", e.low, e.high)
+}
+
+// readRecord reads an RPC record, which may consist of multiple fragments,
+// using record marking (RFC 5531, section 11).
+func readRecord(br *bufio.Reader) ([]byte, error) {
+	var rec []byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return nil, err
+		}
+		v := binary.BigEndian.Uint32(hdr[:])
+		last := v&(1<<31) != 0
+		n := int(v &^ (1 << 31))
+		if len(rec)+n > maxRecordSize {
+			return nil, fmt.Errorf("RPC record too large (%d bytes)", len(rec)+n)
+		}
+		start := len(rec)
+		rec = append(rec, make([]byte, n)...)
+		if _, err := io.ReadFull(br, rec[start:]); err != nil {
+			return nil, err
+		}
+		if last {
+			return rec, nil
+		}
+	}
+}
+
+// writeRecord writes b as a single-fragment RPC record.
+func writeRecord(w io.Writer, b []byte) error {
+	rec := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b))|1<<31)
+	_, err := w.Write(append(rec, b...))
+	return err
+}
+
+// parseCall parses an RPC call message. If the message is a call that can't
+// be served, it returns a non-nil reply to send instead.
+func parseCall(rec []byte) (call *rpcCall, reply []byte, err error) {
+	r := &xdrReader{b: rec}
+	call = &rpcCall{xid: r.uint32()}
+	if typ := r.uint32(); r.err == nil && typ != msgCall {
+		return nil, nil, fmt.Errorf("unexpected RPC message type %d", typ)
+	}
+	vers := r.uint32()
+	call.prog = r.uint32()
+	call.vers = r.uint32()
+	call.proc = r.uint32()
+	credFlavor := r.uint32()
+	cred := r.opaque(400)
+	r.uint32() // verifier flavor
+	r.opaque(400)
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if vers != rpcVersion {
+		w := &xdrWriter{}
+		w.uint32(call.xid)
+		w.uint32(msgReply)
+		w.uint32(replyDenied)
+		w.uint32(rejectRPCMismatch)
+		w.uint32(rpcVersion)
+		w.uint32(rpcVersion)
+		return nil, w.b, nil
+	}
+	if credFlavor == authSys {
+		cr := &xdrReader{b: cred}
+		cr.uint32()    // stamp
+		cr.string(255) // machine name
+		uid, gid := cr.uint32(), cr.uint32()
+		if cr.err == nil {
+			call.uid, call.gid = uid, gid
+		}
+	}
+	call.args = r
+	return call, nil, nil
+}
+
+// acceptedReply returns the start of a reply to call with the given accept
+// status, to which results may be appended.
+func acceptedReply(call *rpcCall, stat uint32) *xdrWriter {
+	w := &xdrWriter{}
+	w.uint32(call.xid)
+	w.uint32(msgReply)
+	w.uint32(replyAccepted)
+	w.uint32(authNone) // verifier
+	w.uint32(0)
+	w.uint32(stat)
+	return w
+}
+
+// serveConn serves RPC calls on c until it's closed or fails, dispatching
+// them to handle. Calls are handled one at a time, which is what clients
+// expect of a single connection anyway.
+func serveConn(c net.Conn, handle func(*rpcCall, *xdrWriter) error) error {
+	defer c.Close()
+	br := bufio.NewReaderSize(c, 64<<10)
+	for {
+		rec, err := readRecord(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		call, reply, err := parseCall(rec)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			w := acceptedReply(call, acceptSuccess)
+			err := handle(call, w)
+			var pm progMismatchError
+			switch {
+			case err == nil && call.args.err != nil, errors.Is(err, errGarbageArgs):
+				reply = acceptedReply(call, acceptGarbageArgs).b
+			case errors.Is(err, errProgUnavail):
+				reply = acceptedReply(call, acceptProgUnavail).b
+			case errors.Is(err, errProcUnavail):
+				reply = acceptedReply(call, acceptProcUnavail).b
+			case errors.As(err, &pm):
+				w := acceptedReply(call, acceptProgMismatch)
+				w.uint32(pm.low)
+				w.uint32(pm.high)
+				reply = w.b
+			case err != nil:
+				reply = acceptedReply(call, acceptSystemErr).b
+			default:
+				reply = w.b
+			}
+		}
+		if err := writeRecord(c, reply); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errGarbageArgs is returned when decoding malformed XDR.
+var errGarbageArgs = errors.New("garbage args")
+
+// xdrReader decodes XDR (RFC 4506) from a buffer. Decoding errors are sticky
+// and reported by err, so that callers can decode a whole structure before
+// checking for errors.
+type xdrReader struct {
+	b   []byte
+	err error
+}
+
+func (r *xdrReader) next(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		r.err = errGarbageArgs
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *xdrReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *xdrReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *xdrReader) bool() bool {
+	return r.uint32() != 0
+}
+
+// opaque decodes variable-length opaque data of at most max bytes.
+func (r *xdrReader) opaque(max int) []byte {
+	n := r.uint32()
+	if r.err != nil || n > uint32(max) {
+		r.err = errGarbageArgs
+		return nil
+	}
+	b := r.next(int(n))
+	r.next(pad(int(n)))
+	return b
+}
+
+// fixed decodes fixed-length opaque data of n bytes.
+func (r *xdrReader) fixed(n int) []byte {
+	b := r.next(n)
+	r.next(pad(n))
+	return b
+}
+
+func (r *xdrReader) string(max int) string {
+	return string(r.opaque(max))
+}
+
+// pad returns the number of padding bytes after n bytes of opaque data.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// xdrWriter encodes XDR.
+type xdrWriter struct {
+	b []byte
+}
+
+func (w *xdrWriter) uint32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+func (w *xdrWriter) uint64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+func (w *xdrWriter) bool(v bool) {
+	if v {
+		w.uint32(1)
+	} else {
+		w.uint32(0)
+	}
+}
+
+func (w *xdrWriter) opaque(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.fixed(b)
+}
+
+func (w *xdrWriter) fixed(b []byte) {
+	w.b = append(w.b, b...)
+	for range pad(len(b)) {
+		w.b = append(w.b, 0)
+	}
+}
+
+func (w *xdrWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.b = append(w.b, s...)
+	for range pad(len(s)) {
+		w.b = append(w.b, 0)
+	}
+}
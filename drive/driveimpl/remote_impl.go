@@ -5,9 +5,13 @@
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/hex"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"net"
@@ -17,6 +21,7 @@
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"slices"
 	"strings"
 	"sync"
@@ -200,21 +205,6 @@ func (s *FileSystemForRemote) buildChild(share *drive.Share) *compositedav.Child
 
 // ServeHTTPWithPerms implements drive.FileSystemForRemote.
 func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions, w http.ResponseWriter, r *http.Request) {
-	isWrite := writeMethods[r.Method]
-	if isWrite {
-		share := shared.CleanAndSplit(r.URL.Path)[0]
-		switch permissions.For(share) {
-		case drive.PermissionNone:
-			// If we have no permissions to this share, treat it as not found
-			// to avoid leaking any information about the share's existence.
-			http.Error(w, "not found", http.StatusNotFound)
-			return
-		case drive.PermissionReadOnly:
-			http.Error(w, "permission denied", http.StatusForbidden)
-			return
-		}
-	}
-
 	s.mu.RLock()
 	childrenMap := s.children
 	s.mu.RUnlock()
@@ -222,20 +212,234 @@ func (s *FileSystemForRemote) ServeHTTPWithPerms(permissions drive.Permissions,
 	children := make([]*compositedav.Child, 0, len(childrenMap))
 	// filter out shares to which the connecting principal has no access
 	for name, child := range childrenMap {
-		if permissions.For(name) == drive.PermissionNone {
+		if !permissions.Visible(name, "") {
 			continue
 		}
 
 		children = append(children, child)
 	}
 
-	h := compositedav.Handler{
+	h := &compositedav.Handler{
 		Logf: s.logf,
 	}
 	h.SetChildren("", children...)
+
+	pathComponents := shared.CleanAndSplit(r.URL.Path)
+	share, pth := pathComponents[0], path.Join(pathComponents[1:]...)
+	if share == "" {
+		// The root is a read-only listing of the shares.
+		if writeMethods[r.Method] {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		// Directories that merely lead to paths with some permission can be
+		// listed, with the entries the principal can't see filtered out.
+		if !permissions.Visible(share, pth) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if permissions.ForPath(share, pth) == drive.PermissionNone || !permissions.Uniform(share, pth) {
+			serveFilteredPROPFIND(permissions, h, w, r)
+			return
+		}
+	case "COPY", "MOVE":
+		want := drive.PermissionReadOnly
+		if r.Method == "MOVE" {
+			want = drive.PermissionReadWrite
+		}
+		if !checkPermission(w, permissions, share, pth, want) || !checkTree(w, permissions, h, r, share, pth) {
+			return
+		}
+		if dest := r.Header.Get("Destination"); dest != "" {
+			destURL, err := url.Parse(dest)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			destComponents := shared.CleanAndSplit(destURL.Path)
+			destShare, destPth := destComponents[0], path.Join(destComponents[1:]...)
+			// With Overwrite, an existing directory at the destination is
+			// deleted as a whole, so it's subject to the same checks.
+			if !checkPermission(w, permissions, destShare, destPth, drive.PermissionReadWrite) || !checkTree(w, permissions, h, r, destShare, destPth) {
+				return
+			}
+		}
+	case "DELETE":
+		if !checkPermission(w, permissions, share, pth, drive.PermissionReadWrite) || !checkTree(w, permissions, h, r, share, pth) {
+			return
+		}
+	default:
+		want := drive.PermissionReadOnly
+		if writeMethods[r.Method] {
+			want = drive.PermissionReadWrite
+		}
+		if !checkPermission(w, permissions, share, pth, want) {
+			return
+		}
+	}
+
 	h.ServeHTTP(w, r)
 }
 
+// checkPermission checks that permissions grant at least want to the path pth
+// within share. If not, it writes an error response and returns false.
+func checkPermission(w http.ResponseWriter, permissions drive.Permissions, share, pth string, want drive.Permission) bool {
+	if permissions.ForPath(share, pth) >= want {
+		return true
+	}
+	if !permissions.Visible(share, pth) {
+		// If we have no permissions to this path, treat it as not found
+		// to avoid leaking any information about its existence.
+		http.Error(w, "not found", http.StatusNotFound)
+		return false
+	}
+	http.Error(w, "permission denied", http.StatusForbidden)
+	return false
+}
+
+// checkTree checks that, if the path pth within share is a directory, the
+// permissions to everything beneath it are the same as to the directory
+// itself, so that operating on the whole tree doesn't touch anything the
+// principal can't see. If not, it writes an error response and returns false.
+func checkTree(w http.ResponseWriter, permissions drive.Permissions, h *compositedav.Handler, r *http.Request, share, pth string) bool {
+	if permissions.Uniform(share, pth) {
+		return true
+	}
+	sr := r.Clone(r.Context())
+	sr.URL.Path, sr.URL.RawPath = "/"+path.Join(share, pth), ""
+	sr.Method = "PROPFIND"
+	sr.Header = http.Header{"Depth": {"0"}}
+	sr.Body = http.NoBody
+	sr.ContentLength = 0
+	bw := &bufferingResponseWriter{header: make(http.Header)}
+	h.ServeHTTP(bw, sr)
+	if bw.status == http.StatusMultiStatus && !bytes.Contains(bw.buf.Bytes(), []byte("<D:collection")) {
+		return true // not a directory
+	}
+	if bw.status == http.StatusNotFound {
+		return true // let the request fail normally
+	}
+	http.Error(w, "permission denied", http.StatusForbidden)
+	return false
+}
+
+var (
+	davMultiStatus = xml.Name{Space: "DAV:", Local: "multistatus"}
+	davResponse    = xml.Name{Space: "DAV:", Local: "response"}
+)
+
+// serveFilteredPROPFIND serves the PROPFIND request r using h, removing the
+// entries that permissions don't make visible from the response. If the
+// response can't be parsed, none of it is sent.
+func serveFilteredPROPFIND(permissions drive.Permissions, h *compositedav.Handler, w http.ResponseWriter, r *http.Request) {
+	bw := &bufferingResponseWriter{header: w.Header()}
+	h.ServeHTTP(bw, r)
+	result := bw.buf.Bytes()
+	if bw.status == http.StatusMultiStatus {
+		var err error
+		result, err = filterMultiStatus(result, func(href string) bool {
+			// compositedav inserts the share name as is, while the child
+			// escapes the path within the share.
+			pathComponents := shared.CleanAndSplit(href)
+			pth, err := url.PathUnescape(path.Join(pathComponents[1:]...))
+			if err != nil {
+				return false
+			}
+			return permissions.Visible(pathComponents[0], pth)
+		})
+		if err != nil {
+			w.Header().Del("Content-Type")
+			http.Error(w, "invalid PROPFIND response", http.StatusBadGateway)
+			return
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(cmp.Or(bw.status, http.StatusOK))
+	w.Write(result)
+}
+
+// filterMultiStatus returns the WebDAV multistatus document b without the
+// response elements that have an href for which visible returns false, or
+// no href at all. The rest of the document is kept as is.
+func filterMultiStatus(b []byte, visible func(href string) bool) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var out bytes.Buffer
+	var copied int64 // offset in b up to which b was copied or dropped
+	var depth int
+	var sawRoot bool
+	for {
+		start := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if sawRoot || t.Name != davMultiStatus {
+					return nil, fmt.Errorf("unexpected root element %v", t.Name)
+				}
+				sawRoot = true
+			}
+			if depth == 1 && t.Name == davResponse {
+				var resp struct {
+					Hrefs []string `xml:"DAV: href"`
+				}
+				if err := d.DecodeElement(&resp, &t); err != nil {
+					return nil, err
+				}
+				keep := len(resp.Hrefs) > 0
+				for _, href := range resp.Hrefs {
+					keep = keep && visible(href)
+				}
+				if !keep {
+					out.Write(b[copied:start])
+					copied = d.InputOffset()
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	if !sawRoot || depth != 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out.Write(b[copied:])
+	return out.Bytes(), nil
+}
+
+// bufferingResponseWriter is an http.ResponseWriter that buffers the
+// response.
+type bufferingResponseWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (bw *bufferingResponseWriter) Header() http.Header { return bw.header }
+
+func (bw *bufferingResponseWriter) WriteHeader(statusCode int) {
+	bw.status = statusCode
+}
+
+func (bw *bufferingResponseWriter) Write(p []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.buf.Write(p)
+}
+
 func (s *FileSystemForRemote) stopUserServers(userServers map[string]*userServer) {
 	for _, server := range userServers {
 		if err := server.Close(); err != nil {
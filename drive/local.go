@@ -12,6 +12,8 @@
 import (
 	"net"
 	"net/http"
+
+	"tailscale.com/tstime"
 )
 
 // Remote represents a remote Taildrive node.
@@ -19,6 +21,52 @@ type Remote struct {
 	Name      string
 	URL       string
 	Available func() bool
+
+	// Cache configures caching of the remote's file metadata and contents.
+	Cache CacheConfig
+
+	// CacheDir is the directory in which to cache the contents of the
+	// remote's files. If empty, file contents aren't cached.
+	CacheDir string
+}
+
+// CacheConfig configures how the local Taildrive filesystem caches the file
+// metadata and contents of a remote.
+type CacheConfig struct {
+	// StatTTL is how long to cache file metadata and directory listings. If
+	// zero, a default of a few seconds is used. If negative, they aren't
+	// cached.
+	StatTTL tstime.GoDuration `json:",omitzero"`
+
+	// MaxContentSize is the maximum total size of file contents to cache on
+	// disk, in bytes. If zero, a default is used. If negative, file contents
+	// aren't cached.
+	MaxContentSize int64 `json:",omitzero"`
+}
+
+// CacheSettings is the Taildrive cache configuration for all remotes.
+type CacheSettings struct {
+	// Default is the configuration of remotes not in Remotes, and of the
+	// unset fields of those in Remotes.
+	Default CacheConfig `json:",omitzero"`
+
+	// Remotes are the configurations of specific remotes, by name.
+	Remotes map[string]CacheConfig `json:",omitempty"`
+}
+
+// For returns the cache configuration of the named remote.
+func (s *CacheSettings) For(remote string) CacheConfig {
+	if s == nil {
+		return CacheConfig{}
+	}
+	cfg := s.Remotes[remote]
+	if cfg.StatTTL.Duration == 0 {
+		cfg.StatTTL = s.Default.StatTTL
+	}
+	if cfg.MaxContentSize == 0 {
+		cfg.MaxContentSize = s.Default.MaxContentSize
+	}
+	return cfg
 }
 
 // FileSystemForLocal is the Taildrive filesystem exposed to local clients. It
@@ -27,6 +75,11 @@ type FileSystemForLocal interface {
 	// HandleConn handles connections from local WebDAV clients
 	HandleConn(conn net.Conn, remoteAddr net.Addr) error
 
+	// HandleNFSConn handles connections from local NFSv3 clients, which
+	// see the same files as WebDAV clients. Clients aren't authenticated,
+	// so it must only be called for connections from this machine.
+	HandleNFSConn(conn net.Conn) error
+
 	// SetRemotes sets the complete set of remotes on the given tailnet domain
 	// using a map of name -> url. If transport is specified, that transport
 	// will be used to connect to these remotes.
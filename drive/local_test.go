@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package drive
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCacheSettingsFor(t *testing.T) {
+	var s *CacheSettings
+	if got := s.For("a"); got != (CacheConfig{}) {
+		t.Errorf("nil settings For = %+v; want zero", got)
+	}
+
+	s = new(CacheSettings)
+	if err := json.Unmarshal([]byte(`{
+		"Default": {"StatTTL": "30s", "MaxContentSize": 1000},
+		"Remotes": {
+			"nas": {"StatTTL": "5m"},
+			"laptop": {"StatTTL": "-1s", "MaxContentSize": -1}
+		}
+	}`), s); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remote  string
+		ttl     time.Duration
+		maxSize int64
+	}{
+		{"other", 30 * time.Second, 1000},
+		{"nas", 5 * time.Minute, 1000},
+		{"laptop", -time.Second, -1},
+	}
+	for _, tt := range tests {
+		got := s.For(tt.remote)
+		if got.StatTTL.Duration != tt.ttl || got.MaxContentSize != tt.maxSize {
+			t.Errorf("For(%q) = %+v; want StatTTL %v, MaxContentSize %d", tt.remote, got, tt.ttl, tt.maxSize)
+		}
+	}
+}
@@ -107,7 +107,7 @@ type FileSystemForRemote interface {
 	SetShares(shares []*Share)
 
 	// ServeHTTPWithPerms behaves like the similar method from http.Handler but
-	// also accepts a Permissions map that captures the permissions of the
+	// also accepts Permissions that capture the permissions of the
 	// connecting node.
 	ServeHTTPWithPerms(permissions Permissions, w http.ResponseWriter, r *http.Request)
 
@@ -6,6 +6,10 @@
 import (
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
+
+	"tailscale.com/util/mak"
 )
 
 type Permission uint8
@@ -24,42 +28,229 @@
 )
 
 // Permissions represents the set of permissions for a given principal to a
-// set of shares.
-type Permissions map[string]Permission
+// set of shares, and optionally to paths within those shares.
+//
+// Permissions granted to a path extend to everything beneath it, and
+// permissions from different grants combine by taking the most permissive.
+// Deny rules take precedence over all grants.
+type Permissions struct {
+	// shares maps share names (or wildcardShare) to the permission granted
+	// to the whole share.
+	shares map[string]Permission
+
+	// paths maps share names (or wildcardShare) to the permissions granted
+	// to paths within the share, keyed by cleaned path relative to the share
+	// root without a leading slash.
+	paths map[string]map[string]Permission
+
+	// deny maps share names (or wildcardShare) to lowercased glob patterns
+	// of paths within the share to which access is denied. Patterns that
+	// start with a slash are anchored at the share root, others match names
+	// at any depth.
+	deny map[string][]string
+}
 
+// grant is a taildrive grant as found in the drive peer capability.
 type grant struct {
 	Shares []string
 	Access string
+
+	// Paths, if non-empty, limits Access to these paths within Shares,
+	// relative to the share root. Without Paths, Access applies to the
+	// whole share.
+	Paths []string `json:",omitempty"`
+
+	// Deny is a list of glob patterns, in the syntax of path.Match, of paths
+	// within Shares to deny all access to. Patterns without a slash match
+	// files and directories with a matching name at any depth. Patterns with
+	// a slash, such as "/private" or "home/*/.ssh", match paths relative to
+	// the share root, along with everything beneath them. Patterns match
+	// case-insensitively, as shares may be on case-insensitive file systems.
+	Deny []string `json:",omitempty"`
 }
 
-// ParsePermissions builds a Permissions map from a list of raw grants.
+// ParsePermissions builds Permissions from a list of raw grants.
 func ParsePermissions(rawGrants [][]byte) (Permissions, error) {
-	permissions := make(Permissions)
+	var permissions Permissions
 	for _, rawGrant := range rawGrants {
 		var g grant
 		err := json.Unmarshal(rawGrant, &g)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshal raw grants %s: %v", rawGrant, err)
+			return Permissions{}, fmt.Errorf("unmarshal raw grants %s: %v", rawGrant, err)
+		}
+		permission := PermissionReadOnly
+		if g.Access == accessReadWrite {
+			permission = PermissionReadWrite
+		}
+		for _, pattern := range g.Deny {
+			if _, err := path.Match(pattern, ""); err != nil || cleanPath(pattern) == "" {
+				return Permissions{}, fmt.Errorf("invalid deny pattern %q in grant %s", pattern, rawGrant)
+			}
 		}
 		for _, share := range g.Shares {
-			existingPermission := permissions[share]
-			permission := PermissionReadOnly
-			if g.Access == accessReadWrite {
-				permission = PermissionReadWrite
+			for _, pattern := range g.Deny {
+				pattern = strings.ToLower(pattern)
+				if strings.Contains(pattern, "/") {
+					pattern = "/" + cleanPath(pattern) // anchored at the share root
+				}
+				mak.Set(&permissions.deny, share, append(permissions.deny[share], pattern))
+			}
+			if len(g.Paths) == 0 {
+				permissions.add(share, "", permission)
 			}
-			if permission > existingPermission {
-				permissions[share] = permission
+			for _, p := range g.Paths {
+				permissions.add(share, cleanPath(p), permission)
 			}
 		}
 	}
 	return permissions, nil
 }
 
+// add grants permission to the cleaned path pth within share, unless a more
+// permissive one was already granted.
+func (p *Permissions) add(share, pth string, permission Permission) {
+	if pth == "" {
+		if permission > p.shares[share] {
+			mak.Set(&p.shares, share, permission)
+		}
+		return
+	}
+	if permission > p.paths[share][pth] {
+		if p.paths[share] == nil {
+			mak.Set(&p.paths, share, make(map[string]Permission))
+		}
+		p.paths[share][pth] = permission
+	}
+}
+
+// cleanPath cleans the path p within a share, returning it relative to the
+// share root without leading or trailing slashes. The share root is "".
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// isWithin reports whether the cleaned path p is dir or beneath it.
+func isWithin(p, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// For returns the permission granted to the whole share.
 func (p Permissions) For(share string) Permission {
-	specific := p[share]
-	wildcard := p[wildcardShare]
+	specific := p.shares[share]
+	wildcard := p.shares[wildcardShare]
 	if specific > wildcard {
 		return specific
 	}
 	return wildcard
 }
+
+// ForPath returns the permission to the file or directory at path pth within
+// share.
+func (p Permissions) ForPath(share, pth string) Permission {
+	pth = cleanPath(pth)
+	if p.denied(share, pth) {
+		return PermissionNone
+	}
+	permission := p.For(share)
+	for _, s := range []string{share, wildcardShare} {
+		for dir, dp := range p.paths[s] {
+			if dp > permission && isWithin(pth, dir) {
+				permission = dp
+			}
+		}
+	}
+	return permission
+}
+
+// Visible reports whether the file or directory at path pth within share may
+// be seen, either because there is some permission to it or because it
+// contains a path with some permission to it. Directories that are only
+// visible for the latter reason may be listed, but only show the entries that
+// are themselves visible.
+func (p Permissions) Visible(share, pth string) bool {
+	pth = cleanPath(pth)
+	if p.ForPath(share, pth) != PermissionNone {
+		return true
+	}
+	if p.denied(share, pth) {
+		return false
+	}
+	for _, s := range []string{share, wildcardShare} {
+		for dir := range p.paths[s] {
+			if isWithin(dir, pth) && !p.denied(share, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Uniform reports whether everything beneath the directory at path pth
+// within share has the same permission as the directory itself, that is,
+// whether no deny rule could apply to anything beneath it. Operations that
+// act on a whole directory tree, like moving or deleting it, are only safe if
+// this is true.
+//
+// Grants to paths beneath pth only ever add permissions, so they don't affect
+// the result.
+func (p Permissions) Uniform(share, pth string) bool {
+	pth = cleanPath(pth)
+	var elems []string
+	if pth != "" {
+		elems = strings.Split(strings.ToLower(pth), "/")
+	}
+	for _, s := range []string{share, wildcardShare} {
+		for _, pattern := range p.deny[s] {
+			anchored, ok := strings.CutPrefix(pattern, "/")
+			if !ok {
+				return false // matches names at any depth
+			}
+			patternElems := strings.Split(anchored, "/")
+			if len(patternElems) <= len(elems) {
+				continue // can only match pth or its ancestors
+			}
+			if matchElems(patternElems[:len(elems)], elems) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// denied reports whether a deny rule applies to the cleaned path pth within
+// share, ignoring case.
+func (p Permissions) denied(share, pth string) bool {
+	if pth == "" {
+		return false
+	}
+	elems := strings.Split(strings.ToLower(pth), "/")
+	for _, s := range []string{share, wildcardShare} {
+		for _, pattern := range p.deny[s] {
+			anchored, ok := strings.CutPrefix(pattern, "/")
+			if !ok {
+				for _, elem := range elems {
+					if ok, _ := path.Match(pattern, elem); ok {
+						return true
+					}
+				}
+				continue
+			}
+			patternElems := strings.Split(anchored, "/")
+			if len(patternElems) <= len(elems) && matchElems(patternElems, elems[:len(patternElems)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchElems reports whether each path element in elems matches the
+// corresponding pattern in patterns, which must be of the same length.
+func matchElems(patterns, elems []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, elems[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -59,3 +59,100 @@ func TestPermissions(t *testing.T) {
 		})
 	}
 }
+
+func TestPathPermissions(t *testing.T) {
+	grants := []grant{
+		{Shares: []string{"a"}, Access: "ro"},
+		{Shares: []string{"a"}, Access: "rw", Paths: []string{"/incoming", "x/y/"}},
+		{Shares: []string{"a"}, Access: "ro", Deny: []string{"*.key", "/private", "home/*/.ssh"}},
+		{Shares: []string{"b"}, Access: "rw", Paths: []string{"/shared/docs"}, Deny: []string{"*.key"}},
+	}
+	var rawPerms [][]byte
+	for _, g := range grants {
+		b, err := json.Marshal(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawPerms = append(rawPerms, b)
+	}
+	p, err := ParsePermissions(rawPerms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		share, path string
+		want        Permission
+		visible     bool
+		uniform     bool
+	}{
+		{"a", "", PermissionReadOnly, true, false},
+		{"a", "/", PermissionReadOnly, true, false},
+		{"a", "file.txt", PermissionReadOnly, true, false},
+		{"a", "incoming", PermissionReadWrite, true, false},
+		{"a", "/incoming/sub/file.txt", PermissionReadWrite, true, false},
+		{"a", "incoming2", PermissionReadOnly, true, false},
+		{"a", "x", PermissionReadOnly, true, false},
+		{"a", "x/y/z", PermissionReadWrite, true, false},
+		{"a", "incoming/id.key", PermissionNone, false, false},
+		{"a", "private", PermissionNone, false, false},
+		{"a", "private/file.txt", PermissionNone, false, false},
+		{"a", "Private/file.txt", PermissionNone, false, false},
+		{"a", "incoming/ID.KEY", PermissionNone, false, false},
+		{"a", "HOME/alice/.SSH/id", PermissionNone, false, false},
+		{"a", "sub/private", PermissionReadOnly, true, false},
+		{"a", "home/alice/.ssh/id", PermissionNone, false, false},
+		{"a", "home/alice/.sshx", PermissionReadOnly, true, false},
+		{"b", "", PermissionNone, true, false},
+		{"b", "shared", PermissionNone, true, false},
+		{"b", "other", PermissionNone, false, false},
+		{"b", "shared/docs/file.txt", PermissionReadWrite, true, false},
+		{"b", "shared/docs/file.key", PermissionNone, false, false},
+		{"c", "", PermissionNone, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.share+"/"+tt.path, func(t *testing.T) {
+			if got := p.ForPath(tt.share, tt.path); got != tt.want {
+				t.Errorf("ForPath = %v, want %v", got, tt.want)
+			}
+			if got := p.Visible(tt.share, tt.path); got != tt.visible {
+				t.Errorf("Visible = %v, want %v", got, tt.visible)
+			}
+			if got := p.Uniform(tt.share, tt.path); got != tt.uniform {
+				t.Errorf("Uniform = %v, want %v", got, tt.uniform)
+			}
+		})
+	}
+}
+
+func TestPermissionsUniform(t *testing.T) {
+	p, err := ParsePermissions([][]byte{[]byte(`{"shares": ["a"], "access": "rw", "deny": ["/home/*/.ssh"]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pth, want := range map[string]bool{
+		"":                  false,
+		"home":              false,
+		"home/alice":        false,
+		"home/alice/.ssh":   true, // denied itself
+		"Home/Alice":        false,
+		"home/alice/public": true,
+		"other":             true,
+	} {
+		if got := p.Uniform("a", pth); got != want {
+			t.Errorf("Uniform(%q) = %v, want %v", pth, got, want)
+		}
+	}
+}
+
+func TestParsePermissionsInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`{`,
+		`{"shares": ["a"], "deny": ["["]}`,
+		`{"shares": ["a"], "deny": ["/"]}`,
+	} {
+		if _, err := ParsePermissions([][]byte{[]byte(raw)}); err == nil {
+			t.Errorf("ParsePermissions(%s) succeeded, want error", raw)
+		}
+	}
+}
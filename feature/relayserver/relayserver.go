@@ -17,6 +17,7 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnext"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
 	"tailscale.com/net/udprelay"
 	"tailscale.com/net/udprelay/endpoint"
 	"tailscale.com/tailcfg"
@@ -24,6 +25,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/httpm"
+	"tailscale.com/util/usermetric"
 )
 
 // featureName is the name of the feature implemented by this package.
@@ -34,19 +36,24 @@ func init() {
 	feature.Register(featureName)
 	ipnext.RegisterExtension(featureName, newExtension)
 	ipnlocal.RegisterPeerAPIHandler("/v0/relay/endpoint", handlePeerAPIRelayAllocateEndpoint)
+	localapi.Register("debug-relay-status", serveDebugRelayStatus)
 }
 
 // newExtension is an [ipnext.NewExtensionFn] that creates a new relay server
 // extension. It is registered with [ipnext.RegisterExtension] if the package is
 // imported.
-func newExtension(logf logger.Logf, _ ipnext.SafeBackend) (ipnext.Extension, error) {
-	return &extension{logf: logger.WithPrefix(logf, featureName+": ")}, nil
+func newExtension(logf logger.Logf, sb ipnext.SafeBackend) (ipnext.Extension, error) {
+	return &extension{
+		logf:    logger.WithPrefix(logf, featureName+": "),
+		metrics: sb.Sys().UserMetricsRegistry(),
+	}, nil
 }
 
 // extension is an [ipnext.Extension] managing the relay server on platforms
 // that import this package.
 type extension struct {
-	logf logger.Logf
+	logf    logger.Logf
+	metrics *usermetric.Registry // may be nil in tests
 
 	mu                     sync.Mutex // guards the following fields
 	shutdown               bool
@@ -55,9 +62,27 @@ type extension struct {
 	server                 relayServer // lazily initialized
 }
 
+var (
+	// Resource limits of the relay server, see [udprelay.Quotas]. Clients
+	// are the peers requesting endpoint allocations over the PeerAPI.
+	maxEndpointsPerClient = envknob.RegisterInt("TS_RELAY_SERVER_MAX_ENDPOINTS_PER_CLIENT")
+	endpointBytesPerSec   = envknob.RegisterInt("TS_RELAY_SERVER_ENDPOINT_BYTES_PER_SEC")
+	clientBytesPerSec     = envknob.RegisterInt("TS_RELAY_SERVER_CLIENT_BYTES_PER_SEC")
+)
+
+// quotas returns the [udprelay.Quotas] configured via envknobs.
+func quotas() udprelay.Quotas {
+	return udprelay.Quotas{
+		MaxEndpointsPerClient: maxEndpointsPerClient(),
+		EndpointBytesPerSec:   endpointBytesPerSec(),
+		ClientBytesPerSec:     clientBytesPerSec(),
+	}
+}
+
 // relayServer is the interface of [udprelay.Server].
 type relayServer interface {
-	AllocateEndpoint(discoA key.DiscoPublic, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error)
+	AllocateEndpoint(discoA key.DiscoPublic, discoB key.DiscoPublic, requester tailcfg.StableNodeID) (endpoint.ServerEndpoint, error)
+	Status() endpoint.ServerStatus
 	Close() error
 }
 
@@ -134,14 +159,49 @@ func (e *extension) relayServerOrInit() (relayServer, error) {
 	if !envknob.UseWIPCode() {
 		return nil, errors.New("TAILSCALE_USE_WIP_CODE envvar is not set")
 	}
-	var err error
-	e.server, _, err = udprelay.NewServer(e.logf, *e.port, nil)
+	server, _, err := udprelay.NewServer(e.logf, *e.port, nil)
 	if err != nil {
 		return nil, err
 	}
+	server.SetQuotas(quotas())
+	server.SetMetricsRegistry(e.metrics)
+	e.server = server
 	return e.server, nil
 }
 
+// status returns the status of the relay server. It does not start the relay
+// server if it has not been started yet.
+func (e *extension) status() endpoint.ServerStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.server != nil {
+		return e.server.Status()
+	}
+	var st endpoint.ServerStatus
+	if e.port != nil {
+		st.UDPPort = ptr.To(*e.port)
+	}
+	return st
+}
+
+func serveDebugRelayStatus(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "only GET method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	e, ok := ipnlocal.GetExt[*extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "relay server extension is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(e.status())
+}
+
 func handlePeerAPIRelayAllocateEndpoint(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
 	e, ok := ipnlocal.GetExt[*extension](h.LocalBackend())
 	if !ok {
@@ -182,7 +242,11 @@ func handlePeerAPIRelayAllocateEndpoint(h ipnlocal.PeerAPIHandler, w http.Respon
 		httpErrAndLog(err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	ep, err := rs.AllocateEndpoint(allocateEndpointReq.DiscoKeys[0], allocateEndpointReq.DiscoKeys[1])
+	ep, err := rs.AllocateEndpoint(allocateEndpointReq.DiscoKeys[0], allocateEndpointReq.DiscoKeys[1], h.Peer().StableID())
+	if errors.Is(err, udprelay.ErrQuotaExceeded) {
+		httpErrAndLog(err.Error(), http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		httpErrAndLog(err.Error(), http.StatusInternalServerError)
 		return
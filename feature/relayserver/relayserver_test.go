@@ -7,8 +7,11 @@
 	"errors"
 	"testing"
 
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
+	"tailscale.com/net/udprelay"
 	"tailscale.com/net/udprelay/endpoint"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/types/ptr"
 )
@@ -17,7 +20,9 @@ type fakeRelayServer struct{
 
 func (f *fakeRelayServer) Close() error { return nil }
 
-func (f *fakeRelayServer) AllocateEndpoint(_, _ key.DiscoPublic) (endpoint.ServerEndpoint, error) {
+func (f *fakeRelayServer) Status() endpoint.ServerStatus { return endpoint.ServerStatus{} }
+
+func (f *fakeRelayServer) AllocateEndpoint(_, _ key.DiscoPublic, _ tailcfg.StableNodeID) (endpoint.ServerEndpoint, error) {
 	return endpoint.ServerEndpoint{}, errors.New("fake relay server")
 }
 
@@ -124,3 +129,32 @@ type args struct {
 		})
 	}
 }
+
+func Test_quotas(t *testing.T) {
+	envknob.Setenv("TS_RELAY_SERVER_MAX_ENDPOINTS_PER_CLIENT", "10")
+	envknob.Setenv("TS_RELAY_SERVER_CLIENT_BYTES_PER_SEC", "1000000")
+	defer envknob.Setenv("TS_RELAY_SERVER_MAX_ENDPOINTS_PER_CLIENT", "")
+	defer envknob.Setenv("TS_RELAY_SERVER_CLIENT_BYTES_PER_SEC", "")
+	want := udprelay.Quotas{
+		MaxEndpointsPerClient: 10,
+		ClientBytesPerSec:     1000000,
+	}
+	if got := quotas(); got != want {
+		t.Errorf("quotas() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_extension_status(t *testing.T) {
+	e := &extension{}
+	if got := e.status(); got.UDPPort != nil {
+		t.Errorf("got UDPPort %v for disabled server, want nil", *got.UDPPort)
+	}
+	e.port = ptr.To(7)
+	if got := e.status(); got.UDPPort == nil || *got.UDPPort != 7 {
+		t.Errorf("got UDPPort %v, want 7", got.UDPPort)
+	}
+	e.server = &fakeRelayServer{}
+	if got := e.status(); got.UDPPort != nil {
+		t.Errorf("got UDPPort %v, want status of server", *got.UDPPort)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/clientmetric"
+)
+
+// Auto-accept rules let headless nodes (servers without anyone around to run
+// `tailscale file get`) move received files out of the Taildrop inbox as soon
+// as they arrive, and optionally run a command on each of them.
+//
+// The rules are read from a JSON file, autoAcceptFileName in the tailscaled
+// state directory unless overridden by TS_TAILDROP_AUTO_ACCEPT_CONFIG. The
+// file is read each time a file is received, so changes take effect without
+// restarting tailscaled. For example:
+//
+//	{
+//	  "Rules": [
+//	    {
+//	      "From": ["tag:ci"],
+//	      "Names": ["*.tar.gz"],
+//	      "MaxSize": 1073741824,
+//	      "Dir": "/srv/artifacts",
+//	      "Exec": ["/usr/local/bin/unpack-artifact"]
+//	    },
+//	    {"From": ["alice@example.com"], "Dir": "/home/alice/Downloads"}
+//	  ]
+//	}
+//
+// Auto-accept rules are only supported on Linux.
+
+// autoAcceptFileName is the name of the auto-accept configuration file within
+// the tailscaled state directory.
+const autoAcceptFileName = "taildrop-auto-accept.json"
+
+// hookTimeout is how long a post-receive hook may run before it is killed.
+const hookTimeout = 5 * time.Minute
+
+// hookPath is the PATH of post-receive hooks.
+const hookPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+var autoAcceptConfigEnv = envknob.RegisterString("TS_TAILDROP_AUTO_ACCEPT_CONFIG")
+
+var (
+	metricAutoAccepted  = clientmetric.NewCounter("taildrop_auto_accepted")
+	metricAutoAcceptErr = clientmetric.NewCounter("taildrop_auto_accept_error")
+	metricHookErr       = clientmetric.NewCounter("taildrop_hook_error")
+)
+
+// autoAcceptConfigPath returns the path of the auto-accept configuration
+// file, given the tailscaled state directory varRoot, or the empty string
+// if there is none.
+func autoAcceptConfigPath(varRoot string) string {
+	if v := autoAcceptConfigEnv(); v != "" {
+		return v
+	}
+	if varRoot == "" {
+		return ""
+	}
+	return filepath.Join(varRoot, autoAcceptFileName)
+}
+
+// autoAcceptConfig is the auto-accept configuration.
+type autoAcceptConfig struct {
+	// Rules are the auto-accept rules. The first rule that matches a
+	// received file applies; files matching no rule stay in the inbox.
+	Rules []autoAcceptRule
+}
+
+// autoAcceptRule is a rule for accepting received files.
+type autoAcceptRule struct {
+	// From, if non-empty, restricts the rule to files sent by the listed
+	// users (by login name, such as "alice@example.com") or tagged nodes
+	// (by tag, such as "tag:ci").
+	From []string `json:",omitempty"`
+
+	// Names, if non-empty, restricts the rule to files whose names match
+	// one of the listed patterns, using the syntax of [path.Match].
+	Names []string `json:",omitempty"`
+
+	// MaxSize, if positive, restricts the rule to files (or directories
+	// with a total size) of at most MaxSize bytes.
+	MaxSize int64 `json:",omitempty"`
+
+	// Dir is the absolute path of the directory to move accepted files
+	// into. If a file of the same name exists, the accepted file is
+	// renamed, as in the Taildrop inbox.
+	Dir string
+
+	// Exec, if non-empty, is a command to run with its arguments after
+	// a file was accepted. The path of the accepted file is appended as
+	// the last argument, and details about the file and its sender are
+	// passed in TS_TAILDROP_* environment variables. See [hookEnv].
+	//
+	// The command runs as the same user as tailscaled, but does not inherit
+	// its environment.
+	Exec []string `json:",omitempty"`
+}
+
+// loadAutoAcceptConfig reads the auto-accept configuration from path.
+// It returns (nil, nil) if the file does not exist.
+func loadAutoAcceptConfig(path string) (*autoAcceptConfig, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(autoAcceptConfig)
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *autoAcceptConfig) validate() error {
+	for i, r := range cfg.Rules {
+		if !filepath.IsAbs(r.Dir) {
+			return fmt.Errorf("rule %d: Dir %q is not an absolute path", i, r.Dir)
+		}
+		for _, pat := range r.Names {
+			if _, err := path.Match(pat, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid name pattern %q", i, pat)
+			}
+		}
+		if len(r.Exec) > 0 && r.Exec[0] == "" {
+			return fmt.Errorf("rule %d: empty Exec command", i)
+		}
+	}
+	return nil
+}
+
+// sender identifies the node that sent a file.
+type sender struct {
+	NodeID    tailcfg.StableNodeID
+	NodeName  string   // the node's MagicDNS name, without the trailing dot
+	LoginName string   // the node owner's login name; empty if tagged
+	Tags      []string // the node's tags, if any
+}
+
+// matches reports whether r applies to a file named name, of the given size,
+// received from s.
+func (r *autoAcceptRule) matches(s sender, name string, size int64) bool {
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return false
+	}
+	if len(r.From) > 0 && !slices.ContainsFunc(r.From, func(from string) bool {
+		if strings.HasPrefix(from, "tag:") {
+			return slices.Contains(s.Tags, from)
+		}
+		return s.LoginName != "" && strings.EqualFold(from, s.LoginName)
+	}) {
+		return false
+	}
+	if len(r.Names) > 0 && !slices.ContainsFunc(r.Names, func(pat string) bool {
+		ok, _ := path.Match(pat, name)
+		return ok
+	}) {
+		return false
+	}
+	return true
+}
+
+// match returns the first rule in cfg that applies, or nil if none does.
+func (cfg *autoAcceptConfig) match(s sender, name string, size int64) *autoAcceptRule {
+	for i := range cfg.Rules {
+		if r := &cfg.Rules[i]; r.matches(s, name, size) {
+			return r
+		}
+	}
+	return nil
+}
+
+// senderByID returns the identity of the peer with the given ID.
+func (e *Extension) senderByID(id clientID) (s sender, ok bool) {
+	nb := e.nodeBackend()
+	if nb == nil {
+		return s, false
+	}
+	peer, ok := nb.PeerByStableID(tailcfg.StableNodeID(id))
+	if !ok {
+		return s, false
+	}
+	s = sender{
+		NodeID:   peer.StableID(),
+		NodeName: strings.TrimSuffix(peer.Name(), "."),
+		Tags:     peer.Tags().AsSlice(),
+	}
+	if !peer.IsTagged() {
+		if u, ok := nb.UserByID(peer.User()); ok {
+			s.LoginName = u.LoginName()
+		}
+	}
+	return s, true
+}
+
+// onReceived applies the auto-accept rules to the file or directory name,
+// which was just received from id into the Taildrop inbox.
+func (e *Extension) onReceived(id clientID, name string) {
+	m := e.manager()
+	if m == nil || e.autoAcceptPath == "" {
+		return
+	}
+	cfg, err := loadAutoAcceptConfig(e.autoAcceptPath)
+	if err != nil {
+		metricAutoAcceptErr.Add(1)
+		e.logf("auto-accept: %v", err)
+		return
+	}
+	if cfg == nil || len(cfg.Rules) == 0 {
+		return
+	}
+	s, ok := e.senderByID(id)
+	if !ok {
+		e.logf("auto-accept: unknown sender %v; leaving file in inbox", id)
+		return
+	}
+
+	src := filepath.Join(m.Dir(), name)
+	fi, err := os.Lstat(src)
+	if err != nil {
+		// Already retrieved or deleted.
+		return
+	}
+	size := fi.Size()
+	if fi.IsDir() {
+		size = dirSize(src)
+	}
+	r := cfg.match(s, name, size)
+	if r == nil {
+		return
+	}
+	dst, err := m.moveOut(name, r.Dir)
+	if err != nil {
+		metricAutoAcceptErr.Add(1)
+		e.logf("auto-accept: could not move file from %v: %v", s.NodeName, redactError(err))
+		return
+	}
+	metricAutoAccepted.Add(1)
+	e.logf("auto-accepted %s from %v", approxSize(size), s.NodeName)
+	m.opts.SendFileNotify()
+
+	if len(r.Exec) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, r.Exec[0], append(r.Exec[1:], dst)...)
+	cmd.Env = hookEnv(s, dst, size, fi.IsDir())
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		metricHookErr.Add(1)
+		const maxOutput = 1 << 10
+		e.logf("auto-accept: hook %q failed: %v; output: %q", r.Exec[0], err, out.Bytes()[:min(out.Len(), maxOutput)])
+	}
+}
+
+// hookEnv returns the environment of a post-receive hook for an accepted
+// file at dst of the given size, received from s. Besides PATH, set to
+// hookPath, it only contains variables describing the file:
+//
+//   - TS_TAILDROP_PATH: the path of the accepted file
+//   - TS_TAILDROP_SIZE: its size in bytes, or total size if a directory
+//   - TS_TAILDROP_IS_DIR: "1" if it was received as a directory
+//   - TS_TAILDROP_SENDER_NODE: the sender's MagicDNS name
+//   - TS_TAILDROP_SENDER_NODE_ID: the sender's stable node ID
+//   - TS_TAILDROP_SENDER_LOGIN: the sender's login name, if not tagged
+//   - TS_TAILDROP_SENDER_TAGS: the sender's tags, comma-separated
+func hookEnv(s sender, dst string, size int64, isDir bool) []string {
+	env := []string{
+		"PATH=" + hookPath,
+		"TS_TAILDROP_PATH=" + dst,
+		"TS_TAILDROP_SIZE=" + strconv.FormatInt(size, 10),
+		"TS_TAILDROP_SENDER_NODE=" + s.NodeName,
+		"TS_TAILDROP_SENDER_NODE_ID=" + string(s.NodeID),
+		"TS_TAILDROP_SENDER_LOGIN=" + s.LoginName,
+		"TS_TAILDROP_SENDER_TAGS=" + strings.Join(s.Tags, ","),
+	}
+	if isDir {
+		env = append(env, "TS_TAILDROP_IS_DIR=1")
+	}
+	return env
+}
+
+// moveOut moves the received file or directory baseName out of [manager.Dir]
+// into dir, choosing a new name if baseName already exists there. It returns
+// the new path.
+//
+// If it can't be renamed because dir is on another file system, the file is
+// copied instead, and then deleted with [manager.DeleteFile].
+func (m *manager) moveOut(baseName, dir string) (string, error) {
+	src, err := joinDir(m.opts.Dir, baseName)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, baseName)
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+	const maxRetries = 10
+	for range maxRetries {
+		if _, err := os.Lstat(dst); err == nil {
+			dst = nextFilename(dst)
+			continue
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		err := os.Rename(src, dst)
+		if err == nil {
+			return dst, nil
+		}
+		if !isCrossDevice(err) {
+			return "", err
+		}
+		if err := copyTree(src, dst); err != nil {
+			os.RemoveAll(dst)
+			return "", err
+		}
+		return dst, m.DeleteFile(baseName)
+	}
+	return "", fmt.Errorf("too many retries trying to move %q", baseName)
+}
+
+// copyTree copies the regular file or directory tree at src to dst, which
+// must not exist. Files other than regular files and directories are
+// skipped.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			// Keep the directory writable while copying its contents.
+			if err := os.Mkdir(target, fi.Mode().Perm()|0o700); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := copyFile(p, target, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, time.Time{}, fi.ModTime())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
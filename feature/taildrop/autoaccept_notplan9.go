@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package taildrop
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice reports whether err is from renaming a file across file
+// systems.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
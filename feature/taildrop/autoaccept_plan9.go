@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+// isCrossDevice reports whether err is from renaming a file across file
+// systems.
+func isCrossDevice(err error) bool {
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/ipnext"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+func TestAutoAcceptRuleMatches(t *testing.T) {
+	alice := sender{NodeID: "n1", NodeName: "laptop.tail-scale.ts.net", LoginName: "alice@example.com"}
+	ci := sender{NodeID: "n2", NodeName: "ci.tail-scale.ts.net", Tags: []string{"tag:ci", "tag:prod"}}
+
+	tests := []struct {
+		name string
+		rule autoAcceptRule
+		s    sender
+		file string
+		size int64
+		want bool
+	}{
+		{"any", autoAcceptRule{}, alice, "a.txt", 1, true},
+		{"user", autoAcceptRule{From: []string{"Alice@example.com"}}, alice, "a.txt", 1, true},
+		{"other-user", autoAcceptRule{From: []string{"bob@example.com"}}, alice, "a.txt", 1, false},
+		{"tag", autoAcceptRule{From: []string{"bob@example.com", "tag:ci"}}, ci, "a.txt", 1, true},
+		{"tag-not-user", autoAcceptRule{From: []string{"tag:ci"}}, alice, "a.txt", 1, false},
+		{"size-ok", autoAcceptRule{MaxSize: 10}, alice, "a.txt", 10, true},
+		{"too-large", autoAcceptRule{MaxSize: 10}, alice, "a.txt", 11, false},
+		{"name", autoAcceptRule{Names: []string{"*.jpg", "*.txt"}}, alice, "a.txt", 1, true},
+		{"other-name", autoAcceptRule{Names: []string{"*.jpg"}}, alice, "a.txt", 1, false},
+		{"all", autoAcceptRule{From: []string{"tag:prod"}, Names: []string{"build-*.tar.gz"}, MaxSize: 100}, ci, "build-42.tar.gz", 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.matches(tt.s, tt.file, tt.size); got != tt.want {
+				t.Errorf("matches = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAutoAcceptConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, autoAcceptFileName)
+	if cfg, err := loadAutoAcceptConfig(path); cfg != nil || err != nil {
+		t.Errorf("missing config = %v, %v; want nil, nil", cfg, err)
+	}
+
+	for _, bad := range []string{
+		`{`,
+		`{"Rules": [{"Dir": "relative"}]}`,
+		`{"Rules": [{"Dir": "/srv", "Names": ["["]}]}`,
+		`{"Rules": [{"Dir": "/srv", "Exec": [""]}]}`,
+	} {
+		must.Do(os.WriteFile(path, []byte(bad), 0600))
+		if _, err := loadAutoAcceptConfig(path); err == nil {
+			t.Errorf("loading %s succeeded; want error", bad)
+		}
+	}
+
+	must.Do(os.WriteFile(path, []byte(`{"Rules": [{"From": ["tag:ci"], "Dir": "/srv/ci"}, {"Dir": "/srv/other"}]}`), 0600))
+	cfg := must.Get(loadAutoAcceptConfig(path))
+	if r := cfg.match(sender{Tags: []string{"tag:ci"}}, "x", 1); r == nil || r.Dir != "/srv/ci" {
+		t.Errorf("match for tag:ci = %+v; want first rule", r)
+	}
+	if r := cfg.match(sender{LoginName: "alice@example.com"}, "x", 1); r == nil || r.Dir != "/srv/other" {
+		t.Errorf("match for alice = %+v; want second rule", r)
+	}
+}
+
+// senderNodeBackend is a NodeBackend with a single peer.
+type senderNodeBackend struct {
+	ipnext.NodeBackend
+	peer tailcfg.NodeView
+	user tailcfg.UserProfileView
+}
+
+func (nb senderNodeBackend) PeerByStableID(id tailcfg.StableNodeID) (tailcfg.NodeView, bool) {
+	return nb.peer, nb.peer.StableID() == id
+}
+
+func (nb senderNodeBackend) UserByID(id tailcfg.UserID) (tailcfg.UserProfileView, bool) {
+	return nb.user, nb.user.ID() == id
+}
+
+func TestAutoAccept(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	inbox := t.TempDir()
+	accepted := filepath.Join(t.TempDir(), "accepted")
+	envFile := filepath.Join(t.TempDir(), "env")
+	t.Setenv("TS_TEST_AUTO_ACCEPT_SECRET", "hunter2")
+	configPath := filepath.Join(t.TempDir(), autoAcceptFileName)
+	must.Do(os.WriteFile(configPath, []byte(`{"Rules": [{
+		"From": ["alice@example.com"],
+		"Names": ["*.txt"],
+		"Dir": "`+accepted+`",
+		"Exec": ["/bin/sh", "-c", "env > `+envFile+`"]
+	}]}`), 0600))
+
+	m := managerOptions{Logf: t.Logf, Dir: inbox}.New()
+	defer m.Shutdown()
+	e := &Extension{
+		logf:           t.Logf,
+		autoAcceptPath: configPath,
+		nodeBackendForTest: senderNodeBackend{
+			peer: (&tailcfg.Node{
+				StableID: "nALICE",
+				Name:     "laptop.tail-scale.ts.net.",
+				User:     1,
+			}).View(),
+			user: (&tailcfg.UserProfile{ID: 1, LoginName: "alice@example.com"}).View(),
+		},
+	}
+	e.mgr.Store(m)
+
+	for _, name := range []string{"a.txt", "b.jpg"} {
+		must.Get(m.PutFile("nALICE", name, strings.NewReader("hello"), 0, 5))
+		e.onReceived("nALICE", name)
+	}
+
+	if got := must.Get(os.ReadFile(filepath.Join(accepted, "a.txt"))); string(got) != "hello" {
+		t.Errorf("accepted a.txt = %q; want %q", got, "hello")
+	}
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 1 || wfs[0].Name != "b.jpg" {
+		t.Errorf("WaitingFiles = %+v; want only b.jpg", wfs)
+	}
+
+	env := string(must.Get(os.ReadFile(envFile)))
+	for _, want := range []string{
+		"TS_TAILDROP_PATH=" + filepath.Join(accepted, "a.txt"),
+		"TS_TAILDROP_SIZE=5",
+		"TS_TAILDROP_SENDER_NODE=laptop.tail-scale.ts.net",
+		"TS_TAILDROP_SENDER_NODE_ID=nALICE",
+		"TS_TAILDROP_SENDER_LOGIN=alice@example.com",
+	} {
+		if !strings.Contains(env, want+"\n") {
+			t.Errorf("hook environment does not contain %q", want)
+		}
+	}
+	if strings.Contains(env, "TS_TEST_AUTO_ACCEPT_SECRET") {
+		t.Errorf("hook environment inherits tailscaled's environment:\n%s", env)
+	}
+
+	// A second file of the same name is renamed.
+	must.Get(m.PutFile("nALICE", "a.txt", strings.NewReader("again"), 0, 5))
+	e.onReceived("nALICE", "a.txt")
+	if got := must.Get(os.ReadFile(filepath.Join(accepted, "a (1).txt"))); string(got) != "again" {
+		t.Errorf("accepted a (1).txt = %q; want %q", got, "again")
+	}
+
+	// Files from unknown senders stay in the inbox.
+	must.Get(m.PutFile("nBOB", "c.txt", strings.NewReader("hello"), 0, 5))
+	e.onReceived("nBOB", "c.txt")
+	if _, err := os.Stat(filepath.Join(inbox, "c.txt")); err != nil {
+		t.Errorf("file from unknown sender was moved: %v", err)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := t.TempDir()
+	must.Do(os.MkdirAll(filepath.Join(src, "a", "b"), 0o755))
+	must.Do(os.WriteFile(filepath.Join(src, "a", "b", "f"), []byte("hello"), 0o640))
+	dst := filepath.Join(t.TempDir(), "copy")
+	must.Do(copyTree(src, dst))
+	if got := must.Get(os.ReadFile(filepath.Join(dst, "a", "b", "f"))); string(got) != "hello" {
+		t.Errorf("copied file = %q; want %q", got, "hello")
+	}
+	if err := copyTree(src, dst); err == nil {
+		t.Error("copyTree to existing destination succeeded; want error")
+	}
+}
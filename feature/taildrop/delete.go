@@ -75,7 +75,8 @@ func (d *fileDeleter) Init(m *manager, eventHook func(string)) {
 			switch {
 			case d.shutdownCtx.Err() != nil:
 				return false // terminate early
-			case !de.Type().IsRegular():
+			case !de.Type().IsRegular() && !(de.IsDir() && strings.HasSuffix(de.Name(), partialSuffix)):
+				// Partial directories are staged directory transfers.
 				return true
 			case strings.HasSuffix(de.Name(), partialSuffix):
 				// Only enqueue the file for deletion if there is no active put.
@@ -155,7 +156,7 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					continue
 				}
 			}
-			if err := os.Remove(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
+			if err := os.RemoveAll(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
 				d.logf("could not delete: %v", redactError(err))
 				failed = append(failed, elem)
 				continue
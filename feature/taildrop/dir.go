@@ -0,0 +1,484 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Directory transfers.
+//
+// A directory is sent as a manifest listing its files and subdirectories
+// (see [apitype.TaildropManifest]), followed by the contents of each file, and
+// finally a commit. The receiver stages the tree in a partial directory next
+// to where the directory will end up:
+//
+//	<name>.<id>.partial/manifest.json
+//	<name>.<id>.partial/tree/...
+//
+// Files in the tree are written and resumed like partial files of single file
+// transfers. On commit, every file is verified against the manifest and the
+// tree is renamed into place, so the directory appears as a single waiting
+// file once complete.
+
+var (
+	ErrInvalidManifest  = errors.New("invalid directory manifest")
+	ErrManifestMismatch = errors.New("directory contents don't match manifest")
+	ErrNoDirTransfer    = errors.New("no directory transfer in progress")
+)
+
+const (
+	// maxManifestEntries is the maximum number of entries in a manifest.
+	maxManifestEntries = 100_000
+
+	// MaxManifestSize is the maximum size in bytes of an encoded manifest.
+	MaxManifestSize = 32 << 20
+
+	// manifestName is the name of the manifest within a staging directory.
+	manifestName = "manifest.json"
+
+	// treeName is the name of the directory tree within a staging directory.
+	treeName = "tree"
+)
+
+// validateManifest reports whether man is a well-formed manifest whose paths
+// are safe to create within a directory.
+func validateManifest(man *apitype.TaildropManifest) error {
+	if len(man.Files) > maxManifestEntries {
+		return fmt.Errorf("%w: too many entries", ErrInvalidManifest)
+	}
+	byPath := make(map[string]*apitype.TaildropManifestEntry, len(man.Files))
+	for i := range man.Files {
+		e := &man.Files[i]
+		if !validManifestPath(e.Path) {
+			return fmt.Errorf("%w: invalid path %q", ErrInvalidManifest, e.Path)
+		}
+		if _, dup := byPath[e.Path]; dup {
+			return fmt.Errorf("%w: duplicate path %q", ErrInvalidManifest, e.Path)
+		}
+		byPath[e.Path] = e
+		switch {
+		case e.Mode.IsDir():
+			if e.Size != 0 || e.SHA256 != "" {
+				return fmt.Errorf("%w: directory %q has contents", ErrInvalidManifest, e.Path)
+			}
+		case e.Mode.IsRegular():
+			if e.Size < 0 {
+				return fmt.Errorf("%w: file %q has negative size", ErrInvalidManifest, e.Path)
+			}
+			if b, err := hex.DecodeString(e.SHA256); err != nil || len(b) != len(checksum{}.cs) {
+				return fmt.Errorf("%w: file %q has invalid SHA-256", ErrInvalidManifest, e.Path)
+			}
+		default:
+			return fmt.Errorf("%w: %q has unsupported type %v", ErrInvalidManifest, e.Path, e.Mode.Type())
+		}
+	}
+	for p := range byPath {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if e, ok := byPath[dir]; ok && !e.Mode.IsDir() {
+				return fmt.Errorf("%w: %q is within file %q", ErrInvalidManifest, p, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// validManifestPath reports whether p is a valid slash-separated relative
+// path for a directory transfer. Each element must be a valid file name.
+func validManifestPath(p string) bool {
+	if p == "" || len(p) > 4096 {
+		return false
+	}
+	for elem := range strings.SplitSeq(p, "/") {
+		if _, err := joinDir("", elem); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// stagingDir returns the path of the directory where the directory transfer
+// of baseName from id is staged.
+func (m *manager) stagingDir(id clientID, baseName string) (string, error) {
+	dstPath, err := joinDir(m.opts.Dir, baseName)
+	if err != nil {
+		return "", err
+	}
+	return dstPath + id.partialSuffix(), nil
+}
+
+// BeginDir starts or resumes receiving a directory named baseName from id,
+// with contents described by man.
+//
+// If a transfer of the same directory with the same manifest was
+// interrupted, the files received so far are kept, and may be resumed using
+// [manager.HashPartialDirFile]. Otherwise any previous partial transfer is
+// discarded.
+func (m *manager) BeginDir(id clientID, baseName string, man *apitype.TaildropManifest) error {
+	if err := m.checkCanReceive(); err != nil {
+		return err
+	}
+	if m.opts.Mode != PutModeDirect {
+		return errors.New("directory transfers not supported")
+	}
+	if err := validateManifest(man); err != nil {
+		return err
+	}
+	staging, err := m.stagingDir(id, baseName)
+	if err != nil {
+		return err
+	}
+	m.deleter.Remove(filepath.Base(staging)) // avoid deleting the staging directory while receiving
+
+	manJSON, err := json.Marshal(man)
+	if err != nil {
+		return err
+	}
+	manPath := filepath.Join(staging, manifestName)
+	if old, err := os.ReadFile(manPath); err == nil && bytes.Equal(old, manJSON) {
+		return nil // resuming
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return m.redactAndLogError("RemoveAll", err)
+	}
+	if err := os.MkdirAll(staging, 0o700); err != nil {
+		return m.redactAndLogError("Mkdir", err)
+	}
+	if err := os.Mkdir(filepath.Join(staging, treeName), 0o777); err != nil {
+		return m.redactAndLogError("Mkdir", err)
+	}
+	if err := os.WriteFile(manPath, manJSON, 0o600); err != nil {
+		return m.redactAndLogError("WriteManifest", err)
+	}
+	m.markReceived()
+	return nil
+}
+
+// readManifest returns the manifest of the directory transfer staged in
+// staging.
+func readManifest(staging string) (*apitype.TaildropManifest, error) {
+	b, err := os.ReadFile(filepath.Join(staging, manifestName))
+	if os.IsNotExist(err) {
+		return nil, ErrNoDirTransfer
+	}
+	if err != nil {
+		return nil, redactError(err)
+	}
+	man := new(apitype.TaildropManifest)
+	if err := json.Unmarshal(b, man); err != nil {
+		return nil, err
+	}
+	return man, nil
+}
+
+// dirFile returns the staging directory of the directory transfer of
+// baseName from id, and the manifest entry for the regular file at relPath
+// within it.
+func (m *manager) dirFile(id clientID, baseName, relPath string) (staging string, _ apitype.TaildropManifestEntry, _ error) {
+	if m == nil || m.opts.Dir == "" {
+		return "", apitype.TaildropManifestEntry{}, ErrNoTaildrop
+	}
+	staging, err := m.stagingDir(id, baseName)
+	if err != nil {
+		return "", apitype.TaildropManifestEntry{}, err
+	}
+	man, err := readManifest(staging)
+	if err != nil {
+		return "", apitype.TaildropManifestEntry{}, err
+	}
+	for _, e := range man.Files {
+		if e.Path == relPath && e.Mode.IsRegular() {
+			return staging, e, nil
+		}
+	}
+	return "", apitype.TaildropManifestEntry{}, ErrInvalidFileName
+}
+
+// PutDirFile stores the file at relPath within the directory transfer of
+// baseName from id, which must have been started with [manager.BeginDir].
+// Like [manager.PutFile], it resumes writing at offset, and length is the
+// expected length of content to read from r, or negative if unknown.
+// It returns the length of the entire file.
+func (m *manager) PutDirFile(id clientID, baseName, relPath string, r io.Reader, offset, length int64) (int64, error) {
+	if err := m.checkCanReceive(); err != nil {
+		return 0, err
+	}
+	staging, e, err := m.dirFile(id, baseName, relPath)
+	if err != nil {
+		return 0, err
+	}
+	if length >= 0 && offset+length != e.Size {
+		return 0, fmt.Errorf("%w: %q is %d bytes, not %d", ErrManifestMismatch, relPath, e.Size, offset+length)
+	}
+
+	key := incomingFileKey{id, baseName + "/" + relPath}
+	inFile, loaded := m.incomingFiles.LoadOrInit(key, func() *incomingFile {
+		return &incomingFile{
+			clock:          m.opts.Clock,
+			started:        m.opts.Clock.Now(),
+			size:           e.Size,
+			sendFileNotify: m.opts.SendFileNotify,
+		}
+	})
+	if loaded {
+		return 0, ErrFileExists
+	}
+	defer m.incomingFiles.Delete(key)
+	defer func() {
+		if err != nil {
+			m.deleter.Insert(filepath.Base(staging)) // mark staging directory for eventual deletion
+		}
+	}()
+
+	dst := filepath.Join(staging, treeName, filepath.FromSlash(relPath))
+	if err = os.MkdirAll(filepath.Dir(dst), 0o777); err != nil {
+		return 0, m.redactAndLogError("Mkdir", err)
+	}
+	f, err := m.openAt(dst, offset)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	inFile.w = f
+	inFile.partialPath = dst
+
+	// Read at most one byte more than expected, to detect oversized files.
+	n, err := io.Copy(inFile, io.LimitReader(r, e.Size-offset+1))
+	if err != nil {
+		return 0, m.redactAndLogError("Copy", err)
+	}
+	if offset+n != e.Size {
+		err = fmt.Errorf("%w: %q is %d bytes, received %d", ErrManifestMismatch, relPath, e.Size, offset+n)
+		return 0, m.redactAndLogError("Copy", err)
+	}
+	if err = f.Close(); err != nil {
+		return 0, m.redactAndLogError("Close", err)
+	}
+
+	inFile.mu.Lock()
+	inFile.done = true
+	inFile.mu.Unlock()
+	m.opts.SendFileNotify()
+	return e.Size, nil
+}
+
+// HashPartialDirFile is like [manager.HashPartialFile], for the file at
+// relPath within the directory transfer of baseName from id.
+func (m *manager) HashPartialDirFile(id clientID, baseName, relPath string) (next func() (blockChecksum, error), close func() error, err error) {
+	staging, _, err := m.dirFile(id, baseName, relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hashBlocks(filepath.Join(staging, treeName, filepath.FromSlash(relPath)))
+}
+
+// CommitDir completes the directory transfer of baseName from id. It
+// verifies that all files were received intact, applies the modes and
+// modification times from the manifest, and moves the directory into place,
+// choosing a new name if baseName already exists. It returns the name the
+// directory was stored under.
+//
+// If a file doesn't match the manifest, it is removed from the staging
+// directory, and an error wrapping ErrManifestMismatch is returned. The
+// sender may then send the file again and retry.
+func (m *manager) CommitDir(id clientID, baseName string) (string, error) {
+	if err := m.checkCanReceive(); err != nil {
+		return "", err
+	}
+	staging, err := m.stagingDir(id, baseName)
+	if err != nil {
+		return "", err
+	}
+	man, err := readManifest(staging)
+	if err != nil {
+		return "", err
+	}
+	tree := filepath.Join(staging, treeName)
+	localPath := func(e apitype.TaildropManifestEntry) string {
+		return filepath.Join(tree, filepath.FromSlash(e.Path))
+	}
+
+	var dirs []apitype.TaildropManifestEntry
+	for _, e := range man.Files {
+		p := localPath(e)
+		if e.Mode.IsDir() {
+			if err := os.MkdirAll(p, 0o777); err != nil {
+				return "", m.redactAndLogError("Mkdir", err)
+			}
+			dirs = append(dirs, e)
+			continue
+		}
+		sum, err := sha256File(p)
+		if err != nil && !os.IsNotExist(err) {
+			return "", m.redactAndLogError("Hash", err)
+		}
+		if err != nil || hex.EncodeToString(sum[:]) != e.SHA256 {
+			os.Remove(p)
+			return "", fmt.Errorf("%w: %q", ErrManifestMismatch, e.Path)
+		}
+		if err := os.Chmod(p, e.Mode.Perm()); err != nil {
+			return "", m.redactAndLogError("Chmod", err)
+		}
+		if err := os.Chtimes(p, time.Time{}, e.ModTime); err != nil {
+			return "", m.redactAndLogError("Chtimes", err)
+		}
+	}
+	// Apply directory metadata deepest first, as creating entries within a
+	// directory updates its modification time.
+	slices.SortFunc(dirs, func(a, b apitype.TaildropManifestEntry) int {
+		return strings.Count(b.Path, "/") - strings.Count(a.Path, "/")
+	})
+	for _, e := range dirs {
+		p := localPath(e)
+		if err := os.Chtimes(p, time.Time{}, e.ModTime); err != nil {
+			return "", m.redactAndLogError("Chtimes", err)
+		}
+		// Keep directories writable by us until the tree is in place.
+		if err := os.Chmod(p, e.Mode.Perm()|0o700); err != nil {
+			return "", m.redactAndLogError("Chmod", err)
+		}
+	}
+
+	dstPath, err := m.renameDirIntoPlace(tree, filepath.Join(m.opts.Dir, baseName))
+	if err != nil {
+		return "", m.redactAndLogError("Rename", err)
+	}
+	for _, e := range dirs {
+		if e.Mode.Perm()&0o700 != 0o700 {
+			os.Chmod(filepath.Join(dstPath, filepath.FromSlash(e.Path)), e.Mode.Perm())
+		}
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		m.opts.Logf("could not remove staging directory: %v", redactError(err))
+		m.deleter.Insert(filepath.Base(staging))
+	}
+
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	m.notifyReceived(id, filepath.Base(dstPath))
+	return filepath.Base(dstPath), nil
+}
+
+// renameDirIntoPlace renames the directory tree to dstPath, or to the next
+// free name as returned by nextFilename if dstPath exists. It returns the
+// path tree was renamed to.
+func (m *manager) renameDirIntoPlace(tree, dstPath string) (string, error) {
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+	const maxRetries = 10
+	for range maxRetries {
+		_, err := os.Lstat(dstPath)
+		if os.IsNotExist(err) {
+			return dstPath, os.Rename(tree, dstPath)
+		}
+		if err != nil {
+			return "", err
+		}
+		dstPath = nextFilename(dstPath)
+	}
+	return "", fmt.Errorf("too many retries trying to rename a partial directory %q", dstPath)
+}
+
+// dirSize returns the total size of the regular files within dir.
+func dirSize(dir string) (size int64) {
+	filepath.WalkDir(dir, func(_ string, de fs.DirEntry, err error) error {
+		if err == nil && de.Type().IsRegular() {
+			if fi, err := de.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// OpenDir returns the contents of the waiting directory baseName from
+// [Handler.Dir] as a tar archive. Only regular files and directories are
+// included.
+// This method is only allowed when [Handler.DirectFileMode] is false.
+func (m *manager) OpenDir(baseName string) (io.ReadCloser, error) {
+	if m == nil || m.opts.Dir == "" {
+		return nil, ErrNoTaildrop
+	}
+	if m.opts.DirectFileMode {
+		return nil, errors.New("opens not allowed in direct mode")
+	}
+	root, err := joinDir(m.opts.Dir, baseName)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, redactError(err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", baseName)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, root))
+	}()
+	return pr, nil
+}
+
+// writeTar writes the regular files and directories within root to w as a
+// tar archive, with paths relative to root.
+func writeTar(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root || !(de.IsDir() || de.Type().IsRegular()) {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if de.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname, hdr.Uid, hdr.Gid = "", "", 0, 0
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return redactError(err)
+	}
+	return tw.Close()
+}
@@ -0,0 +1,237 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/must"
+)
+
+func fileEntry(path, contents string) apitype.TaildropManifestEntry {
+	sum := sha256.Sum256([]byte(contents))
+	return apitype.TaildropManifestEntry{
+		Path:    path,
+		Size:    int64(len(contents)),
+		Mode:    0o644,
+		ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		SHA256:  hex.EncodeToString(sum[:]),
+	}
+}
+
+func dirEntry(path string) apitype.TaildropManifestEntry {
+	return apitype.TaildropManifestEntry{
+		Path:    path,
+		Mode:    fs.ModeDir | 0o755,
+		ModTime: time.Date(2023, 6, 7, 8, 9, 10, 0, time.UTC),
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []apitype.TaildropManifestEntry
+		ok    bool
+	}{
+		{"empty", nil, true},
+		{"nested", []apitype.TaildropManifestEntry{dirEntry("a"), fileEntry("a/b.txt", "x"), fileEntry("c/d.txt", "y")}, true},
+		{"dot-dot", []apitype.TaildropManifestEntry{fileEntry("../x", "x")}, false},
+		{"absolute", []apitype.TaildropManifestEntry{fileEntry("/etc/passwd", "x")}, false},
+		{"empty-elem", []apitype.TaildropManifestEntry{fileEntry("a//b", "x")}, false},
+		{"backslash", []apitype.TaildropManifestEntry{fileEntry(`a\b`, "x")}, false},
+		{"duplicate", []apitype.TaildropManifestEntry{fileEntry("a", "x"), fileEntry("a", "y")}, false},
+		{"within-file", []apitype.TaildropManifestEntry{fileEntry("a", "x"), fileEntry("a/b", "y")}, false},
+		{"dir-with-hash", []apitype.TaildropManifestEntry{func() apitype.TaildropManifestEntry {
+			e := dirEntry("a")
+			e.SHA256 = fileEntry("a", "x").SHA256
+			return e
+		}()}, false},
+		{"bad-hash", []apitype.TaildropManifestEntry{func() apitype.TaildropManifestEntry {
+			e := fileEntry("a", "x")
+			e.SHA256 = "abc"
+			return e
+		}()}, false},
+		{"symlink", []apitype.TaildropManifestEntry{{Path: "a", Mode: fs.ModeSymlink | 0o777}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateManifest(&apitype.TaildropManifest{Files: tt.files})
+			if (err == nil) != tt.ok {
+				t.Errorf("validateManifest = %v; want ok=%v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidManifest) {
+				t.Errorf("error %v does not wrap ErrInvalidManifest", err)
+			}
+		})
+	}
+}
+
+func TestDirTransfer(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	contents := map[string]string{
+		"a.txt":     "hello",
+		"sub/b.txt": strings.Repeat("b", 1000),
+		"sub/empty": "",
+	}
+	man := &apitype.TaildropManifest{Files: []apitype.TaildropManifestEntry{
+		fileEntry("a.txt", contents["a.txt"]),
+		dirEntry("sub"),
+		fileEntry("sub/b.txt", contents["sub/b.txt"]),
+		fileEntry("sub/empty", contents["sub/empty"]),
+		dirEntry("sub/nothing"),
+	}}
+	man.Files[0].Mode = 0o600
+
+	must.Do(m.BeginDir("id", "photos", man))
+	if _, err := m.PutDirFile("id", "photos", "missing", strings.NewReader(""), 0, 0); err == nil {
+		t.Error("PutDirFile of file not in manifest succeeded")
+	}
+	if _, err := m.PutDirFile("other", "photos", "a.txt", strings.NewReader("hello"), 0, 5); !errors.Is(err, ErrNoDirTransfer) {
+		t.Errorf("PutDirFile from another client = %v; want ErrNoDirTransfer", err)
+	}
+
+	// Send part of sub/b.txt, then resume it after restarting the transfer.
+	if _, err := m.PutDirFile("id", "photos", "sub/b.txt", strings.NewReader(contents["sub/b.txt"][:300]), 0, -1); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("short PutDirFile = %v; want ErrManifestMismatch", err)
+	}
+	must.Do(m.BeginDir("id", "photos", man))
+	next, close, err := m.HashPartialDirFile("id", "photos", "sub/b.txt")
+	must.Do(err)
+	offset, r, err := resumeReader(strings.NewReader(contents["sub/b.txt"]), next)
+	must.Do(err)
+	must.Do(close())
+	if offset == 0 {
+		t.Error("resume offset = 0; want partial file to be resumed")
+	}
+	must.Get(m.PutDirFile("id", "photos", "sub/b.txt", r, offset, -1))
+
+	for _, p := range []string{"a.txt", "sub/empty"} {
+		must.Get(m.PutDirFile("id", "photos", p, strings.NewReader(contents[p]), 0, int64(len(contents[p]))))
+	}
+	name, err := m.CommitDir("id", "photos")
+	must.Do(err)
+	if name != "photos" {
+		t.Errorf("CommitDir name = %q; want %q", name, "photos")
+	}
+
+	root := filepath.Join(dir, "photos")
+	for p, want := range contents {
+		got := must.Get(os.ReadFile(filepath.Join(root, filepath.FromSlash(p))))
+		if string(got) != want {
+			t.Errorf("%s = %q; want %q", p, got, want)
+		}
+	}
+	for _, e := range man.Files {
+		fi := must.Get(os.Stat(filepath.Join(root, filepath.FromSlash(e.Path))))
+		if !fi.ModTime().Equal(e.ModTime) {
+			t.Errorf("%s: mtime = %v; want %v", e.Path, fi.ModTime(), e.ModTime)
+		}
+		if runtime.GOOS != "windows" && fi.Mode() != e.Mode {
+			t.Errorf("%s: mode = %v; want %v", e.Path, fi.Mode(), e.Mode)
+		}
+	}
+	if entries := must.Get(os.ReadDir(dir)); len(entries) != 1 {
+		t.Errorf("got %d entries in %s; want only the received directory", len(entries), dir)
+	}
+
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 1 || !wfs[0].Dir || wfs[0].Name != "photos" || wfs[0].Size != 1005 {
+		t.Errorf("WaitingFiles = %+v; want directory photos of size 1005", wfs)
+	}
+
+	// A second directory with the same name is renamed.
+	must.Do(m.BeginDir("id", "photos", &apitype.TaildropManifest{}))
+	if name := must.Get(m.CommitDir("id", "photos")); name != "photos (1)" {
+		t.Errorf("second CommitDir name = %q; want %q", name, "photos (1)")
+	}
+
+	rc := must.Get(m.OpenDir("photos"))
+	got := map[string]string{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		must.Do(err)
+		if hdr.Typeflag == tar.TypeDir {
+			got[strings.TrimSuffix(hdr.Name, "/")+"/"] = ""
+			continue
+		}
+		got[hdr.Name] = string(must.Get(io.ReadAll(tr)))
+	}
+	rc.Close()
+	want := map[string]string{"sub/": "", "sub/nothing/": ""}
+	for p, c := range contents {
+		want[p] = c
+	}
+	if len(got) != len(want) {
+		t.Errorf("tar has %d entries; want %d", len(got), len(want))
+	}
+	for p, c := range want {
+		if g, ok := got[p]; !ok || g != c {
+			t.Errorf("tar entry %q = %q, %v; want %q", p, g, ok, c)
+		}
+	}
+
+	must.Do(m.DeleteFile("photos"))
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("directory still exists after DeleteFile: %v", err)
+	}
+}
+
+func TestDirTransferMismatch(t *testing.T) {
+	dir := t.TempDir()
+	m := managerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	man := &apitype.TaildropManifest{Files: []apitype.TaildropManifestEntry{
+		fileEntry("good", "good"),
+		fileEntry("bad", "good"),
+	}}
+	must.Do(m.BeginDir("id", "d", man))
+	must.Get(m.PutDirFile("id", "d", "good", strings.NewReader("good"), 0, 4))
+	if _, err := m.PutDirFile("id", "d", "bad", strings.NewReader("toolong"), 0, -1); err == nil {
+		t.Error("PutDirFile of file larger than the manifest size succeeded")
+	}
+	must.Do(m.BeginDir("id", "d", man))
+	must.Get(m.PutDirFile("id", "d", "bad", strings.NewReader("evil"), 0, 4))
+	if _, err := m.CommitDir("id", "d"); !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("CommitDir = %v; want ErrManifestMismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "d")); !os.IsNotExist(err) {
+		t.Errorf("directory was committed despite mismatch: %v", err)
+	}
+
+	// The mismatched file was discarded, so it can be sent again.
+	next, close, err := m.HashPartialDirFile("id", "d", "bad")
+	must.Do(err)
+	offset, r, err := resumeReader(strings.NewReader("good"), next)
+	must.Do(err)
+	must.Do(close())
+	if offset != 0 {
+		t.Errorf("resume offset of mismatched file = %d; want 0", offset)
+	}
+	must.Get(m.PutDirFile("id", "d", "bad", r, offset, -1))
+	must.Get(m.CommitDir("id", "d"))
+	if got := must.Get(os.ReadFile(filepath.Join(dir, "d", "bad"))); !bytes.Equal(got, []byte("good")) {
+		t.Errorf("bad = %q; want %q", got, "good")
+	}
+}
@@ -10,6 +10,7 @@
 	"fmt"
 	"io"
 	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -49,6 +50,9 @@ func newExtension(logf logger.Logf, b ipnext.SafeBackend) (ipnext.Extension, err
 		logf:       logger.WithPrefix(logf, "taildrop: "),
 	}
 	e.setPlatformDefaultDirectFileRoot()
+	if runtime.GOOS == "linux" {
+		e.autoAcceptPath = autoAcceptConfigPath(b.TailscaleVarRoot())
+	}
 	return e, nil
 }
 
@@ -77,6 +81,19 @@ type Extension struct {
 	// This is currently being used for Android to use the Storage Access Framework.
 	FileOps FileOps
 
+	// outboxRoot is the directory holding the outbox of each profile, or
+	// empty if there is no state directory to store them in.
+	outboxRoot string
+	// outboxTr is the transport outboxes reach peers' PeerAPI with.
+	outboxTr http.RoundTripper
+	// outbox is the queue of files waiting to be sent by the current
+	// profile, or nil if there is none. The mutex is held to write it.
+	outbox atomic.Pointer[outbox]
+
+	// autoAcceptPath is the path of the auto-accept rules configuration
+	// file, or empty if auto-accept rules are not supported.
+	autoAcceptPath string
+
 	nodeBackendForTest ipnext.NodeBackend // if non-nil, pretend we're this node state for tests
 
 	mu             sync.Mutex // Lock order: lb.mu > e.mu
@@ -128,6 +145,12 @@ func (e *Extension) Init(h ipnext.Host) error {
 	h.Hooks().SetPeerStatus.Add(e.setPeerStatus)
 	h.Hooks().BackendStateChange.Add(e.onBackendStateChange)
 
+	varRoot := e.sb.TailscaleVarRoot()
+	if dialer, ok := e.sb.Sys().Dialer.GetOK(); varRoot != "" && ok {
+		e.outboxRoot = filepath.Join(varRoot, outboxDirName)
+		e.outboxTr = dialer.PeerAPITransport()
+	}
+
 	// TODO(nickkhyl): remove this after the profileManager refactoring.
 	// See tailscale/tailscale#15974.
 	profile, prefs := h.Profiles().CurrentProfileState()
@@ -135,6 +158,51 @@ func (e *Extension) Init(h ipnext.Host) error {
 	return nil
 }
 
+// setOutboxLocked switches to the outbox of the profile with the given ID,
+// or to none if id is empty. e.mu must be held.
+func (e *Extension) setOutboxLocked(id ipn.ProfileID) {
+	var dir string
+	if e.outboxRoot != "" && id != "" && filepath.IsLocal(string(id)) {
+		dir = filepath.Join(e.outboxRoot, string(id))
+	}
+	old := e.outbox.Load()
+	if old != nil && old.dir == dir {
+		return
+	}
+	var ob *outbox
+	if dir != "" {
+		ob = &outbox{
+			logf:      e.logf,
+			clock:     e.sb.Clock(),
+			dir:       dir,
+			tr:        e.outboxTr,
+			targetURL: e.outboxTargetURL,
+			progress: func(f *ipn.OutgoingFile) {
+				e.updateOutgoingFiles(map[string]*ipn.OutgoingFile{f.ID: f})
+			},
+		}
+		ob.start()
+	}
+	e.outbox.Store(ob)
+	// The send loop of the old outbox may be waiting for locks held by our
+	// caller, so don't wait for it to stop.
+	go old.Shutdown() // no-op on nil receiver
+}
+
+// outboxTargetURL returns the PeerAPI base URL of the peer with the given ID
+// if it's currently a reachable Taildrop target, or the empty string if not.
+func (e *Extension) outboxTargetURL(id tailcfg.StableNodeID) string {
+	nb := e.nodeBackend()
+	if nb == nil {
+		return ""
+	}
+	p, ok := nb.PeerByStableID(id)
+	if !ok || e.taildropTargetStatus(p, nb) != ipnstate.TaildropTargetAvailable {
+		return ""
+	}
+	return nb.PeerAPIBase(p)
+}
+
 func (e *Extension) onBackendStateChange(st ipn.State) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -168,9 +236,11 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 
 	if uid == 0 {
 		e.setMgrLocked(nil)
+		e.setOutboxLocked("")
 		e.outgoingFiles = nil
 		return
 	}
+	e.setOutboxLocked(profile.ID())
 
 	if sameNode && e.manager() != nil {
 		return
@@ -185,7 +255,7 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 	if e.directFileRoot != "" && strings.HasPrefix(e.directFileRoot, SafDirectoryPrefix) {
 		mode = PutModeAndroidSAF
 	}
-	e.setMgrLocked(managerOptions{
+	opts := managerOptions{
 		Logf:           e.logf,
 		Clock:          tstime.DefaultClock{Clock: e.sb.Clock()},
 		State:          e.stateStore,
@@ -194,7 +264,11 @@ func (e *Extension) onChangeProfile(profile ipn.LoginProfileView, _ ipn.PrefsVie
 		FileOps:        e.FileOps,
 		Mode:           mode,
 		SendFileNotify: e.sendFileNotify,
-	}.New())
+	}
+	if e.autoAcceptPath != "" && !isDirectFileMode {
+		opts.OnReceived = e.onReceived
+	}
+	e.setMgrLocked(opts.New())
 }
 
 // fileRoot returns where to store Taildrop files for the given user and whether
@@ -249,7 +323,8 @@ func (e *Extension) Clock() tstime.Clock {
 }
 
 func (e *Extension) Shutdown() error {
-	e.manager().Shutdown() // no-op on nil receiver
+	e.manager().Shutdown()     // no-op on nil receiver
+	e.outbox.Load().Shutdown() // likewise
 	return nil
 }
 
@@ -342,6 +417,10 @@ func (e *Extension) OpenFile(name string) (rc io.ReadCloser, size int64, err err
 	return e.manager().OpenFile(name)
 }
 
+func (e *Extension) OpenDir(name string) (io.ReadCloser, error) {
+	return e.manager().OpenDir(name)
+}
+
 func (e *Extension) nodeBackend() ipnext.NodeBackend {
 	if e.nodeBackendForTest != nil {
 		return e.nodeBackendForTest
@@ -7,6 +7,7 @@
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
@@ -17,6 +18,8 @@
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
@@ -24,6 +27,7 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/localapi"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/httphdr"
 	"tailscale.com/util/mak"
@@ -33,12 +37,16 @@
 
 func init() {
 	localapi.Register("file-put/", serveFilePut)
+	localapi.Register("file-put-dir/", serveFilePutDir)
+	localapi.Register("file-queue/", serveFileQueue)
 	localapi.Register("files/", serveFiles)
 	localapi.Register("file-targets", serveFileTargets)
 }
 
 var (
-	metricFilePutCalls = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutCalls    = clientmetric.NewCounter("localapi_file_put")
+	metricFilePutDirCalls = clientmetric.NewCounter("localapi_file_put_dir")
+	metricFileQueueCalls  = clientmetric.NewCounter("localapi_file_queue")
 )
 
 // serveFilePut sends a file to another node.
@@ -110,20 +118,8 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 	peerID := tailcfg.StableNodeID(peerIDStr)
 
-	var ft *apitype.FileTarget
-	for _, x := range fts {
-		if x.Node.StableID == peerID {
-			ft = x
-			break
-		}
-	}
-	if ft == nil {
-		http.Error(w, "node not found", http.StatusNotFound)
-		return
-	}
-	dstURL, err := url.Parse(ft.PeerAPIURL)
-	if err != nil {
-		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+	dstURL, ok := fileTargetURL(w, fts, peerID)
+	if !ok {
 		return
 	}
 
@@ -166,6 +162,29 @@ func serveFilePut(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// fileTargetURL returns the PeerAPI URL of the file target in fts with the
+// given ID. If there is no such target, it writes an error response to w and
+// returns false.
+func fileTargetURL(w http.ResponseWriter, fts []*apitype.FileTarget, peerID tailcfg.StableNodeID) (_ *url.URL, ok bool) {
+	var ft *apitype.FileTarget
+	for _, x := range fts {
+		if x.Node.StableID == peerID {
+			ft = x
+			break
+		}
+	}
+	if ft == nil {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return nil, false
+	}
+	dstURL, err := url.Parse(ft.PeerAPIURL)
+	if err != nil {
+		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
+		return nil, false
+	}
+	return dstURL, true
+}
+
 func multiFilePost(h *localapi.Handler, progressUpdates chan (ipn.OutgoingFile), w http.ResponseWriter, r *http.Request, peerID tailcfg.StableNodeID, dstURL *url.URL) {
 	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
@@ -292,42 +311,12 @@ func singleFilePut(
 	// Before we PUT a file we check to see if there are any existing partial file and if so,
 	// we resume the upload from where we left off by sending the remaining file instead of
 	// the full file.
-	var offset int64
-	var resumeDuration time.Duration
-	remainingBody := io.Reader(body)
-	client := &http.Client{
-		Transport: h.LocalBackend().Dialer().PeerAPITransport(),
-		Timeout:   10 * time.Second,
-	}
-	req, err := http.NewRequestWithContext(ctx, "GET", dstURL.String()+"/v0/put/"+outgoingFile.Name, nil)
+	offset, remainingBody, resumeDuration, err := resumeBody(ctx, h.Logf, h.LocalBackend().Dialer().PeerAPITransport(), dstURL.String()+"/v0/put/"+outgoingFile.Name, body)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
 		fail()
 		return false
 	}
-	resp, err := client.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
-	switch {
-	case err != nil:
-		h.Logf("could not fetch remote hashes: %v", err)
-	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotFound:
-		// noop; implies older peerapi without resume support
-	case resp.StatusCode != http.StatusOK:
-		h.Logf("fetch remote hashes status code: %d", resp.StatusCode)
-	default:
-		resumeStart := time.Now()
-		dec := json.NewDecoder(resp.Body)
-		offset, remainingBody, err = resumeReader(body, func() (out blockChecksum, err error) {
-			err = dec.Decode(&out)
-			return out, err
-		})
-		if err != nil {
-			h.Logf("reader could not be fully resumed: %v", err)
-		}
-		resumeDuration = time.Since(resumeStart).Round(time.Millisecond)
-	}
 
 	outReq, err := http.NewRequestWithContext(ctx, "PUT", "http://peer/v0/put/"+outgoingFile.Name, remainingBody)
 	if err != nil {
@@ -356,6 +345,361 @@ func singleFilePut(
 	return true
 }
 
+// serveFilePutDir sends a directory to another node.
+//
+// The request is a multipart/form-data POST. The first part is the
+// application/json encoded [apitype.TaildropManifest] of the directory,
+// followed by a part for each regular file in the manifest, in order, with
+// the file's path as the form name.
+//
+// The directory is sent to the peer's /v0/put-dir/ PeerAPI endpoint, resuming
+// any files the peer already received partially.
+//
+// URL format:
+//
+//   - POST /localapi/v0/file-put-dir/:stableID/:escaped-dirname
+func serveFilePutDir(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	metricFilePutDirCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST to put directory", http.StatusBadRequest)
+		return
+	}
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+	fts, err := ext.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	peerIDStr, nameEscaped, ok := strings.Cut(upath, "/")
+	if !ok {
+		http.Error(w, "bogus URL", http.StatusBadRequest)
+		return
+	}
+	name, err := url.PathUnescape(nameEscaped)
+	if err != nil || !validManifestPath(name) || strings.Contains(name, "/") {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	peerID := tailcfg.StableNodeID(peerIDStr)
+	dstURL, ok := fileTargetURL(w, fts, peerID)
+	if !ok {
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Content-Type for multipart POST: %s", err), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode multipart/form-data: %s", err), http.StatusBadRequest)
+		return
+	}
+	if part.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "first MIME part must be a JSON manifest", http.StatusBadRequest)
+		return
+	}
+	var man apitype.TaildropManifest
+	if err := json.NewDecoder(io.LimitReader(part, MaxManifestSize)).Decode(&man); err != nil {
+		http.Error(w, fmt.Sprintf("invalid manifest: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateManifest(&man); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Report progress of the directory as a single outgoing file.
+	file := &ipn.OutgoingFile{
+		ID:      rands.HexString(30),
+		PeerID:  peerID,
+		Name:    name,
+		Started: time.Now(),
+	}
+	for _, e := range man.Files {
+		file.DeclaredSize += e.Size
+	}
+	var mu sync.Mutex // guards file
+	update := func(f func(*ipn.OutgoingFile)) {
+		mu.Lock()
+		f(file)
+		u := *file
+		mu.Unlock()
+		ext.updateOutgoingFiles(map[string]*ipn.OutgoingFile{u.ID: &u})
+	}
+	update(func(*ipn.OutgoingFile) {}) // report the transfer as started
+
+	s := &dirSender{
+		logf:    h.Logf,
+		tr:      h.LocalBackend().Dialer().PeerAPITransport(),
+		baseURL: dstURL.String() + "/v0/put-dir/" + url.PathEscape(name),
+		progress: func(sent int64) {
+			update(func(f *ipn.OutgoingFile) { f.Sent = sent })
+		},
+	}
+	err = s.send(r.Context(), &man, mr)
+	update(func(f *ipn.OutgoingFile) {
+		f.Finished = true
+		f.Succeeded = err == nil
+	})
+	if err != nil {
+		h.Logf("put of directory failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	io.WriteString(w, "{}\n")
+}
+
+// dirSender sends a directory to a peer's /v0/put-dir/ PeerAPI endpoint.
+type dirSender struct {
+	logf     logger.Logf
+	tr       http.RoundTripper
+	baseURL  string           // URL of the directory on the peer
+	progress func(sent int64) // called with the total bytes sent so far
+
+	sent atomic.Int64
+}
+
+// send sends the directory described by man, with the contents of the
+// regular files read from the parts of mr in order.
+func (s *dirSender) send(ctx context.Context, man *apitype.TaildropManifest, mr *multipart.Reader) error {
+	manJSON, err := json.Marshal(man)
+	if err != nil {
+		return err
+	}
+	if err := s.do(ctx, "PUT", s.baseURL, bytes.NewReader(manJSON), int64(len(manJSON)), 0); err != nil {
+		var he httpError
+		if errors.As(err, &he) && (he.code == http.StatusNotFound || he.code == http.StatusMethodNotAllowed) {
+			return errors.New("target does not support receiving directories")
+		}
+		return err
+	}
+	for _, e := range man.Files {
+		if !e.Mode.IsRegular() {
+			continue
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			return fmt.Errorf("reading %q from request: %w", e.Path, err)
+		}
+		if part.FormName() != e.Path {
+			return fmt.Errorf("got part %q; want %q", part.FormName(), e.Path)
+		}
+		if err := s.sendFile(ctx, e, part); err != nil {
+			return fmt.Errorf("sending %q: %w", e.Path, err)
+		}
+	}
+	return s.do(ctx, "POST", s.baseURL, nil, 0, 0)
+}
+
+// sendFile sends the file e with contents r, resuming it if the peer already
+// has part of it.
+func (s *dirSender) sendFile(ctx context.Context, e apitype.TaildropManifestEntry, r io.Reader) error {
+	fileURL := s.baseURL + "/" + url.PathEscape(e.Path)
+	start := s.sent.Load()
+	r = progresstracking.NewReader(r, time.Second, func(n int, _ error) {
+		s.progress(start + int64(n))
+	})
+	offset, rest, d, err := resumeBody(ctx, s.logf, s.tr, fileURL, r)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		s.logf("resuming put at offset %d after %v", offset, d)
+	}
+	if err := s.do(ctx, "PUT", fileURL, rest, e.Size-offset, offset); err != nil {
+		return err
+	}
+	s.sent.Add(e.Size)
+	s.progress(s.sent.Load())
+	return nil
+}
+
+// httpError is a non-200 response from the peer.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e httpError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// do sends a request to the peer, with a Range header starting at offset if
+// non-zero, and returns an error if it doesn't succeed.
+func (s *dirSender) do(ctx context.Context, method, reqURL string, body io.Reader, size, offset int64) error {
+	return doPeerRequest(ctx, s.tr, method, reqURL, body, size, offset)
+}
+
+// doPeerRequest sends a request to a peer's PeerAPI using tr, with a Range
+// header starting at offset if non-zero. It returns an [httpError] if the
+// response status isn't 200.
+func doPeerRequest(ctx context.Context, tr http.RoundTripper, method, reqURL string, body io.Reader, size, offset int64) error {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if offset > 0 {
+		rangeHdr, _ := httphdr.FormatRange([]httphdr.Range{{Start: offset, Length: 0}})
+		req.Header.Set("Range", rangeHdr)
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return httpError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// resumeBody fetches the block hashes of a partially received file from
+// hashURL on the peer and skips the leading part of body that the peer
+// already has. It returns the offset to resume sending at, the remainder of
+// body, and how long it took. If the peer has no partial file or doesn't
+// support resuming, the offset is zero and rest is body.
+func resumeBody(ctx context.Context, logf logger.Logf, tr http.RoundTripper, hashURL string, body io.Reader) (offset int64, rest io.Reader, d time.Duration, err error) {
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   10 * time.Second,
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", hashURL, nil)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	rest = body
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	switch {
+	case err != nil:
+		logf("could not fetch remote hashes: %v", err)
+	case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotFound:
+		// noop; implies older peerapi without resume support
+	case resp.StatusCode != http.StatusOK:
+		logf("fetch remote hashes status code: %d", resp.StatusCode)
+	default:
+		resumeStart := time.Now()
+		dec := json.NewDecoder(resp.Body)
+		offset, rest, err = resumeReader(body, func() (out blockChecksum, err error) {
+			err = dec.Decode(&out)
+			return out, err
+		})
+		if err != nil {
+			logf("reader could not be fully resumed: %v", err)
+		}
+		d = time.Since(resumeStart).Round(time.Millisecond)
+	}
+	return offset, rest, d, nil
+}
+
+// serveFileQueue manages the outbox of files waiting to be sent to nodes
+// once they're online.
+//
+// URL format:
+//
+//   - GET /localapi/v0/file-queue/ lists the queued files.
+//   - PUT /localapi/v0/file-queue/:stableID/:escaped-filename queues a file.
+//   - DELETE /localapi/v0/file-queue/:id cancels sending a queued file.
+func serveFileQueue(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "misconfigured taildrop extension", http.StatusInternalServerError)
+		return
+	}
+	ob := ext.outbox.Load()
+	if ob == nil {
+		http.Error(w, "no outbox; tailscaled has no state directory", http.StatusNotImplemented)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-queue/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if suffix != "" {
+			http.Error(w, "bogus URL", http.StatusBadRequest)
+			return
+		}
+		files, err := ob.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mak.NonNilSliceForJSON(&files)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(files)
+	case "PUT":
+		metricFileQueueCalls.Add(1)
+		peerIDStr, nameEscaped, ok := strings.Cut(suffix, "/")
+		if !ok || peerIDStr == "" {
+			http.Error(w, "bogus URL", http.StatusBadRequest)
+			return
+		}
+		name, err := url.PathUnescape(nameEscaped)
+		if err != nil {
+			http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+			return
+		}
+		qf, err := ob.Add(tailcfg.StableNodeID(peerIDStr), name, r.Body)
+		if errors.Is(err, ErrInvalidFileName) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			h.Logf("queueing file failed: %v", redactError(err))
+			http.Error(w, redactError(err).Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(qf)
+	case "DELETE":
+		id, err := url.PathUnescape(suffix)
+		if err != nil {
+			http.Error(w, "bad ID", http.StatusBadRequest)
+			return
+		}
+		err = ob.Cancel(id)
+		if errors.Is(err, ErrNotQueued) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, redactError(err).Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "want GET, PUT or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
 func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "file access denied", http.StatusForbidden)
@@ -420,6 +764,17 @@ func serveFiles(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
+	if r.FormValue("format") == "tar" {
+		rc, err := ext.OpenDir(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		w.Header().Set("Content-Type", "application/x-tar")
+		io.Copy(w, rc)
+		return
+	}
 	rc, size, err := ext.OpenFile(name)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,374 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/progresstracking"
+	"tailscale.com/util/rands"
+	"tailscale.com/util/set"
+)
+
+// outboxDirName is the name of the directory within the tailscaled state
+// directory that holds the outbox of each profile, in a subdirectory named
+// after the profile ID.
+const outboxDirName = "taildrop-outbox"
+
+const (
+	// outboxPollInterval is how often the outbox checks whether the
+	// targets of queued files came online.
+	outboxPollInterval = 10 * time.Second
+
+	// outboxMaxRetryDelay is the maximum time to wait before retrying a
+	// failed send to an online target.
+	outboxMaxRetryDelay = 30 * time.Minute
+)
+
+// ErrNotQueued is returned when canceling a file that isn't in the outbox.
+var ErrNotQueued = errors.New("file not in outbox")
+
+// outbox is a durable queue of files to send to peers.
+//
+// Each queued file is stored in dir as <id>.data, with an <id>.json
+// [apitype.QueuedFile] describing it. Queued files are sent in the
+// background once their target is online, resuming any part of the file the
+// target already received, and removed from the outbox once sent.
+type outbox struct {
+	logf  logger.Logf
+	clock tstime.Clock
+	dir   string
+
+	// tr is the transport to reach peers' PeerAPI with.
+	tr http.RoundTripper
+
+	// targetURL returns the PeerAPI base URL of the peer with the given ID
+	// if it's online and can receive files, or the empty string if not.
+	targetURL func(tailcfg.StableNodeID) string
+
+	// progress is called to report the progress of sends.
+	progress func(*ipn.OutgoingFile)
+
+	kick     chan struct{} // wakes up the send loop; buffered
+	ctx      context.Context
+	shutdown context.CancelFunc
+	group    syncs.WaitGroup
+
+	// offline is the set of peers that were offline when last checked.
+	// It's only accessed by the send loop.
+	offline set.Set[tailcfg.StableNodeID]
+
+	mu         sync.Mutex
+	sendingID  string             // ID of the file being sent, if any
+	cancelSend context.CancelFunc // cancels the send of sendingID
+}
+
+// start starts sending queued files in the background. It must be called
+// before any other method.
+func (o *outbox) start() {
+	o.removeIncomplete()
+	o.kick = make(chan struct{}, 1)
+	o.ctx, o.shutdown = context.WithCancel(context.Background())
+	o.group.Go(o.run)
+}
+
+// Shutdown stops sending files and waits for the send loop to stop.
+func (o *outbox) Shutdown() {
+	if o == nil {
+		return
+	}
+	o.shutdown()
+	o.group.Wait()
+}
+
+func (o *outbox) wake() {
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (o *outbox) dataPath(id string) string { return filepath.Join(o.dir, id+".data") }
+func (o *outbox) metaPath(id string) string { return filepath.Join(o.dir, id+".json") }
+
+// validQueueID reports whether id is a valid outbox entry ID.
+func validQueueID(id string) bool {
+	return id != "" && len(id) <= 64 && strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// Add adds the file name with contents r to the outbox, to be sent to peer.
+func (o *outbox) Add(peer tailcfg.StableNodeID, name string, r io.Reader) (*apitype.QueuedFile, error) {
+	if _, err := joinDir(o.dir, name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(o.dir, 0700); err != nil {
+		return nil, err
+	}
+	qf := &apitype.QueuedFile{
+		ID:      rands.HexString(32),
+		PeerID:  peer,
+		Name:    name,
+		Created: o.clock.Now(),
+	}
+	tmp := o.dataPath(qf.ID) + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*apitype.QueuedFile, error) {
+		os.Remove(tmp)
+		os.Remove(o.dataPath(qf.ID))
+		os.Remove(o.metaPath(qf.ID))
+		return nil, err
+	}
+	qf.Size, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, o.dataPath(qf.ID)); err != nil {
+		return fail(err)
+	}
+	if err := o.writeMeta(qf); err != nil {
+		return fail(err)
+	}
+	o.wake()
+	return qf, nil
+}
+
+func (o *outbox) writeMeta(qf *apitype.QueuedFile) error {
+	b, err := json.Marshal(qf)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(o.metaPath(qf.ID), b, 0600)
+}
+
+// List returns the queued files, oldest first.
+func (o *outbox) List() ([]*apitype.QueuedFile, error) {
+	des, err := os.ReadDir(o.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret []*apitype.QueuedFile
+	for _, de := range des {
+		id, ok := strings.CutSuffix(de.Name(), ".json")
+		if !ok || !validQueueID(id) {
+			continue
+		}
+		b, err := os.ReadFile(o.metaPath(id))
+		if err != nil {
+			continue // canceled concurrently
+		}
+		qf := new(apitype.QueuedFile)
+		if err := json.Unmarshal(b, qf); err != nil || qf.ID != id {
+			o.logf("outbox: ignoring invalid entry %s: %v", de.Name(), err)
+			continue
+		}
+		ret = append(ret, qf)
+	}
+	slices.SortFunc(ret, func(a, b *apitype.QueuedFile) int {
+		return cmp.Or(a.Created.Compare(b.Created), strings.Compare(a.ID, b.ID))
+	})
+	return ret, nil
+}
+
+// Cancel removes the file with the given ID from the outbox, stopping its
+// send if in progress. It returns ErrNotQueued if there is no such file.
+func (o *outbox) Cancel(id string) error {
+	if !validQueueID(id) {
+		return ErrNotQueued
+	}
+	o.mu.Lock()
+	if o.sendingID == id {
+		o.cancelSend()
+	}
+	o.mu.Unlock()
+	err := os.Remove(o.metaPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotQueued
+	}
+	if err != nil {
+		return err
+	}
+	// If this fails, such as on Windows while the file is still being
+	// sent, the data is removed by removeIncomplete on the next start.
+	os.Remove(o.dataPath(id))
+	return nil
+}
+
+// run is the send loop. It sends queued files whose targets are online
+// whenever a file is added, and every outboxPollInterval.
+func (o *outbox) run() {
+	for {
+		o.sendReady()
+		tc, ch := o.clock.NewTimer(outboxPollInterval)
+		select {
+		case <-o.ctx.Done():
+			tc.Stop()
+			return
+		case <-o.kick:
+		case <-ch:
+		}
+		tc.Stop()
+	}
+}
+
+// removeIncomplete removes the data of files that were being added, or
+// canceled, when tailscaled last stopped.
+func (o *outbox) removeIncomplete() {
+	des, _ := os.ReadDir(o.dir)
+	for _, de := range des {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(o.dir, name))
+		} else if id, ok := strings.CutSuffix(name, ".data"); ok {
+			if _, err := os.Stat(o.metaPath(id)); errors.Is(err, fs.ErrNotExist) {
+				os.Remove(filepath.Join(o.dir, name))
+			}
+		}
+	}
+}
+
+// retryDelay returns how long to wait before retrying a file that failed to
+// send attempts times in a row.
+func retryDelay(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+	d := 30 * time.Second << min(attempts-1, 10)
+	return min(d, outboxMaxRetryDelay)
+}
+
+// sendReady sends the queued files whose targets are online, one at a time.
+func (o *outbox) sendReady() {
+	files, err := o.List()
+	if err != nil {
+		o.logf("outbox: %v", err)
+		return
+	}
+	wasOffline := o.offline
+	o.offline = make(set.Set[tailcfg.StableNodeID])
+	for _, qf := range files {
+		if o.ctx.Err() != nil {
+			return
+		}
+		base := o.targetURL(qf.PeerID)
+		if base == "" {
+			o.offline.Add(qf.PeerID) // wait for it to come online
+			continue
+		}
+		// Back off from peers that are online but failing, unless they
+		// just came online.
+		if !wasOffline.Contains(qf.PeerID) && !qf.LastAttempt.IsZero() && o.clock.Since(qf.LastAttempt) < retryDelay(qf.Attempts) {
+			continue
+		}
+		err := o.send(qf, base)
+		if errors.Is(err, context.Canceled) {
+			continue // canceled or shutting down
+		}
+		if err == nil {
+			os.Remove(o.metaPath(qf.ID))
+			os.Remove(o.dataPath(qf.ID))
+			continue
+		}
+		o.logf("outbox: sending file to %v failed (attempt %d): %v", qf.PeerID, qf.Attempts+1, err)
+		qf.Attempts++
+		qf.LastAttempt = o.clock.Now()
+		qf.LastError = err.Error()
+		if _, err := os.Stat(o.metaPath(qf.ID)); err == nil {
+			if err := o.writeMeta(qf); err != nil {
+				o.logf("outbox: %v", err)
+			}
+		}
+	}
+}
+
+// send sends qf to the peer whose PeerAPI is at base, resuming any partial
+// file the peer has.
+func (o *outbox) send(qf *apitype.QueuedFile, base string) (err error) {
+	ctx, cancel := context.WithCancel(o.ctx)
+	defer cancel()
+	o.mu.Lock()
+	o.sendingID, o.cancelSend = qf.ID, cancel
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		o.sendingID, o.cancelSend = "", nil
+		o.mu.Unlock()
+	}()
+
+	f, err := os.Open(o.dataPath(qf.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out := &ipn.OutgoingFile{
+		ID:           qf.ID,
+		PeerID:       qf.PeerID,
+		Name:         qf.Name,
+		Started:      o.clock.Now(),
+		DeclaredSize: qf.Size,
+	}
+	var outMu sync.Mutex // guards out
+	update := func(f func(*ipn.OutgoingFile)) {
+		outMu.Lock()
+		f(out)
+		u := *out
+		outMu.Unlock()
+		o.progress(&u)
+	}
+	defer update(func(out *ipn.OutgoingFile) {
+		out.Finished = true
+		out.Succeeded = err == nil
+	})
+	body := progresstracking.NewReader(f, time.Second, func(n int, _ error) {
+		update(func(out *ipn.OutgoingFile) { out.Sent = int64(n) })
+	})
+
+	fileURL := base + "/v0/put/" + url.PathEscape(qf.Name)
+	offset, rest, d, err := resumeBody(ctx, o.logf, o.tr, fileURL, body)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		o.logf("outbox: resuming put at offset %d after %v", offset, d)
+	}
+	if err := doPeerRequest(ctx, o.tr, "PUT", fileURL, rest, qf.Size-offset, offset); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", context.Canceled, err)
+		}
+		return err
+	}
+	update(func(out *ipn.OutgoingFile) { out.Sent = qf.Size })
+	return nil
+}
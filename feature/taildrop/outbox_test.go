@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/util/must"
+)
+
+// outboxTestEnv is an outbox sending to a peer that receives files with the
+// PeerAPI handlers.
+type outboxTestEnv struct {
+	ob     *outbox
+	recv   *manager
+	online atomic.Bool
+	fail   atomic.Bool // whether the peer fails requests
+
+	mu       sync.Mutex
+	ranges   []string            // Range headers of PUT requests
+	progress []*ipn.OutgoingFile // progress reports
+}
+
+func newOutboxTestEnv(t *testing.T, dir string) *outboxTestEnv {
+	env := &outboxTestEnv{
+		recv: managerOptions{Logf: t.Logf, Dir: t.TempDir()}.New(),
+	}
+	t.Cleanup(env.recv.Shutdown)
+	ph := &peerAPIHandler{
+		isSelf: true,
+		peerNode: (&tailcfg.Node{
+			StableID:     "nSENDER",
+			ComputedName: "sender",
+		}).View(),
+		selfNode: (&tailcfg.Node{
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")},
+		}).View(),
+	}
+	ext := &fakeExtension{
+		logf:           t.Logf,
+		capFileSharing: true,
+		clock:          &tstest.Clock{},
+		taildrop:       env.recv,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.fail.Load() {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == "PUT" {
+			env.mu.Lock()
+			env.ranges = append(env.ranges, r.Header.Get("Range"))
+			env.mu.Unlock()
+		}
+		handlePeerPutWithBackend(ph, ext, w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	env.ob = &outbox{
+		logf:  t.Logf,
+		clock: tstime.StdClock{},
+		dir:   dir,
+		tr:    srv.Client().Transport,
+		targetURL: func(id tailcfg.StableNodeID) string {
+			if id == "nPEER" && env.online.Load() {
+				return srv.URL
+			}
+			return ""
+		},
+		progress: func(f *ipn.OutgoingFile) {
+			env.mu.Lock()
+			defer env.mu.Unlock()
+			env.progress = append(env.progress, f)
+		},
+	}
+	env.ob.start()
+	t.Cleanup(env.ob.Shutdown)
+	return env
+}
+
+// waitQueued waits for the outbox to have n queued files, and returns them.
+func (env *outboxTestEnv) waitQueued(t *testing.T, n int, cond func([]*apitype.QueuedFile) bool) []*apitype.QueuedFile {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		files := must.Get(env.ob.List())
+		if len(files) == n && (cond == nil || cond(files)) {
+			return files
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got queued files %+v; want %d", files, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	env := newOutboxTestEnv(t, t.TempDir())
+	content := make([]byte, 3<<20)
+	rand.Read(content)
+
+	qf := must.Get(env.ob.Add("nPEER", "a.bin", bytes.NewReader(content)))
+	if qf.Size != int64(len(content)) || qf.Name != "a.bin" || qf.PeerID != "nPEER" {
+		t.Errorf("Add = %+v", qf)
+	}
+	if _, err := env.ob.Add("nPEER", "../x", bytes.NewReader(nil)); !errors.Is(err, ErrInvalidFileName) {
+		t.Errorf("Add with invalid name = %v; want ErrInvalidFileName", err)
+	}
+
+	// The peer is offline, so the file stays queued.
+	time.Sleep(50 * time.Millisecond)
+	env.waitQueued(t, 1, nil)
+
+	// Pretend the peer received the first part of the file in an earlier
+	// attempt, then bring it online.
+	partial := filepath.Join(env.recv.Dir(), "a.bin"+clientID("nSENDER").partialSuffix())
+	must.Do(os.WriteFile(partial, content[:2<<20], 0600))
+	env.online.Store(true)
+	env.ob.wake()
+	env.waitQueued(t, 0, nil)
+
+	got := must.Get(os.ReadFile(filepath.Join(env.recv.Dir(), "a.bin")))
+	if !bytes.Equal(got, content) {
+		t.Errorf("received file differs from the one sent")
+	}
+	env.mu.Lock()
+	if len(env.ranges) != 1 || env.ranges[0] == "" {
+		t.Errorf("PUT Range headers = %q; want one resuming the partial file", env.ranges)
+	}
+	if last := env.progress[len(env.progress)-1]; !last.Finished || !last.Succeeded || last.ID != qf.ID {
+		t.Errorf("last progress report = %+v; want success of %v", last, qf.ID)
+	}
+	env.mu.Unlock()
+	if des := must.Get(os.ReadDir(env.ob.dir)); len(des) != 0 {
+		t.Errorf("outbox directory has %d entries after sending; want 0", len(des))
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	env := newOutboxTestEnv(t, t.TempDir())
+	env.online.Store(true)
+	env.fail.Store(true)
+	must.Get(env.ob.Add("nPEER", "a.txt", bytes.NewReader([]byte("hello"))))
+	files := env.waitQueued(t, 1, func(files []*apitype.QueuedFile) bool {
+		return files[0].Attempts == 1
+	})
+	if files[0].LastError == "" || files[0].LastAttempt.IsZero() {
+		t.Errorf("failed file = %+v; want LastError and LastAttempt set", files[0])
+	}
+
+	// Failed files are retried right away when the peer comes back online.
+	env.fail.Store(false)
+	env.online.Store(false)
+	env.ob.wake()
+	time.Sleep(50 * time.Millisecond)
+	env.online.Store(true)
+	env.ob.wake()
+	env.waitQueued(t, 0, nil)
+	if got := must.Get(os.ReadFile(filepath.Join(env.recv.Dir(), "a.txt"))); string(got) != "hello" {
+		t.Errorf("received %q; want %q", got, "hello")
+	}
+}
+
+func TestOutboxCancel(t *testing.T) {
+	dir := t.TempDir()
+	env := newOutboxTestEnv(t, dir)
+	a := must.Get(env.ob.Add("nPEER", "a.txt", bytes.NewReader([]byte("a"))))
+	must.Get(env.ob.Add("nPEER", "b.txt", bytes.NewReader([]byte("b"))))
+
+	must.Do(env.ob.Cancel(a.ID))
+	for _, id := range []string{a.ID, "0123", "../x"} {
+		if err := env.ob.Cancel(id); !errors.Is(err, ErrNotQueued) {
+			t.Errorf("Cancel(%q) = %v; want ErrNotQueued", id, err)
+		}
+	}
+	files := env.waitQueued(t, 1, nil)
+	if files[0].Name != "b.txt" {
+		t.Errorf("queued file = %+v; want b.txt", files[0])
+	}
+
+	// The outbox survives restarts, and incomplete files are removed.
+	env.ob.Shutdown()
+	must.Do(os.WriteFile(filepath.Join(dir, "0123.data.tmp"), nil, 0600))
+	env2 := newOutboxTestEnv(t, dir)
+	env2.waitQueued(t, 1, nil)
+	env2.online.Store(true)
+	env2.ob.wake()
+	env2.waitQueued(t, 0, nil)
+	if des := must.Get(os.ReadDir(dir)); len(des) != 0 {
+		t.Errorf("outbox directory has %d entries after sending; want 0", len(des))
+	}
+}
+
+func TestOutboxAddCopyFails(t *testing.T) {
+	env := newOutboxTestEnv(t, t.TempDir())
+	r := io.MultiReader(bytes.NewReader([]byte("partial")), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := env.ob.Add("nPEER", "a.txt", r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Add = %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+	if files := must.Get(env.ob.List()); len(files) != 0 {
+		t.Errorf("queued files = %+v; want none", files)
+	}
+	if des := must.Get(os.ReadDir(env.ob.dir)); len(des) != 0 {
+		t.Errorf("outbox directory has %d entries after failed Add; want 0", len(des))
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@
 	"strings"
 	"time"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -21,10 +23,12 @@
 
 func init() {
 	ipnlocal.RegisterPeerAPIHandler("/v0/put/", handlePeerPut)
+	ipnlocal.RegisterPeerAPIHandler("/v0/put-dir/", handlePeerPutDir)
 }
 
 var (
-	metricPutCalls = clientmetric.NewCounter("peerapi_put")
+	metricPutCalls    = clientmetric.NewCounter("peerapi_put")
+	metricPutDirCalls = clientmetric.NewCounter("peerapi_put_dir")
 )
 
 // canPutFile reports whether h can put a file ("Taildrop") to this node.
@@ -53,24 +57,35 @@ type extensionForPut interface {
 	Clock() tstime.Clock
 }
 
-func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
-	if r.Method == "PUT" {
-		metricPutCalls.Add(1)
-	}
-
+// checkPeerPut returns the taildrop manager if the peer making the request
+// h is allowed to send files. Otherwise it writes an error response to w and
+// returns false.
+func checkPeerPut(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter) (_ *manager, ok bool) {
 	taildropMgr := ext.manager()
 	if taildropMgr == nil {
 		h.Logf("taildrop: no taildrop manager")
 		http.Error(w, "failed to get taildrop manager", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
 	if !canPutFile(h) {
 		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
-		return
+		return nil, false
 	}
 	if !ext.hasCapFileSharing() {
 		http.Error(w, ErrNoTaildrop.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	return taildropMgr, true
+}
+
+func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		metricPutCalls.Add(1)
+	}
+
+	taildropMgr, ok := checkPeerPut(h, ext, w)
+	if !ok {
 		return
 	}
 	rawPath := r.URL.EscapedPath()
@@ -158,6 +173,136 @@ func handlePeerPutWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w
 	}
 }
 
+func handlePeerPutDir(h ipnlocal.PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+	ext, ok := ipnlocal.GetExt[*Extension](h.LocalBackend())
+	if !ok {
+		http.Error(w, "miswired", http.StatusInternalServerError)
+		return
+	}
+	handlePeerPutDirWithBackend(h, ext, w, r)
+}
+
+// handlePeerPutDirWithBackend handles directory transfers. The URL formats
+// are:
+//
+//   - PUT /v0/put-dir/:escaped-dirname with a JSON manifest starts or
+//     resumes the transfer of a directory
+//   - GET /v0/put-dir/:escaped-dirname/:escaped-path streams the block
+//     hashes of a partially received file, as for /v0/put/
+//   - PUT /v0/put-dir/:escaped-dirname/:escaped-path sends a file, possibly
+//     resuming it with a Range header, as for /v0/put/
+//   - POST /v0/put-dir/:escaped-dirname completes the transfer
+//
+// The path of a file within the directory is escaped as a single path
+// segment, including its slashes.
+func handlePeerPutDirWithBackend(h ipnlocal.PeerAPIHandler, ext extensionForPut, w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		metricPutDirCalls.Add(1)
+	}
+
+	taildropMgr, ok := checkPeerPut(h, ext, w)
+	if !ok {
+		return
+	}
+	rawPath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	rawName, rawFilePath, isFile := strings.Cut(rawPath, "/")
+	baseName, err := url.PathUnescape(rawName)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	filePath, err := url.PathUnescape(rawFilePath)
+	if err != nil {
+		http.Error(w, ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	id := clientID(h.Peer().StableID())
+
+	writeErr := func(err error) {
+		switch {
+		case errors.Is(err, ErrNoTaildrop):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrInvalidManifest):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrFileExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrNoDirTransfer):
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		case errors.Is(err, ErrManifestMismatch):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	switch {
+	case !isFile && r.Method == "PUT":
+		var man apitype.TaildropManifest
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxManifestSize)).Decode(&man); err != nil {
+			http.Error(w, fmt.Sprintf("invalid manifest: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := taildropMgr.BeginDir(id, baseName, &man); err != nil {
+			writeErr(err)
+			return
+		}
+		io.WriteString(w, "{}\n")
+	case !isFile && r.Method == "POST":
+		t0 := ext.Clock().Now()
+		if _, err := taildropMgr.CommitDir(id, baseName); err != nil {
+			writeErr(err)
+			return
+		}
+		d := ext.Clock().Since(t0).Round(time.Second / 10)
+		h.Logf("got put of a directory (verified in %v) from %v/%v", d, h.RemoteAddr().Addr(), h.Peer().ComputedName)
+		io.WriteString(w, "{}\n")
+	case isFile && r.Method == "GET":
+		next, close, err := taildropMgr.HashPartialDirFile(id, baseName, filePath)
+		if err != nil {
+			writeErr(err)
+			return
+		}
+		defer close()
+		enc := json.NewEncoder(w)
+		for {
+			switch cs, err := next(); {
+			case err == io.EOF:
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				h.Logf("HashPartialDirFile.next error: %v", err)
+				return
+			default:
+				if err := enc.Encode(cs); err != nil {
+					h.Logf("json.Encoder.Encode error: %v", err)
+					return
+				}
+			}
+		}
+	case isFile && r.Method == "PUT":
+		var offset int64
+		if rangeHdr := r.Header.Get("Range"); rangeHdr != "" {
+			ranges, ok := httphdr.ParseRange(rangeHdr)
+			if !ok || len(ranges) != 1 || ranges[0].Length != 0 {
+				http.Error(w, "invalid Range header", http.StatusBadRequest)
+				return
+			}
+			offset = ranges[0].Start
+		}
+		if _, err := taildropMgr.PutDirFile(id, baseName, filePath, r.Body, offset, r.ContentLength); err != nil {
+			writeErr(err)
+			return
+		}
+		io.WriteString(w, "{}\n")
+	default:
+		http.Error(w, "expected method GET, PUT or POST", http.StatusMethodNotAllowed)
+	}
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
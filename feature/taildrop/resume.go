@@ -76,14 +76,21 @@ func (m *manager) HashPartialFile(id clientID, baseName string) (next func() (bl
 	if m == nil || m.opts.Dir == "" {
 		return nil, nil, ErrNoTaildrop
 	}
-	noopNext := func() (blockChecksum, error) { return blockChecksum{}, io.EOF }
-	noopClose := func() error { return nil }
-
 	dstFile, err := joinDir(m.opts.Dir, baseName)
 	if err != nil {
 		return nil, nil, err
 	}
-	f, err := os.Open(dstFile + id.partialSuffix())
+	return hashBlocks(dstFile + id.partialSuffix())
+}
+
+// hashBlocks is like [manager.HashPartialFile], but for the file at path.
+// It returns a function that reports io.EOF immediately if the file does
+// not exist.
+func hashBlocks(path string) (next func() (blockChecksum, error), close func() error, err error) {
+	noopNext := func() (blockChecksum, error) { return blockChecksum{}, io.EOF }
+	noopClose := func() error { return nil }
+
+	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return noopNext, noopClose, nil
@@ -6,6 +6,7 @@
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
@@ -38,7 +39,7 @@ func (m *manager) HasFilesWaiting() (has bool) {
 	// Check whether there is at least one one waiting file.
 	err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
-		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
+		if isPartialOrDeleted(name) || !(de.Type().IsRegular() || de.IsDir()) {
 			return true
 		}
 		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
@@ -70,11 +71,19 @@ func (m *manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	}
 	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
-		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
+		if isPartialOrDeleted(name) || !(de.Type().IsRegular() || de.IsDir()) {
 			return true
 		}
 		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
 		if os.IsNotExist(err) {
+			if de.IsDir() {
+				ret = append(ret, apitype.WaitingFile{
+					Name: filepath.Base(name),
+					Size: dirSize(filepath.Join(m.opts.Dir, name)),
+					Dir:  true,
+				})
+				return true
+			}
 			fi, err := de.Info()
 			if err != nil {
 				return true
@@ -108,8 +117,12 @@ func (m *manager) DeleteFile(baseName string) error {
 	var bo *backoff.Backoff
 	logf := m.opts.Logf
 	t0 := m.opts.Clock.Now()
+	remove := os.Remove
+	if fi, err := os.Lstat(path); err == nil && fi.IsDir() {
+		remove = os.RemoveAll // received with a directory transfer
+	}
 	for {
-		err := os.Remove(path)
+		err := remove(path)
 		if err != nil && !os.IsNotExist(err) {
 			err = redactError(err)
 			// Put a retry loop around deletes on Windows.
@@ -174,5 +187,9 @@ func (m *manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 		f.Close()
 		return nil, 0, redactError(err)
 	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, 0, fmt.Errorf("%q is a directory", baseName)
+	}
 	return f, fi.Size(), nil
 }
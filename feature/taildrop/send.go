@@ -74,13 +74,8 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
 func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, length int64) (int64, error) {
-	switch {
-	case m == nil || m.opts.Dir == "":
-		return 0, ErrNoTaildrop
-	case !envknob.CanTaildrop():
-		return 0, ErrNoTaildrop
-	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
-		return 0, ErrNotAccessible
+	if err := m.checkCanReceive(); err != nil {
+		return 0, err
 	}
 
 	//Compute dstPath & avoid mid‑upload deletion
@@ -125,16 +120,7 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 		}
 	}()
 
-	// Record that we have started to receive at least one file.
-	// This is used by the deleter upon a cold-start to scan the directory
-	// for any files that need to be deleted.
-	if st := m.opts.State; st != nil {
-		if b, _ := st.ReadState(ipn.TaildropReceivedKey); len(b) == 0 {
-			if werr := st.WriteState(ipn.TaildropReceivedKey, []byte{1}); werr != nil {
-				m.opts.Logf("WriteState error: %v", werr) // non-fatal error
-			}
-		}
-	}
+	m.markReceived()
 
 	// Copy the contents of the file to the writer.
 	copyLength, err := io.Copy(wc, r)
@@ -155,9 +141,9 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 	inFile.mu.Unlock()
 
 	// Finalize rename
+	var finalDst string
 	switch m.opts.Mode {
 	case PutModeDirect:
-		var finalDst string
 		finalDst, err = m.finalizeDirect(inFile, partialPath, dstPath, fileLength)
 		if err != nil {
 			return 0, m.redactAndLogError("Rename", err)
@@ -172,9 +158,25 @@ func (m *manager) PutFile(id clientID, baseName string, r io.Reader, offset, len
 
 	m.totalReceived.Add(1)
 	m.opts.SendFileNotify()
+	if finalDst != "" {
+		m.notifyReceived(id, filepath.Base(finalDst))
+	}
 	return fileLength, nil
 }
 
+// checkCanReceive reports whether m can receive files.
+func (m *manager) checkCanReceive() error {
+	switch {
+	case m == nil || m.opts.Dir == "":
+		return ErrNoTaildrop
+	case !envknob.CanTaildrop():
+		return ErrNoTaildrop
+	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
+		return ErrNotAccessible
+	}
+	return nil
+}
+
 // openWriterAndPaths opens the correct writer, seeks/truncates if needed,
 // and sets inFile.partialPath & inFile.finalPath for later cleanup/rename.
 // The caller is responsible for closing the file on completion.
@@ -190,28 +192,9 @@ func (m *manager) openWriterAndPaths(
 
 	case PutModeDirect:
 		partialPath = dstPath + id.partialSuffix()
-		f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0o666)
+		f, err := m.openAt(partialPath, offset)
 		if err != nil {
-			return nil, "", m.redactAndLogError("Create", err)
-		}
-		if offset != 0 {
-			curr, err := f.Seek(0, io.SeekEnd)
-			if err != nil {
-				f.Close()
-				return nil, "", m.redactAndLogError("Seek", err)
-			}
-			if offset < 0 || offset > curr {
-				f.Close()
-				return nil, "", m.redactAndLogError("Seek", fmt.Errorf("offset %d out of range", offset))
-			}
-			if _, err := f.Seek(offset, io.SeekStart); err != nil {
-				f.Close()
-				return nil, "", m.redactAndLogError("Seek", err)
-			}
-			if err := f.Truncate(offset); err != nil {
-				f.Close()
-				return nil, "", m.redactAndLogError("Truncate", err)
-			}
+			return nil, "", err
 		}
 		inFile.w = f
 		wc = f
@@ -247,6 +230,48 @@ func (m *manager) openWriterAndPaths(
 	}
 }
 
+// markReceived records that we have started to receive at least one file.
+// This is used by the deleter upon a cold-start to scan the directory
+// for any files that need to be deleted.
+func (m *manager) markReceived() {
+	if st := m.opts.State; st != nil {
+		if b, _ := st.ReadState(ipn.TaildropReceivedKey); len(b) == 0 {
+			if werr := st.WriteState(ipn.TaildropReceivedKey, []byte{1}); werr != nil {
+				m.opts.Logf("WriteState error: %v", werr) // non-fatal error
+			}
+		}
+	}
+}
+
+// openAt opens the partial file at path for writing at offset, creating it
+// if needed and truncating any content after offset.
+func (m *manager) openAt(path string, offset int64) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o666)
+	if err != nil {
+		return nil, m.redactAndLogError("Create", err)
+	}
+	if offset != 0 {
+		curr, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, m.redactAndLogError("Seek", err)
+		}
+		if offset < 0 || offset > curr {
+			f.Close()
+			return nil, m.redactAndLogError("Seek", fmt.Errorf("offset %d out of range", offset))
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, m.redactAndLogError("Seek", err)
+		}
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+			return nil, m.redactAndLogError("Truncate", err)
+		}
+	}
+	return f, nil
+}
+
 // finalizeDirect atomically renames or dedups the partial file, retrying
 // under new names up to 10 times. It returns the final path that succeeded.
 func (m *manager) finalizeDirect(
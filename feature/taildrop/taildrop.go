@@ -100,6 +100,11 @@ type managerOptions struct {
 	// to the function when reception completes.
 	// It is not called if nil.
 	SendFileNotify func()
+
+	// OnReceived, if non-nil, is called in a new goroutine after a file or
+	// directory was received from id and stored as name in Dir.
+	// It is only called in PutModeDirect.
+	OnReceived func(id clientID, name string)
 }
 
 // manager manages the state for receiving and managing taildropped files.
@@ -151,6 +156,14 @@ func (m *manager) Shutdown() {
 	}
 }
 
+// notifyReceived calls [managerOptions.OnReceived], if set, for a file or
+// directory received from id and stored as name.
+func (m *manager) notifyReceived(id clientID, name string) {
+	if m.opts.OnReceived != nil {
+		go m.opts.OnReceived(id, name)
+	}
+}
+
 func validFilenameRune(r rune) bool {
 	switch r {
 	case '/':
@@ -12,9 +12,11 @@
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/qos"
 )
 
 // Clone makes a deep copy of LoginProfile.
@@ -64,6 +66,8 @@ func (src *Prefs) Clone() *Prefs {
 	if dst.RelayServerPort != nil {
 		dst.RelayServerPort = ptr.To(*src.RelayServerPort)
 	}
+	dst.PathPolicy = src.PathPolicy.Clone()
+	dst.QoSPolicy = src.QoSPolicy.Clone()
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
@@ -100,6 +104,8 @@ func (src *Prefs) Clone() *Prefs {
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	RelayServerPort        *int
+	PathPolicy             *pathpolicy.Policy
+	QoSPolicy              *qos.Policy
 	AllowSingleHosts       marshalAsTrueInJSON
 	Persist                *persist.Persist
 }{})
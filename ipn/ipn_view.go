@@ -13,8 +13,10 @@
 	"tailscale.com/drive"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
+	"tailscale.com/types/qos"
 	"tailscale.com/types/views"
 )
 
@@ -169,6 +171,8 @@ func (v PrefsView) DriveShares() views.SliceView[*drive.Share, drive.ShareView]
 func (v PrefsView) RelayServerPort() views.ValuePointer[int] {
 	return views.ValuePointerOf(v.ж.RelayServerPort)
 }
+func (v PrefsView) PathPolicy() pathpolicy.PolicyView { return v.ж.PathPolicy.View() }
+func (v PrefsView) QoSPolicy() qos.PolicyView         { return v.ж.QoSPolicy.View() }
 
 func (v PrefsView) AllowSingleHosts() marshalAsTrueInJSON { return v.ж.AllowSingleHosts }
 func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.View() }
@@ -205,6 +209,8 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	NetfilterKind          string
 	DriveShares            []*drive.Share
 	RelayServerPort        *int
+	PathPolicy             *pathpolicy.Policy
+	QoSPolicy              *qos.Policy
 	AllowSingleHosts       marshalAsTrueInJSON
 	Persist                *persist.Persist
 }{})
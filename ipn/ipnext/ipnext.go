@@ -382,6 +382,12 @@ type NodeBackend interface {
 	// to the base slice and returns it.
 	AppendMatchingPeers(base []tailcfg.NodeView, pred func(tailcfg.NodeView) bool) []tailcfg.NodeView
 
+	// PeerByStableID returns the peer with the given stable ID, if any.
+	PeerByStableID(tailcfg.StableNodeID) (_ tailcfg.NodeView, ok bool)
+
+	// UserByID returns the profile of the user with the given ID, if any.
+	UserByID(tailcfg.UserID) (_ tailcfg.UserProfileView, ok bool)
+
 	// PeerCaps returns the capabilities that src has to this node.
 	PeerCaps(src netip.Addr) tailcfg.PeerCapMap
 
@@ -4,11 +4,15 @@
 package ipnlocal
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"slices"
 
 	"tailscale.com/drive"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/netmap"
@@ -19,8 +23,25 @@
 	// DriveLocalPort is the port on which the Taildrive listens for location
 	// connections on quad 100.
 	DriveLocalPort = 8080
+
+	// DriveLocalNFSPort is the port on which Taildrive listens for NFSv3
+	// connections on quad 100, if enabled with TS_DRIVE_NFS.
+	DriveLocalNFSPort = 2049
+
+	// driveCacheSettingsFile is the name of the file in the tailscaled state
+	// directory that configures how Taildrive caches the file metadata and
+	// contents of remotes, as a JSON-encoded drive.CacheSettings.
+	driveCacheSettingsFile = "drive-cache.json"
+
+	// driveCacheDir is the name of the directory in the tailscaled state
+	// directory in which Taildrive caches the contents of remotes' files.
+	driveCacheDir = "drive-cache"
 )
 
+// driveNFSEnabled reports whether Taildrive serves local NFSv3 clients in
+// addition to WebDAV clients.
+var driveNFSEnabled = envknob.RegisterBool("TS_DRIVE_NFS")
+
 // DriveSharingEnabled reports whether sharing to remote nodes via Taildrive is
 // enabled. This is currently based on checking for the drive:share node
 // attribute.
@@ -306,13 +327,21 @@ func (b *LocalBackend) updateDrivePeersLocked(nm *netmap.NetworkMap) {
 }
 
 func (b *LocalBackend) driveRemotesFromPeers(nm *netmap.NetworkMap) []*drive.Remote {
+	varRoot := b.TailscaleVarRoot()
 	driveRemotes := make([]*drive.Remote, 0, len(nm.Peers))
 	for _, p := range nm.Peers {
 		peerID := p.ID()
-		url := fmt.Sprintf("%s/%s", peerAPIBase(nm, p), taildrivePrefix[1:])
+		name := p.DisplayName(false)
+		remoteURL := fmt.Sprintf("%s/%s", peerAPIBase(nm, p), taildrivePrefix[1:])
+		var cacheDir string
+		if varRoot != "" {
+			cacheDir = filepath.Join(varRoot, driveCacheDir, url.PathEscape(name))
+		}
 		driveRemotes = append(driveRemotes, &drive.Remote{
-			Name: p.DisplayName(false),
-			URL:  url,
+			Name:     name,
+			URL:      remoteURL,
+			Cache:    b.driveCache.For(name),
+			CacheDir: cacheDir,
 			Available: func() bool {
 				// Peers are available to Taildrive if:
 				// - They are online
@@ -346,3 +375,29 @@ func (b *LocalBackend) driveRemotesFromPeers(nm *netmap.NetworkMap) []*drive.Rem
 	}
 	return driveRemotes
 }
+
+// loadDriveCacheSettings loads the Taildrive cache settings from the state
+// directory varRoot. It returns nil if there are none, so that the defaults
+// apply.
+//
+// It reads from disk, so it's only called once, by NewLocalBackend, rather
+// than on each netmap update with b.mu held.
+func (b *LocalBackend) loadDriveCacheSettings(varRoot string) *drive.CacheSettings {
+	if varRoot == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(varRoot, driveCacheSettingsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		b.logf("taildrive: reading cache settings: %v", err)
+		return nil
+	}
+	settings := new(drive.CacheSettings)
+	if err := json.Unmarshal(data, settings); err != nil {
+		b.logf("taildrive: invalid cache settings in %s: %v", driveCacheSettingsFile, err)
+		return nil
+	}
+	return settings
+}
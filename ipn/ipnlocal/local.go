@@ -26,6 +26,7 @@
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"slices"
@@ -91,6 +92,7 @@
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/types/ptr"
@@ -220,9 +222,12 @@ type LocalBackend struct {
 	// exposeRemoteWebClientAtomicBool controls whether the web client is exposed over
 	// Tailscale on port 5252.
 	exposeRemoteWebClientAtomicBool atomic.Bool // TODO(nickkhyl): move to nodeBackend
-	shutdownCalled                  bool        // if Shutdown has been called
-	debugSink                       packet.CaptureSink
-	sockstatLogger                  *sockstatlog.Logger
+	// netcheckHistory records magicsock's netcheck reports. It's set by
+	// initOnce.
+	netcheckHistory syncs.AtomicValue[*netcheck.History]
+	shutdownCalled  bool // if Shutdown has been called
+	debugSink       packet.CaptureSink
+	sockstatLogger  *sockstatlog.Logger
 
 	// getTCPHandlerForFunnelFlow returns a handler for an incoming TCP flow for
 	// the provided srcAddr and dstPort if one exists.
@@ -365,6 +370,11 @@ type LocalBackend struct {
 	// notified about.
 	lastNotifiedDriveShares *views.SliceView[*drive.Share, drive.ShareView]
 
+	// driveCache is the Taildrive cache settings loaded from the state
+	// directory, or nil to use the defaults. It's set by NewLocalBackend and
+	// read-only afterwards.
+	driveCache *drive.CacheSettings
+
 	// lastKnownHardwareAddrs is a list of the previous known hardware addrs.
 	// Previously known hwaddrs are kept to work around an issue on Windows
 	// where all addresses might disappear.
@@ -576,6 +586,14 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		b.logf("[unexpected] failed to wire up PeerAPI port for engine %T", e)
 	}
 
+	// Load the SSH host CA now, while b.mu isn't held, so that Hostinfo
+	// can advertise it without reading the key file.
+	b.loadSSHHostCA()
+
+	if _, ok := b.sys.DriveForLocal.GetOK(); ok {
+		b.driveCache = b.loadDriveCacheSettings(b.TailscaleVarRoot())
+	}
+
 	for _, component := range ipn.DebuggableComponents {
 		key := componentStateKey(component)
 		if ut, err := ipn.ReadStoreInt(pm.Store(), key); err == nil {
@@ -1118,6 +1136,9 @@ func (b *LocalBackend) Shutdown() {
 	extHost.Shutdown()
 	b.e.Close()
 	<-b.e.Done()
+	if h := b.netcheckHistory.Load(); h != nil {
+		h.Flush()
+	}
 	b.awaitNoGoroutinesInTest()
 }
 
@@ -1255,6 +1276,8 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 			ss.InNetworkMap = true
 			if hi := nm.SelfNode.Hostinfo(); hi.Valid() {
 				ss.HostName = hi.Hostname()
+				ss.SSH_HostKeys = hi.SSH_HostKeys().AsSlice()
+				ss.SSH_HostCAKeys = hi.SSH_HostCAKeys().AsSlice()
 			}
 			ss.DNSName = nm.Name
 			ss.UserID = nm.User()
@@ -1321,6 +1344,7 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 			ShareeNode:      p.Hostinfo().ShareeNode(),
 			ExitNode:        p.StableID() != "" && p.StableID() == exitNodeID,
 			SSH_HostKeys:    p.Hostinfo().SSH_HostKeys().AsSlice(),
+			SSH_HostCAKeys:  p.Hostinfo().SSH_HostCAKeys().AsSlice(),
 			Location:        p.Hostinfo().Location().AsStruct(),
 			Capabilities:    p.Capabilities().AsSlice(),
 		}
@@ -1866,6 +1890,20 @@ func applySysPolicy(prefs *ipn.Prefs, lastSuggestedExitNode tailcfg.StableNodeID
 		}
 	}
 
+	if pathPolicyJSON, _ := syspolicy.GetString(syspolicy.PathPolicy, ""); pathPolicyJSON != "" {
+		var pp pathpolicy.Policy
+		err := json.Unmarshal([]byte(pathPolicyJSON), &pp)
+		if err == nil {
+			err = pp.Validate()
+		}
+		if err != nil {
+			log.Printf("ignoring invalid %s policy: %v", syspolicy.PathPolicy, err)
+		} else if !prefs.PathPolicy.Equal(&pp) {
+			prefs.PathPolicy = &pp
+			anyChange = true
+		}
+	}
+
 	if alwaysOn, _ := syspolicy.GetBoolean(syspolicy.AlwaysOn, false); alwaysOn && !overrideAlwaysOn && !prefs.WantRunning {
 		prefs.WantRunning = true
 		anyChange = true
@@ -2190,6 +2228,7 @@ func (b *LocalBackend) getNewControlClientFuncLocked() clientGen {
 // initOnce is called on the first call to [LocalBackend.Start].
 func (b *LocalBackend) initOnce() {
 	b.extHost.Init()
+	b.initNetcheckHistory()
 }
 
 // Start applies the configuration specified in opts, and starts the
@@ -3181,6 +3220,31 @@ func (b *LocalBackend) DebugForcePreferDERP(n int) {
 	b.sys.MagicSock.Get().DebugForcePreferDERP(n)
 }
 
+// netcheckHistoryFile is the name of the file in TailscaleVarRoot that
+// recent netcheck reports are saved to.
+const netcheckHistoryFile = "netcheck-history.json"
+
+// initNetcheckHistory starts recording magicsock's netcheck reports, saving
+// them to TailscaleVarRoot if there is one.
+func (b *LocalBackend) initNetcheckHistory() {
+	var path string
+	if root := b.TailscaleVarRoot(); root != "" {
+		path = filepath.Join(root, netcheckHistoryFile)
+	}
+	h := netcheck.NewHistory(b.logf, path, 0)
+	b.netcheckHistory.Store(h)
+	b.MagicConn().SetNetcheckHistory(h)
+}
+
+// NetcheckHistory returns magicsock's recent netcheck reports, oldest first.
+func (b *LocalBackend) NetcheckHistory() []*netcheck.Report {
+	h := b.netcheckHistory.Load()
+	if h == nil {
+		return nil
+	}
+	return h.Reports()
+}
+
 // send delivers n to the connected frontend and any API watchers from
 // LocalBackend.WatchNotifications (via the LocalAPI).
 //
@@ -3621,6 +3685,7 @@ func (b *LocalBackend) setVIPServicesTCPPortsInterceptedLocked(svcPorts map[tail
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setExposeRemoteWebClientAtomicBoolLocked(p)
+	b.setPathPolicyLocked(p)
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(ipset.FalseContainsIPFunc())
@@ -4059,6 +4124,12 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkAutoUpdatePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := p.PathPolicy.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid path policy: %w", err))
+	}
+	if err := p.QoSPolicy.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid QoS policy: %w", err))
+	}
 	return multierr.New(errs...)
 }
 
@@ -4591,6 +4662,11 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 			return b.HandleQuad100Port80Conn, opts
 		case DriveLocalPort:
 			return b.handleDriveConn, opts
+		case DriveLocalNFSPort:
+			// NFS clients aren't authenticated, so only serve this machine.
+			if driveNFSEnabled() && b.isLocalIP(src.Addr()) {
+				return b.handleDriveNFSConn, opts
+			}
 		}
 	}
 
@@ -4638,6 +4714,15 @@ func (b *LocalBackend) handleDriveConn(conn net.Conn) error {
 	return fs.HandleConn(conn, conn.RemoteAddr())
 }
 
+func (b *LocalBackend) handleDriveNFSConn(conn net.Conn) error {
+	fs, ok := b.sys.DriveForLocal.GetOK()
+	if !ok || !b.DriveAccessEnabled() {
+		conn.Close()
+		return nil
+	}
+	return fs.HandleNFSConn(conn)
+}
+
 func (b *LocalBackend) peerAPIServicesLocked() (ret []tailcfg.Service) {
 	for _, pln := range b.peerAPIListeners {
 		proto := tailcfg.PeerAPI4
@@ -4913,6 +4998,7 @@ func (b *LocalBackend) authReconfig() {
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, b.sys.NetMon.Get(), b.sys.ControlKnobs(), version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
+	b.setShaping(prefs.QoSPolicy(), nm)
 
 	err = b.e.Reconfig(cfg, rcfg, dcfg)
 	if err == wgengine.ErrNoChanges {
@@ -5324,7 +5410,7 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 
 	b.metrics.advertisedRoutes.Set(float64(tsaddr.WithoutExitRoute(prefs.AdvertiseRoutes()).Len()))
 
-	var sshHostKeys []string
+	var sshHostKeys, sshHostCAKeys []string
 	if prefs.RunSSH() && envknob.CanSSHD() {
 		// TODO(bradfitz): this is called with b.mu held. Not ideal.
 		// If the filesystem gets wedged or something we could block for
@@ -5334,8 +5420,15 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 		if err != nil {
 			b.logf("warning: unable to get SSH host keys, SSH will appear as disabled for this node: %v", err)
 		}
+		if len(sshHostKeys) > 0 {
+			sshHostCAKeys, err = b.getSSHHostCAPublicStrings()
+			if err != nil {
+				b.logf("warning: unable to get SSH host CA key: %v", err)
+			}
+		}
 	}
 	hi.SSH_HostKeys = sshHostKeys
+	hi.SSH_HostCAKeys = sshHostCAKeys
 
 	hi.ServicesHash = b.vipServiceHash(b.vipServicesFromPrefsLocked(prefs))
 
@@ -5736,6 +5829,21 @@ func (b *LocalBackend) setExposeRemoteWebClientAtomicBoolLocked(prefs ipn.PrefsV
 	b.exposeRemoteWebClientAtomicBool.Store(shouldExpose)
 }
 
+// setPathPolicyLocked applies the path policy in prefs to magicsock.
+//
+// b.mu must be held.
+func (b *LocalBackend) setPathPolicyLocked(prefs ipn.PrefsView) {
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return
+	}
+	var pp pathpolicy.PolicyView
+	if prefs.Valid() {
+		pp = prefs.PathPolicy()
+	}
+	mc.SetPathPolicy(pp)
+}
+
 // ShouldHandleViaIP reports whether ip is an IPv6 address in the
 // Tailscale ULA's v6 "via" range embedding an IPv4 address to be forwarded to
 // by Tailscale.
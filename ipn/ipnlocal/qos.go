@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/pathpolicy"
+	"tailscale.com/types/qos"
+)
+
+// setShaping configures the TUN wrapper to shape and mark traffic to the
+// peers in nm according to the QoS policy p.
+func (b *LocalBackend) setShaping(p qos.PolicyView, nm *netmap.NetworkMap) {
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return
+	}
+	tunWrap.SetShaping(shapingConfig(p, nm))
+}
+
+// shapingConfig returns the TUN wrapper's shaping configuration for the QoS
+// policy p, or nil if p is invalid.
+//
+// A rule's Peers are resolved against the peers in nm, each of which gets
+// its own class, and so its own rate limit, covering its Tailscale IPs and
+// the subnet routes it's the primary router for. A rule's Routes get one
+// class between them.
+func shapingConfig(p qos.PolicyView, nm *netmap.NetworkMap) *tstun.ShapingConfig {
+	if !p.Valid() {
+		return nil
+	}
+	cfg := &tstun.ShapingConfig{MaxRate: p.MaxRate()}
+	for _, r := range p.Rules().All() {
+		class := func(dsts []netip.Prefix) tstun.ShapingClass {
+			return tstun.ShapingClass{
+				Dsts:     dsts,
+				Rate:     r.Rate(),
+				Burst:    r.Burst(),
+				DSCP:     r.DSCP(),
+				Priority: r.Priority(),
+			}
+		}
+		if r.Peers().Len() > 0 && nm != nil {
+			patterns := r.Peers().AsSlice()
+			for _, peer := range nm.Peers {
+				if !pathpolicy.NodeMatches(peer, patterns) {
+					continue
+				}
+				dsts := peer.Addresses().AsSlice()
+				for _, pfx := range peer.PrimaryRoutes().All() {
+					if !tsaddr.IsExitRoute(pfx) {
+						dsts = append(dsts, pfx)
+					}
+				}
+				cfg.Classes = append(cfg.Classes, class(dsts))
+			}
+		}
+		if r.Routes().Len() > 0 {
+			cfg.Classes = append(cfg.Classes, class(r.Routes().AsSlice()))
+		}
+	}
+	return cfg
+}
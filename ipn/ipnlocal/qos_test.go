@@ -0,0 +1,71 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/net/tstun"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/qos"
+)
+
+func TestShapingConfig(t *testing.T) {
+	pfxs := func(ss ...string) []netip.Prefix {
+		var ret []netip.Prefix
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	nm := &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				ID:            1,
+				Name:          "db1.foo.ts.net.",
+				Tags:          []string{"tag:db"},
+				Addresses:     pfxs("100.64.0.1/32", "fd7a:115c:a1e0::1/128"),
+				PrimaryRoutes: pfxs("10.1.0.0/16", "0.0.0.0/0", "::/0"),
+			}).View(),
+			(&tailcfg.Node{
+				ID:        2,
+				Name:      "db2.foo.ts.net.",
+				Tags:      []string{"tag:db"},
+				Addresses: pfxs("100.64.0.2/32"),
+			}).View(),
+			(&tailcfg.Node{
+				ID:        3,
+				Name:      "web.foo.ts.net.",
+				Addresses: pfxs("100.64.0.3/32"),
+			}).View(),
+		},
+	}
+	p := &qos.Policy{
+		MaxRate: 100e6,
+		Rules: []*qos.Rule{
+			{Peers: []string{"tag:db"}, Rate: 10e6, Priority: qos.PriorityLow},
+			{Routes: pfxs("192.168.0.0/24", "192.168.1.0/24"), Rate: 1e6, Burst: 4096, DSCP: 46},
+		},
+	}
+
+	got := shapingConfig(p.View(), nm)
+	want := &tstun.ShapingConfig{
+		MaxRate: 100e6,
+		Classes: []tstun.ShapingClass{
+			{Dsts: pfxs("100.64.0.1/32", "fd7a:115c:a1e0::1/128", "10.1.0.0/16"), Rate: 10e6, Priority: qos.PriorityLow},
+			{Dsts: pfxs("100.64.0.2/32"), Rate: 10e6, Priority: qos.PriorityLow},
+			{Dsts: pfxs("192.168.0.0/24", "192.168.1.0/24"), Rate: 1e6, Burst: 4096, DSCP: 46},
+		},
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+		t.Errorf("shapingConfig (-want +got):\n%s", diff)
+	}
+
+	if got := shapingConfig(qos.PolicyView{}, nm); got != nil {
+		t.Errorf("shapingConfig(invalid) = %+v; want nil", got)
+	}
+}
@@ -23,10 +23,13 @@
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"go4.org/mem"
 	"golang.org/x/crypto/ssh"
+	"tailscale.com/envknob"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
 	"tailscale.com/util/lineiter"
 	"tailscale.com/util/mak"
 )
@@ -36,6 +39,25 @@
 // running as root.
 var keyTypes = []string{"rsa", "ecdsa", "ed25519"}
 
+// sshHostCAKeyFile is the path to the private key of an SSH certificate
+// authority, in any format understood by ssh.ParsePrivateKey. If set, the
+// node's SSH host keys are also presented as certificates signed by it, and
+// its public key is advertised to peers so they can trust the node's host
+// keys without having to pin each of them.
+var sshHostCAKeyFile = envknob.RegisterString("TS_SSH_HOST_CA_KEY_FILE")
+
+const (
+	// sshHostCertValidity is how long SSH host certificates are valid
+	// for. Certificates are signed for each new connection, so this only
+	// needs to be long enough to complete a handshake, but is generous to
+	// tolerate clock skew between the client and this node.
+	sshHostCertValidity = 24 * time.Hour
+
+	// sshHostCertBackdate is how far in the past SSH host certificates
+	// start being valid, to tolerate clients with slow clocks.
+	sshHostCertBackdate = 5 * time.Minute
+)
+
 // getSSHUsernames discovers and returns the list of usernames that are
 // potential Tailscale SSH user targets.
 //
@@ -110,7 +132,41 @@ func (b *LocalBackend) getSSHUsernames(req *tailcfg.C2NSSHUsernamesRequest) (*ta
 	return res, nil
 }
 
+// GetSSH_HostKeys returns the signers for the SSH host keys that Tailscale SSH
+// should present. If an SSH host CA is configured with
+// TS_SSH_HOST_CA_KEY_FILE, the result also contains a certificate signer for
+// each host key, valid for the node's MagicDNS names and Tailscale IPs.
+//
+// Invariant: must not be called with b.mu held.
 func (b *LocalBackend) GetSSH_HostKeys() (keys []ssh.Signer, err error) {
+	keys, err = b.getSSH_HostKeys()
+	if err != nil {
+		return nil, err
+	}
+	ca, err := sshHostCA.load()
+	if err != nil {
+		b.logf("ssh: not using host certificates: %v", err)
+		return keys, nil
+	}
+	if ca == nil {
+		return keys, nil
+	}
+	principals := sshHostCertPrincipals(b.NetMap())
+	if len(principals) == 0 {
+		// Not yet in the tailnet, there's nothing to certify.
+		return keys, nil
+	}
+	certs, err := signSSHHostKeys(ca, keys, principals, b.clock.Now())
+	if err != nil {
+		b.logf("ssh: not using host certificates: %v", err)
+		return keys, nil
+	}
+	return append(keys, certs...), nil
+}
+
+// getSSH_HostKeys returns the node's SSH host keys, preferring the system's
+// OpenSSH host keys when running as root.
+func (b *LocalBackend) getSSH_HostKeys() (keys []ssh.Signer, err error) {
 	var existing map[string]ssh.Signer
 	if os.Geteuid() == 0 {
 		existing = b.getSystemSSH_HostKeys()
@@ -118,6 +174,130 @@ func (b *LocalBackend) GetSSH_HostKeys() (keys []ssh.Signer, err error) {
 	return b.getTailscaleSSH_HostKeys(existing)
 }
 
+// sshHostCA is the SSH host CA configured with TS_SSH_HOST_CA_KEY_FILE.
+var sshHostCA sshHostCACache
+
+// sshHostCACache caches the SSH host CA key, so that it's only read and
+// parsed again when the key file changes.
+type sshHostCACache struct {
+	mu      sync.Mutex
+	loaded  bool
+	path    string
+	modTime time.Time
+	size    int64
+	ca      ssh.Signer
+	err     error
+}
+
+// load returns the SSH host CA, or (nil, nil) if none is configured. It
+// reloads the key if the key file changed since it was last loaded, so that
+// the key can be rotated without restarting tailscaled.
+//
+// It stats the key file, so it must not be called with LocalBackend.mu held.
+func (c *sshHostCACache) load() (ssh.Signer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := sshHostCAKeyFile()
+	if path == "" {
+		c.loaded, c.path, c.ca, c.err = true, "", nil, nil
+		return nil, nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		c.loaded, c.path, c.ca, c.err = true, path, nil, fmt.Errorf("reading SSH host CA key: %w", err)
+		return nil, c.err
+	}
+	if c.loaded && c.err == nil && c.path == path && fi.ModTime().Equal(c.modTime) && fi.Size() == c.size {
+		return c.ca, nil
+	}
+	c.loaded, c.path, c.modTime, c.size = true, path, fi.ModTime(), fi.Size()
+	c.ca, c.err = loadSSHHostCAFile(path)
+	return c.ca, c.err
+}
+
+// cached returns the SSH host CA as of the last call to load, or (nil, nil)
+// if it was never loaded. It never reads the key file, so it's safe to call
+// with LocalBackend.mu held.
+func (c *sshHostCACache) cached() (ssh.Signer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ca, c.err
+}
+
+// loadSSHHostCA loads the SSH host CA, if one is configured, so that it's
+// available to getSSHHostCAPublicStrings.
+//
+// It must not be called with b.mu held.
+func (b *LocalBackend) loadSSHHostCA() {
+	if sshHostCAKeyFile() == "" {
+		return
+	}
+	if _, err := sshHostCA.load(); err != nil {
+		b.logf("ssh: %v", err)
+	}
+}
+
+// loadSSHHostCAFile reads and parses the SSH host CA key at path.
+func loadSSHHostCAFile(path string) (ssh.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading SSH host CA key: %w", err)
+	}
+	ca, err := ssh.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SSH host CA key %q: %w", path, err)
+	}
+	return ca, nil
+}
+
+// sshHostCertPrincipals returns the names that SSH host certificates for the
+// self node in nm are valid for: its MagicDNS FQDN, its short MagicDNS name,
+// and its Tailscale IPs.
+func sshHostCertPrincipals(nm *netmap.NetworkMap) []string {
+	if nm == nil {
+		return nil
+	}
+	var ret []string
+	if fqdn := strings.TrimSuffix(nm.Name, "."); fqdn != "" {
+		ret = append(ret, fqdn)
+		if short, _, ok := strings.Cut(fqdn, "."); ok {
+			ret = append(ret, short)
+		}
+	}
+	addrs := nm.GetAddresses()
+	for i := range addrs.Len() {
+		if pfx := addrs.At(i); pfx.IsSingleIP() {
+			ret = append(ret, pfx.Addr().String())
+		}
+	}
+	return ret
+}
+
+// signSSHHostKeys returns certificate signers for keys, signed by ca and valid
+// for principals from shortly before now until sshHostCertValidity after.
+func signSSHHostKeys(ca ssh.Signer, keys []ssh.Signer, principals []string, now time.Time) ([]ssh.Signer, error) {
+	var ret []ssh.Signer
+	for _, k := range keys {
+		cert := &ssh.Certificate{
+			Key:             k.PublicKey(),
+			CertType:        ssh.HostCert,
+			KeyId:           "tailscale-ssh:" + principals[0],
+			ValidPrincipals: principals,
+			ValidAfter:      uint64(now.Add(-sshHostCertBackdate).Unix()),
+			ValidBefore:     uint64(now.Add(sshHostCertValidity).Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			return nil, fmt.Errorf("signing %s host key: %w", k.PublicKey().Type(), err)
+		}
+		cs, err := ssh.NewCertSigner(cert, k)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cs)
+	}
+	return ret, nil
+}
+
 // getTailscaleSSH_HostKeys returns the three (rsa, ecdsa, ed25519) SSH host
 // keys, reusing the provided ones in existing if present in the map.
 func (b *LocalBackend) getTailscaleSSH_HostKeys(existing map[string]ssh.Signer) (keys []ssh.Signer, err error) {
@@ -213,7 +393,7 @@ func (b *LocalBackend) getSystemSSH_HostKeys() (ret map[string]ssh.Signer) {
 }
 
 func (b *LocalBackend) getSSHHostKeyPublicStrings() ([]string, error) {
-	signers, err := b.GetSSH_HostKeys()
+	signers, err := b.getSSH_HostKeys()
 	if err != nil {
 		return nil, err
 	}
@@ -224,6 +404,18 @@ func (b *LocalBackend) getSSHHostKeyPublicStrings() ([]string, error) {
 	return keyStrings, nil
 }
 
+// getSSHHostCAPublicStrings returns the public key of the configured SSH host
+// CA in authorized_keys format, or nil if none is configured. It uses the CA
+// as last loaded by NewLocalBackend or GetSSH_HostKeys, so it's safe to call
+// with b.mu held.
+func (b *LocalBackend) getSSHHostCAPublicStrings() ([]string, error) {
+	ca, err := sshHostCA.cached()
+	if ca == nil || err != nil {
+		return nil, err
+	}
+	return []string{strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.PublicKey())))}, nil
+}
+
 // tailscaleSSHEnabled reports whether Tailscale SSH is currently enabled based
 // on prefs. It returns false if there are no prefs set.
 func (b *LocalBackend) tailscaleSSHEnabled() bool {
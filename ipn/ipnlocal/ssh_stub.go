@@ -15,6 +15,12 @@ func (b *LocalBackend) getSSHHostKeyPublicStrings() ([]string, error) {
 	return nil, nil
 }
 
+func (b *LocalBackend) getSSHHostCAPublicStrings() ([]string, error) {
+	return nil, nil
+}
+
+func (b *LocalBackend) loadSSHHostCA() {}
+
 func (b *LocalBackend) getSSHUsernames(*tailcfg.C2NSSHUsernamesRequest) (*tailcfg.C2NSSHUsernamesResponse, error) {
 	return nil, errors.New("not implemented")
 }
@@ -6,13 +6,25 @@
 package ipnlocal
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
+	"golang.org/x/crypto/ssh"
+	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
 	"tailscale.com/util/must"
 )
 
@@ -59,3 +71,122 @@ func TestGetSSHUsernames(t *testing.T) {
 	}
 	t.Logf("Got: %s", must.Get(json.Marshal(res)))
 }
+
+func TestSignSSHHostKeys(t *testing.T) {
+	lb := &LocalBackend{varRoot: t.TempDir()}
+	keys, err := lb.getTailscaleSSH_HostKeys(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nm := &netmap.NetworkMap{
+		Name: "foo.tail-scale.ts.net.",
+		SelfNode: (&tailcfg.Node{
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		}).View(),
+	}
+	principals := sshHostCertPrincipals(nm)
+	if want := []string{"foo.tail-scale.ts.net", "foo", "100.64.0.1"}; !reflect.DeepEqual(principals, want) {
+		t.Fatalf("principals = %q; want %q", principals, want)
+	}
+
+	now := time.Now()
+	certs, err := signSSHHostKeys(ca, keys, principals, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != len(keys) {
+		t.Fatalf("got %d certs; want %d", len(certs), len(keys))
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+		Clock: func() time.Time { return now },
+	}
+	for _, c := range certs {
+		cert, ok := c.PublicKey().(*ssh.Certificate)
+		if !ok {
+			t.Fatalf("got %T; want *ssh.Certificate", c.PublicKey())
+		}
+		for _, p := range principals {
+			if err := checker.CheckHostKey(net.JoinHostPort(p, "22"), nil, cert); err != nil {
+				t.Errorf("%s cert for %q: %v", cert.Key.Type(), p, err)
+			}
+		}
+		if err := checker.CheckHostKey("bar.tail-scale.ts.net:22", nil, cert); err == nil {
+			t.Errorf("%s cert valid for unrelated host", cert.Key.Type())
+		}
+	}
+
+	if got := sshHostCertPrincipals(nil); got != nil {
+		t.Errorf("principals for nil netmap = %q; want nil", got)
+	}
+}
+
+func TestSSHHostCACache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca")
+	writeCA := func() ssh.PublicKey {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := must.Get(ssh.MarshalPrivateKey(priv, ""))
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return must.Get(ssh.NewSignerFromKey(priv)).PublicKey()
+	}
+	envknob.Setenv("TS_SSH_HOST_CA_KEY_FILE", path)
+	defer envknob.Setenv("TS_SSH_HOST_CA_KEY_FILE", "")
+
+	var c sshHostCACache
+	if ca, err := c.cached(); ca != nil || err != nil {
+		t.Fatalf("cached before load = %v, %v; want nil, nil", ca, err)
+	}
+	if _, err := c.load(); err == nil {
+		t.Fatal("load with missing key file succeeded")
+	}
+	if _, err := c.cached(); err == nil {
+		t.Fatal("cached after failed load succeeded")
+	}
+	pub := writeCA()
+	ca, err := c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ca.PublicKey().Marshal(), pub.Marshal()) {
+		t.Fatal("loaded wrong CA")
+	}
+	if ca2, _ := c.cached(); ca2 != ca {
+		t.Error("cached returned a different signer")
+	}
+	if ca2, _ := c.load(); ca2 != ca {
+		t.Error("load of unchanged key file returned a different signer")
+	}
+
+	// A rotated key is picked up by load, but not by cached until then.
+	pub = writeCA()
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if ca2, _ := c.cached(); ca2 != ca {
+		t.Error("cached reloaded the key file")
+	}
+	ca, err = c.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ca.PublicKey().Marshal(), pub.Marshal()) {
+		t.Error("load didn't pick up the rotated CA")
+	}
+}
@@ -255,6 +255,10 @@ type PeerStatus struct {
 	CurAddr string // one of Addrs, or unique if roaming
 	Relay   string // DERP region
 
+	// PathPolicy describes the constraints the local path policy places
+	// on the paths used to reach this peer, if any.
+	PathPolicy string `json:",omitempty"`
+
 	RxBytes        int64
 	TxBytes        int64
 	Created        time.Time // time registered with tailcontrol
@@ -299,6 +303,10 @@ type PeerStatus struct {
 	// SSH_HostKeys are the node's SSH host keys, if known.
 	SSH_HostKeys []string `json:"sshHostKeys,omitempty"`
 
+	// SSH_HostCAKeys are the public keys of the SSH certificate
+	// authorities that sign the node's SSH host keys, if any.
+	SSH_HostCAKeys []string `json:"sshHostCAKeys,omitempty"`
+
 	// ShareeNode indicates this node exists in the netmap because
 	// it's owned by a shared-to user and that node might connect
 	// to us. These nodes should be hidden by "tailscale status"
@@ -475,12 +483,18 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.SSH_HostKeys; v != nil {
 		e.SSH_HostKeys = v
 	}
+	if v := st.SSH_HostCAKeys; v != nil {
+		e.SSH_HostCAKeys = v
+	}
 	if v := st.Addrs; v != nil {
 		e.Addrs = v
 	}
 	if v := st.CurAddr; v != "" {
 		e.CurAddr = v
 	}
+	if v := st.PathPolicy; v != "" {
+		e.PathPolicy = v
+	}
 	if v := st.RxBytes; v != 0 {
 		e.RxBytes = v
 	}
@@ -40,6 +40,7 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
@@ -115,6 +116,7 @@
 	"logout":                       (*Handler).serveLogout,
 	"logtap":                       (*Handler).serveLogTap,
 	"metrics":                      (*Handler).serveMetrics,
+	"netcheck-history":             (*Handler).serveNetcheckHistory,
 	"ping":                         (*Handler).servePing,
 	"pprof":                        (*Handler).servePprof,
 	"prefs":                        (*Handler).servePrefs,
@@ -1630,6 +1632,27 @@ func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	e.Encode(h.b.DERPMap())
 }
 
+// serveNetcheckHistory returns tailscaled's recent netcheck reports, oldest
+// first, as a JSON array.
+func (h *Handler) serveNetcheckHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "netcheck history access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.GET {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	reports := h.b.NetcheckHistory()
+	if reports == nil {
+		reports = []*netcheck.Report{}
+	}
+	e.Encode(reports)
+}
+
 // serveSetExpirySooner sets the expiry date on the current machine, specified
 // by an `expiry` unix timestamp as POST or query param.
 func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
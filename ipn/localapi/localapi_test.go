@@ -334,6 +334,24 @@ func TestServeWatchIPNBus(t *testing.T) {
 	}
 }
 
+func TestServeNetcheckHistory(t *testing.T) {
+	for _, permitRead := range []bool{false, true} {
+		h := &Handler{
+			PermitRead: permitRead,
+			b:          newTestLocalBackend(t),
+		}
+		w := httptest.NewRecorder()
+		h.serveNetcheckHistory(w, httptest.NewRequest("GET", "/localapi/v0/netcheck-history", nil))
+		want := http.StatusOK
+		if !permitRead {
+			want = http.StatusForbidden
+		}
+		if w.Code != want {
+			t.Errorf("PermitRead=%v: status = %d, want %d; body: %s", permitRead, w.Code, want, w.Body)
+		}
+	}
+}
+
 func newTestLocalBackend(t testing.TB) *ipnlocal.LocalBackend {
 	var logf logger.Logf = logger.Discard
 	sys := tsd.NewSystem()
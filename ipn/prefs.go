@@ -24,8 +24,10 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
+	"tailscale.com/types/qos"
 	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
 	"tailscale.com/util/syspolicy"
@@ -259,6 +261,16 @@ type Prefs struct {
 	// non-nil/enabled.
 	RelayServerPort *int `json:",omitempty"`
 
+	// PathPolicy, if non-nil, constrains how paths to peers are chosen,
+	// such as which DERP regions may be used and which peers must only be
+	// reached through peer relays. A nil value imposes no constraints.
+	PathPolicy *pathpolicy.Policy `json:",omitempty"`
+
+	// QoSPolicy, if non-nil, configures rate limits, queueing priorities,
+	// and DSCP marking for traffic this node sends to its peers. A nil
+	// value leaves that traffic alone.
+	QoSPolicy *qos.Policy `json:",omitempty"`
+
 	// AllowSingleHosts was a legacy field that was always true
 	// for the past 4.5 years. It controlled whether Tailscale
 	// peers got /32 or /127 routes for each other.
@@ -351,6 +363,8 @@ type MaskedPrefs struct {
 	NetfilterKindSet          bool                `json:",omitempty"`
 	DriveSharesSet            bool                `json:",omitempty"`
 	RelayServerPortSet        bool                `json:",omitempty"`
+	PathPolicySet             bool                `json:",omitempty"`
+	QoSPolicySet              bool                `json:",omitempty"`
 }
 
 // SetsInternal reports whether mp has any of the Internal*Set field bools set
@@ -572,6 +586,12 @@ func (p *Prefs) pretty(goos string) string {
 	if p.RelayServerPort != nil {
 		fmt.Fprintf(&sb, "relayServerPort=%d ", *p.RelayServerPort)
 	}
+	if p.PathPolicy != nil {
+		sb.WriteString("pathPolicy ")
+	}
+	if p.QoSPolicy != nil {
+		sb.WriteString("qosPolicy ")
+	}
 	if p.Persist != nil {
 		sb.WriteString(p.Persist.Pretty())
 	} else {
@@ -634,7 +654,9 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.PostureChecking == p2.PostureChecking &&
 		slices.EqualFunc(p.DriveShares, p2.DriveShares, drive.SharesEqual) &&
 		p.NetfilterKind == p2.NetfilterKind &&
-		compareIntPtrs(p.RelayServerPort, p2.RelayServerPort)
+		compareIntPtrs(p.RelayServerPort, p2.RelayServerPort) &&
+		p.PathPolicy.Equal(p2.PathPolicy) &&
+		p.QoSPolicy.Equal(p2.QoSPolicy)
 }
 
 func (au AutoUpdatePrefs) Pretty() string {
@@ -21,8 +21,10 @@
 	"tailscale.com/tstest"
 	"tailscale.com/types/key"
 	"tailscale.com/types/opt"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
+	"tailscale.com/types/qos"
 )
 
 func fieldsOf(t reflect.Type) (fields []string) {
@@ -66,6 +68,8 @@ func TestPrefsEqual(t *testing.T) {
 		"NetfilterKind",
 		"DriveShares",
 		"RelayServerPort",
+		"PathPolicy",
+		"QoSPolicy",
 		"AllowSingleHosts",
 		"Persist",
 	}
@@ -355,6 +359,36 @@ func TestPrefsEqual(t *testing.T) {
 			&Prefs{RelayServerPort: relayServerPort(1)},
 			false,
 		},
+		{
+			&Prefs{PathPolicy: &pathpolicy.Policy{PreferDERPRegion: 1}},
+			&Prefs{PathPolicy: nil},
+			false,
+		},
+		{
+			&Prefs{PathPolicy: &pathpolicy.Policy{PreferDERPRegion: 1}},
+			&Prefs{PathPolicy: &pathpolicy.Policy{PreferDERPRegion: 1}},
+			true,
+		},
+		{
+			&Prefs{PathPolicy: &pathpolicy.Policy{Peers: []*pathpolicy.PeerRule{{Match: []string{"*"}, DERPRegions: []int{1}}}}},
+			&Prefs{PathPolicy: &pathpolicy.Policy{Peers: []*pathpolicy.PeerRule{{Match: []string{"*"}, DERPRegions: []int{2}}}}},
+			false,
+		},
+		{
+			&Prefs{QoSPolicy: &qos.Policy{MaxRate: 1e6}},
+			&Prefs{QoSPolicy: nil},
+			false,
+		},
+		{
+			&Prefs{QoSPolicy: &qos.Policy{Rules: []*qos.Rule{{Peers: []string{"*"}, Rate: 1e6}}}},
+			&Prefs{QoSPolicy: &qos.Policy{Rules: []*qos.Rule{{Peers: []string{"*"}, Rate: 1e6}}}},
+			true,
+		},
+		{
+			&Prefs{QoSPolicy: &qos.Policy{Rules: []*qos.Rule{{Peers: []string{"*"}, DSCP: 46}}}},
+			&Prefs{QoSPolicy: &qos.Policy{Rules: []*qos.Rule{{Peers: []string{"*"}, DSCP: 10}}}},
+			false,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equals(tt.b)
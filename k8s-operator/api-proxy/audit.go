@@ -0,0 +1,178 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package apiproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+const (
+	// Annotations set on audit events, matching the ones set by the
+	// kube-apiserver for its authorization decisions.
+	auditAnnotationDecision = "authorization.k8s.io/decision"
+	auditAnnotationReason   = "authorization.k8s.io/reason"
+	// auditAnnotationNode is the tailnet node that the request came from.
+	auditAnnotationNode = "tailscale.com/node"
+)
+
+// auditLogger writes a Kubernetes audit event for each request to the API
+// server proxy. Events are written as JSON, one per line, in the same format
+// as the kube-apiserver's log backend, so that existing tooling for audit logs
+// can be used to process them.
+type auditLogger struct {
+	mu sync.Mutex // protects w
+	w  io.Writer
+}
+
+// newAuditLogger returns an auditLogger that appends events to the file at
+// path, or writes them to stdout if path is "-".
+func newAuditLogger(path string) (*auditLogger, error) {
+	if path == "-" {
+		return &auditLogger{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	return &auditLogger{w: f}, nil
+}
+
+func (a *auditLogger) log(ev *auditv1.Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(b)
+	return err
+}
+
+// newAuditEvent returns an audit event for a request from the caller who,
+// impersonated as user, groups.
+func newAuditEvent(r *http.Request, ri *requestInfo, who *apitype.WhoIsResponse, user string, groups []string, received time.Time) *auditv1.Event {
+	ev := &auditv1.Event{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Event",
+			APIVersion: auditv1.SchemeGroupVersion.String(),
+		},
+		Level:                    auditv1.LevelMetadata,
+		AuditID:                  types.UID(uuid.NewString()),
+		Stage:                    auditv1.StageResponseComplete,
+		RequestURI:               r.URL.RequestURI(),
+		Verb:                     ri.Verb,
+		UserAgent:                r.UserAgent(),
+		RequestReceivedTimestamp: metav1.NewMicroTime(received),
+		Annotations:              map[string]string{},
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ev.SourceIPs = []string{host}
+	}
+	if who != nil {
+		if who.UserProfile != nil {
+			ev.User.Username = who.UserProfile.LoginName
+		}
+		if who.Node != nil {
+			ev.Annotations[auditAnnotationNode] = strings.TrimSuffix(who.Node.Name, ".")
+			if who.Node.IsTagged() {
+				ev.User.Username = strings.TrimSuffix(who.Node.Name, ".")
+				ev.User.Groups = who.Node.Tags
+			}
+		}
+	}
+	if user != "" {
+		ev.ImpersonatedUser = &authnv1.UserInfo{
+			Username: user,
+			Groups:   groups,
+		}
+	}
+	if ri.IsResourceRequest {
+		ev.ObjectRef = &auditv1.ObjectReference{
+			Resource:    ri.Resource,
+			Namespace:   ri.Namespace,
+			Name:        ri.Name,
+			APIGroup:    ri.APIGroup,
+			APIVersion:  ri.APIVersion,
+			Subresource: ri.Subresource,
+		}
+	}
+	return ev
+}
+
+// statusRecorder is an [http.ResponseWriter] that records the status code of
+// the response, for audit events.
+type statusRecorder struct {
+	http.ResponseWriter
+	code     int
+	hijacked bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements [http.Hijacker], which is needed for 'kubectl exec' and
+// other streaming requests that upgrade the connection.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", s.ResponseWriter)
+	}
+	c, brw, err := hj.Hijack()
+	if err == nil {
+		s.hijacked = true
+	}
+	return c, brw, err
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// status returns the status code of the response.
+func (s *statusRecorder) status() int {
+	switch {
+	case s.code != 0:
+		return s.code
+	case s.hijacked:
+		return http.StatusSwitchingProtocols
+	default:
+		return http.StatusOK
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package apiproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"tailscale.com/client/local"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+func TestRecordAuditEvent(t *testing.T) {
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	who := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "foo@example.com"},
+	}
+	rules := []kubetypes.KubernetesCapRule{{Impersonate: &kubetypes.ImpersonateRule{Groups: []string{"admins"}}}}
+
+	tests := []struct {
+		name         string
+		mode         APIServerProxyMode
+		authzErr     error
+		code         int
+		wantUser     *authnv1.UserInfo
+		wantDecision string
+	}{
+		{
+			name:         "allowed",
+			mode:         APIServerProxyModeEnabled,
+			code:         http.StatusOK,
+			wantUser:     &authnv1.UserInfo{Username: "foo@example.com", Groups: []string{"admins"}},
+			wantDecision: "allow",
+		},
+		{
+			name:         "denied",
+			mode:         APIServerProxyModeEnabled,
+			authzErr:     errors.New("create pods/exec in namespace \"foo\" is denied by a tailnet grant"),
+			code:         http.StatusForbidden,
+			wantDecision: "forbid",
+		},
+		{
+			name:         "noauth",
+			mode:         APIServerProxyModeNoAuth,
+			code:         http.StatusSwitchingProtocols,
+			wantDecision: "allow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ap := &apiserverProxy{
+				log:   zl.Sugar(),
+				mode:  tt.mode,
+				audit: &auditLogger{w: &buf},
+			}
+			r := must.Get(http.NewRequest("POST", "https://op.ts.net/api/v1/namespaces/foo/pods/bar/exec?command=sh", nil))
+			r.RemoteAddr = "100.64.0.1:1234"
+			r.Header.Set("User-Agent", "kubectl")
+			ap.recordAuditEvent(r, parseRequestInfo(r), who, rules, time.Now(), tt.code, tt.authzErr)
+
+			var ev auditv1.Event
+			if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+				t.Fatalf("error parsing audit event %q: %v", buf.String(), err)
+			}
+			if ev.Kind != "Event" || ev.APIVersion != "audit.k8s.io/v1" || ev.AuditID == "" {
+				t.Errorf("unexpected event metadata: %+v", ev)
+			}
+			if ev.Stage != auditv1.StageResponseComplete || ev.Verb != "create" || ev.RequestURI != "/api/v1/namespaces/foo/pods/bar/exec?command=sh" {
+				t.Errorf("unexpected request details: stage %q, verb %q, URI %q", ev.Stage, ev.Verb, ev.RequestURI)
+			}
+			if ev.User.Username != "foo@example.com" {
+				t.Errorf("unexpected user %q", ev.User.Username)
+			}
+			if diff := cmp.Diff(tt.wantUser, ev.ImpersonatedUser); diff != "" {
+				t.Errorf("unexpected impersonated user (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]string{"100.64.0.1"}, ev.SourceIPs); diff != "" {
+				t.Errorf("unexpected source IPs (-want +got):\n%s", diff)
+			}
+			wantRef := &auditv1.ObjectReference{Resource: "pods", Namespace: "foo", Name: "bar", APIVersion: "v1", Subresource: "exec"}
+			if diff := cmp.Diff(wantRef, ev.ObjectRef); diff != "" {
+				t.Errorf("unexpected object reference (-want +got):\n%s", diff)
+			}
+			if ev.ResponseStatus == nil || ev.ResponseStatus.Code != int32(tt.code) {
+				t.Errorf("unexpected response status %+v, want code %d", ev.ResponseStatus, tt.code)
+			}
+			if got := ev.Annotations[auditAnnotationDecision]; got != tt.wantDecision {
+				t.Errorf("unexpected decision %q, want %q", got, tt.wantDecision)
+			}
+			if tt.authzErr != nil && ev.Annotations[auditAnnotationReason] != tt.authzErr.Error() {
+				t.Errorf("unexpected reason %q", ev.Annotations[auditAnnotationReason])
+			}
+		})
+	}
+}
+
+func TestServeAuditsUnauthenticated(t *testing.T) {
+	var buf bytes.Buffer
+	ap := &apiserverProxy{
+		log: zap.NewNop().Sugar(),
+		lc: &local.Client{Dial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("tailscaled unavailable")
+		}},
+		mode:  APIServerProxyModeEnabled,
+		audit: &auditLogger{w: &buf},
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/namespaces/foo/secrets", nil)
+	r.RemoteAddr = "100.64.0.1:1234"
+	ap.serve(w, r, func(http.ResponseWriter, *http.Request, *apitype.WhoIsResponse) {
+		t.Fatal("unauthenticated request was proxied")
+	})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status code %d", w.Code)
+	}
+
+	var ev auditv1.Event
+	if err := json.Unmarshal(buf.Bytes(), &ev); err != nil {
+		t.Fatalf("error parsing audit event %q: %v", buf.String(), err)
+	}
+	if ev.User.Username != "" || ev.ImpersonatedUser != nil {
+		t.Errorf("unexpected user %+v, impersonated %+v", ev.User, ev.ImpersonatedUser)
+	}
+	if ev.ResponseStatus == nil || ev.ResponseStatus.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected response status %+v", ev.ResponseStatus)
+	}
+	if got := ev.Annotations[auditAnnotationDecision]; got != "forbid" {
+		t.Errorf("unexpected decision %q, want forbid", got)
+	}
+	if got := ev.Annotations[auditAnnotationReason]; !strings.Contains(got, "failed to authenticate caller") {
+		t.Errorf("unexpected reason %q", got)
+	}
+}
+
+func TestWriteForbidden(t *testing.T) {
+	w := httptest.NewRecorder()
+	sr := &statusRecorder{ResponseWriter: w}
+	ri := &requestInfo{IsResourceRequest: true, Verb: "delete", APIGroup: "apps", APIVersion: "v1", Namespace: "foo", Resource: "deployments", Name: "bar"}
+	writeForbidden(sr, ri, errors.New("delete deployments in namespace \"foo\" is not allowed by any tailnet grant"))
+
+	if sr.status() != http.StatusForbidden || w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status code %d, recorded %d", w.Code, sr.status())
+	}
+	var st metav1.Status
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Kind != "Status" || st.Reason != metav1.StatusReasonForbidden || st.Code != http.StatusForbidden {
+		t.Errorf("unexpected Status %+v", st)
+	}
+	if st.Details == nil || st.Details.Group != "apps" || st.Details.Kind != "deployments" || st.Details.Name != "bar" {
+		t.Errorf("unexpected Status details %+v", st.Details)
+	}
+}
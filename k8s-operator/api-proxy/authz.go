@@ -0,0 +1,219 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package apiproxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"tailscale.com/kube/kubetypes"
+)
+
+// requestInfo describes a Kubernetes API server request. It is a subset of
+// k8s.io/apiserver/pkg/endpoints/request.RequestInfo, parsed the same way.
+type requestInfo struct {
+	// IsResourceRequest is false for non-resource requests, such as
+	// discovery (/api, /apis) or /version.
+	IsResourceRequest bool
+	Path              string
+	Verb              string
+
+	APIGroup    string
+	APIVersion  string
+	Namespace   string
+	Resource    string
+	Subresource string
+	Name        string
+}
+
+// parseRequestInfo returns information about the Kubernetes API server
+// request r.
+func parseRequestInfo(r *http.Request) *requestInfo {
+	ri := &requestInfo{
+		Path: r.URL.Path,
+		Verb: strings.ToLower(r.Method),
+	}
+	parts := splitPath(r.URL.Path)
+	if len(parts) < 3 {
+		// Discovery for /api, /apis and /apis/<group>, or a
+		// non-resource URL.
+		return ri
+	}
+	switch parts[0] {
+	case "api":
+		ri.APIVersion = parts[1]
+		parts = parts[2:]
+	case "apis":
+		if len(parts) < 4 {
+			return ri
+		}
+		ri.APIGroup, ri.APIVersion = parts[1], parts[2]
+		parts = parts[3:]
+	default:
+		return ri
+	}
+	ri.IsResourceRequest = true
+
+	switch r.Method {
+	case "POST":
+		ri.Verb = "create"
+	case "GET", "HEAD":
+		ri.Verb = "get"
+	case "PUT":
+		ri.Verb = "update"
+	case "PATCH":
+		ri.Verb = "patch"
+	case "DELETE":
+		ri.Verb = "delete"
+	}
+	// Deprecated /api/v1/watch/... paths.
+	if parts[0] == "watch" {
+		if ri.Verb == "get" {
+			ri.Verb = "watch"
+		}
+		parts = parts[1:]
+	}
+
+	// /namespaces/<ns>/<resource> is a namespaced resource, whereas
+	// /namespaces/<ns> and its status and finalize subresources refer to
+	// the Namespace itself.
+	if parts[0] == "namespaces" && len(parts) > 1 {
+		ri.Namespace = parts[1]
+		if len(parts) > 2 && parts[2] != "status" && parts[2] != "finalize" {
+			parts = parts[2:]
+		}
+	}
+	ri.Resource = parts[0]
+	if len(parts) > 1 {
+		ri.Name = parts[1]
+	}
+	if len(parts) > 2 {
+		ri.Subresource = parts[2]
+	}
+	switch ri.Subresource {
+	case "exec", "attach", "portforward":
+		// Streaming subresources are authorized as create, like the API
+		// server does, whatever the method. Clients using WebSockets
+		// send them as GET requests.
+		ri.Verb = "create"
+	}
+
+	if ri.Name == "" {
+		switch ri.Verb {
+		case "get":
+			ri.Verb = "list"
+			if w := r.URL.Query().Get("watch"); w == "true" || w == "1" {
+				ri.Verb = "watch"
+			}
+		case "delete":
+			ri.Verb = "deletecollection"
+		}
+	}
+	return ri
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// authorizeRequest checks the request described by ri against the allow and
+// deny rules in the caller's Kubernetes capability grants. It returns an
+// error describing why the request is not allowed, or nil if it is.
+//
+// Non-resource requests are not subject to the rules, as clients need them
+// for API discovery; Kubernetes RBAC still applies to them. If none of the
+// grants contain allow rules, all requests that don't match a deny rule are
+// allowed, so that grants that only configure impersonation or session
+// recording keep working as before.
+func authorizeRequest(rules []kubetypes.KubernetesCapRule, ri *requestInfo) error {
+	if !ri.IsResourceRequest {
+		return nil
+	}
+	hasAllowRules := false
+	allowed := false
+	for _, rule := range rules {
+		for _, d := range rule.Deny {
+			if apiRuleMatches(d, ri, true) {
+				return fmt.Errorf("%s is denied by a tailnet grant", ri.describe())
+			}
+		}
+		if len(rule.Allow) > 0 {
+			hasAllowRules = true
+		}
+		allowed = allowed || slices.ContainsFunc(rule.Allow, func(a kubetypes.KubernetesAPIRule) bool {
+			return apiRuleMatches(a, ri, false)
+		})
+	}
+	if hasAllowRules && !allowed {
+		return fmt.Errorf("%s is not allowed by any tailnet grant", ri.describe())
+	}
+	return nil
+}
+
+// describe returns a human-readable description of the request, in a form
+// similar to the one used by Kubernetes in RBAC errors.
+func (ri *requestInfo) describe() string {
+	res := ri.Resource
+	if ri.Subresource != "" {
+		res += "/" + ri.Subresource
+	}
+	if ri.Namespace != "" {
+		return fmt.Sprintf("%s %s in namespace %q", ri.Verb, res, ri.Namespace)
+	}
+	return fmt.Sprintf("%s %s at the cluster scope", ri.Verb, res)
+}
+
+// apiRuleMatches reports whether rule matches the request ri. deny reports
+// whether rule is a deny rule.
+func apiRuleMatches(rule kubetypes.KubernetesAPIRule, ri *requestInfo, deny bool) bool {
+	if len(rule.Namespaces) > 0 && !slices.Contains(rule.Namespaces, "*") {
+		switch {
+		case ri.Namespace != "":
+			if !slices.Contains(rule.Namespaces, ri.Namespace) {
+				return false
+			}
+		case deny && ri.Name == "":
+			// A collection request without a namespace, such as
+			// 'kubectl get secrets -A', covers every namespace
+			// of a namespaced resource. We can't tell namespaced
+			// and cluster-scoped resources apart here, so deny
+			// rules err on the side of matching.
+		default:
+			return false
+		}
+	}
+	if len(rule.Verbs) > 0 && !slices.Contains(rule.Verbs, "*") && !slices.Contains(rule.Verbs, ri.Verb) {
+		return false
+	}
+	if len(rule.Resources) > 0 && !slices.ContainsFunc(rule.Resources, func(r string) bool {
+		return resourceMatches(r, ri.Resource, ri.Subresource)
+	}) {
+		return false
+	}
+	return true
+}
+
+// resourceMatches reports whether the resource pattern from a grant matches
+// the resource and subresource of a request.
+func resourceMatches(pattern, resource, subresource string) bool {
+	if pattern == "*" {
+		return true
+	}
+	r, sub, hasSub := strings.Cut(pattern, "/")
+	if r != resource {
+		return false
+	}
+	if !hasSub {
+		return subresource == ""
+	}
+	return sub == "*" || sub == subresource
+}
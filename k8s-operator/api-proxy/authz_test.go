@@ -0,0 +1,240 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package apiproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"tailscale.com/kube/kubetypes"
+	"tailscale.com/util/must"
+)
+
+func TestParseRequestInfo(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		want   requestInfo
+	}{
+		{
+			method: "GET",
+			url:    "/version",
+			want:   requestInfo{Path: "/version", Verb: "get"},
+		},
+		{
+			method: "GET",
+			url:    "/apis/apps",
+			want:   requestInfo{Path: "/apis/apps", Verb: "get"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces", Verb: "list", APIVersion: "v1", Resource: "namespaces"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo", Verb: "get", APIVersion: "v1", Namespace: "foo", Resource: "namespaces", Name: "foo"},
+		},
+		{
+			method: "PUT",
+			url:    "/api/v1/namespaces/foo/finalize",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/finalize", Verb: "update", APIVersion: "v1", Namespace: "foo", Resource: "namespaces", Name: "foo", Subresource: "finalize"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo/pods?watch=true",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods", Verb: "watch", APIVersion: "v1", Namespace: "foo", Resource: "pods"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/watch/namespaces/foo/pods/bar",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/watch/namespaces/foo/pods/bar", Verb: "watch", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar"},
+		},
+		{
+			method: "POST",
+			url:    "/api/v1/namespaces/foo/pods/bar/exec?command=sh",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods/bar/exec", Verb: "create", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "exec"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo/pods/bar/exec?command=sh",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods/bar/exec", Verb: "create", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "exec"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo/pods/bar/attach",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods/bar/attach", Verb: "create", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "attach"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo/pods/bar/portforward",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods/bar/portforward", Verb: "create", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "portforward"},
+		},
+		{
+			method: "GET",
+			url:    "/api/v1/namespaces/foo/pods/bar/log",
+			want:   requestInfo{IsResourceRequest: true, Path: "/api/v1/namespaces/foo/pods/bar/log", Verb: "get", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "log"},
+		},
+		{
+			method: "DELETE",
+			url:    "/apis/apps/v1/namespaces/foo/deployments",
+			want:   requestInfo{IsResourceRequest: true, Path: "/apis/apps/v1/namespaces/foo/deployments", Verb: "deletecollection", APIGroup: "apps", APIVersion: "v1", Namespace: "foo", Resource: "deployments"},
+		},
+		{
+			method: "PATCH",
+			url:    "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin",
+			want:   requestInfo{IsResourceRequest: true, Path: "/apis/rbac.authorization.k8s.io/v1/clusterroles/admin", Verb: "patch", APIGroup: "rbac.authorization.k8s.io", APIVersion: "v1", Resource: "clusterroles", Name: "admin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			r := must.Get(http.NewRequest(tt.method, "https://op.ts.net"+tt.url, nil))
+			if diff := cmp.Diff(tt.want, *parseRequestInfo(r)); diff != "" {
+				t.Errorf("unexpected request info (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequest(t *testing.T) {
+	podsInFoo := &requestInfo{IsResourceRequest: true, Verb: "get", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar"}
+	execInFoo := &requestInfo{IsResourceRequest: true, Verb: "create", APIVersion: "v1", Namespace: "foo", Resource: "pods", Name: "bar", Subresource: "exec"}
+	nodes := &requestInfo{IsResourceRequest: true, Verb: "list", APIVersion: "v1", Resource: "nodes"}
+	allSecrets := &requestInfo{IsResourceRequest: true, Verb: "watch", APIVersion: "v1", Resource: "secrets"}
+	node := &requestInfo{IsResourceRequest: true, Verb: "get", APIVersion: "v1", Resource: "nodes", Name: "n1"}
+	discovery := &requestInfo{Verb: "get", Path: "/apis"}
+
+	tests := []struct {
+		name    string
+		rules   []kubetypes.KubernetesCapRule
+		ri      *requestInfo
+		wantErr bool
+	}{
+		{
+			name: "no_rules",
+			ri:   execInFoo,
+		},
+		{
+			name:  "impersonation_only",
+			rules: []kubetypes.KubernetesCapRule{{Impersonate: &kubetypes.ImpersonateRule{Groups: []string{"admins"}}}},
+			ri:    nodes,
+		},
+		{
+			name: "allowed",
+			rules: []kubetypes.KubernetesCapRule{{Allow: []kubetypes.KubernetesAPIRule{{
+				Namespaces: []string{"foo"},
+				Verbs:      []string{"get", "list"},
+				Resources:  []string{"pods"},
+			}}}},
+			ri: podsInFoo,
+		},
+		{
+			name: "subresource_not_allowed_by_resource",
+			rules: []kubetypes.KubernetesCapRule{{Allow: []kubetypes.KubernetesAPIRule{{
+				Namespaces: []string{"foo"},
+				Resources:  []string{"pods"},
+			}}}},
+			ri:      execInFoo,
+			wantErr: true,
+		},
+		{
+			name: "subresource_wildcard",
+			rules: []kubetypes.KubernetesCapRule{{Allow: []kubetypes.KubernetesAPIRule{{
+				Resources: []string{"pods/*"},
+			}}}},
+			ri: execInFoo,
+		},
+		{
+			name: "cluster_scoped_not_allowed_by_namespaced_rule",
+			rules: []kubetypes.KubernetesCapRule{{Allow: []kubetypes.KubernetesAPIRule{{
+				Namespaces: []string{"foo"},
+			}}}},
+			ri:      nodes,
+			wantErr: true,
+		},
+		{
+			name: "allowed_by_second_grant",
+			rules: []kubetypes.KubernetesCapRule{
+				{Allow: []kubetypes.KubernetesAPIRule{{Namespaces: []string{"bar"}}}},
+				{Allow: []kubetypes.KubernetesAPIRule{{Namespaces: []string{"*"}, Verbs: []string{"*"}, Resources: []string{"*"}}}},
+			},
+			ri: podsInFoo,
+		},
+		{
+			name: "deny_wins",
+			rules: []kubetypes.KubernetesCapRule{
+				{Allow: []kubetypes.KubernetesAPIRule{{Resources: []string{"*"}}}},
+				{Deny: []kubetypes.KubernetesAPIRule{{Resources: []string{"pods/exec"}}}},
+			},
+			ri:      execInFoo,
+			wantErr: true,
+		},
+		{
+			name: "deny_only",
+			rules: []kubetypes.KubernetesCapRule{
+				{Deny: []kubetypes.KubernetesAPIRule{{Verbs: []string{"create"}}}},
+			},
+			ri: podsInFoo,
+		},
+		{
+			name: "namespaced_deny_matches_all_namespaces",
+			rules: []kubetypes.KubernetesCapRule{
+				{Allow: []kubetypes.KubernetesAPIRule{{Resources: []string{"*"}}}},
+				{Deny: []kubetypes.KubernetesAPIRule{{Namespaces: []string{"prod"}, Resources: []string{"secrets"}}}},
+			},
+			ri:      allSecrets,
+			wantErr: true,
+		},
+		{
+			name: "namespaced_allow_does_not_match_all_namespaces",
+			rules: []kubetypes.KubernetesCapRule{
+				{Allow: []kubetypes.KubernetesAPIRule{{Namespaces: []string{"prod"}, Resources: []string{"secrets"}}}},
+			},
+			ri:      allSecrets,
+			wantErr: true,
+		},
+		{
+			name: "namespaced_deny_does_not_match_named_cluster_scoped",
+			rules: []kubetypes.KubernetesCapRule{
+				{Deny: []kubetypes.KubernetesAPIRule{{Namespaces: []string{"prod"}}}},
+			},
+			ri: node,
+		},
+		{
+			name: "non_resource_request",
+			rules: []kubetypes.KubernetesCapRule{
+				{Deny: []kubetypes.KubernetesAPIRule{{Verbs: []string{"*"}}}},
+			},
+			ri: discovery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeRequest(tt.rules, tt.ri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authorizeRequest() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWebSocketExec(t *testing.T) {
+	// kubectl exec over WebSockets sends a GET request, which must still be
+	// subject to rules about creating exec sessions.
+	r := must.Get(http.NewRequest("GET", "https://op.ts.net/api/v1/namespaces/foo/pods/bar/exec?command=sh&stdin=true&tty=true", nil))
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Protocol", "v5.channel.k8s.io")
+	rules := []kubetypes.KubernetesCapRule{
+		{Allow: []kubetypes.KubernetesAPIRule{{Resources: []string{"*"}, Verbs: []string{"*"}}}},
+		{Deny: []kubetypes.KubernetesAPIRule{{Resources: []string{"pods/exec"}, Verbs: []string{"create"}}}},
+	}
+	if err := authorizeRequest(rules, parseRequestInfo(r)); err == nil {
+		t.Error("WebSocket exec allowed despite a rule denying create on pods/exec")
+	}
+}
@@ -7,6 +7,7 @@
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,9 +16,13 @@
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/transport"
 	"tailscale.com/client/local"
@@ -34,7 +39,10 @@
 var (
 	// counterNumRequestsproxies counts the number of API server requests proxied via this proxy.
 	counterNumRequestsProxied = clientmetric.NewCounter("k8s_auth_proxy_requests_proxied")
-	whoIsKey                  = ctxkey.New("", (*apitype.WhoIsResponse)(nil))
+	// counterNumRequestsDenied counts the number of API server requests
+	// denied by the allow and deny rules in tailnet grants.
+	counterNumRequestsDenied = clientmetric.NewCounter("k8s_auth_proxy_requests_denied")
+	whoIsKey                 = ctxkey.New("", (*apitype.WhoIsResponse)(nil))
 )
 
 type APIServerProxyMode int
@@ -115,7 +123,16 @@ func MaybeLaunchAPIServerProxy(zlog *zap.SugaredLogger, restConfig *rest.Config,
 	if err != nil {
 		startlog.Fatalf("could not get rest.TransportConfig(): %v", err)
 	}
-	go runAPIServerProxy(s, rt, zlog.Named("apiserver-proxy"), mode, restConfig.Host)
+
+	// An audit event is written for every request if an audit log path is
+	// set; "-" writes the events to stdout.
+	var audit *auditLogger
+	if p := defaultEnv("APISERVER_PROXY_AUDIT_LOG_PATH", ""); p != "" {
+		if audit, err = newAuditLogger(p); err != nil {
+			startlog.Fatalf("could not set up API server proxy audit log: %v", err)
+		}
+	}
+	go runAPIServerProxy(s, rt, zlog.Named("apiserver-proxy"), mode, restConfig.Host, audit)
 }
 
 // runAPIServerProxy runs an HTTP server that authenticates requests using the
@@ -123,6 +140,7 @@ func MaybeLaunchAPIServerProxy(zlog *zap.SugaredLogger, restConfig *rest.Config,
 // It listens on :443 and uses the Tailscale HTTPS certificate.
 // s will be started if it is not already running.
 // rt is used to proxy requests to the Kubernetes API.
+// If audit is non-nil, an audit event is written to it for every request.
 //
 // mode controls how the proxy behaves:
 //   - apiserverProxyModeDisabled: the proxy is not started.
@@ -132,7 +150,7 @@ func MaybeLaunchAPIServerProxy(zlog *zap.SugaredLogger, restConfig *rest.Config,
 //     are passed through to the Kubernetes API.
 //
 // It never returns.
-func runAPIServerProxy(ts *tsnet.Server, rt http.RoundTripper, log *zap.SugaredLogger, mode APIServerProxyMode, host string) {
+func runAPIServerProxy(ts *tsnet.Server, rt http.RoundTripper, log *zap.SugaredLogger, mode APIServerProxyMode, host string, audit *auditLogger) {
 	if mode == APIServerProxyModeDisabled {
 		return
 	}
@@ -156,6 +174,7 @@ func runAPIServerProxy(ts *tsnet.Server, rt http.RoundTripper, log *zap.SugaredL
 		mode:        mode,
 		upstreamURL: u,
 		ts:          ts,
+		audit:       audit,
 	}
 	ap.rp = &httputil.ReverseProxy{
 		Rewrite: func(pr *httputil.ProxyRequest) {
@@ -195,17 +214,95 @@ type apiserverProxy struct {
 	mode        APIServerProxyMode
 	ts          *tsnet.Server
 	upstreamURL *url.URL
+	audit       *auditLogger // or nil if audit events are not recorded
 }
 
 // serveDefault is the default handler for Kubernetes API server requests.
 func (ap *apiserverProxy) serveDefault(w http.ResponseWriter, r *http.Request) {
-	who, err := ap.whoIs(r)
+	ap.serve(w, r, func(w http.ResponseWriter, r *http.Request, _ *apitype.WhoIsResponse) {
+		ap.rp.ServeHTTP(w, r)
+	})
+}
+
+// serve authenticates the caller of r and checks the request against the
+// allow and deny rules in the caller's tailnet grants. If the request is
+// allowed, it is passed to next with the caller's identity stashed in its
+// context. An audit event is recorded for each request once it completes.
+func (ap *apiserverProxy) serve(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request, *apitype.WhoIsResponse)) {
+	received := time.Now()
+	ri := parseRequestInfo(r)
+	sr := &statusRecorder{ResponseWriter: w}
+	var (
+		who   *apitype.WhoIsResponse
+		rules []kubetypes.KubernetesCapRule
+		err   error
+	)
+	if ap.audit != nil {
+		defer func() {
+			ap.recordAuditEvent(r, ri, who, rules, received, sr.status(), err)
+		}()
+	}
+
+	who, err = ap.whoIs(r)
+	if err != nil {
+		ap.authError(sr, err)
+		err = fmt.Errorf("failed to authenticate caller: %w", err)
+		return
+	}
+	rules, err = kubernetesCapRules(who)
+	if err != nil {
+		// Fail closed, as the grants may contain deny rules that we
+		// cannot parse.
+		err = fmt.Errorf("failed to parse Kubernetes capability grants: %w", err)
+	} else {
+		err = authorizeRequest(rules, ri)
+	}
 	if err != nil {
-		ap.authError(w, err)
+		counterNumRequestsDenied.Add(1)
+		ap.log.With("remote", r.RemoteAddr).Infof("denying request: %v", err)
+		writeForbidden(sr, ri, err)
 		return
 	}
 	counterNumRequestsProxied.Add(1)
-	ap.rp.ServeHTTP(w, r.WithContext(whoIsKey.WithValue(r.Context(), who)))
+	next(sr, r.WithContext(whoIsKey.WithValue(r.Context(), who)), who)
+}
+
+// recordAuditEvent writes an audit event for the completed request r to the
+// audit log. authzErr is the reason the request was denied, or nil if it was
+// allowed.
+func (ap *apiserverProxy) recordAuditEvent(r *http.Request, ri *requestInfo, who *apitype.WhoIsResponse, rules []kubetypes.KubernetesCapRule, received time.Time, code int, authzErr error) {
+	var user string
+	var groups []string
+	if ap.mode == APIServerProxyModeEnabled && authzErr == nil {
+		user, groups = impersonatedIdentity(who, rules)
+	}
+	ev := newAuditEvent(r, ri, who, user, groups, received)
+	ev.ResponseStatus = &metav1.Status{Code: int32(code)}
+	ev.StageTimestamp = metav1.NewMicroTime(time.Now())
+	if authzErr != nil {
+		ev.Annotations[auditAnnotationDecision] = "forbid"
+		ev.Annotations[auditAnnotationReason] = authzErr.Error()
+	} else {
+		ev.Annotations[auditAnnotationDecision] = "allow"
+	}
+	if err := ap.audit.log(ev); err != nil {
+		ap.log.Errorf("failed to write audit event: %v", err)
+	}
+}
+
+// writeForbidden writes a Kubernetes Status response for the denied request,
+// so that clients such as kubectl display the reason to the user.
+func writeForbidden(w http.ResponseWriter, ri *requestInfo, err error) {
+	status := apierrors.NewForbidden(schema.GroupResource{Group: ri.APIGroup, Resource: ri.Resource}, ri.Name, err).ErrStatus
+	status.APIVersion, status.Kind = "v1", "Status"
+	b, mErr := json.Marshal(status)
+	if mErr != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(b)
 }
 
 // serveExecSPDY serves 'kubectl exec' requests for sessions streamed over SPDY,
@@ -221,25 +318,27 @@ func (ap *apiserverProxy) serveExecWS(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ap *apiserverProxy) execForProto(w http.ResponseWriter, r *http.Request, proto ksr.Protocol) {
+	ap.serve(w, r, func(w http.ResponseWriter, r *http.Request, who *apitype.WhoIsResponse) {
+		ap.serveExec(w, r, who, proto)
+	})
+}
+
+// serveExec serves a 'kubectl exec' request from who that has been
+// authorized, recording the session if the caller's grants require it.
+func (ap *apiserverProxy) serveExec(w http.ResponseWriter, r *http.Request, who *apitype.WhoIsResponse, proto ksr.Protocol) {
 	const (
 		podNameKey       = "pod"
 		namespaceNameKey = "namespace"
 		upgradeHeaderKey = "Upgrade"
 	)
 
-	who, err := ap.whoIs(r)
-	if err != nil {
-		ap.authError(w, err)
-		return
-	}
-	counterNumRequestsProxied.Add(1)
 	failOpen, addrs, err := determineRecorderConfig(who)
 	if err != nil {
 		ap.log.Errorf("error trying to determine whether the 'kubectl exec' session needs to be recorded: %v", err)
 		return
 	}
 	if failOpen && len(addrs) == 0 { // will not record
-		ap.rp.ServeHTTP(w, r.WithContext(whoIsKey.WithValue(r.Context(), who)))
+		ap.rp.ServeHTTP(w, r)
 		return
 	}
 	ksr.CounterSessionRecordingsAttempted.Add(1) // at this point we know that users intended for this session to be recorded
@@ -256,7 +355,7 @@ func (ap *apiserverProxy) execForProto(w http.ResponseWriter, r *http.Request, p
 		if failOpen {
 			msg = msg + "; failure mode is 'fail open'; continuing session without recording."
 			ap.log.Warn(msg)
-			ap.rp.ServeHTTP(w, r.WithContext(whoIsKey.WithValue(r.Context(), who)))
+			ap.rp.ServeHTTP(w, r)
 			return
 		}
 		ap.log.Error(msg)
@@ -279,7 +378,7 @@ func (ap *apiserverProxy) execForProto(w http.ResponseWriter, r *http.Request, p
 	}
 	h := ksr.New(opts)
 
-	ap.rp.ServeHTTP(h, r.WithContext(whoIsKey.WithValue(r.Context(), who)))
+	ap.rp.ServeHTTP(h, r)
 }
 
 func (h *apiserverProxy) addImpersonationHeadersAsRequired(r *http.Request) {
@@ -339,49 +438,57 @@ func (ap *apiserverProxy) authError(w http.ResponseWriter, err error) {
 func addImpersonationHeaders(r *http.Request, log *zap.SugaredLogger) error {
 	log = log.With("remote", r.RemoteAddr)
 	who := whoIsKey.Value(r.Context())
+	rules, err := kubernetesCapRules(who)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal capability: %v", err)
+	}
+
+	user, groups := impersonatedIdentity(who, rules)
+	for _, group := range groups {
+		r.Header.Add("Impersonate-Group", group)
+		log.Debugf("adding group impersonation header for group %s", group)
+	}
+	r.Header.Set("Impersonate-User", user)
+	log.Debugf("adding user impersonation header for user %s", user)
+	return nil
+}
+
+// kubernetesCapRules returns the Kubernetes capability rules granted to who.
+func kubernetesCapRules(who *apitype.WhoIsResponse) ([]kubetypes.KubernetesCapRule, error) {
 	rules, err := tailcfg.UnmarshalCapJSON[kubetypes.KubernetesCapRule](who.CapMap, tailcfg.PeerCapabilityKubernetes)
 	if len(rules) == 0 && err == nil {
 		// Try the old capability name for backwards compatibility.
 		rules, err = tailcfg.UnmarshalCapJSON[kubetypes.KubernetesCapRule](who.CapMap, oldCapabilityName)
 	}
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal capability: %v", err)
-	}
+	return rules, err
+}
 
+// impersonatedIdentity returns the Kubernetes user and groups that requests
+// from who are impersonated as, given the caller's capability rules.
+func impersonatedIdentity(who *apitype.WhoIsResponse, rules []kubetypes.KubernetesCapRule) (user string, groups []string) {
 	var groupsAdded set.Slice[string]
 	for _, rule := range rules {
 		if rule.Impersonate == nil {
 			continue
 		}
 		for _, group := range rule.Impersonate.Groups {
-			if groupsAdded.Contains(group) {
-				continue
-			}
-			r.Header.Add("Impersonate-Group", group)
 			groupsAdded.Add(group)
-			log.Debugf("adding group impersonation header for user group %s", group)
 		}
 	}
+	groups = groupsAdded.Slice().AsSlice()
 
 	if !who.Node.IsTagged() {
-		r.Header.Set("Impersonate-User", who.UserProfile.LoginName)
-		log.Debugf("adding user impersonation header for user %s", who.UserProfile.LoginName)
-		return nil
+		return who.UserProfile.LoginName, groups
 	}
 	// "Impersonate-Group" requires "Impersonate-User" to be set, so we set it
 	// to the node FQDN for tagged nodes.
-	nodeName := strings.TrimSuffix(who.Node.Name, ".")
-	r.Header.Set("Impersonate-User", nodeName)
-	log.Debugf("adding user impersonation header for node name %s", nodeName)
+	user = strings.TrimSuffix(who.Node.Name, ".")
 
 	// For legacy behavior (before caps), set the groups to the nodes tags.
-	if groupsAdded.Slice().Len() == 0 {
-		for _, tag := range who.Node.Tags {
-			r.Header.Add("Impersonate-Group", tag)
-			log.Debugf("adding group impersonation header for node tag %s", tag)
-		}
+	if len(groups) == 0 {
+		groups = who.Node.Tags
 	}
-	return nil
+	return user, groups
 }
 
 // determineRecorderConfig determines recorder config from requester's peer
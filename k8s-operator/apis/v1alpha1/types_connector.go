@@ -113,6 +113,20 @@ type ConnectorSpec struct {
 	// https://tailscale.com/kb/1103/exit-nodes
 	// +optional
 	ExitNode bool `json:"exitNode"`
+	// Replicas specifies how many devices to create for this Connector.
+	// Set this to a value greater than 1 to run the Connector in high
+	// availability mode. Each replica is configured with the same subnet
+	// routes, exit node or app connector configuration, so that traffic
+	// fails over to another replica if one of them becomes unavailable.
+	// Replicas are updated one at a time, and a replica is only replaced
+	// once the previously updated one is connected to the tailnet.
+	// https://tailscale.com/kb/1115/high-availability
+	// If replicas is greater than 1, the replicas are given the hostnames
+	// <hostname>-0, <hostname>-1 etc.
+	// Defaults to 1.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
 }
 
 // SubnetRouter defines subnet routes that should be exposed to tailnet via a
@@ -193,13 +207,24 @@ type ConnectorStatus struct {
 	IsAppConnector bool `json:"isAppConnector"`
 	// TailnetIPs is the set of tailnet IP addresses (both IPv4 and IPv6)
 	// assigned to the Connector node.
+	// Only set for Connectors with a single replica, see devices for the
+	// IP addresses of each replica.
 	// +optional
 	TailnetIPs []string `json:"tailnetIPs,omitempty"`
 	// Hostname is the fully qualified domain name of the Connector node.
 	// If MagicDNS is enabled in your tailnet, it is the MagicDNS name of the
 	// node.
+	// Only set for Connectors with a single replica, see devices for the
+	// hostname of each replica.
 	// +optional
 	Hostname string `json:"hostname,omitempty"`
+	// Devices is the list of tailnet devices created for the Connector's
+	// replicas, in order of the replica index. A replica's device is only
+	// listed once it has authenticated to the tailnet.
+	// +listType=map
+	// +listMapKey=hostname
+	// +optional
+	Devices []TailnetDevice `json:"devices,omitempty"`
 }
 
 type ConditionType string
@@ -210,6 +235,12 @@ type ConnectorStatus struct {
 	ProxyGroupReady ConditionType = `ProxyGroupReady`
 	ProxyReady      ConditionType = `TailscaleProxyReady` // a Tailscale-specific condition type for corev1.Service
 	RecorderReady   ConditionType = `RecorderReady`
+	// ServiceExportReady gets set on a ServiceExport. Set to true once the
+	// exported Service has been exposed to the tailnet as a Tailscale Service.
+	ServiceExportReady ConditionType = `ServiceExportReady`
+	// ServiceImportReady gets set on a ServiceImport. Set to true once the
+	// imported Service is ready to route cluster traffic.
+	ServiceImportReady ConditionType = `ServiceImportReady`
 	// EgressSvcValid gets set on a user configured ExternalName Service that defines a tailnet target to be exposed
 	// on a ProxyGroup.
 	// Set to true if the user provided configuration is valid.
@@ -6,6 +6,7 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -48,6 +49,7 @@ type ProxyGroupList struct {
 	Items []ProxyGroup `json:"items"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!(has(self.replicas) && has(self.autoscaling))",message="The replicas field is mutually exclusive with the autoscaling field."
 type ProxyGroupSpec struct {
 	// Type of the ProxyGroup proxies. Supported types are egress and ingress.
 	// Type is immutable once a ProxyGroup is created.
@@ -69,6 +71,12 @@ type ProxyGroupSpec struct {
 	// +kubebuilder:validation:Minimum=0
 	Replicas *int32 `json:"replicas,omitempty"`
 
+	// Autoscaling configures the operator to scale the number of ProxyGroup
+	// replicas between a minimum and a maximum, based on the load reported
+	// by each replica's metrics endpoint. Mutually exclusive with Replicas.
+	// +optional
+	Autoscaling *ProxyGroupAutoscaling `json:"autoscaling,omitempty"`
+
 	// HostnamePrefix is the hostname prefix to use for tailnet devices created
 	// by the ProxyGroup. Each device will have the integer number from its
 	// StatefulSet pod appended to this prefix to form the full hostname.
@@ -99,6 +107,69 @@ type ProxyGroupStatus struct {
 	// +listMapKey=hostname
 	// +optional
 	Devices []TailnetDevice `json:"devices,omitempty"`
+
+	// Autoscaling describes the state of the ProxyGroup's autoscaling, if
+	// autoscaling is configured.
+	// +optional
+	Autoscaling *ProxyGroupAutoscalingStatus `json:"autoscaling,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:rule="has(self.targetConnectionsPerReplica) || has(self.targetThroughputPerReplica)",message="At least one of targetConnectionsPerReplica or targetThroughputPerReplica must be set."
+// +kubebuilder:validation:XValidation:rule="!has(self.minReplicas) || self.minReplicas <= self.maxReplicas",message="minReplicas must not be greater than maxReplicas."
+type ProxyGroupAutoscaling struct {
+	// MinReplicas is the lower limit for the number of replicas to which the
+	// ProxyGroup can be scaled down. Defaults to 1.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit for the number of replicas to which the
+	// ProxyGroup can be scaled up.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetConnectionsPerReplica is the average number of connections
+	// tracked by each replica that the operator will aim for when scaling
+	// the ProxyGroup.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TargetConnectionsPerReplica *int64 `json:"targetConnectionsPerReplica,omitempty"`
+
+	// TargetThroughputPerReplica is the average throughput in bytes per
+	// second, summed across both directions, that the operator will aim for
+	// when scaling the ProxyGroup, for example 50Mi.
+	// +optional
+	TargetThroughputPerReplica *resource.Quantity `json:"targetThroughputPerReplica,omitempty"`
+
+	// ScaleDownStabilizationWindow is the period of time for which the
+	// operator considers past replica count recommendations when scaling
+	// down, to avoid flapping when load fluctuates. Scaling up is not
+	// delayed. Defaults to 5m.
+	// +optional
+	ScaleDownStabilizationWindow *metav1.Duration `json:"scaleDownStabilizationWindow,omitempty"`
+}
+
+type ProxyGroupAutoscalingStatus struct {
+	// DesiredReplicas is the number of replicas that the operator has
+	// determined the ProxyGroup should be running.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+
+	// CurrentConnectionsPerReplica is the average number of connections
+	// tracked by each replica, as observed at the last scrape of the replicas'
+	// metrics endpoints.
+	// +optional
+	CurrentConnectionsPerReplica *int64 `json:"currentConnectionsPerReplica,omitempty"`
+
+	// CurrentThroughputPerReplica is the average throughput in bytes per
+	// second of each replica, as observed at the last scrape of the replicas'
+	// metrics endpoints.
+	// +optional
+	CurrentThroughputPerReplica *resource.Quantity `json:"currentThroughputPerReplica,omitempty"`
+
+	// LastScaleTime is the last time the operator changed the number of
+	// desired replicas.
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
 }
 
 type TailnetDevice struct {
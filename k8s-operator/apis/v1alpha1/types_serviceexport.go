@@ -0,0 +1,81 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the ServiceExport CRD i.e. if someone runs kubectl explain serviceexport.
+
+var ServiceExportKind = "ServiceExport"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=svcexport
+// +kubebuilder:printcolumn:name="TailscaleService",type="string",JSONPath=`.status.tailscaleService`,description="Name of the Tailscale Service that the Service is exported as."
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "ServiceExportReady")].reason`,description="Status of the export."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ServiceExport exports the Service with the same name in the same namespace
+// to the tailnet as a Tailscale Service, so that it can be imported into other
+// clusters using a ServiceImport.
+//
+// The Service is exposed on the proxies of an ingress ProxyGroup and the
+// Tailscale Service is named svc:<namespace>-<name>-<n>, where <n> is the
+// length of the namespace. If multiple clusters export a Service with the
+// same name in the same namespace, tailnet traffic for the Tailscale
+// Service is load balanced across all of them.
+type ServiceExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec describes how the Service should be exported.
+	Spec ServiceExportSpec `json:"spec"`
+
+	// ServiceExportStatus describes the status of the export. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status ServiceExportStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type ServiceExportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ServiceExport `json:"items"`
+}
+
+type ServiceExportSpec struct {
+	// ProxyGroup is the name of the ingress ProxyGroup whose proxies will
+	// expose the Service to the tailnet.
+	// +kubebuilder:validation:MinLength=1
+	ProxyGroup string `json:"proxyGroup"`
+
+	// Tags that the Tailscale Service will be tagged with. Defaults to the
+	// operator's default proxy tags. If you specify custom tags here, make
+	// sure you also make the operator an owner of these tags.
+	// Tag values must be in form ^tag:[a-zA-Z][a-zA-Z0-9-]*$.
+	// +optional
+	Tags Tags `json:"tags,omitempty"`
+}
+
+type ServiceExportStatus struct {
+	// List of status conditions to indicate the status of the export.
+	// Known condition types are `ServiceExportReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions"`
+
+	// TailscaleService is the name of the Tailscale Service that the
+	// Service is exported as, for example svc:default-my-app-7.
+	// +optional
+	TailscaleService string `json:"tailscaleService,omitempty"`
+}
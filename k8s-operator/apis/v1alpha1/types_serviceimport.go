@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Code comments on these types should be treated as user facing documentation-
+// they will appear on the ServiceImport CRD i.e. if someone runs kubectl explain serviceimport.
+
+var ServiceImportKind = "ServiceImport"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=svcimport
+// +kubebuilder:printcolumn:name="TailscaleService",type="string",JSONPath=`.status.tailscaleService`,description="Name of the imported Tailscale Service."
+// +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.conditions[?(@.type == "ServiceImportReady")].reason`,description="Status of the import."
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ServiceImport imports a Service that another cluster has exported with a
+// ServiceExport of the same name in the same namespace.
+//
+// The operator creates a Service with the same name as the ServiceImport that
+// cluster workloads can use to reach the exported Service. Traffic is routed
+// to the tailnet via the proxies of an egress ProxyGroup.
+type ServiceImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec describes how the Service should be imported.
+	Spec ServiceImportSpec `json:"spec"`
+
+	// ServiceImportStatus describes the status of the import. This is set
+	// and managed by the Tailscale operator.
+	// +optional
+	Status ServiceImportStatus `json:"status"`
+}
+
+// +kubebuilder:object:root=true
+
+type ServiceImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ServiceImport `json:"items"`
+}
+
+type ServiceImportSpec struct {
+	// ProxyGroup is the name of the egress ProxyGroup whose proxies will
+	// route cluster traffic to the imported Service.
+	// +kubebuilder:validation:MinLength=1
+	ProxyGroup string `json:"proxyGroup"`
+
+	// Ports to expose on the imported Service. Defaults to the ports of the
+	// exported Service.
+	// +optional
+	Ports []ServiceImportPort `json:"ports,omitempty"`
+}
+
+type ServiceImportPort struct {
+	// Name of the port. Must be set if there are multiple ports.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Protocol of the port. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP
+	// +kubebuilder:default=TCP
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+
+	// Port is the port number of the exported Service.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+}
+
+type ServiceImportStatus struct {
+	// List of status conditions to indicate the status of the import.
+	// Known condition types are `ServiceImportReady`.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions"`
+
+	// TailscaleService is the name of the imported Tailscale Service, for
+	// example svc:default-my-app-7.
+	// +optional
+	TailscaleService string `json:"tailscaleService,omitempty"`
+
+	// TailnetIPs is the set of tailnet IP addresses of the imported
+	// Tailscale Service.
+	// +optional
+	TailnetIPs []string `json:"tailnetIPs,omitempty"`
+}
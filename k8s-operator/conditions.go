@@ -91,6 +91,22 @@ func SetProxyGroupCondition(pg *tsapi.ProxyGroup, conditionType tsapi.ConditionT
 	pg.Status.Conditions = conds
 }
 
+// SetServiceExportCondition ensures that ServiceExport status has a condition
+// with the given attributes. LastTransitionTime gets set every time
+// condition's status changes.
+func SetServiceExportCondition(se *tsapi.ServiceExport, conditionType tsapi.ConditionType, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) {
+	conds := updateCondition(se.Status.Conditions, conditionType, status, reason, message, gen, clock, logger)
+	se.Status.Conditions = conds
+}
+
+// SetServiceImportCondition ensures that ServiceImport status has a condition
+// with the given attributes. LastTransitionTime gets set every time
+// condition's status changes.
+func SetServiceImportCondition(si *tsapi.ServiceImport, conditionType tsapi.ConditionType, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) {
+	conds := updateCondition(si.Status.Conditions, conditionType, status, reason, message, gen, clock, logger)
+	si.Status.Conditions = conds
+}
+
 func updateCondition(conds []metav1.Condition, conditionType tsapi.ConditionType, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) []metav1.Condition {
 	newCondition := metav1.Condition{
 		Type:               string(conditionType),
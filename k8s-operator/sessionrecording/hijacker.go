@@ -63,7 +63,18 @@ func New(opts HijackerOpts) *Hijacker {
 		failOpen:          opts.FailOpen,
 		proto:             opts.Proto,
 		log:               opts.Log,
-		connectToRecorder: sessionrecording.ConnectToRecorder,
+		connectToRecorder: connectWithEnvOptions(opts.Log, opts.FailOpen),
+	}
+}
+
+// connectWithEnvOptions returns a RecorderDialFn that connects to recorders
+// with the sessionrecording.ConnectOptions set in the environment. Unless
+// failOpen is set, a failed upload to any one recorder fails the recording.
+func connectWithEnvOptions(log *zap.SugaredLogger, failOpen bool) RecorderDialFn {
+	return func(ctx context.Context, addrs []netip.AddrPort, dial netx.DialFunc) (io.WriteCloser, []*tailcfg.SSHRecordingAttempt, <-chan error, error) {
+		opts := sessionrecording.ConnectOptionsFromEnv(log.Infof)
+		opts.RequireAll = !failOpen
+		return sessionrecording.Connect(ctx, addrs, dial, opts)
 	}
 }
 
@@ -100,9 +111,10 @@ type Hijacker struct {
 
 // RecorderDialFn dials the specified netip.AddrPorts that should be tsrecorder
 // addresses. It tries to connect to recorder endpoints one by one, till one
-// connection succeeds. In case of success, returns a list with a single
-// successful recording attempt and an error channel. If the connection errors
-// after having been established, an error is sent down the channel.
+// connection succeeds, or to all of them if TS_SESSION_RECORDING_FAN_OUT is
+// set. In case of success, returns a list with a single successful recording
+// attempt and an error channel. If the connection errors after having been
+// established, an error is sent down the channel.
 type RecorderDialFn func(context.Context, []netip.AddrPort, netx.DialFunc) (io.WriteCloser, []*tailcfg.SSHRecordingAttempt, <-chan error, error)
 
 // Hijack hijacks a 'kubectl exec' session and configures for the session
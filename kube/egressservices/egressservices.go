@@ -11,6 +11,7 @@
 import (
 	"encoding/json"
 	"net/netip"
+	"time"
 )
 
 const (
@@ -36,6 +37,27 @@ type Config struct {
 	TailnetTarget TailnetTarget `json:"tailnetTarget"`
 	// Ports contains mappings for ports that can be accessed on the tailnet target.
 	Ports PortMaps `json:"ports"`
+	// Backends, if set, are multiple tailnet targets that new connections
+	// for this service are load balanced across in proportion to their
+	// weights. TailnetTarget is ignored if Backends is set. Weighted
+	// backends are only supported in nftables firewall mode.
+	Backends []Backend `json:"backends,omitempty"`
+	// Drain, if true, makes the proxy drain backends that are removed from
+	// Backends: a removed backend does not receive any new connections, but
+	// connections already established to it keep their DNAT mapping and the
+	// proxy reports the backend as draining in its status until the
+	// connections have been closed or have expired from conntrack.
+	Drain bool `json:"drain,omitempty"`
+}
+
+// Backend is a weighted tailnet target of an egress service.
+type Backend struct {
+	// TailnetTarget is the tailnet target to which the backend's share of
+	// connections should be proxied.
+	TailnetTarget TailnetTarget `json:"tailnetTarget"`
+	// Weight is the relative share of new connections that the backend
+	// receives. A Weight of 0 is treated as 1.
+	Weight uint32 `json:"weight,omitempty"`
 }
 
 // TailnetTarget is the tailnet target to which traffic for the egress service
@@ -107,4 +129,19 @@ type ServiceStatus struct {
 	// is the same as IP.
 	TailnetTargetIPs []netip.Addr  `json:"tailnetTargetIPs"`
 	TailnetTarget    TailnetTarget `json:"tailnetTarget"`
+	// Backends are the weighted backends that were used to configure these
+	// firewall rules, if any.
+	Backends []Backend `json:"backends,omitempty"`
+	// Draining are the tailnet target IPs of backends that have been
+	// removed, but still have connections established via this proxy.
+	Draining []DrainingTarget `json:"draining,omitempty"`
+}
+
+// DrainingTarget is the tailnet IP of a removed backend of an egress service
+// that does not receive new connections, but that is kept configured until the
+// connections established to it are closed.
+type DrainingTarget struct {
+	IP netip.Addr `json:"ip"`
+	// Since is when the proxy started draining the target.
+	Since time.Time `json:"since"`
 }
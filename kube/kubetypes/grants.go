@@ -40,6 +40,18 @@ type KubernetesCapRule struct {
 	// session recorder.
 	// https://tailscale.com/kb/1246/tailscale-ssh-session-recording#turn-on-session-recording-in-acls
 	EnforceRecorder bool `json:"enforceRecorder,omitempty"`
+	// Allow is a list of rules for Kubernetes API server requests that a
+	// client matching `src` of this grant may make via an API server proxy
+	// matching `dst` of this grant. If any of the client's grants contain
+	// allow rules, the proxy rejects resource requests that don't match at
+	// least one of them. The proxy enforces these rules in addition to
+	// Kubernetes RBAC.
+	Allow []KubernetesAPIRule `json:"allow,omitempty"`
+	// Deny is a list of rules for Kubernetes API server requests that a
+	// client matching `src` of this grant must not make via an API server
+	// proxy matching `dst` of this grant. Deny rules take precedence over
+	// allow rules.
+	Deny []KubernetesAPIRule `json:"deny,omitempty"`
 }
 
 // ImpersonateRule defines how a request from the tailnet identity matching
@@ -53,3 +65,22 @@ type ImpersonateRule struct {
 	// https://kubernetes.io/docs/reference/access-authn-authz/rbac/#referring-to-subjects
 	Groups []string `json:"groups,omitempty"`
 }
+
+// KubernetesAPIRule matches Kubernetes API server resource requests. An empty
+// field matches any value and "*" can be used as a wildcard in any field. A
+// request matches the rule if it matches all of the rule's fields.
+type KubernetesAPIRule struct {
+	// Namespaces is a list of namespaces. Requests for cluster-scoped
+	// resources only match if Namespaces is empty or contains "*", except
+	// that deny rules also match collection requests across all
+	// namespaces (such as list or watch without a namespace), since those
+	// include the listed namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Verbs is a list of Kubernetes API verbs, such as get, list, watch,
+	// create, update, patch, delete and deletecollection.
+	Verbs []string `json:"verbs,omitempty"`
+	// Resources is a list of resources, such as pods or deployments.
+	// Subresources must be listed explicitly, for example pods/exec or
+	// pods/log. "pods/*" matches all subresources of pods.
+	Resources []string `json:"resources,omitempty"`
+}
@@ -19,6 +19,7 @@
 	MetricIngressResourceCount           = "k8s_ingress_resources"    // L7
 	MetricIngressPGResourceCount         = "k8s_ingress_pg_resources" // L7 on ProxyGroup
 	MetricServicePGResourceCount         = "k8s_service_pg_resources" // L3 on ProxyGroup
+	MetricGatewayResourceCount           = "k8s_gateway_resources"    // L4/L7 on ProxyGroup
 	MetricEgressProxyCount               = "k8s_egress_proxies"
 	MetricConnectorResourceCount         = "k8s_connector_resources"
 	MetricConnectorWithSubnetRouterCount = "k8s_connector_subnetrouter_resources"
@@ -50,6 +51,12 @@
 
 	EgessServicesPreshutdownEP = "/internal-egress-services-preshutdown"
 
+	// MetricProxyConnections is the name of a gauge served by containerboot
+	// alongside tailscaled's user metrics. It reports the number of connections
+	// tracked by the kernel in the proxy's network namespace, and is used by the
+	// operator to autoscale ProxyGroups.
+	MetricProxyConnections = "tailscale_proxy_connections"
+
 	LabelManaged    = "tailscale.com/managed"
 	LabelSecretType = "tailscale.com/secret-type" // "config", "state" "certs"
 )
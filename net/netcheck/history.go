@@ -0,0 +1,154 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/logger"
+)
+
+// DefaultHistorySize is the number of reports a History keeps if no size is
+// given to NewHistory.
+const DefaultHistorySize = 100
+
+// historyRecordInterval is how long History goes without recording a report
+// when the network conditions that reports describe don't change, so that the
+// history still shows how long they have been stable.
+const historyRecordInterval = time.Hour
+
+// historySaveDelay is how long History waits after a report is added before
+// writing its file, so that a burst of reports, which can come seconds apart,
+// only costs one write.
+const historySaveDelay = time.Minute
+
+// History is a bounded record of recent reports, oldest first, optionally
+// persisted as JSON to a file so that it survives restarts. It's meant for
+// spotting changes in network conditions over time, such as a NAT whose
+// mapping behavior flaps, so it only records reports that differ from the
+// previous one in a way that matters for NAT traversal, plus one report per
+// historyRecordInterval while nothing changes.
+//
+// It is safe for concurrent use.
+type History struct {
+	logf logger.Logf
+	path string // or empty to not persist
+	size int
+
+	mu        sync.Mutex
+	reports   []*Report
+	saveTimer *time.Timer // non-nil if a save is scheduled
+}
+
+// NewHistory returns a History of up to size reports (or
+// DefaultHistorySize, if size is not positive), persisted to the file path,
+// if non-empty. Any reports already in the file are loaded; if it can't be
+// read, the history starts out empty.
+func NewHistory(logf logger.Logf, path string, size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h := &History{
+		logf: logger.WithPrefix(logf, "netcheck history: "),
+		path: path,
+		size: size,
+	}
+	if path == "" {
+		return h
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			h.logf("%v", err)
+		}
+		return h
+	}
+	var reports []*Report
+	if err := json.Unmarshal(b, &reports); err != nil {
+		h.logf("decoding %s: %v", path, err)
+		return h
+	}
+	if len(reports) > size {
+		reports = reports[len(reports)-size:]
+	}
+	h.reports = reports
+	return h
+}
+
+// Add records a copy of r as the newest report, evicting the oldest report
+// if the history is full. If r describes the same network conditions as the
+// newest recorded report, it's only recorded if that report is at least
+// historyRecordInterval older than r.
+func (h *History) Add(r *Report) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.reports); n > 0 {
+		last := h.reports[n-1]
+		if sameNATConditions(last, r) && r.Now.Sub(last.Now) < historyRecordInterval {
+			return
+		}
+	}
+	h.reports = append(h.reports, r.Clone())
+	if n := len(h.reports) - h.size; n > 0 {
+		clear(h.reports[:n])
+		h.reports = h.reports[n:]
+	}
+	if h.path != "" && h.saveTimer == nil {
+		h.saveTimer = time.AfterFunc(historySaveDelay, h.save)
+	}
+}
+
+// sameNATConditions reports whether a and b agree on the fields of a report
+// that matter for NAT traversal.
+func sameNATConditions(a, b *Report) bool {
+	return a.UDP == b.UDP &&
+		a.IPv4 == b.IPv4 &&
+		a.IPv6 == b.IPv6 &&
+		a.MappingVariesByDestIP == b.MappingVariesByDestIP &&
+		a.PreferredDERP == b.PreferredDERP
+}
+
+// Reports returns copies of the recorded reports, oldest first.
+func (h *History) Reports() []*Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]*Report, len(h.reports))
+	for i, r := range h.reports {
+		ret[i] = r.Clone()
+	}
+	return ret
+}
+
+// Flush writes any reports added since the history was last saved to its
+// file now, rather than waiting for the pending save. It should be called
+// when shutting down.
+func (h *History) Flush() {
+	h.mu.Lock()
+	pending := h.saveTimer != nil && h.saveTimer.Stop()
+	h.mu.Unlock()
+	if pending {
+		h.save()
+	}
+}
+
+// save writes the history to h.path.
+func (h *History) save() {
+	h.mu.Lock()
+	h.saveTimer = nil
+	b, err := json.Marshal(h.reports)
+	h.mu.Unlock()
+	if err != nil {
+		h.logf("encoding: %v", err)
+		return
+	}
+	if err := atomicfile.WriteFile(h.path, b, 0600); err != nil {
+		h.logf("%v", err)
+	}
+}
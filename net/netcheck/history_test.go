@@ -0,0 +1,115 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/types/opt"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netcheck-history.json")
+	h := NewHistory(t.Logf, path, 3)
+
+	start := time.Unix(1729624521, 0).UTC()
+	for i := range 5 {
+		h.Add(&Report{
+			Now:                   start.Add(time.Duration(i) * time.Minute),
+			UDP:                   true,
+			MappingVariesByDestIP: opt.NewBool(i%2 == 1),
+			PreferredDERP:         1,
+			RegionLatency:         map[int]time.Duration{1: 10 * time.Millisecond},
+			GlobalV4:              netip.MustParseAddrPort("192.0.2.1:41641"),
+			GlobalV4Counters:      map[netip.AddrPort]int{netip.MustParseAddrPort("192.0.2.1:41641"): 2},
+			STUNServers: map[string]STUNServerResult{
+				"stun.example.com:3478": {V4Latency: 20 * time.Millisecond, GlobalV4: netip.MustParseAddrPort("192.0.2.1:41641")},
+			},
+		})
+	}
+	got := h.Reports()
+	if len(got) != 3 {
+		t.Fatalf("got %d reports; want 3", len(got))
+	}
+	for i, r := range got {
+		if want := start.Add(time.Duration(i+2) * time.Minute); !r.Now.Equal(want) {
+			t.Errorf("report %d: Now = %v; want %v", i, r.Now, want)
+		}
+	}
+
+	// Modifying the returned reports must not affect the history.
+	got[0].RegionLatency[1] = 0
+	if h.Reports()[0].RegionLatency[1] == 0 {
+		t.Error("Reports returned a report shared with the history")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("history saved before delay; stat error = %v", err)
+	}
+	h.Flush()
+	h2 := NewHistory(t.Logf, path, 2)
+	// Compare as JSON, as unset opt.Bools don't round-trip exactly.
+	gotJSON, _ := json.Marshal(h2.Reports())
+	wantJSON, _ := json.Marshal(h.Reports()[1:])
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("loaded history = %s; want %s", gotJSON, wantJSON)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := NewHistory(t.Logf, path, 2).Reports(); len(got) != 0 {
+		t.Errorf("history loaded from corrupt file has %d reports; want 0", len(got))
+	}
+}
+
+func TestHistorySkipsUnchangedReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netcheck-history.json")
+	h := NewHistory(t.Logf, path, 10)
+	start := time.Unix(1729624521, 0).UTC()
+	report := func(d time.Duration, preferredDERP int) *Report {
+		return &Report{
+			Now:                   start.Add(d),
+			UDP:                   true,
+			IPv4:                  true,
+			MappingVariesByDestIP: opt.NewBool(false),
+			PreferredDERP:         preferredDERP,
+			RegionLatency:         map[int]time.Duration{1: d},
+		}
+	}
+
+	h.Add(report(0, 1))
+	h.Flush()
+	// Reports that only differ in latencies are not recorded, and don't
+	// cause the history to be saved again.
+	h.Add(report(time.Minute, 1))
+	h.Add(report(2*time.Minute, 1))
+	h.mu.Lock()
+	saveScheduled := h.saveTimer != nil
+	h.mu.Unlock()
+	if saveScheduled {
+		t.Error("save scheduled for unchanged reports")
+	}
+	// A change in the preferred DERP is recorded.
+	h.Add(report(3*time.Minute, 2))
+	// So is an unchanged report once historyRecordInterval has passed.
+	h.Add(report(3*time.Minute+historyRecordInterval, 2))
+
+	var got []time.Duration
+	for _, r := range h.Reports() {
+		got = append(got, r.Now.Sub(start))
+	}
+	want := []time.Duration{0, 3 * time.Minute, 3*time.Minute + historyRecordInterval}
+	if !slices.Equal(got, want) {
+		t.Errorf("recorded reports at %v; want %v", got, want)
+	}
+	h.Flush()
+}
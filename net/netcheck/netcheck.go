@@ -18,6 +18,7 @@
 	"net/http"
 	"net/netip"
 	"runtime"
+	"slices"
 	"sort"
 	"sync"
 	"syscall"
@@ -124,6 +125,10 @@ type Report struct {
 	// intercepting HTTP traffic.
 	CaptivePortal opt.Bool
 
+	// STUNServers holds the results of probing [Client.STUNServers], keyed
+	// by server "host:port". Servers that didn't reply are absent.
+	STUNServers map[string]STUNServerResult `json:",omitempty"`
+
 	// TODO: update Clone when adding new fields
 }
 
@@ -179,6 +184,7 @@ func (r *Report) Clone() *Report {
 	r2.RegionV6Latency = maps.Clone(r2.RegionV6Latency)
 	r2.GlobalV4Counters = maps.Clone(r2.GlobalV4Counters)
 	r2.GlobalV6Counters = maps.Clone(r2.GlobalV6Counters)
+	r2.STUNServers = maps.Clone(r2.STUNServers)
 	return &r2
 }
 
@@ -230,6 +236,21 @@ type Client struct {
 	// the DERP is found to be reachable.
 	ForcePreferredDERP int
 
+	// AvoidDERPRegions are DERP regions not to pick as the preferred one
+	// unless no other region is reachable.
+	AvoidDERPRegions []int
+
+	// STUNServers are additional STUN servers to probe alongside the DERP
+	// map's, each as "host:port" or just "host" for the default STUN port.
+	// Their replies count towards the report's global addresses and
+	// MappingVariesByDestIP, and are recorded in [Report.STUNServers], but
+	// they never affect DERP region latencies or the preferred DERP.
+	STUNServers []string
+
+	// History, if non-nil, records reports as network conditions change.
+	// See SetHistory.
+	History *History
+
 	// For tests
 	testEnoughRegions      int
 	testCaptivePortalDelay time.Duration
@@ -667,7 +688,15 @@ func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort
 	ret := rs.report
 
 	ret.UDP = true
-	updateLatency(ret.RegionLatency, node.RegionID, d)
+
+	// Nodes for Client.STUNServers aren't DERP servers, so they only
+	// contribute to what we learn about our own addresses.
+	isSTUNServer := node.RegionID == stunServerRegionID
+	if isSTUNServer {
+		addSTUNServerResult(ret, node, ipp, d)
+	} else {
+		updateLatency(ret.RegionLatency, node.RegionID, d)
+	}
 
 	// Once we've heard from enough regions (3), start a timer to
 	// give up on the other ones. The timer's duration is a
@@ -675,7 +704,7 @@ func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort
 	// incremental one. For incremental ones, wait for the
 	// duration of the slowest region. For initial ones, double
 	// that.
-	if len(ret.RegionLatency) == rs.c.enoughRegions() {
+	if !isSTUNServer && len(ret.RegionLatency) == rs.c.enoughRegions() {
 		timeout := maxDurationValue(ret.RegionLatency)
 		if !rs.incremental {
 			timeout *= 2
@@ -685,14 +714,18 @@ func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort
 
 	switch {
 	case ipp.Addr().Is6():
-		updateLatency(ret.RegionV6Latency, node.RegionID, d)
+		if !isSTUNServer {
+			updateLatency(ret.RegionV6Latency, node.RegionID, d)
+		}
 		ret.IPv6 = true
 		ret.GlobalV6 = ipp
 		mak.Set(&ret.GlobalV6Counters, ipp, ret.GlobalV6Counters[ipp]+1)
 		// TODO: track MappingVariesByDestIP for IPv6
 		// too? Would be sad if so, but who knows.
 	case ipp.Addr().Is4():
-		updateLatency(ret.RegionV4Latency, node.RegionID, d)
+		if !isSTUNServer {
+			updateLatency(ret.RegionV4Latency, node.RegionID, d)
+		}
 		ret.IPv4 = true
 		mak.Set(&ret.GlobalV4Counters, ipp, ret.GlobalV4Counters[ipp]+1)
 		if !rs.gotEP4.IsValid() {
@@ -786,6 +819,22 @@ func (c *Client) SetForcePreferredDERP(region int) {
 	c.ForcePreferredDERP = region
 }
 
+// SetAvoidDERPRegions sets the DERP regions not to pick as the preferred one
+// unless no other region is reachable.
+func (c *Client) SetAvoidDERPRegions(regions []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.AvoidDERPRegions = regions
+}
+
+// SetHistory sets the History that subsequent reports are recorded to, or
+// nil to stop recording them.
+func (c *Client) SetHistory(h *History) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.History = h
+}
+
 // GetReport gets a report. The 'opts' argument is optional and can be nil.
 // Callers are discouraged from passing a ctx with an arbitrary deadline as this
 // may cause GetReport to return prematurely before all reporting methods have
@@ -821,6 +870,10 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 	if c.NetMon == nil {
 		return nil, errors.New("netcheck: GetReport: Client.NetMon is nil")
 	}
+	stunServers, err := parseSTUNServers(c.STUNServers)
+	if err != nil {
+		return nil, fmt.Errorf("netcheck: GetReport: %w", err)
+	}
 
 	c.mu.Lock()
 	if c.curState != nil {
@@ -901,8 +954,10 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 	}
 
 	var plan probePlan
+	probeDM := dm
 	if opts == nil || !opts.OnlyTCP443 {
 		plan = makeProbePlan(dm, ifState, last, preferredDERP)
+		probeDM = addSTUNServerProbes(plan, dm, stunServers, ifState)
 	}
 
 	// If we're doing a full probe, also check for a captive portal. We
@@ -950,7 +1005,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap, opts *GetRe
 		setCtx, cancelSet := context.WithCancel(ctx)
 		go func(probeSet []probe) {
 			for _, probe := range probeSet {
-				go rs.runProbe(setCtx, dm, probe, cancelSet)
+				go rs.runProbe(setCtx, probeDM, probe, cancelSet)
 			}
 			<-setCtx.Done()
 			wg.Decr()
@@ -1056,6 +1111,13 @@ func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Rep
 	c.addReportHistoryAndSetPreferredDERP(rs, report, dm.View())
 	c.logConciseReport(report, dm)
 
+	c.mu.Lock()
+	h := c.History
+	c.mu.Unlock()
+	if h != nil {
+		h.Add(report)
+	}
+
 	return report
 }
 
@@ -1327,6 +1389,15 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		if c.ForcePreferredDERP != 0 {
 			fmt.Fprintf(w, " force=%v", c.ForcePreferredDERP)
 		}
+		if len(r.STUNServers) > 0 {
+			fmt.Fprintf(w, " stun=")
+			for i, hp := range slices.Sorted(maps.Keys(r.STUNServers)) {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s:%v", hp, r.STUNServers[hp].Latency().Round(time.Millisecond))
+			}
+		}
 		fmt.Fprintf(w, " derp=%v", r.PreferredDERP)
 		if r.PreferredDERP != 0 {
 			fmt.Fprintf(w, " derpdist=")
@@ -1435,6 +1506,8 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 	var (
 		bestAny             time.Duration // global minimum
 		oldRegionCurLatency time.Duration // latency of old PreferredDERP
+		bestAvoided         time.Duration // minimum among AvoidDERPRegions
+		bestAvoidedRegion   int
 	)
 	for regionID, d := range r.RegionLatency {
 		// Scale this report's latency by any scores provided by the
@@ -1449,11 +1522,23 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 			oldRegionCurLatency = d
 		}
 		best := bestRecent[regionID]
+		if slices.Contains(c.AvoidDERPRegions, regionID) {
+			if bestAvoidedRegion == 0 || best < bestAvoided {
+				bestAvoided = best
+				bestAvoidedRegion = regionID
+			}
+			continue
+		}
 		if r.PreferredDERP == 0 || best < bestAny {
 			bestAny = best
 			r.PreferredDERP = regionID
 		}
 	}
+	if r.PreferredDERP == 0 && bestAvoidedRegion != 0 {
+		// Only avoided regions are reachable; use the best of them.
+		bestAny = bestAvoided
+		r.PreferredDERP = bestAvoidedRegion
+	}
 
 	// If we're changing our preferred DERP, we want to add some stickiness
 	// to the current DERP region. We avoid changing if the old region is
@@ -1474,7 +1559,8 @@ func (c *Client) addReportHistoryAndSetPreferredDERP(rs *reportState, r *Report,
 	// The old region is accessible if we've heard from it via a non-STUN
 	// mechanism, or have a latency (and thus heard back via STUN).
 	oldRegionIsAccessible := oldRegionCurLatency != 0 || heardFromOldRegionRecently
-	if changingPreferred && oldRegionIsAccessible {
+	oldRegionIsAvoided := slices.Contains(c.AvoidDERPRegions, prevDERP) && !slices.Contains(c.AvoidDERPRegions, r.PreferredDERP)
+	if changingPreferred && oldRegionIsAccessible && !oldRegionIsAvoided {
 		// bestAny < any other value, so oldRegionCurLatency - bestAny >= 0
 		if oldRegionCurLatency-bestAny < preferredDERPAbsoluteDiff {
 			// The absolute value of latency difference is below
@@ -203,6 +203,7 @@ type step struct {
 		homeParams  *tailcfg.DERPHomeParams
 		opts        *GetReportOpts
 		forcedDERP  int // if non-zero, force this DERP to be the preferred one
+		avoidDERPs  []int
 		wantDERP    int // want PreferredDERP on final step
 		wantPrevLen int // wanted len(c.prev)
 	}{
@@ -420,6 +421,35 @@ type step struct {
 			wantPrevLen: 2,
 			wantDERP:    1,
 		},
+		{
+			name: "avoided",
+			steps: []step{
+				{0, report("d1", 2, "d2", 3)},
+			},
+			avoidDERPs:  []int{1},
+			wantPrevLen: 1,
+			wantDERP:    2,
+		},
+		{
+			name: "avoided_only_reachable",
+			steps: []step{
+				{0, report("d1", 2, "d2", 3)},
+				{time.Second, report("d1", 2)},
+			},
+			avoidDERPs:  []int{1},
+			wantPrevLen: 2,
+			wantDERP:    1,
+		},
+		{
+			name: "avoided_move_away",
+			steps: []step{
+				{0, report("d1", 2)},
+				{time.Second, report("d1", 2, "d2", 2100*time.Millisecond)},
+			},
+			avoidDERPs:  []int{1},
+			wantPrevLen: 2,
+			wantDERP:    2,
+		},
 		{
 			name: "no_data_keep_home",
 			steps: []step{
@@ -460,6 +490,7 @@ type step struct {
 			c := &Client{
 				TimeNow:            func() time.Time { return fakeTime },
 				ForcePreferredDERP: tt.forcedDERP,
+				AvoidDERPRegions:   tt.avoidDERPs,
 			}
 			dm := &tailcfg.DERPMap{HomeParams: tt.homeParams}
 			rs := &reportState{
@@ -1049,3 +1080,83 @@ func TestNoUDPNilGetReportOpts(t *testing.T) {
 		t.Fatal("unexpected working UDP")
 	}
 }
+
+func TestSTUNServers(t *testing.T) {
+	derpSTUN, cleanup := stuntest.Serve(t)
+	defer cleanup()
+	extraSTUN, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	c := newTestClient(t)
+	c.STUNServers = []string{extraSTUN.String()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Standalone(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.GetReport(ctx, stuntest.DERPMapOf(derpSTUN.String()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Collect(maps.Keys(r.RegionLatency)); !slices.Equal(got, []int{1}) {
+		t.Errorf("RegionLatency keys = %v; want [1]", got)
+	}
+	if r.PreferredDERP != 1 {
+		t.Errorf("PreferredDERP = %v; want 1", r.PreferredDERP)
+	}
+	res, ok := r.STUNServers[extraSTUN.String()]
+	if !ok {
+		t.Fatalf("no result for %v in STUNServers: %+v", extraSTUN, r.STUNServers)
+	}
+	if res.V4Latency <= 0 {
+		t.Errorf("V4Latency = %v; want > 0", res.V4Latency)
+	}
+	if res.GlobalV4 != r.GlobalV4 {
+		t.Errorf("GlobalV4 = %v; want %v", res.GlobalV4, r.GlobalV4)
+	}
+	if r.GlobalV4Counters[r.GlobalV4] != 2 {
+		t.Errorf("GlobalV4Counters = %v; want 2 observations of %v", r.GlobalV4Counters, r.GlobalV4)
+	}
+	if r.MappingVariesByDestIP != "false" {
+		t.Errorf("MappingVariesByDestIP = %q; want false", r.MappingVariesByDestIP)
+	}
+
+	c.STUNServers = []string{"example.com:http"}
+	if _, err := c.GetReport(ctx, stuntest.DERPMapOf(derpSTUN.String()), nil); err == nil {
+		t.Error("GetReport with invalid STUN server succeeded")
+	}
+}
+
+func TestSplitSTUNServer(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantHost string
+		wantPort int
+		wantErr  bool
+	}{
+		{in: "stun.example.com:3479", wantHost: "stun.example.com", wantPort: 3479},
+		{in: "stun.example.com", wantHost: "stun.example.com", wantPort: 3478},
+		{in: "192.0.2.1:19302", wantHost: "192.0.2.1", wantPort: 19302},
+		{in: "192.0.2.1", wantHost: "192.0.2.1", wantPort: 3478},
+		{in: "[2001:db8::1]:3479", wantHost: "2001:db8::1", wantPort: 3479},
+		{in: "2001:db8::1", wantHost: "2001:db8::1", wantPort: 3478},
+		{in: "", wantErr: true},
+		{in: ":3478", wantErr: true},
+		{in: "stun.example.com:0", wantErr: true},
+		{in: "stun.example.com:65536", wantErr: true},
+		{in: "stun.example.com:stun", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := splitSTUNServer(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitSTUNServer(%q) error = %v; want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("splitSTUNServer(%q) = %q, %d; want %q, %d", tt.in, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+)
+
+// STUNServerResult is the result of probing one of [Client.STUNServers].
+type STUNServerResult struct {
+	V4Latency time.Duration  `json:",omitempty"` // or 0 if no IPv4 reply
+	V6Latency time.Duration  `json:",omitempty"` // or 0 if no IPv6 reply
+	GlobalV4  netip.AddrPort `json:",omitzero"`  // our IPv4 address as seen by the server
+	GlobalV6  netip.AddrPort `json:",omitzero"`  // our IPv6 address as seen by the server
+}
+
+// Latency returns the lower of r's IPv4 and IPv6 latencies, ignoring
+// address families that didn't reply.
+func (r STUNServerResult) Latency() time.Duration {
+	if r.V4Latency == 0 || (r.V6Latency != 0 && r.V6Latency < r.V4Latency) {
+		return r.V6Latency
+	}
+	return r.V4Latency
+}
+
+// stunServerRegionID is the region ID of the fake DERP region that holds a
+// node for each of Client.STUNServers, so they can be probed like any other
+// STUN server. Real region IDs are positive.
+const stunServerRegionID = -1
+
+// defaultSTUNPort is the port STUN servers listen on if none is specified.
+const defaultSTUNPort = 3478
+
+// parseSTUNServers parses Client.STUNServers into STUN-only DERP nodes in
+// the fake region stunServerRegionID.
+func parseSTUNServers(servers []string) ([]*tailcfg.DERPNode, error) {
+	var nodes []*tailcfg.DERPNode
+	for i, s := range servers {
+		host, port, err := splitSTUNServer(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STUN server %q: %w", s, err)
+		}
+		n := &tailcfg.DERPNode{
+			Name:     fmt.Sprintf("stun-server-%d", i),
+			RegionID: stunServerRegionID,
+			HostName: host,
+			STUNPort: port,
+			STUNOnly: true,
+		}
+		// Don't make nodeAddrPort do a DNS lookup for IP literals, nor
+		// probe them over the other address family.
+		if ip, err := netip.ParseAddr(host); err == nil {
+			if ip.Is4() {
+				n.IPv4, n.IPv6 = host, "none"
+			} else {
+				n.IPv4, n.IPv6 = "none", host
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// splitSTUNServer splits a STUN server in the form "host:port", "host",
+// "[v6addr]:port" or "v6addr" into its host and port.
+func splitSTUNServer(s string) (host string, port int, err error) {
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.String(), defaultSTUNPort, nil
+	}
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port.
+		host, portStr = s, strconv.Itoa(defaultSTUNPort)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("missing host")
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 1<<16-1 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, port, nil
+}
+
+// stunServerKey returns the key of the STUN server node n in
+// Report.STUNServers.
+func stunServerKey(n *tailcfg.DERPNode) string {
+	return net.JoinHostPort(n.HostName, strconv.Itoa(n.STUNPort))
+}
+
+// addSTUNServerProbes adds probes of the STUN server nodes to plan, and
+// returns a DERP map in which to find them, which is dm plus a fake region
+// holding the nodes. If there are none, it returns dm.
+//
+// The STUN servers are always probed, with a few retries, so that every
+// report has a result for each of them.
+func addSTUNServerProbes(plan probePlan, dm *tailcfg.DERPMap, nodes []*tailcfg.DERPNode, ifState *netmon.State) *tailcfg.DERPMap {
+	if len(nodes) == 0 {
+		return dm
+	}
+	for _, n := range nodes {
+		var p4, p6 []probe
+		for try := range 3 {
+			delay := time.Duration(try) * defaultActiveRetransmitTime
+			if ifState.HaveV4 && nodeMight4(n) {
+				p4 = append(p4, probe{delay: delay, node: n.Name, proto: probeIPv4})
+			}
+			if ifState.HaveV6 && nodeMight6(n) {
+				p6 = append(p6, probe{delay: delay, node: n.Name, proto: probeIPv6})
+			}
+		}
+		if len(p4) > 0 {
+			plan[n.Name+"-v4"] = p4
+		}
+		if len(p6) > 0 {
+			plan[n.Name+"-v6"] = p6
+		}
+	}
+	dm2 := *dm
+	dm2.Regions = maps.Clone(dm.Regions)
+	mak.Set(&dm2.Regions, stunServerRegionID, &tailcfg.DERPRegion{
+		RegionID:        stunServerRegionID,
+		RegionCode:      "stun-servers",
+		NoMeasureNoHome: true,
+		Nodes:           nodes,
+	})
+	return &dm2
+}
+
+// addSTUNServerResult records the reply from the STUN server node n in r.
+func addSTUNServerResult(r *Report, n *tailcfg.DERPNode, ipp netip.AddrPort, d time.Duration) {
+	k := stunServerKey(n)
+	res := r.STUNServers[k]
+	switch {
+	case ipp.Addr().Is6():
+		if res.V6Latency == 0 || d < res.V6Latency {
+			res.V6Latency = d
+		}
+		res.GlobalV6 = ipp
+	case ipp.Addr().Is4():
+		if res.V4Latency == 0 || d < res.V4Latency {
+			res.V4Latency = d
+		}
+		res.GlobalV4 = ipp
+	}
+	mak.Set(&r.STUNServers, k, res)
+}
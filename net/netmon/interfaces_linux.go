@@ -8,6 +8,7 @@
 import (
 	"bufio"
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -28,6 +29,7 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	likelyHomeRouterIPv6 = likelyHomeRouterIPv6Linux
 }
 
 var procNetRouteErr atomic.Bool
@@ -122,6 +124,56 @@ func likelyHomeRouterIPLinux() (ret netip.Addr, myIP netip.Addr, ok bool) {
 	return netip.Addr{}, netip.Addr{}, false
 }
 
+var procNetIPv6RoutePath = "/proc/net/ipv6_route"
+
+/*
+Parse fe80::1 and eth0 out of the default route in:
+
+$ cat /proc/net/ipv6_route
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+*/
+func likelyHomeRouterIPv6Linux() (gw netip.Addr, ifName string, ok bool) {
+	lineNum := 0
+	var f []mem.RO
+	for lr := range lineiter.File(procNetIPv6RoutePath) {
+		line, err := lr.Value()
+		if err != nil {
+			return netip.Addr{}, "", false
+		}
+		lineNum++
+		if lineNum > maxProcNetRouteRead {
+			break
+		}
+		f = mem.AppendFields(f[:0], mem.B(line))
+		if len(f) < 10 {
+			continue
+		}
+		dstLen, nextHop, flagsHex := f[1], f[4], f[8]
+		if !dstLen.EqualString("00") || nextHop.Len() != 32 {
+			continue
+		}
+		flags, err := mem.ParseUint(flagsHex, 16, 32)
+		if err != nil {
+			continue
+		}
+		if flags&(unix.RTF_UP|unix.RTF_GATEWAY) != unix.RTF_UP|unix.RTF_GATEWAY {
+			continue
+		}
+		var a [16]byte
+		if _, err := hex.Decode(a[:], []byte(nextHop.StringCopy())); err != nil {
+			continue
+		}
+		gw = netip.AddrFrom16(a)
+		ifName = f[9].StringCopy()
+		if gw.IsLinkLocalUnicast() {
+			gw = gw.WithZone(ifName)
+		}
+		return gw, ifName, true
+	}
+	return netip.Addr{}, "", false
+}
+
 func defaultRoute() (d DefaultRouteDetails, err error) {
 	v, err := defaultRouteInterfaceProcNet()
 	if err == nil {
@@ -7,6 +7,7 @@
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
@@ -35,6 +36,27 @@ func TestGoogleCloudRunDefaultRouteInterface(t *testing.T) {
 	}
 }
 
+func TestLikelyHomeRouterIPv6Linux(t *testing.T) {
+	dir := t.TempDir()
+	tstest.Replace(t, &procNetIPv6RoutePath, filepath.Join(dir, "ipv6_route"))
+	buf := []byte("fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo\n" +
+		"00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0\n")
+	if err := os.WriteFile(procNetIPv6RoutePath, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gw, ifName, ok := likelyHomeRouterIPv6Linux()
+	if !ok {
+		t.Fatal("expected success")
+	}
+	if want := netip.MustParseAddr("fe80::1%eth0"); gw != want {
+		t.Errorf("gw = %v; want %v", gw, want)
+	}
+	if ifName != "eth0" {
+		t.Errorf("ifName = %q; want eth0", ifName)
+	}
+}
+
 // we read chunks of /proc/net/route at a time, test that files longer than the chunk
 // size can be handled.
 func TestExtremelyLongProcNetRoute(t *testing.T) {
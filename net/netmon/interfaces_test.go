@@ -125,6 +125,60 @@ func TestLikelyHomeRouterIP(t *testing.T) {
 	})
 }
 
+func TestLikelyHomeRouterIPv6(t *testing.T) {
+	ipnet := func(s string) net.Addr {
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipnet.IP = ip
+		return ipnet
+	}
+	tstest.Replace(t, &altNetInterfaces, func() ([]Interface, error) {
+		return []Interface{
+			{
+				Interface: &net.Interface{
+					Index: 1,
+					MTU:   1500,
+					Name:  "other0",
+					Flags: net.FlagUp | net.FlagBroadcast | net.FlagMulticast | net.FlagRunning,
+				},
+				AltAddrs: []net.Addr{
+					ipnet("2001:db8:1::100/64"),
+				},
+			},
+			{
+				Interface: &net.Interface{
+					Index: 2,
+					MTU:   1500,
+					Name:  "fake0",
+					Flags: net.FlagUp | net.FlagBroadcast | net.FlagMulticast | net.FlagRunning,
+				},
+				AltAddrs: []net.Addr{
+					ipnet("192.168.7.100/24"),
+					ipnet("fe80::100/64"),
+					ipnet("fd00::100/64"),
+					ipnet("2001:db8:2::100/64"),
+				},
+			},
+		}, nil
+	})
+	tstest.Replace(t, &likelyHomeRouterIPv6, func() (netip.Addr, string, bool) {
+		return netip.MustParseAddr("fe80::1%fake0"), "fake0", true
+	})
+
+	gw, my, ok := LikelyHomeRouterIPv6()
+	if !ok {
+		t.Fatal("expected success")
+	}
+	if want := netip.MustParseAddr("fe80::1%fake0"); gw != want {
+		t.Errorf("got gateway %v; want %v", gw, want)
+	}
+	if want := netip.MustParseAddr("2001:db8:2::100"); my != want {
+		t.Errorf("got self IP %v; want %v", my, want)
+	}
+}
+
 // https://github.com/tailscale/tailscale/issues/10466
 func TestLikelyHomeRouterIP_Prefix(t *testing.T) {
 	ipnet := func(s string) net.Addr {
@@ -627,6 +627,48 @@ func LikelyHomeRouterIP() (gateway, myIP netip.Addr, ok bool) {
 	return gateway, myIP, myIP.IsValid()
 }
 
+// likelyHomeRouterIPv6, if present, is a platform-specific function that
+// returns the IPv6 default gateway of the current system and the name of the
+// interface it's reachable over. A link-local gateway includes its zone.
+var likelyHomeRouterIPv6 func() (gw netip.Addr, ifName string, ok bool)
+
+// LikelyHomeRouterIPv6 is like LikelyHomeRouterIP, but for IPv6. It returns
+// the IPv6 address of the likely residential router, if known, and a global
+// unicast IPv6 address of the current machine on the LAN using that router.
+//
+// The gateway is often a link-local address, in which case it includes the
+// interface zone. On platforms where the IPv6 gateway can't be determined,
+// ok may be true with an invalid gateway, as myIP alone is enough for
+// protocols like UPnP that don't talk to the router over IPv6.
+func LikelyHomeRouterIPv6() (gateway, myIP netip.Addr, ok bool) {
+	var ifName string
+	if likelyHomeRouterIPv6 != nil {
+		gateway, ifName, _ = likelyHomeRouterIPv6()
+	}
+	if ifName == "" {
+		var err error
+		ifName, err = DefaultRouteInterface()
+		if err != nil {
+			return netip.Addr{}, netip.Addr{}, false
+		}
+	}
+	ForeachInterface(func(i Interface, pfxs []netip.Prefix) {
+		if i.Name != ifName || !i.IsUp() {
+			return
+		}
+		for _, pfx := range pfxs {
+			if ip := pfx.Addr(); ip.Is6() && v6Global1.Contains(ip) {
+				myIP = ip
+				return
+			}
+		}
+	})
+	if !myIP.IsValid() {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	return gateway, myIP, true
+}
+
 // isUsableV4 reports whether ip is a usable IPv4 address which could
 // conceivably be used to get Internet connectivity. Globally routable and
 // private IPv4 addresses are always Usable, and link local 169.254.x.x
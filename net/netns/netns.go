@@ -15,6 +15,7 @@
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/netip"
 	"sync/atomic"
@@ -66,6 +67,24 @@ func Listener(logf logger.Logf, netMon *netmon.Monitor) *net.ListenConfig {
 	return &net.ListenConfig{Control: control(logf, netMon)}
 }
 
+// ListenerOnInterface returns a new net.ListenConfig whose sockets are bound
+// to the network interface named ifName, rather than to whichever interface
+// has the default route, while still not routing back into Tailscale.
+// It's for sending over a particular uplink when a machine has several.
+//
+// It returns an error if the platform doesn't support binding to an
+// interface, or if there's no such interface.
+func ListenerOnInterface(logf logger.Logf, ifName string) (*net.ListenConfig, error) {
+	if ifName == "" {
+		return nil, errors.New("netns: empty interface name")
+	}
+	ctl, err := controlOnInterface(logf, ifName)
+	if err != nil {
+		return nil, err
+	}
+	return &net.ListenConfig{Control: ctl}, nil
+}
+
 // NewDialer returns a new Dialer using a net.Dialer with its Control
 // hook func initialized as necessary to run in a logical network
 // namespace that doesn't route back into Tailscale. It also handles
@@ -6,6 +6,7 @@
 package netns
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"syscall"
@@ -73,3 +74,7 @@ func controlC(network, address string, c syscall.RawConn) error {
 	}
 	return sockErr
 }
+
+func controlOnInterface(logger.Logf, string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("netns: binding to an interface is not supported on Android")
+}
@@ -264,6 +264,16 @@ func SetListenConfigInterfaceIndex(lc *net.ListenConfig, ifIndex int) error {
 	return nil
 }
 
+func controlOnInterface(logf logger.Logf, ifName string) (func(network, address string, c syscall.RawConn) error, error) {
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		return bindConnToInterface(c, network, address, ifc.Index, logf)
+	}, nil
+}
+
 func bindConnToInterface(c syscall.RawConn, network, address string, ifIndex int, logf logger.Logf) error {
 	v6 := strings.Contains(address, "]:") || strings.HasSuffix(network, "6") // hacky test for v6
 	proto := unix.IPPROTO_IP
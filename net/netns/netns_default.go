@@ -6,6 +6,8 @@
 package netns
 
 import (
+	"errors"
+	"runtime"
 	"syscall"
 
 	"tailscale.com/net/netmon"
@@ -20,3 +22,7 @@ func control(logger.Logf, *netmon.Monitor) func(network, address string, c sysca
 func controlC(network, address string, c syscall.RawConn) error {
 	return nil
 }
+
+func controlOnInterface(logger.Logf, string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("netns: binding to an interface is not supported on " + runtime.GOOS)
+}
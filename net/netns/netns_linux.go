@@ -110,6 +110,29 @@ func controlC(network, address string, c syscall.RawConn) error {
 	return sockErr
 }
 
+func controlOnInterface(_ logger.Logf, ifName string) (func(network, address string, c syscall.RawConn) error, error) {
+	if _, err := net.InterfaceByName(ifName); err != nil {
+		return nil, err
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if UseSocketMark() {
+				if sockErr = setBypassMark(fd); sockErr != nil {
+					return
+				}
+			}
+			if err := unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifName); err != nil {
+				sockErr = fmt.Errorf("setting SO_BINDTODEVICE to %q: %w", ifName, err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("RawConn.Control on %T: %w", c, err)
+		}
+		return sockErr
+	}, nil
+}
+
 func setBypassMark(fd uintptr) error {
 	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, linuxfw.TailscaleBypassMarkNum); err != nil {
 		return fmt.Errorf("setting SO_MARK bypass: %w", err)
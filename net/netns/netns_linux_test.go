@@ -4,6 +4,7 @@
 package netns
 
 import (
+	"context"
 	"testing"
 )
 
@@ -12,3 +13,19 @@ func TestSocketMarkWorks(t *testing.T) {
 	// we cannot actually assert whether the test runner has SO_MARK available
 	// or not, as we don't know. We're just checking that it doesn't panic.
 }
+
+func TestListenerOnInterface(t *testing.T) {
+	if _, err := ListenerOnInterface(t.Logf, "no-such-interface0"); err == nil {
+		t.Error("ListenerOnInterface succeeded for missing interface")
+	}
+	lc, err := ListenerOnInterface(t.Logf, "lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	if err != nil {
+		// SO_BINDTODEVICE needs CAP_NET_RAW on older kernels.
+		t.Skipf("ListenPacket: %v", err)
+	}
+	pc.Close()
+}
@@ -6,6 +6,7 @@
 import (
 	"fmt"
 	"math/bits"
+	"net"
 	"net/netip"
 	"strings"
 	"syscall"
@@ -172,6 +173,27 @@ func interfaceIndexFor(addr netip.Addr) (uint32, error) {
 	return idx, nil
 }
 
+func controlOnInterface(_ logger.Logf, ifName string) (func(network, address string, c syscall.RawConn) error, error) {
+	ifc, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return nil, err
+	}
+	idx := uint32(ifc.Index)
+	return func(network, address string, c syscall.RawConn) error {
+		if !strings.HasSuffix(network, "6") {
+			if err := bindSocket4(c, idx); err != nil {
+				return fmt.Errorf("binding to %q: %w", ifName, err)
+			}
+		}
+		if !strings.HasSuffix(network, "4") {
+			if err := bindSocket6(c, idx); err != nil {
+				return fmt.Errorf("binding to %q: %w", ifName, err)
+			}
+		}
+		return nil
+	}, nil
+}
+
 // sockoptBoundInterface is the value of IP_UNICAST_IF and IPV6_UNICAST_IF.
 //
 // See https://docs.microsoft.com/en-us/windows/win32/winsock/ipproto-ip-socket-options
@@ -70,6 +70,37 @@ func UpdateDstAddr(q *packet.Parsed, dst netip.Addr) {
 	}
 }
 
+// UpdateDSCP sets the Differentiated Services code point in the IP header of
+// the packet buffer to dscp, leaving the ECN bits as they are. For IPv4, it
+// also updates the header checksum. It does nothing if q is neither IPv4 nor
+// IPv6 or dscp is out of range.
+func UpdateDSCP(q *packet.Parsed, dscp uint8) {
+	if dscp > 63 {
+		return
+	}
+	b := q.Buffer()
+	switch q.IPVersion {
+	case 4:
+		if len(b) < 20 {
+			return
+		}
+		var old [2]byte
+		copy(old[:], b[0:2])
+		b[1] = dscp<<2 | b[1]&0x03
+		updateV4Checksum(b[10:12], old[:], b[0:2])
+	case 6:
+		if len(b) < 40 {
+			return
+		}
+		// The traffic class straddles the first two bytes, after the
+		// 4-bit version. There's no header checksum, and the transport
+		// checksum's pseudo-header doesn't cover it.
+		tc := dscp<<2 | (b[1]>>4)&0x03
+		b[0] = b[0]&0xf0 | tc>>4
+		b[1] = tc<<4 | b[1]&0x0f
+	}
+}
+
 // updateV4PacketChecksums updates the checksums in the packet buffer.
 // Currently (2023-03-01) only TCP/UDP/ICMP over IPv4 is supported.
 // p is modified in place.
@@ -221,3 +221,44 @@ func TestNatChecksumsV6TCP(t *testing.T) {
 		t.Fatal("incorrect checksum after updating destination address")
 	}
 }
+
+func TestUpdateDSCP(t *testing.T) {
+	// An IPv4 UDP (DNS) packet with ECT(1) set.
+	v4 := []byte{
+		0x45, 0x01, 0x00, 0x1c, 0xe2, 0x85, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00, 0x64, 0x64, 0x64, 0x64, 0x64, 0x42, 0xd4, 0x33,
+		0x00, 0x35, 0xec, 0x55, 0x00, 0x08, 0x00, 0x00,
+	}
+	binary.BigEndian.PutUint16(v4[10:12], fullHeaderChecksumV4(v4[:20]))
+	var p packet.Parsed
+	p.Decode(v4)
+	UpdateDSCP(&p, 46)
+	if got, want := v4[1], byte(46<<2|0x01); got != want {
+		t.Errorf("IPv4 TOS = %#x; want %#x", got, want)
+	}
+	if got, want := binary.BigEndian.Uint16(v4[10:12]), fullHeaderChecksumV4(v4[:20]); got != want {
+		t.Errorf("IPv4 checksum = %#x; want %#x", got, want)
+	}
+
+	v6 := header.IPv6(make([]byte, header.IPv6MinimumSize+header.UDPMinimumSize))
+	v6.Encode(&header.IPv6Fields{
+		TrafficClass:      0x02, // ECT(0)
+		FlowLabel:         0xabcde,
+		PayloadLength:     header.UDPMinimumSize,
+		TransportProtocol: header.UDPProtocolNumber,
+		HopLimit:          16,
+		SrcAddr:           tcpip.AddrFrom16Slice(randV6Addr().AsSlice()),
+		DstAddr:           tcpip.AddrFrom16Slice(randV6Addr().AsSlice()),
+	})
+	p.Decode(v6)
+	UpdateDSCP(&p, 10)
+	tc, flow := v6.TOS()
+	if tc != 10<<2|0x02 {
+		t.Errorf("IPv6 traffic class = %#x; want %#x", tc, 10<<2|0x02)
+	}
+	if flow != 0xabcde {
+		t.Errorf("IPv6 flow label = %#x; want %#x", flow, 0xabcde)
+	}
+	if v6[0]>>4 != 6 {
+		t.Errorf("IPv6 version = %d; want 6", v6[0]>>4)
+	}
+}
@@ -24,9 +24,19 @@ func processUPnPResponses(metas []uPnPDiscoResponse) []uPnPDiscoResponse {
 
 func (c *Client) getUPnPPortMapping(
 	ctx context.Context,
+	k MappingKey,
 	gw netip.Addr,
 	internal netip.AddrPort,
 	prevPort uint16,
 ) (external netip.AddrPort, ok bool) {
 	return netip.AddrPort{}, false
 }
+
+func (c *Client) getUPnPPinhole(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	prev mapping,
+) (mapping, bool) {
+	return nil, false
+}
@@ -0,0 +1,230 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"tailscale.com/util/mak"
+)
+
+// MappingKey identifies a mapping that a [Client] maintains in addition to
+// the one for the port set by [Client.SetLocalPort]. See [Client.AddMapping].
+type MappingKey struct {
+	// LocalPort is the local UDP port to map. It must be non-zero.
+	LocalPort uint16
+
+	// IPv6, if true, requests an IPv6 firewall pinhole for LocalPort on the
+	// machine's global IPv6 address, rather than an IPv4 port mapping.
+	IPv6 bool
+}
+
+func (k MappingKey) String() string {
+	if k.IPv6 {
+		return fmt.Sprintf("udp6/%d", k.LocalPort)
+	}
+	return fmt.Sprintf("udp4/%d", k.LocalPort)
+}
+
+// managedMapping is the state of a mapping requested with AddMapping.
+//
+// All fields are guarded by Client.mu.
+type managedMapping struct {
+	key     MappingKey
+	mapping mapping     // non-nil if we have a mapping
+	running bool        // whether a createManagedMapping goroutine is running
+	timer   *time.Timer // fires when the mapping is next due to be renewed or retried; nil if none
+}
+
+const (
+	// managedRetryInterval is how long we wait to try again after failing
+	// to create a mapping requested with AddMapping.
+	managedRetryInterval = time.Minute
+
+	// managedMinRenewInterval is the minimum time between renewals of a
+	// mapping requested with AddMapping, in case a gateway hands out
+	// uselessly short leases.
+	managedMinRenewInterval = 10 * time.Second
+)
+
+// AddMapping asks c to create and maintain the mapping identified by k, in
+// addition to the one for the port set by SetLocalPort. Each such mapping is
+// renewed independently as its lease requires, until it's removed with
+// RemoveMapping or c is closed.
+//
+// Mappings are announced on the event bus as they're obtained, with
+// [Mapping.Key] set to k. The OnChange callback is not called for them.
+func (c *Client) AddMapping(k MappingKey) {
+	if k.LocalPort == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if _, ok := c.managed[k]; ok {
+		return
+	}
+	mm := &managedMapping{key: k}
+	mak.Set(&c.managed, k, mm)
+	c.startManagedLocked(mm)
+}
+
+// RemoveMapping stops maintaining the mapping identified by k, previously
+// requested with AddMapping, and releases it.
+func (c *Client) RemoveMapping(k MappingKey) {
+	c.mu.Lock()
+	mm, ok := c.managed[k]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.managed, k)
+	m := mm.stopLocked()
+	c.mu.Unlock()
+
+	if m != nil {
+		m.Release(context.Background())
+	}
+}
+
+// GetCachedMapping returns the external address of the mapping identified by
+// k, which must have been requested with AddMapping, if it has been obtained
+// and is still valid. For IPv6 pinholes, it's the machine's own address.
+func (c *Client) GetCachedMapping(k MappingKey) (external netip.AddrPort, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mm := c.managed[k]
+	if mm == nil || mm.mapping == nil || !time.Now().Before(mm.mapping.GoodUntil()) {
+		return netip.AddrPort{}, false
+	}
+	return mm.mapping.External(), true
+}
+
+// stopLocked stops mm's timer, clears its mapping, and returns that mapping,
+// if any, for the caller to release.
+//
+// Client.mu must be held.
+func (mm *managedMapping) stopLocked() mapping {
+	if mm.timer != nil {
+		mm.timer.Stop()
+		mm.timer = nil
+	}
+	m := mm.mapping
+	mm.mapping = nil
+	return m
+}
+
+// startManagedLocked starts a createManagedMapping goroutine for mm, if one
+// isn't already running.
+//
+// c.mu must be held.
+func (c *Client) startManagedLocked(mm *managedMapping) {
+	if mm.running || c.closed {
+		return
+	}
+	if mm.timer != nil {
+		mm.timer.Stop()
+		mm.timer = nil
+	}
+	mm.running = true
+	go c.createManagedMapping(mm)
+}
+
+func (c *Client) createManagedMapping(mm *managedMapping) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The mapping services are normally discovered by netcheck's periodic
+	// Probe, but nothing guarantees anyone is probing with this Client.
+	c.mu.Lock()
+	needProbe := c.lastProbe.Before(time.Now().Add(-trustServiceStillAvailableDuration))
+	c.mu.Unlock()
+	if needProbe {
+		c.Probe(ctx)
+	}
+
+	m, _, err := c.createOrGetMappingFor(ctx, mm.key)
+
+	c.mu.Lock()
+	mm.running = false
+	if c.closed || c.managed[mm.key] != mm {
+		// Removed while we were working; setMappingLocked has already
+		// released anything we obtained.
+		c.mu.Unlock()
+		return
+	}
+	next := managedRetryInterval
+	if err != nil {
+		if !IsNoMappingError(err) {
+			c.logf("createOrGetMapping(%v): %v", mm.key, err)
+		}
+	} else if mm.mapping != nil {
+		next = max(time.Until(mm.mapping.RenewAfter()), managedMinRenewInterval)
+	}
+	mm.timer = time.AfterFunc(next, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.managed[mm.key] == mm {
+			c.startManagedLocked(mm)
+		}
+	})
+	c.mu.Unlock()
+
+	if err == nil && m != nil && c.updates != nil {
+		c.updates.Publish(Mapping{
+			External:  m.External(),
+			Type:      m.MappingType(),
+			GoodUntil: m.GoodUntil(),
+			Key:       mm.key,
+		})
+	}
+}
+
+// localPortLocked returns the local port of the mapping identified by k.
+//
+// c.mu must be held.
+func (c *Client) localPortLocked(k MappingKey) uint16 {
+	if k == (MappingKey{}) {
+		return c.localPort
+	}
+	return k.LocalPort
+}
+
+// mappingLocked returns the current mapping identified by k, or nil if there
+// is none.
+//
+// c.mu must be held.
+func (c *Client) mappingLocked(k MappingKey) mapping {
+	if k == (MappingKey{}) {
+		return c.mapping
+	}
+	if mm := c.managed[k]; mm != nil {
+		return mm.mapping
+	}
+	return nil
+}
+
+// setMappingLocked records m as the current mapping identified by k. If the
+// mapping has been removed with RemoveMapping in the meantime, m is released
+// instead.
+//
+// c.mu must be held.
+func (c *Client) setMappingLocked(k MappingKey, m mapping) {
+	if k == (MappingKey{}) {
+		c.mapping = m
+		return
+	}
+	if mm := c.managed[k]; mm != nil {
+		mm.mapping = m
+		return
+	}
+	if m != nil {
+		go m.Release(context.Background())
+	}
+}
@@ -57,7 +57,12 @@ type pcpMapping struct {
 	epoch uint32
 }
 
-func (p *pcpMapping) MappingType() string      { return "pcp" }
+func (p *pcpMapping) MappingType() string {
+	if p.internal.Addr().Is6() {
+		return "pcp-pinhole"
+	}
+	return "pcp"
+}
 func (p *pcpMapping) GoodUntil() time.Time     { return p.goodUntil }
 func (p *pcpMapping) RenewAfter() time.Time    { return p.renewAfter }
 func (p *pcpMapping) External() netip.AddrPort { return p.external }
@@ -68,7 +73,13 @@ func (p *pcpMapping) MappingDebug() string {
 }
 
 func (p *pcpMapping) Release(ctx context.Context) {
-	uc, err := p.c.listenPacket(ctx, "udp4", ":0")
+	network, laddr := "udp4", ":0"
+	if p.gw.Addr().Is6() {
+		// The PCP server checks the request's source address against the
+		// client address in the packet, so send from the pinhole's address.
+		network, laddr = "udp6", netip.AddrPortFrom(p.internal.Addr(), 0).String()
+	}
+	uc, err := p.c.listenPacket(ctx, network, laddr)
 	if err != nil {
 		return
 	}
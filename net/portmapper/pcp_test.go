@@ -5,10 +5,14 @@
 
 import (
 	"encoding/binary"
+	"net"
 	"net/netip"
+	"slices"
 	"testing"
 
 	"tailscale.com/net/netaddr"
+	"tailscale.com/net/netmon"
+	"tailscale.com/util/mak"
 )
 
 var examplePCPMapResponse = []byte{2, 129, 0, 0, 0, 0, 28, 32, 0, 2, 155, 237, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 129, 112, 9, 24, 241, 208, 251, 45, 157, 76, 10, 188, 17, 0, 0, 0, 4, 210, 4, 210, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 135, 180, 175, 246}
@@ -60,3 +64,60 @@ func buildPCPMapResponse(req []byte) []byte {
 	copy(mapResp[20:36], assignedIP16[:])
 	return out
 }
+
+func TestPCPPinhole(t *testing.T) {
+	pc, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer pc.Close()
+
+	// Serve a single pinhole request: a PCP firewall grants the suggested
+	// external address, which is the internal one.
+	gotReq := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		gotReq <- slices.Clone(req)
+		resp := buildPCPMapResponse(req)
+		copy(resp[24+18:], req[24+18:60])
+		pc.WriteTo(resp, src)
+	}()
+
+	c := NewClient(Config{Logf: t.Logf, NetMon: netmon.NewStatic()})
+	defer c.Close()
+	c.testPxPPort = uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+	c.ipv6Gateway = func() (gw, ip netip.Addr, ok bool) {
+		return netip.IPv6Loopback(), netip.IPv6Loopback(), true
+	}
+	c.debug.DisableUPnP = true
+
+	k := MappingKey{LocalPort: 1234, IPv6: true}
+	mak.Set(&c.managed, k, &managedMapping{key: k})
+	m, ext, err := c.createOrGetPinhole(t.Context(), k)
+	if err != nil {
+		t.Fatalf("createOrGetPinhole: %v", err)
+	}
+	want := netip.MustParseAddrPort("[::1]:1234")
+	if ext != want {
+		t.Errorf("external = %v; want %v", ext, want)
+	}
+	if got := m.MappingType(); got != "pcp-pinhole" {
+		t.Errorf("MappingType = %q; want pcp-pinhole", got)
+	}
+	if got, ok := c.GetCachedMapping(k); !ok || got != want {
+		t.Errorf("GetCachedMapping = %v, %v; want %v, true", got, ok, want)
+	}
+
+	req := <-gotReq
+	if got := netip.AddrFrom16([16]byte(req[8:24])); got != netip.IPv6Loopback() {
+		t.Errorf("request client IP = %v; want ::1", got)
+	}
+	if got := binary.BigEndian.Uint16(req[24+16:]); got != 1234 {
+		t.Errorf("request internal port = %d; want 1234", got)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/neterror"
+)
+
+// IPv6 has no NAT to map through, but home routers commonly run a stateful
+// firewall that drops unsolicited inbound traffic. A pinhole asks the router
+// to let traffic through to one of our ports, and is otherwise managed like
+// any other mapping: its External address is just the internal one.
+//
+// PCP does pinholes with a regular MAP request sent to the router over IPv6
+// (RFC 6887, section 11). UPnP uses the separate WANIPv6FirewallControl
+// service, which is usually reachable over the same IPv4 control URL as the
+// port mapping services; see upnp_pinhole.go.
+
+// createOrGetPinhole is the IPv6 counterpart of createOrGetMappingFor: it
+// opens a firewall pinhole for k.LocalPort on the machine's global IPv6
+// address, or renews or returns the existing one.
+//
+// If no pinhole is available, the error will be of type NoMappingError.
+func (c *Client) createOrGetPinhole(ctx context.Context, k MappingKey) (_ mapping, external netip.AddrPort, err error) {
+	if c.debug.disableAll() {
+		return nil, netip.AddrPort{}, NoMappingError{ErrPortMappingDisabled}
+	}
+	if c.debug.DisableUPnP && c.debug.DisablePCP {
+		return nil, netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+	}
+	gw6, myIP6, ok := c.ipv6Gateway()
+	if !ok {
+		return nil, netip.AddrPort{}, NoMappingError{ErrNoGlobalIPv6}
+	}
+	internal := netip.AddrPortFrom(myIP6, k.LocalPort)
+
+	c.mu.Lock()
+	prev := c.mappingLocked(k)
+	if prev != nil && prev.External() != internal {
+		// Our address changed, e.g. due to a new prefix from the
+		// router, so the old pinhole is of no use anymore.
+		c.setMappingLocked(k, nil)
+		go prev.Release(context.Background())
+		prev = nil
+	}
+	if prev != nil && time.Now().Before(prev.RenewAfter()) {
+		c.mu.Unlock()
+		return prev, prev.External(), nil
+	}
+	c.mu.Unlock()
+
+	var m mapping
+	if !c.debug.DisablePCP && gw6.IsValid() {
+		pm, err := c.pcpPinhole(ctx, gw6, internal)
+		if err == nil {
+			m = pm
+		} else {
+			c.vlogf("PCP pinhole for %v: %v", internal, err)
+		}
+	}
+	if m == nil {
+		if gw, _, ok := c.gatewayAndSelfIP(); ok {
+			m, _ = c.getUPnPPinhole(ctx, gw, internal, prev)
+		}
+	}
+	if m == nil {
+		return nil, netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setMappingLocked(k, m)
+	c.logf("[v1] successfully obtained pinhole: external=%v type=%s goodUntil=%d renewAfter=%d",
+		m.External(), m.MappingType(), m.GoodUntil().Unix(), m.RenewAfter().Unix())
+	return m, m.External(), nil
+}
+
+// pcpPinhole requests a PCP firewall pinhole for internal from the PCP server
+// on the IPv6 router gw.
+func (c *Client) pcpPinhole(ctx context.Context, gw netip.Addr, internal netip.AddrPort) (*pcpMapping, error) {
+	// Bind to the address we're asking for; a PCP server rejects requests
+	// whose source address doesn't match the client address in the packet,
+	// which a temporary (privacy) address chosen by the kernel might not.
+	uc, err := c.listenPacket(ctx, "udp6", netip.AddrPortFrom(internal.Addr(), 0).String())
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	uc.SetReadDeadline(time.Now().Add(portMapServiceTimeout))
+	defer closeCloserOnContextDone(ctx, uc)()
+
+	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
+	pkt := buildPCPRequestMappingPacket(internal.Addr(), internal.Port(), internal.Port(), pcpMapLifetimeSec, internal.Addr())
+	if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
+		if neterror.TreatAsLostUDP(err) {
+			err = NoMappingError{ErrNoPortMappingServices}
+		}
+		return nil, err
+	}
+
+	res := make([]byte, 1500)
+	for {
+		n, src, err := uc.ReadFromUDPAddrPort(res)
+		if err != nil {
+			return nil, err
+		}
+		if src.Addr().WithZone("") != gw.WithZone("") || src.Port() != pxpAddr.Port() {
+			continue
+		}
+		m, err := parsePCPMapResponse(res[:n])
+		if err != nil {
+			return nil, err
+		}
+		m.c = c
+		m.gw = pxpAddr
+		m.internal = internal
+		return m, nil
+	}
+}
@@ -2,7 +2,7 @@
 // SPDX-License-Identifier: BSD-3-Clause
 
 // Package portmapper is a UDP port mapping client. It currently allows for mapping over
-// NAT-PMP, UPnP, and PCP.
+// NAT-PMP, UPnP, and PCP, and for opening IPv6 firewall pinholes over UPnP and PCP.
 package portmapper
 
 import (
@@ -94,6 +94,7 @@ type Client struct {
 	netMon       *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
 	controlKnobs *controlknobs.Knobs
 	ipAndGateway func() (gw, ip netip.Addr, ok bool)
+	ipv6Gateway  func() (gw, ip netip.Addr, ok bool)
 	onChange     func() // or nil
 	debug        DebugKnobs
 	testPxPPort  uint16 // if non-zero, pxpPort to use for tests
@@ -128,6 +129,10 @@ type Client struct {
 	localPort uint16
 
 	mapping mapping // non-nil if we have a mapping
+
+	// managed are the mappings requested with AddMapping, in addition to
+	// the one for localPort above.
+	managed map[MappingKey]*managedMapping
 }
 
 func (c *Client) vlogf(format string, args ...any) {
@@ -245,6 +250,7 @@ func NewClient(c Config) *Client {
 		logf:         c.Logf,
 		netMon:       c.NetMon,
 		ipAndGateway: netmon.LikelyHomeRouterIP, // TODO(bradfitz): move this to method on netMon
+		ipv6Gateway:  netmon.LikelyHomeRouterIPv6,
 		onChange:     c.OnChange,
 		controlKnobs: c.ControlKnobs,
 	}
@@ -286,6 +292,12 @@ func (c *Client) Close() error {
 	}
 	c.closed = true
 	c.invalidateMappingsLocked(true)
+	for _, mm := range c.managed {
+		if m := mm.stopLocked(); m != nil {
+			m.Release(context.Background())
+		}
+	}
+	c.managed = nil
 	if c.updates != nil {
 		c.updates.Close()
 		c.pubClient.Close()
@@ -379,6 +391,16 @@ func (c *Client) invalidateMappingsLocked(releaseOld bool) {
 		}
 		c.mapping = nil
 	}
+	for _, mm := range c.managed {
+		if mm.mapping == nil {
+			continue
+		}
+		m := mm.stopLocked()
+		if releaseOld {
+			m.Release(context.Background())
+		}
+		c.startManagedLocked(mm)
+	}
 
 	c.pmpPubIP = netip.Addr{}
 	c.pmpPubIPTime = time.Time{}
@@ -456,6 +478,7 @@ func IsNoMappingError(err error) bool {
 	ErrGatewayRange          = errors.New("skipping portmap; gateway range likely lacks support")
 	ErrGatewayIPv6           = errors.New("skipping portmap; no IPv6 support for portmapping")
 	ErrPortMappingDisabled   = errors.New("port mapping is disabled")
+	ErrNoGlobalIPv6          = errors.New("skipping pinhole; no global IPv6 address")
 )
 
 // GetCachedMappingOrStartCreatingOne quickly returns with our current cached portmapping, if any.
@@ -526,18 +549,32 @@ type Mapping struct {
 	Type      string
 	GoodUntil time.Time
 
+	// Key identifies the mapping if it was requested with
+	// [Client.AddMapping]. It is the zero value for the mapping of the port
+	// set by [Client.SetLocalPort].
+	Key MappingKey
+
 	// TODO(creachadair): Record whether we reused an existing mapping?
 }
 
 // wildcardIP is used when the previous external IP is not known for PCP port mapping.
 var wildcardIP = netip.MustParseAddr("0.0.0.0")
 
-// createOrGetMapping either creates a new mapping or returns a cached
-// valid one.
+// createOrGetMapping either creates a new mapping for the port set by
+// SetLocalPort or returns a cached valid one.
 //
 // If no mapping is available, the error will be of type
 // NoMappingError; see IsNoMappingError.
 func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, external netip.AddrPort, err error) {
+	return c.createOrGetMappingFor(ctx, MappingKey{})
+}
+
+// createOrGetMappingFor is like createOrGetMapping, but for the mapping
+// identified by k. The zero MappingKey is the mapping for c.localPort.
+func (c *Client) createOrGetMappingFor(ctx context.Context, k MappingKey) (mapping mapping, external netip.AddrPort, err error) {
+	if k.IPv6 {
+		return c.createOrGetPinhole(ctx, k)
+	}
 	if c.debug.disableAll() {
 		return nil, netip.AddrPort{}, NoMappingError{ErrPortMappingDisabled}
 	}
@@ -564,15 +601,16 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 		c.mu.Lock()
 		defer c.mu.Unlock()
 
+		cur := c.mappingLocked(k)
 		portmapType := "none"
-		if c.mapping != nil {
-			portmapType = c.mapping.MappingType()
+		if cur != nil {
+			portmapType = cur.MappingType()
 		}
 		if reusedExisting {
 			portmapType = "existing-" + portmapType
 		}
 
-		if c.mapping == nil {
+		if cur == nil {
 			c.logf("[unexpected] no error but no stored mapping: now=%d external=%v type=%s",
 				now.Unix(), external, portmapType)
 			return
@@ -587,22 +625,22 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 		// race with a later update. The mapping itself is concurrency-safe.
 		//
 		// We should restructure this code so the locks are properly scoped.
-		mapping = c.mapping
+		mapping = cur
 
 		// Print the internal details of each mapping if we're being verbose.
 		if c.debug.VerboseLogs {
 			c.logf("successfully obtained mapping: now=%d external=%v type=%s mapping=%s",
-				now.Unix(), external, portmapType, c.mapping.MappingDebug())
+				now.Unix(), external, portmapType, cur.MappingDebug())
 			return
 		}
 
 		c.logf("[v1] successfully obtained mapping: now=%d external=%v type=%s goodUntil=%d renewAfter=%d",
 			now.Unix(), external, portmapType,
-			c.mapping.GoodUntil().Unix(), c.mapping.RenewAfter().Unix())
+			cur.GoodUntil().Unix(), cur.RenewAfter().Unix())
 	}()
 
 	c.mu.Lock()
-	localPort := c.localPort
+	localPort := c.localPortLocked(k)
 	internalAddr := netip.AddrPortFrom(myIP, localPort)
 
 	// prevPort is the port we had most previously, if any. We try
@@ -610,7 +648,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 	var prevPort uint16
 
 	// Do we have an existing mapping that's valid?
-	if m := c.mapping; m != nil {
+	if m := c.mappingLocked(k); m != nil {
 		if now.Before(m.RenewAfter()) {
 			defer c.mu.Unlock()
 			reusedExisting = true
@@ -622,7 +660,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 
 	if c.debug.DisablePCP && c.debug.DisablePMP {
 		c.mu.Unlock()
-		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
+		if external, ok := c.getUPnPPortMapping(ctx, k, gw, internalAddr, prevPort); ok {
 			return nil, external, nil
 		}
 		c.vlogf("fallback to UPnP due to PCP and PMP being disabled failed")
@@ -652,7 +690,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 	if c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP {
 		c.mu.Unlock()
 		// fallback to UPnP portmapping
-		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
+		if external, ok := c.getUPnPPortMapping(ctx, k, gw, internalAddr, prevPort); ok {
 			return nil, external, nil
 		}
 		c.vlogf("fallback to UPnP due to no PCP and PMP failed")
@@ -712,7 +750,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 				return nil, netip.AddrPort{}, err
 			}
 			// fallback to UPnP portmapping
-			if mapping, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
+			if mapping, ok := c.getUPnPPortMapping(ctx, k, gw, internalAddr, prevPort); ok {
 				return nil, mapping, nil
 			}
 			return nil, netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
@@ -756,7 +794,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 				pcpMapping.gw = netip.AddrPortFrom(gw, c.pxpPort())
 				c.mu.Lock()
 				defer c.mu.Unlock()
-				c.mapping = pcpMapping
+				c.setMappingLocked(k, pcpMapping)
 				return pcpMapping, pcpMapping.external, nil
 			default:
 				c.logf("unknown PMP/PCP version number: %d %v", version, res[:n])
@@ -767,7 +805,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (mapping mapping, exter
 		if m.externalValid() {
 			c.mu.Lock()
 			defer c.mu.Unlock()
-			c.mapping = m
+			c.setMappingLocked(k, m)
 			return nil, m.external, nil
 		}
 	}
@@ -161,3 +161,79 @@ func TestUpdateEvent(t *testing.T) {
 		t.Error("Timed out waiting for an update event")
 	}
 }
+
+func TestAddMapping(t *testing.T) {
+	igd, err := NewTestIGD(t, TestIGDOptions{PCP: true})
+	if err != nil {
+		t.Fatalf("Create test gateway: %v", err)
+	}
+	defer igd.Close()
+
+	bus := eventbus.New()
+	defer bus.Close()
+
+	sub := eventbus.Subscribe[Mapping](bus.Client("TestAddMapping"))
+	c := newTestClient(t, igd, bus)
+	c.SetLocalPort(1234)
+
+	k := MappingKey{LocalPort: 4321}
+	c.AddMapping(k)
+
+	select {
+	case evt := <-sub.Events():
+		if evt.Key != k {
+			t.Errorf("event key = %v; want %v", evt.Key, k)
+		}
+		if !evt.External.IsValid() {
+			t.Errorf("event has no external address: %+v", evt)
+		}
+	case <-sub.Done():
+		t.Fatal("Subscriber closed prematurely")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an update event")
+	}
+
+	if _, ok := c.GetCachedMapping(k); !ok {
+		t.Error("GetCachedMapping: no mapping after update event")
+	}
+	if c.HaveMapping() {
+		t.Error("HaveMapping: additional mapping unexpectedly counted as the primary one")
+	}
+
+	before := igd.stats().numPCPMapRecv
+	c.RemoveMapping(k)
+	if _, ok := c.GetCachedMapping(k); ok {
+		t.Error("GetCachedMapping: still have mapping after RemoveMapping")
+	}
+	// RemoveMapping releases synchronously, but the IGD counts the
+	// release request on its own goroutine.
+	for range 100 {
+		if igd.stats().numPCPMapRecv > before {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPMapRecv; got != before+1 {
+		t.Errorf("IGD got %d PCP MAP requests after RemoveMapping; want 1 (the release)", got-before)
+	}
+
+	// Close releases the remaining additional mappings too.
+	k = MappingKey{LocalPort: 5432}
+	c.AddMapping(k)
+	select {
+	case <-sub.Events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an update event")
+	}
+	before = igd.stats().numPCPMapRecv
+	c.Close()
+	for range 100 {
+		if igd.stats().numPCPMapRecv > before {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := igd.stats().numPCPMapRecv; got <= before {
+		t.Error("IGD got no PCP MAP requests after Close; want the additional mapping released")
+	}
+}
@@ -425,11 +425,12 @@ func (c *Client) upnpHTTPClientLocked() *http.Client {
 	disableUPnpEnv = envknob.RegisterBool("TS_DISABLE_UPNP")
 )
 
-// getUPnPPortMapping attempts to create a port-mapping over the UPnP protocol. On success,
-// it will return the externally exposed IP and port. Otherwise, it will return a zeroed IP and
-// port and an error.
+// getUPnPPortMapping attempts to create the port-mapping identified by k over
+// the UPnP protocol. On success, it will return the externally exposed IP and
+// port. Otherwise, it will return a zeroed IP and port and an error.
 func (c *Client) getUPnPPortMapping(
 	ctx context.Context,
+	k MappingKey,
 	gw netip.Addr,
 	internal netip.AddrPort,
 	prevPort uint16,
@@ -452,7 +453,7 @@ func (c *Client) getUPnPPortMapping(
 	// Start by grabbing the list of metas, any existing mapping, and
 	// creating a HTTP client for use.
 	c.mu.Lock()
-	oldMapping, ok := c.mapping.(*upnpMapping)
+	oldMapping, ok := c.mappingLocked(k).(*upnpMapping)
 	metas := c.uPnPMetas
 	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
 	c.mu.Unlock()
@@ -539,8 +540,10 @@ type step struct {
 
 		c.mu.Lock()
 		defer c.mu.Unlock()
-		c.mapping = upnp
-		c.localPort = externalAddrPort.Port()
+		c.setMappingLocked(k, upnp)
+		if k == (MappingKey{}) {
+			c.localPort = externalAddrPort.Port()
+		}
 		return upnp.external, true
 	}
 
@@ -0,0 +1,282 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+// (no raw sockets in JS/WASM)
+
+package portmapper
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/tailscale/goupnp"
+	"github.com/tailscale/goupnp/soap"
+)
+
+// References:
+//
+// WANIPv6FirewallControl v1: http://upnp.org/specs/gw/UPnP-gw-WANIPv6FirewallControl-v1-Service.pdf
+
+const urn_WANIPv6FirewallControl_1 = "urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"
+
+// upnpProtocolNumberUDP is the IANA protocol number for UDP, which is how
+// WANIPv6FirewallControl identifies protocols, unlike WANIPConnection.
+const upnpProtocolNumberUDP = 17
+
+// wanIPv6FirewallControl1 is a client for the UPnP WANIPv6FirewallControl:1
+// service. It isn't generated in goupnp's internetgateway2 package, so like
+// legacyWANPPPConnection1 it's written out by hand, with only the actions we
+// need.
+type wanIPv6FirewallControl1 struct {
+	goupnp.ServiceClient
+}
+
+// GetFirewallStatus reports whether the device's IPv6 firewall is enabled,
+// and whether it allows creating inbound pinholes.
+func (client *wanIPv6FirewallControl1) GetFirewallStatus(ctx context.Context) (FirewallEnabled bool, InboundPinholeAllowed bool, err error) {
+	// Request structure.
+	request := any(nil)
+
+	// Response structure.
+	response := &struct {
+		FirewallEnabled       string
+		InboundPinholeAllowed string
+	}{}
+
+	// Perform the SOAP call.
+	if err = client.SOAPClient.PerformAction(ctx, urn_WANIPv6FirewallControl_1, "GetFirewallStatus", request, response); err != nil {
+		return
+	}
+
+	if FirewallEnabled, err = soap.UnmarshalBoolean(response.FirewallEnabled); err != nil {
+		return
+	}
+	if InboundPinholeAllowed, err = soap.UnmarshalBoolean(response.InboundPinholeAllowed); err != nil {
+		return
+	}
+	return
+}
+
+// AddPinhole opens a pinhole for inbound traffic from RemoteHost:RemotePort
+// to InternalClient:InternalPort, returning its ID. An empty RemoteHost and
+// zero RemotePort are wildcards.
+func (client *wanIPv6FirewallControl1) AddPinhole(
+	ctx context.Context,
+	RemoteHost string,
+	RemotePort uint16,
+	InternalClient string,
+	InternalPort uint16,
+	Protocol uint16,
+	LeaseTime uint32,
+) (UniqueID uint16, err error) {
+	// Request structure.
+	request := &struct {
+		RemoteHost     string
+		RemotePort     string
+		InternalClient string
+		InternalPort   string
+		Protocol       string
+		LeaseTime      string
+	}{}
+
+	if request.RemoteHost, err = soap.MarshalString(RemoteHost); err != nil {
+		return
+	}
+	if request.RemotePort, err = soap.MarshalUi2(RemotePort); err != nil {
+		return
+	}
+	if request.InternalClient, err = soap.MarshalString(InternalClient); err != nil {
+		return
+	}
+	if request.InternalPort, err = soap.MarshalUi2(InternalPort); err != nil {
+		return
+	}
+	if request.Protocol, err = soap.MarshalUi2(Protocol); err != nil {
+		return
+	}
+	if request.LeaseTime, err = soap.MarshalUi4(LeaseTime); err != nil {
+		return
+	}
+
+	// Response structure.
+	response := &struct {
+		UniqueID string
+	}{}
+
+	// Perform the SOAP call.
+	if err = client.SOAPClient.PerformAction(ctx, urn_WANIPv6FirewallControl_1, "AddPinhole", request, response); err != nil {
+		return
+	}
+
+	if UniqueID, err = soap.UnmarshalUi2(response.UniqueID); err != nil {
+		return
+	}
+	return
+}
+
+// UpdatePinhole extends the lease of the pinhole with the given ID.
+func (client *wanIPv6FirewallControl1) UpdatePinhole(ctx context.Context, UniqueID uint16, NewLeaseTime uint32) (err error) {
+	// Request structure.
+	request := &struct {
+		UniqueID     string
+		NewLeaseTime string
+	}{}
+	if request.UniqueID, err = soap.MarshalUi2(UniqueID); err != nil {
+		return
+	}
+	if request.NewLeaseTime, err = soap.MarshalUi4(NewLeaseTime); err != nil {
+		return
+	}
+
+	// Response structure.
+	response := any(nil)
+
+	// Perform the SOAP call.
+	return client.SOAPClient.PerformAction(ctx, urn_WANIPv6FirewallControl_1, "UpdatePinhole", request, response)
+}
+
+// DeletePinhole closes the pinhole with the given ID.
+func (client *wanIPv6FirewallControl1) DeletePinhole(ctx context.Context, UniqueID uint16) (err error) {
+	// Request structure.
+	request := &struct {
+		UniqueID string
+	}{}
+	if request.UniqueID, err = soap.MarshalUi2(UniqueID); err != nil {
+		return
+	}
+
+	// Response structure.
+	response := any(nil)
+
+	// Perform the SOAP call.
+	return client.SOAPClient.PerformAction(ctx, urn_WANIPv6FirewallControl_1, "DeletePinhole", request, response)
+}
+
+// upnpPinhole is an IPv6 firewall pinhole opened over UPnP. After being
+// created it is immutable, but the client field may be shared across pinhole
+// instances.
+type upnpPinhole struct {
+	internal   netip.AddrPort
+	id         uint16
+	goodUntil  time.Time
+	renewAfter time.Time
+
+	// rootDev is the UPnP root device, and may be reused when renewing.
+	rootDev *goupnp.RootDevice
+	// loc is the location used to fetch the rootDev
+	loc *url.URL
+	// client is the firewall control client that created the pinhole,
+	// used to renew and release it.
+	client *wanIPv6FirewallControl1
+}
+
+func (u *upnpPinhole) MappingType() string      { return "upnp-pinhole" }
+func (u *upnpPinhole) GoodUntil() time.Time     { return u.goodUntil }
+func (u *upnpPinhole) RenewAfter() time.Time    { return u.renewAfter }
+func (u *upnpPinhole) External() netip.AddrPort { return u.internal }
+func (u *upnpPinhole) MappingDebug() string {
+	return fmt.Sprintf("upnpPinhole{internal:%v, id:%d, renewAfter:%d, goodUntil:%d, loc:%q}",
+		u.internal, u.id,
+		u.renewAfter.Unix(), u.goodUntil.Unix(),
+		u.loc)
+}
+func (u *upnpPinhole) Release(ctx context.Context) {
+	u.client.DeletePinhole(ctx, u.id)
+}
+
+// getUPnPPinhole attempts to open an IPv6 firewall pinhole for internal over
+// UPnP, using the IGDs discovered behind the IPv4 gateway gw. If prev is a
+// pinhole for the same address, it's renewed in place if possible.
+func (c *Client) getUPnPPinhole(
+	ctx context.Context,
+	gw netip.Addr,
+	internal netip.AddrPort,
+	prev mapping,
+) (_ mapping, ok bool) {
+	if disableUPnpEnv() || c.debug.DisableUPnP || (c.controlKnobs != nil && c.controlKnobs.DisableUPnP.Load()) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	metas := c.uPnPMetas
+	ctx = goupnp.WithHTTPClient(ctx, c.upnpHTTPClientLocked())
+	c.mu.Unlock()
+
+	now := time.Now()
+	d := time.Duration(pmpMapLifetimeSec) * time.Second
+
+	old, _ := prev.(*upnpPinhole)
+	if old != nil && old.internal == internal {
+		err := old.client.UpdatePinhole(ctx, old.id, pmpMapLifetimeSec)
+		c.vlogf("UpdatePinhole(%d): %v", old.id, err)
+		if err == nil {
+			p := *old
+			p.goodUntil = now.Add(d)
+			p.renewAfter = now.Add(d / 2)
+			return &p, true
+		}
+		// The pinhole may have expired or the device restarted; fall
+		// through and open a new one, preferring the same device.
+	}
+
+	type step struct {
+		rootDev *goupnp.RootDevice // if nil, use 'meta'
+		loc     *url.URL           // non-nil if rootDev is non-nil
+		meta    uPnPDiscoResponse
+	}
+	var steps []step
+	if old != nil && old.rootDev != nil {
+		steps = append(steps, step{rootDev: old.rootDev, loc: old.loc})
+	}
+	for _, meta := range metas {
+		steps = append(steps, step{meta: meta})
+	}
+
+	for _, step := range steps {
+		rootDev, loc := step.rootDev, step.loc
+		if rootDev == nil {
+			var err error
+			rootDev, loc, err = getUPnPRootDevice(ctx, c.logf, c.debug, gw, step.meta)
+			if err != nil || rootDev == nil {
+				continue
+			}
+		}
+		clients, err := goupnp.NewServiceClientsFromRootDevice(ctx, rootDev, loc, urn_WANIPv6FirewallControl_1)
+		if err != nil {
+			continue
+		}
+		for _, sc := range clients {
+			fc := &wanIPv6FirewallControl1{sc}
+			if enabled, allowed, err := fc.GetFirewallStatus(ctx); err == nil && enabled && !allowed {
+				c.vlogf("UPnP IPv6 firewall at %v doesn't allow inbound pinholes", loc)
+				continue
+			}
+			id, err := fc.AddPinhole(ctx, "", 0, internal.Addr().String(), internal.Port(), upnpProtocolNumberUDP, pmpMapLifetimeSec)
+			c.vlogf("AddPinhole(%v): id=%d, err=%v", internal, id, err)
+			if err != nil {
+				if code, ok := getUPnPErrorCode(err); ok {
+					getUPnPErrorsMetric(code).Add(1)
+				}
+				continue
+			}
+			if old != nil {
+				go old.Release(context.Background())
+			}
+			return &upnpPinhole{
+				internal:   internal,
+				id:         id,
+				goodUntil:  now.Add(d),
+				renewAfter: now.Add(d / 2),
+				rootDev:    rootDev,
+				loc:        loc,
+				client:     fc,
+			}, true
+		}
+	}
+	return nil, false
+}
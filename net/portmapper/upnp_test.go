@@ -15,6 +15,7 @@
 	"reflect"
 	"regexp"
 	"slices"
+	"strings"
 	"sync/atomic"
 	"testing"
 
@@ -606,7 +607,7 @@ func TestGetUPnPPortMapping(t *testing.T) {
 			}
 			t.Logf("gw=%v myIP=%v", gw, myIP)
 
-			ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), prevPort)
+			ext, ok := c.getUPnPPortMapping(ctx, MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), prevPort)
 			if !ok {
 				t.Fatal("could not get UPnP port mapping")
 			}
@@ -701,7 +702,7 @@ func TestGetUPnPPortMapping_LeaseDuration(t *testing.T) {
 			}
 			t.Logf("gw=%v myIP=%v", gw, myIP)
 
-			ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+			ext, ok := c.getUPnPPortMapping(ctx, MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), 0)
 			if !ok {
 				t.Fatal("could not get UPnP port mapping")
 			}
@@ -745,7 +746,7 @@ func TestGetUPnPPortMapping_NoValidServices(t *testing.T) {
 	}
 
 	// This shouldn't panic
-	_, ok = c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+	_, ok = c.getUPnPPortMapping(ctx, MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), 0)
 	if ok {
 		t.Fatal("did not expect to get UPnP port mapping")
 	}
@@ -786,7 +787,7 @@ func TestGetUPnPPortMapping_Legacy(t *testing.T) {
 		t.Fatalf("could not get gateway and self IP")
 	}
 
-	ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+	ext, ok := c.getUPnPPortMapping(ctx, MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), 0)
 	if !ok {
 		t.Fatal("could not get UPnP port mapping")
 	}
@@ -820,7 +821,7 @@ func TestGetUPnPPortMappingNoResponses(t *testing.T) {
 		}}
 		c.mu.Unlock()
 
-		_, ok := c.getUPnPPortMapping(context.Background(), gw, netip.AddrPortFrom(myIP, 12345), 0)
+		_, ok := c.getUPnPPortMapping(context.Background(), MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), 0)
 		if ok {
 			t.Errorf("expected no mapping when there are no responses")
 		}
@@ -945,7 +946,7 @@ func TestGetUPnPPortMapping_Invalid(t *testing.T) {
 				t.Fatalf("could not get gateway and self IP")
 			}
 
-			ext, ok := c.getUPnPPortMapping(ctx, gw, netip.AddrPortFrom(myIP, 12345), 0)
+			ext, ok := c.getUPnPPortMapping(ctx, MappingKey{}, gw, netip.AddrPortFrom(myIP, 12345), 0)
 			if ok {
 				t.Fatal("did not expect to get UPnP port mapping")
 			}
@@ -956,6 +957,110 @@ func TestGetUPnPPortMapping_Invalid(t *testing.T) {
 	}
 }
 
+func TestGetUPnPPinhole(t *testing.T) {
+	igd, err := NewTestIGD(t, TestIGDOptions{UPnP: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	internal := netip.MustParseAddrPort("[2001:db8::1234]:41641")
+	var added, updated, deleted atomic.Int32
+	handlers := map[string]any{
+		"GetFirewallStatus": testGetFirewallStatusResponse,
+		"AddPinhole": func(body []byte) (int, string) {
+			var req struct {
+				RemoteHost     string
+				RemotePort     string
+				InternalClient string
+				InternalPort   string
+				Protocol       string
+			}
+			if err := xml.Unmarshal(body, &req); err != nil {
+				t.Errorf("bad request: %v", err)
+				return http.StatusBadRequest, "bad request"
+			}
+			if req.RemoteHost != "" || req.RemotePort != "0" {
+				t.Errorf("got remote %q:%q, want wildcard", req.RemoteHost, req.RemotePort)
+			}
+			if req.InternalClient != "2001:db8::1234" || req.InternalPort != "41641" {
+				t.Errorf("got internal %s:%s, want %v", req.InternalClient, req.InternalPort, internal)
+			}
+			if req.Protocol != "17" {
+				t.Errorf(`got Protocol=%q, want "17"`, req.Protocol)
+			}
+			added.Add(1)
+			return http.StatusOK, testAddPinholeResponse
+		},
+		"UpdatePinhole": func(body []byte) (int, string) {
+			var req struct {
+				UniqueID string
+			}
+			if err := xml.Unmarshal(body, &req); err != nil {
+				t.Errorf("bad request: %v", err)
+				return http.StatusBadRequest, "bad request"
+			}
+			if req.UniqueID != "7" {
+				t.Errorf("UpdatePinhole: got UniqueID=%q, want 7", req.UniqueID)
+			}
+			updated.Add(1)
+			return http.StatusOK, testUpdatePinholeResponse
+		},
+		"DeletePinhole": func(body []byte) (int, string) {
+			deleted.Add(1)
+			return http.StatusOK, testDeletePinholeResponse
+		},
+	}
+	igd.SetUPnPHandler(&upnpServer{
+		t:    t,
+		Desc: testRootDescIPv6,
+		Control: map[string]map[string]any{
+			"/ctl/IP6FCtl": handlers,
+		},
+	})
+
+	c := newTestClient(t, igd, nil)
+	c.debug.VerboseLogs = true
+
+	ctx := context.Background()
+	mustProbeUPnP(t, ctx, c)
+	gw, _, ok := c.gatewayAndSelfIP()
+	if !ok {
+		t.Fatalf("could not get gateway and self IP")
+	}
+
+	m, ok := c.getUPnPPinhole(ctx, gw, internal, nil)
+	if !ok {
+		t.Fatal("could not get UPnP pinhole")
+	}
+	if got := m.External(); got != internal {
+		t.Errorf("External = %v; want %v", got, internal)
+	}
+	if got, want := m.MappingType(), "upnp-pinhole"; got != want {
+		t.Errorf("MappingType = %q; want %q", got, want)
+	}
+
+	// Renewing the pinhole should update it in place.
+	m2, ok := c.getUPnPPinhole(ctx, gw, internal, m)
+	if !ok {
+		t.Fatal("could not renew UPnP pinhole")
+	}
+	if got := m2.(*upnpPinhole).id; got != 7 {
+		t.Errorf("renewed pinhole id = %d; want 7", got)
+	}
+	m2.Release(ctx)
+
+	if got := added.Load(); got != 1 {
+		t.Errorf("AddPinhole called %d times; want 1", got)
+	}
+	if got := updated.Load(); got != 1 {
+		t.Errorf("UpdatePinhole called %d times; want 1", got)
+	}
+	if got := deleted.Load(); got != 1 {
+		t.Errorf("DeletePinhole called %d times; want 1", got)
+	}
+}
+
 type upnpServer struct {
 	t       *testing.T
 	Desc    string                    // root device XML
@@ -1112,6 +1217,16 @@ func mustProbeUPnP(tb testing.TB, ctx context.Context, c *Client) ProbeResult {
 </root>
 `
 
+// testRootDescIPv6 is testRootDesc with an IPv6 firewall control service.
+var testRootDescIPv6 = strings.Replace(testRootDesc, "</serviceList>", `  <service>
+		<serviceType>urn:schemas-upnp-org:service:WANIPv6FirewallControl:1</serviceType>
+		<serviceId>urn:upnp-org:serviceId:WANIPv6Firewall1</serviceId>
+		<SCPDURL>/WANIP6FC.xml</SCPDURL>
+		<controlURL>/ctl/IP6FCtl</controlURL>
+		<eventSubURL>/evt/IP6FCtl</eventSubURL>
+	      </service>
+	    </serviceList>`, 1)
+
 const testAddPortMappingPermanentLease = `<?xml version="1.0"?>
 <s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
   <s:Body>
@@ -1206,6 +1321,43 @@ func mustProbeUPnP(tb testing.TB, ctx context.Context, c *Client) ProbeResult {
 </s:Envelope>
 `
 
+const testGetFirewallStatusResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetFirewallStatusResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">
+      <FirewallEnabled>1</FirewallEnabled>
+      <InboundPinholeAllowed>1</InboundPinholeAllowed>
+    </u:GetFirewallStatusResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+const testAddPinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:AddPinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1">
+      <UniqueID>7</UniqueID>
+    </u:AddPinholeResponse>
+  </s:Body>
+</s:Envelope>
+`
+
+const testUpdatePinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:UpdatePinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"/>
+  </s:Body>
+</s:Envelope>
+`
+
+const testDeletePinholeResponse = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:DeletePinholeResponse xmlns:u="urn:schemas-upnp-org:service:WANIPv6FirewallControl:1"/>
+  </s:Body>
+</s:Envelope>
+`
+
 func makeGetExternalIPAddressResponse(ip string) string {
 	return fmt.Sprintf(`<?xml version="1.0"?>
 <s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
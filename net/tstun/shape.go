@@ -0,0 +1,427 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"bytes"
+	"cmp"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gaissmai/bart"
+	"tailscale.com/net/packet"
+	"tailscale.com/net/packet/checksum"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/qos"
+	"tailscale.com/util/usermetric"
+)
+
+// ShapingConfig configures the shaping and marking of packets sent to peers.
+// The zero value leaves them alone.
+type ShapingConfig struct {
+	// MaxRate, if non-zero, limits the total rate, in bits per second, at
+	// which packets are sent to peers. Packets beyond it are queued, and
+	// sent highest priority first.
+	MaxRate uint64
+
+	// Classes are classes of traffic, by destination. A packet belongs to
+	// the class with the most specific prefix containing its destination,
+	// or the first such class for equally specific prefixes.
+	Classes []ShapingClass
+}
+
+// ShapingClass configures the shaping and marking of a class of packets.
+type ShapingClass struct {
+	Dsts     []netip.Prefix
+	Rate     uint64       // in bits per second, or zero for no limit
+	Burst    int          // in bytes, or zero for a default based on Rate
+	DSCP     uint8        // or zero to leave packets' DSCP alone
+	Priority qos.Priority // when queued because of MaxRate
+}
+
+func (c *ShapingConfig) equal(c2 *ShapingConfig) bool {
+	if c == nil || c2 == nil {
+		return c == c2
+	}
+	return c.MaxRate == c2.MaxRate && slices.EqualFunc(c.Classes, c2.Classes, func(a, b ShapingClass) bool {
+		return slices.Equal(a.Dsts, b.Dsts) &&
+			a.Rate == b.Rate &&
+			a.Burst == b.Burst &&
+			a.DSCP == b.DSCP &&
+			a.Priority == b.Priority
+	})
+}
+
+// SetShaping sets how packets sent to peers are shaped and marked. A nil or
+// zero cfg turns shaping off. Packets queued under a previous, different
+// configuration are dropped.
+//
+// The shaping applies both to packets read from the TUN device and to those
+// injected by netstack.
+func (t *Wrapper) SetShaping(cfg *ShapingConfig) {
+	if cfg != nil && cfg.MaxRate == 0 && len(cfg.Classes) == 0 {
+		cfg = nil
+	}
+
+	t.shapingMu.Lock()
+	defer t.shapingMu.Unlock()
+	old := t.shaper.Load()
+	if old == nil && cfg == nil || old != nil && old.cfg.equal(cfg) {
+		return
+	}
+	var s *shaper
+	if cfg != nil {
+		s = newShaper(cfg, mono.Now())
+		t.logf("traffic shaping: %d classes, max rate %d bit/s", len(cfg.Classes), cfg.MaxRate)
+	} else {
+		t.logf("traffic shaping: off")
+	}
+	t.shaper.Store(s)
+	if old != nil {
+		old.close()
+	}
+}
+
+// shapeOutbound returns sh's verdict on the packet p, first marking it as
+// configured. Unless the verdict is shapeSend, p must not be sent to WireGuard
+// now: sh has either queued it to be returned by a later readShaped, or
+// dropped it.
+func (t *Wrapper) shapeOutbound(sh *shaper, p *packet.Parsed, now mono.Time) shapeVerdict {
+	v := sh.admit(p, now)
+	if v == shapeDrop {
+		metricPacketOutDropRateLimit.Add(1)
+		t.metrics.outboundDroppedPacketsTotal.Add(usermetric.DropLabels{
+			Reason: usermetric.ReasonRateLimit,
+		}, 1)
+	}
+	return v
+}
+
+// shapeBuffs is like shapeOutbound, but for the n packets in buffs. It moves
+// those that may be sent now to the front of buffs and returns how many there
+// are, along with how many of the n packets sh didn't drop.
+func (t *Wrapper) shapeBuffs(sh *shaper, buffs [][]byte, sizes []int, offset, n int) (kept, admitted int) {
+	p := parsedPacketPool.Get().(*packet.Parsed)
+	defer parsedPacketPool.Put(p)
+	now := mono.Now()
+	for i := range n {
+		p.Decode(buffs[i][offset : offset+sizes[i]])
+		v := t.shapeOutbound(sh, p, now)
+		if v != shapeDrop {
+			admitted++
+		}
+		if v != shapeSend {
+			continue
+		}
+		if kept != i {
+			// Copy rather than swap buffers: callers may track which
+			// buffer is which by index.
+			sizes[kept] = copy(buffs[kept][offset:], buffs[i][offset:offset+sizes[i]])
+		}
+		kept++
+	}
+	return kept, admitted
+}
+
+// readShaped fills buffs with packets that sh queued and that may now be
+// sent, and returns how many it filled.
+func (t *Wrapper) readShaped(sh *shaper, buffs [][]byte, sizes []int, offset int) int {
+	n := sh.dequeue(buffs, sizes, offset, mono.Now())
+	if n == 0 {
+		return 0
+	}
+	if stats := t.stats.Load(); stats != nil {
+		for i := range n {
+			stats.UpdateTxVirtual(buffs[i][offset : offset+sizes[i]])
+		}
+	}
+	t.noteActivity()
+	return n
+}
+
+const (
+	// defaultBurst is how long, at a class's rate, its burst lasts if it
+	// doesn't set one, subject to minDefaultBurst.
+	defaultBurst    = 50 * time.Millisecond
+	minDefaultBurst = 16 << 10
+
+	// maxQueueDelay is how long, at a class's rate (or else MaxRate), its
+	// queued packets may take to send before more are dropped, subject to
+	// minQueueBytes.
+	maxQueueDelay = 200 * time.Millisecond
+	minQueueBytes = 64 << 10
+)
+
+// Queueing bands, in priority order.
+const (
+	bandHigh = iota
+	bandNormal
+	bandLow
+	numBands
+)
+
+func priorityBand(p qos.Priority) int {
+	switch p {
+	case qos.PriorityHigh:
+		return bandHigh
+	case qos.PriorityLow:
+		return bandLow
+	}
+	return bandNormal
+}
+
+type shapeVerdict int
+
+const (
+	shapeSend   shapeVerdict = iota // send now
+	shapeQueued                     // queued for later
+	shapeDrop                       // queue full; dropped
+)
+
+// shaper rate limits, queues, and marks packets sent to peers, according to
+// a ShapingConfig.
+type shaper struct {
+	cfg   *ShapingConfig
+	byDst bart.Table[*shapeClass]
+	def   *shapeClass  // for packets in no configured class
+	total *tokenBucket // or nil, if there's no MaxRate
+
+	// wake is sent to, without blocking, when queued packets may be
+	// sendable, and when the shaper is closed.
+	wake chan struct{}
+
+	mu     sync.Mutex
+	active [numBands][]*shapeClass // classes with queued packets
+	timer  *time.Timer             // or nil; fires when queued packets may be sendable
+	closed bool
+}
+
+// shapeClass is the state of a class of traffic.
+type shapeClass struct {
+	dscp       uint8
+	band       int
+	bucket     *tokenBucket // or nil, if unlimited
+	queueLimit int          // max bytes in queue
+
+	// The following fields are guarded by shaper.mu.
+	queue       [][]byte // FIFO of packets awaiting tokens
+	queuedBytes int
+}
+
+func newShaper(cfg *ShapingConfig, now mono.Time) *shaper {
+	s := &shaper{
+		cfg:   cfg,
+		total: newTokenBucket(cfg.MaxRate, 0, now),
+		wake:  make(chan struct{}, 1),
+	}
+	s.def = &shapeClass{band: bandNormal, queueLimit: queueLimit(cfg.MaxRate)}
+	for _, sc := range cfg.Classes {
+		c := &shapeClass{
+			dscp:       sc.DSCP,
+			band:       priorityBand(sc.Priority),
+			bucket:     newTokenBucket(sc.Rate, sc.Burst, now),
+			queueLimit: queueLimit(cmp.Or(sc.Rate, cfg.MaxRate)),
+		}
+		for _, pfx := range sc.Dsts {
+			pfx = pfx.Masked()
+			if _, ok := s.byDst.Get(pfx); !ok {
+				s.byDst.Insert(pfx, c)
+			}
+		}
+	}
+	return s
+}
+
+func queueLimit(bitsPerSec uint64) int {
+	return max(int(float64(bitsPerSec)/8*maxQueueDelay.Seconds()), minQueueBytes)
+}
+
+// admit marks the packet p as configured and decides whether it may be sent
+// now. If it may not, admit queues a copy of it to be returned by a later
+// dequeue, or drops it if its class's queue is full.
+func (s *shaper) admit(p *packet.Parsed, now mono.Time) shapeVerdict {
+	c, ok := s.byDst.Lookup(p.Dst.Addr())
+	if !ok {
+		c = s.def
+	}
+	if c.dscp != 0 {
+		checksum.UpdateDSCP(p, c.dscp)
+	}
+	if c.bucket == nil && s.total == nil {
+		return shapeSend
+	}
+
+	pkt := p.Buffer()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return shapeDrop
+	}
+	// Queue behind any already queued packets of the class, so as not to
+	// reorder them.
+	if len(c.queue) == 0 && c.bucket.wait(len(pkt), now) == 0 && s.total.wait(len(pkt), now) == 0 {
+		c.bucket.take(len(pkt))
+		s.total.take(len(pkt))
+		return shapeSend
+	}
+	if c.queuedBytes+len(pkt) > c.queueLimit {
+		return shapeDrop
+	}
+	c.queue = append(c.queue, bytes.Clone(pkt))
+	c.queuedBytes += len(pkt)
+	if len(c.queue) == 1 {
+		s.active[c.band] = append(s.active[c.band], c)
+	}
+	s.scheduleLocked(now)
+	return shapeQueued
+}
+
+// dequeue moves queued packets that may now be sent into buffs and returns
+// how many it moved. It takes packets from higher priority bands first and,
+// within a band, from each class in turn.
+func (s *shaper) dequeue(buffs [][]byte, sizes []int, offset int, now mono.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	defer s.scheduleLocked(now)
+	for band := range s.active {
+		for progress := true; progress && n < len(buffs); {
+			progress = false
+			for i := 0; i < len(s.active[band]) && n < len(buffs); {
+				c := s.active[band][i]
+				pkt := c.queue[0]
+				if c.bucket.wait(len(pkt), now) > 0 {
+					i++
+					continue
+				}
+				if s.total.wait(len(pkt), now) > 0 {
+					// Lower priority bands must wait for this one.
+					return n
+				}
+				c.bucket.take(len(pkt))
+				s.total.take(len(pkt))
+				sizes[n] = copy(buffs[n][offset:], pkt)
+				n++
+				progress = true
+
+				c.queue[0] = nil
+				c.queue = c.queue[1:]
+				c.queuedBytes -= len(pkt)
+				if len(c.queue) == 0 {
+					s.active[band] = slices.Delete(s.active[band], i, i+1)
+				} else {
+					i++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// scheduleLocked arranges for s.wake to be sent to when the first of the
+// queued packets may be sent.
+//
+// s.mu must be held.
+func (s *shaper) scheduleLocked(now mono.Time) {
+	if s.closed {
+		return
+	}
+	next := time.Duration(-1)
+	for _, cs := range s.active {
+		for _, c := range cs {
+			n := len(c.queue[0])
+			d := max(c.bucket.wait(n, now), s.total.wait(n, now))
+			if next < 0 || d < next {
+				next = d
+			}
+		}
+	}
+	switch {
+	case next < 0:
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	case next == 0:
+		s.signal()
+	case s.timer == nil:
+		s.timer = time.AfterFunc(next, s.signal)
+	default:
+		s.timer.Reset(next)
+	}
+}
+
+func (s *shaper) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close drops any queued packets and wakes any reader waiting on s.
+func (s *shaper) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	for band, cs := range s.active {
+		for _, c := range cs {
+			c.queue, c.queuedBytes = nil, 0
+		}
+		s.active[band] = nil
+	}
+	s.signal()
+}
+
+// tokenBucket is a token bucket rate limiter of bytes. A nil tokenBucket
+// imposes no limit.
+type tokenBucket struct {
+	rate   float64 // bytes per second
+	burst  float64 // max tokens
+	tokens float64 // may go negative; see wait
+	last   mono.Time
+}
+
+// newTokenBucket returns a token bucket that allows bitsPerSec, with bursts
+// of burst bytes (or a default, if zero), or nil if bitsPerSec is zero.
+func newTokenBucket(bitsPerSec uint64, burst int, now mono.Time) *tokenBucket {
+	if bitsPerSec == 0 {
+		return nil
+	}
+	rate := float64(bitsPerSec) / 8
+	b := float64(burst)
+	if burst == 0 {
+		b = max(rate*defaultBurst.Seconds(), minDefaultBurst)
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// wait returns how long until n bytes may be taken from b, or zero if they
+// may be taken now.
+func (b *tokenBucket) wait(n int, now mono.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if now > b.last {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	// Let packets bigger than the burst through once the bucket is full,
+	// rather than never.
+	need := min(float64(n), b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return max(time.Duration((need-b.tokens)/b.rate*float64(time.Second)), 1)
+}
+
+// take takes n bytes' tokens from b, which must have been allowed by wait.
+func (b *tokenBucket) take(n int) {
+	if b != nil {
+		b.tokens -= float64(n)
+	}
+}
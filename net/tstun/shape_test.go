@@ -0,0 +1,280 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"expvar"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/qos"
+	"tailscale.com/util/usermetric"
+)
+
+// udp4Sized returns an IPv4 UDP packet to dst with a payload of n bytes.
+func udp4Sized(dst string, n int) []byte {
+	header := &packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			Src: netip.MustParseAddr("1.2.3.4"),
+			Dst: netip.MustParseAddr(dst),
+		},
+		SrcPort: 123,
+		DstPort: 456,
+	}
+	return packet.Generate(header, make([]byte, n))
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := mono.Now()
+	if b := newTokenBucket(0, 0, now); b != nil {
+		t.Fatalf("zero rate bucket = %+v; want nil", b)
+	}
+	var nilBucket *tokenBucket
+	if d := nilBucket.wait(1<<20, now); d != 0 {
+		t.Errorf("nil bucket wait = %v; want 0", d)
+	}
+
+	b := newTokenBucket(8000, 1000, now) // 1000 bytes/s
+	if d := b.wait(1000, now); d != 0 {
+		t.Fatalf("full bucket wait = %v; want 0", d)
+	}
+	b.take(1000)
+	if d := b.wait(500, now); d != 500*time.Millisecond {
+		t.Errorf("empty bucket wait = %v; want 500ms", d)
+	}
+	if d := b.wait(500, now+mono.Time(500*time.Millisecond)); d != 0 {
+		t.Errorf("refilled bucket wait = %v; want 0", d)
+	}
+
+	// Packets bigger than the burst go once the bucket is full.
+	if d := b.wait(5000, now+mono.Time(time.Second)); d != 0 {
+		t.Errorf("oversized packet wait = %v; want 0", d)
+	}
+
+	if b := newTokenBucket(1<<20, 0, now); b.burst != minDefaultBurst {
+		t.Errorf("default burst = %v; want %v", b.burst, minDefaultBurst)
+	}
+}
+
+func TestShaperRateLimit(t *testing.T) {
+	now := mono.Now()
+	s := newShaper(&ShapingConfig{
+		Classes: []ShapingClass{{
+			Dsts:  []netip.Prefix{netip.MustParsePrefix("5.6.7.8/32")},
+			Rate:  8000, // 1000 bytes/s
+			Burst: 1000,
+		}},
+	}, now)
+	defer s.close()
+
+	var p packet.Parsed
+	admit := func(dst string, at mono.Time) shapeVerdict {
+		p.Decode(udp4Sized(dst, 472)) // 500 bytes
+		return s.admit(&p, at)
+	}
+	for i, want := range []shapeVerdict{shapeSend, shapeSend, shapeQueued, shapeQueued} {
+		if got := admit("5.6.7.8", now); got != want {
+			t.Fatalf("packet %d: admit = %v; want %v", i, got, want)
+		}
+	}
+	// Other destinations aren't limited.
+	if got := admit("9.9.9.9", now); got != shapeSend {
+		t.Errorf("unclassified admit = %v; want shapeSend", got)
+	}
+
+	buffs := [][]byte{make([]byte, MaxPacketSize), make([]byte, MaxPacketSize)}
+	sizes := make([]int, len(buffs))
+	if n := s.dequeue(buffs, sizes, 0, now); n != 0 {
+		t.Fatalf("dequeue before refill = %d; want 0", n)
+	}
+	if n := s.dequeue(buffs, sizes, 0, now+mono.Time(500*time.Millisecond)); n != 1 || sizes[0] != 500 {
+		t.Fatalf("dequeue after 500ms = %d (sizes %v); want one 500 byte packet", n, sizes)
+	}
+	// A new packet queues behind the one still queued, even if there
+	// would be tokens for it by the time it arrives.
+	if got := admit("5.6.7.8", now+mono.Time(time.Second)); got != shapeQueued {
+		t.Errorf("admit behind queue = %v; want shapeQueued", got)
+	}
+	if n := s.dequeue(buffs, sizes, 0, now+mono.Time(2*time.Second)); n != 2 {
+		t.Fatalf("dequeue after 2s = %d; want 2", n)
+	}
+
+	// Fill the queue until packets drop.
+	var dropped bool
+	for range 2 * minQueueBytes / 500 {
+		if admit("5.6.7.8", now+mono.Time(2*time.Second)) == shapeDrop {
+			dropped = true
+			break
+		}
+	}
+	if !dropped {
+		t.Error("queue never filled")
+	}
+}
+
+func TestShaperPriority(t *testing.T) {
+	now := mono.Now()
+	s := newShaper(&ShapingConfig{
+		MaxRate: 8000,
+		Classes: []ShapingClass{
+			{Dsts: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, Priority: qos.PriorityLow},
+			{Dsts: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}, Priority: qos.PriorityHigh},
+		},
+	}, now)
+	defer s.close()
+
+	var p packet.Parsed
+	admit := func(dst string) shapeVerdict {
+		p.Decode(udp4Sized(dst, 9972)) // 10000 bytes, more than the burst
+		return s.admit(&p, now)
+	}
+	if got := admit("10.0.0.2"); got != shapeSend {
+		t.Fatalf("first admit = %v; want shapeSend", got)
+	}
+	if got := admit("10.0.0.2"); got != shapeQueued {
+		t.Fatalf("low admit = %v; want shapeQueued", got)
+	}
+	if got := admit("10.0.0.1"); got != shapeQueued {
+		t.Fatalf("high admit = %v; want shapeQueued", got)
+	}
+
+	buffs := [][]byte{make([]byte, MaxPacketSize), make([]byte, MaxPacketSize)}
+	sizes := make([]int, len(buffs))
+	later := now + mono.Time(time.Minute)
+	if n := s.dequeue(buffs, sizes, 0, later); n != 1 {
+		t.Fatalf("dequeue = %d; want 1", n)
+	}
+	p.Decode(buffs[0][:sizes[0]])
+	if got, want := p.Dst.Addr(), netip.MustParseAddr("10.0.0.1"); got != want {
+		t.Errorf("first dequeued packet to %v; want %v", got, want)
+	}
+	if n := s.dequeue(buffs, sizes, 0, later+mono.Time(time.Minute)); n != 1 {
+		t.Fatalf("second dequeue = %d; want 1", n)
+	}
+	p.Decode(buffs[0][:sizes[0]])
+	if got, want := p.Dst.Addr(), netip.MustParseAddr("10.0.0.2"); got != want {
+		t.Errorf("second dequeued packet to %v; want %v", got, want)
+	}
+}
+
+func TestShaperMarksDSCP(t *testing.T) {
+	s := newShaper(&ShapingConfig{
+		Classes: []ShapingClass{{
+			Dsts: []netip.Prefix{netip.MustParsePrefix("5.6.7.0/24")},
+			DSCP: 46,
+		}},
+	}, mono.Now())
+	defer s.close()
+
+	var p packet.Parsed
+	for dst, want := range map[string]byte{"5.6.7.8": 46 << 2, "9.9.9.9": 0} {
+		pkt := udp4Sized(dst, 10)
+		p.Decode(pkt)
+		if got := s.admit(&p, mono.Now()); got != shapeSend {
+			t.Fatalf("admit to %s = %v; want shapeSend", dst, got)
+		}
+		if pkt[1] != want {
+			t.Errorf("TOS to %s = %#x; want %#x", dst, pkt[1], want)
+		}
+	}
+}
+
+func TestWrapperShaping(t *testing.T) {
+	chtun, tun := newChannelTUN(t.Logf, false)
+	defer tun.Close()
+	tun.metrics.outboundDroppedPacketsTotal.ResetAllForTest()
+
+	cfg := &ShapingConfig{
+		Classes: []ShapingClass{{
+			Dsts:  []netip.Prefix{netip.MustParsePrefix("5.6.7.8/32")},
+			Rate:  80000, // 10000 bytes/s
+			Burst: 1000,
+		}},
+	}
+	tun.SetShaping(cfg)
+	sh := tun.shaper.Load()
+	tun.SetShaping(&ShapingConfig{Classes: cfg.Classes})
+	if tun.shaper.Load() != sh {
+		t.Error("SetShaping with equal config replaced the shaper")
+	}
+
+	// Two packets fit in the burst; the third, which is injected as
+	// netstack would, must wait for tokens.
+	go func() {
+		chtun.Outbound <- udp4Sized("5.6.7.8", 472)
+		chtun.Outbound <- udp4Sized("5.6.7.8", 472)
+		if err := tun.InjectOutbound(udp4Sized("5.6.7.8", 472)); err != nil {
+			t.Error(err)
+		}
+	}()
+	buffs := [][]byte{make([]byte, MaxPacketSize)}
+	sizes := make([]int, 1)
+	start := time.Now()
+	var got int
+	for got < 3 {
+		n, err := tun.Read(buffs, sizes, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += n
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("read 3 packets in %v; want at least 40ms", d)
+	}
+
+	// Drops are counted.
+	p := new(packet.Parsed)
+	for range 2 * minQueueBytes / 500 {
+		p.Decode(udp4Sized("5.6.7.8", 472))
+		tun.shapeOutbound(sh, p, mono.Now())
+	}
+	m, _ := tun.metrics.outboundDroppedPacketsTotal.Get(usermetric.DropLabels{Reason: usermetric.ReasonRateLimit}).(*expvar.Int)
+	if m == nil || m.Value() == 0 {
+		t.Error("no rate limit drops counted")
+	}
+
+	tun.SetShaping(nil)
+	if tun.shaper.Load() != nil {
+		t.Error("shaper still set after SetShaping(nil)")
+	}
+}
+
+func TestInjectedReadShapingDropsNotCounted(t *testing.T) {
+	_, tun := newChannelTUN(t.Logf, false)
+	defer tun.Close()
+	tun.SetShaping(&ShapingConfig{
+		Classes: []ShapingClass{{
+			Dsts:  []netip.Prefix{netip.MustParsePrefix("5.6.7.8/32")},
+			Rate:  8, // 1 byte/s
+			Burst: 1,
+		}},
+	})
+	sh := tun.shaper.Load()
+
+	// Fill the queue, so that the next packet is dropped.
+	p := new(packet.Parsed)
+	for {
+		p.Decode(udp4Sized("5.6.7.8", 472))
+		if tun.shapeOutbound(sh, p, mono.Now()) == shapeDrop {
+			break
+		}
+	}
+
+	before := metricPacketOut.Value()
+	buffs := [][]byte{make([]byte, MaxPacketSize)}
+	sizes := make([]int, 1)
+	n, err := tun.injectedRead(tunInjectedRead{data: udp4Sized("5.6.7.8", 472)}, buffs, sizes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("injectedRead returned %d packets; want 0", n)
+	}
+	if got := metricPacketOut.Value(); got != before {
+		t.Errorf("tstun_out_to_wg went from %d to %d for a dropped packet", before, got)
+	}
+}
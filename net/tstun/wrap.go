@@ -209,6 +209,10 @@ type Wrapper struct {
 
 	captureHook syncs.AtomicValue[packet.CaptureCallback]
 
+	// shaper, if non-nil, shapes and marks packets sent to peers.
+	shaper    atomic.Pointer[shaper]
+	shapingMu sync.Mutex // serializes SetShaping
+
 	metrics *metrics
 }
 
@@ -355,6 +359,9 @@ func (t *Wrapper) Close() error {
 		t.outboundClosed = true
 		close(t.vectorOutbound)
 		t.outboundMu.Unlock()
+		if sh := t.shaper.Load(); sh != nil {
+			sh.close()
+		}
 		err = t.tdev.Close()
 	})
 	return err
@@ -925,8 +932,26 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	if !t.started.Load() {
 		t.awaitStart()
 	}
+	sh := t.shaper.Load()
+	if sh != nil {
+		// Packets held back earlier go first.
+		if n := t.readShaped(sh, buffs, sizes, offset); n > 0 {
+			return n, nil
+		}
+	}
 	// packet from OS read and sent to WG
-	res, ok := <-t.vectorOutbound
+	var res tunVectorReadResult
+	var ok bool
+	if sh == nil {
+		res, ok = <-t.vectorOutbound
+	} else {
+		select {
+		case res, ok = <-t.vectorOutbound:
+		case <-sh.wake:
+			// Empty reads are fine; WireGuard calls Read again.
+			return t.readShaped(sh, buffs, sizes, offset), nil
+		}
+	}
 	if !ok {
 		return 0, io.EOF
 	}
@@ -944,6 +969,10 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	defer parsedPacketPool.Put(p)
 	captHook := t.captureHook.Load()
 	pc := t.peerConfig.Load()
+	var now mono.Time
+	if sh != nil {
+		now = mono.Now()
+	}
 	var buffsGRO *gro.GRO
 	for _, data := range res.data {
 		p.Decode(data[res.dataOffset:])
@@ -968,6 +997,9 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 		// Make sure to do SNAT after filtering, so that any flow tracking in
 		// the filter sees the original source address. See #12133.
 		pc.snat(p)
+		if sh != nil && t.shapeOutbound(sh, p, now) != shapeSend {
+			continue
+		}
 		n := copy(buffs[buffsPos][offset:], p.Buffer())
 		if n != len(data)-res.dataOffset {
 			panic(fmt.Sprintf("short copy: %d != %d", n, len(data)-res.dataOffset))
@@ -1088,6 +1120,15 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, outBuffs [][]byte, sizes []i
 		}
 		n, err = tun.GSOSplit(pkt, gsoOptions, outBuffs, sizes, offset)
 	}
+	if sh := t.shaper.Load(); sh != nil {
+		// Count packets as they're admitted by the shaper, including those
+		// it queues, which readShaped doesn't count again.
+		var admitted int
+		n, admitted = t.shapeBuffs(sh, outBuffs, sizes, offset, n)
+		metricPacketOut.Add(int64(admitted))
+	} else {
+		metricPacketOut.Add(int64(n))
+	}
 
 	if stats := t.stats.Load(); stats != nil {
 		for i := 0; i < n; i++ {
@@ -1096,7 +1137,6 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, outBuffs [][]byte, sizes []i
 	}
 
 	t.noteActivity()
-	metricPacketOut.Add(int64(n))
 	return n, err
 }
 
@@ -1488,6 +1528,7 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
 	metricPacketOutDropSelfDisco = clientmetric.NewCounter("tstun_out_to_wg_drop_self_disco")
+	metricPacketOutDropRateLimit = clientmetric.NewCounter("tstun_out_to_wg_drop_rate_limit")
 )
 
 func (t *Wrapper) InstallCaptureHook(cb packet.CaptureCallback) {
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"tailscale.com/types/nettype"
+)
+
+var (
+	// This acts as a compile-time check for our usage of ipv6.Message in
+	// batchingConn for both IPv6 and IPv4 operations.
+	_ ipv6.Message = ipv4.Message{}
+)
+
+const (
+	// batchSize is the maximum number of packets read or written per
+	// batchingConn syscall.
+	batchSize = 32
+	// maxPacketLen is the size of the buffers packets are read into.
+	maxPacketLen = 1<<16 - 1
+)
+
+// batchingConn is a nettype.PacketConn that provides batched i/o, and
+// optionally the local address packets were received on. The local address
+// is used as the source address of packets sent in reply, which matters on
+// hosts with multiple addresses in the same address family, where the kernel
+// may otherwise select a source address the peer does not expect.
+type batchingConn interface {
+	nettype.PacketConn
+	// ReadBatch reads up to len(msgs) packets, returning the number of
+	// packets read. msgs[i].Buffers must contain a single buffer, and
+	// msgs[i].OOB must be at least controlMessageSize bytes long.
+	ReadBatch(msgs []ipv6.Message, flags int) (n int, err error)
+	// WriteBatch writes all msgs, returning the first error encountered.
+	// msgs[i].Buffers must contain a single buffer.
+	WriteBatch(msgs []ipv6.Message) error
+	// LocalAddrFromControl returns the local address a packet with socket
+	// control message 'control' was received on, or the zero value if
+	// unknown.
+	LocalAddrFromControl(control []byte) netip.Addr
+	// AppendSourceControl appends a socket control message to 'control'
+	// that sets the source address of a written packet to 'src'. It returns
+	// 'control' unmodified if 'src' is invalid, or setting the source
+	// address is unsupported.
+	AppendSourceControl(control []byte, src netip.Addr) []byte
+}
+
+// readSingle reads a single packet from uc into msgs[0]. It is used where
+// batched reads are unavailable.
+func readSingle(uc *net.UDPConn, msgs []ipv6.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	n, oobn, _, from, err := uc.ReadMsgUDPAddrPort(msgs[0].Buffers[0], msgs[0].OOB)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N = n
+	msgs[0].NN = oobn
+	msgs[0].Addr = net.UDPAddrFromAddrPort(from)
+	return 1, nil
+}
+
+// writeSingle writes msgs to uc one packet at a time. It is used where batched
+// writes are unavailable.
+func writeSingle(uc *net.UDPConn, msgs []ipv6.Message) error {
+	for _, msg := range msgs {
+		_, _, err := uc.WriteMsgUDP(msg.Buffers[0], msg.OOB, msg.Addr.(*net.UDPAddr))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package udprelay
+
+import (
+	"net"
+	"net/netip"
+
+	"golang.org/x/net/ipv6"
+)
+
+// controlMessageSize is the size of the socket control message buffer passed
+// to ReadBatch.
+const controlMessageSize = 0
+
+// singlePacketConn is a batchingConn that reads and writes a single packet
+// per syscall, and does not report or set local addresses.
+type singlePacketConn struct {
+	*net.UDPConn
+}
+
+func newBatchingConn(uc *net.UDPConn, _ string) batchingConn {
+	return singlePacketConn{uc}
+}
+
+func (c singlePacketConn) ReadBatch(msgs []ipv6.Message, _ int) (int, error) {
+	return readSingle(c.UDPConn, msgs)
+}
+
+func (c singlePacketConn) WriteBatch(msgs []ipv6.Message) error {
+	return writeSingle(c.UDPConn, msgs)
+}
+
+func (singlePacketConn) LocalAddrFromControl([]byte) netip.Addr {
+	return netip.Addr{}
+}
+
+func (singlePacketConn) AppendSourceControl(control []byte, _ netip.Addr) []byte {
+	return control
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"tailscale.com/hostinfo"
+)
+
+// controlMessageSize is the size of the socket control message buffer passed
+// to ReadBatch, large enough for an IPv4 or IPv6 packet info message.
+var controlMessageSize = max(
+	len(ipv4.NewControlMessage(ipv4.FlagDst)),
+	len(ipv6.NewControlMessage(ipv6.FlagDst)),
+)
+
+// xnetBatchReaderWriter defines the batching i/o methods of
+// golang.org/x/net/ipv4.PacketConn (and ipv6.PacketConn).
+type xnetBatchReaderWriter interface {
+	ReadBatch([]ipv6.Message, int) (int, error)
+	WriteBatch([]ipv6.Message, int) (int, error)
+}
+
+// linuxBatchingConn is a UDP socket that provides batched i/o via
+// recvmmsg/sendmmsg, and reports and sets local addresses via
+// IP_PKTINFO/IPV6_PKTINFO. It implements batchingConn.
+type linuxBatchingConn struct {
+	*net.UDPConn
+	xpc     xnetBatchReaderWriter
+	is6     bool
+	mmsg    bool // recvmmsg/sendmmsg are supported
+	pktinfo bool // IP_PKTINFO or IPV6_RECVPKTINFO is set
+}
+
+func newBatchingConn(uc *net.UDPConn, network string) batchingConn {
+	c := &linuxBatchingConn{
+		UDPConn: uc,
+		is6:     network == "udp6",
+		// recvmmsg/sendmmsg were added in 2.6.33, but we support down to
+		// 2.6.32 for old NAS devices. Mirror magicsock's heuristic of not
+		// using them on any 2.x kernel.
+		mmsg: !strings.HasPrefix(hostinfo.GetOSVersion(), "2."),
+	}
+	var err error
+	if c.is6 {
+		pc := ipv6.NewPacketConn(uc)
+		err = pc.SetControlMessage(ipv6.FlagDst, true)
+		c.xpc = pc
+	} else {
+		pc := ipv4.NewPacketConn(uc)
+		err = pc.SetControlMessage(ipv4.FlagDst, true)
+		c.xpc = pc
+	}
+	c.pktinfo = err == nil
+	return c
+}
+
+func (c *linuxBatchingConn) ReadBatch(msgs []ipv6.Message, flags int) (int, error) {
+	if !c.mmsg {
+		return readSingle(c.UDPConn, msgs)
+	}
+	return c.xpc.ReadBatch(msgs, flags)
+}
+
+func (c *linuxBatchingConn) WriteBatch(msgs []ipv6.Message) error {
+	if !c.mmsg {
+		return writeSingle(c.UDPConn, msgs)
+	}
+	var head int
+	for head < len(msgs) {
+		n, err := c.xpc.WriteBatch(msgs[head:], 0)
+		if err != nil {
+			return err
+		}
+		head += n
+	}
+	return nil
+}
+
+func (c *linuxBatchingConn) LocalAddrFromControl(control []byte) netip.Addr {
+	if !c.pktinfo || len(control) == 0 {
+		return netip.Addr{}
+	}
+	var dst net.IP
+	if c.is6 {
+		var cm ipv6.ControlMessage
+		if cm.Parse(control) != nil {
+			return netip.Addr{}
+		}
+		dst = cm.Dst
+	} else {
+		var cm ipv4.ControlMessage
+		if cm.Parse(control) != nil {
+			return netip.Addr{}
+		}
+		dst = cm.Dst
+	}
+	addr, _ := netip.AddrFromSlice(dst)
+	return addr.Unmap()
+}
+
+func (c *linuxBatchingConn) AppendSourceControl(control []byte, src netip.Addr) []byte {
+	if !c.pktinfo || !src.IsValid() || src.Is6() != c.is6 {
+		return control
+	}
+	if src.IsMulticast() || src.IsLinkLocalUnicast() {
+		// Not usable as a source address without also specifying the
+		// interface, leave it to the kernel.
+		return control
+	}
+	if c.is6 {
+		cm := ipv6.ControlMessage{Src: src.AsSlice()}
+		return append(control, cm.Marshal()...)
+	}
+	cm := ipv4.ControlMessage{Src: src.AsSlice()}
+	return append(control, cm.Marshal()...)
+}
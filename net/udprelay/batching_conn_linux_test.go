@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv6"
+)
+
+func Test_linuxBatchingConn_sourceAddr(t *testing.T) {
+	uc, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := newBatchingConn(uc, "udp4")
+	defer bc.Close()
+	port := uc.LocalAddr().(*net.UDPAddr).AddrPort().Port()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 127.0.0.2 is a local address on Linux, but not the one the kernel
+	// would select as the source of a reply to 127.0.0.1.
+	local := netip.MustParseAddr("127.0.0.2")
+	if _, err := client.WriteToUDPAddrPort([]byte("ping"), netip.AddrPortFrom(local, port)); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make([]ipv6.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, maxPacketLen)}
+		msgs[i].OOB = make([]byte, controlMessageSize)
+	}
+	bc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := bc.ReadBatch(msgs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || string(msgs[0].Buffers[0][:msgs[0].N]) != "ping" {
+		t.Fatalf("unexpected read of %d msgs", n)
+	}
+	if got := bc.LocalAddrFromControl(msgs[0].OOB[:msgs[0].NN]); got != local {
+		t.Fatalf("LocalAddrFromControl() = %v, want %v", got, local)
+	}
+
+	reply := []ipv6.Message{{
+		Buffers: [][]byte{[]byte("pong")},
+		OOB:     bc.AppendSourceControl(nil, local),
+		Addr:    client.LocalAddr(),
+	}}
+	if err := bc.WriteBatch(reply); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 16)
+	n, from, err := client.ReadFromUDPAddrPort(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "pong" {
+		t.Fatalf("got %q, want pong", b[:n])
+	}
+	if from != netip.AddrPortFrom(local, port) {
+		t.Fatalf("got reply from %v, want %v", from, netip.AddrPortFrom(local, port))
+	}
+}
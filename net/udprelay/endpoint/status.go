@@ -0,0 +1,70 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package endpoint
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/types/key"
+)
+
+// ServerStatus contains the status of a [tailscale.com/net/udprelay.Server].
+type ServerStatus struct {
+	// UDPPort is the port the Server is listening on, or configured to
+	// listen on if it has not been started yet, in which case 0 means a
+	// random port. It is nil if the Server is not enabled.
+	UDPPort *int `json:",omitempty"`
+
+	// Endpoints are the endpoints currently allocated on the Server, sorted
+	// by VNI.
+	Endpoints []EndpointStatus `json:",omitempty"`
+}
+
+// EndpointStatus contains the status of an endpoint allocated on a
+// [tailscale.com/net/udprelay.Server].
+type EndpointStatus struct {
+	// VNI is the Geneve header Virtual Network Identifier of the endpoint.
+	VNI uint32
+
+	// LamportID is the LamportID of the endpoint, see
+	// [ServerEndpoint.LamportID].
+	LamportID uint64
+
+	// AllocatedAt is when the endpoint was allocated.
+	AllocatedAt time.Time
+
+	// BoundAt is when both clients completed the bind handshake. It is the
+	// zero value if the endpoint is not bound.
+	BoundAt time.Time `json:",omitzero"`
+
+	// Clients are the two clients served by the endpoint.
+	Clients [2]ClientStatus
+}
+
+// ClientStatus contains the status of one of the two clients of an endpoint.
+type ClientStatus struct {
+	// Disco is the client's Disco public key.
+	Disco key.DiscoPublic
+
+	// AddrPort is the client's source ip:port as seen by the Server. It is
+	// the zero value until the client begins the bind handshake.
+	AddrPort netip.AddrPort `json:",omitzero"`
+
+	// Bound is whether the client has completed the bind handshake.
+	Bound bool
+
+	// LastSeen is when the Server last received a packet from the client
+	// after it bound. It is the zero value if the client is not bound.
+	LastSeen time.Time `json:",omitzero"`
+
+	// Packets and Bytes count the data packets received from the client
+	// that were relayed to the other client.
+	Packets uint64
+	Bytes   uint64
+
+	// DroppedPackets counts the data packets received from the client that
+	// were dropped for exceeding a quota.
+	DroppedPackets uint64 `json:",omitempty"`
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"tailscale.com/metrics"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/usermetric"
+)
+
+// endpointEvent is an event in the lifecycle of a serverEndpoint.
+type endpointEvent string
+
+const (
+	endpointEventAllocated      endpointEvent = "allocated"
+	endpointEventBound          endpointEvent = "bound"
+	endpointEventExpiredUnbound endpointEvent = "expired_unbound" // bind lifetime elapsed before both clients bound
+	endpointEventExpiredIdle    endpointEvent = "expired_idle"    // steady state lifetime elapsed without traffic
+)
+
+var (
+	metricEndpointEvents = map[endpointEvent]*clientmetric.Metric{
+		endpointEventAllocated:      clientmetric.NewCounter("udprelay_endpoint_allocated"),
+		endpointEventBound:          clientmetric.NewCounter("udprelay_endpoint_bound"),
+		endpointEventExpiredUnbound: clientmetric.NewCounter("udprelay_endpoint_expired_unbound"),
+		endpointEventExpiredIdle:    clientmetric.NewCounter("udprelay_endpoint_expired_idle"),
+	}
+	metricAllocateErrQuota    = clientmetric.NewCounter("udprelay_allocate_err_quota")
+	metricPacketsDroppedQuota = clientmetric.NewCounter("udprelay_packets_dropped_quota")
+)
+
+type endpointStateLabel struct {
+	State string // "bound" or "unbound"
+}
+
+type endpointEventLabel struct {
+	Event string
+}
+
+type addressFamilyLabel struct {
+	Family string // "ipv4" or "ipv6", of the packet as relayed
+}
+
+type dropReasonLabel struct {
+	Reason string
+}
+
+// serverMetrics are the user-facing metrics of a Server. A nil *serverMetrics
+// is valid, and only updates client metrics.
+type serverMetrics struct {
+	endpoints        *metrics.MultiLabelMap[endpointStateLabel]
+	endpointEvents   *metrics.MultiLabelMap[endpointEventLabel]
+	forwardedPackets *metrics.MultiLabelMap[addressFamilyLabel]
+	forwardedBytes   *metrics.MultiLabelMap[addressFamilyLabel]
+	droppedPackets   *metrics.MultiLabelMap[dropReasonLabel]
+}
+
+func newServerMetrics(reg *usermetric.Registry) *serverMetrics {
+	return &serverMetrics{
+		endpoints: usermetric.NewMultiLabelMapWithRegistry[endpointStateLabel](
+			reg,
+			"tailscaled_relay_server_endpoints",
+			"gauge",
+			"Number of endpoints allocated on the UDP relay server, broken down by state.",
+		),
+		endpointEvents: usermetric.NewMultiLabelMapWithRegistry[endpointEventLabel](
+			reg,
+			"tailscaled_relay_server_endpoint_events_total",
+			"counter",
+			"Counts UDP relay server endpoint allocations, binds and expirations.",
+		),
+		forwardedPackets: usermetric.NewMultiLabelMapWithRegistry[addressFamilyLabel](
+			reg,
+			"tailscaled_relay_server_forwarded_packets_total",
+			"counter",
+			"Counts the number of data packets relayed by the UDP relay server.",
+		),
+		forwardedBytes: usermetric.NewMultiLabelMapWithRegistry[addressFamilyLabel](
+			reg,
+			"tailscaled_relay_server_forwarded_bytes_total",
+			"counter",
+			"Counts the number of bytes of data packets relayed by the UDP relay server.",
+		),
+		droppedPackets: usermetric.NewMultiLabelMapWithRegistry[dropReasonLabel](
+			reg,
+			"tailscaled_relay_server_dropped_packets_total",
+			"counter",
+			"Counts the number of data packets dropped by the UDP relay server.",
+		),
+	}
+}
+
+func (m *serverMetrics) endpointEvent(ev endpointEvent) {
+	metricEndpointEvents[ev].Add(1)
+	if m == nil {
+		return
+	}
+	m.endpointEvents.Add(endpointEventLabel{Event: string(ev)}, 1)
+}
+
+func (m *serverMetrics) forwarded(family string, n int) {
+	if m == nil {
+		return
+	}
+	m.forwardedPackets.Add(addressFamilyLabel{Family: family}, 1)
+	m.forwardedBytes.Add(addressFamilyLabel{Family: family}, int64(n))
+}
+
+func (m *serverMetrics) droppedQuota() {
+	metricPacketsDroppedQuota.Add(1)
+	if m == nil {
+		return
+	}
+	m.droppedPackets.Add(dropReasonLabel{Reason: "quota"}, 1)
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package udprelay
+
+import (
+	"errors"
+
+	"tailscale.com/tstime/rate"
+)
+
+// ErrQuotaExceeded is returned by [Server.AllocateEndpoint] when allocating an
+// endpoint would exceed [Quotas.MaxEndpointsPerClient] for the requesting
+// client.
+var ErrQuotaExceeded = errors.New("endpoint quota exceeded")
+
+// Quotas are resource limits enforced by a [Server]. A client is the node
+// that requested the allocation of an endpoint, identified by its stable node
+// ID. Disco keys are not used to identify clients, as requesters choose them
+// freely. The zero value imposes no limits.
+type Quotas struct {
+	// MaxEndpointsPerClient is the maximum number of endpoints, and thus
+	// VNIs, that a single client may have allocated concurrently. Zero means
+	// unlimited.
+	MaxEndpointsPerClient int
+	// EndpointBytesPerSec is the maximum rate, in bytes per second, that
+	// data packets are relayed through a single endpoint, summed across both
+	// directions. Packets in excess of the rate are dropped. Zero means
+	// unlimited.
+	EndpointBytesPerSec int
+	// ClientBytesPerSec is the maximum rate, in bytes per second, that data
+	// packets are relayed through the endpoints allocated by a single
+	// client, summed across all of them and both directions. Packets in
+	// excess of the rate are dropped. Zero means unlimited.
+	ClientBytesPerSec int
+}
+
+// newBytesLimiter returns a [rate.Limiter] allowing bytesPerSec, or nil if
+// bytesPerSec is not positive. The burst is one second's worth of bytes, but
+// never less than a maximum size packet, which would otherwise never be
+// allowed.
+func newBytesLimiter(bytesPerSec int) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), max(bytesPerSec, maxPacketLen))
+}
+
+// clientState is the Server-internal state of a client, shared by all of the
+// endpoints it allocated.
+type clientState struct {
+	endpoints int           // number of endpoints allocated by the client
+	limiter   *rate.Limiter // nil if unlimited
+}
+
+// allowBytes reports whether n bytes may be relayed on behalf of the client.
+// c may be nil.
+func (c *clientState) allowBytes(n int) bool {
+	return c == nil || c.limiter == nil || c.limiter.AllowN(n)
+}
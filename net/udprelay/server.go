@@ -8,30 +8,37 @@
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/rand"
 	"errors"
+	"expvar"
 	"fmt"
 	"net"
 	"net/netip"
 	"slices"
-	"strconv"
 	"sync"
 	"time"
 
 	"go4.org/mem"
+	"golang.org/x/net/ipv6"
 	"tailscale.com/client/local"
 	"tailscale.com/disco"
 	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/portmapper"
 	"tailscale.com/net/stun"
 	"tailscale.com/net/udprelay/endpoint"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
+	"tailscale.com/tstime/rate"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/eventbus"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/set"
+	"tailscale.com/util/usermetric"
 )
 
 const (
@@ -57,11 +64,13 @@ type Server struct {
 	bindLifetime        time.Duration
 	steadyStateLifetime time.Duration
 	bus                 *eventbus.Bus
-	uc                  *net.UDPConn
+	uc4                 batchingConn // always non-nil
+	uc6                 batchingConn // nil if IPv6 is unavailable
 	closeOnce           sync.Once
 	wg                  sync.WaitGroup
 	closeCh             chan struct{}
 	netChecker          *netcheck.Client
+	portMapper          *portmapper.Client // nil if addrs are overridden
 
 	mu        sync.Mutex       // guards the following fields
 	addrPorts []netip.AddrPort // the ip:port pairs returned as candidate endpoints
@@ -70,6 +79,9 @@ type Server struct {
 	vniPool   []uint32 // the pool of available VNIs
 	byVNI     map[uint32]*serverEndpoint
 	byDisco   map[pairOfDiscoPubKeys]*serverEndpoint
+	byClient  map[tailcfg.StableNodeID]*clientState
+	quotas    Quotas
+	metrics   *serverMetrics // nil until SetMetricsRegistry is called
 }
 
 // pairOfDiscoPubKeys is a pair of key.DiscoPublic. It must be constructed via
@@ -99,15 +111,28 @@ type serverEndpoint struct {
 	discoSharedSecrets [2]key.DiscoShared
 	handshakeState     [2]disco.BindUDPRelayHandshakeState
 	addrPorts          [2]netip.AddrPort
-	lastSeen           [2]time.Time // TODO(jwhited): consider using mono.Time
+	localAddrs         [2]netip.Addr // the local addrs packets from addrPorts arrive on, zero if unknown
+	lastSeen           [2]time.Time  // TODO(jwhited): consider using mono.Time
 	challenge          [2][disco.BindUDPRelayEndpointChallengeLen]byte
 
 	lamportID   uint64
 	vni         uint32
 	allocatedAt time.Time
+	boundAt     time.Time
+
+	// The following fields count data packets received from each client,
+	// aligned with discoPubKeys.
+	packets [2]uint64 // relayed
+	bytes   [2]uint64 // relayed
+	dropped [2]uint64 // dropped for exceeding a quota
+
+	limiter   *rate.Limiter        // nil if unlimited, see [Quotas.EndpointBytesPerSec]
+	requester tailcfg.StableNodeID // the client that allocated the endpoint
+	client    *clientState         // state of requester
+	metrics   *serverMetrics       // may be nil
 }
 
-func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex int, discoMsg disco.Message, uw udpWriter, serverDisco key.DiscoPublic) {
+func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, local netip.Addr, senderIndex int, discoMsg disco.Message, uw udpWriter, serverDisco key.DiscoPublic) {
 	if senderIndex != 0 && senderIndex != 1 {
 		return
 	}
@@ -122,6 +147,7 @@ func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex
 		case disco.BindUDPRelayHandshakeStateInit:
 			// set sender addr
 			e.addrPorts[senderIndex] = from
+			e.localAddrs[senderIndex] = local
 			fallthrough
 		case disco.BindUDPRelayHandshakeStateChallengeSent:
 			if from != e.addrPorts[senderIndex] {
@@ -141,7 +167,7 @@ func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex
 			reply = serverDisco.AppendTo(reply)
 			box := e.discoSharedSecrets[senderIndex].Seal(m.AppendMarshal(nil))
 			reply = append(reply, box...)
-			uw.WriteMsgUDPAddrPort(reply, nil, from)
+			uw.writeUDP(reply, local, from)
 			// set new state
 			e.handshakeState[senderIndex] = disco.BindUDPRelayHandshakeStateChallengeSent
 			return
@@ -166,6 +192,10 @@ func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex
 			e.handshakeState[senderIndex] = disco.BindUDPRelayHandshakeStateAnswerReceived
 			// record last seen as bound time
 			e.lastSeen[senderIndex] = time.Now()
+			if e.isBound() {
+				e.boundAt = e.lastSeen[senderIndex]
+				e.metrics.endpointEvent(endpointEventBound)
+			}
 			return
 		default:
 			// disco.BindUDPRelayEndpointAnswer is unexpected in all other handshake
@@ -178,7 +208,7 @@ func (e *serverEndpoint) handleDiscoControlMsg(from netip.AddrPort, senderIndex
 	}
 }
 
-func (e *serverEndpoint) handleSealedDiscoControlMsg(from netip.AddrPort, b []byte, uw udpWriter, serverDisco key.DiscoPublic) {
+func (e *serverEndpoint) handleSealedDiscoControlMsg(from netip.AddrPort, local netip.Addr, b []byte, uw udpWriter, serverDisco key.DiscoPublic) {
 	senderRaw, isDiscoMsg := disco.Source(b)
 	if !isDiscoMsg {
 		// Not a Disco message
@@ -209,33 +239,50 @@ func (e *serverEndpoint) handleSealedDiscoControlMsg(from netip.AddrPort, b []by
 		return
 	}
 
-	e.handleDiscoControlMsg(from, senderIndex, discoMsg, uw, serverDisco)
+	e.handleDiscoControlMsg(from, local, senderIndex, discoMsg, uw, serverDisco)
 }
 
+// udpWriter queues packets for transmission.
 type udpWriter interface {
-	WriteMsgUDPAddrPort(b []byte, oob []byte, addr netip.AddrPort) (n, oobn int, err error)
+	// writeUDP queues b for transmission to dst, from the local address src
+	// if valid. b must not be modified until the queue is flushed.
+	writeUDP(b []byte, src netip.Addr, dst netip.AddrPort)
 }
 
-func (e *serverEndpoint) handlePacket(from netip.AddrPort, gh packet.GeneveHeader, b []byte, uw udpWriter, serverDisco key.DiscoPublic) {
+func (e *serverEndpoint) handlePacket(from netip.AddrPort, local netip.Addr, gh packet.GeneveHeader, b []byte, uw udpWriter, serverDisco key.DiscoPublic) {
 	if !gh.Control {
 		if !e.isBound() {
 			// not a control packet, but serverEndpoint isn't bound
 			return
 		}
-		var to netip.AddrPort
+		var senderIndex int
 		switch {
 		case from == e.addrPorts[0]:
-			e.lastSeen[0] = time.Now()
-			to = e.addrPorts[1]
+			senderIndex = 0
 		case from == e.addrPorts[1]:
-			e.lastSeen[1] = time.Now()
-			to = e.addrPorts[0]
+			senderIndex = 1
 		default:
 			// unrecognized source
 			return
 		}
+		e.lastSeen[senderIndex] = time.Now()
+		e.localAddrs[senderIndex] = local
+		if !e.allowBytes(len(b)) {
+			// over quota
+			e.dropped[senderIndex]++
+			e.metrics.droppedQuota()
+			return
+		}
 		// relay packet
-		uw.WriteMsgUDPAddrPort(b, nil, to)
+		to := e.addrPorts[1-senderIndex]
+		uw.writeUDP(b, e.localAddrs[1-senderIndex], to)
+		e.packets[senderIndex]++
+		e.bytes[senderIndex] += uint64(len(b))
+		family := "ipv4"
+		if to.Addr().Is6() {
+			family = "ipv6"
+		}
+		e.metrics.forwarded(family, len(b))
 		return
 	}
 
@@ -250,7 +297,16 @@ func (e *serverEndpoint) handlePacket(from netip.AddrPort, gh packet.GeneveHeade
 	}
 
 	msg := b[packet.GeneveFixedHeaderLength:]
-	e.handleSealedDiscoControlMsg(from, msg, uw, serverDisco)
+	e.handleSealedDiscoControlMsg(from, local, msg, uw, serverDisco)
+}
+
+// allowBytes reports whether n bytes may be relayed within the endpoint and
+// client quotas.
+func (e *serverEndpoint) allowBytes(n int) bool {
+	if e.limiter != nil && !e.limiter.AllowN(n) {
+		return false
+	}
+	return e.client.allowBytes(n)
 }
 
 func (e *serverEndpoint) isExpired(now time.Time, bindLifetime, steadyStateLifetime time.Duration) bool {
@@ -266,6 +322,28 @@ func (e *serverEndpoint) isExpired(now time.Time, bindLifetime, steadyStateLifet
 	return false
 }
 
+// status returns the status of e.
+func (e *serverEndpoint) status() endpoint.EndpointStatus {
+	st := endpoint.EndpointStatus{
+		VNI:         e.vni,
+		LamportID:   e.lamportID,
+		AllocatedAt: e.allocatedAt,
+		BoundAt:     e.boundAt,
+	}
+	for i := range st.Clients {
+		st.Clients[i] = endpoint.ClientStatus{
+			Disco:          e.discoPubKeys[i],
+			AddrPort:       e.addrPorts[i],
+			Bound:          e.handshakeState[i] == disco.BindUDPRelayHandshakeStateAnswerReceived,
+			LastSeen:       e.lastSeen[i],
+			Packets:        e.packets[i],
+			Bytes:          e.bytes[i],
+			DroppedPackets: e.dropped[i],
+		}
+	}
+	return st
+}
+
 // isBound returns true if both clients have completed their 3-way handshake,
 // otherwise false.
 func (e *serverEndpoint) isBound() bool {
@@ -273,12 +351,11 @@ func (e *serverEndpoint) isBound() bool {
 		e.handshakeState[1] == disco.BindUDPRelayHandshakeStateAnswerReceived
 }
 
-// NewServer constructs a [Server] listening on 0.0.0.0:'port'. IPv6 is not yet
-// supported. Port may be 0, and what ultimately gets bound is returned as
+// NewServer constructs a [Server] listening on 0.0.0.0:'port' and [::]:'port'.
+// Listening on IPv6 is best effort, the [Server] operates IPv4-only if it
+// fails. Port may be 0, and what ultimately gets bound is returned as
 // 'boundPort'. If len(overrideAddrs) > 0 these will be used in place of dynamic
 // discovery, which is useful to override in tests.
-//
-// TODO: IPv6 support
 func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Server, boundPort uint16, err error) {
 	s = &Server{
 		logf:                logger.WithPrefix(logf, "relayserver"),
@@ -307,7 +384,13 @@ func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Serve
 		NetMon: netMon,
 		Logf:   logger.WithPrefix(logf, "relayserver: netcheck:"),
 		SendPacket: func(b []byte, addrPort netip.AddrPort) (int, error) {
-			return s.uc.WriteToUDPAddrPort(b, addrPort)
+			if addrPort.Addr().Is6() {
+				if s.uc6 == nil {
+					return 0, errors.New("IPv6 is unavailable")
+				}
+				return s.uc6.WriteToUDPAddrPort(b, addrPort)
+			}
+			return s.uc4.WriteToUDPAddrPort(b, addrPort)
 		},
 	}
 
@@ -317,7 +400,11 @@ func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Serve
 	}
 
 	s.wg.Add(1)
-	go s.packetReadLoop()
+	go s.packetReadLoop(s.uc4)
+	if s.uc6 != nil {
+		s.wg.Add(1)
+		go s.packetReadLoop(s.uc6)
+	}
 	s.wg.Add(1)
 	go s.endpointGCLoop()
 	if len(overrideAddrs) > 0 {
@@ -330,6 +417,17 @@ func NewServer(logf logger.Logf, port int, overrideAddrs []netip.Addr) (s *Serve
 		}
 		s.addrPorts = addrPorts.Slice()
 	} else {
+		// Ask the gateway to forward the relay port to us, so that peers
+		// behind other NATs can reach it.
+		s.portMapper = portmapper.NewClient(portmapper.Config{
+			EventBus: s.bus,
+			Logf:     logger.WithPrefix(logf, "relayserver: portmapper: "),
+			NetMon:   netMon,
+		})
+		s.portMapper.AddMapping(portmapper.MappingKey{LocalPort: boundPort})
+		if s.uc6 != nil {
+			s.portMapper.AddMapping(portmapper.MappingKey{LocalPort: boundPort, IPv6: true})
+		}
 		s.wg.Add(1)
 		go s.addrDiscoveryLoop()
 	}
@@ -347,12 +445,15 @@ func (s *Server) addrDiscoveryLoop() {
 		addrPorts.Make()
 
 		// get local addresses
-		localPort := s.uc.LocalAddr().(*net.UDPAddr).Port
+		localPort := s.uc4.LocalAddr().(*net.UDPAddr).Port
 		ips, _, err := netmon.LocalAddresses()
 		if err != nil {
 			return nil, err
 		}
 		for _, ip := range ips {
+			if ip.Is6() && s.uc6 == nil {
+				continue
+			}
 			if ip.IsValid() {
 				addrPorts.Add(netip.AddrPortFrom(ip, uint16(localPort)))
 			}
@@ -381,9 +482,17 @@ func (s *Server) addrDiscoveryLoop() {
 		if rep.GlobalV4.IsValid() {
 			addrPorts.Add(rep.GlobalV4)
 		}
-		if rep.GlobalV6.IsValid() {
+		if rep.GlobalV6.IsValid() && s.uc6 != nil {
 			addrPorts.Add(rep.GlobalV6)
 		}
+		for _, k := range []portmapper.MappingKey{
+			{LocalPort: uint16(localPort)},
+			{LocalPort: uint16(localPort), IPv6: true},
+		} {
+			if ext, ok := s.portMapper.GetCachedMapping(k); ok {
+				addrPorts.Add(ext)
+			}
+		}
 		// TODO(jwhited): consider logging if rep.MappingVariesByDestIP as
 		//  that's a hint we are not well-positioned to operate as a UDP relay.
 		return addrPorts.Slice(), nil
@@ -409,24 +518,23 @@ func (s *Server) addrDiscoveryLoop() {
 
 }
 
+// listenOn binds an IPv4 socket to port, and an IPv6 socket to the port the
+// IPv4 socket was bound to, which the kernel chooses if port is 0. Failure to
+// bind the IPv6 socket is not fatal, as the host may not support IPv6.
 func (s *Server) listenOn(port int) (uint16, error) {
-	uc, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	uc4, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
 	if err != nil {
 		return 0, err
 	}
-	// TODO: set IP_PKTINFO sockopt
-	_, boundPortStr, err := net.SplitHostPort(uc.LocalAddr().String())
+	boundPort := uc4.LocalAddr().(*net.UDPAddr).AddrPort().Port()
+	s.uc4 = newBatchingConn(uc4, "udp4")
+	uc6, err := net.ListenUDP("udp6", &net.UDPAddr{Port: int(boundPort)})
 	if err != nil {
-		s.uc.Close()
-		return 0, err
-	}
-	boundPort, err := strconv.ParseUint(boundPortStr, 10, 16)
-	if err != nil {
-		s.uc.Close()
-		return 0, err
+		s.logf("unable to listen on IPv6, continuing with IPv4 only: %v", err)
+		return boundPort, nil
 	}
-	s.uc = uc
-	return uint16(boundPort), nil
+	s.uc6 = newBatchingConn(uc6, "udp6")
+	return boundPort, nil
 }
 
 // Close closes the server.
@@ -434,13 +542,20 @@ func (s *Server) Close() error {
 	s.closeOnce.Do(func() {
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		s.uc.Close()
+		s.uc4.Close()
+		if s.uc6 != nil {
+			s.uc6.Close()
+		}
 		close(s.closeCh)
 		s.wg.Wait()
 		clear(s.byVNI)
 		clear(s.byDisco)
+		clear(s.byClient)
 		s.vniPool = nil
 		s.closed = true
+		if s.portMapper != nil {
+			s.portMapper.Close()
+		}
 		s.bus.Close()
 	})
 	return nil
@@ -457,11 +572,14 @@ func (s *Server) endpointGCLoop() {
 		// holding s.mu for the duration. Keep it simple (and slow) for now.
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		for k, v := range s.byDisco {
+		for _, v := range s.byDisco {
 			if v.isExpired(now, s.bindLifetime, s.steadyStateLifetime) {
-				delete(s.byDisco, k)
-				delete(s.byVNI, v.vni)
-				s.vniPool = append(s.vniPool, v.vni)
+				if v.isBound() {
+					s.metrics.endpointEvent(endpointEventExpiredIdle)
+				} else {
+					s.metrics.endpointEvent(endpointEventExpiredUnbound)
+				}
+				s.deleteEndpointLocked(v)
 			}
 		}
 	}
@@ -476,7 +594,21 @@ func (s *Server) endpointGCLoop() {
 	}
 }
 
-func (s *Server) handlePacket(from netip.AddrPort, b []byte, uw udpWriter) {
+// deleteEndpointLocked deletes e, returning its VNI to the pool, and releases
+// it from the quota of its requester. s.mu must be held.
+func (s *Server) deleteEndpointLocked(e *serverEndpoint) {
+	delete(s.byDisco, e.discoPubKeys)
+	delete(s.byVNI, e.vni)
+	s.vniPool = append(s.vniPool, e.vni)
+	if c, ok := s.byClient[e.requester]; ok {
+		c.endpoints--
+		if c.endpoints <= 0 {
+			delete(s.byClient, e.requester)
+		}
+	}
+}
+
+func (s *Server) handlePacket(from netip.AddrPort, local netip.Addr, b []byte, uw udpWriter) {
 	if stun.Is(b) && b[1] == 0x01 {
 		// A b[1] value of 0x01 (STUN method binding) is sufficiently
 		// non-overlapping with the Geneve header where the LSB is always 0
@@ -500,32 +632,103 @@ func (s *Server) handlePacket(from netip.AddrPort, b []byte, uw udpWriter) {
 		return
 	}
 
-	e.handlePacket(from, gh, b, uw, s.discoPublic)
+	e.handlePacket(from, local, gh, b, uw, s.discoPublic)
 }
 
-func (s *Server) packetReadLoop() {
+func (s *Server) packetReadLoop(uc batchingConn) {
 	defer func() {
 		s.wg.Done()
 		s.Close()
 	}()
-	b := make([]byte, 1<<16-1)
+	msgs := make([]ipv6.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, maxPacketLen)}
+		msgs[i].OOB = make([]byte, controlMessageSize)
+	}
+	bw := newBatchWriter(s.uc4, s.uc6)
 	for {
-		// TODO: extract laddr from IP_PKTINFO for use in reply
-		n, from, err := s.uc.ReadFromUDPAddrPort(b)
+		n, err := uc.ReadBatch(msgs, 0)
 		if err != nil {
 			return
 		}
-		s.handlePacket(from, b[:n], s.uc)
+		for _, msg := range msgs[:n] {
+			ua, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			from := ua.AddrPort()
+			from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+			local := uc.LocalAddrFromControl(msg.OOB[:msg.NN])
+			s.handlePacket(from, local, msg.Buffers[0][:msg.N], bw)
+		}
+		// Packets queued by handlePacket may reference msgs buffers, flush
+		// before reading into them again.
+		bw.flush()
+	}
+}
+
+// batchWriter is a udpWriter that queues packets, writing them in batches via
+// the batchingConn matching the address family of their destination when
+// flushed.
+type batchWriter struct {
+	uc4, uc6     batchingConn // uc6 may be nil
+	msgs4, msgs6 []ipv6.Message
+}
+
+func newBatchWriter(uc4, uc6 batchingConn) *batchWriter {
+	return &batchWriter{
+		uc4:   uc4,
+		uc6:   uc6,
+		msgs4: make([]ipv6.Message, 0, batchSize),
+		msgs6: make([]ipv6.Message, 0, batchSize),
+	}
+}
+
+func (w *batchWriter) writeUDP(b []byte, src netip.Addr, dst netip.AddrPort) {
+	uc, msgs := w.uc4, &w.msgs4
+	if dst.Addr().Is6() {
+		uc, msgs = w.uc6, &w.msgs6
+	}
+	if uc == nil {
+		return
+	}
+	if len(*msgs) == cap(*msgs) {
+		w.flush()
+	}
+	i := len(*msgs)
+	*msgs = (*msgs)[:i+1]
+	m := &(*msgs)[i]
+	if m.Buffers == nil {
+		m.Buffers = make([][]byte, 1)
+	}
+	m.Buffers[0] = b
+	m.OOB = uc.AppendSourceControl(m.OOB[:0], src)
+	m.Addr = net.UDPAddrFromAddrPort(dst)
+}
+
+// flush writes all queued packets. Write errors are not actionable here, and
+// are disregarded, similar to packet loss.
+func (w *batchWriter) flush() {
+	if len(w.msgs4) > 0 {
+		w.uc4.WriteBatch(w.msgs4)
+		w.msgs4 = w.msgs4[:0]
+	}
+	if len(w.msgs6) > 0 {
+		w.uc6.WriteBatch(w.msgs6)
+		w.msgs6 = w.msgs6[:0]
 	}
 }
 
 var ErrServerClosed = errors.New("server closed")
 
 // AllocateEndpoint allocates an [endpoint.ServerEndpoint] for the provided pair
-// of [key.DiscoPublic]'s. If an allocation already exists for discoA and discoB
-// it is returned without modification/reallocation. AllocateEndpoint returns
-// [ErrServerClosed] if the server has been closed.
-func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.ServerEndpoint, error) {
+// of [key.DiscoPublic]'s on behalf of the requester, which must be the
+// authenticated node that asked for the allocation. If an allocation already
+// exists for discoA and discoB it is returned without
+// modification/reallocation. AllocateEndpoint returns [ErrServerClosed] if the
+// server has been closed, and [ErrQuotaExceeded] if the requester has reached
+// [Quotas.MaxEndpointsPerClient].
+func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic, requester tailcfg.StableNodeID) (endpoint.ServerEndpoint, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
@@ -567,11 +770,26 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		return endpoint.ServerEndpoint{}, errors.New("VNI pool exhausted")
 	}
 
+	c, ok := s.byClient[requester]
+	if ok && s.quotas.MaxEndpointsPerClient > 0 && c.endpoints >= s.quotas.MaxEndpointsPerClient {
+		metricAllocateErrQuota.Add(1)
+		return endpoint.ServerEndpoint{}, fmt.Errorf("%w for client %s", ErrQuotaExceeded, requester)
+	}
+	if !ok {
+		c = &clientState{limiter: newBytesLimiter(s.quotas.ClientBytesPerSec)}
+		mak.Set(&s.byClient, requester, c)
+	}
+	c.endpoints++
+
 	s.lamportID++
 	e = &serverEndpoint{
 		discoPubKeys: pair,
 		lamportID:    s.lamportID,
 		allocatedAt:  time.Now(),
+		limiter:      newBytesLimiter(s.quotas.EndpointBytesPerSec),
+		requester:    requester,
+		client:       c,
+		metrics:      s.metrics,
 	}
 	e.discoSharedSecrets[0] = s.disco.Shared(e.discoPubKeys[0])
 	e.discoSharedSecrets[1] = s.disco.Shared(e.discoPubKeys[1])
@@ -581,6 +799,7 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 
 	s.byDisco[pair] = e
 	s.byVNI[e.vni] = e
+	s.metrics.endpointEvent(endpointEventAllocated)
 
 	return endpoint.ServerEndpoint{
 		ServerDisco:         s.discoPublic,
@@ -591,3 +810,64 @@ func (s *Server) AllocateEndpoint(discoA, discoB key.DiscoPublic) (endpoint.Serv
 		SteadyStateLifetime: tstime.GoDuration{Duration: s.steadyStateLifetime},
 	}, nil
 }
+
+// SetQuotas sets the resource limits enforced by the server. Byte rate limits
+// apply to existing endpoints immediately. Endpoints allocated in excess of
+// q.MaxEndpointsPerClient are not deallocated, but count towards it.
+func (s *Server) SetQuotas(q Quotas) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quotas = q
+	for _, e := range s.byVNI {
+		e.limiter = newBytesLimiter(q.EndpointBytesPerSec)
+	}
+	for _, c := range s.byClient {
+		c.limiter = newBytesLimiter(q.ClientBytesPerSec)
+	}
+}
+
+// SetMetricsRegistry registers the server's user-facing metrics with reg.
+func (s *Server) SetMetricsRegistry(reg *usermetric.Registry) {
+	if reg == nil {
+		return
+	}
+	m := newServerMetrics(reg)
+	for _, bound := range []bool{true, false} {
+		label := endpointStateLabel{State: "unbound"}
+		if bound {
+			label.State = "bound"
+		}
+		m.endpoints.Set(label, expvar.Func(func() any {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			var n int
+			for _, e := range s.byVNI {
+				if e.isBound() == bound {
+					n++
+				}
+			}
+			return n
+		}))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics = m
+	for _, e := range s.byVNI {
+		e.metrics = m
+	}
+}
+
+// Status returns the status of the server and its allocated endpoints.
+func (s *Server) Status() endpoint.ServerStatus {
+	port := s.uc4.LocalAddr().(*net.UDPAddr).Port
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := endpoint.ServerStatus{UDPPort: &port}
+	for _, e := range s.byVNI {
+		st.Endpoints = append(st.Endpoints, e.status())
+	}
+	slices.SortFunc(st.Endpoints, func(a, b endpoint.EndpointStatus) int {
+		return cmp.Compare(a.VNI, b.VNI)
+	})
+	return st
+}
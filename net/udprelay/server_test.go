@@ -5,8 +5,13 @@
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"net"
+	"net/http/httptest"
 	"net/netip"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,7 +20,9 @@
 	"go4.org/mem"
 	"tailscale.com/disco"
 	"tailscale.com/net/packet"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
+	"tailscale.com/util/usermetric"
 )
 
 type testClient struct {
@@ -27,7 +34,7 @@ type testClient struct {
 
 func newTestClient(t *testing.T, vni uint32, serverEndpoint netip.AddrPort, local key.DiscoPrivate, server key.DiscoPublic) *testClient {
 	rAddr := &net.UDPAddr{IP: serverEndpoint.Addr().AsSlice(), Port: int(serverEndpoint.Port())}
-	uc, err := net.DialUDP("udp4", nil, rAddr)
+	uc, err := net.DialUDP("udp", nil, rAddr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -151,22 +158,36 @@ func (c *testClient) close() {
 }
 
 func TestServer(t *testing.T) {
+	for _, addr := range []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("::1"),
+	} {
+		t.Run(addr.String(), func(t *testing.T) {
+			testServer(t, addr)
+		})
+	}
+}
+
+func testServer(t *testing.T, loopbackAddr netip.Addr) {
 	discoA := key.NewDisco()
 	discoB := key.NewDisco()
 
-	ipv4LoopbackAddr := netip.MustParseAddr("127.0.0.1")
-
-	server, _, err := NewServer(t.Logf, 0, []netip.Addr{ipv4LoopbackAddr})
+	server, _, err := NewServer(t.Logf, 0, []netip.Addr{loopbackAddr})
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer server.Close()
+	if loopbackAddr.Is6() && server.uc6 == nil {
+		t.Skip("IPv6 is unavailable")
+	}
+	reg := new(usermetric.Registry)
+	server.SetMetricsRegistry(reg)
 
-	endpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public())
+	endpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public(), "nodeA")
 	if err != nil {
 		t.Fatal(err)
 	}
-	dupEndpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public())
+	dupEndpoint, err := server.AllocateEndpoint(discoA.Public(), discoB.Public(), "nodeA")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -187,7 +208,7 @@ func TestServer(t *testing.T) {
 	tcA.handshake(t)
 	tcB.handshake(t)
 
-	dupEndpoint, err = server.AllocateEndpoint(discoA.Public(), discoB.Public())
+	dupEndpoint, err = server.AllocateEndpoint(discoA.Public(), discoB.Public(), "nodeA")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -209,4 +230,178 @@ func TestServer(t *testing.T) {
 	if !bytes.Equal(txToA, rxFromB) {
 		t.Fatal("unexpected msg B->A")
 	}
+
+	st := server.Status()
+	if len(st.Endpoints) != 1 {
+		t.Fatalf("got %d endpoints in status, want 1", len(st.Endpoints))
+	}
+	es := st.Endpoints[0]
+	if es.VNI != endpoint.VNI || es.BoundAt.IsZero() {
+		t.Errorf("unexpected endpoint status: %+v", es)
+	}
+	wantDataPktLen := uint64(packet.GeneveFixedHeaderLength + len(txToB))
+	for _, cs := range es.Clients {
+		if !cs.Bound || cs.Packets != 1 || cs.Bytes != wantDataPktLen {
+			t.Errorf("unexpected client status: %+v", cs)
+		}
+	}
+
+	family := "ipv4"
+	if loopbackAddr.Is6() {
+		family = "ipv6"
+	}
+	rec := httptest.NewRecorder()
+	reg.Handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := rec.Body.String()
+	for _, want := range []string{
+		`tailscaled_relay_server_endpoints{state="bound"} 1`,
+		`tailscaled_relay_server_endpoint_events_total{event="allocated"} 1`,
+		`tailscaled_relay_server_endpoint_events_total{event="bound"} 1`,
+		fmt.Sprintf(`tailscaled_relay_server_forwarded_packets_total{family=%q} 2`, family),
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestServerQuotas(t *testing.T) {
+	server, _, err := NewServer(t.Logf, 0, []netip.Addr{netip.MustParseAddr("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetQuotas(Quotas{MaxEndpointsPerClient: 1})
+
+	const nodeA, nodeB tailcfg.StableNodeID = "nodeA", "nodeB"
+	discoA, discoB, discoC := key.NewDisco().Public(), key.NewDisco().Public(), key.NewDisco().Public()
+	if _, err := server.AllocateEndpoint(discoA, discoB, nodeA); err != nil {
+		t.Fatal(err)
+	}
+	// An existing allocation is returned regardless of quota.
+	if _, err := server.AllocateEndpoint(discoB, discoA, nodeA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AllocateEndpoint(discoA, discoC, nodeA); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got err %v, want %v", err, ErrQuotaExceeded)
+	}
+	// Rotating disco keys doesn't bypass the quota of the requester.
+	for range 3 {
+		if _, err := server.AllocateEndpoint(key.NewDisco().Public(), key.NewDisco().Public(), nodeA); !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("got err %v, want %v", err, ErrQuotaExceeded)
+		}
+	}
+	// Other requesters have their own quota, even for the same disco keys.
+	if _, err := server.AllocateEndpoint(discoA, discoC, nodeB); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expiry of the first endpoint releases quota.
+	server.mu.Lock()
+	server.deleteEndpointLocked(server.byDisco[newPairOfDiscoPubKeys(discoA, discoB)])
+	if _, ok := server.byClient[nodeA]; ok {
+		t.Errorf("byClient = %v, want no entry for %v", server.byClient, nodeA)
+	}
+	server.mu.Unlock()
+	if _, err := server.AllocateEndpoint(discoB, discoC, nodeA); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type queuedWrite struct {
+	n   int
+	src netip.Addr
+	dst netip.AddrPort
+}
+
+type receivedPacket struct {
+	n     int
+	from  netip.AddrPort
+	local netip.Addr
+}
+
+type fakeUDPWriter struct {
+	writes []queuedWrite
+}
+
+func (w *fakeUDPWriter) writeUDP(b []byte, src netip.Addr, dst netip.AddrPort) {
+	w.writes = append(w.writes, queuedWrite{len(b), src, dst})
+}
+
+func TestServerEndpointRelay(t *testing.T) {
+	var (
+		addrA  = netip.MustParseAddrPort("192.0.2.1:1")
+		addrB  = netip.MustParseAddrPort("[2001:db8::1]:2")
+		localA = netip.MustParseAddr("198.51.100.1")
+		localB = netip.MustParseAddr("2001:db8::100")
+		gh     = packet.GeneveHeader{Protocol: packet.GeneveProtocolWireGuard}
+	)
+	newEndpoint := func(endpointBytesPerSec, clientBytesPerSec int) *serverEndpoint {
+		e := &serverEndpoint{
+			handshakeState: [2]disco.BindUDPRelayHandshakeState{
+				disco.BindUDPRelayHandshakeStateAnswerReceived,
+				disco.BindUDPRelayHandshakeStateAnswerReceived,
+			},
+			addrPorts:  [2]netip.AddrPort{addrA, addrB},
+			localAddrs: [2]netip.Addr{localA, localB},
+			limiter:    newBytesLimiter(endpointBytesPerSec),
+			client:     &clientState{endpoints: 1, limiter: newBytesLimiter(clientBytesPerSec)},
+		}
+		return e
+	}
+
+	tests := []struct {
+		name                string
+		endpointBytesPerSec int
+		clientBytesPerSec   int
+		sends               []receivedPacket
+		want                []queuedWrite
+	}{
+		{
+			name: "unlimited",
+			sends: []receivedPacket{
+				{n: 60000, from: addrA, local: localA},
+				{n: 60000, from: addrB, local: localB},
+			},
+			want: []queuedWrite{
+				{n: 60000, src: localB, dst: addrB},
+				{n: 60000, src: localA, dst: addrA},
+			},
+		},
+		{
+			name:                "endpoint-limit",
+			endpointBytesPerSec: 1,
+			sends: []receivedPacket{
+				{n: 60000, from: addrA, local: localA},
+				{n: 6000, from: addrB, local: localB},
+			},
+			want: []queuedWrite{
+				{n: 60000, src: localB, dst: addrB},
+			},
+		},
+		{
+			name:              "client-limit",
+			clientBytesPerSec: 1,
+			sends: []receivedPacket{
+				{n: 60000, from: addrA, local: localA},
+				{n: 6000, from: addrA, local: localA},
+				{n: 6000, from: addrB, local: localB},
+			},
+			want: []queuedWrite{
+				{n: 60000, src: localB, dst: addrB},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEndpoint(tt.endpointBytesPerSec, tt.clientBytesPerSec)
+			w := &fakeUDPWriter{}
+			for _, rx := range tt.sends {
+				e.handlePacket(rx.from, rx.local, gh, make([]byte, rx.n), w, key.DiscoPublic{})
+			}
+			if !reflect.DeepEqual(w.writes, tt.want) {
+				t.Errorf("got writes %v, want %v", w.writes, tt.want)
+			}
+		})
+	}
 }
@@ -29,7 +29,7 @@
 const (
 	// Timeout for an individual DialFunc call for a single recorder address.
 	perDialAttemptTimeout = 5 * time.Second
-	// Timeout for the V2 API HEAD probe request (supportsV2).
+	// Timeout for the V2 API HEAD probe request (probeV2).
 	http2ProbeTimeout = 10 * time.Second
 	// Maximum timeout for trying all available recorders, including V2 API
 	// probes and dial attempts.
@@ -53,7 +53,19 @@
 // attempts are in order the recorder(s) was attempted. If successful a
 // successful connection is made, the last attempt in the slice is the
 // attempt for connected recorder.
+//
+// It is equivalent to [Connect] with zero ConnectOptions.
 func ConnectToRecorder(ctx context.Context, recs []netip.AddrPort, dial netx.DialFunc) (io.WriteCloser, []*tailcfg.SSHRecordingAttempt, <-chan error, error) {
+	return Connect(ctx, recs, dial, ConnectOptions{})
+}
+
+// Connect is like [ConnectToRecorder], but with options to make uploads
+// resumable and to upload to several recorders at once.
+//
+// With opts.FanOut, the attempts for all of recs are returned, and the
+// returned WriteCloser and channel cover the uploads to all recorders that
+// accepted the recording.
+func Connect(ctx context.Context, recs []netip.AddrPort, dial netx.DialFunc, opts ConnectOptions) (io.WriteCloser, []*tailcfg.SSHRecordingAttempt, <-chan error, error) {
 	if len(recs) == 0 {
 		return nil, nil, nil, errors.New("no recorders configured")
 	}
@@ -65,6 +77,7 @@ func ConnectToRecorder(ctx context.Context, recs []netip.AddrPort, dial netx.Dia
 
 	var errs []error
 	var attempts []*tailcfg.SSHRecordingAttempt
+	var uploads []upload
 	for _, ap := range recs {
 		attempt := &tailcfg.SSHRecordingAttempt{
 			Recorder: ap,
@@ -80,9 +93,13 @@ func ConnectToRecorder(ctx context.Context, recs []netip.AddrPort, dial netx.Dia
 		// request body is closed (instead of returning a 404 as one would
 		// expect). Sending a HEAD request without a body does not have that
 		// problem.
-		if supportsV2(ctx, hc, ap) {
+		v2, resumable := probeV2(ctx, hc, ap)
+		switch {
+		case v2 && resumable && opts.SpoolSize > 0:
+			pw, errChan, err = connectResumable(ctx, hc, dial, ap, opts)
+		case v2:
 			pw, errChan, err = connectV2(ctx, hc, ap)
-		} else {
+		default:
 			pw, errChan, err = connectV1(ctx, clientHTTP1(dialCtx, dial), ap)
 		}
 		if err != nil {
@@ -91,26 +108,39 @@ func ConnectToRecorder(ctx context.Context, recs []netip.AddrPort, dial netx.Dia
 			errs = append(errs, err)
 			continue
 		}
-		return pw, attempts, errChan, nil
+		uploads = append(uploads, upload{ap: ap, w: pw, errc: errChan})
+		if !opts.FanOut {
+			break
+		}
+	}
+	switch len(uploads) {
+	case 0:
+		return nil, attempts, nil, multierr.New(errs...)
+	case 1:
+		return uploads[0].w, attempts, uploads[0].errc, nil
 	}
-	return nil, attempts, nil, multierr.New(errs...)
+	w, errChan := fanOut(uploads, opts.RequireAll, opts.logf)
+	return w, attempts, errChan, nil
 }
 
-// supportsV2 checks whether a recorder instance supports the /v2/record
-// endpoint.
-func supportsV2(ctx context.Context, hc *http.Client, ap netip.AddrPort) bool {
+// probeV2 checks whether a recorder instance supports the /v2/record
+// endpoint, and whether it supports resuming uploads to it.
+func probeV2(ctx context.Context, hc *http.Client, ap netip.AddrPort) (v2, resumable bool) {
 	ctx, cancel := context.WithTimeout(ctx, http2ProbeTimeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, httpm.HEAD, fmt.Sprintf("http://%s/v2/record", ap), nil)
 	if err != nil {
-		return false
+		return false, false
 	}
 	resp, err := hc.Do(req)
 	if err != nil {
-		return false
+		return false, false
 	}
 	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK && resp.ProtoMajor > 1
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor <= 1 {
+		return false, false
+	}
+	return true, resp.Header.Get(HeaderResumable) == "true"
 }
 
 // connectV1 connects to the legacy /record endpoint on the recorder. It is
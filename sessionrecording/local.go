@@ -0,0 +1,236 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"tailscale.com/smallzstd"
+)
+
+const (
+	// castExt is the file extension of uncompressed local recordings.
+	castExt = ".cast"
+	// castZstdExt is the file extension of zstd-compressed local recordings.
+	castZstdExt = ".cast.zst"
+)
+
+// LocalStore is a directory of session recordings in the asciicast v2 format,
+// for use when recordings are kept on local disk instead of being uploaded to
+// a recorder.
+//
+// Each recording is a single file. Old recordings are removed when a new
+// recording is created, according to MaxSize and MaxAge.
+type LocalStore struct {
+	// Dir is the directory in which recordings are stored.
+	// It is created with mode 0700 if it doesn't exist.
+	Dir string
+
+	// MaxSize, if non-zero, is the maximum total size in bytes of all
+	// recordings in Dir. When exceeded, the oldest recordings are removed
+	// until the total is below MaxSize.
+	MaxSize int64
+
+	// MaxAge, if non-zero, is the maximum age of a recording before it is
+	// removed.
+	MaxAge time.Duration
+
+	// Compress specifies whether new recordings are zstd compressed.
+	Compress bool
+}
+
+// LocalRecording describes a recording in a [LocalStore].
+type LocalRecording struct {
+	// Name is the base name of the recording file.
+	Name string
+	// Size is the size of the file on disk, which may be compressed.
+	Size int64
+	// ModTime is the time the recording was last written to.
+	ModTime time.Time
+	// Header is the recording's header, or nil if it could not be read
+	// (for instance, because the recording is still being written).
+	Header *CastHeader
+}
+
+// isRecordingName reports whether name is the base name of a local recording.
+func isRecordingName(name string) bool {
+	return strings.HasSuffix(name, castExt) || strings.HasSuffix(name, castZstdExt)
+}
+
+// Create creates a new recording file in s, first removing any recordings
+// that exceed the store's limits.
+//
+// The caller is responsible for writing the [CastHeader] and the recording
+// lines to the returned WriteCloser, and for closing it.
+func (s *LocalStore) Create(now time.Time) (io.WriteCloser, error) {
+	if s.Dir == "" {
+		return nil, errors.New("no directory configured for local recordings")
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, err
+	}
+	// Removing old recordings is best effort; failing to do so
+	// shouldn't prevent recording the new session.
+	s.Prune(now)
+	ext := castExt
+	if s.Compress {
+		ext = castZstdExt
+	}
+	f, err := os.CreateTemp(s.Dir, fmt.Sprintf("ssh-session-%v-*%s", now.UnixNano(), ext))
+	if err != nil {
+		return nil, err
+	}
+	if !s.Compress {
+		return f, nil
+	}
+	enc, err := smallzstd.NewEncoder(f)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &zstdFileWriter{Encoder: enc, f: f}, nil
+}
+
+// zstdFileWriter is an io.WriteCloser that compresses writes to a file.
+type zstdFileWriter struct {
+	*zstd.Encoder
+	f *os.File
+}
+
+func (w *zstdFileWriter) Close() error {
+	err := w.Encoder.Close()
+	if err2 := w.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Prune removes recordings from s that are older than MaxAge, and then the
+// oldest recordings until the total size is within MaxSize.
+func (s *LocalStore) Prune(now time.Time) error {
+	if s.MaxAge == 0 && s.MaxSize == 0 {
+		return nil
+	}
+	recs, err := s.list(false)
+	if err != nil {
+		return err
+	}
+	// recs is sorted newest first.
+	var errs []error
+	var total int64
+	for _, r := range recs {
+		total += r.Size
+		tooOld := s.MaxAge > 0 && now.Sub(r.ModTime) > s.MaxAge
+		tooBig := s.MaxSize > 0 && total > s.MaxSize
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.Dir, r.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// List returns the recordings in s, newest first.
+func (s *LocalStore) List() ([]LocalRecording, error) {
+	return s.list(true)
+}
+
+func (s *LocalStore) list(withHeaders bool) ([]LocalRecording, error) {
+	des, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []LocalRecording
+	for _, de := range des {
+		if !de.Type().IsRegular() || !isRecordingName(de.Name()) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		r := LocalRecording{
+			Name:    de.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		}
+		if withHeaders {
+			r.Header, _ = s.readHeader(de.Name())
+		}
+		recs = append(recs, r)
+	}
+	slices.SortFunc(recs, func(a, b LocalRecording) int {
+		if c := b.ModTime.Compare(a.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Name, a.Name)
+	})
+	return recs, nil
+}
+
+func (s *LocalStore) readHeader(name string) (*CastHeader, error) {
+	rc, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	line, err := bufio.NewReader(rc).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	h := new(CastHeader)
+	if err := json.Unmarshal(line, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Open opens the named recording in s for reading. The returned
+// ReadCloser yields the uncompressed asciicast contents.
+func (s *LocalStore) Open(name string) (io.ReadCloser, error) {
+	if !isRecordingName(name) || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, castZstdExt) {
+		return f, nil
+	}
+	dec, err := smallzstd.NewDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &zstdFileReader{Decoder: dec, f: f}, nil
+}
+
+// zstdFileReader is an io.ReadCloser that decompresses reads from a file.
+type zstdFileReader struct {
+	*zstd.Decoder
+	f *os.File
+}
+
+func (r *zstdFileReader) Close() error {
+	r.Decoder.Close()
+	return r.f.Close()
+}
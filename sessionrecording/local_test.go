@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "zstd"}[compress], func(t *testing.T) {
+			st := &LocalStore{
+				Dir:      filepath.Join(t.TempDir(), "recs"),
+				Compress: compress,
+			}
+			now := time.Unix(1700000000, 0)
+			w, err := st.Create(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr, _ := json.Marshal(CastHeader{Version: 2, SSHUser: "alice", LocalUser: "root"})
+			body := string(hdr) + "\n" + `[0.5,"o","hello"]` + "\n"
+			if _, err := io.WriteString(w, body); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			recs, err := st.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(recs) != 1 {
+				t.Fatalf("got %d recordings; want 1", len(recs))
+			}
+			if recs[0].Header == nil || recs[0].Header.SSHUser != "alice" {
+				t.Errorf("header = %+v; want SSHUser alice", recs[0].Header)
+			}
+			rc, err := st.Open(recs[0].Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("contents = %q; want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestLocalStoreOpenInvalidName(t *testing.T) {
+	st := &LocalStore{Dir: t.TempDir()}
+	for _, name := range []string{"", "foo.txt", "../x.cast", "a/b.cast", ".cast"} {
+		if rc, err := st.Open(name); err == nil {
+			rc.Close()
+			t.Errorf("Open(%q) succeeded; want error", name)
+		}
+	}
+}
+
+func TestLocalStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	write := func(name string, size int, age time.Duration) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mt := now.Add(-age)
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("old.cast", 10, 48*time.Hour)
+	write("a.cast", 100, 3*time.Hour)
+	write("b.cast.zst", 100, 2*time.Hour)
+	write("c.cast", 100, 1*time.Hour)
+	write("unrelated.txt", 1000, 100*time.Hour)
+
+	st := &LocalStore{Dir: dir, MaxAge: 24 * time.Hour, MaxSize: 250}
+	if err := st.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	des, _ := os.ReadDir(dir)
+	for _, de := range des {
+		got = append(got, de.Name())
+	}
+	want := []string{"b.cast.zst", "c.cast", "unrelated.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("remaining files = %q; want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("remaining files = %q; want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package recorder implements a session recording server that is compatible
+// with tsrecorder. It accepts recordings uploaded by Tailscale SSH and the
+// Kubernetes API server proxy using the protocol implemented by the client in
+// package sessionrecording, and serves a web UI to list and replay them.
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"tailscale.com/sessionrecording"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// defaultResumeTimeout is the default for Server.ResumeTimeout.
+	defaultResumeTimeout = 5 * time.Minute
+	// takeoverTimeout is how long a request that resumes an upload waits
+	// for the request it replaces to stop.
+	takeoverTimeout = 10 * time.Second
+)
+
+// ackInterval is how often ack frames are sent to clients of the /v2/record
+// endpoint. The client terminates uploads that aren't acked for 30s, so acks
+// are sent even when no new data was received. It is a variable to allow
+// overriding it in tests.
+var ackInterval = time.Second
+
+// Server is a session recording server.
+//
+// Its zero value is not valid; Storage must be set.
+type Server struct {
+	// Storage is where recordings are stored.
+	Storage Storage
+
+	// Logf is the logger to use. If nil, log.Printf is used.
+	Logf logger.Logf
+
+	// ResumeTimeout is how long an interrupted /v2/record upload can be
+	// resumed for, after which the partial recording is finalized. If zero,
+	// a default of five minutes is used.
+	ResumeTimeout time.Duration
+
+	// AuthorizeUI, if non-nil, enables the web UI that lists and replays
+	// recordings, and reports whether the UI request r may be served. If it
+	// returns an error, the request is rejected with 403 Forbidden.
+	//
+	// Recordings contain sensitive session contents and nodes upload them
+	// on the same listener, so the UI is disabled when AuthorizeUI is nil.
+	AuthorizeUI func(r *http.Request) error
+
+	// Now, if non-nil, returns the current time. It is used in tests.
+	Now func() time.Time
+
+	initOnce sync.Once
+	handler  http.Handler
+
+	mu      sync.Mutex
+	uploads map[string]*upload // by recording ID
+}
+
+// upload is a resumable recording upload, by recording ID.
+type upload struct {
+	name string
+	w    io.WriteCloser
+	n    atomic.Int64 // number of bytes written to w
+
+	// The following fields are guarded by Server.mu.
+
+	gen    int           // incremented each time a request takes over the upload
+	stop   func()        // interrupts the request currently writing to w, if any
+	done   chan struct{} // closed when the current request stops using w
+	expire *time.Timer   // finalizes the upload if not resumed in time
+}
+
+// v2ResponseFrame is a frame in the response to a /v2/record request. It
+// matches the type used by the client in package sessionrecording.
+type v2ResponseFrame struct {
+	Ack   int64  `json:"ack,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Server) resumeTimeout() time.Duration {
+	if s.ResumeTimeout > 0 {
+		return s.ResumeTimeout
+	}
+	return defaultResumeTimeout
+}
+
+// ServeHTTP implements http.Handler. It serves both HTTP/1 and unencrypted
+// HTTP/2 (h2c) requests, as the latter are needed by the /v2/record endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.initOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /record", s.serveRecordV1)
+		mux.HandleFunc("HEAD /v2/record", s.serveProbeV2)
+		mux.HandleFunc("POST /v2/record", s.serveRecordV2)
+		if s.AuthorizeUI != nil {
+			s.registerUI(mux)
+		}
+		s.handler = h2c.NewHandler(mux, &http2.Server{})
+	})
+	s.handler.ServeHTTP(w, r)
+}
+
+// serveRecordV1 handles the legacy /record endpoint, where the whole
+// recording is the request body.
+func (s *Server) serveRecordV1(w http.ResponseWriter, r *http.Request) {
+	name := newRecordingName(s.now())
+	wc, err := s.Storage.Create(r.Context(), name)
+	if err != nil {
+		s.logf("recorder: creating recording: %v", err)
+		http.Error(w, "failed to create recording", http.StatusInternalServerError)
+		return
+	}
+	// Reading the body sends the 100-continue response the client waits
+	// for.
+	n, err := io.Copy(wc, r.Body)
+	if err2 := wc.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		s.logf("recorder: recording %s from %v failed after %d bytes: %v", name, r.RemoteAddr, n, err)
+		http.Error(w, "failed to store recording", http.StatusInternalServerError)
+		return
+	}
+	s.logf("recorder: stored recording %s from %v (%d bytes)", name, r.RemoteAddr, n)
+}
+
+// serveProbeV2 handles the HEAD request that clients use to check whether
+// the /v2/record endpoint is supported.
+func (s *Server) serveProbeV2(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(sessionrecording.HeaderResumable, "true")
+}
+
+// storageError is an error writing a recording to its Storage, as opposed to
+// an error reading it from the client.
+type storageError struct{ err error }
+
+func (e storageError) Error() string { return e.err.Error() }
+func (e storageError) Unwrap() error { return e.err }
+
+// serveRecordV2 handles the /v2/record endpoint, over HTTP/2. The recording is
+// the request body, and the response is a stream of JSON frames acking the
+// received data.
+//
+// If the request has a recording ID, interrupted uploads can be resumed by a
+// new request with the same ID, within Server.ResumeTimeout.
+func (s *Server) serveRecordV2(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor < 2 {
+		http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	id := r.Header.Get(sessionrecording.HeaderRecordingID)
+	done := make(chan struct{})
+	defer close(done)
+	stop := func() { r.Body.Close() }
+
+	var up *upload
+	var gen int
+	if off := r.Header.Get(sessionrecording.HeaderRecordingOffset); off != "" {
+		if id == "" {
+			http.Error(w, "missing recording ID", http.StatusBadRequest)
+			return
+		}
+		var err error
+		up, gen, err = s.takeOver(id, stop, done)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set(sessionrecording.HeaderRecordingOffset, strconv.FormatInt(up.n.Load(), 10))
+		s.logf("recorder: resuming recording %s from %v at offset %d (client has %s)", up.name, r.RemoteAddr, up.n.Load(), off)
+	} else {
+		name := newRecordingName(s.now())
+		wc, err := s.Storage.Create(r.Context(), name)
+		if err != nil {
+			s.logf("recorder: creating recording: %v", err)
+			http.Error(w, "failed to create recording", http.StatusInternalServerError)
+			return
+		}
+		up = &upload{name: name, w: wc}
+		s.mu.Lock()
+		up.gen = 1
+		gen = up.gen
+		up.stop, up.done = stop, done
+		if id != "" {
+			mak.Set(&s.uploads, id, up)
+		}
+		s.mu.Unlock()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				if _, err := up.w.Write(buf[:n]); err != nil {
+					readErr <- storageError{err}
+					return
+				}
+				up.n.Add(int64(n))
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	enc := json.NewEncoder(w)
+	sendFrame := func(f v2ResponseFrame) error {
+		if err := enc.Encode(f); err != nil {
+			return err
+		}
+		return http.NewResponseController(w).Flush()
+	}
+	tick := time.NewTicker(ackInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case err := <-readErr:
+			var serr storageError
+			switch {
+			case errors.Is(err, io.EOF):
+				sendFrame(v2ResponseFrame{Ack: up.n.Load()})
+				s.finish(id, up, gen, nil)
+			case errors.As(err, &serr):
+				sendFrame(v2ResponseFrame{Error: "failed to store recording"})
+				s.finish(id, up, gen, serr)
+			default:
+				s.interrupted(id, up, gen, err)
+			}
+			return
+		case <-tick.C:
+			if err := sendFrame(v2ResponseFrame{Ack: up.n.Load()}); err != nil {
+				// The client went away. Stop reading, and wait for
+				// the reader to return so that we know how much was
+				// written.
+				r.Body.Close()
+				s.interrupted(id, up, gen, <-readErr)
+				return
+			}
+		}
+	}
+}
+
+// takeOver prepares the upload with the given recording ID to be resumed by a
+// new request, interrupting the request currently writing to it if any. stop
+// and done are those of the new request. It returns the upload's new
+// generation.
+func (s *Server) takeOver(id string, newStop func(), newDone chan struct{}) (_ *upload, gen int, _ error) {
+	s.mu.Lock()
+	up := s.uploads[id]
+	if up == nil {
+		s.mu.Unlock()
+		return nil, 0, errors.New("unknown recording")
+	}
+	if up.expire != nil {
+		up.expire.Stop()
+		up.expire = nil
+	}
+	up.gen++
+	gen = up.gen
+	stop, done := up.stop, up.done
+	up.stop, up.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		stop()
+		select {
+		case <-done:
+		case <-time.After(takeoverTimeout):
+			return nil, 0, errors.New("timeout waiting for previous upload to stop")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if up.gen != gen || s.uploads[id] != up {
+		return nil, 0, errors.New("recording resumed concurrently")
+	}
+	up.stop, up.done = newStop, newDone
+	return up, gen, nil
+}
+
+// interrupted handles the end of a request for up that ended with err before
+// the whole recording was uploaded. If the upload has an ID, it is kept open
+// for Server.ResumeTimeout for the client to resume it.
+func (s *Server) interrupted(id string, up *upload, gen int, err error) {
+	if id == "" {
+		s.finish(id, up, gen, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if up.gen != gen {
+		// Another request took over.
+		return
+	}
+	s.logf("recorder: upload of recording %s interrupted at offset %d: %v", up.name, up.n.Load(), err)
+	up.stop = nil
+	up.expire = time.AfterFunc(s.resumeTimeout(), func() {
+		s.mu.Lock()
+		stillWaiting := up.gen == gen && s.uploads[id] == up
+		s.mu.Unlock()
+		if stillWaiting {
+			s.finish(id, up, gen, errors.New("upload was not resumed"))
+		}
+	})
+}
+
+// finish finalizes the recording of up, if no other request took it over.
+// A non-nil err is the reason the recording ended before the client closed
+// it; the partial recording is kept.
+func (s *Server) finish(id string, up *upload, gen int, err error) {
+	s.mu.Lock()
+	if up.gen != gen {
+		s.mu.Unlock()
+		return
+	}
+	up.gen++ // invalidate any expiry timer
+	up.stop = nil
+	if id != "" && s.uploads[id] == up {
+		delete(s.uploads, id)
+	}
+	s.mu.Unlock()
+
+	if cerr := up.w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.logf("recorder: stored partial recording %s (%d bytes): %v", up.name, up.n.Load(), err)
+		return
+	}
+	s.logf("recorder: stored recording %s (%d bytes)", up.name, up.n.Load())
+}
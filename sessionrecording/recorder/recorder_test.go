@@ -0,0 +1,348 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/sessionrecording"
+	"tailscale.com/tstest"
+)
+
+func startServer(t *testing.T, s *Server) netip.AddrPort {
+	t.Helper()
+	tstest.Replace(t, &ackInterval, 10*time.Millisecond)
+	if s.Logf == nil {
+		s.Logf = t.Logf
+	}
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return netip.MustParseAddrPort(hs.Listener.Addr().String())
+}
+
+// onlyRecording returns the contents of the only recording in st.
+func onlyRecording(t *testing.T, st Storage) []byte {
+	t.Helper()
+	ctx := context.Background()
+	recs, err := st.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d recordings; want 1", len(recs))
+	}
+	rc, err := st.Open(ctx, recs[0].Name)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func writeRecording(t *testing.T, w io.WriteCloser, size int64) []byte {
+	t.Helper()
+	var sent bytes.Buffer
+	h := sessionrecording.CastHeader{
+		Version:   2,
+		Timestamp: time.Now().Unix(),
+		SrcNode:   "laptop.tail-scale.ts.net",
+		SSHUser:   "alice",
+		LocalUser: "root",
+	}
+	if err := json.NewEncoder(io.MultiWriter(w, &sent)).Encode(h); err != nil {
+		t.Fatalf("writing header: %v", err)
+	}
+	if _, err := io.CopyN(io.MultiWriter(w, &sent), rand.Reader, size); err != nil {
+		t.Fatalf("writing recording data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing recording stream: %v", err)
+	}
+	return sent.Bytes()
+}
+
+func waitUpload(t *testing.T, errc <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for upload to complete")
+	}
+}
+
+// waitRecordings waits for st to have n recordings, as the server may store
+// a recording after acking it.
+func waitRecordings(t *testing.T, st Storage, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		recs, err := st.List(context.Background())
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(recs) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d recordings; want %d", len(recs), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecordV2(t *testing.T) {
+	st := &DirStorage{Dir: t.TempDir()}
+	ap := startServer(t, &Server{Storage: st})
+
+	d := new(net.Dialer)
+	w, _, errc, err := sessionrecording.ConnectToRecorder(context.Background(), []netip.AddrPort{ap}, d.DialContext)
+	if err != nil {
+		t.Fatalf("ConnectToRecorder: %v", err)
+	}
+	sent := writeRecording(t, w, 256<<10)
+	waitUpload(t, errc)
+	waitRecordings(t, st, 1)
+	if got := onlyRecording(t, st); !bytes.Equal(got, sent) {
+		t.Errorf("recorded %d bytes, not equal to the %d bytes sent", len(got), len(sent))
+	}
+}
+
+func TestRecordV1(t *testing.T) {
+	st := &DirStorage{Dir: t.TempDir()}
+	ap := startServer(t, &Server{Storage: st})
+
+	const body = "{\"version\":2}\n[0.1,\"o\",\"hi\"]\n"
+	resp, err := http.Post("http://"+ap.String()+"/record", "application/octet-stream", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v; want 200", resp.Status)
+	}
+	if got := onlyRecording(t, st); string(got) != body {
+		t.Errorf("recorded %q; want %q", got, body)
+	}
+}
+
+// breakingConn is a net.Conn that fails after writing limit bytes.
+type breakingConn struct {
+	net.Conn
+	limit int
+	n     int
+}
+
+func (c *breakingConn) Write(p []byte) (int, error) {
+	if c.n+len(p) > c.limit {
+		c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+	c.n += len(p)
+	return c.Conn.Write(p)
+}
+
+func TestRecordV2Resume(t *testing.T) {
+	st := &DirStorage{Dir: t.TempDir()}
+	ap := startServer(t, &Server{Storage: st})
+
+	d := new(net.Dialer)
+	var dials atomic.Int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// Break the first connection, which carries the probe and the
+		// first upload request, partway through the upload.
+		if dials.Add(1) == 1 {
+			return &breakingConn{Conn: c, limit: 128 << 10}, nil
+		}
+		return c, nil
+	}
+	opts := sessionrecording.ConnectOptions{
+		SpoolSize: 512 << 10,
+		Logf:      t.Logf,
+	}
+	w, _, errc, err := sessionrecording.Connect(context.Background(), []netip.AddrPort{ap}, dial, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	sent := writeRecording(t, w, 1<<20)
+	waitUpload(t, errc)
+	waitRecordings(t, st, 1)
+	if dials.Load() < 2 {
+		t.Errorf("got %d dials; want at least 2, as the upload should have been resumed", dials.Load())
+	}
+	if got := onlyRecording(t, st); !bytes.Equal(got, sent) {
+		t.Errorf("recorded %d bytes, not equal to the %d bytes sent", len(got), len(sent))
+	}
+}
+
+// closeRecorder is a Storage that records which recordings were closed.
+type closeRecorder struct {
+	DirStorage
+	mu     sync.Mutex
+	closed []string
+}
+
+func (s *closeRecorder) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	wc, err := s.DirStorage.Create(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &closeHook{WriteCloser: wc, onClose: func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = append(s.closed, name)
+	}}, nil
+}
+
+type closeHook struct {
+	io.WriteCloser
+	onClose func()
+}
+
+func (c *closeHook) Close() error {
+	c.onClose()
+	return c.WriteCloser.Close()
+}
+
+func TestInterruptedUploadFinalized(t *testing.T) {
+	st := &closeRecorder{DirStorage: DirStorage{Dir: t.TempDir()}}
+	s := &Server{Storage: st, ResumeTimeout: 50 * time.Millisecond}
+	ap := startServer(t, s)
+
+	d := new(net.Dialer)
+	var dials atomic.Int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if dials.Add(1) > 1 {
+			// Recorder is unreachable after the first connection.
+			return nil, net.ErrClosed
+		}
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &breakingConn{Conn: c, limit: 64 << 10}, nil
+	}
+	opts := sessionrecording.ConnectOptions{
+		SpoolSize:     1 << 20,
+		ResumeTimeout: 200 * time.Millisecond,
+	}
+	w, _, errc, err := sessionrecording.Connect(context.Background(), []netip.AddrPort{ap}, dial, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	go io.CopyN(w, rand.Reader, 256<<10)
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("upload succeeded; want failure")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for upload to fail")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		st.mu.Lock()
+		n := len(st.closed)
+		st.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("partial recording was not finalized")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.uploads) != 0 {
+		t.Errorf("got %d uploads still registered; want 0", len(s.uploads))
+	}
+}
+
+func TestDirStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "recordings")
+	st := &DirStorage{Dir: dir}
+
+	recs, err := st.List(ctx)
+	if err != nil || len(recs) != 0 {
+		t.Fatalf("List on missing dir = %v, %v; want empty, nil", recs, err)
+	}
+	name := newRecordingName(time.Now())
+	wc, err := st.Create(ctx, name)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	io.WriteString(wc, "hello")
+	if err := wc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Create(ctx, name); err == nil {
+		t.Error("Create of existing recording succeeded; want error")
+	}
+	// Files that aren't recordings are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	recs, err = st.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Name != name || recs[0].Size != 5 {
+		t.Errorf("List = %+v; want one recording %q of size 5", recs, name)
+	}
+	for _, bad := range []string{"../x.cast", "notes.txt", ".cast", `a\b.cast`} {
+		if _, err := st.Open(ctx, bad); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%q) = %v; want not-exist error", bad, err)
+		}
+		if _, err := st.Create(ctx, bad); err == nil {
+			t.Errorf("Create(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestSortRecordings(t *testing.T) {
+	t0 := time.Now()
+	recs := []Recording{
+		{Name: "a.cast", ModTime: t0},
+		{Name: "c.cast", ModTime: t0.Add(time.Second)},
+		{Name: "b.cast", ModTime: t0},
+	}
+	sortRecordings(recs)
+	var got []string
+	for _, r := range recs {
+		got = append(got, r.Name)
+	}
+	if want := "c.cast b.cast a.cast"; strings.Join(got, " ") != want {
+		t.Errorf("got order %q; want %q", got, want)
+	}
+}
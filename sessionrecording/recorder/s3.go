@@ -0,0 +1,167 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3UploadTimeout is the maximum time to upload a completed recording to S3.
+const s3UploadTimeout = 10 * time.Minute
+
+// S3API is the subset of the S3 client API used by S3Storage. It is
+// implemented by *s3.Client.
+type S3API interface {
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	ListObjectsV2(context.Context, *s3.ListObjectsV2Input, ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+var _ S3API = (*s3.Client)(nil)
+
+// S3Storage is a Storage that keeps recordings in an S3-compatible object
+// store.
+//
+// As objects can't be appended to, recordings are written to a temporary
+// local file while in progress, and uploaded when complete.
+type S3Storage struct {
+	// Client is the S3 client to use.
+	Client S3API
+	// Bucket is the name of the bucket to store recordings in.
+	Bucket string
+	// Prefix is prepended to recording names to form object keys.
+	// If non-empty, it typically ends with a slash.
+	Prefix string
+	// TempDir is the directory for in-progress recordings. If empty,
+	// os.TempDir is used.
+	//
+	// Recordings that fail to upload are moved to its "failed"
+	// subdirectory, so they can be uploaded manually.
+	TempDir string
+}
+
+func (s *S3Storage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	if !validRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	f, err := os.CreateTemp(s.TempDir, "tsrecorder-*-"+name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{s: s, key: s.Prefix + name, f: f}, nil
+}
+
+// s3Writer is an in-progress recording of an S3Storage.
+type s3Writer struct {
+	s   *S3Storage
+	key string
+	f   *os.File
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.f.Write(p)
+}
+
+// Close uploads the recording and removes the temporary file. If the upload
+// fails, the file is kept, and its path is included in the returned error.
+func (w *s3Writer) Close() error {
+	err := w.upload()
+	w.f.Close()
+	if err == nil {
+		os.Remove(w.f.Name())
+		return nil
+	}
+	return fmt.Errorf("%w; recording kept in %s", err, w.keepFailed())
+}
+
+func (w *s3Writer) upload() error {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3UploadTimeout)
+	defer cancel()
+	_, err := w.s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(w.s.Bucket),
+		Key:         aws.String(w.key),
+		Body:        w.f,
+		ContentType: aws.String("application/x-asciicast"),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %q to S3: %w", w.key, err)
+	}
+	return nil
+}
+
+// keepFailed moves the temporary file of a recording that failed to upload
+// to the "failed" subdirectory of its directory, and returns its new path.
+// If it can't be moved, the file is left in place and its path returned.
+func (w *s3Writer) keepFailed() string {
+	name := w.f.Name()
+	dir := filepath.Join(filepath.Dir(name), "failed")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return name
+	}
+	failed := filepath.Join(dir, filepath.Base(name))
+	if err := os.Rename(name, failed); err != nil {
+		return name
+	}
+	return failed
+}
+
+func (s *S3Storage) List(ctx context.Context) ([]Recording, error) {
+	var recs []Recording
+	p := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(o.Key), s.Prefix)
+			if !validRecordingName(name) || path.Base(name) != name {
+				continue
+			}
+			recs = append(recs, Recording{
+				Name:    name,
+				Size:    aws.ToInt64(o.Size),
+				ModTime: aws.ToTime(o.LastModified),
+			})
+		}
+	}
+	return recs, nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !validRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q: %w", name, fs.ErrNotExist)
+	}
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + name),
+	})
+	var nsk *types.NoSuchKey
+	if errors.As(err, &nsk) {
+		return nil, fmt.Errorf("recording %q: %w", name, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
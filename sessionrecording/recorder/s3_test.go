@@ -0,0 +1,177 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeS3 is an in-memory S3API.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // by bucket/key
+}
+
+func (f *fakeS3) PutObject(ctx context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects == nil {
+		f.objects = make(map[string][]byte)
+	}
+	f.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(ctx context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, in *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &s3.ListObjectsV2Output{}
+	bucketPrefix := aws.ToString(in.Bucket) + "/"
+	for k, b := range f.objects {
+		key, ok := strings.CutPrefix(k, bucketPrefix)
+		if !ok || !strings.HasPrefix(key, aws.ToString(in.Prefix)) {
+			continue
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(b))),
+			LastModified: aws.Time(time.Now()),
+		})
+	}
+	return out, nil
+}
+
+func TestS3Storage(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	st := &S3Storage{
+		Client:  fake,
+		Bucket:  "recs",
+		Prefix:  "ssh/",
+		TempDir: t.TempDir(),
+	}
+
+	name := newRecordingName(time.Now())
+	wc, err := st.Create(ctx, name)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	io.WriteString(wc, "hello")
+	if recs, _ := st.List(ctx); len(recs) != 0 {
+		t.Errorf("in-progress recording was listed: %+v", recs)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := string(fake.objects["recs/ssh/"+name]); got != "hello" {
+		t.Errorf("uploaded object = %q; want %q", got, "hello")
+	}
+
+	// Objects outside the prefix or in sub-directories are ignored.
+	fake.objects["recs/other/x.cast"] = nil
+	fake.objects["recs/ssh/sub/x.cast"] = nil
+	recs, err := st.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(recs) != 1 || recs[0].Name != name || recs[0].Size != 5 {
+		t.Errorf("List = %+v; want one recording %q of size 5", recs, name)
+	}
+
+	rc, err := st.Open(ctx, name)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "hello" {
+		t.Errorf("Open read %q; want %q", b, "hello")
+	}
+	if _, err := st.Open(ctx, "missing.cast"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of missing recording = %v; want not-exist error", err)
+	}
+}
+
+func TestS3StorageRecord(t *testing.T) {
+	fake := &fakeS3{}
+	st := &S3Storage{Client: fake, Bucket: "recs", TempDir: t.TempDir()}
+	ap := startServer(t, &Server{Storage: st})
+
+	resp, err := http.Post("http://"+ap.String()+"/record", "application/octet-stream", strings.NewReader("{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v; want 200", resp.Status)
+	}
+	if got := string(onlyRecording(t, st)); got != "{}\n" {
+		t.Errorf("recorded %q; want %q", got, "{}\n")
+	}
+}
+
+// failingS3 is an S3API whose uploads always fail.
+type failingS3 struct {
+	fakeS3
+}
+
+func (*failingS3) PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestS3StorageKeepsFailedUploads(t *testing.T) {
+	dir := t.TempDir()
+	st := &S3Storage{Client: &failingS3{}, Bucket: "recs", TempDir: dir}
+	w, err := st.Create(context.Background(), "rec.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "{}\n"); err != nil {
+		t.Fatal(err)
+	}
+	closeErr := w.Close()
+	if closeErr == nil {
+		t.Fatal("Close succeeded; want upload error")
+	}
+
+	kept, err := filepath.Glob(filepath.Join(dir, "failed", "*rec.cast"))
+	if err != nil || len(kept) != 1 {
+		t.Fatalf("got failed recordings %q (err=%v); want 1", kept, err)
+	}
+	if got, err := os.ReadFile(kept[0]); err != nil || string(got) != "{}\n" {
+		t.Errorf("kept recording has %q (err=%v); want %q", got, err, "{}\n")
+	}
+	if !strings.Contains(closeErr.Error(), kept[0]) {
+		t.Errorf("error %q doesn't name the kept recording %s", closeErr, kept[0])
+	}
+}
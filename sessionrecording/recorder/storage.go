@@ -0,0 +1,144 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/sessionrecording"
+	"tailscale.com/util/rands"
+)
+
+// castExt is the file extension of stored recordings.
+const castExt = ".cast"
+
+// Storage stores session recordings.
+type Storage interface {
+	// Create creates a new recording with the given name. The recording is
+	// complete once the returned WriteCloser is closed. Recordings that are
+	// still being written may or may not be returned by List.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+
+	// List returns the stored recordings, in no particular order.
+	List(ctx context.Context) ([]Recording, error)
+
+	// Open opens the named recording for reading. It returns an error
+	// wrapping fs.ErrNotExist if there is no such recording.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// Recording describes a stored recording.
+type Recording struct {
+	// Name is the name of the recording in its Storage.
+	Name string `json:"name"`
+	// Size is the size of the recording in bytes.
+	Size int64 `json:"size"`
+	// ModTime is when the recording was last written to.
+	ModTime time.Time `json:"modTime"`
+}
+
+// newRecordingName returns the name of a new recording started at now.
+func newRecordingName(now time.Time) string {
+	return now.UTC().Format("20060102T150405.000000000Z") + "-" + rands.HexString(8) + castExt
+}
+
+// validRecordingName reports whether name is a name that could have been
+// returned by newRecordingName. It guards against path traversal through
+// names provided by users of the web UI.
+func validRecordingName(name string) bool {
+	if !strings.HasSuffix(name, castExt) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	return !strings.ContainsAny(name, `/\`)
+}
+
+// readHeader reads the asciicast header of the named recording in st.
+func readHeader(ctx context.Context, st Storage, name string) (*sessionrecording.CastHeader, error) {
+	rc, err := st.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	line, err := bufio.NewReader(io.LimitReader(rc, 64<<10)).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	h := new(sessionrecording.CastHeader)
+	if err := json.Unmarshal(line, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// sortRecordings sorts recs newest first.
+func sortRecordings(recs []Recording) {
+	slices.SortFunc(recs, func(a, b Recording) int {
+		if c := b.ModTime.Compare(a.ModTime); c != 0 {
+			return c
+		}
+		return strings.Compare(b.Name, a.Name)
+	})
+}
+
+// DirStorage is a Storage that keeps recordings as files in a local
+// directory.
+type DirStorage struct {
+	// Dir is the directory in which recordings are stored. It is created
+	// with mode 0700 if it doesn't exist.
+	Dir string
+}
+
+func (s *DirStorage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	if !validRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q", name)
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(s.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (s *DirStorage) List(ctx context.Context) ([]Recording, error) {
+	des, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []Recording
+	for _, de := range des {
+		if !de.Type().IsRegular() || !validRecordingName(de.Name()) {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		recs = append(recs, Recording{
+			Name:    de.Name(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+	return recs, nil
+}
+
+func (s *DirStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !validRecordingName(name) {
+		return nil, fmt.Errorf("invalid recording name %q: %w", name, fs.ErrNotExist)
+	}
+	return os.Open(filepath.Join(s.Dir, name))
+}
@@ -0,0 +1,189 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"tailscale.com/sessionrecording"
+)
+
+// maxUIRecordings is the maximum number of recordings listed by the web UI,
+// as the header of each listed recording has to be read from storage.
+const maxUIRecordings = 500
+
+//go:embed ui-list.html
+var listHTML string
+
+//go:embed ui-play.html
+var playHTML string
+
+//go:embed ui-style.css
+var styleCSS string
+
+var (
+	listTmpl = template.Must(template.New("list").Parse(listHTML))
+	playTmpl = template.Must(template.New("play").Parse(playHTML))
+)
+
+// recordingInfo is a recording as shown in the web UI and returned by the
+// /api/recordings endpoint.
+type recordingInfo struct {
+	Recording
+	Start   time.Time `json:"start,omitzero"`
+	From    string    `json:"from,omitempty"`    // node or user that started the session
+	Target  string    `json:"target,omitempty"`  // SSH user or Kubernetes pod
+	Command string    `json:"command,omitempty"` // command run, if any
+}
+
+// newRecordingInfo returns the recordingInfo for rec, with header h. h may be
+// nil if the header could not be read.
+func newRecordingInfo(rec Recording, h *sessionrecording.CastHeader) recordingInfo {
+	ri := recordingInfo{Recording: rec, Start: rec.ModTime}
+	if h == nil {
+		return ri
+	}
+	if h.Timestamp != 0 {
+		ri.Start = time.Unix(h.Timestamp, 0)
+	}
+	switch {
+	case h.SrcNodeUser != "":
+		ri.From = h.SrcNodeUser
+	case h.SrcNode != "":
+		ri.From = h.SrcNode
+	default:
+		ri.From = string(h.SrcNodeID)
+	}
+	switch {
+	case h.Kubernetes != nil:
+		ri.Target = h.Kubernetes.Namespace + "/" + h.Kubernetes.PodName
+		if h.Kubernetes.Container != "" {
+			ri.Target += "/" + h.Kubernetes.Container
+		}
+	case h.SSHUser != "":
+		ri.Target = h.SSHUser + "@" + h.LocalUser
+	default:
+		ri.Target = h.LocalUser
+	}
+	ri.Command = h.Command
+	return ri
+}
+
+func (s *Server) registerUI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", s.authorizeUI(s.serveList))
+	mux.HandleFunc("GET /style.css", s.authorizeUI(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		io.WriteString(w, styleCSS)
+	}))
+	mux.HandleFunc("GET /api/recordings", s.authorizeUI(s.serveAPIList))
+	mux.HandleFunc("GET /recordings/{name}", s.authorizeUI(s.serveRecording))
+	mux.HandleFunc("GET /play/{name}", s.authorizeUI(s.servePlay))
+}
+
+// authorizeUI returns a handler that serves UI requests with h if
+// s.AuthorizeUI permits them.
+func (s *Server) authorizeUI(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.AuthorizeUI(r); err != nil {
+			s.logf("recorder: denied UI request %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// recordingInfos returns up to maxUIRecordings of the most recent
+// recordings, and whether there were more.
+func (s *Server) recordingInfos(r *http.Request) (_ []recordingInfo, truncated bool, _ error) {
+	recs, err := s.Storage.List(r.Context())
+	if err != nil {
+		return nil, false, err
+	}
+	sortRecordings(recs)
+	if len(recs) > maxUIRecordings {
+		recs, truncated = recs[:maxUIRecordings], true
+	}
+	infos := make([]recordingInfo, 0, len(recs))
+	for _, rec := range recs {
+		h, err := readHeader(r.Context(), s.Storage, rec.Name)
+		if err != nil {
+			// Possibly still being written; list it without details.
+			h = nil
+		}
+		infos = append(infos, newRecordingInfo(rec, h))
+	}
+	return infos, truncated, nil
+}
+
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request) {
+	infos, truncated, err := s.recordingInfos(r)
+	if err != nil {
+		s.logf("recorder: listing recordings: %v", err)
+		http.Error(w, "failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Recordings []recordingInfo
+		Truncated  bool
+	}{infos, truncated}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := listTmpl.Execute(w, data); err != nil {
+		s.logf("recorder: rendering recording list: %v", err)
+	}
+}
+
+func (s *Server) serveAPIList(w http.ResponseWriter, r *http.Request) {
+	infos, _, err := s.recordingInfos(r)
+	if err != nil {
+		s.logf("recorder: listing recordings: %v", err)
+		http.Error(w, "failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+func (s *Server) serveRecording(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	rc, err := s.Storage.Open(r.Context(), name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.logf("recorder: opening recording %q: %v", name, err)
+		http.Error(w, "failed to open recording", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	io.Copy(w, rc)
+}
+
+func (s *Server) servePlay(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	h, err := readHeader(r.Context(), s.Storage, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.logf("recorder: reading recording %q: %v", name, err)
+		http.Error(w, "failed to read recording", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := playTmpl.Execute(w, newRecordingInfo(Recording{Name: name}, h)); err != nil {
+		s.logf("recorder: rendering player: %v", err)
+	}
+}
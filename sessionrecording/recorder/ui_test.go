@@ -0,0 +1,120 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/sessionrecording"
+)
+
+func TestUI(t *testing.T) {
+	st := &DirStorage{Dir: t.TempDir()}
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	sshRec := newRecordingName(start)
+	k8sRec := newRecordingName(start.Add(time.Minute))
+	for name, h := range map[string]sessionrecording.CastHeader{
+		sshRec: {
+			Version:     2,
+			Timestamp:   start.Unix(),
+			Command:     "uptime",
+			SrcNode:     "laptop.tail-scale.ts.net",
+			SrcNodeUser: "alice@example.com",
+			SSHUser:     "alice",
+			LocalUser:   "root",
+		},
+		k8sRec: {
+			Version:   2,
+			Timestamp: start.Add(time.Minute).Unix(),
+			SrcNode:   "ci.tail-scale.ts.net",
+			Kubernetes: &sessionrecording.Kubernetes{
+				PodName:   "web-0",
+				Namespace: "prod",
+			},
+		},
+	} {
+		wc, err := st.Create(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewEncoder(wc).Encode(h)
+		io.WriteString(wc, `[0.5,"o","\u001b[1mhi\u001b[0m\r\n"]`+"\n")
+		wc.Close()
+	}
+	var denyUI error
+	s := &Server{Storage: st, Logf: t.Logf, AuthorizeUI: func(*http.Request) error { return denyUI }}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, body := get("/")
+	if code != http.StatusOK {
+		t.Fatalf("GET / = %d", code)
+	}
+	for _, want := range []string{
+		"alice@example.com", "alice@root", "uptime", "prod/web-0",
+		"/play/" + sshRec, "/recordings/" + k8sRec,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET / doesn't contain %q", want)
+		}
+	}
+
+	code, body = get("/api/recordings")
+	if code != http.StatusOK {
+		t.Fatalf("GET /api/recordings = %d", code)
+	}
+	var infos []recordingInfo
+	if err := json.Unmarshal([]byte(body), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d recordings; want 2", len(infos))
+	}
+	if infos[0].From != "ci.tail-scale.ts.net" || infos[0].Target != "prod/web-0" {
+		t.Errorf("first recording = %+v; want the Kubernetes one", infos[0])
+	}
+	if !infos[1].Start.Equal(start) || infos[1].Command != "uptime" {
+		t.Errorf("second recording = %+v; want the SSH one started at %v", infos[1], start)
+	}
+
+	code, body = get("/recordings/" + sshRec)
+	if code != http.StatusOK || !strings.Contains(body, `"command":"uptime"`) {
+		t.Errorf("GET /recordings/%s = %d, %q", sshRec, code, body)
+	}
+	code, body = get("/play/" + sshRec)
+	if code != http.StatusOK || !strings.Contains(body, "alice@root") {
+		t.Errorf("GET /play/%s = %d, %q", sshRec, code, body)
+	}
+
+	for _, path := range []string{"/recordings/missing.cast", "/recordings/..%2f..%2fetc%2fpasswd", "/play/missing.cast"} {
+		if code, _ := get(path); code != http.StatusNotFound {
+			t.Errorf("GET %s = %d; want 404", path, code)
+		}
+	}
+
+	denyUI = errors.New("not allowed")
+	for _, path := range []string{"/", "/api/recordings", "/recordings/" + sshRec, "/play/" + sshRec} {
+		if code, _ := get(path); code != http.StatusForbidden {
+			t.Errorf("GET %s when denied = %d; want 403", path, code)
+		}
+	}
+
+	s = &Server{Storage: st, Logf: t.Logf}
+	if code, _ := get("/"); code != http.StatusNotFound {
+		t.Errorf("GET / with UI disabled = %d; want 404", code)
+	}
+}
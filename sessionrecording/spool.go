@@ -0,0 +1,286 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"tailscale.com/net/netx"
+	"tailscale.com/util/set"
+)
+
+// spool holds the data of a resumable upload that the recorder hasn't
+// acknowledged yet. Offsets are relative to the first unacknowledged byte.
+type spool interface {
+	// Len returns the number of bytes in the spool.
+	Len() int
+	// Append adds p to the end of the spool.
+	Append(p []byte) error
+	// ReadAt fills p with the data at offset off.
+	ReadAt(p []byte, off int) error
+	// Discard drops the first n bytes of the spool.
+	Discard(n int)
+	// Close releases the spool's resources. If remove is true, any data
+	// kept on disk is removed as well.
+	Close(remove bool)
+}
+
+// memSpool is a spool kept in memory.
+type memSpool struct {
+	buf []byte
+}
+
+func (s *memSpool) Len() int { return len(s.buf) }
+
+func (s *memSpool) Append(p []byte) error {
+	s.buf = append(s.buf, p...)
+	return nil
+}
+
+func (s *memSpool) ReadAt(p []byte, off int) error {
+	copy(p, s.buf[off:])
+	return nil
+}
+
+func (s *memSpool) Discard(n int) {
+	s.buf = s.buf[:copy(s.buf, s.buf[n:])]
+}
+
+func (s *memSpool) Close(bool) { s.buf = nil }
+
+const (
+	// spoolExt is the file extension of spool files.
+	spoolExt = ".spool"
+	// spoolMetaExt is the file extension of the metadata file that goes
+	// with each spool file.
+	spoolMetaExt = ".json"
+	// spoolHeaderLen is the length of the header of spool files, which
+	// holds the total number of bytes ever written to the spool.
+	spoolHeaderLen = 8
+)
+
+// spoolMeta is the content of the metadata file of a file spool.
+type spoolMeta struct {
+	Recorder netip.AddrPort // recorder the recording is uploaded to
+	ID       string         // value of HeaderRecordingID
+	Size     int            // capacity of the spool, in bytes
+}
+
+// fileSpool is a spool kept in a file, so that an upload can be resumed by
+// [ResumeSpooled] if the process exits before it completes.
+//
+// The file is a ring buffer of meta.Size bytes after a header, so it never
+// grows beyond that, however long the recording.
+type fileSpool struct {
+	dir  string
+	meta spoolMeta
+	f    *os.File
+	head int64 // offset in the recording of the first byte in the spool
+	n    int   // number of bytes in the spool
+}
+
+// activeSpools is the set of IDs of file spools in use by uploads in this
+// process, which ResumeSpooled must leave alone.
+var activeSpools struct {
+	sync.Mutex
+	ids set.Set[string]
+}
+
+func markSpoolActive(id string, active bool) {
+	activeSpools.Lock()
+	defer activeSpools.Unlock()
+	if active {
+		activeSpools.ids.Make()
+		activeSpools.ids.Add(id)
+	} else {
+		activeSpools.ids.Delete(id)
+	}
+}
+
+func spoolActive(id string) bool {
+	activeSpools.Lock()
+	defer activeSpools.Unlock()
+	return activeSpools.ids.Contains(id)
+}
+
+// newFileSpool creates a file spool of size bytes in dir for the upload with
+// the given ID to the recorder at ap.
+func newFileSpool(dir string, ap netip.AddrPort, id string, size int) (*fileSpool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &fileSpool{dir: dir, meta: spoolMeta{Recorder: ap, ID: id, Size: size}}
+	mj, err := json.Marshal(s.meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.path(spoolMetaExt), mj, 0600); err != nil {
+		return nil, err
+	}
+	s.f, err = os.OpenFile(s.path(spoolExt), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		os.Remove(s.path(spoolMetaExt))
+		return nil, err
+	}
+	if err := s.writeHeader(); err != nil {
+		s.Close(true)
+		return nil, err
+	}
+	markSpoolActive(id, true)
+	return s, nil
+}
+
+// openFileSpool opens the file spool with the given metadata file, as left
+// behind by a previous process. The spool holds as much of the end of the
+// recording as fits.
+func openFileSpool(metaPath string) (*fileSpool, error) {
+	mj, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileSpool{dir: filepath.Dir(metaPath)}
+	if err := json.Unmarshal(mj, &s.meta); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", metaPath, err)
+	}
+	if s.meta.ID == "" || s.meta.Size <= 0 || filepath.Base(s.path(spoolMetaExt)) != filepath.Base(metaPath) {
+		return nil, fmt.Errorf("invalid spool metadata in %s", metaPath)
+	}
+	s.f, err = os.OpenFile(s.path(spoolExt), os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [spoolHeaderLen]byte
+	if _, err := s.f.ReadAt(hdr[:], 0); err != nil {
+		s.f.Close()
+		return nil, fmt.Errorf("reading spool header: %w", err)
+	}
+	written := int64(binary.BigEndian.Uint64(hdr[:]))
+	s.head = max(0, written-int64(s.meta.Size))
+	s.n = int(written - s.head)
+	markSpoolActive(s.meta.ID, true)
+	return s, nil
+}
+
+func (s *fileSpool) path(ext string) string {
+	return filepath.Join(s.dir, s.meta.ID+ext)
+}
+
+func (s *fileSpool) writeHeader() error {
+	var hdr [spoolHeaderLen]byte
+	binary.BigEndian.PutUint64(hdr[:], uint64(s.head)+uint64(s.n))
+	_, err := s.f.WriteAt(hdr[:], 0)
+	return err
+}
+
+func (s *fileSpool) Len() int { return s.n }
+
+// ringIO calls fn for the one or two contiguous parts of the ring buffer that
+// hold the n bytes at offset off in the recording.
+func (s *fileSpool) ringIO(off int64, n int, fn func(fileOff int64, lo, hi int) error) error {
+	size := int64(s.meta.Size)
+	pos := off % size
+	first := min(int64(n), size-pos)
+	if err := fn(spoolHeaderLen+pos, 0, int(first)); err != nil {
+		return err
+	}
+	if int(first) < n {
+		return fn(spoolHeaderLen, int(first), n)
+	}
+	return nil
+}
+
+func (s *fileSpool) Append(p []byte) error {
+	if s.n+len(p) > s.meta.Size {
+		return errors.New("recording: spool overflow")
+	}
+	err := s.ringIO(s.head+int64(s.n), len(p), func(fileOff int64, lo, hi int) error {
+		_, err := s.f.WriteAt(p[lo:hi], fileOff)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("recording: writing spool: %w", err)
+	}
+	s.n += len(p)
+	return s.writeHeader()
+}
+
+func (s *fileSpool) ReadAt(p []byte, off int) error {
+	return s.ringIO(s.head+int64(off), len(p), func(fileOff int64, lo, hi int) error {
+		_, err := s.f.ReadAt(p[lo:hi], fileOff)
+		return err
+	})
+}
+
+func (s *fileSpool) Discard(n int) {
+	s.head += int64(n)
+	s.n -= n
+}
+
+func (s *fileSpool) Close(remove bool) {
+	s.f.Close()
+	if remove {
+		os.Remove(s.path(spoolExt))
+		os.Remove(s.path(spoolMetaExt))
+	}
+	markSpoolActive(s.meta.ID, false)
+}
+
+// ResumeSpooled resumes the uploads of recordings that were spooled to dir
+// (see ConnectOptions.SpoolDir) by a previous process that exited before the
+// recorder received all of their data. The recordings end where the previous
+// process stopped writing them.
+//
+// It blocks until all of the uploads completed or failed. Spools of uploads
+// that completed, or that the recorder can no longer resume, are removed;
+// the others are kept to be retried by a later call.
+func ResumeSpooled(ctx context.Context, dir string, dial netx.DialFunc, opts ConnectOptions) {
+	metas, err := filepath.Glob(filepath.Join(dir, "*"+spoolMetaExt))
+	if err != nil {
+		opts.logf("recording: listing spools: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, mp := range metas {
+		if spoolActive(strings.TrimSuffix(filepath.Base(mp), spoolMetaExt)) {
+			continue
+		}
+		s, err := openFileSpool(mp)
+		if err != nil {
+			opts.logf("recording: dropping unusable spool %s: %v", mp, err)
+			os.Remove(mp)
+			os.Remove(strings.TrimSuffix(mp, spoolMetaExt) + spoolExt)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := opts
+			opts.SpoolSize = s.meta.Size
+			u := newResumableUpload(ctx, dial, s.meta.Recorder, s.meta.ID, s, opts)
+			u.acked = s.head
+			u.closed = true // nothing more will be written
+			opts.logf("recording: resuming spooled upload of %d bytes to %v", s.Len(), s.meta.Recorder)
+			us, err := u.reconnect()
+			if err != nil {
+				opts.logf("recording: could not resume spooled upload to %v: %v", s.meta.Recorder, err)
+				s.Close(errors.Is(err, errCannotResume))
+				return
+			}
+			go u.run(us)
+			if err := <-u.errc; err != nil {
+				opts.logf("recording: spooled upload to %v failed: %v", s.meta.Recorder, err)
+			}
+		}()
+	}
+}
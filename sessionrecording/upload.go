@@ -0,0 +1,600 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/net/netx"
+	"tailscale.com/types/logger"
+)
+
+// Headers used by recorders that support resuming interrupted uploads to the
+// /v2/record endpoint.
+const (
+	// HeaderResumable is set to "true" by recorders in their response to
+	// the HEAD /v2/record probe if they support resuming uploads.
+	HeaderResumable = "Tailscale-Recording-Resumable"
+
+	// HeaderRecordingID is sent by the client with each POST to
+	// /v2/record. It is a random identifier of the recording, and is the
+	// same for all requests that upload parts of the same recording.
+	//
+	// When it is set, the acks sent by the recorder are the total number of
+	// bytes of the recording received, including those received in earlier
+	// requests for the same recording.
+	HeaderRecordingID = "Tailscale-Recording-Id"
+
+	// HeaderRecordingOffset is sent by the client in a request that resumes
+	// an upload, and is the number of bytes of the recording that the
+	// recorder acknowledged before the upload was interrupted.
+	//
+	// The recorder must include it in its response to such a request, set
+	// to the number of bytes of the recording that it has received. The
+	// client continues the upload from that offset.
+	HeaderRecordingOffset = "Tailscale-Recording-Offset"
+)
+
+const (
+	// defaultResumeTimeout is the default for ConnectOptions.ResumeTimeout.
+	defaultResumeTimeout = time.Minute
+	// maxResumeBackoff is the longest time to wait between attempts to resume
+	// an upload.
+	maxResumeBackoff = 5 * time.Second
+	// maxUploadChunk is the largest amount of data written to the recorder
+	// connection at once by a resumable upload.
+	maxUploadChunk = 32 << 10
+	// defaultSpoolMB is the default spool size, in MiB, used by
+	// ConnectOptionsFromEnv.
+	defaultSpoolMB = 16
+)
+
+var (
+	// recordingSpoolMB is the size, in MiB, of the spool of resumable
+	// uploads (see ConnectOptions.SpoolSize). If unset or zero,
+	// defaultSpoolMB is used. If negative, uploads are not resumable.
+	recordingSpoolMB = envknob.RegisterInt("TS_SESSION_RECORDING_SPOOL_MB")
+	// recordingResumeTimeout is how long to try to resume an interrupted
+	// upload (see ConnectOptions.ResumeTimeout).
+	recordingResumeTimeout = envknob.RegisterDuration("TS_SESSION_RECORDING_RESUME_TIMEOUT")
+	// recordingFanOut enables uploading recordings to all recorders (see
+	// ConnectOptions.FanOut).
+	recordingFanOut         = envknob.RegisterBool("TS_SESSION_RECORDING_FAN_OUT")
+	errUploadClosed         = errors.New("recording: write to closed upload")
+	errRecorderClosedUpload = errors.New("recording: recorder ended the upload early")
+)
+
+// ConnectOptions are options for [Connect].
+type ConnectOptions struct {
+	// FanOut specifies whether to upload the recording to all of the
+	// recorders that accept it, instead of only the first one.
+	//
+	// With FanOut, the recording continues as long as at least one of the
+	// uploads is healthy, and the upload is only reported as failed once
+	// all of them failed, unless RequireAll is set.
+	FanOut bool
+
+	// RequireAll, with FanOut, specifies that the upload fails as soon as
+	// the upload to any one of the recorders that accepted the recording
+	// fails. Callers that terminate sessions when recording fails should
+	// set it, so that losing one recorder isn't silently tolerated.
+	RequireAll bool
+
+	// SpoolSize, if non-zero, is the maximum number of bytes of recording
+	// data that are buffered until acknowledged by each recorder. It enables
+	// resuming uploads to recorders that support it, if the connection to
+	// the recorder is interrupted.
+	//
+	// Once the buffer is full, writes to the upload block until the
+	// recorder acknowledges more data or the upload fails.
+	//
+	// The buffer is kept in memory unless SpoolDir is set.
+	SpoolSize int
+
+	// SpoolDir, if non-empty, is the directory in which the buffer of
+	// resumable uploads is kept, instead of in memory. Uploads that didn't
+	// complete before the process exited can then be resumed with
+	// [ResumeSpooled].
+	SpoolDir string
+
+	// ResumeTimeout is how long to keep trying to resume an interrupted
+	// upload before giving up. If zero, a default of one minute is used.
+	ResumeTimeout time.Duration
+
+	// Logf, if non-nil, is used to log interruptions and resumptions of
+	// uploads.
+	Logf logger.Logf
+}
+
+// ConnectOptionsFromEnv returns the ConnectOptions configured with the
+// following environment variables:
+//
+//   - TS_SESSION_RECORDING_SPOOL_MB sets SpoolSize, in MiB. Uploads to
+//     recorders that support it are resumable by default, with a 16 MiB
+//     spool; a negative value disables resumption.
+//   - TS_SESSION_RECORDING_RESUME_TIMEOUT sets ResumeTimeout.
+//   - TS_SESSION_RECORDING_FAN_OUT sets FanOut.
+//
+// SpoolDir is left empty; callers with a state directory should set it.
+func ConnectOptionsFromEnv(logf logger.Logf) ConnectOptions {
+	spoolMB := recordingSpoolMB()
+	if spoolMB == 0 {
+		spoolMB = defaultSpoolMB
+	}
+	return ConnectOptions{
+		FanOut:        recordingFanOut(),
+		SpoolSize:     max(spoolMB, 0) << 20,
+		ResumeTimeout: recordingResumeTimeout(),
+		Logf:          logf,
+	}
+}
+
+func (o ConnectOptions) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+func (o ConnectOptions) resumeTimeout() time.Duration {
+	if o.ResumeTimeout > 0 {
+		return o.ResumeTimeout
+	}
+	return defaultResumeTimeout
+}
+
+// resumableUpload is an upload of a recording to a recorder that supports
+// resuming interrupted uploads. Data written to it is kept in a spool until
+// acknowledged by the recorder, so that it can be sent again on a new
+// connection.
+type resumableUpload struct {
+	ctx  context.Context
+	dial netx.DialFunc
+	ap   netip.AddrPort
+	id   string
+	opts ConnectOptions
+	errc chan error
+
+	mu     sync.Mutex
+	cond   sync.Cond
+	sp     spool // data not yet acked, starting at offset acked
+	acked  int64 // number of bytes acked by the recorder
+	closed bool  // whether Close was called
+	err    error // if non-nil, the upload failed permanently
+}
+
+// connectResumable starts a resumable upload to the recorder at ap, using hc
+// for the first connection.
+func connectResumable(ctx context.Context, hc *http.Client, dial netx.DialFunc, ap netip.AddrPort, opts ConnectOptions) (io.WriteCloser, <-chan error, error) {
+	idb := make([]byte, 16)
+	rand.Read(idb)
+	id := hex.EncodeToString(idb)
+	var sp spool = new(memSpool)
+	if opts.SpoolDir != "" {
+		fs, err := newFileSpool(opts.SpoolDir, ap, id, opts.SpoolSize)
+		if err != nil {
+			opts.logf("recording: spooling upload to %v in memory: %v", ap, err)
+		} else {
+			sp = fs
+		}
+	}
+	u := newResumableUpload(ctx, dial, ap, id, sp, opts)
+	s, err := u.open(hc, false)
+	if err != nil {
+		sp.Close(true)
+		return nil, nil, err
+	}
+	go u.run(s)
+	return u, u.errc, nil
+}
+
+func newResumableUpload(ctx context.Context, dial netx.DialFunc, ap netip.AddrPort, id string, sp spool, opts ConnectOptions) *resumableUpload {
+	u := &resumableUpload{
+		ctx:  ctx,
+		dial: dial,
+		ap:   ap,
+		id:   id,
+		sp:   sp,
+		opts: opts,
+		errc: make(chan error, 1),
+	}
+	u.cond.L = &u.mu
+	return u
+}
+
+// Write implements io.Writer. It blocks while the spool buffer is full.
+func (u *resumableUpload) Write(p []byte) (n int, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for len(p) > 0 {
+		for u.err == nil && !u.closed && u.sp.Len() >= u.opts.SpoolSize {
+			u.cond.Wait()
+		}
+		if u.err != nil {
+			return n, u.err
+		}
+		if u.closed {
+			return n, errUploadClosed
+		}
+		m := min(len(p), u.opts.SpoolSize-u.sp.Len())
+		if err := u.sp.Append(p[:m]); err != nil {
+			u.err = err
+			u.cond.Broadcast()
+			return n, err
+		}
+		p = p[m:]
+		n += m
+		u.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Close implements io.Closer. It returns immediately; the result of the upload
+// is sent to the error channel returned by connectResumable once all data was
+// acknowledged by the recorder.
+func (u *resumableUpload) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	u.cond.Broadcast()
+	return nil
+}
+
+// run uploads the recording over s, and over new connections to the recorder
+// when the upload is interrupted, until it completes or fails permanently.
+//
+// The spool is removed once the upload completes or can't be resumed anymore.
+// If the recorder is merely unreachable, a file spool is kept so that
+// ResumeSpooled can complete the upload later.
+func (u *resumableUpload) run(s *uploadStream) {
+	defer close(u.errc)
+	for {
+		err := u.stream(s)
+		if err == nil {
+			u.closeSpool(true)
+			u.errc <- nil
+			return
+		}
+		if spoolErr := u.failure(); spoolErr != nil {
+			u.closeSpool(true)
+			u.errc <- spoolErr
+			return
+		}
+		u.opts.logf("recording: upload to %v interrupted at offset %d: %v", u.ap, u.ackedOffset(), err)
+		s, err = u.reconnect()
+		if err != nil {
+			err = fmt.Errorf("recording: could not resume upload to %v: %w", u.ap, err)
+			u.mu.Lock()
+			u.err = err
+			u.cond.Broadcast()
+			u.mu.Unlock()
+			u.closeSpool(errors.Is(err, errCannotResume))
+			u.errc <- err
+			return
+		}
+		u.opts.logf("recording: resumed upload to %v at offset %d", u.ap, s.start)
+	}
+}
+
+// failure returns the error that made the upload fail permanently, if any.
+func (u *resumableUpload) failure() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+func (u *resumableUpload) closeSpool(remove bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sp.Close(remove)
+}
+
+func (u *resumableUpload) ackedOffset() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.acked
+}
+
+// reconnect tries to resume the upload on a new connection to the recorder,
+// with exponential backoff, until ConnectOptions.ResumeTimeout elapses.
+func (u *resumableUpload) reconnect() (*uploadStream, error) {
+	ctx, cancel := context.WithTimeout(u.ctx, u.opts.resumeTimeout())
+	defer cancel()
+	backoff := 250 * time.Millisecond
+	for {
+		dialCtx, dialCancel := context.WithTimeout(ctx, allDialAttemptsTimeout)
+		s, err := u.open(clientHTTP2(dialCtx, u.dial), true)
+		dialCancel()
+		if err == nil {
+			return s, nil
+		}
+		if errors.Is(err, errCannotResume) {
+			return nil, err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-t.C:
+		}
+		backoff = min(2*backoff, maxResumeBackoff)
+	}
+}
+
+var errCannotResume = errors.New("recorder cannot resume the upload")
+
+// uploadStream is a single POST request to /v2/record that uploads the part
+// of a recording starting at offset start.
+type uploadStream struct {
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	resp  *http.Response
+	start int64
+}
+
+// open sends a new request to the recorder to upload the recording, resuming
+// it from the last acknowledged offset if resume is true.
+func (u *resumableUpload) open(hc *http.Client, resume bool) (*uploadStream, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(u.ctx, "POST", fmt.Sprintf("http://%s/v2/record", u.ap), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HeaderRecordingID, u.id)
+	if resume {
+		req.Header.Set(HeaderRecordingOffset, strconv.FormatInt(u.ackedOffset(), 10))
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("recording: unexpected status: %v", resp.Status)
+	}
+	s := &uploadStream{pr: pr, pw: pw, resp: resp}
+	if !resume {
+		return s, nil
+	}
+	fail := func(err error) (*uploadStream, error) {
+		pr.CloseWithError(err)
+		resp.Body.Close()
+		return nil, err
+	}
+	s.start, err = strconv.ParseInt(resp.Header.Get(HeaderRecordingOffset), 10, 64)
+	if err != nil {
+		return fail(fmt.Errorf("%w: invalid %s in response: %v", errCannotResume, HeaderRecordingOffset, err))
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if end := u.acked + int64(u.sp.Len()); s.start < u.acked || s.start > end {
+		return fail(fmt.Errorf("%w: recorder has %d bytes, client has bytes %d-%d", errCannotResume, s.start, u.acked, end))
+	}
+	u.ackLocked(s.start)
+	return s, nil
+}
+
+// ackLocked records that the recorder received the first n bytes of the
+// recording, and drops them from the spool.
+//
+// u.mu must be held.
+func (u *resumableUpload) ackLocked(n int64) {
+	if n <= u.acked {
+		return
+	}
+	n = min(n, u.acked+int64(u.sp.Len()))
+	u.sp.Discard(int(n - u.acked))
+	u.acked = n
+	u.cond.Broadcast()
+}
+
+// stream uploads the recording over s until the upload completes, in which
+// case it returns nil, or the connection fails.
+func (u *resumableUpload) stream(s *uploadStream) error {
+	ctx, cancel := context.WithCancel(u.ctx)
+	defer func() {
+		cancel()
+		s.pr.CloseWithError(errRecorderClosedUpload)
+		s.resp.Body.Close()
+		u.mu.Lock()
+		u.cond.Broadcast() // wake up pump
+		u.mu.Unlock()
+	}()
+	go u.pump(ctx, s)
+
+	// Like connectV2, terminate the connection if data isn't acked for
+	// uploadAckWindow. The recorder sends acks even when idle.
+	var timedOut bool
+	var timedOutMu sync.Mutex
+	timer := time.AfterFunc(uploadAckWindow, func() {
+		timedOutMu.Lock()
+		timedOut = true
+		timedOutMu.Unlock()
+		s.pr.CloseWithError(errNoAcks)
+		s.resp.Body.Close()
+	})
+	defer timer.Stop()
+
+	dec := json.NewDecoder(s.resp.Body)
+	for {
+		var frame v2ResponseFrame
+		if err := dec.Decode(&frame); err != nil {
+			timedOutMu.Lock()
+			defer timedOutMu.Unlock()
+			if timedOut {
+				return errNoAcks
+			}
+			if errors.Is(err, io.EOF) {
+				if u.finished() {
+					return nil
+				}
+				return errRecorderClosedUpload
+			}
+			return err
+		}
+		if frame.Error != "" {
+			return fmt.Errorf("received error from the recorder: %q", frame.Error)
+		}
+		timer.Reset(uploadAckWindow)
+		u.mu.Lock()
+		u.ackLocked(frame.Ack)
+		u.mu.Unlock()
+	}
+}
+
+// finished reports whether the upload was closed and all of its data was
+// acknowledged by the recorder.
+func (u *resumableUpload) finished() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.closed && u.sp.Len() == 0
+}
+
+// pump copies spooled data to the request body of s, starting at s.start,
+// until ctx is done or all data was sent after the upload was closed.
+//
+// If the spool can't be read, the upload fails.
+func (u *resumableUpload) pump(ctx context.Context, s *uploadStream) {
+	off := s.start
+	for {
+		u.mu.Lock()
+		for ctx.Err() == nil && !u.closed && off >= u.acked+int64(u.sp.Len()) {
+			u.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			u.mu.Unlock()
+			return
+		}
+		off = max(off, u.acked)
+		end := u.acked + int64(u.sp.Len())
+		if off >= end {
+			// Closed, and everything was sent.
+			u.mu.Unlock()
+			s.pw.Close()
+			return
+		}
+		chunk := make([]byte, min(end-off, maxUploadChunk))
+		if err := u.sp.ReadAt(chunk, int(off-u.acked)); err != nil {
+			u.err = fmt.Errorf("recording: reading spool: %w", err)
+			u.cond.Broadcast()
+			u.mu.Unlock()
+			s.pw.CloseWithError(err)
+			return
+		}
+		u.mu.Unlock()
+
+		if _, err := s.pw.Write(chunk); err != nil {
+			return
+		}
+		off += int64(len(chunk))
+	}
+}
+
+// upload is a started upload of a recording to a single recorder.
+type upload struct {
+	ap   netip.AddrPort
+	w    io.WriteCloser
+	errc <-chan error
+}
+
+// fanOutWriter writes a recording to several uploads. Uploads that fail are
+// dropped, and writes only fail once all uploads failed, or once any upload
+// failed if requireAll is set.
+type fanOutWriter struct {
+	logf       func(format string, args ...any)
+	requireAll bool
+
+	mu      sync.Mutex
+	uploads []upload // uploads that haven't failed yet
+}
+
+// fanOut returns a WriteCloser that writes to all of uploads, and an error
+// channel that is sent nil once all uploads completed, if at least one of them
+// succeeded, or an error if all of them failed.
+//
+// If requireAll is true, an error is instead sent as soon as any of the
+// uploads fails.
+func fanOut(uploads []upload, requireAll bool, logf func(format string, args ...any)) (io.WriteCloser, <-chan error) {
+	w := &fanOutWriter{uploads: slices.Clone(uploads), requireAll: requireAll, logf: logf}
+	results := make(chan error, len(uploads))
+	for _, up := range uploads {
+		go func() {
+			err := <-up.errc
+			if err != nil {
+				logf("recording: upload to %v failed: %v", up.ap, err)
+			}
+			results <- err
+		}()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		var errs []error
+		for range uploads {
+			if err := <-results; err != nil {
+				if requireAll {
+					errc <- err
+					return
+				}
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == len(uploads) {
+			errc <- errors.Join(errs...)
+		} else {
+			errc <- nil
+		}
+	}()
+	return w, errc
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	live := w.uploads[:0]
+	for _, up := range w.uploads {
+		if _, err := up.w.Write(p); err != nil {
+			w.logf("recording: dropping upload to %v: %v", up.ap, err)
+			up.w.Close()
+			errs = append(errs, err)
+			continue
+		}
+		live = append(live, up)
+	}
+	w.uploads = live
+	if len(live) == 0 {
+		return 0, fmt.Errorf("recording: all uploads failed: %w", errors.Join(errs...))
+	}
+	if len(errs) > 0 && w.requireAll {
+		return 0, fmt.Errorf("recording: upload failed: %w", errors.Join(errs...))
+	}
+	return len(p), nil
+}
+
+func (w *fanOutWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for _, up := range w.uploads {
+		if err := up.w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	w.uploads = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,394 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package sessionrecording
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// resumableRecorder is a fake recorder that supports resuming uploads.
+type resumableRecorder struct {
+	// abortAfter, if non-zero, is the number of bytes of the first upload
+	// request after which the request is aborted.
+	abortAfter int
+
+	mu      sync.Mutex
+	data    map[string]*bytes.Buffer // by recording ID
+	reqs    int
+	resumes int
+}
+
+func (rr *resumableRecorder) start(t *testing.T) netip.AddrPort {
+	rr.data = make(map[string]*bytes.Buffer)
+	mux := http.NewServeMux()
+	mux.HandleFunc("HEAD /v2/record", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderResumable, "true")
+	})
+	mux.HandleFunc("POST /v2/record", rr.serveRecord)
+	srv := httptest.NewUnstartedServer(mux)
+	h2s := &http2.Server{}
+	srv.Config.Handler = h2c.NewHandler(mux, h2s)
+	if err := http2.ConfigureServer(srv.Config, h2s); err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return netip.MustParseAddrPort(srv.Listener.Addr().String())
+}
+
+func (rr *resumableRecorder) recording() []byte {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for _, b := range rr.data {
+		return b.Bytes()
+	}
+	return nil
+}
+
+func (rr *resumableRecorder) serveRecord(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(HeaderRecordingID)
+	rr.mu.Lock()
+	rr.reqs++
+	first := rr.reqs == 1
+	buf := rr.data[id]
+	if r.Header.Get(HeaderRecordingOffset) != "" {
+		rr.resumes++
+		if buf == nil {
+			rr.mu.Unlock()
+			http.Error(w, "unknown recording", http.StatusNotFound)
+			return
+		}
+		w.Header().Set(HeaderRecordingOffset, strconv.Itoa(buf.Len()))
+	} else {
+		buf = new(bytes.Buffer)
+		rr.data[id] = buf
+	}
+	rr.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		b := make([]byte, 4096)
+		var n int
+		for {
+			m, err := r.Body.Read(b)
+			rr.mu.Lock()
+			buf.Write(b[:m])
+			rr.mu.Unlock()
+			n += m
+			if err != nil {
+				return
+			}
+			if first && rr.abortAfter > 0 && n >= rr.abortAfter {
+				return
+			}
+		}
+	}()
+
+	enc := json.NewEncoder(w)
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if first && rr.abortAfter > 0 {
+				panic(http.ErrAbortHandler)
+			}
+			rr.mu.Lock()
+			ack := int64(buf.Len())
+			rr.mu.Unlock()
+			enc.Encode(v2ResponseFrame{Ack: ack})
+			return
+		case <-tick.C:
+			rr.mu.Lock()
+			ack := int64(buf.Len())
+			rr.mu.Unlock()
+			if err := enc.Encode(v2ResponseFrame{Ack: ack}); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+func writeRandom(t *testing.T, w io.WriteCloser, n int64) []byte {
+	t.Helper()
+	var sent bytes.Buffer
+	if _, err := io.CopyN(io.MultiWriter(w, &sent), rand.Reader, n); err != nil {
+		t.Fatalf("writing recording data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("closing recording stream: %v", err)
+	}
+	return sent.Bytes()
+}
+
+func TestResumableUpload(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testResumableUpload(t, "")
+	})
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		testResumableUpload(t, dir)
+		if ents, err := os.ReadDir(dir); err != nil || len(ents) > 0 {
+			t.Errorf("spool directory has %d entries (err=%v); want spool removed after upload", len(ents), err)
+		}
+	})
+}
+
+func testResumableUpload(t *testing.T, spoolDir string) {
+	rr := &resumableRecorder{abortAfter: 256 << 10}
+	ap := rr.start(t)
+
+	opts := ConnectOptions{
+		SpoolSize: 64 << 10,
+		SpoolDir:  spoolDir,
+		Logf:      t.Logf,
+	}
+	d := new(net.Dialer)
+	w, _, errc, err := Connect(context.Background(), []netip.AddrPort{ap}, d.DialContext, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if _, ok := w.(*resumableUpload); !ok {
+		t.Fatalf("got upload of type %T; want *resumableUpload", w)
+	}
+	sent := writeRandom(t, w, 1<<20)
+	if err := <-errc; err != nil {
+		t.Fatalf("error from the channel: %v", err)
+	}
+	if got := rr.recording(); !bytes.Equal(got, sent) {
+		t.Errorf("recorded %d bytes, not equal to the %d bytes sent", len(got), len(sent))
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.resumes == 0 {
+		t.Errorf("upload was not resumed")
+	}
+}
+
+func TestResumableUploadTimeout(t *testing.T) {
+	rr := &resumableRecorder{abortAfter: 1}
+	ap := rr.start(t)
+
+	d := new(net.Dialer)
+	var dialed int
+	var mu sync.Mutex
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dialed++
+		if dialed > 1 {
+			// Recorder is unreachable after the first connection.
+			return nil, net.ErrClosed
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	opts := ConnectOptions{
+		SpoolSize:     1 << 20,
+		ResumeTimeout: 500 * time.Millisecond,
+	}
+	w, _, errc, err := Connect(context.Background(), []netip.AddrPort{ap}, dial, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("got nil error; want resume failure")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for upload to fail")
+	}
+	if _, err := io.WriteString(w, "world"); err == nil {
+		t.Error("Write after failure succeeded; want error")
+	}
+}
+
+func TestResumeSpooled(t *testing.T) {
+	rr := &resumableRecorder{abortAfter: 1}
+	ap := rr.start(t)
+	dir := t.TempDir()
+
+	// The recorder becomes unreachable after the first connection, and the
+	// upload is abandoned with its data left in the spool, as when the
+	// process exits.
+	d := new(net.Dialer)
+	var mu sync.Mutex
+	var dialed int
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dialed++
+		if dialed > 1 {
+			return nil, net.ErrClosed
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	opts := ConnectOptions{
+		SpoolSize:     1 << 20,
+		SpoolDir:      dir,
+		ResumeTimeout: 500 * time.Millisecond,
+		Logf:          t.Logf,
+	}
+	w, _, errc, err := Connect(context.Background(), []netip.AddrPort{ap}, dial, opts)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	sent := writeRandom(t, w, 100<<10)
+	if err := <-errc; err == nil {
+		t.Fatal("upload succeeded; want resume failure")
+	}
+	if got := rr.recording(); len(got) >= len(sent) {
+		t.Fatalf("recorder got %d of %d bytes before the failure; want fewer", len(got), len(sent))
+	}
+
+	ResumeSpooled(context.Background(), dir, d.DialContext, opts)
+	if got := rr.recording(); !bytes.Equal(got, sent) {
+		t.Errorf("recorded %d bytes, not equal to the %d bytes sent", len(got), len(sent))
+	}
+	if ents, err := os.ReadDir(dir); err != nil || len(ents) > 0 {
+		t.Errorf("spool directory has %d entries (err=%v); want spool removed after upload", len(ents), err)
+	}
+}
+
+func TestFileSpoolWraps(t *testing.T) {
+	s, err := newFileSpool(t.TempDir(), netip.MustParseAddrPort("127.0.0.1:80"), "id", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(true)
+	for _, chunk := range []string{"0123456", "789"} {
+		if err := s.Append([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append([]byte("x")); err == nil {
+		t.Error("Append to full spool succeeded; want error")
+	}
+	s.Discard(6)
+	if err := s.Append([]byte("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, s.Len())
+	if err := s.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "6789abcde" {
+		t.Errorf("spool has %q; want %q", got, "6789abcde")
+	}
+
+	// Reopening the spool yields the last Size bytes written.
+	re, err := openFileSpool(s.path(spoolMetaExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer re.Close(false)
+	got = make([]byte, re.Len())
+	if err := re.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "56789abcde" || re.head != 5 {
+		t.Errorf("reopened spool has %q at offset %d; want %q at 5", got, re.head, "56789abcde")
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	good1 := &resumableRecorder{}
+	good2 := &resumableRecorder{}
+	aps := []netip.AddrPort{good1.start(t), good2.start(t)}
+
+	d := new(net.Dialer)
+	w, attempts, errc, err := Connect(context.Background(), aps, d.DialContext, ConnectOptions{FanOut: true})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Errorf("got %d attempts; want 2", len(attempts))
+	}
+	sent := writeRandom(t, w, 256<<10)
+	if err := <-errc; err != nil {
+		t.Fatalf("error from the channel: %v", err)
+	}
+	for i, rr := range []*resumableRecorder{good1, good2} {
+		if got := rr.recording(); !bytes.Equal(got, sent) {
+			t.Errorf("recorder %d: recorded %d bytes, not equal to the %d bytes sent", i, len(got), len(sent))
+		}
+	}
+}
+
+func TestFanOutWriterDropsFailed(t *testing.T) {
+	failing := &failingWriteCloser{}
+	var good bytes.Buffer
+	goodErrc := make(chan error, 1)
+	failErrc := make(chan error, 1)
+	w, errc := fanOut([]upload{
+		{w: failing, errc: failErrc},
+		{w: nopCloser{&good}, errc: goodErrc},
+	}, false, t.Logf)
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	failErrc <- io.ErrUnexpectedEOF
+	goodErrc <- nil
+	if err := <-errc; err != nil {
+		t.Errorf("got error %v; want nil as one upload succeeded", err)
+	}
+	if good.String() != "hello" {
+		t.Errorf("got %q; want %q", good.String(), "hello")
+	}
+}
+
+func TestFanOutRequireAll(t *testing.T) {
+	goodErrc := make(chan error, 1)
+	failErrc := make(chan error, 1)
+	w, errc := fanOut([]upload{
+		{w: failingWriteCloser{}, errc: failErrc},
+		{w: nopCloser{io.Discard}, errc: goodErrc},
+	}, true, t.Logf)
+	if _, err := io.WriteString(w, "hello"); err == nil {
+		t.Error("Write succeeded after an upload failed; want error")
+	}
+
+	// The failure is reported without waiting for the other upload.
+	failErrc <- io.ErrUnexpectedEOF
+	if err := <-errc; !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+type failingWriteCloser struct{}
+
+func (failingWriteCloser) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+func (failingWriteCloser) Close() error              { return nil }
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
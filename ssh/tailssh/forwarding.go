@@ -0,0 +1,510 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// This file contains Unix domain socket forwarding (the OpenSSH
+// "streamlocal" extensions) and the `tailscaled be-child ssh-fwd` helper
+// process, which performs forwarding-related operations as the local user.
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+	"tailscale.com/cmd/tailscaled/childproc"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+)
+
+func init() {
+	childproc.Add("ssh-fwd", beForwardHelper)
+}
+
+const (
+	directStreamLocalChannelType    = "direct-streamlocal@openssh.com"
+	forwardedStreamLocalChannelType = "forwarded-streamlocal@openssh.com"
+	streamLocalForwardRequest       = "streamlocal-forward@openssh.com"
+	cancelStreamLocalForwardRequest = "cancel-streamlocal-forward@openssh.com"
+)
+
+// Forwarding helper operations; see beForwardHelper.
+const (
+	fwdOpDialUnix   = "dial-unix"
+	fwdOpListenUnix = "listen-unix"
+	fwdOpRemoveUnix = "remove-unix"
+	fwdOpXAuth      = "xauth"
+)
+
+// registerForwardingHandlers registers the channel and request handlers for
+// Unix domain socket forwarding on the conn's SSH server.
+func (c *conn) registerForwardingHandlers() {
+	h := &unixForwardHandler{c: c}
+	c.Server.ChannelHandlers[directStreamLocalChannelType] = c.handleDirectStreamLocal
+	c.Server.RequestHandlers[streamLocalForwardRequest] = h.HandleSSHRequest
+	c.Server.RequestHandlers[cancelStreamLocalForwardRequest] = h.HandleSSHRequest
+	c.Server.X11Callback = c.mayForwardX11
+}
+
+// mayForwardUnixSocket reports whether the connection is allowed to forward
+// Unix domain sockets.
+func (c *conn) mayForwardUnixSocket() bool {
+	if sshDisableForwarding() {
+		return false
+	}
+	return c.finalAction != nil && c.finalAction.AllowUnixSocketForwarding && c.localUser != nil
+}
+
+// handleDirectStreamLocal handles a "direct-streamlocal@openssh.com" channel,
+// connecting it to a Unix domain socket on this machine. This is what
+// `ssh -L localport:/path/to/socket` uses.
+func (c *conn) handleDirectStreamLocal(srv *ssh.Server, sconn *gossh.ServerConn, newChan gossh.NewChannel, ctx ssh.Context) {
+	var d struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+	if err := gossh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+		newChan.Reject(gossh.ConnectionFailed, "error parsing forward data: "+err.Error())
+		return
+	}
+	if !c.mayForwardUnixSocket() {
+		newChan.Reject(gossh.Prohibited, "unix socket forwarding is disabled")
+		return
+	}
+	metricLocalUnixSocketForward.Add(1)
+	f, err := c.openUnixSocketAsLocalUser(ctx, fwdOpDialUnix, d.SocketPath)
+	if err != nil {
+		c.logf("direct-streamlocal to %q: %v", d.SocketPath, err)
+		newChan.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	dconn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		newChan.Reject(gossh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		dconn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+	go proxyChannel(ch, dconn)
+}
+
+// unixForwardHandler handles "streamlocal-forward@openssh.com" and
+// "cancel-streamlocal-forward@openssh.com" requests, which are what
+// `ssh -R /path/to/socket:...` uses. It is the Unix domain socket
+// counterpart of ssh.ForwardedTCPHandler.
+type unixForwardHandler struct {
+	c *conn
+
+	mu       sync.Mutex
+	forwards map[string]net.Listener // keyed by socket path; nil while being set up
+}
+
+func (h *unixForwardHandler) HandleSSHRequest(ctx ssh.Context, srv *ssh.Server, req *gossh.Request) (bool, []byte) {
+	var payload struct {
+		SocketPath string
+	}
+	if err := gossh.Unmarshal(req.Payload, &payload); err != nil {
+		return false, nil
+	}
+	path := payload.SocketPath
+	switch req.Type {
+	case streamLocalForwardRequest:
+		if !h.c.mayForwardUnixSocket() {
+			return false, []byte("unix socket forwarding is disabled")
+		}
+		metricRemoteUnixSocketForward.Add(1)
+		// Reserve path before creating the socket, so that concurrent
+		// requests for the same path can't both succeed.
+		h.mu.Lock()
+		_, dup := h.forwards[path]
+		if !dup {
+			mak.Set(&h.forwards, path, nil)
+		}
+		h.mu.Unlock()
+		if dup {
+			return false, nil
+		}
+		f, err := h.c.openUnixSocketAsLocalUser(ctx, fwdOpListenUnix, path)
+		if err != nil {
+			h.c.logf("streamlocal-forward on %q: %v", path, err)
+			h.release(path)
+			return false, nil
+		}
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			h.c.removeUnixSocketAsLocalUser(path)
+			h.release(path)
+			return false, nil
+		}
+		h.mu.Lock()
+		h.forwards[path] = ln
+		h.mu.Unlock()
+		sconn := ctx.Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+		go h.serve(sconn, path, ln)
+		return true, nil
+	case cancelStreamLocalForwardRequest:
+		h.mu.Lock()
+		ln := h.forwards[path]
+		h.mu.Unlock()
+		if ln == nil {
+			return false, nil
+		}
+		ln.Close()
+		return true, nil
+	}
+	return false, nil
+}
+
+// release removes path from h.forwards.
+func (h *unixForwardHandler) release(path string) {
+	h.mu.Lock()
+	delete(h.forwards, path)
+	h.mu.Unlock()
+}
+
+// serve accepts connections on ln and forwards them to the client until ln
+// is closed, at which point it removes the socket at path.
+func (h *unixForwardHandler) serve(sconn *gossh.ServerConn, path string, ln net.Listener) {
+	defer func() {
+		h.c.removeUnixSocketAsLocalUser(path)
+		h.release(path)
+	}()
+	payload := gossh.Marshal(&struct {
+		SocketPath string
+		Reserved   string
+	}{SocketPath: path})
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			ch, reqs, err := sconn.OpenChannel(forwardedStreamLocalChannelType, payload)
+			if err != nil {
+				c.Close()
+				return
+			}
+			go gossh.DiscardRequests(reqs)
+			proxyChannel(ch, c)
+		}()
+	}
+}
+
+// proxyChannel copies data between ch and c until both directions are done,
+// then closes both.
+func proxyChannel(ch gossh.Channel, c net.Conn) {
+	defer ch.Close()
+	defer c.Close()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(ch, c)
+		ch.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(c, ch)
+		if cw, ok := c.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			c.Close()
+		}
+	}()
+	wg.Wait()
+}
+
+// needsPrivilegeDrop reports whether operations on behalf of the local user
+// must be run in a helper process with dropped privileges. It returns an
+// error if tailscaled is running as a different, unprivileged user, in which
+// case it can't act on behalf of the local user at all.
+func (c *conn) needsPrivilegeDrop() (bool, error) {
+	euid := os.Geteuid()
+	if c.localUser.Uid == strconv.Itoa(euid) {
+		return false, nil
+	}
+	if euid != 0 {
+		return false, fmt.Errorf("can't switch to user %q from process euid %v", c.localUser.Username, euid)
+	}
+	if c.srv.tailscaledPath == "" {
+		return false, errors.New("no tailscaled found on path, can't drop privileges")
+	}
+	return true, nil
+}
+
+// forwardHelperCommand returns a command that runs the forwarding helper for
+// the provided operation as the conn's local user.
+func (c *conn) forwardHelperCommand(ctx context.Context, op string, extraArgs ...string) *exec.Cmd {
+	lu := c.localUser
+	args := []string{
+		"be-child",
+		"ssh-fwd",
+		"--uid=" + lu.Uid,
+		"--gid=" + lu.Gid,
+		"--groups=" + strings.Join(c.userGroupIDs, ","),
+		"--home-dir=" + lu.HomeDir,
+		"--op=" + op,
+	}
+	cmd := exec.CommandContext(ctx, c.srv.tailscaledPath, append(args, extraArgs...)...)
+	cmd.Dir = "/"
+	cmd.Env = []string{}
+	return cmd
+}
+
+// openUnixSocketAsLocalUser connects to (op fwdOpDialUnix) or listens on (op
+// fwdOpListenUnix) the Unix domain socket at path with the credentials of the
+// conn's local user, so that file system permissions are enforced as they
+// would be for the user's own processes. It returns the socket's file.
+func (c *conn) openUnixSocketAsLocalUser(ctx context.Context, op, path string) (*os.File, error) {
+	if path == "" {
+		return nil, errors.New("empty socket path")
+	}
+	drop, err := c.needsPrivilegeDrop()
+	if err != nil {
+		return nil, err
+	}
+	if !drop {
+		fd, err := openUnixSocket(op, path)
+		if err != nil {
+			return nil, err
+		}
+		return os.NewFile(uintptr(fd), path), nil
+	}
+
+	// The helper sends the socket's fd back to us over a socketpair.
+	syscall.ForkLock.RLock()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(fds[0])
+		unix.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	ours := os.NewFile(uintptr(fds[0]), "ssh-fwd-parent")
+	defer ours.Close()
+	theirs := os.NewFile(uintptr(fds[1]), "ssh-fwd-child")
+
+	cmd := c.forwardHelperCommand(ctx, op, "--path="+path)
+	cmd.ExtraFiles = []*os.File{theirs}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	theirs.Close()
+	if err != nil {
+		return nil, err
+	}
+	fd, recvErr := recvFD(int(ours.Fd()))
+	if err := cmd.Wait(); err != nil {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	if recvErr != nil {
+		return nil, recvErr
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// removeUnixSocketAsLocalUser removes the Unix domain socket at path, which
+// was created by openUnixSocketAsLocalUser, with the credentials of the
+// conn's local user. The path was chosen by the client, so it must not be
+// removed with tailscaled's own privileges. Errors are logged.
+func (c *conn) removeUnixSocketAsLocalUser(path string) {
+	drop, err := c.needsPrivilegeDrop()
+	if err == nil && !drop {
+		err = removeUnixSocket(path)
+	} else if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var out []byte
+		out, err = c.forwardHelperCommand(ctx, fwdOpRemoveUnix, "--path="+path).CombinedOutput()
+		if msg := strings.TrimSpace(string(out)); err != nil && msg != "" {
+			err = errors.New(msg)
+		}
+	}
+	if err != nil {
+		c.logf("removing forwarded socket %q: %v", path, err)
+	}
+}
+
+// removeUnixSocket removes path if it's a Unix domain socket.
+func removeUnixSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("not removing %q: not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// openUnixSocket connects to (op fwdOpDialUnix) or listens on (op
+// fwdOpListenUnix) the Unix domain socket at path, returning its fd.
+func openUnixSocket(op, path string) (fd int, err error) {
+	syscall.ForkLock.RLock()
+	fd, err = unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, err
+	}
+	sa := &unix.SockaddrUnix{Name: path}
+	switch op {
+	case fwdOpDialUnix:
+		err = unix.Connect(fd, sa)
+	case fwdOpListenUnix:
+		if err = unix.Bind(fd, sa); err == nil {
+			if err = unix.Listen(fd, unix.SOMAXCONN); err != nil {
+				os.Remove(path)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown op %q", op)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return -1, &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+// sendFD sends fd over the Unix domain socket sock.
+func sendFD(sock, fd int) error {
+	return unix.Sendmsg(sock, []byte{0}, unix.UnixRights(fd), nil, 0)
+}
+
+// recvFD receives a file descriptor sent with sendFD over the Unix domain
+// socket sock. It returns -1 and an error if no file descriptor was received.
+func recvFD(sock int) (int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(sock, buf, oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return -1, err
+	}
+	for _, m := range msgs {
+		fds, err := unix.ParseUnixRights(&m)
+		if err != nil || len(fds) == 0 {
+			continue
+		}
+		for _, extra := range fds[1:] {
+			unix.Close(extra)
+		}
+		unix.CloseOnExec(fds[0])
+		return fds[0], nil
+	}
+	return -1, errors.New("no file descriptor received")
+}
+
+// beForwardHelper is the entrypoint to the `tailscaled be-child ssh-fwd`
+// subcommand. It drops privileges to the provided user and then performs a
+// single operation on their behalf:
+//
+//   - dial-unix: connects to the Unix domain socket at --path and sends the
+//     connected socket to the parent over fd 3.
+//   - listen-unix: creates a listening Unix domain socket at --path and
+//     sends it to the parent over fd 3.
+//   - remove-unix: removes the Unix domain socket at --path.
+//   - xauth: runs xauth(1), reading commands from stdin.
+//
+// Tailscaled launches the helper as the same user as it was launched as.
+func beForwardHelper(args []string) error {
+	// See beIncubator.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var (
+		uid, gid        int
+		groups, homeDir string
+		op, path        string
+	)
+	flags := flag.NewFlagSet("", flag.ExitOnError)
+	flags.IntVar(&uid, "uid", 0, "the uid of local-user")
+	flags.IntVar(&gid, "gid", 0, "the gid of local-user")
+	flags.StringVar(&groups, "groups", "", "comma-separated list of gids of local-user")
+	flags.StringVar(&homeDir, "home-dir", "/", "the user's home directory")
+	flags.StringVar(&op, "op", "", "the operation to perform: dial-unix, listen-unix, remove-unix or xauth")
+	flags.StringVar(&path, "path", "", "the Unix domain socket path for dial-unix, listen-unix and remove-unix")
+	flags.Parse(args)
+
+	var gids []int
+	for _, g := range strings.Split(groups, ",") {
+		if g == "" {
+			continue
+		}
+		gid, err := strconv.Atoi(g)
+		if err != nil {
+			return fmt.Errorf("unable to parse group id %q: %w", g, err)
+		}
+		gids = append(gids, gid)
+	}
+	if err := doDropPrivileges(logger.Discard, uid, gid, gids, homeDir); err != nil {
+		return err
+	}
+
+	switch op {
+	case fwdOpDialUnix, fwdOpListenUnix:
+		fd, err := openUnixSocket(op, path)
+		if err != nil {
+			return err
+		}
+		return sendFD(3, fd)
+	case fwdOpRemoveUnix:
+		return removeUnixSocket(path)
+	case fwdOpXAuth:
+		xauth, err := findXAuth()
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(xauth, "-q", "-")
+		cmd.Env = []string{"HOME=" + homeDir}
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return fmt.Errorf("unknown op %q", op)
+}
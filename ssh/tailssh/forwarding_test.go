@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+)
+
+// newForwardingTestClient returns an SSH client connected to a Tailscale SSH
+// server that skips Tailscale authentication and accepts with action.
+func newForwardingTestClient(t *testing.T, action *tailcfg.SSHAction) *gossh.Client {
+	t.Helper()
+	srv := &server{
+		lb:   &localState{sshEnabled: true},
+		logf: tstest.WhileTestRunningLogger(t),
+	}
+	sc, err := srv.newConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.insecureSkipTailscaleAuth = true
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	um, err := userLookup(u.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.localUser = um
+	sc.info = &sshConnInfo{
+		sshUser: "test",
+		src:     netip.MustParseAddrPort("1.2.3.4:32342"),
+		dst:     netip.MustParseAddrPort("1.2.3.5:22"),
+		node:    (&tailcfg.Node{}).View(),
+	}
+	sc.action0 = action
+	sc.finalAction = action
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		sc.HandleConn(c)
+	}()
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, chans, reqs, err := gossh.NewClientConn(nc, ln.Addr().String(), &gossh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := gossh.NewClient(cc, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDirectStreamLocal(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	t.Run("allowed", func(t *testing.T) {
+		client := newForwardingTestClient(t, &tailcfg.SSHAction{Accept: true, AllowUnixSocketForwarding: true})
+		c, err := client.Dial("unix", sock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := io.WriteString(c, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "hello" {
+			t.Errorf("got %q; want %q", buf, "hello")
+		}
+	})
+	t.Run("denied", func(t *testing.T) {
+		client := newForwardingTestClient(t, &tailcfg.SSHAction{Accept: true, AllowLocalPortForwarding: true})
+		if c, err := client.Dial("unix", sock); err == nil {
+			c.Close()
+			t.Fatal("Dial succeeded; want error")
+		}
+	})
+}
+
+func TestStreamLocalForward(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "sock")
+
+	client := newForwardingTestClient(t, &tailcfg.SSHAction{Accept: true, AllowUnixSocketForwarding: true})
+	ln, err := client.ListenUnix(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.WriteString(c, "hi from client")
+	}()
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hi from client" {
+		t.Errorf("got %q; want %q", got, "hi from client")
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = tstest.WaitFor(5*time.Second, func() error {
+		if c, err := net.Dial("unix", sock); err == nil {
+			c.Close()
+			return errors.New("socket still accepting connections")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	denied := newForwardingTestClient(t, &tailcfg.SSHAction{Accept: true})
+	if _, err := denied.ListenUnix(filepath.Join(t.TempDir(), "sock2")); err == nil {
+		t.Fatal("ListenUnix succeeded; want error")
+	}
+}
+
+func TestSendRecvFD(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	sock := filepath.Join(t.TempDir(), "sock")
+	lfd, err := openUnixSocket(fwdOpListenUnix, sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendFD(fds[0], lfd); err != nil {
+		t.Fatal(err)
+	}
+	unix.Close(lfd)
+	got, err := recvFD(fds[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Close(got); err != nil {
+		t.Fatalf("received fd is not valid: %v", err)
+	}
+
+	if _, err := openUnixSocket(fwdOpDialUnix, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("dial of missing socket succeeded; want error")
+	}
+}
@@ -326,7 +326,7 @@ func (ia incubatorArgs) forwardedEnviron() (env, allowedExtraKeys []string, err
 	// it, couldn't it have just passed it to the incubator in encodedEnv?
 	// If it didn't, no reason for us to pass it to "su -w ..." if it's not in our env
 	// anyway? (Surely we don't want to inherit the tailscaled parent SSH_AUTH_SOCK, if any)
-	allowedExtraKeys = []string{"SSH_AUTH_SOCK"}
+	allowedExtraKeys = []string{"SSH_AUTH_SOCK", "DISPLAY"}
 
 	if ia.encodedEnv != "" {
 		unquoted, err := strconv.Unquote(ia.encodedEnv)
@@ -852,6 +852,9 @@ func (ss *sshSession) launchProcess() error {
 	if ss.agentListener != nil {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("SSH_AUTH_SOCK=%s", ss.agentListener.Addr()))
 	}
+	if ss.x11Listener != nil {
+		cmd.Env = append(cmd.Env, "DISPLAY="+ss.x11Display)
+	}
 
 	ptyReq, winCh, isPty := ss.Pty()
 	if !isPty {
@@ -419,3 +419,10 @@ func acceptEnvPair(kv string) bool {
 	_ = k
 	return true // permit anything on plan9 during bringup, for debugging at least
 }
+
+// registerForwardingHandlers is a no-op on plan9, which doesn't support Unix
+// domain socket or X11 forwarding.
+func (c *conn) registerForwardingHandlers() {}
+
+// handleX11Forwarding is a no-op on plan9; see registerForwardingHandlers.
+func (ss *sshSession) handleX11Forwarding() error { return nil }
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd || plan9
+
+package tailssh
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+func init() {
+	localapi.Register("ssh-recordings/", serveSSHRecordings)
+}
+
+// serveSSHRecordings lists and serves SSH session recordings stored on local
+// disk.
+//
+// URL format:
+//
+//   - GET /localapi/v0/ssh-recordings/
+//   - GET /localapi/v0/ssh-recordings/:escaped-name
+func serveSSHRecordings(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "ssh recording access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := localRecordingStore(h.LocalBackend().TailscaleVarRoot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/ssh-recordings/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	if suffix == "" {
+		recs, err := st.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ret := make([]apitype.SSHRecording, 0, len(recs))
+		for _, rec := range recs {
+			sr := apitype.SSHRecording{
+				Name:    rec.Name,
+				Size:    rec.Size,
+				ModTime: rec.ModTime,
+			}
+			if ch := rec.Header; ch != nil {
+				sr.SSHUser = ch.SSHUser
+				sr.LocalUser = ch.LocalUser
+				sr.SrcNode = ch.SrcNode
+				sr.SrcNodeUser = ch.SrcNodeUser
+				sr.Command = ch.Command
+			}
+			ret = append(ret, sr)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ret)
+		return
+	}
+	name, err := url.PathUnescape(suffix)
+	if err != nil {
+		http.Error(w, "bad recording name", http.StatusBadRequest)
+		return
+	}
+	rc, err := st.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	io.Copy(w, rc)
+}
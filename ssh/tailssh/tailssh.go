@@ -114,6 +114,10 @@ func init() {
 				return lb.ControlNow(time.Now())
 			},
 		}
+		if dir := recordingSpoolDir(lb.TailscaleVarRoot()); dir != "" {
+			// Finish uploading recordings that were interrupted by a restart.
+			go sessionrecording.ResumeSpooled(context.Background(), dir, lb.Dialer().UserDial, sessionrecording.ConnectOptionsFromEnv(logf))
+		}
 
 		return srv, nil
 	})
@@ -481,6 +485,7 @@ func (srv *server) newConn() (*conn, error) {
 			"cancel-tcpip-forward": fwdHandler.HandleSSHRequest,
 		},
 	}
+	c.registerForwardingHandlers()
 	ss := c.Server
 	for k, v := range ssh.DefaultRequestHandlers {
 		ss.RequestHandlers[k] = v
@@ -678,6 +683,9 @@ type sshSession struct {
 	cancelCtx     context.CancelCauseFunc
 	conn          *conn
 	agentListener net.Listener // non-nil if agent-forwarding requested+allowed
+	x11Listener   net.Listener // non-nil if X11-forwarding requested+allowed
+	x11Screen     string       // X11 display and screen number if x11Listener is non-nil
+	x11Display    string       // DISPLAY for the session if x11Listener is non-nil
 
 	// initialized by launchProcess:
 	cmd      *exec.Cmd
@@ -936,6 +944,11 @@ func (ss *sshSession) run() {
 			// TODO(maisem/bradfitz): add a way to close all session resources
 			defer ss.agentListener.Close()
 		}
+		if err := ss.handleX11Forwarding(); err != nil {
+			ss.logf("x11 forwarding failed: %v", err)
+		} else if ss.x11Listener != nil {
+			defer ss.stopX11Forwarding()
+		}
 
 		if ss.shouldRecord() {
 			var err error
@@ -1057,6 +1070,32 @@ func (ss *sshSession) run() {
 // coordination server. This will be removed in the future.
 var recordSSHToLocalDisk = envknob.RegisterBool("TS_DEBUG_LOG_SSH")
 
+var (
+	// sshLocalRecording enables recording SSH sessions to local disk. Local
+	// recording is used when the coordination server configures no
+	// recorders, and as a fallback when the configured recorders are
+	// unreachable and the OnRecordingFailure policy allows the session to
+	// proceed.
+	sshLocalRecording = envknob.RegisterBool("TS_SSH_LOCAL_RECORDING")
+	// sshLocalRecordingDir optionally overrides the directory in which local
+	// recordings are stored.
+	sshLocalRecordingDir = envknob.RegisterString("TS_SSH_LOCAL_RECORDING_DIR")
+	// sshLocalRecordingMaxMB is the maximum total size, in MiB, of local
+	// recordings before the oldest are removed. Zero means no limit.
+	sshLocalRecordingMaxMB = envknob.RegisterInt("TS_SSH_LOCAL_RECORDING_MAX_MB")
+	// sshLocalRecordingMaxAge is the maximum age of local recordings before
+	// they are removed. Zero means no limit.
+	sshLocalRecordingMaxAge = envknob.RegisterDuration("TS_SSH_LOCAL_RECORDING_MAX_AGE")
+	// sshLocalRecordingZstd specifies whether local recordings are zstd
+	// compressed.
+	sshLocalRecordingZstd = envknob.RegisterBool("TS_SSH_LOCAL_RECORDING_ZSTD")
+)
+
+// recordLocally reports whether SSH sessions may be recorded to local disk.
+func recordLocally() bool {
+	return recordSSHToLocalDisk() || sshLocalRecording()
+}
+
 // recorders returns the list of recorders to use for this session.
 // If the final action has a non-empty list of recorders, that list is
 // returned. Otherwise, the list of recorders from the initial action
@@ -1070,7 +1109,7 @@ func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailure
 
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
-	return len(recs) > 0 || recordSSHToLocalDisk()
+	return len(recs) > 0 || recordLocally()
 }
 
 type sshConnInfo struct {
@@ -1215,20 +1254,42 @@ func randBytes(n int) []byte {
 	return b
 }
 
-func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
-	varRoot := ss.conn.srv.lb.TailscaleVarRoot()
+// localRecordingStore returns the store for SSH session recordings kept on
+// local disk. The recordings are stored in the directory named by
+// TS_SSH_LOCAL_RECORDING_DIR, or in the "ssh-sessions" directory under
+// varRoot if unset.
+func localRecordingStore(varRoot string) (*sessionrecording.LocalStore, error) {
+	dir := sshLocalRecordingDir()
+	if dir == "" {
+		if varRoot == "" {
+			return nil, errors.New("no var root for recording storage")
+		}
+		dir = filepath.Join(varRoot, "ssh-sessions")
+	}
+	return &sessionrecording.LocalStore{
+		Dir:      dir,
+		MaxSize:  int64(sshLocalRecordingMaxMB()) << 20,
+		MaxAge:   sshLocalRecordingMaxAge(),
+		Compress: sshLocalRecordingZstd(),
+	}, nil
+}
+
+// recordingSpoolDir returns the directory under varRoot in which uploads of
+// recordings are spooled until the recorder acknowledges them, or "" if
+// varRoot is empty.
+func recordingSpoolDir(varRoot string) string {
 	if varRoot == "" {
-		return nil, errors.New("no var root for recording storage")
+		return ""
 	}
-	dir := filepath.Join(varRoot, "ssh-sessions")
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return nil, err
-	}
-	f, err := os.CreateTemp(dir, fmt.Sprintf("ssh-session-%v-*.cast", now.UnixNano()))
+	return filepath.Join(varRoot, "ssh-recording-spool")
+}
+
+func (ss *sshSession) openFileForRecording(now time.Time) (_ io.WriteCloser, err error) {
+	st, err := localRecordingStore(ss.conn.srv.lb.TailscaleVarRoot())
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	return st.Create(now)
 }
 
 // startNewRecording starts a new SSH session recording.
@@ -1244,7 +1305,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	recorders, onFailure := ss.recorders()
 	var localRecording bool
 	if len(recorders) == 0 {
-		if recordSSHToLocalDisk() {
+		if recordLocally() {
 			localRecording = true
 		} else {
 			return nil, errors.New("no recorders configured")
@@ -1280,7 +1341,10 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 	} else {
 		var errChan <-chan error
 		var attempts []*tailcfg.SSHRecordingAttempt
-		rec.out, attempts, errChan, err = sessionrecording.ConnectToRecorder(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial)
+		opts := sessionrecording.ConnectOptionsFromEnv(ss.logf)
+		opts.RequireAll = !rec.failOpen
+		opts.SpoolDir = recordingSpoolDir(ss.conn.srv.lb.TailscaleVarRoot())
+		rec.out, attempts, errChan, err = sessionrecording.Connect(ctx, recorders, ss.conn.srv.lb.Dialer().UserDial, opts)
 		if err != nil {
 			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
 				eventType := tailcfg.SSHSessionRecordingFailed
@@ -1297,42 +1361,60 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 					msg:   onFailure.RejectSessionWithMessage,
 				}
 			}
-			ss.logf("recording: error starting recording (failing open): %v", err)
-			return nil, nil
-		}
-		go func() {
-			err := <-errChan
-			if err == nil {
-				select {
-				case <-ss.ctx.Done():
-					// Success.
-					ss.logf("recording: finished uploading recording")
-					return
-				default:
-					err = errors.New("recording upload ended before the SSH session")
+			if !recordLocally() {
+				ss.logf("recording: error starting recording (failing open): %v", err)
+				return nil, nil
+			}
+			ss.logf("recording: error starting recording (falling back to local disk): %v", err)
+			rec.out, err = ss.openFileForRecording(now)
+			if err != nil {
+				ss.logf("recording: error starting local recording (failing open): %v", err)
+				return nil, nil
+			}
+		} else {
+			if rec.failOpen && recordLocally() {
+				rec.openLocal = func(cause error) (io.WriteCloser, error) {
+					ss.logf("recording: error uploading recording (continuing on local disk): %v", cause)
+					return ss.openFileForRecording(now)
 				}
 			}
-			if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
-				lastAttempt := attempts[len(attempts)-1]
-				lastAttempt.FailureMessage = err.Error()
-
-				eventType := tailcfg.SSHSessionRecordingFailed
-				if onFailure.TerminateSessionWithMessage != "" {
-					eventType = tailcfg.SSHSessionRecordingTerminated
+			go func() {
+				err := <-errChan
+				if err == nil {
+					select {
+					case <-ss.ctx.Done():
+						// Success.
+						ss.logf("recording: finished uploading recording")
+						return
+					default:
+						err = errors.New("recording upload ended before the SSH session")
+					}
 				}
+				if onFailure != nil && onFailure.NotifyURL != "" && len(attempts) > 0 {
+					lastAttempt := attempts[len(attempts)-1]
+					lastAttempt.FailureMessage = err.Error()
 
-				ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
-			}
-			if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
-				ss.logf("recording: error uploading recording (closing session): %v", err)
-				ss.cancelCtx(userVisibleError{
-					error: err,
-					msg:   onFailure.TerminateSessionWithMessage,
-				})
-				return
-			}
-			ss.logf("recording: error uploading recording (failing open): %v", err)
-		}()
+					eventType := tailcfg.SSHSessionRecordingFailed
+					if onFailure.TerminateSessionWithMessage != "" {
+						eventType = tailcfg.SSHSessionRecordingTerminated
+					}
+
+					ss.notifyControl(ctx, nodeKey, eventType, attempts, onFailure.NotifyURL)
+				}
+				if onFailure != nil && onFailure.TerminateSessionWithMessage != "" {
+					ss.logf("recording: error uploading recording (closing session): %v", err)
+					ss.cancelCtx(userVisibleError{
+						error: err,
+						msg:   onFailure.TerminateSessionWithMessage,
+					})
+					return
+				}
+				if rec.fallBackToLocal(err) {
+					return
+				}
+				ss.logf("recording: error uploading recording (failing open): %v", err)
+			}()
+		}
 	}
 
 	ch := sessionrecording.CastHeader{
@@ -1369,6 +1451,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		return nil, err
 	}
 	j = append(j, '\n')
+	rec.header = j
 	if _, err := rec.out.Write(j); err != nil {
 		if errors.Is(err, io.ErrClosedPipe) && ss.ctx.Err() != nil {
 			// If we got an io.ErrClosedPipe, it's likely because
@@ -1429,8 +1512,47 @@ type recording struct {
 	// continue if writing to the recording fails.
 	failOpen bool
 
-	mu  sync.Mutex // guards writes to, close of out
+	// header is the cast header line that starts the recording.
+	header []byte
+
+	mu  sync.Mutex // guards writes to, close of out, and openLocal
 	out io.WriteCloser
+
+	// openLocal, if non-nil, opens a recording on local disk to carry on
+	// with if uploading the recording fails mid-session. It's only set
+	// when failing open, and is called at most once.
+	openLocal func(cause error) (io.WriteCloser, error)
+}
+
+// fallBackToLocal switches the rest of the recording over to local disk
+// after uploading it failed with cause. It reports whether it did so.
+func (r *recording) fallBackToLocal(cause error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fallBackToLocalLocked(cause)
+}
+
+// fallBackToLocalLocked is like fallBackToLocal, but r.mu must be held.
+func (r *recording) fallBackToLocalLocked(cause error) bool {
+	if r.openLocal == nil || r.out == nil {
+		return false
+	}
+	openLocal := r.openLocal
+	r.openLocal = nil
+	r.out.Close()
+	r.out = nil
+	out, err := openLocal(cause)
+	if err != nil {
+		r.ss.logf("recording: error starting local recording (failing open): %v", err)
+		return false
+	}
+	if _, err := out.Write(r.header); err != nil {
+		r.ss.logf("recording: error writing local recording (failing open): %v", err)
+		out.Close()
+		return false
+	}
+	r.out = out
+	return true
 }
 
 func (r *recording) Close() error {
@@ -1504,6 +1626,9 @@ func (w loggingWriter) writeCastLine(j []byte) error {
 		return errors.New("logger closed")
 	}
 	_, err := w.r.out.Write(j)
+	if err != nil && w.r.fallBackToLocalLocked(err) {
+		_, err = w.r.out.Write(j)
+	}
 	if err != nil {
 		return fmt.Errorf("logger Write: %w", err)
 	}
@@ -1541,6 +1666,10 @@ func envEq(a, b string) bool {
 	metricSFTP                = clientmetric.NewCounter("ssh_sftp_sessions")
 	metricLocalPortForward    = clientmetric.NewCounter("ssh_local_port_forward_requests")
 	metricRemotePortForward   = clientmetric.NewCounter("ssh_remote_port_forward_requests")
+	metricX11Forward          = clientmetric.NewCounter("ssh_x11_forward_requests")
+
+	metricLocalUnixSocketForward  = clientmetric.NewCounter("ssh_local_unix_socket_forward_requests")
+	metricRemoteUnixSocketForward = clientmetric.NewCounter("ssh_remote_unix_socket_forward_requests")
 )
 
 // userVisibleError is a wrapper around an error that implements
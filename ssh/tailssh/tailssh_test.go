@@ -1332,3 +1332,70 @@ func mockRecordingServer(t *testing.T, handleRecord http.HandlerFunc) *httptest.
 	t.Cleanup(srv.Close)
 	return srv
 }
+
+type failingWriteCloser struct{ closed bool }
+
+func (w *failingWriteCloser) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+func (w *failingWriteCloser) Close() error              { w.closed = true; return nil }
+
+type bufferWriteCloser struct{ bytes.Buffer }
+
+func (*bufferWriteCloser) Close() error { return nil }
+
+func TestRecordingFallsBackToLocal(t *testing.T) {
+	header := []byte(`{"version":2}` + "\n")
+	newRecording := func() (*recording, *failingWriteCloser, *bufferWriteCloser) {
+		remote := &failingWriteCloser{}
+		local := &bufferWriteCloser{}
+		return &recording{
+			start:    time.Now(),
+			failOpen: true,
+			header:   header,
+			out:      remote,
+			openLocal: func(error) (io.WriteCloser, error) {
+				return local, nil
+			},
+		}, remote, local
+	}
+
+	t.Run("write_error", func(t *testing.T) {
+		rec, remote, local := newRecording()
+		var tty bytes.Buffer
+		w := rec.writer("o", &tty)
+		for _, s := range []string{"one", "two"} {
+			if _, err := io.WriteString(w, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !remote.closed {
+			t.Error("remote recording not closed after failing")
+		}
+		if tty.String() != "onetwo" {
+			t.Errorf("session output = %q", tty.String())
+		}
+		got := local.String()
+		if !strings.HasPrefix(got, string(header)) || !strings.Contains(got, `"one"`) || !strings.Contains(got, `"two"`) {
+			t.Errorf("local recording = %q; want the header and both writes", got)
+		}
+	})
+
+	t.Run("upload_error", func(t *testing.T) {
+		rec, remote, local := newRecording()
+		if !rec.fallBackToLocal(errors.New("upload failed")) {
+			t.Fatal("fallBackToLocal = false")
+		}
+		if rec.fallBackToLocal(errors.New("upload failed")) {
+			t.Error("second fallBackToLocal = true; want false")
+		}
+		if !remote.closed {
+			t.Error("remote recording not closed after failing")
+		}
+		var tty bytes.Buffer
+		if _, err := io.WriteString(rec.writer("o", &tty), "three"); err != nil {
+			t.Fatal(err)
+		}
+		if got := local.String(); !strings.HasPrefix(got, string(header)) || !strings.Contains(got, `"three"`) {
+			t.Errorf("local recording = %q; want the header and the write", got)
+		}
+	})
+}
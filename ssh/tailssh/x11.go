@@ -0,0 +1,208 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build (linux && !android) || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"tailscale.com/tempfork/gliderlabs/ssh"
+)
+
+const (
+	// x11DisplayOffset is the first X11 display number used for forwarded
+	// displays, to avoid conflicting with local X servers. It matches
+	// OpenSSH's default X11DisplayOffset.
+	x11DisplayOffset = 10
+	// x11MaxDisplays is the number of display numbers to try, starting at
+	// x11DisplayOffset.
+	x11MaxDisplays = 1000
+	// x11BasePort is the TCP port of X11 display number 0.
+	x11BasePort = 6000
+)
+
+// xauthPaths are the locations where xauth(1) is commonly installed, which
+// are checked if it isn't found on the PATH.
+var xauthPaths = []string{
+	"/usr/bin/xauth",
+	"/usr/X11/bin/xauth",
+	"/usr/X11R6/bin/xauth",
+	"/usr/local/bin/xauth",
+	"/opt/X11/bin/xauth",
+}
+
+// findXAuth returns the path to the xauth(1) binary.
+func findXAuth() (string, error) {
+	if p, err := exec.LookPath("xauth"); err == nil {
+		return p, nil
+	}
+	for _, p := range xauthPaths {
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, nil
+		}
+	}
+	return "", errors.New("xauth not found")
+}
+
+// mayForwardX11 implements ssh.X11Callback. It reports whether the client may
+// forward X11 connections for a session.
+func (c *conn) mayForwardX11(ctx ssh.Context, x11 ssh.X11) bool {
+	if sshDisableForwarding() {
+		return false
+	}
+	if c.finalAction == nil || !c.finalAction.AllowX11Forwarding || c.localUser == nil {
+		return false
+	}
+	if _, err := findXAuth(); err != nil {
+		c.logf("x11 forwarding requested, but %v", err)
+		return false
+	}
+	metricX11Forward.Add(1)
+	return true
+}
+
+// handleX11Forwarding starts X11 forwarding for the session if the client
+// requested it and it was allowed by mayForwardX11.
+//
+// It listens on a loopback TCP port for a free display number and registers
+// the client's X11 authentication cookie for that display with xauth(1),
+// running as the local user.
+//
+// On success, it assigns ss.x11Listener, ss.x11Screen and ss.x11Display. The
+// caller must call stopX11Forwarding when the session ends.
+func (ss *sshSession) handleX11Forwarding() error {
+	x11, ok := ss.X11()
+	if !ok {
+		return nil
+	}
+	if !isHex(x11.AuthCookie) {
+		return errors.New("invalid x11 auth cookie")
+	}
+	if strings.ContainsAny(x11.AuthProtocol, " \t\r\n") {
+		return fmt.Errorf("invalid x11 auth protocol %q", x11.AuthProtocol)
+	}
+	ln, display, err := listenX11()
+	if err != nil {
+		return err
+	}
+	screen := fmt.Sprintf("%d.%d", display, x11.ScreenNumber)
+	if err := ss.conn.runXAuth(ss.ctx, fmt.Sprintf("remove unix:%s\nadd unix:%s %s %s\n", screen, screen, x11.AuthProtocol, x11.AuthCookie)); err != nil {
+		ln.Close()
+		return fmt.Errorf("xauth: %w", err)
+	}
+	ss.logf("ssh: x11 forwarding on display %s", screen)
+	sconn := ss.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn)
+	go forwardX11Connections(sconn, ln, x11.SingleConnection)
+	ss.x11Listener = ln
+	ss.x11Screen = screen
+	ss.x11Display = "localhost:" + screen
+	return nil
+}
+
+// stopX11Forwarding stops the X11 forwarding started by handleX11Forwarding
+// and removes the session's cookie from the local user's xauth file.
+func (ss *sshSession) stopX11Forwarding() {
+	ss.x11Listener.Close()
+	// ss.ctx is done by now, so the removal needs its own deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ss.conn.runXAuth(ctx, fmt.Sprintf("remove unix:%s\n", ss.x11Screen)); err != nil {
+		ss.logf("x11: removing xauth entry: %v", err)
+	}
+}
+
+func isHex(s string) bool {
+	if s == "" || len(s)%2 != 0 {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// listenX11 listens on the loopback TCP port of the first free X11 display
+// number.
+func listenX11() (_ net.Listener, display int, _ error) {
+	for display = x11DisplayOffset; display < x11DisplayOffset+x11MaxDisplays; display++ {
+		ln, err := net.Listen("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(x11BasePort+display)))
+		if err == nil {
+			return ln, display, nil
+		}
+	}
+	return nil, 0, errors.New("no free x11 display found")
+}
+
+// runXAuth runs xauth(1) as the conn's local user, with the provided commands
+// on its stdin.
+func (c *conn) runXAuth(ctx context.Context, commands string) error {
+	drop, err := c.needsPrivilegeDrop()
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	if drop {
+		cmd = c.forwardHelperCommand(ctx, fwdOpXAuth)
+	} else {
+		xauth, err := findXAuth()
+		if err != nil {
+			return err
+		}
+		cmd = exec.CommandContext(ctx, xauth, "-q", "-")
+		cmd.Env = []string{"HOME=" + c.localUser.HomeDir}
+	}
+	cmd.Stdin = strings.NewReader(commands)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// forwardX11Connections accepts connections on ln and forwards each of them
+// to the client over a new "x11" channel, until ln is closed. If single is
+// true, only the first connection is forwarded.
+func forwardX11Connections(sconn *gossh.ServerConn, ln net.Listener, single bool) {
+	defer ln.Close()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if single {
+			ln.Close()
+		}
+		go func() {
+			origin := c.RemoteAddr().(*net.TCPAddr)
+			payload := gossh.Marshal(&struct {
+				OriginatorAddress string
+				OriginatorPort    uint32
+			}{origin.IP.String(), uint32(origin.Port)})
+			ch, reqs, err := sconn.OpenChannel("x11", payload)
+			if err != nil {
+				c.Close()
+				return
+			}
+			go gossh.DiscardRequests(reqs)
+			proxyChannel(ch, c)
+		}()
+		if single {
+			return
+		}
+	}
+}
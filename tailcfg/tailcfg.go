@@ -162,7 +162,8 @@
 //   - 115: 2025-03-07: Client understands DERPRegion.NoMeasureNoHome.
 //   - 116: 2025-05-05: Client serves MagicDNS "AAAA" if NodeAttrMagicDNSPeerAAAA set on self node
 //   - 117: 2025-05-28: Client understands DisplayMessages (structured health messages), but not necessarily PrimaryAction.
-const CurrentCapabilityVersion CapabilityVersion = 117
+//   - 118: 2026-10-19: Client understands SSHAction.AllowX11Forwarding and SSHAction.AllowUnixSocketForwarding.
+const CurrentCapabilityVersion CapabilityVersion = 118
 
 // ID is an integer ID for a user, node, or login allocated by the
 // control plane.
@@ -865,7 +866,8 @@ type Hostinfo struct {
 	WoLMACs         []string       `json:",omitempty"` // MAC address(es) to send Wake-on-LAN packets to wake this node (lowercase hex w/ colons)
 	Services        []Service      `json:",omitempty"` // services advertised by this machine
 	NetInfo         *NetInfo       `json:",omitempty"`
-	SSH_HostKeys    []string       `json:"sshHostKeys,omitempty"` // if advertised
+	SSH_HostKeys    []string       `json:"sshHostKeys,omitempty"`   // if advertised
+	SSH_HostCAKeys  []string       `json:"sshHostCAKeys,omitempty"` // public keys of SSH CAs that sign SSH_HostKeys, if any
 	Cloud           string         `json:",omitempty"`
 	Userspace       opt.Bool       `json:",omitempty"` // if the client is running in userspace (netstack) mode
 	UserspaceRouter opt.Bool       `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
@@ -1509,6 +1511,10 @@ type CapGrant struct {
 	// capabilities, such as the ability to add user groups to the OIDC
 	// claim
 	PeerCapabilityTsIDP PeerCapability = "tailscale.com/cap/tsidp"
+
+	// PeerCapabilityTsRecorderUI grants a peer access to the web UI of a
+	// tsrecorder instance, which lists and replays session recordings.
+	PeerCapabilityTsRecorderUI PeerCapability = "tailscale.com/cap/tsrecorder-ui"
 )
 
 // NodeCapMap is a map of capabilities to their optional values. It is valid for
@@ -2822,6 +2828,16 @@ type SSHAction struct {
 	// to use remote port forwarding if requested.
 	AllowRemotePortForwarding bool `json:"allowRemotePortForwarding,omitempty"`
 
+	// AllowX11Forwarding, if true, allows accepted connections to forward
+	// X11 connections to the client if requested.
+	AllowX11Forwarding bool `json:"allowX11Forwarding,omitempty"`
+
+	// AllowUnixSocketForwarding, if true, allows accepted connections to
+	// forward Unix domain sockets in either direction if requested
+	// (the OpenSSH streamlocal extensions). Sockets are connected to and
+	// created as the local user.
+	AllowUnixSocketForwarding bool `json:"allowUnixSocketForwarding,omitempty"`
+
 	// Recorders defines the destinations of the SSH session recorders.
 	// The recording will be uploaded to http://addr:port/record.
 	Recorders []netip.AddrPort `json:"recorders,omitempty"`
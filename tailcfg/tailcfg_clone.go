@@ -138,6 +138,7 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	dst.Services = append(src.Services[:0:0], src.Services...)
 	dst.NetInfo = src.NetInfo.Clone()
 	dst.SSH_HostKeys = append(src.SSH_HostKeys[:0:0], src.SSH_HostKeys...)
+	dst.SSH_HostCAKeys = append(src.SSH_HostCAKeys[:0:0], src.SSH_HostCAKeys...)
 	if dst.Location != nil {
 		dst.Location = ptr.To(*src.Location)
 	}
@@ -181,6 +182,7 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	Services        []Service
 	NetInfo         *NetInfo
 	SSH_HostKeys    []string
+	SSH_HostCAKeys  []string
 	Cloud           string
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
@@ -547,6 +549,8 @@ func (src *SSHAction) Clone() *SSHAction {
 	HoldAndDelegate           string
 	AllowLocalPortForwarding  bool
 	AllowRemotePortForwarding bool
+	AllowX11Forwarding        bool
+	AllowUnixSocketForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
 }{})
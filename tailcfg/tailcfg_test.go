@@ -62,6 +62,7 @@ func TestHostinfoEqual(t *testing.T) {
 		"Services",
 		"NetInfo",
 		"SSH_HostKeys",
+		"SSH_HostCAKeys",
 		"Cloud",
 		"Userspace",
 		"UserspaceRouter",
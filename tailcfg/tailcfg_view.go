@@ -295,6 +295,7 @@ func (v HostinfoView) WoLMACs() views.Slice[string]           { return views.Sli
 func (v HostinfoView) Services() views.Slice[Service]         { return views.SliceOf(v.ж.Services) }
 func (v HostinfoView) NetInfo() NetInfoView                   { return v.ж.NetInfo.View() }
 func (v HostinfoView) SSH_HostKeys() views.Slice[string]      { return views.SliceOf(v.ж.SSH_HostKeys) }
+func (v HostinfoView) SSH_HostCAKeys() views.Slice[string]    { return views.SliceOf(v.ж.SSH_HostCAKeys) }
 func (v HostinfoView) Cloud() string                          { return v.ж.Cloud }
 func (v HostinfoView) Userspace() opt.Bool                    { return v.ж.Userspace }
 func (v HostinfoView) UserspaceRouter() opt.Bool              { return v.ж.UserspaceRouter }
@@ -339,6 +340,7 @@ func (v HostinfoView) Equal(v2 HostinfoView) bool { return v.ж.Equal(v2.ж) }
 	Services        []Service
 	NetInfo         *NetInfo
 	SSH_HostKeys    []string
+	SSH_HostCAKeys  []string
 	Cloud           string
 	Userspace       opt.Bool
 	UserspaceRouter opt.Bool
@@ -1160,6 +1162,8 @@ func (v SSHActionView) AllowAgentForwarding() bool             { return v.ж.All
 func (v SSHActionView) HoldAndDelegate() string                { return v.ж.HoldAndDelegate }
 func (v SSHActionView) AllowLocalPortForwarding() bool         { return v.ж.AllowLocalPortForwarding }
 func (v SSHActionView) AllowRemotePortForwarding() bool        { return v.ж.AllowRemotePortForwarding }
+func (v SSHActionView) AllowX11Forwarding() bool               { return v.ж.AllowX11Forwarding }
+func (v SSHActionView) AllowUnixSocketForwarding() bool        { return v.ж.AllowUnixSocketForwarding }
 func (v SSHActionView) Recorders() views.Slice[netip.AddrPort] { return views.SliceOf(v.ж.Recorders) }
 func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailureAction] {
 	return views.ValuePointerOf(v.ж.OnRecordingFailure)
@@ -1175,6 +1179,8 @@ func (v SSHActionView) OnRecordingFailure() views.ValuePointer[SSHRecorderFailur
 	HoldAndDelegate           string
 	AllowLocalPortForwarding  bool
 	AllowRemotePortForwarding bool
+	AllowX11Forwarding        bool
+	AllowUnixSocketForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
 }{})
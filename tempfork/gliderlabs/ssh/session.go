@@ -84,6 +84,10 @@ type Session interface {
 	// During the time that no channel is registered, breaks are ignored.
 	Break(c chan<- bool)
 
+	// X11 returns X11 forwarding information and whether X11 forwarding was
+	// requested by the client and accepted by the server's X11Callback.
+	X11() (X11, bool)
+
 	// DisablePTYEmulation disables the session's default minimal PTY emulation.
 	// If you're setting the pty's termios settings from the Pty request, use
 	// this method to avoid corruption.
@@ -107,6 +111,7 @@ func DefaultSessionHandler(srv *Server, conn *gossh.ServerConn, newChan gossh.Ne
 		conn:              conn,
 		handler:           srv.Handler,
 		ptyCb:             srv.PtyCallback,
+		x11Cb:             srv.X11Callback,
 		sessReqCb:         srv.SessionRequestCallback,
 		subsystemHandlers: srv.SubsystemHandlers,
 		ctx:               ctx,
@@ -126,6 +131,8 @@ type session struct {
 	winch               chan Window
 	env                 []string
 	ptyCb               PtyCallback
+	x11                 *X11
+	x11Cb               X11Callback
 	sessReqCb           SessionRequestCallback
 	rawCmd              string
 	subsystem           string
@@ -227,6 +234,13 @@ func (sess *session) Pty() (Pty, <-chan Window, bool) {
 	return Pty{}, sess.winch, false
 }
 
+func (sess *session) X11() (X11, bool) {
+	if sess.x11 != nil {
+		return *sess.x11, true
+	}
+	return X11{}, false
+}
+
 func (sess *session) Signals(c chan<- Signal) {
 	sess.Lock()
 	defer sess.Unlock()
@@ -365,6 +379,19 @@ func (sess *session) handleRequests(reqs <-chan *gossh.Request) {
 				sess.winch <- win
 			}
 			req.Reply(ok, nil)
+		case "x11-req":
+			if sess.handled || sess.x11 != nil || sess.x11Cb == nil {
+				req.Reply(false, nil)
+				continue
+			}
+			x11Req, ok := parseX11Request(req.Payload)
+			if ok {
+				ok = sess.x11Cb(sess.ctx, x11Req)
+			}
+			if ok {
+				sess.x11 = &x11Req
+			}
+			req.Reply(ok, nil)
 		case agentRequestType:
 			// TODO: option/callback to allow agent forwarding
 			SetAgentRequested(sess.ctx)
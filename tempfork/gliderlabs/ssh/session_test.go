@@ -230,6 +230,38 @@ func TestPty(t *testing.T) {
 	<-done
 }
 
+func TestX11(t *testing.T) {
+	t.Parallel()
+	done := make(chan bool)
+	session, _, cleanup := newTestSession(t, &Server{
+		Handler: func(s Session) {
+			x11, ok := s.X11()
+			if !ok {
+				t.Fatalf("expected x11 but none requested")
+			}
+			if !x11.SingleConnection || x11.AuthProtocol != "MIT-MAGIC-COOKIE-1" || x11.AuthCookie != "abcd" || x11.ScreenNumber != 1 {
+				t.Fatalf("unexpected x11 request %+v", x11)
+			}
+			close(done)
+		},
+		X11Callback: func(ctx Context, x11 X11) bool { return true },
+	}, nil)
+	defer cleanup()
+	ok, err := session.SendRequest("x11-req", true, gossh.Marshal(X11{
+		SingleConnection: true,
+		AuthProtocol:     "MIT-MAGIC-COOKIE-1",
+		AuthCookie:       "abcd",
+		ScreenNumber:     1,
+	}))
+	if err != nil || !ok {
+		t.Fatalf("x11-req = %v, %v; want true, nil", ok, err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("expected nil but got %v", err)
+	}
+	<-done
+}
+
 func TestPtyResize(t *testing.T) {
 	t.Parallel()
 	winch0 := Window{Width: 40, Height: 80}
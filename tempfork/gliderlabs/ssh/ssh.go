@@ -54,6 +54,9 @@
 // SessionRequestCallback is a callback for allowing or denying SSH sessions.
 type SessionRequestCallback func(sess Session, requestType string) bool
 
+// X11Callback is a hook for allowing X11 forwarding requests.
+type X11Callback func(ctx Context, x11 X11) bool
+
 // ConnCallback is a hook for new connections before handling.
 // It allows wrapping for timeouts and limiting by returning
 // the net.Conn that will be used as the underlying connection.
@@ -110,6 +113,23 @@ type Pty struct {
 	Modes gossh.TerminalModes
 }
 
+// X11 represents an X11 forwarding request.
+type X11 struct {
+	// SingleConnection is whether only a single X11 connection should be
+	// forwarded.
+	SingleConnection bool
+
+	// AuthProtocol is the X11 authentication protocol, typically
+	// "MIT-MAGIC-COOKIE-1".
+	AuthProtocol string
+
+	// AuthCookie is the hex-encoded X11 authentication cookie.
+	AuthCookie string
+
+	// ScreenNumber is the X11 screen number.
+	ScreenNumber uint32
+}
+
 // Serve accepts incoming SSH connections on the listener l, creating a new
 // connection goroutine for each. The connection goroutines read requests and
 // then calls handler to handle sessions. Handler is typically nil, in which
@@ -16,6 +16,28 @@ func generateSigner() (ssh.Signer, error) {
 	return ssh.NewSignerFromKey(key)
 }
 
+func parseX11Request(payload []byte) (x11 X11, ok bool) {
+	// See https://datatracker.ietf.org/doc/html/rfc4254#section-6.3.1
+	//    byte      SSH_MSG_CHANNEL_REQUEST
+	//    uint32    recipient channel
+	//    string    "x11-req"
+	//    boolean   want reply
+	//    boolean   single connection
+	//    string    x11 authentication protocol
+	//    string    x11 authentication cookie
+	//    uint32    x11 screen number
+	var req struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return X11{}, false
+	}
+	return X11(req), true
+}
+
 func parsePtyRequest(payload []byte) (pty Pty, ok bool) {
 	// See https://datatracker.ietf.org/doc/html/rfc4254#section-6.2
 	// 6.2.  Requesting a Pseudo-Terminal
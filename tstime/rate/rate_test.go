@@ -103,6 +103,27 @@ func TestLimiterJumpBackwards(t *testing.T) {
 	})
 }
 
+func TestLimiterAllowN(t *testing.T) {
+	lim := NewLimiter(10, 20)
+	steps := []struct {
+		t  mono.Time
+		n  int
+		ok bool
+	}{
+		{t0, 15, true},
+		{t0, 6, false}, // only 5 tokens remain
+		{t0, 5, true},
+		{t1, 2, false}, // 1 token replenished
+		{t1, 1, true},
+		{t2, 21, false}, // larger than the burst
+	}
+	for i, step := range steps {
+		if ok := lim.allowN(step.t, step.n); ok != step.ok {
+			t.Errorf("step %d: lim.allowN(%v, %d) = %v want %v", i, step.t, step.n, ok, step.ok)
+		}
+	}
+}
+
 // Ensure that tokensFromDuration doesn't produce
 // rounding errors by truncating nanoseconds.
 // See golang.org/issues/34861.
@@ -0,0 +1,261 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package pathpolicy defines policy constraining how a node chooses network
+// paths (direct UDP, peer relays, and DERP) to its peers.
+package pathpolicy
+
+//go:generate go run tailscale.com/cmd/viewer --type=Policy,PeerRule --clonefunc=true
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
+)
+
+// Policy constrains how a node chooses paths to its peers, beyond picking
+// the lowest-latency one. The zero value imposes no constraints.
+type Policy struct {
+	// PreferDERPRegion, if non-zero, is the DERP region to use as this
+	// node's home whenever it's reachable, regardless of latency.
+	PreferDERPRegion int `json:",omitempty"`
+
+	// AvoidDERPRegions are DERP regions not to pick as this node's home,
+	// unless one already is.
+	AvoidDERPRegions []int `json:",omitempty"`
+
+	// AvoidMetered, if true, avoids direct paths that go out over a
+	// metered network interface, such as cellular, whenever a peer has a
+	// direct or relay path over an unmetered one.
+	AvoidMetered bool `json:",omitempty"`
+
+	// MeteredInterfaces are patterns, in the syntax of [path.Match], of the
+	// names of network interfaces to treat as metered, in addition to any
+	// the OS reports as expensive.
+	MeteredInterfaces []string `json:",omitempty"`
+
+	// Peers are rules for specific peers. The first rule that matches a
+	// peer applies to it; peers that match no rule are unconstrained.
+	Peers []*PeerRule `json:",omitempty"`
+}
+
+// PeerRule constrains the paths used to the peers it matches.
+type PeerRule struct {
+	// Match are the peers the rule applies to, each of which is one of:
+	//   - "*", for all peers
+	//   - a tag, such as "tag:server"
+	//   - a stable node ID
+	//   - a node's MagicDNS name, either fully qualified or just the
+	//     host label
+	Match []string
+
+	// DERPRegions, if non-empty, are the only DERP regions that traffic to
+	// matching peers may be relayed through. Peers whose home DERP region
+	// isn't one of them can only be reached over direct or relay paths.
+	//
+	// Disco messages, which set up direct paths and carry no user
+	// traffic, may still be exchanged through any region.
+	DERPRegions []int `json:",omitempty"`
+
+	// ForceRelay, if non-empty, limits traffic to matching peers to peer
+	// relay (UDP relay server) paths through relay servers at addresses in
+	// these prefixes, never direct paths. Until such a path is established,
+	// traffic uses DERP, subject to DERPRegions.
+	ForceRelay []netip.Prefix `json:",omitempty"`
+}
+
+// Validate reports whether p is well-formed.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	if p.PreferDERPRegion < 0 {
+		return fmt.Errorf("invalid PreferDERPRegion %d", p.PreferDERPRegion)
+	}
+	for _, id := range p.AvoidDERPRegions {
+		if id <= 0 {
+			return fmt.Errorf("invalid DERP region %d in AvoidDERPRegions", id)
+		}
+		if id == p.PreferDERPRegion {
+			return fmt.Errorf("DERP region %d is both preferred and avoided", id)
+		}
+	}
+	for _, pat := range p.MeteredInterfaces {
+		if _, err := path.Match(pat, ""); err != nil {
+			return fmt.Errorf("invalid MeteredInterfaces pattern %q: %w", pat, err)
+		}
+	}
+	for i, r := range p.Peers {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("Peers[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *PeerRule) validate() error {
+	if r == nil {
+		return errors.New("nil rule")
+	}
+	if len(r.Match) == 0 {
+		return errors.New("no peers to match")
+	}
+	if err := CheckPatterns(r.Match); err != nil {
+		return err
+	}
+	for _, id := range r.DERPRegions {
+		if id <= 0 {
+			return fmt.Errorf("invalid DERP region %d", id)
+		}
+	}
+	for _, pfx := range r.ForceRelay {
+		if !pfx.IsValid() {
+			return errors.New("invalid ForceRelay prefix")
+		}
+	}
+	return nil
+}
+
+// CheckPatterns reports whether patterns are well-formed peer patterns, in
+// the syntax of [PeerRule.Match].
+func CheckPatterns(patterns []string) error {
+	for _, m := range patterns {
+		if m == "" {
+			return errors.New("empty peer to match")
+		}
+		if strings.HasPrefix(m, "tag:") {
+			if err := tailcfg.CheckTag(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RuleFor returns the rule that applies to the peer n, or nil if there's
+// none (including if p is nil).
+func (p *Policy) RuleFor(n tailcfg.NodeView) *PeerRule {
+	if p == nil {
+		return nil
+	}
+	for _, r := range p.Peers {
+		if NodeMatches(n, r.Match) {
+			return r
+		}
+	}
+	return nil
+}
+
+// NodeMatches reports whether the node n matches any of the patterns, which
+// are in the syntax of [PeerRule.Match].
+func NodeMatches(n tailcfg.NodeView, patterns []string) bool {
+	name := strings.TrimSuffix(n.Name(), ".")
+	host, _, _ := strings.Cut(name, ".")
+	for _, m := range patterns {
+		switch {
+		case m == "*":
+			return true
+		case strings.HasPrefix(m, "tag:"):
+			if views.SliceContains(n.Tags(), m) {
+				return true
+			}
+		case tailcfg.StableNodeID(m) == n.StableID():
+			return true
+		case name != "" && (strings.EqualFold(m, name) || strings.EqualFold(m, host)):
+			return true
+		}
+	}
+	return false
+}
+
+// IsMeteredInterface reports whether the network interface ifName matches
+// one of p.MeteredInterfaces.
+func (p *Policy) IsMeteredInterface(ifName string) bool {
+	if p == nil || ifName == "" {
+		return false
+	}
+	for _, pat := range p.MeteredInterfaces {
+		if ok, _ := path.Match(pat, ifName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDERPRegion reports whether traffic may be relayed through the DERP
+// region with the given ID. A nil rule allows every region.
+func (r *PeerRule) AllowsDERPRegion(regionID int) bool {
+	return r == nil || len(r.DERPRegions) == 0 || slices.Contains(r.DERPRegions, regionID)
+}
+
+// AllowsDirect reports whether direct (non-relayed) UDP paths may be used.
+// A nil rule allows them.
+func (r *PeerRule) AllowsDirect() bool {
+	return r == nil || len(r.ForceRelay) == 0
+}
+
+// AllowsRelay reports whether a peer relay path through the relay server at
+// addr may be used. A nil rule allows any relay server.
+func (r *PeerRule) AllowsRelay(addr netip.Addr) bool {
+	if r == nil || len(r.ForceRelay) == 0 {
+		return true
+	}
+	for _, pfx := range r.ForceRelay {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a short description of the constraints r imposes, for
+// display in status output.
+func (r *PeerRule) String() string {
+	if r == nil {
+		return ""
+	}
+	var parts []string
+	if len(r.DERPRegions) > 0 {
+		ids := make([]string, len(r.DERPRegions))
+		for i, id := range r.DERPRegions {
+			ids[i] = fmt.Sprint(id)
+		}
+		parts = append(parts, "derp="+strings.Join(ids, ","))
+	}
+	if len(r.ForceRelay) > 0 {
+		pfxs := make([]string, len(r.ForceRelay))
+		for i, pfx := range r.ForceRelay {
+			pfxs[i] = pfx.String()
+		}
+		parts = append(parts, "relay-only="+strings.Join(pfxs, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Equal reports whether p and p2 are equal.
+func (p *Policy) Equal(p2 *Policy) bool {
+	if p == nil || p2 == nil {
+		return p == p2
+	}
+	return p.PreferDERPRegion == p2.PreferDERPRegion &&
+		slices.Equal(p.AvoidDERPRegions, p2.AvoidDERPRegions) &&
+		p.AvoidMetered == p2.AvoidMetered &&
+		slices.Equal(p.MeteredInterfaces, p2.MeteredInterfaces) &&
+		slices.EqualFunc(p.Peers, p2.Peers, (*PeerRule).Equal)
+}
+
+// Equal reports whether r and r2 are equal.
+func (r *PeerRule) Equal(r2 *PeerRule) bool {
+	if r == nil || r2 == nil {
+		return r == r2
+	}
+	return slices.Equal(r.Match, r2.Match) &&
+		slices.Equal(r.DERPRegions, r2.DERPRegions) &&
+		slices.Equal(r.ForceRelay, r2.ForceRelay)
+}
@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package pathpolicy
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestRuleFor(t *testing.T) {
+	tagged := &PeerRule{Match: []string{"tag:eu"}, DERPRegions: []int{4}}
+	byName := &PeerRule{Match: []string{"db"}, ForceRelay: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	byID := &PeerRule{Match: []string{"nStable1"}, DERPRegions: []int{1}}
+	all := &PeerRule{Match: []string{"*"}}
+	p := &Policy{Peers: []*PeerRule{tagged, byName, byID, all}}
+
+	tests := []struct {
+		name string
+		node *tailcfg.Node
+		want *PeerRule
+	}{
+		{"tag", &tailcfg.Node{Name: "web.foo.ts.net.", Tags: []string{"tag:eu"}}, tagged},
+		{"host_label", &tailcfg.Node{Name: "db.foo.ts.net."}, byName},
+		{"host_label_case", &tailcfg.Node{Name: "DB.foo.ts.net."}, byName},
+		{"stable_id", &tailcfg.Node{Name: "x.foo.ts.net.", StableID: "nStable1"}, byID},
+		{"first_match_wins", &tailcfg.Node{Name: "db.foo.ts.net.", Tags: []string{"tag:eu"}}, tagged},
+		{"wildcard", &tailcfg.Node{Name: "other.foo.ts.net."}, all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.RuleFor(tt.node.View()); got != tt.want {
+				t.Errorf("RuleFor = %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	var nilPolicy *Policy
+	if got := nilPolicy.RuleFor((&tailcfg.Node{Name: "db.foo.ts.net."}).View()); got != nil {
+		t.Errorf("nil Policy RuleFor = %v; want nil", got)
+	}
+	fqdn := &Policy{Peers: []*PeerRule{{Match: []string{"db.foo.ts.net"}}}}
+	if fqdn.RuleFor((&tailcfg.Node{Name: "db.bar.ts.net."}).View()) != nil {
+		t.Error("FQDN rule matched node in other tailnet")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       *Policy
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"zero", &Policy{}, false},
+		{"full", &Policy{
+			PreferDERPRegion:  1,
+			AvoidDERPRegions:  []int{2},
+			AvoidMetered:      true,
+			MeteredInterfaces: []string{"wwan*"},
+			Peers: []*PeerRule{
+				{Match: []string{"tag:eu", "db"}, DERPRegions: []int{4}, ForceRelay: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+			},
+		}, false},
+		{"negative_prefer", &Policy{PreferDERPRegion: -1}, true},
+		{"prefer_and_avoid", &Policy{PreferDERPRegion: 1, AvoidDERPRegions: []int{1}}, true},
+		{"bad_pattern", &Policy{MeteredInterfaces: []string{"["}}, true},
+		{"nil_rule", &Policy{Peers: []*PeerRule{nil}}, true},
+		{"no_match", &Policy{Peers: []*PeerRule{{DERPRegions: []int{1}}}}, true},
+		{"bad_tag", &Policy{Peers: []*PeerRule{{Match: []string{"tag:"}}}}, true},
+		{"bad_region", &Policy{Peers: []*PeerRule{{Match: []string{"*"}, DERPRegions: []int{0}}}}, true},
+		{"bad_prefix", &Policy{Peers: []*PeerRule{{Match: []string{"*"}, ForceRelay: []netip.Prefix{{}}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPeerRuleAllows(t *testing.T) {
+	var nilRule *PeerRule
+	if !nilRule.AllowsDERPRegion(1) || !nilRule.AllowsDirect() || !nilRule.AllowsRelay(netip.MustParseAddr("1.2.3.4")) {
+		t.Error("nil rule disallows something")
+	}
+
+	r := &PeerRule{
+		Match:       []string{"*"},
+		DERPRegions: []int{1, 2},
+		ForceRelay:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	if !r.AllowsDERPRegion(2) || r.AllowsDERPRegion(3) {
+		t.Error("wrong AllowsDERPRegion")
+	}
+	if r.AllowsDirect() {
+		t.Error("AllowsDirect = true with ForceRelay")
+	}
+	if !r.AllowsRelay(netip.MustParseAddr("10.1.2.3")) || r.AllowsRelay(netip.MustParseAddr("1.2.3.4")) {
+		t.Error("wrong AllowsRelay")
+	}
+	if got, want := r.String(), "derp=1,2 relay-only=10.0.0.0/8"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+}
+
+func TestIsMeteredInterface(t *testing.T) {
+	p := &Policy{MeteredInterfaces: []string{"wwan*", "usb0"}}
+	for ifName, want := range map[string]bool{
+		"wwan0": true,
+		"usb0":  true,
+		"usb1":  false,
+		"eth0":  false,
+		"":      false,
+	} {
+		if got := p.IsMeteredInterface(ifName); got != want {
+			t.Errorf("IsMeteredInterface(%q) = %v; want %v", ifName, got, want)
+		}
+	}
+}
+
+func TestCloneEqual(t *testing.T) {
+	p := &Policy{
+		PreferDERPRegion: 1,
+		AvoidDERPRegions: []int{2},
+		Peers:            []*PeerRule{{Match: []string{"*"}, DERPRegions: []int{3}}},
+	}
+	c := p.Clone()
+	if !p.Equal(c) {
+		t.Fatal("clone not equal")
+	}
+	c.Peers[0].DERPRegions[0] = 4
+	if p.Equal(c) {
+		t.Error("mutated clone still equal")
+	}
+	if p.Peers[0].DERPRegions[0] != 3 {
+		t.Error("clone aliases original")
+	}
+}
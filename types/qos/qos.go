@@ -0,0 +1,146 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package qos defines policy for shaping and marking the traffic a node
+// sends to its peers.
+package qos
+
+//go:generate go run tailscale.com/cmd/viewer --type=Policy,Rule --clonefunc=true
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"tailscale.com/types/pathpolicy"
+)
+
+// Policy configures the shaping and marking of packets this node sends to
+// its peers. The zero value leaves all traffic alone.
+type Policy struct {
+	// MaxRate, if non-zero, limits the total rate, in bits per second, at
+	// which this node sends traffic to its peers. Traffic beyond it is
+	// queued, and higher priority traffic is sent first.
+	MaxRate uint64 `json:",omitempty"`
+
+	// Rules classify outgoing traffic by destination. If a packet's
+	// destination matches several rules, the rule with the most specific
+	// matching prefix applies, or the first such rule for equally specific
+	// ones. Traffic that matches no rule has normal priority and is only
+	// limited by MaxRate.
+	Rules []*Rule `json:",omitempty"`
+}
+
+// Rule shapes and marks traffic to the destinations it matches.
+type Rule struct {
+	// Peers are the peers whose traffic the rule applies to, in the syntax
+	// of [pathpolicy.PeerRule.Match]. A peer's traffic is the traffic to
+	// its Tailscale IPs and to the subnet routes it's the primary router
+	// for, but not traffic through it as an exit node. Each matching peer
+	// is limited to Rate separately.
+	Peers []string `json:",omitempty"`
+
+	// Routes are destination prefixes, typically peers' subnet routes,
+	// whose traffic the rule applies to. All of them share one Rate.
+	Routes []netip.Prefix `json:",omitempty"`
+
+	// Rate, if non-zero, is the rate, in bits per second, to limit matching
+	// traffic to. Traffic beyond it is queued, up to a limit, and then
+	// dropped.
+	Rate uint64 `json:",omitempty"`
+
+	// Burst, if non-zero, is how many bytes may be sent at once in excess
+	// of Rate after a period of less traffic. If zero, a default based on
+	// Rate is used.
+	Burst int `json:",omitempty"`
+
+	// DSCP, if non-zero, is the Differentiated Services code point (0-63)
+	// to set in the IP header of matching packets, for the use of
+	// networks beyond the receiving peer.
+	DSCP uint8 `json:",omitempty"`
+
+	// Priority is the priority of matching traffic when it's queued
+	// because of Policy.MaxRate. The empty value means PriorityNormal.
+	Priority Priority `json:",omitempty"`
+}
+
+// Priority is the priority of traffic queued for sending.
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// Valid reports whether p is a known priority or empty.
+func (p Priority) Valid() bool {
+	switch p {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return true
+	}
+	return false
+}
+
+// Validate reports whether p is well-formed.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for i, r := range p.Rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("Rules[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r == nil {
+		return errors.New("nil rule")
+	}
+	if len(r.Peers) == 0 && len(r.Routes) == 0 {
+		return errors.New("no peers or routes to match")
+	}
+	if err := pathpolicy.CheckPatterns(r.Peers); err != nil {
+		return err
+	}
+	for _, pfx := range r.Routes {
+		if !pfx.IsValid() {
+			return errors.New("invalid route")
+		}
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("invalid Burst %d", r.Burst)
+	}
+	if r.DSCP > 63 {
+		return fmt.Errorf("invalid DSCP %d", r.DSCP)
+	}
+	if !r.Priority.Valid() {
+		return fmt.Errorf("invalid Priority %q", r.Priority)
+	}
+	return nil
+}
+
+// Equal reports whether p and p2 are equal.
+func (p *Policy) Equal(p2 *Policy) bool {
+	if p == nil || p2 == nil {
+		return p == p2
+	}
+	return p.MaxRate == p2.MaxRate &&
+		slices.EqualFunc(p.Rules, p2.Rules, (*Rule).Equal)
+}
+
+// Equal reports whether r and r2 are equal.
+func (r *Rule) Equal(r2 *Rule) bool {
+	if r == nil || r2 == nil {
+		return r == r2
+	}
+	return slices.Equal(r.Peers, r2.Peers) &&
+		slices.Equal(r.Routes, r2.Routes) &&
+		r.Rate == r2.Rate &&
+		r.Burst == r2.Burst &&
+		r.DSCP == r2.DSCP &&
+		r.Priority == r2.Priority
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package qos
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       *Policy
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"zero", &Policy{}, false},
+		{"full", &Policy{
+			MaxRate: 50e6,
+			Rules: []*Rule{
+				{Peers: []string{"tag:db", "web"}, Rate: 10e6, Burst: 1 << 16, Priority: PriorityLow},
+				{Routes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, DSCP: 46, Priority: PriorityHigh},
+			},
+		}, false},
+		{"nil_rule", &Policy{Rules: []*Rule{nil}}, true},
+		{"no_match", &Policy{Rules: []*Rule{{Rate: 1}}}, true},
+		{"bad_tag", &Policy{Rules: []*Rule{{Peers: []string{"tag:"}}}}, true},
+		{"bad_route", &Policy{Rules: []*Rule{{Routes: []netip.Prefix{{}}}}}, true},
+		{"bad_burst", &Policy{Rules: []*Rule{{Peers: []string{"*"}, Burst: -1}}}, true},
+		{"bad_dscp", &Policy{Rules: []*Rule{{Peers: []string{"*"}, DSCP: 64}}}, true},
+		{"bad_priority", &Policy{Rules: []*Rule{{Peers: []string{"*"}, Priority: "urgent"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloneEqual(t *testing.T) {
+	p := &Policy{
+		MaxRate: 1e6,
+		Rules:   []*Rule{{Peers: []string{"*"}, Rate: 1e5}},
+	}
+	c := p.Clone()
+	if !p.Equal(c) {
+		t.Fatal("clone not equal")
+	}
+	c.Rules[0].Peers[0] = "tag:x"
+	if p.Equal(c) {
+		t.Error("mutated clone still equal")
+	}
+	if p.Rules[0].Peers[0] != "*" {
+		t.Error("clone aliases original")
+	}
+}
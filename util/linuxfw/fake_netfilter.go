@@ -7,8 +7,10 @@
 
 import (
 	"net/netip"
+	"slices"
 
 	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
 )
 
 // FakeNetfilterRunner is a fake netfilter runner for tests.
@@ -19,6 +21,9 @@ type FakeNetfilterRunner struct {
 		TailscaleServiceIP netip.Addr
 		ClusterIP          netip.Addr
 	}
+	// weighted tracks the targets with a non-zero weight that were set via
+	// EnsureWeightedPortMapRulesForSvc, keyed by service and portmap.
+	weighted map[string]map[PortMap][]WeightedTarget
 }
 
 // NewFakeNetfilterRunner creates a new FakeNetfilterRunner.
@@ -88,8 +93,45 @@ func (f *FakeNetfilterRunner) DeletePortMapRuleForSvc(svc, tun string, targetIP
 	return nil
 }
 func (f *FakeNetfilterRunner) DeleteSvc(svc, tun string, targetIPs []netip.Addr, pms []PortMap) error {
+	delete(f.weighted, svc)
 	return nil
 }
 func (f *FakeNetfilterRunner) EnsurePortMapRuleForSvc(svc, tun string, targetIP netip.Addr, pm PortMap) error {
 	return nil
 }
+
+func (f *FakeNetfilterRunner) EnsureWeightedPortMapRulesForSvc(svc, tun string, targets []WeightedTarget, pm PortMap) error {
+	cur := f.weighted[svc][pm]
+	for _, is6 := range []bool{false, true} {
+		if !slices.ContainsFunc(targets, func(t WeightedTarget) bool { return t.IP.Is6() == is6 }) {
+			continue
+		}
+		// Replace the targets of each IP family present in targets.
+		cur = slices.DeleteFunc(cur, func(t WeightedTarget) bool { return t.IP.Is6() == is6 })
+		for _, t := range targets {
+			if t.IP.Is6() == is6 && t.Weight > 0 {
+				cur = append(cur, t)
+			}
+		}
+	}
+	if len(cur) == 0 {
+		delete(f.weighted[svc], pm)
+		return nil
+	}
+	if f.weighted[svc] == nil {
+		mak.Set(&f.weighted, svc, make(map[PortMap][]WeightedTarget))
+	}
+	f.weighted[svc][pm] = cur
+	return nil
+}
+
+func (f *FakeNetfilterRunner) DeleteWeightedPortMapRulesForSvc(svc, tun string, pm PortMap) error {
+	delete(f.weighted[svc], pm)
+	return nil
+}
+
+// GetWeightedPortMapRules returns the targets with a non-zero weight that
+// traffic for the given service and portmap is load balanced across.
+func (f *FakeNetfilterRunner) GetWeightedPortMapRules(svc string) map[PortMap][]WeightedTarget {
+	return f.weighted[svc]
+}
@@ -6,10 +6,12 @@
 package linuxfw
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net/netip"
 	"reflect"
+	"slices"
 	"strings"
 
 	"github.com/google/nftables"
@@ -307,3 +309,249 @@ func protoFromString(s string) (uint8, error) {
 func svcRuleMeta(svcName string, origDst, dst netip.Addr) []byte {
 	return []byte(fmt.Sprintf("svc:%s,VIP:%s,ClusterIP:%s", svcName, origDst.String(), dst.String()))
 }
+
+// WeightedTarget is a tailnet target that a load balanced service forwards a
+// share of new connections to.
+type WeightedTarget struct {
+	// IP is the tailnet IP of the target.
+	IP netip.Addr
+	// Weight is the relative share of new connections that the target
+	// receives. A target with weight 0 does not receive any new connections.
+	Weight uint32
+}
+
+// WeightedPortMapper is implemented by NetfilterRunners that can load balance
+// traffic for a service across multiple weighted tailnet targets. Currently
+// (10/2026) only the nftables runner implements it.
+type WeightedPortMapper interface {
+	// EnsureWeightedPortMapRulesForSvc ensures that new connections matching
+	// the given portmap are DNATed to the given targets in proportion to
+	// their weights. Targets can be of both IP families; the rules for each
+	// IP family present in targets are replaced atomically. Passing the
+	// previous targets of an IP family with weight 0 removes its rules.
+	EnsureWeightedPortMapRulesForSvc(svc, tun string, targets []WeightedTarget, pm PortMap) error
+	// DeleteWeightedPortMapRulesForSvc deletes the rules created by
+	// EnsureWeightedPortMapRulesForSvc for the given portmap for both IP
+	// families.
+	DeleteWeightedPortMapRulesForSvc(svc, tun string, pm PortMap) error
+}
+
+var _ WeightedPortMapper = (*nftablesRunner)(nil)
+
+// EnsureWeightedPortMapRulesForSvc implements [WeightedPortMapper].
+//
+// Each target with a non-zero weight gets a rule in the service's chain for its
+// IP family. The rules are evaluated in order and each one uses a random number
+// generated by numgen to match its target's share of the connections not
+// matched by previous rules. For example, for targets with weights 3, 1 and 1,
+// the first rule matches 3/5 of new connections, the second one 1/2 of the
+// remaining ones and the last one all the rest.
+//
+// Netfilter only evaluates NAT rules for the first packet of a connection, so
+// established connections keep their mapping in conntrack when the targets
+// change.
+func (n *nftablesRunner) EnsureWeightedPortMapRulesForSvc(svc, tun string, targets []WeightedTarget, pm PortMap) error {
+	p, err := protoFromString(pm.Protocol)
+	if err != nil {
+		return fmt.Errorf("error converting protocol %s: %w", pm.Protocol, err)
+	}
+	var v4, v6 []WeightedTarget
+	for _, t := range targets {
+		if t.IP.Is6() {
+			v6 = append(v6, t)
+		} else {
+			v4 = append(v4, t)
+		}
+	}
+	var changed bool
+	for _, ts := range [][]WeightedTarget{v4, v6} {
+		if len(ts) == 0 {
+			continue
+		}
+		c, err := n.replaceWeightedPortMapRules(svc, tun, ts, pm, p)
+		if err != nil {
+			return err
+		}
+		changed = changed || c
+	}
+	if !changed {
+		return nil
+	}
+	return n.conn.Flush()
+}
+
+// DeleteWeightedPortMapRulesForSvc implements [WeightedPortMapper].
+func (n *nftablesRunner) DeleteWeightedPortMapRulesForSvc(svc, tun string, pm PortMap) error {
+	addrs := []netip.Addr{netip.IPv4Unspecified()}
+	if n.v6Available {
+		addrs = append(addrs, netip.IPv6Unspecified())
+	}
+	var changed bool
+	for _, addr := range addrs {
+		t, ch, err := n.svcChainIfExists(svc, addr)
+		if err != nil {
+			return err
+		}
+		if ch == nil {
+			continue
+		}
+		rules, err := n.weightedPortMapRules(t, ch, svc, pm)
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			if err := n.conn.DelRule(r); err != nil {
+				return fmt.Errorf("error deleting rule: %w", err)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return n.conn.Flush()
+}
+
+// replaceWeightedPortMapRules queues the changes needed to replace the
+// weighted portmapping rules for the IP family of targets. It reports whether
+// any changes were queued; the caller is expected to flush them.
+func (n *nftablesRunner) replaceWeightedPortMapRules(svc, tun string, targets []WeightedTarget, pm PortMap, proto uint8) (bool, error) {
+	var total uint32
+	for _, t := range targets {
+		total += t.Weight
+	}
+	var (
+		table *nftables.Table
+		ch    *nftables.Chain
+		err   error
+	)
+	if total == 0 {
+		// Don't create a chain just to not add any rules to it.
+		table, ch, err = n.svcChainIfExists(svc, targets[0].IP)
+		if err != nil || ch == nil {
+			return false, err
+		}
+	} else {
+		table, ch, err = n.ensureChainForSvc(svc, targets[0].IP)
+		if err != nil {
+			return false, fmt.Errorf("error ensuring chain for %s: %w", svc, err)
+		}
+	}
+
+	var want []*nftables.Rule
+	remaining := total
+	for _, t := range targets {
+		if t.Weight == 0 {
+			continue
+		}
+		meta := svcWeightedPortMapRuleMeta(svc, t, remaining, pm)
+		want = append(want, weightedPortMapRule(table, ch, tun, t, remaining, pm, proto, meta))
+		remaining -= t.Weight
+	}
+	got, err := n.weightedPortMapRules(table, ch, svc, pm)
+	if err != nil {
+		return false, err
+	}
+	if slices.EqualFunc(got, want, func(r, r1 *nftables.Rule) bool { return bytes.Equal(r.UserData, r1.UserData) }) {
+		return false, nil
+	}
+	for _, r := range got {
+		if err := n.conn.DelRule(r); err != nil {
+			return false, fmt.Errorf("error deleting rule: %w", err)
+		}
+	}
+	for _, r := range want {
+		n.conn.AddRule(r)
+	}
+	return true, nil
+}
+
+// weightedPortMapRules returns the weighted portmapping rules for the given
+// service and portmap in ch, in the order that they are evaluated.
+func (n *nftablesRunner) weightedPortMapRules(t *nftables.Table, ch *nftables.Chain, svc string, pm PortMap) ([]*nftables.Rule, error) {
+	rules, err := n.conn.GetRules(t, ch)
+	if err != nil {
+		return nil, fmt.Errorf("error listing rules: %w", err)
+	}
+	prefix := svcWeightedPortMapRuleMetaPrefix(svc, pm)
+	var ret []*nftables.Rule
+	for _, r := range rules {
+		if bytes.HasPrefix(r.UserData, prefix) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// svcChainIfExists returns the nat table and the chain for the given service
+// and the IP family of addr, or a nil chain if either does not exist.
+func (n *nftablesRunner) svcChainIfExists(svc string, addr netip.Addr) (*nftables.Table, *nftables.Chain, error) {
+	table, err := n.getNFTByAddr(addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error setting up nftables for IP family of %s: %w", addr, err)
+	}
+	t, err := getTableIfExists(n.conn, table.Proto, "nat")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking if nat table exists: %w", err)
+	}
+	if t == nil {
+		return nil, nil, nil
+	}
+	ch, err := getChainFromTable(n.conn, t, svc)
+	if errors.Is(err, errorChainNotFound{t.Name, svc}) {
+		return t, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking if chain %s exists: %w", svc, err)
+	}
+	return t, ch, nil
+}
+
+// weightedPortMapRule returns a portmapping rule that DNATs a target.Weight
+// out of remaining share of the matching new connections to target. The
+// share is determined by comparing a random number in [0, remaining) with the
+// target's weight.
+func weightedPortMapRule(t *nftables.Table, ch *nftables.Chain, tun string, target WeightedTarget, remaining uint32, pm PortMap, proto uint8, meta []byte) *nftables.Rule {
+	rule := portMapRule(t, ch, tun, target.IP, pm.MatchPort, pm.TargetPort, proto, meta)
+	if target.Weight >= remaining {
+		// The last target matches all remaining connections.
+		return rule
+	}
+	// Insert the random match before the DNAT statement, which is made up
+	// of the last three expressions.
+	i := len(rule.Exprs) - 3
+	match := []expr.Any{
+		&expr.Numgen{
+			Register: 1,
+			Modulus:  remaining,
+			Type:     unix.NFT_NG_RANDOM,
+		},
+		// numgen stores the number in host byte order, whereas cmp
+		// compares bytes in network byte order.
+		&expr.Byteorder{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Op:             expr.ByteorderHton,
+			Len:            4,
+			Size:           4,
+		},
+		&expr.Cmp{
+			Op:       expr.CmpOpLt,
+			Register: 1,
+			Data:     binaryutil.BigEndian.PutUint32(target.Weight),
+		},
+	}
+	rule.Exprs = slices.Insert(rule.Exprs, i, match...)
+	return rule
+}
+
+// svcWeightedPortMapRuleMeta generates metadata for a weighted portmapping
+// rule. It includes the target's weight and the remaining total weight, so that
+// a rule changes whenever the share of connections that it matches changes.
+func svcWeightedPortMapRuleMeta(svc string, target WeightedTarget, remaining uint32, pm PortMap) []byte {
+	return fmt.Appendf(svcWeightedPortMapRuleMetaPrefix(svc, pm), "targetIP:%s,weight:%d/%d", target.IP, target.Weight, remaining)
+}
+
+func svcWeightedPortMapRuleMetaPrefix(svc string, pm PortMap) []byte {
+	return []byte(fmt.Sprintf("svc:%s,weighted,matchPort:%v,targetPort:%v,proto:%v,", svc, pm.MatchPort, pm.TargetPort, pm.Protocol))
+}
@@ -7,9 +7,14 @@
 
 import (
 	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
 )
 
 // This test creates a temporary network namespace for the nftables rules being
@@ -235,6 +240,101 @@ func checkDNATRule(t *testing.T, svc string, origDst, targetIP netip.Addr, runne
 
 // svcChains verifies that the expected number of chains exist (for either IP
 // family) and that each of them is configured as NAT prerouting chain.
+func Test_nftablesRunner_EnsureWeightedPortMapRulesForSvc(t *testing.T) {
+	conn := newSysConn(t)
+	runner := newFakeNftablesRunnerWithConn(t, conn, true)
+	ip1, ip2, ip3 := netip.MustParseAddr("100.99.99.1"), netip.MustParseAddr("100.99.99.2"), netip.MustParseAddr("100.99.99.3")
+	ipv6 := netip.MustParseAddr("fd7a:115c:a1e0::701:b62a")
+	pmTCP := PortMap{MatchPort: 4003, TargetPort: 80, Protocol: "TCP"}
+	pmUDP := PortMap{MatchPort: 4053, TargetPort: 53, Protocol: "UDP"}
+
+	ensure := func(pm PortMap, targets ...WeightedTarget) {
+		t.Helper()
+		if err := runner.EnsureWeightedPortMapRulesForSvc("svc:foo", "tailscale0", targets, pm); err != nil {
+			t.Fatalf("error ensuring weighted rules: %v", err)
+		}
+	}
+	// checkWeightedRules verifies the rules in the service chain, in the
+	// order that they get evaluated.
+	checkWeightedRules := func(fam nftables.TableFamily, pm PortMap, want ...string) {
+		t.Helper()
+		addr := ip1
+		if fam == nftables.TableFamilyIPv6 {
+			addr = ipv6
+		}
+		table, ch, err := runner.svcChainIfExists("svc:foo", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		if ch != nil {
+			rules, err := runner.weightedPortMapRules(table, ch, "svc:foo", pm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range rules {
+				got = append(got, strings.TrimPrefix(string(r.UserData), string(svcWeightedPortMapRuleMetaPrefix("svc:foo", pm))))
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("unexpected weighted rules, got %q, want %q", got, want)
+		}
+	}
+
+	// Weighted rules for two IPv4 targets and one IPv6 target.
+	ensure(pmTCP, WeightedTarget{ip1, 3}, WeightedTarget{ip2, 1}, WeightedTarget{ipv6, 1})
+	svcChains(t, 2, conn)
+	checkWeightedRules(nftables.TableFamilyIPv4, pmTCP, "targetIP:100.99.99.1,weight:3/4", "targetIP:100.99.99.2,weight:1/1")
+	checkWeightedRules(nftables.TableFamilyIPv6, pmTCP, "targetIP:fd7a:115c:a1e0::701:b62a,weight:1/1")
+	// The first rule matches 3 out of 4 new connections. The nftables
+	// library does not parse numgen expressions, so only check the
+	// comparison of the generated number.
+	table, ch, err := runner.svcChainIfExists("svc:foo", ip1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := runner.findRuleByMetadata(table, ch, svcWeightedPortMapRuleMeta("svc:foo", WeightedTarget{ip1, 3}, 4, pmTCP))
+	if err != nil || r == nil {
+		t.Fatalf("weighted rule not found: %v", err)
+	}
+	wantCmp := &expr.Cmp{Op: expr.CmpOpLt, Register: 1, Data: binaryutil.BigEndian.PutUint32(3)}
+	if !slices.ContainsFunc(r.Exprs, func(e expr.Any) bool { return reflect.DeepEqual(e, wantCmp) }) {
+		t.Fatalf("weighted rule %+v does not compare the generated number with the weight", r.Exprs)
+	}
+
+	// Ensuring the same rules again is a no-op.
+	ensure(pmTCP, WeightedTarget{ip1, 3}, WeightedTarget{ip2, 1}, WeightedTarget{ipv6, 1})
+	chainRuleCount(t, "svc:foo", 2, conn, nftables.TableFamilyIPv4)
+
+	// Rules for another portmap are independent.
+	ensure(pmUDP, WeightedTarget{ip3, 1})
+	chainRuleCount(t, "svc:foo", 3, conn, nftables.TableFamilyIPv4)
+	checkWeightedRules(nftables.TableFamilyIPv4, pmUDP, "targetIP:100.99.99.3,weight:1/1")
+
+	// A target with weight 0 stops getting new connections and a new target
+	// is added.
+	ensure(pmTCP, WeightedTarget{ip1, 0}, WeightedTarget{ip2, 1}, WeightedTarget{ip3, 2}, WeightedTarget{ipv6, 1})
+	checkWeightedRules(nftables.TableFamilyIPv4, pmTCP, "targetIP:100.99.99.2,weight:1/3", "targetIP:100.99.99.3,weight:2/2")
+	checkWeightedRules(nftables.TableFamilyIPv6, pmTCP, "targetIP:fd7a:115c:a1e0::701:b62a,weight:1/1")
+
+	// IPv6 target is removed.
+	ensure(pmTCP, WeightedTarget{ip2, 1}, WeightedTarget{ipv6, 0})
+	checkWeightedRules(nftables.TableFamilyIPv4, pmTCP, "targetIP:100.99.99.2,weight:1/1")
+	checkWeightedRules(nftables.TableFamilyIPv6, pmTCP)
+
+	// Deleting the rules for a portmap leaves other portmaps' rules alone.
+	if err := runner.DeleteWeightedPortMapRulesForSvc("svc:foo", "tailscale0", pmTCP); err != nil {
+		t.Fatal(err)
+	}
+	checkWeightedRules(nftables.TableFamilyIPv4, pmTCP)
+	checkWeightedRules(nftables.TableFamilyIPv4, pmUDP, "targetIP:100.99.99.3,weight:1/1")
+
+	if err := runner.DeleteSvc("svc:foo", "tailscale0", []netip.Addr{ip1, ipv6}, nil); err != nil {
+		t.Fatal(err)
+	}
+	svcChains(t, 0, conn)
+}
+
 func svcChains(t *testing.T, wantCount int, conn *nftables.Conn) {
 	t.Helper()
 	chains, err := conn.ListChains()
@@ -46,6 +46,10 @@ type Metrics struct {
 
 	// ReasonError means that the packet was dropped because of an error.
 	ReasonError DropReason = "error"
+
+	// ReasonRateLimit means that the packet was dropped because it exceeded a
+	// configured rate limit and too many packets were already queued.
+	ReasonRateLimit DropReason = "rate_limit"
 )
 
 // DropLabels contains common label(s) for dropped packet counters.
@@ -62,6 +62,16 @@
 	//
 	//lint:ignore U1000 used on Linux/Darwin only
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_PMTUD")
+	// debugNetcheckSTUNServers is a comma-separated list of additional STUN
+	// servers for netcheck to probe, as "host:port" or "host".
+	debugNetcheckSTUNServers = envknob.RegisterString("TS_DEBUG_NETCHECK_STUN_SERVERS")
+	// debugMultipath, if set, enables sending WireGuard packets over the
+	// interfaces in TS_DEBUG_MULTIPATH_INTERFACES as well as the default
+	// route's. It's one of "spread" or "duplicate".
+	debugMultipath = envknob.RegisterString("TS_DEBUG_MULTIPATH")
+	// debugMultipathInterfaces is a comma-separated list of the network
+	// interfaces to use when TS_DEBUG_MULTIPATH is set.
+	debugMultipathInterfaces = envknob.RegisterString("TS_DEBUG_MULTIPATH_INTERFACES")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the
 	// debugknobs_stubs.go file too.
 )
@@ -95,3 +105,27 @@ func inTest() bool { return envknob.Bool("IN_TS_TEST") }
 	}
 	return
 })
+
+// netcheckSTUNServers returns TS_DEBUG_NETCHECK_STUN_SERVERS as a list of
+// STUN servers, if set.
+func netcheckSTUNServers() []string {
+	var servers []string
+	for s := range strings.SplitSeq(debugNetcheckSTUNServers(), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// multipathInterfaces returns TS_DEBUG_MULTIPATH_INTERFACES as a list of
+// interface names, if set.
+func multipathInterfaces() []string {
+	var ifNames []string
+	for s := range strings.SplitSeq(debugMultipathInterfaces(), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ifNames = append(ifNames, s)
+		}
+	}
+	return ifNames
+}
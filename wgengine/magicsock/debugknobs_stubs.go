@@ -31,3 +31,6 @@ func debugRingBufferMaxSizeBytes() int { return 0 }
 func inTest() bool                     { return false }
 func debugPeerMap() bool               { return false }
 func pretendpoints() []netip.AddrPort  { return []netip.AddrPort{} }
+func netcheckSTUNServers() []string    { return nil }
+func debugMultipath() string           { return "" }
+func multipathInterfaces() []string    { return nil }
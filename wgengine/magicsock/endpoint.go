@@ -32,6 +32,7 @@
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/slicesx"
@@ -97,6 +98,14 @@ type endpoint struct {
 	expired         bool // whether the node has expired
 	isWireguardOnly bool // whether the endpoint is WireGuard only
 	relayCapable    bool // whether the node is capable of speaking via a [tailscale.com/net/udprelay.Server]
+
+	pathRule *pathpolicy.PeerRule // path policy rule for this peer; nil if unconstrained
+
+	// The following fields are related to sending over multiple uplinks;
+	// see multipathManager.
+	multipathPaths     map[string]*multipathPath // by uplink interface name; paths to bestAddr
+	multipathNext      uint                      // rotates which path is used in multipathSpread mode
+	lastMultipathProbe mono.Time                 // last time uplink paths were probed
 }
 
 // relayEndpointReady determines whether the given relay addr should be
@@ -109,7 +118,7 @@ func (de *endpoint) relayEndpointReady(addr epAddr, latency time.Duration) {
 	defer de.mu.Unlock()
 
 	maybeBetter := addrQuality{addr, latency, pingSizeToPktLen(0, addr)}
-	if !betterAddr(maybeBetter, de.bestAddr) {
+	if !de.betterAddrLocked(maybeBetter, de.bestAddr) {
 		return
 	}
 
@@ -959,6 +968,10 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 
 	now := mono.Now()
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
+	rule := de.pathRule
+	if derpAddr.IsValid() && !rule.AllowsDERPRegion(int(derpAddr.Port())) {
+		derpAddr = netip.AddrPort{}
+	}
 
 	if de.isWireguardOnly {
 		if startWGPing {
@@ -967,6 +980,12 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 	} else if !udpAddr.ap.IsValid() || now.After(de.trustBestAddrUntil) {
 		de.sendDiscoPingsLocked(now, true)
 	}
+	var multipathIfNames []string
+	sendMain := true
+	if mode := de.c.multipath.currentMode(); mode != multipathOff && udpAddr.ap.IsValid() && !udpAddr.vni.isSet() {
+		de.maybeProbeMultipathLocked(now, udpAddr.ap)
+		multipathIfNames, sendMain = de.multipathSendLocked(mode, now, udpAddr.ap)
+	}
 	de.noteTxActivityExtTriggerLocked(now)
 	de.lastSendAny = now
 	de.mu.Unlock()
@@ -976,14 +995,14 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 		// they contacted us over DERP and we don't know their UDP endpoints or
 		// their DERP home, we can at least assume they're reachable over the
 		// DERP they used to contact us.
-		if rid := de.c.fallbackDERPRegionForPeer(de.publicKey); rid != 0 {
+		if rid := de.c.fallbackDERPRegionForPeer(de.publicKey); rid != 0 && rule.AllowsDERPRegion(rid) {
 			derpAddr = netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(rid))
 		} else {
 			return errNoUDPOrDERP
 		}
 	}
 	var err error
-	if udpAddr.ap.IsValid() {
+	if udpAddr.ap.IsValid() && sendMain {
 		_, err = de.c.sendUDPBatch(udpAddr, buffs, offset)
 
 		// If the error is known to indicate that the endpoint is no longer
@@ -1012,6 +1031,13 @@ func (de *endpoint) send(buffs [][]byte, offset int) error {
 			stats.UpdateTxPhysical(de.nodeAddr, udpAddr.ap, len(buffs), txBytes)
 		}
 	}
+	for _, ifName := range multipathIfNames {
+		// Failures are only reported if no other path was used; otherwise
+		// the uplink's path will fail its next probes and stop being used.
+		if mpErr := de.c.multipath.send(ifName, udpAddr.ap, buffs, offset); mpErr != nil && !sendMain && len(multipathIfNames) == 1 {
+			err = mpErr
+		}
+	}
 	if derpAddr.IsValid() {
 		allOk := true
 		var txBytes int
@@ -1520,6 +1546,7 @@ func (de *endpoint) clearBestAddrLocked() {
 	de.setBestAddrLocked(addrQuality{})
 	de.bestAddrAt = 0
 	de.trustBestAddrUntil = 0
+	clear(de.multipathPaths)
 }
 
 // noteBadEndpoint marks udpAddr as a bad endpoint that would need to be
@@ -1666,7 +1693,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src epAdd
 	// TODO(bradfitz): decide how latency vs. preference order affects decision
 	if !isDerp {
 		thisPong := addrQuality{sp.to, latency, tstun.WireMTU(pingSizeToPktLen(sp.size, sp.to))}
-		if betterAddr(thisPong, de.bestAddr) {
+		if de.betterAddrLocked(thisPong, de.bestAddr) {
 			if src.vni.isSet() {
 				// This would be unexpected. Switching to a Geneve-encapsulated
 				// path should only happen in de.relayEndpointReady().
@@ -1882,6 +1909,7 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	defer de.mu.Unlock()
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
+	ps.PathPolicy = de.pathRule.String()
 
 	if de.lastSendExt.IsZero() {
 		return
@@ -55,6 +55,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/pathpolicy"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/eventbus"
@@ -198,6 +199,10 @@ type Conn struct {
 	// It must have buffer size > 0; see issue 3736.
 	derpRecvCh chan derpReadResult
 
+	// multipath sends and receives packets over additional uplinks,
+	// if enabled with SetMultipath.
+	multipath *multipathManager
+
 	// bind is the wireguard-go conn.Bind for Conn.
 	bind *connBind
 
@@ -350,6 +355,7 @@ type Conn struct {
 
 	derpMap          *tailcfg.DERPMap              // nil (or zero regions/nodes) means DERP is disabled
 	peers            views.Slice[tailcfg.NodeView] // from last onNodeViewsUpdate update
+	pathPolicy       *pathpolicy.Policy            // from last SetPathPolicy; nil means unconstrained
 	lastFlags        debugFlags                    // at time of last onNodeViewsUpdate
 	firstAddrForTest netip.Addr                    // from last onNodeViewsUpdate update; for tests only
 	privateKey       key.NodePrivate               // WireGuard private key for this node
@@ -551,6 +557,7 @@ func newConn(logf logger.Logf) *Conn {
 		cloudInfo:    newCloudInfo(logf),
 	}
 	c.discoShort = c.discoPublic.ShortString()
+	c.multipath = newMultipathManager(c)
 	c.bind = &connBind{Conn: c, closed: true}
 	c.receiveBatchPool = sync.Pool{New: func() any {
 		msgs := make([]ipv6.Message, c.bind.BatchSize())
@@ -582,8 +589,12 @@ func (c *Conn) consumeEventbusTopics() {
 		select {
 		case <-c.pmSub.Done():
 			return
-		case <-c.pmSub.Events():
-			c.onPortMapChanged()
+		case pm := <-c.pmSub.Events():
+			// Mappings requested with AddMapping are for other
+			// services' ports, and don't change our endpoints.
+			if pm.Key == (portmapper.MappingKey{}) {
+				c.onPortMapChanged()
+			}
 		case filterUpdate := <-c.filterSub.Events():
 			c.onFilterUpdate(filterUpdate)
 		case nodeViews := <-c.nodeViewsSub.Events():
@@ -658,6 +669,7 @@ func NewConn(opts Options) (*Conn, error) {
 		SkipExternalNetwork: inTest(),
 		PortMapper:          c.portMapper,
 		UseDNSCache:         true,
+		STUNServers:         netcheckSTUNServers(),
 	}
 
 	c.metrics = registerMetrics(opts.Metrics)
@@ -675,6 +687,12 @@ func NewConn(opts Options) (*Conn, error) {
 		c.logf("[v1] couldn't create raw v6 disco listener, using regular listener instead: %v", err)
 	}
 
+	if mode := debugMultipath(); mode != "" {
+		if err := c.SetMultipath(mode, multipathInterfaces()); err != nil {
+			c.logf("magicsock: multipath: %v", err)
+		}
+	}
+
 	c.logf("magicsock: disco key = %v", c.discoShort)
 	return c, nil
 }
@@ -999,6 +1017,14 @@ func (c *Conn) addValidDiscoPathForTest(nodeKey key.NodePublic, addr netip.AddrP
 	c.peerMap.setNodeKeyForEpAddr(epAddr{ap: addr}, nodeKey)
 }
 
+// SetNetcheckHistory sets the History that the Conn's netcheck reports are
+// recorded to, or nil to stop recording them.
+//
+// This is called by LocalBackend.
+func (c *Conn) SetNetcheckHistory(h *netcheck.History) {
+	c.netChecker.SetHistory(h)
+}
+
 // SetNetInfoCallback sets the func to be called whenever the network conditions
 // change.
 //
@@ -2060,6 +2086,9 @@ func (c *Conn) handleDiscoMessage(msg []byte, src epAddr, shouldBeRelayHandshake
 		c.handlePingLocked(dm, src, di, derpNodeSrc)
 	case *disco.Pong:
 		metricRecvDiscoPong.Add(1)
+		if c.multipath.handlePong(dm) {
+			return
+		}
 		// There might be multiple nodes for the sender's DiscoKey.
 		// Ask each to handle it, stopping once one reports that
 		// the Pong's TxID was theirs.
@@ -2642,6 +2671,7 @@ func (c *Conn) onNodeViewsUpdate(update NodeViewsUpdate) {
 				oldDiscoKey = epDisco.key
 			}
 			ep.updateFromNode(n, flags.heartbeatDisabled, flags.probeUDPLifetimeOn)
+			ep.setPathRule(c.pathPolicy.RuleFor(n))
 			c.peerMap.upsertEndpoint(ep, oldDiscoKey) // maybe update discokey mappings in peerMap
 			continue
 		}
@@ -2702,6 +2732,7 @@ func (c *Conn) onNodeViewsUpdate(update NodeViewsUpdate) {
 		}
 
 		ep.updateFromNode(n, flags.heartbeatDisabled, flags.probeUDPLifetimeOn)
+		ep.setPathRule(c.pathPolicy.RuleFor(n))
 		c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
 	}
 
@@ -2818,7 +2849,7 @@ func (c *connBind) Open(ignoredPort uint16) ([]conn.ReceiveFunc, uint16, error)
 		return nil, 0, errors.New("magicsock: connBind already open")
 	}
 	c.closed = false
-	fns := []conn.ReceiveFunc{c.receiveIPv4(), c.receiveIPv6(), c.receiveDERP}
+	fns := []conn.ReceiveFunc{c.receiveIPv4(), c.receiveIPv6(), c.receiveDERP, c.receiveMultipath()}
 	if runtime.GOOS == "js" {
 		fns = []conn.ReceiveFunc{c.receiveDERP}
 	}
@@ -2858,6 +2889,12 @@ func (c *connBind) Close() error {
 	// which will then check connBind.Closed.
 	// connBind.Closed takes c.mu, but c.derpRecvCh is buffered.
 	c.derpRecvCh <- derpReadResult{}
+	// Likewise for receiveMultipath, unless it already has a packet to
+	// wake it up with.
+	select {
+	case c.multipath.recvCh <- multipathReadResult{}:
+	default:
+	}
 	return nil
 }
 
@@ -2903,6 +2940,7 @@ func (c *Conn) Close() error {
 	c.closed = true
 	c.connCtxCancel()
 	c.closeAllDerpLocked("conn-close")
+	c.multipath.close()
 	// Ignore errors from c.pconnN.Close.
 	// They will frequently have been closed already by a call to connBind.Close.
 	c.pconn6.Close()
@@ -3173,6 +3211,7 @@ func (c *Conn) Rebind() {
 	if len(ifIPs) > 0 {
 		c.maybeCloseDERPsOnRebind(ifIPs)
 	}
+	c.multipath.rebind()
 	c.resetEndpointStates()
 }
 
@@ -3496,6 +3535,8 @@ type discoInfo struct {
 	metricSendDERPErrorQueue  = clientmetric.NewCounter("magicsock_send_derp_error_queue")
 	metricSendUDP             = clientmetric.NewAggregateCounter("magicsock_send_udp")
 	metricSendUDPError        = clientmetric.NewCounter("magicsock_send_udp_error")
+	metricSendMultipath       = clientmetric.NewCounter("magicsock_send_multipath")
+	metricSendMultipathError  = clientmetric.NewCounter("magicsock_send_multipath_error")
 	metricSendDERP            = clientmetric.NewAggregateCounter("magicsock_send_derp")
 	metricSendDERPError       = clientmetric.NewCounter("magicsock_send_derp_error")
 
@@ -3505,6 +3546,7 @@ type discoInfo struct {
 	metricRecvDataPacketsDERP = clientmetric.NewAggregateCounter("magicsock_recv_data_derp")
 	metricRecvDataPacketsIPv4 = clientmetric.NewAggregateCounter("magicsock_recv_data_ipv4")
 	metricRecvDataPacketsIPv6 = clientmetric.NewAggregateCounter("magicsock_recv_data_ipv6")
+	metricRecvMultipath       = clientmetric.NewCounter("magicsock_recv_multipath")
 
 	// Disco packets
 	metricSendDiscoUDP               = clientmetric.NewCounter("magicsock_disco_send_udp")
@@ -0,0 +1,534 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tailscale/wireguard-go/conn"
+	"tailscale.com/disco"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun"
+	"tailscale.com/tstime/mono"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+)
+
+// multipathMode is how WireGuard packets to a peer are sent when the peer
+// can be reached over more than one uplink.
+type multipathMode uint32
+
+const (
+	// multipathOff sends each packet over the best path only.
+	multipathOff multipathMode = iota
+
+	// multipathSpread sends each batch of packets over one of the usable
+	// paths, rotating between them.
+	multipathSpread
+
+	// multipathDuplicate sends each packet over every usable path, trading
+	// bandwidth for resilience to loss on any one of them.
+	multipathDuplicate
+)
+
+func (m multipathMode) String() string {
+	switch m {
+	case multipathOff:
+		return "off"
+	case multipathSpread:
+		return "spread"
+	case multipathDuplicate:
+		return "duplicate"
+	}
+	return fmt.Sprintf("multipathMode(%d)", uint32(m))
+}
+
+func parseMultipathMode(s string) (multipathMode, error) {
+	switch s {
+	case "", "off":
+		return multipathOff, nil
+	case "spread":
+		return multipathSpread, nil
+	case "duplicate":
+		return multipathDuplicate, nil
+	}
+	return multipathOff, fmt.Errorf("unknown multipath mode %q; want off, spread, or duplicate", s)
+}
+
+const (
+	// multipathProbeInterval is how often, while sending to a peer, each
+	// uplink's path to the peer is probed with a disco ping.
+	multipathProbeInterval = 2 * time.Second
+
+	// multipathMaxLoss is the fraction of recent probes over an uplink that
+	// may go unanswered before the uplink stops being used for a peer.
+	multipathMaxLoss = 0.25
+
+	// multipathMaxSkew is how much slower than the fastest path a path may
+	// be and still be used in multipathSpread mode. It bounds how far out
+	// of order packets can arrive; WireGuard's replay window tolerates far
+	// more, but the transports running over it degrade with reordering.
+	multipathMaxSkew = 30 * time.Millisecond
+)
+
+// multipathManager sends and receives WireGuard packets over uplinks: network
+// interfaces other than the one with the default route, each with its own
+// UDP socket bound to it, used in addition to the Conn's own sockets.
+//
+// The paths to a peer over each uplink are validated and measured with disco
+// pings to the peer's best address, and only paths with recent replies and
+// low loss are used.
+type multipathManager struct {
+	c      *Conn
+	recvCh chan multipathReadResult // fed by uplink read loops; must be buffered
+	mode   atomic.Uint32            // of multipathMode
+
+	mu      sync.Mutex
+	ifNames []string                     // uplink interface names, as configured
+	uplinks map[string]*uplink           // by interface name; only those that could be opened
+	probes  map[stun.TxID]multipathProbe // outstanding probes
+	closed  bool
+}
+
+// uplink is a pair of UDP sockets bound to a network interface.
+type uplink struct {
+	ifName string
+	pconn4 *net.UDPConn // or nil
+	pconn6 *net.UDPConn // or nil
+}
+
+func (u *uplink) close() {
+	if u.pconn4 != nil {
+		u.pconn4.Close()
+	}
+	if u.pconn6 != nil {
+		u.pconn6.Close()
+	}
+}
+
+// multipathProbe is a disco ping sent over an uplink.
+type multipathProbe struct {
+	de     *endpoint
+	ifName string
+	dst    netip.AddrPort
+	at     mono.Time
+}
+
+type multipathReadResult struct {
+	b      []byte // nil to unblock a receiver
+	src    netip.AddrPort
+	ifName string
+}
+
+// multipathPath is the state of the path to a peer over an uplink.
+type multipathPath struct {
+	dst      netip.AddrPort // peer address the path leads to
+	latency  time.Duration  // of the most recent probe reply
+	lastPong mono.Time      // zero if none since dst last changed
+	loss     pathLoss
+}
+
+// pathLoss records which of the most recent probes of a path went
+// unanswered.
+type pathLoss struct {
+	lost uint32 // a bit per outcome, most recent in the low bit; set if lost
+	n    int    // number of outcomes recorded, up to 32
+}
+
+func (l *pathLoss) record(lost bool) {
+	l.lost <<= 1
+	if lost {
+		l.lost |= 1
+	}
+	l.n = min(l.n+1, 32)
+}
+
+// rate returns the fraction of recorded probes that were lost.
+func (l pathLoss) rate() float64 {
+	if l.n == 0 {
+		return 0
+	}
+	mask := uint32(1)<<l.n - 1
+	if l.n == 32 {
+		mask = ^uint32(0)
+	}
+	return float64(bits.OnesCount32(l.lost&mask)) / float64(l.n)
+}
+
+func newMultipathManager(c *Conn) *multipathManager {
+	return &multipathManager{
+		c:      c,
+		recvCh: make(chan multipathReadResult, 1),
+	}
+}
+
+func (m *multipathManager) currentMode() multipathMode {
+	return multipathMode(m.mode.Load())
+}
+
+// SetMultipath sets how WireGuard packets to peers are sent over the network
+// interfaces named by ifNames, in addition to the one with the default
+// route. mode is one of "off", "spread", or "duplicate".
+//
+// Multipath requires binding sockets to interfaces, which isn't supported
+// on all platforms.
+func (c *Conn) SetMultipath(mode string, ifNames []string) error {
+	mm, err := parseMultipathMode(mode)
+	if err != nil {
+		return err
+	}
+	if mm != multipathOff && len(ifNames) == 0 {
+		return errors.New("multipath needs at least one interface")
+	}
+	return c.multipath.setConfig(mm, ifNames)
+}
+
+func (m *multipathManager) setConfig(mode multipathMode, ifNames []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errConnClosed
+	}
+	if mode == multipathOff {
+		ifNames = nil
+	}
+	if mode == m.currentMode() && slices.Equal(ifNames, m.ifNames) {
+		return nil
+	}
+	m.c.logf("magicsock: multipath mode %v over %q", mode, ifNames)
+	m.mode.Store(uint32(mode))
+	m.ifNames = slices.Clone(ifNames)
+	return m.rebindLocked()
+}
+
+// rebind reopens the uplink sockets, such as after a link change that may
+// have added or removed interfaces.
+func (m *multipathManager) rebind() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || len(m.ifNames) == 0 {
+		return
+	}
+	if err := m.rebindLocked(); err != nil {
+		m.c.logf("magicsock: multipath: %v", err)
+	}
+}
+
+// rebindLocked closes any open uplinks and opens one per configured
+// interface that exists. It returns an error only if none could be opened.
+//
+// m.mu must be held.
+func (m *multipathManager) rebindLocked() error {
+	for _, u := range m.uplinks {
+		u.close()
+	}
+	clear(m.uplinks)
+	var errs []error
+	for _, ifName := range m.ifNames {
+		u, err := m.openUplink(ifName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if m.uplinks == nil {
+			m.uplinks = make(map[string]*uplink)
+		}
+		m.uplinks[ifName] = u
+	}
+	if len(m.uplinks) == 0 && len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		m.c.logf("magicsock: multipath: %v", err)
+	}
+	return nil
+}
+
+// openUplink opens sockets bound to the interface ifName and starts reading
+// from them.
+func (m *multipathManager) openUplink(ifName string) (*uplink, error) {
+	lc, err := netns.ListenerOnInterface(m.c.logf, ifName)
+	if err != nil {
+		return nil, fmt.Errorf("uplink %s: %w", ifName, err)
+	}
+	u := &uplink{ifName: ifName}
+	ctx := context.Background()
+	if pc, err := lc.ListenPacket(ctx, "udp4", ":0"); err == nil {
+		u.pconn4 = pc.(*net.UDPConn)
+	}
+	if pc, err := lc.ListenPacket(ctx, "udp6", ":0"); err == nil {
+		u.pconn6 = pc.(*net.UDPConn)
+	}
+	if u.pconn4 == nil && u.pconn6 == nil {
+		return nil, fmt.Errorf("uplink %s: no sockets could be opened", ifName)
+	}
+	for _, pc := range []*net.UDPConn{u.pconn4, u.pconn6} {
+		if pc != nil {
+			go m.readLoop(ifName, pc)
+		}
+	}
+	return u, nil
+}
+
+// readLoop reads packets from pc, which is bound to the interface ifName,
+// and passes them to receive, until pc is closed.
+func (m *multipathManager) readLoop(ifName string, pc *net.UDPConn) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, src, err := pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		res := multipathReadResult{
+			b:      slices.Clone(buf[:n]),
+			src:    netip.AddrPortFrom(src.Addr().Unmap(), src.Port()),
+			ifName: ifName,
+		}
+		select {
+		case m.recvCh <- res:
+			metricRecvMultipath.Add(1)
+		case <-m.c.donec:
+			return
+		}
+	}
+}
+
+// receiveMultipath is a [conn.ReceiveFunc] for packets read from uplinks.
+func (c *connBind) receiveMultipath() conn.ReceiveFunc {
+	// epCache caches an epAddr->endpoint for hot flows.
+	var epCache epAddrEndpointCache
+
+	return func(buffs [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		for res := range c.multipath.recvCh {
+			if c.isClosed() {
+				break
+			}
+			if res.b == nil {
+				continue
+			}
+			n := copy(buffs[0], res.b)
+			ep, size, ok := c.receiveIP(buffs[0][:n], res.src, &epCache)
+			if !ok {
+				continue
+			}
+			sizes[0] = size
+			eps[0] = ep
+			return 1, nil
+		}
+		return 0, net.ErrClosed
+	}
+}
+
+// close closes all uplinks. The manager can't be used after.
+func (m *multipathManager) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.mode.Store(uint32(multipathOff))
+	for _, u := range m.uplinks {
+		u.close()
+	}
+	clear(m.uplinks)
+	clear(m.probes)
+}
+
+// send sends each of buffs, starting at offset, to dst over the uplink
+// ifName.
+func (m *multipathManager) send(ifName string, dst netip.AddrPort, buffs [][]byte, offset int) error {
+	m.mu.Lock()
+	u := m.uplinks[ifName]
+	m.mu.Unlock()
+	if u == nil {
+		return fmt.Errorf("no uplink %s", ifName)
+	}
+	pc := u.pconn4
+	if dst.Addr().Is6() {
+		pc = u.pconn6
+	}
+	if pc == nil {
+		return fmt.Errorf("uplink %s has no socket for %v", ifName, dst)
+	}
+	for _, b := range buffs {
+		if _, err := pc.WriteToUDPAddrPort(b[offset:], dst); err != nil {
+			metricSendMultipathError.Add(1)
+			return err
+		}
+	}
+	metricSendMultipath.Add(int64(len(buffs)))
+	return nil
+}
+
+// probe sends a disco ping to dst, the best address of de, whose disco key
+// is discoKey, over each uplink, and records the probes that went
+// unanswered since the last call as lost.
+func (m *multipathManager) probe(de *endpoint, discoKey key.DiscoPublic, dst netip.AddrPort) {
+	now := mono.Now()
+	type sendProbe struct {
+		u    *uplink
+		txid stun.TxID
+	}
+	var toSend []sendProbe
+	var expired []multipathProbe
+
+	m.mu.Lock()
+	for txid, p := range m.probes {
+		if now.Sub(p.at) > pingTimeoutDuration {
+			expired = append(expired, p)
+			delete(m.probes, txid)
+		}
+	}
+	for ifName, u := range m.uplinks {
+		txid := stun.NewTxID()
+		if m.probes == nil {
+			m.probes = make(map[stun.TxID]multipathProbe)
+		}
+		m.probes[txid] = multipathProbe{de: de, ifName: ifName, dst: dst, at: now}
+		toSend = append(toSend, sendProbe{u, txid})
+	}
+	m.mu.Unlock()
+
+	for _, p := range expired {
+		p.de.noteMultipathProbeResult(p.ifName, p.dst, 0, false)
+	}
+	for _, p := range toSend {
+		pkt, ok := m.c.discoPingPacket(discoKey, p.txid)
+		if !ok {
+			return
+		}
+		if err := m.send(p.u.ifName, dst, [][]byte{pkt}, 0); err != nil {
+			m.c.dlogf("[v1] magicsock: multipath: probing %v over %s: %v", dst, p.u.ifName, err)
+		}
+	}
+}
+
+// handlePong handles a disco pong, reporting whether it was a reply to a
+// probe over an uplink.
+func (m *multipathManager) handlePong(pong *disco.Pong) bool {
+	if m.currentMode() == multipathOff {
+		return false
+	}
+	m.mu.Lock()
+	p, ok := m.probes[pong.TxID]
+	delete(m.probes, pong.TxID)
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	p.de.noteMultipathProbeResult(p.ifName, p.dst, mono.Since(p.at), true)
+	return true
+}
+
+// discoPingPacket returns a disco ping with the given transaction ID, sealed
+// for the peer with the given disco key. It reports false if the peer is
+// unknown.
+func (c *Conn) discoPingPacket(dstDisco key.DiscoPublic, txid stun.TxID) (pkt []byte, ok bool) {
+	c.mu.Lock()
+	if !c.peerMap.knownPeerDiscoKey(dstDisco) {
+		c.mu.Unlock()
+		return nil, false
+	}
+	di := c.discoInfoForKnownPeerLocked(dstDisco)
+	c.mu.Unlock()
+
+	ping := &disco.Ping{
+		TxID:    [12]byte(txid),
+		NodeKey: c.publicKeyAtomic.Load(),
+	}
+	pkt = append(pkt, disco.Magic...)
+	pkt = c.discoPublic.AppendTo(pkt)
+	pkt = append(pkt, di.sharedKey.Seal(ping.AppendMarshal(nil))...)
+	return pkt, true
+}
+
+// noteMultipathProbeResult records the outcome of a probe to dst over the
+// uplink ifName.
+func (de *endpoint) noteMultipathProbeResult(ifName string, dst netip.AddrPort, latency time.Duration, ok bool) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	p := de.multipathPaths[ifName]
+	if p == nil || p.dst != dst {
+		if !ok {
+			return
+		}
+		p = &multipathPath{dst: dst}
+		mak.Set(&de.multipathPaths, ifName, p)
+	}
+	p.loss.record(!ok)
+	if ok {
+		if p.lastPong == 0 {
+			de.c.dlogf("[v1] magicsock: multipath: node %v %v reachable over %s at %v", de.publicKey.ShortString(), de.discoShort(), ifName, dst)
+		}
+		p.latency = latency
+		p.lastPong = mono.Now()
+	}
+}
+
+// maybeProbeMultipathLocked probes the uplinks' paths to dst, de's best
+// address, if they weren't probed recently.
+//
+// de.mu must be held.
+func (de *endpoint) maybeProbeMultipathLocked(now mono.Time, dst netip.AddrPort) {
+	if now.Sub(de.lastMultipathProbe) < multipathProbeInterval {
+		return
+	}
+	epDisco := de.disco.Load()
+	if epDisco == nil {
+		return
+	}
+	de.lastMultipathProbe = now
+	go de.c.multipath.probe(de, epDisco.key, dst)
+}
+
+// multipathSendLocked returns which uplinks to send the next batch of
+// packets to dst (de's best address) over, and whether to also send it over
+// the Conn's own socket.
+//
+// de.mu must be held.
+func (de *endpoint) multipathSendLocked(mode multipathMode, now mono.Time, dst netip.AddrPort) (ifNames []string, sendMain bool) {
+	type candidate struct {
+		ifName  string // empty for the Conn's own socket
+		latency time.Duration
+	}
+	cands := []candidate{{"", de.bestAddr.latency}}
+	fastest := de.bestAddr.latency
+	for ifName, p := range de.multipathPaths {
+		if p.dst != dst || p.lastPong == 0 || now.Sub(p.lastPong) > trustUDPAddrDuration || p.loss.rate() > multipathMaxLoss {
+			continue
+		}
+		cands = append(cands, candidate{ifName, p.latency})
+		fastest = min(fastest, p.latency)
+	}
+	if len(cands) == 1 {
+		return nil, true
+	}
+	if mode == multipathDuplicate {
+		for _, c := range cands[1:] {
+			ifNames = append(ifNames, c.ifName)
+		}
+		return ifNames, true
+	}
+	cands = slices.DeleteFunc(cands, func(c candidate) bool {
+		return c.latency > fastest+multipathMaxSkew
+	})
+	slices.SortFunc(cands, func(a, b candidate) int { return strings.Compare(a.ifName, b.ifName) })
+	c := cands[de.multipathNext%uint(len(cands))]
+	de.multipathNext++
+	if c.ifName == "" {
+		return nil, true
+	}
+	return []string{c.ifName}, false
+}
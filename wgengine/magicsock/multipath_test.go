@@ -0,0 +1,150 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"tailscale.com/tstime/mono"
+)
+
+func TestParseMultipathMode(t *testing.T) {
+	for _, mode := range []multipathMode{multipathOff, multipathSpread, multipathDuplicate} {
+		got, err := parseMultipathMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("parseMultipathMode(%q) = %v, %v; want %v", mode.String(), got, err, mode)
+		}
+	}
+	if _, err := parseMultipathMode("bond"); err == nil {
+		t.Error("parseMultipathMode(bond) succeeded")
+	}
+}
+
+func TestPathLoss(t *testing.T) {
+	var l pathLoss
+	if got := l.rate(); got != 0 {
+		t.Errorf("empty rate = %v; want 0", got)
+	}
+	l.record(false)
+	l.record(true)
+	l.record(false)
+	l.record(true)
+	if got := l.rate(); got != 0.5 {
+		t.Errorf("rate = %v; want 0.5", got)
+	}
+	for range 32 {
+		l.record(false)
+	}
+	if got := l.rate(); got != 0 {
+		t.Errorf("rate after 32 successes = %v; want 0", got)
+	}
+	for range 40 {
+		l.record(true)
+	}
+	if got := l.rate(); got != 1 {
+		t.Errorf("rate after 40 losses = %v; want 1", got)
+	}
+}
+
+func TestMultipathSendLocked(t *testing.T) {
+	const ms = time.Millisecond
+	dst := netip.MustParseAddrPort("1.2.3.4:41641")
+	now := mono.Now()
+
+	newEndpoint := func() *endpoint {
+		de := &endpoint{c: newConn(t.Logf)}
+		de.bestAddr = addrQuality{epAddr: epAddr{ap: dst}, latency: 20 * ms}
+		de.multipathPaths = map[string]*multipathPath{
+			"wwan0": {dst: dst, latency: 40 * ms, lastPong: now},
+			"wlan1": {dst: dst, latency: 25 * ms, lastPong: now},
+			"slow0": {dst: dst, latency: 200 * ms, lastPong: now},
+			"stale": {dst: dst, latency: 10 * ms, lastPong: now - mono.Time(2*trustUDPAddrDuration)},
+			"other": {dst: netip.MustParseAddrPort("5.6.7.8:1"), latency: 10 * ms, lastPong: now},
+			"never": {dst: dst},
+		}
+		lossy := &multipathPath{dst: dst, latency: 10 * ms, lastPong: now}
+		for range 4 {
+			lossy.loss.record(true)
+		}
+		de.multipathPaths["lossy"] = lossy
+		return de
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		de := newEndpoint()
+		ifNames, sendMain := de.multipathSendLocked(multipathDuplicate, now, dst)
+		slices.Sort(ifNames)
+		if want := []string{"slow0", "wlan1", "wwan0"}; !slices.Equal(ifNames, want) || !sendMain {
+			t.Errorf("got %q, %v; want %q, true", ifNames, sendMain, want)
+		}
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		de := newEndpoint()
+		// slow0 is too far behind the fastest path to be used, so batches
+		// should rotate over the main socket, wlan1, and wwan0.
+		var got []string
+		for range 6 {
+			ifNames, sendMain := de.multipathSendLocked(multipathSpread, now, dst)
+			switch {
+			case sendMain && len(ifNames) == 0:
+				got = append(got, "main")
+			case !sendMain && len(ifNames) == 1:
+				got = append(got, ifNames[0])
+			default:
+				t.Fatalf("got %q, %v; want one path", ifNames, sendMain)
+			}
+		}
+		if want := []string{"main", "wlan1", "wwan0", "main", "wlan1", "wwan0"}; !slices.Equal(got, want) {
+			t.Errorf("paths = %q; want %q", got, want)
+		}
+	})
+
+	t.Run("no_usable_paths", func(t *testing.T) {
+		de := newEndpoint()
+		ifNames, sendMain := de.multipathSendLocked(multipathSpread, now, netip.MustParseAddrPort("9.9.9.9:9"))
+		if len(ifNames) != 0 || !sendMain {
+			t.Errorf("got %q, %v; want only main", ifNames, sendMain)
+		}
+	})
+}
+
+func TestNoteMultipathProbeResult(t *testing.T) {
+	dst := netip.MustParseAddrPort("1.2.3.4:41641")
+	de := &endpoint{c: newConn(t.Logf)}
+
+	// A loss for a path with no replies yet isn't worth tracking.
+	de.noteMultipathProbeResult("wwan0", dst, 0, false)
+	if len(de.multipathPaths) != 0 {
+		t.Fatalf("paths = %v; want none", de.multipathPaths)
+	}
+
+	de.noteMultipathProbeResult("wwan0", dst, 30*time.Millisecond, true)
+	p := de.multipathPaths["wwan0"]
+	if p == nil || p.latency != 30*time.Millisecond || p.lastPong == 0 {
+		t.Fatalf("path = %+v; want validated with 30ms latency", p)
+	}
+	de.noteMultipathProbeResult("wwan0", dst, 0, false)
+	if got := p.loss.rate(); got != 0.5 {
+		t.Errorf("loss = %v; want 0.5", got)
+	}
+
+	// A reply from a new best address starts over.
+	dst2 := netip.MustParseAddrPort("5.6.7.8:41641")
+	de.noteMultipathProbeResult("wwan0", dst2, 10*time.Millisecond, true)
+	p = de.multipathPaths["wwan0"]
+	if p.dst != dst2 || p.loss.rate() != 0 {
+		t.Errorf("path = %+v; want fresh path to %v", p, dst2)
+	}
+
+	de.mu.Lock()
+	de.clearBestAddrLocked()
+	de.mu.Unlock()
+	if len(de.multipathPaths) != 0 {
+		t.Errorf("paths = %v after clearBestAddrLocked; want none", de.multipathPaths)
+	}
+}
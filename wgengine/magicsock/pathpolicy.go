@@ -0,0 +1,122 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/pathpolicy"
+)
+
+// SetPathPolicy sets the policy constraining how paths to peers are chosen.
+// An invalid (zero) view removes any constraints.
+//
+// This is called by LocalBackend whenever its prefs change.
+func (c *Conn) SetPathPolicy(p pathpolicy.PolicyView) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pp := p.AsStruct()
+	if pp.Equal(c.pathPolicy) {
+		return
+	}
+	c.pathPolicy = pp
+	c.logf("magicsock: path policy changed")
+
+	var preferDERP int
+	var avoidDERPs []int
+	if pp != nil {
+		preferDERP = pp.PreferDERPRegion
+		avoidDERPs = pp.AvoidDERPRegions
+	}
+	c.netChecker.SetForcePreferredDERP(preferDERP)
+	c.netChecker.SetAvoidDERPRegions(avoidDERPs)
+
+	for _, n := range c.peers.All() {
+		if ep, ok := c.peerMap.endpointForNodeID(n.ID()); ok {
+			ep.setPathRule(pp.RuleFor(n))
+		}
+	}
+}
+
+// setPathRule sets the path policy rule that applies to de, or nil if
+// there's none, forgetting de's best address if r disallows it.
+func (de *endpoint) setPathRule(r *pathpolicy.PeerRule) {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+	de.pathRule = r
+	if de.bestAddr.ap.IsValid() && !de.pathAllowedLocked(de.bestAddr.epAddr) {
+		de.c.logf("magicsock: disco: node %v %v no longer using %v; disallowed by path policy", de.publicKey.ShortString(), de.discoShort(), de.bestAddr.epAddr)
+		de.clearBestAddrLocked()
+	}
+}
+
+// pathAllowedLocked reports whether de's path policy rule allows the
+// non-DERP path addr.
+//
+// de.mu must be held.
+func (de *endpoint) pathAllowedLocked(addr epAddr) bool {
+	if addr.vni.isSet() {
+		return de.pathRule.AllowsRelay(addr.ap.Addr())
+	}
+	return de.pathRule.AllowsDirect()
+}
+
+// betterAddrLocked is like betterAddr, but takes the path policy into
+// account: a path that de's rule disallows is never better, and, if the
+// policy says to avoid metered paths, an unmetered path is better than a
+// metered one regardless of latency.
+//
+// de.c.mu and de.mu must be held.
+func (de *endpoint) betterAddrLocked(a, b addrQuality) bool {
+	if a.epAddr == b.epAddr || !b.ap.IsValid() {
+		return de.pathAllowedLocked(a.epAddr) && betterAddr(a, b)
+	}
+	if !de.pathAllowedLocked(a.epAddr) {
+		return false
+	}
+	if !de.pathAllowedLocked(b.epAddr) {
+		return true
+	}
+	if p := de.c.pathPolicy; p != nil && p.AvoidMetered {
+		st := de.c.netMon.InterfaceState()
+		aMetered := isMeteredPath(p, st, a.ap.Addr())
+		bMetered := isMeteredPath(p, st, b.ap.Addr())
+		if aMetered != bMetered {
+			return bMetered
+		}
+	}
+	return betterAddr(a, b)
+}
+
+// isMeteredPath reports whether traffic to dst likely leaves over a metered
+// network interface, according to the policy p and the interface state st.
+// The interface is the one on the same network as dst, if any, or else the
+// default route interface.
+func isMeteredPath(p *pathpolicy.Policy, st *netmon.State, dst netip.Addr) bool {
+	if st == nil {
+		return false
+	}
+	ifName := st.DefaultRouteInterface
+	for name, pfxs := range st.InterfaceIPs {
+		if name != st.DefaultRouteInterface && prefixesContain(pfxs, dst) {
+			ifName = name
+			break
+		}
+	}
+	if ifName == st.DefaultRouteInterface && st.IsExpensive {
+		return true
+	}
+	return p.IsMeteredInterface(ifName)
+}
+
+func prefixesContain(pfxs []netip.Prefix, ip netip.Addr) bool {
+	for _, pfx := range pfxs {
+		if pfx.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
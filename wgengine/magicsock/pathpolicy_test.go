@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/net/netmon"
+	"tailscale.com/types/pathpolicy"
+)
+
+func TestBetterAddrLockedPathRule(t *testing.T) {
+	const ms = time.Millisecond
+	direct := addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("1.2.3.4:555")}, latency: 5 * ms}
+	relayIn := addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("10.0.0.1:7")}, latency: 50 * ms}
+	relayIn.vni.set(1)
+	relayOut := addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("5.6.7.8:7")}, latency: 10 * ms}
+	relayOut.vni.set(2)
+
+	c := &Conn{netMon: netmon.NewStatic()}
+	de := &endpoint{c: c}
+
+	// Without a rule, direct beats relay, and the lower latency relay wins.
+	if !de.betterAddrLocked(direct, relayIn) {
+		t.Error("no rule: direct not better than relay")
+	}
+	if !de.betterAddrLocked(relayOut, relayIn) {
+		t.Error("no rule: lower latency relay not better")
+	}
+
+	de.pathRule = &pathpolicy.PeerRule{
+		Match:      []string{"*"},
+		ForceRelay: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+	tests := []struct {
+		name string
+		a, b addrQuality
+		want bool
+	}{
+		{"direct_vs_none", direct, addrQuality{}, false},
+		{"direct_vs_allowed_relay", direct, relayIn, false},
+		{"allowed_relay_vs_direct", relayIn, direct, true},
+		{"allowed_relay_vs_none", relayIn, addrQuality{}, true},
+		{"disallowed_relay_vs_allowed_relay", relayOut, relayIn, false},
+		{"allowed_relay_vs_disallowed_relay", relayIn, relayOut, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := de.betterAddrLocked(tt.a, tt.b); got != tt.want {
+				t.Errorf("betterAddrLocked(%v, %v) = %v; want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPathRuleClearsBestAddr(t *testing.T) {
+	c := &Conn{netMon: netmon.NewStatic(), logf: t.Logf}
+	de := &endpoint{c: c}
+	de.bestAddr = addrQuality{epAddr: epAddr{ap: netip.MustParseAddrPort("1.2.3.4:555")}}
+
+	de.setPathRule(&pathpolicy.PeerRule{Match: []string{"*"}, DERPRegions: []int{1}})
+	if !de.bestAddr.ap.IsValid() {
+		t.Fatal("DERP-only rule cleared direct bestAddr")
+	}
+	de.setPathRule(&pathpolicy.PeerRule{
+		Match:      []string{"*"},
+		ForceRelay: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	if de.bestAddr.ap.IsValid() {
+		t.Fatalf("bestAddr = %v after ForceRelay rule; want none", de.bestAddr)
+	}
+}
+
+func TestIsMeteredPath(t *testing.T) {
+	st := &netmon.State{
+		InterfaceIPs: map[string][]netip.Prefix{
+			"wwan0": {netip.MustParsePrefix("100.70.1.2/30")},
+			"eth0":  {netip.MustParsePrefix("192.168.1.5/24")},
+		},
+		DefaultRouteInterface: "wwan0",
+	}
+	lan := netip.MustParseAddr("192.168.1.9")
+	wan := netip.MustParseAddr("1.2.3.4")
+
+	p := &pathpolicy.Policy{AvoidMetered: true, MeteredInterfaces: []string{"wwan*"}}
+	if !isMeteredPath(p, st, wan) {
+		t.Error("path over metered default route not metered")
+	}
+	if isMeteredPath(p, st, lan) {
+		t.Error("path over unmetered LAN interface metered")
+	}
+
+	p = &pathpolicy.Policy{AvoidMetered: true}
+	if isMeteredPath(p, st, wan) {
+		t.Error("path metered without matching pattern or IsExpensive")
+	}
+	st.IsExpensive = true
+	if !isMeteredPath(p, st, wan) {
+		t.Error("path over expensive default route not metered")
+	}
+	if isMeteredPath(p, st, lan) {
+		t.Error("path over non-default interface metered by IsExpensive")
+	}
+	if isMeteredPath(p, nil, wan) {
+		t.Error("path metered with nil state")
+	}
+}
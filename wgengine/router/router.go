@@ -92,6 +92,36 @@ type Config struct {
 	StatefulFiltering bool                   // Apply stateful filtering to inbound connections
 	NetfilterMode     preftype.NetfilterMode // how much to manage netfilter rules
 	NetfilterKind     string                 // what kind of netfilter to use (nftables, iptables)
+
+	// RouteTable, if non-zero, is the Linux routing table number (1-252)
+	// to install Routes and LocalRoutes in, instead of table 52.
+	//
+	// RouteTable and SourceRules are never set by tailscaled itself; they
+	// are for programs that embed this package and supply their own
+	// Router config, for example by wrapping the Router given to wgengine.
+	RouteTable int
+
+	// SourceRules are Linux policy routing rules that route traffic from
+	// particular source prefixes using additional routes, such as
+	// sending only a container bridge's traffic via an exit node.
+	SourceRules []SourceRule
+}
+
+// SourceRule routes traffic from Sources using Routes, as well as the
+// Config's Routes. It's only supported on Linux.
+type SourceRule struct {
+	// Sources are the source prefixes of the traffic the rule applies to.
+	Sources []netip.Prefix
+
+	// Routes are the routes, pointing into the Tailscale interface, to
+	// use for traffic from Sources that doesn't match the Config's
+	// Routes. The Config's LocalRoutes are excluded from them.
+	Routes []netip.Prefix
+
+	// Table is the Linux routing table number (1-252) to install Routes
+	// in. It must differ from the Config's RouteTable and the Table of
+	// every other SourceRule.
+	Table int
 }
 
 func (a *Config) Equal(b *Config) bool {
@@ -12,8 +12,10 @@
 	"net/netip"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -30,6 +32,7 @@
 	"tailscale.com/types/opt"
 	"tailscale.com/types/preftype"
 	"tailscale.com/util/linuxfw"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/multierr"
 	"tailscale.com/version/distro"
 )
@@ -57,6 +60,15 @@ type linuxRouter struct {
 	netfilterMode     preftype.NetfilterMode
 	netfilterKind     string
 
+	// mu guards table and sourceTables, which the timer that restores
+	// deleted ip rules reads.
+	mu sync.Mutex
+	// table is the routing table that routes and localRoutes are in.
+	table RouteTable
+	// sourceTables are the routing tables for Config.SourceRules,
+	// keyed by number.
+	sourceTables map[int]*sourceTable
+
 	// ruleRestorePending is whether a timer has been started to
 	// restore deleted ip rules.
 	ruleRestorePending atomic.Bool
@@ -102,6 +114,7 @@ func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, netMon *netmon
 
 		ipRuleFixLimiter: rate.NewLimiter(rate.Every(5*time.Second), 10),
 		ipPolicyPrefBase: 5200,
+		table:            tailscaleRouteTable,
 	}
 	if r.useIPCommand() {
 		r.ipRuleAvailable = (cmd.run("ip", "rule") == nil)
@@ -349,6 +362,9 @@ func (r *linuxRouter) Close() error {
 	r.addrs = nil
 	r.routes = nil
 	r.localRoutes = nil
+	r.mu.Lock()
+	r.sourceTables = nil
+	r.mu.Unlock()
 
 	return nil
 }
@@ -374,6 +390,9 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	if cfg == nil {
 		cfg = &shutdownConfig
 	}
+	if err := r.checkRouteTables(cfg); err != nil {
+		return err
+	}
 
 	if cfg.NetfilterKind != r.netfilterKind {
 		if err := r.setNetfilterMode(netfilterOff); err != nil {
@@ -391,6 +410,16 @@ func (r *linuxRouter) Set(cfg *Config) error {
 		errs = append(errs, err)
 	}
 
+	table := tailscaleRouteTable
+	if cfg.RouteTable != 0 {
+		table = routeTableByNum(cfg.RouteTable)
+	}
+	if table != r.table {
+		if err := r.setRouteTable(table); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	newLocalRoutes, err := cidrDiff("localRoute", r.localRoutes, cfg.LocalRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
@@ -409,6 +438,10 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.addrs = newAddrs
 
+	if err := r.setSourceRules(cfg.SourceRules, cfg.LocalRoutes); err != nil {
+		errs = append(errs, err)
+	}
+
 	// Ensure that the SNAT rule is added or removed as needed.
 	switch {
 	case cfg.SNATSubnetRoutes == r.snatSubnetRoutes:
@@ -449,6 +482,150 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	return multierr.New(errs...)
 }
 
+// validRouteTable reports whether num is a routing table number that can
+// be used for Tailscale routes. It excludes the kernel's reserved tables
+// (253-255) and, for Busybox's sake, larger numbers.
+func validRouteTable(num int) bool {
+	return num >= 1 && num <= 252
+}
+
+// checkRouteTables reports whether cfg's RouteTable and SourceRules are
+// valid and can be applied.
+func (r *linuxRouter) checkRouteTables(cfg *Config) error {
+	if len(cfg.SourceRules) > 0 && !r.ipRuleAvailable {
+		return errors.New("SourceRules require policy routing, which is unavailable")
+	}
+	table := tailscaleRouteTable.Num
+	if cfg.RouteTable != 0 {
+		if !validRouteTable(cfg.RouteTable) {
+			return fmt.Errorf("invalid RouteTable %d", cfg.RouteTable)
+		}
+		table = cfg.RouteTable
+	}
+	used := map[int]bool{table: true}
+	for i, sr := range cfg.SourceRules {
+		switch {
+		case !validRouteTable(sr.Table):
+			return fmt.Errorf("SourceRules[%d]: invalid Table %d", i, sr.Table)
+		case used[sr.Table]:
+			return fmt.Errorf("SourceRules[%d]: Table %d already in use", i, sr.Table)
+		case len(sr.Sources) == 0:
+			return fmt.Errorf("SourceRules[%d]: no Sources", i)
+		}
+		used[sr.Table] = true
+		for _, src := range sr.Sources {
+			if !src.IsValid() {
+				return fmt.Errorf("SourceRules[%d]: invalid source", i)
+			}
+		}
+	}
+	return nil
+}
+
+// setRouteTable moves r's routes, throw routes and ip rules to table. The
+// caller is expected to add the routes back.
+func (r *linuxRouter) setRouteTable(table RouteTable) error {
+	var errs []error
+	var err error
+	r.localRoutes, err = cidrDiff("localRoute", r.localRoutes, nil, r.addThrowRoute, r.delThrowRoute, r.logf)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	r.routes, err = cidrDiff("route", r.routes, nil, r.addRoute, r.delRoute, r.logf)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := r.delRules(r.routeTableRules()); err != nil {
+		errs = append(errs, err)
+	}
+	r.mu.Lock()
+	r.table = table
+	r.mu.Unlock()
+	if err := r.addRules(r.routeTableRules()); err != nil {
+		errs = append(errs, err)
+	}
+	return multierr.New(errs...)
+}
+
+// routeTableRules returns the ip rules r installs that look up its route
+// table.
+func (r *linuxRouter) routeTableRules() []netlink.Rule {
+	rules := r.policyRules()
+	return slices.DeleteFunc(rules, func(ru netlink.Rule) bool {
+		return ru.Table != r.table.Num
+	})
+}
+
+// sourceTable is the state of a routing table for a SourceRule.
+type sourceTable struct {
+	table       RouteTable
+	srcs        map[netip.Prefix]bool // sources with an ip rule to table; guarded by linuxRouter.mu
+	routes      map[netip.Prefix]bool
+	localRoutes map[netip.Prefix]bool
+}
+
+// setSourceRules updates r's source routing tables and ip rules to match
+// rules. Each table also gets throw routes for localRoutes.
+func (r *linuxRouter) setSourceRules(rules []SourceRule, localRoutes []netip.Prefix) error {
+	var errs []error
+	want := make(map[int]bool, len(rules))
+	for _, sr := range rules {
+		want[sr.Table] = true
+	}
+	for num, st := range r.sourceTables {
+		if want[num] {
+			continue
+		}
+		errs = append(errs, r.updateSourceTable(st, nil, nil, nil)...)
+		r.mu.Lock()
+		delete(r.sourceTables, num)
+		r.mu.Unlock()
+	}
+	for _, sr := range rules {
+		st := r.sourceTables[sr.Table]
+		if st == nil {
+			st = &sourceTable{table: routeTableByNum(sr.Table)}
+			r.mu.Lock()
+			mak.Set(&r.sourceTables, sr.Table, st)
+			r.mu.Unlock()
+		}
+		errs = append(errs, r.updateSourceTable(st, sr.Sources, sr.Routes, localRoutes)...)
+	}
+	return multierr.New(errs...)
+}
+
+// updateSourceTable makes st route traffic from srcs using routes, except
+// for localRoutes. It returns any errors encountered.
+func (r *linuxRouter) updateSourceTable(st *sourceTable, srcs, routes, localRoutes []netip.Prefix) []error {
+	var errs []error
+	var err error
+	st.localRoutes, err = cidrDiff("sourceLocalRoute", st.localRoutes, localRoutes,
+		func(cidr netip.Prefix) error { return r.addThrowRouteTo(st.table, cidr) },
+		func(cidr netip.Prefix) error { return r.delThrowRouteFrom(st.table, cidr) },
+		r.logf)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	st.routes, err = cidrDiff("sourceRoute", st.routes, routes,
+		func(cidr netip.Prefix) error { return r.addRouteTo(st.table, cidr) },
+		func(cidr netip.Prefix) error { return r.delRouteFrom(st.table, cidr) },
+		r.logf)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	newSrcs, err := cidrDiff("sourceRule", st.srcs, srcs,
+		func(src netip.Prefix) error { return r.addRules([]netlink.Rule{sourceRule(src, st.table)}) },
+		func(src netip.Prefix) error { return r.delRules([]netlink.Rule{sourceRule(src, st.table)}) },
+		r.logf)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	r.mu.Lock()
+	st.srcs = newSrcs
+	r.mu.Unlock()
+	return errs
+}
+
 var dockerStatefulFilteringWarnable = health.Register(&health.Warnable{
 	Code:     "docker-stateful-filtering",
 	Title:    "Docker with stateful filtering",
@@ -799,11 +976,16 @@ func (r *linuxRouter) delLoopbackRule(addr netip.Addr) error {
 // interface. Fails if the route already exists, or if adding the
 // route fails.
 func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
+	return r.addRouteTo(r.table, cidr)
+}
+
+// addRouteTo is like addRoute, but adds the route to table.
+func (r *linuxRouter) addRouteTo(table RouteTable, cidr netip.Prefix) error {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.addRouteDef(table, []string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -812,7 +994,7 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 	return netlink.RouteReplace(&netlink.Route{
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
-		Table:     r.routeTable(),
+		Table:     r.routeTable(table),
 	})
 }
 
@@ -821,6 +1003,11 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 // pretending that no route was found. Fails if the route already exists,
 // or if adding the route fails.
 func (r *linuxRouter) addThrowRoute(cidr netip.Prefix) error {
+	return r.addThrowRouteTo(r.table, cidr)
+}
+
+// addThrowRouteTo is like addThrowRoute, but adds the route to table.
+func (r *linuxRouter) addThrowRouteTo(table RouteTable, cidr netip.Prefix) error {
 	if !r.ipRuleAvailable {
 		return nil
 	}
@@ -828,11 +1015,11 @@ func (r *linuxRouter) addThrowRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr)
+		return r.addRouteDef(table, []string{"throw", normalizeCIDR(cidr)}, cidr)
 	}
 	err := netlink.RouteReplace(&netlink.Route{
 		Dst:   netipx.PrefixIPNet(cidr.Masked()),
-		Table: tailscaleRouteTable.Num,
+		Table: table.Num,
 		Type:  unix.RTN_THROW,
 	})
 	if err != nil {
@@ -841,13 +1028,13 @@ func (r *linuxRouter) addThrowRoute(cidr netip.Prefix) error {
 	return err
 }
 
-func (r *linuxRouter) addRouteDef(routeDef []string, cidr netip.Prefix) error {
+func (r *linuxRouter) addRouteDef(table RouteTable, routeDef []string, cidr netip.Prefix) error {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
 	args := append([]string{"ip", "route", "add"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	err := r.cmd.run(args...)
 	if err == nil {
@@ -876,11 +1063,16 @@ func (r *linuxRouter) addRouteDef(routeDef []string, cidr netip.Prefix) error {
 // interface. Fails if the route doesn't exist, or if removing the
 // route fails.
 func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
+	return r.delRouteFrom(r.table, cidr)
+}
+
+// delRouteFrom is like delRoute, but removes the route from table.
+func (r *linuxRouter) delRouteFrom(table RouteTable, cidr netip.Prefix) error {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(table, []string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -889,7 +1081,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 	err = netlink.RouteDel(&netlink.Route{
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
-		Table:     r.routeTable(),
+		Table:     r.routeTable(table),
 	})
 	if errors.Is(err, errESRCH) {
 		// Didn't exist to begin with.
@@ -901,6 +1093,12 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 // delThrowRoute removes the throw route for the cidr. Fails if the route
 // doesn't exist, or if removing the route fails.
 func (r *linuxRouter) delThrowRoute(cidr netip.Prefix) error {
+	return r.delThrowRouteFrom(r.table, cidr)
+}
+
+// delThrowRouteFrom is like delThrowRoute, but removes the route from
+// table.
+func (r *linuxRouter) delThrowRouteFrom(table RouteTable, cidr netip.Prefix) error {
 	if !r.ipRuleAvailable {
 		return nil
 	}
@@ -908,11 +1106,11 @@ func (r *linuxRouter) delThrowRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr)
+		return r.delRouteDef(table, []string{"throw", normalizeCIDR(cidr)}, cidr)
 	}
 	err := netlink.RouteDel(&netlink.Route{
 		Dst:   netipx.PrefixIPNet(cidr.Masked()),
-		Table: r.routeTable(),
+		Table: r.routeTable(table),
 		Type:  unix.RTN_THROW,
 	})
 	if errors.Is(err, errESRCH) {
@@ -922,17 +1120,17 @@ func (r *linuxRouter) delThrowRoute(cidr netip.Prefix) error {
 	return err
 }
 
-func (r *linuxRouter) delRouteDef(routeDef []string, cidr netip.Prefix) error {
+func (r *linuxRouter) delRouteDef(table RouteTable, routeDef []string, cidr netip.Prefix) error {
 	if !r.getV6Available() && cidr.Addr().Is6() {
 		return nil
 	}
 	args := append([]string{"ip", "route", "del"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	err := r.cmd.run(args...)
 	if err != nil {
-		ok, err := r.hasRoute(routeDef, cidr)
+		ok, err := r.hasRoute(table, routeDef, cidr)
 		if err != nil {
 			r.logf("warning: error checking whether %v even exists after error deleting it: %v", err)
 		} else {
@@ -952,10 +1150,10 @@ func dashFam(ip netip.Addr) string {
 	return "-4"
 }
 
-func (r *linuxRouter) hasRoute(routeDef []string, cidr netip.Prefix) (bool, error) {
+func (r *linuxRouter) hasRoute(table RouteTable, routeDef []string, cidr netip.Prefix) (bool, error) {
 	args := append([]string{"ip", dashFam(cidr.Addr()), "route", "show"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	out, err := r.cmd.output(args...)
 	if err != nil {
@@ -982,10 +1180,11 @@ func (r *linuxRouter) linkIndex() (int, error) {
 	return link.Attrs().Index, nil
 }
 
-// routeTable returns the route table to use.
-func (r *linuxRouter) routeTable() int {
+// routeTable returns the number of the route table to use for routes meant
+// for table.
+func (r *linuxRouter) routeTable(table RouteTable) int {
 	if r.ipRuleAvailable {
-		return tailscaleRouteTable.Num
+		return table.Num
 	}
 	return 0
 }
@@ -1150,16 +1349,13 @@ func newRouteTable(name string, num int) RouteTable {
 	return rt
 }
 
-// MustRouteTable returns the RouteTable with the given number key.
-// It panics if the number is unknown because this result is a part
-// of IP rule argument and we don't want to continue with an invalid
-// argument with table no exist.
-func mustRouteTable(num int) RouteTable {
-	rt, ok := routeTableByNumber[num]
-	if !ok {
-		panic(fmt.Sprintf("unknown route table %v", num))
+// routeTableByNum returns the RouteTable with the given number. Tables
+// other than the well-known ones have no name.
+func routeTableByNum(num int) RouteTable {
+	if rt, ok := routeTableByNumber[num]; ok {
+		return rt
 	}
-	return rt
+	return RouteTable{Num: num}
 }
 
 var (
@@ -1266,18 +1462,67 @@ func ipRules() []netlink.Rule {
 	return baseIPRules
 }
 
+// sourceRulePriority is the priority, added to r.ipPolicyPrefBase, of the
+// rules for Config.SourceRules. They come after the rule for the Tailscale
+// route table, so that its routes take precedence, and are still within
+// the range that onIPRuleDeleted restores.
+const sourceRulePriority = 80
+
+// sourceRule returns the ip rule that routes traffic from src using table.
+func sourceRule(src netip.Prefix, table RouteTable) netlink.Rule {
+	family := netlink.FAMILY_V4
+	if src.Addr().Is6() {
+		family = netlink.FAMILY_V6
+	}
+	return netlink.Rule{
+		Priority: sourceRulePriority,
+		Family:   family,
+		Src:      netipx.PrefixIPNet(src.Masked()),
+		Table:    table.Num,
+	}
+}
+
+// policyRules returns all the ip rules r installs: ipRules, using r's
+// route table, and the rules for its source tables.
+func (r *linuxRouter) policyRules() []netlink.Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rules []netlink.Rule
+	for _, ru := range ipRules() {
+		if ru.Table == tailscaleRouteTable.Num {
+			ru.Table = r.table.Num
+		}
+		rules = append(rules, ru)
+	}
+	for _, st := range r.sourceTables {
+		for src := range st.srcs {
+			rules = append(rules, sourceRule(src, st.table))
+		}
+	}
+	return rules
+}
+
 // justAddIPRules adds policy routing rule without deleting any first.
 func (r *linuxRouter) justAddIPRules() error {
+	return r.addRules(r.policyRules())
+}
+
+// addRules adds the given ip rules, once per address family unless a rule's
+// Family is set. Their priorities are relative to r.ipPolicyPrefBase.
+func (r *linuxRouter) addRules(rules []netlink.Rule) error {
 	if !r.ipRuleAvailable {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addIPRulesWithIPCommand()
+		return r.addIPRulesWithIPCommand(rules)
 	}
 	var errAcc error
 	for _, family := range r.addrFamilies() {
 
-		for _, ru := range ipRules() {
+		for _, ru := range rules {
+			if ru.Family != 0 && ru.Family != family.netlinkInt() {
+				continue
+			}
 			// Note: r is a value type here; safe to mutate it.
 			ru.Family = family.netlinkInt()
 			if ru.Mark != 0 {
@@ -1302,16 +1547,22 @@ func (r *linuxRouter) justAddIPRules() error {
 	return errAcc
 }
 
-func (r *linuxRouter) addIPRulesWithIPCommand() error {
+func (r *linuxRouter) addIPRulesWithIPCommand(rules []netlink.Rule) error {
 	rg := newRunGroup(nil, r.cmd)
 
 	for _, family := range r.addrFamilies() {
-		for _, rule := range ipRules() {
+		for _, rule := range rules {
+			if rule.Family != 0 && rule.Family != family.netlinkInt() {
+				continue
+			}
 			args := []string{
 				"ip", family.dashArg(),
 				"rule", "add",
 				"pref", strconv.Itoa(rule.Priority + r.ipPolicyPrefBase),
 			}
+			if rule.Src != nil {
+				args = append(args, "from", rule.Src.String())
+			}
 			if rule.Mark != 0 {
 				if r.fwmaskWorks() {
 					args = append(args, "fwmark", fmt.Sprintf("0x%x/%s", rule.Mark, linuxfw.TailscaleFwmarkMask))
@@ -1320,7 +1571,7 @@ func (r *linuxRouter) addIPRulesWithIPCommand() error {
 				}
 			}
 			if rule.Table != 0 {
-				args = append(args, "table", mustRouteTable(rule.Table).ipCmdArg())
+				args = append(args, "table", routeTableByNum(rule.Table).ipCmdArg())
 			}
 			if rule.Type == unix.RTN_UNREACHABLE {
 				args = append(args, "type", "unreachable")
@@ -1340,21 +1591,114 @@ func (r *linuxRouter) delRoutes() error {
 			r.logf("failed to delete throw route(%q): %v", rt, err)
 		}
 	}
+	for _, st := range r.sourceTables {
+		for rt := range st.localRoutes {
+			if err := r.delThrowRouteFrom(st.table, rt); err != nil {
+				r.logf("failed to delete throw route(%q) from table %d: %v", rt, st.table.Num, err)
+			}
+		}
+	}
 	return nil
 }
 
 // delIPRules removes the policy routing rules that avoid
 // tailscaled routing loops, if it exists.
+//
+// Rules are matched by priority alone, rather than by the tables they look
+// up, so that rules for route tables left behind by a previous run (say, one
+// that crashed while using a custom RouteTable or SourceRules) are removed
+// too.
 func (r *linuxRouter) delIPRules() error {
 	if !r.ipRuleAvailable {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delIPRulesWithIPCommand()
+		return r.delIPRulesByPriorityWithIPCommand()
+	}
+	prios := ipRulePriorities()
+	var errAcc error
+	for _, family := range r.addrFamilies() {
+		rules, err := netlink.RuleList(family.netlinkInt())
+		if err != nil {
+			if errAcc == nil {
+				errAcc = err
+			}
+			continue
+		}
+		for _, ru := range rules {
+			if !slices.Contains(prios, ru.Priority-r.ipPolicyPrefBase) {
+				continue
+			}
+			err := netlink.RuleDel(&ru)
+			if errors.Is(err, errENOENT) {
+				// Deleted by someone else in the meantime.
+				continue
+			}
+			if err != nil && errAcc == nil {
+				errAcc = err
+			}
+		}
+	}
+	return errAcc
+}
+
+// ipRulePriorities returns the priorities, relative to r.ipPolicyPrefBase,
+// of all the ip rules that any configuration of the router might install.
+func ipRulePriorities() []int {
+	var prios []int
+	for _, rules := range [][]netlink.Rule{baseIPRules, ubntIPRules} {
+		for _, ru := range rules {
+			prios = append(prios, ru.Priority)
+		}
+	}
+	prios = append(prios, sourceRulePriority)
+	slices.Sort(prios)
+	return slices.Compact(prios)
+}
+
+// maxIPRulesPerPriority bounds the number of rules
+// delIPRulesByPriorityWithIPCommand deletes at each priority, in case 'ip
+// rule del' keeps succeeding without deleting anything.
+const maxIPRulesPerPriority = 1000
+
+// delIPRulesByPriorityWithIPCommand is the 'ip' command version of
+// delIPRules. 'ip rule del pref N' deletes one rule at a time, so it's run
+// for each priority until there are no rules left at it.
+func (r *linuxRouter) delIPRulesByPriorityWithIPCommand() error {
+	var errAcc error
+	for _, family := range r.addrFamilies() {
+		for _, prio := range ipRulePriorities() {
+			for range maxIPRulesPerPriority {
+				err := r.cmd.run("ip", family.dashArg(), "rule", "del", "pref", strconv.Itoa(prio+r.ipPolicyPrefBase))
+				if err == nil {
+					continue
+				}
+				// As in delIPRulesWithIPCommand, exit codes 2 and 254
+				// mean there's no such rule.
+				if c := errCode(err); c != 2 && c != 254 && errAcc == nil {
+					errAcc = err
+				}
+				break
+			}
+		}
+	}
+	return errAcc
+}
+
+// delRules removes the given ip rules, as added by addRules, if they exist.
+func (r *linuxRouter) delRules(rules []netlink.Rule) error {
+	if !r.ipRuleAvailable {
+		return nil
+	}
+	if r.useIPCommand() {
+		return r.delIPRulesWithIPCommand(rules)
 	}
 	var errAcc error
 	for _, family := range r.addrFamilies() {
-		for _, ru := range ipRules() {
+		for _, ru := range rules {
+			if ru.Family != 0 && ru.Family != family.netlinkInt() {
+				continue
+			}
 			// Note: r is a value type here; safe to mutate it.
 			// When deleting rules, we want to be a bit specific (mention which
 			// table we were routing to) but not *too* specific (fwmarks, etc).
@@ -1382,7 +1726,7 @@ func (r *linuxRouter) delIPRules() error {
 	return errAcc
 }
 
-func (r *linuxRouter) delIPRulesWithIPCommand() error {
+func (r *linuxRouter) delIPRulesWithIPCommand(rules []netlink.Rule) error {
 	// Error codes: 'ip rule' returns error code 2 if the rule is a
 	// duplicate (add) or not found (del). It returns a different code
 	// for syntax errors. This is also true of busybox.
@@ -1397,14 +1741,20 @@ func (r *linuxRouter) delIPRulesWithIPCommand() error {
 		// That leaves us some flexibility to change these values in later
 		// versions without having ongoing hacks for every possible
 		// combination.
-		for _, rule := range ipRules() {
+		for _, rule := range rules {
+			if rule.Family != 0 && rule.Family != family.netlinkInt() {
+				continue
+			}
 			args := []string{
 				"ip", family.dashArg(),
 				"rule", "del",
 				"pref", strconv.Itoa(rule.Priority + r.ipPolicyPrefBase),
 			}
+			if rule.Src != nil {
+				args = append(args, "from", rule.Src.String())
+			}
 			if rule.Table != 0 {
-				args = append(args, "table", mustRouteTable(rule.Table).ipCmdArg())
+				args = append(args, "table", routeTableByNum(rule.Table).ipCmdArg())
 			} else {
 				args = append(args, "type", "unreachable")
 			}
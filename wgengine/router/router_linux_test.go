@@ -362,6 +362,93 @@ func TestRouterStates(t *testing.T) {
 ip route add throw 10.0.0.0/8 table 52
 ip route add throw 192.168.0.0/24 table 52` + basic,
 		},
+		{
+			name: "custom route table",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "0.0.0.0/0"),
+				LocalRoutes:   mustCIDRs("10.0.0.0/8"),
+				NetfilterMode: netfilterOff,
+				RouteTable:    100,
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 table 100
+ip route add 100.100.100.100/32 dev tailscale0 table 100
+ip route add throw 10.0.0.0/8 table 100` + strings.ReplaceAll(basic, "table 52", "table 100"),
+		},
+		{
+			name: "source rules",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32"),
+				LocalRoutes:   mustCIDRs("10.0.0.0/8"),
+				NetfilterMode: netfilterOff,
+				SourceRules: []SourceRule{{
+					Sources: mustCIDRs("172.17.0.0/16", "fd00:17::/64"),
+					Routes:  mustCIDRs("0.0.0.0/0", "::/0"),
+					Table:   53,
+				}},
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 table 53
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add ::/0 dev tailscale0 table 53
+ip route add throw 10.0.0.0/8 table 52
+ip route add throw 10.0.0.0/8 table 53
+ip rule add -4 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -4 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -4 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -4 pref 5270 table 52
+ip rule add -4 pref 5280 from 172.17.0.0/16 table 53
+ip rule add -6 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -6 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -6 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -6 pref 5270 table 52
+ip rule add -6 pref 5280 from fd00:17::/64 table 53
+`,
+		},
+		{
+			name: "source rules with custom route table",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32"),
+				NetfilterMode: netfilterOff,
+				RouteTable:    100,
+				SourceRules: []SourceRule{
+					{
+						Sources: mustCIDRs("172.17.0.0/16"),
+						Routes:  mustCIDRs("0.0.0.0/0"),
+						Table:   101,
+					},
+					{
+						Sources: mustCIDRs("172.18.0.0/16"),
+						Routes:  mustCIDRs("0.0.0.0/0"),
+						Table:   102,
+					},
+				},
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 table 101
+ip route add 0.0.0.0/0 dev tailscale0 table 102
+ip route add 100.100.100.100/32 dev tailscale0 table 100
+ip rule add -4 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -4 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -4 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -4 pref 5270 table 100
+ip rule add -4 pref 5280 from 172.17.0.0/16 table 101
+ip rule add -4 pref 5280 from 172.18.0.0/16 table 102
+ip rule add -6 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -6 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -6 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -6 pref 5270 table 100
+`,
+		},
 	}
 
 	bus := eventbus.New()
@@ -898,9 +985,12 @@ func (o *fakeOS) run(args ...string) error {
 		*l = append(*l, rest)
 		sort.Strings(*l)
 	case "del":
+		// 'ip rule del pref N' deletes the first rule at that priority,
+		// whatever else it matches.
+		prefOnly := args[1] == "rule" && len(args) == 5 && args[3] == "pref"
 		found := false
 		for i, el := range *l {
-			if el == rest {
+			if el == rest || prefOnly && strings.HasPrefix(el, rest+" ") {
 				found = true
 				*l = append((*l)[:i], (*l)[i+1:]...)
 				break
@@ -1247,6 +1337,91 @@ func adjustFwmask(t *testing.T, s string) string {
 	return fwmaskAdjustRe.ReplaceAllString(s, "$1")
 }
 
+func TestCheckRouteTables(t *testing.T) {
+	r := &linuxRouter{ipRuleAvailable: true}
+	src := mustCIDRs("172.17.0.0/16")
+	tests := []struct {
+		name    string
+		cfg     *Config
+		wantErr bool
+	}{
+		{"empty", &Config{}, false},
+		{"route_table", &Config{RouteTable: 100}, false},
+		{"reserved_route_table", &Config{RouteTable: 254}, true},
+		{"negative_route_table", &Config{RouteTable: -1}, true},
+		{"source_rule", &Config{SourceRules: []SourceRule{{Sources: src, Table: 53}}}, false},
+		{"source_rule_no_table", &Config{SourceRules: []SourceRule{{Sources: src}}}, true},
+		{"source_rule_default_table", &Config{SourceRules: []SourceRule{{Sources: src, Table: 52}}}, true},
+		{"source_rule_route_table", &Config{RouteTable: 100, SourceRules: []SourceRule{{Sources: src, Table: 100}}}, true},
+		{"source_rule_no_sources", &Config{SourceRules: []SourceRule{{Table: 53}}}, true},
+		{"source_rule_invalid_source", &Config{SourceRules: []SourceRule{{Sources: []netip.Prefix{{}}, Table: 53}}}, true},
+		{"source_rules_same_table", &Config{SourceRules: []SourceRule{
+			{Sources: src, Table: 53},
+			{Sources: mustCIDRs("172.18.0.0/16"), Table: 53},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.checkRouteTables(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRouteTables = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	r.ipRuleAvailable = false
+	if err := r.checkRouteTables(&Config{SourceRules: []SourceRule{{Sources: src, Table: 53}}}); err == nil {
+		t.Error("checkRouteTables without policy routing succeeded; want error")
+	}
+}
+
+func TestUpRemovesStaleIPRules(t *testing.T) {
+	bus := eventbus.New()
+	defer bus.Close()
+	mon, err := netmon.New(bus, logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.Start()
+	defer mon.Close()
+
+	// Rules left behind by a previous run that used a custom route table
+	// and source rules, plus one that isn't ours.
+	fake := NewFakeOS(t)
+	fake.rules = []string{
+		"-4 pref 5270 table 100",
+		"-4 pref 5280 from 172.17.0.0/16 table 101",
+		"-4 pref 5280 from 172.18.0.0/16 table 102",
+		"-4 pref 6000 table 200",
+		"-6 pref 5270 table 100",
+	}
+	router, err := newUserspaceRouterAdvanced(t.Logf, "tailscale0", mon, fake, new(health.Tracker))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	router.(*linuxRouter).nfr = fake.nfr
+	if err := router.Up(); err != nil {
+		t.Fatalf("failed to up router: %v", err)
+	}
+	want := []string{
+		"-4 pref 5210 fwmark 0x80000/0xff0000 table main",
+		"-4 pref 5230 fwmark 0x80000/0xff0000 table default",
+		"-4 pref 5250 fwmark 0x80000/0xff0000 type unreachable",
+		"-4 pref 5270 table 52",
+		"-4 pref 6000 table 200",
+		"-6 pref 5210 fwmark 0x80000/0xff0000 table main",
+		"-6 pref 5230 fwmark 0x80000/0xff0000 table default",
+		"-6 pref 5250 fwmark 0x80000/0xff0000 type unreachable",
+		"-6 pref 5270 table 52",
+	}
+	for i := range want {
+		want[i] = adjustFwmask(t, want[i])
+	}
+	if diff := cmp.Diff(want, fake.rules); diff != "" {
+		t.Errorf("unexpected rules after Up (-want +got):\n%s", diff)
+	}
+}
+
 func TestIPRulesForUBNT(t *testing.T) {
 	// Override the global getDistroFunc
 	getDistroFunc = func() distro.Distro {
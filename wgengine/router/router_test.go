@@ -24,7 +24,7 @@ func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
 		"LocalAddrs", "Routes", "LocalRoutes", "NewMTU",
 		"SubnetRoutes", "SNATSubnetRoutes", "StatefulFiltering",
-		"NetfilterMode", "NetfilterKind",
+		"NetfilterMode", "NetfilterKind", "RouteTable", "SourceRules",
 	}
 	configType := reflect.TypeFor[Config]()
 	configFields := []string{}
@@ -156,6 +156,22 @@ func TestConfigEqual(t *testing.T) {
 			&Config{NewMTU: 0},
 			false,
 		},
+
+		{
+			&Config{RouteTable: 100},
+			&Config{RouteTable: 0},
+			false,
+		},
+		{
+			&Config{SourceRules: []SourceRule{{Sources: nets("172.17.0.0/16"), Table: 53}}},
+			&Config{SourceRules: []SourceRule{{Sources: nets("172.17.0.0/16"), Table: 54}}},
+			false,
+		},
+		{
+			&Config{SourceRules: []SourceRule{{Sources: nets("172.17.0.0/16"), Routes: nets("0.0.0.0/0"), Table: 53}}},
+			&Config{SourceRules: []SourceRule{{Sources: nets("172.17.0.0/16"), Routes: nets("0.0.0.0/0"), Table: 53}}},
+			true,
+		},
 	}
 	for i, tt := range tests {
 		got := tt.a.Equal(tt.b)